@@ -46,6 +46,7 @@ func main() {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/create-batches", batchServer.CreateBatchesHandler) // controller that creates work items
 	mux.HandleFunc("/do-work", batchServer.WorkerHandler)               // worker that executes work
+	mux.HandleFunc("/verify-signatures", batchServer.VerifySignaturesHandler)
 
 	logger.Infof("starting exposure export server on :%s", config.Port)
 	instrumentedHandler := &ochttp.Handler{Handler: mux}
@@ -46,6 +46,7 @@ func main() {
 	}
 	mux := http.NewServeMux()
 	mux.Handle("/", handlers.WithMinimumLatency(config.MinRequestDuration, handler))
+	mux.Handle("/admin/reload-blocklist", env.KeyBlocklist().ReloadHandler())
 	logger.Infof("starting exposure server on :%s", config.Port)
 	instrumentedHandler := &ochttp.Handler{Handler: mux}
 	log.Fatal(http.ListenAndServe(":"+config.Port, instrumentedHandler))
@@ -42,6 +42,8 @@ func main() {
 
 	mux := http.NewServeMux()
 	mux.Handle("/", federationin.NewHandler(env, &config))
+	mux.Handle("/admin/reload-blocklist", env.KeyBlocklist().ReloadHandler())
+	mux.Handle("/admin/reprocess-dead-letters", federationin.NewReprocessHandler(env, &config))
 	logger.Infof("Starting federationin server on port %s", config.Port)
 	instrumentedHandler := &ochttp.Handler{Handler: mux}
 	log.Fatal(http.ListenAndServe(":"+config.Port, instrumentedHandler))
@@ -19,13 +19,13 @@ import (
 	"context"
 	"log"
 	"net"
+	"net/http"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 
-	"go.opencensus.io/plugin/ocgrpc"
-
 	"github.com/google/exposure-notifications-server/internal/federationout"
+	"github.com/google/exposure-notifications-server/internal/federationout/database"
 	"github.com/google/exposure-notifications-server/internal/logging"
 	_ "github.com/google/exposure-notifications-server/internal/observability"
 	"github.com/google/exposure-notifications-server/internal/pb"
@@ -43,9 +43,17 @@ func main() {
 	}
 	defer closer()
 
-	server := federationout.NewServer(env, &config)
+	generation, err := database.New(env.Database()).Generation(ctx)
+	if err != nil {
+		logger.Fatalf("reading server generation: %v", err)
+	}
+
+	server, err := federationout.NewServer(env, &config, federationout.WithGeneration(generation))
+	if err != nil {
+		logger.Fatalf("federationout.NewServer: %v", err)
+	}
 
-	var sopts []grpc.ServerOption
+	sopts := federationout.KeepaliveOptions(&config)
 	if config.TLSCertFile != "" && config.TLSKeyFile != "" {
 		creds, err := credentials.NewServerTLSFromFile(config.TLSCertFile, config.TLSKeyFile)
 		if err != nil {
@@ -56,12 +64,22 @@ func main() {
 
 	if !config.AllowAnyClient {
 		sopts = append(sopts, grpc.UnaryInterceptor(server.(*federationout.Server).AuthInterceptor))
+		sopts = append(sopts, grpc.StreamInterceptor(server.(*federationout.Server).StreamAuthInterceptor))
 	}
 
-	sopts = append(sopts, grpc.StatsHandler(&ocgrpc.ServerHandler{}))
+	sopts = append(sopts, grpc.StatsHandler(federationout.StatsHandler()))
 	grpcServer := grpc.NewServer(sopts...)
 	pb.RegisterFederationServer(grpcServer, server)
 
+	if config.AdminPort != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/admin/reload-blocklist", env.KeyBlocklist().ReloadHandler())
+		go func() {
+			logger.Infof("Starting federationout admin listener [:%s]", config.AdminPort)
+			log.Fatal(http.ListenAndServe(":"+config.AdminPort, mux))
+		}()
+	}
+
 	grpcEndpoint := ":" + config.Port
 	listen, err := net.Listen("tcp", grpcEndpoint)
 	if err != nil {
@@ -42,6 +42,7 @@ require (
 	github.com/jefferai/jsonx v1.0.1 // indirect
 	github.com/kelseyhightower/envconfig v1.4.0
 	github.com/keybase/go-crypto v0.0.0-20200123153347-de78d2cb44f4 // indirect
+	github.com/klauspost/compress v1.11.3
 	github.com/kr/text v0.2.0 // indirect
 	github.com/lib/pq v1.5.2 // indirect
 	github.com/lstoll/awskms v0.0.0-20191202211033-9042d2a6f52c
@@ -31,6 +31,7 @@ type formData struct {
 	AllowedRegions                    string  `form:"Regions"`
 	BypassHealthAuthorityVerification bool    `form:"BypassHealthAuthorityVerification"`
 	HealthAuthorityIDs                []int64 `form:"Healthauthorities"`
+	Namespace                         string  `form:"Namespace"`
 }
 
 func (f *formData) PriorKey() string {
@@ -58,5 +59,6 @@ func (f *formData) PopulateAuthorizedApp(a *model.AuthorizedApp) error {
 		a.AllowedHealthAuthorityIDs[haID] = struct{}{}
 	}
 	a.BypassHealthAuthorityVerification = f.BypassHealthAuthorityVerification
+	a.Namespace = strings.TrimSpace(f.Namespace)
 	return nil
 }
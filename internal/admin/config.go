@@ -44,6 +44,14 @@ type Config struct {
 	TemplatePath string `envconfig:"TEMPLATE_DIR" default:"./tools/admin-console/templates"`
 	TopFile      string `envconfig:"TOP_FILE" default:"top"`
 	BotFile      string `envconfig:"BOTTOM_FILE" default:"bottom"`
+
+	// WatermarkBatchSize is the number of exposures read per query when
+	// recomputing region watermarks.
+	WatermarkBatchSize int `envconfig:"WATERMARK_BATCH_SIZE" default:"1000"`
+
+	// SupportToken is the bearer token required to look up a single stored
+	// exposure for support investigations. If empty, lookups are disabled.
+	SupportToken string `envconfig:"SUPPORT_TOKEN"`
 }
 
 func (c *Config) DatabaseConfig() *database.Config {
@@ -23,16 +23,22 @@ import (
 )
 
 type formData struct {
-	OutputRegion string        `form:"OutputRegion"`
-	InputRegions string        `form:"InputRegions"`
-	BucketName   string        `form:"BucketName"`
-	FilenameRoot string        `form:"FilenameRoot"`
-	Period       time.Duration `form:"Period"`
-	FromDate     string        `form:"fromdate"`
-	FromTime     string        `form:"fromtime"`
-	ThruDate     string        `form:"thrudate"`
-	ThruTime     string        `form:"thrutime"`
-	SigInfoIDs   []int64       `form:"siginfo"`
+	OutputRegion     string        `form:"OutputRegion"`
+	InputRegions     string        `form:"InputRegions"`
+	BucketName       string        `form:"BucketName"`
+	FilenameRoot     string        `form:"FilenameRoot"`
+	Period           time.Duration `form:"Period"`
+	FromDate         string        `form:"fromdate"`
+	FromTime         string        `form:"fromtime"`
+	ThruDate         string        `form:"thrudate"`
+	ThruTime         string        `form:"thrutime"`
+	SigInfoIDs       []int64       `form:"siginfo"`
+	MaxKeysPerBatch  int           `form:"MaxKeysPerBatch"`
+	MinKeysPerWindow int           `form:"MinKeysPerWindow"`
+	ProtocolVersion  string        `form:"ProtocolVersion"`
+	Schedule         string        `form:"Schedule"`
+	Mode             string        `form:"Mode"`
+	Preset           string        `form:"Preset"`
 }
 
 func (f *formData) PopulateExportConfig(ec *model.ExportConfig) error {
@@ -59,6 +65,12 @@ func (f *formData) PopulateExportConfig(ec *model.ExportConfig) error {
 	ec.From = from
 	ec.Thru = thru
 	ec.SignatureInfoIDs = f.SigInfoIDs
+	ec.MaxKeysPerBatch = f.MaxKeysPerBatch
+	ec.MinKeysPerWindow = f.MinKeysPerWindow
+	ec.ProtocolVersion = f.ProtocolVersion
+	ec.Schedule = strings.TrimSpace(f.Schedule)
+	ec.Mode = f.Mode
+	ec.Preset = strings.TrimSpace(f.Preset)
 
 	return nil
 }
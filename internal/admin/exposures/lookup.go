@@ -0,0 +1,109 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package exposures contains the admin console support handler for looking up
+// a single stored exposure.
+package exposures
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/exposure-notifications-server/internal/admin"
+	coredb "github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/publish/database"
+	"github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+)
+
+type getExposureFunc func(ctx context.Context, key []byte, intervalNumber int32) (*model.Exposure, error)
+
+type lookupController struct {
+	config      *admin.Config
+	getExposure getExposureFunc
+}
+
+// NewLookup returns a controller which serves a stored exposure as JSON, given
+// its base64 encoded key and interval number in the "key" and "interval" query
+// parameters. Requests must carry Config.SupportToken as a bearer token, and
+// every lookup is audit logged.
+func NewLookup(c *admin.Config, env *serverenv.ServerEnv) admin.Controller {
+	return &lookupController{config: c, getExposure: database.New(env.Database()).GetExposureByKey}
+}
+
+func (h *lookupController) Execute(c *gin.Context) {
+	ctx := c.Request.Context()
+	logger := logging.FromContext(ctx)
+
+	if !h.authorized(c.GetHeader("Authorization")) {
+		logger.Warnw("audit: rejected exposure lookup", "remoteAddr", c.Request.RemoteAddr)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	key, err := decodeKey(c.Query("key"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "key must be base64 encoded"})
+		return
+	}
+	interval, err := strconv.ParseInt(c.Query("interval"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "interval must be an interval number"})
+		return
+	}
+
+	exposure, err := h.getExposure(ctx, key, int32(interval))
+	logger.Infow("audit: exposure lookup",
+		"remoteAddr", c.Request.RemoteAddr,
+		"key", base64.StdEncoding.EncodeToString(key),
+		"interval", interval,
+		"found", err == nil)
+	switch {
+	case errors.Is(err, coredb.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "exposure not found"})
+	case err != nil:
+		logger.Errorf("looking up exposure: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+	default:
+		c.JSON(http.StatusOK, exposure)
+	}
+}
+
+func (h *lookupController) authorized(header string) bool {
+	if h.config.SupportToken == "" || !strings.HasPrefix(header, "Bearer ") {
+		return false
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+	return subtle.ConstantTimeCompare([]byte(token), []byte(h.config.SupportToken)) == 1
+}
+
+// decodeKey accepts keys in standard or URL-safe base64, since a standard
+// encoded key is easily mangled in a query string.
+func decodeKey(s string) ([]byte, error) {
+	if key, err := base64.StdEncoding.DecodeString(s); err == nil && len(key) > 0 {
+		return key, nil
+	}
+	key, err := base64.URLEncoding.DecodeString(s)
+	if err == nil && len(key) == 0 {
+		err = errors.New("empty key")
+	}
+	return key, err
+}
@@ -0,0 +1,100 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exposures
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/exposure-notifications-server/internal/admin"
+	coredb "github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLookup(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	stored := &model.Exposure{
+		ExposureKey:      []byte("0123456789abcdef"),
+		TransmissionRisk: 2,
+		Regions:          []string{"US"},
+		IntervalNumber:   2650000,
+		IntervalCount:    144,
+		CreatedAt:        time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC),
+		LocalProvenance:  true,
+	}
+	getExposure := func(_ context.Context, key []byte, interval int32) (*model.Exposure, error) {
+		if string(key) == string(stored.ExposureKey) && interval == stored.IntervalNumber {
+			return stored, nil
+		}
+		return nil, coredb.ErrNotFound
+	}
+	storedKey := base64.StdEncoding.EncodeToString(stored.ExposureKey)
+
+	cases := []struct {
+		name     string
+		token    string
+		key      string
+		interval string
+		want     int
+	}{
+		{name: "found", token: "secret", key: storedKey, interval: "2650000", want: http.StatusOK},
+		{name: "not_found", token: "secret", key: storedKey, interval: "2650001", want: http.StatusNotFound},
+		{name: "no_token", key: storedKey, interval: "2650000", want: http.StatusUnauthorized},
+		{name: "wrong_token", token: "guess", key: storedKey, interval: "2650000", want: http.StatusUnauthorized},
+		{name: "bad_key", token: "secret", key: "!!", interval: "2650000", want: http.StatusBadRequest},
+		{name: "bad_interval", token: "secret", key: storedKey, interval: "x", want: http.StatusBadRequest},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			controller := &lookupController{config: &admin.Config{SupportToken: "secret"}, getExposure: getExposure}
+			router := gin.New()
+			router.GET("/exposures/lookup", controller.Execute)
+
+			query := url.Values{"key": {tc.key}, "interval": {tc.interval}}
+			req := httptest.NewRequest(http.MethodGet, "/exposures/lookup?"+query.Encode(), nil)
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+
+			if recorder.Code != tc.want {
+				t.Fatalf("got status %d, want %d: %s", recorder.Code, tc.want, recorder.Body)
+			}
+			if tc.want != http.StatusOK {
+				return
+			}
+			var got model.Exposure
+			if err := json.Unmarshal(recorder.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(stored, &got); diff != "" {
+				t.Errorf("exposure mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
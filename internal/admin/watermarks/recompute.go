@@ -0,0 +1,74 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package watermarks contains the admin console maintenance handlers for
+// per-region publish watermarks.
+package watermarks
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/exposure-notifications-server/internal/admin"
+	"github.com/google/exposure-notifications-server/internal/publish/database"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+)
+
+type recomputeController struct {
+	config *admin.Config
+	env    *serverenv.ServerEnv
+}
+
+func NewRecompute(c *admin.Config, env *serverenv.ServerEnv) admin.Controller {
+	return &recomputeController{config: c, env: env}
+}
+
+func (h *recomputeController) Execute(c *gin.Context) {
+	ctx := c.Request.Context()
+	m := admin.TemplateMap{}
+
+	corrections, err := database.New(h.env.Database()).RecomputeWatermarks(ctx, h.config.WatermarkBatchSize)
+	if err != nil {
+		admin.ErrorPage(c, fmt.Sprintf("Error recomputing watermarks: %v", err))
+		return
+	}
+
+	m.AddSuccess(fmt.Sprintf("Recomputed region watermarks, %d correction(s) made", len(corrections)))
+	m["corrections"] = corrections
+	c.HTML(http.StatusOK, "watermarks", m)
+}
+
+type rebuildController struct {
+	env *serverenv.ServerEnv
+}
+
+func NewRebuild(c *admin.Config, env *serverenv.ServerEnv) admin.Controller {
+	return &rebuildController{env: env}
+}
+
+func (h *rebuildController) Execute(c *gin.Context) {
+	ctx := c.Request.Context()
+	m := admin.TemplateMap{}
+
+	corrections, err := database.New(h.env.Database()).RebuildFromIngestLog(ctx)
+	if err != nil {
+		admin.ErrorPage(c, fmt.Sprintf("Error rebuilding from ingest log: %v", err))
+		return
+	}
+
+	m.AddSuccess(fmt.Sprintf("Rebuilt region watermarks and key counts from the ingest log, %d watermark(s) changed", len(corrections)))
+	m["corrections"] = corrections
+	c.HTML(http.StatusOK, "watermarks", m)
+}
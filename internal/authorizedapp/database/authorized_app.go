@@ -46,11 +46,13 @@ func (aa *AuthorizedAppDB) InsertAuthorizedApp(ctx context.Context, m *model.Aut
 			INSERT INTO
 				AuthorizedApp
 				(app_package_name, allowed_regions,
-				allowed_health_authority_ids, bypass_health_authority_verification)
+				allowed_health_authority_ids, bypass_health_authority_verification,
+				namespace)
 			VALUES
-				(LOWER($1), $2, $3, $4)
+				(LOWER($1), $2, $3, $4, $5)
 		`, m.AppPackageName, m.AllAllowedRegions(),
-			m.AllAllowedHealthAuthorityIDs(), m.BypassHealthAuthorityVerification)
+			m.AllAllowedHealthAuthorityIDs(), m.BypassHealthAuthorityVerification,
+			m.Namespace)
 
 		if err != nil {
 			return fmt.Errorf("inserting authorizedapp: %w", err)
@@ -68,12 +70,13 @@ func (aa *AuthorizedAppDB) UpdateAuthorizedApp(ctx context.Context, priorKey str
 			UPDATE AuthorizedApp
 			SET
 				app_package_name = LOWER($1), allowed_regions = $2,
-				allowed_health_authority_ids = $3, bypass_health_authority_verification = $4
+				allowed_health_authority_ids = $3, bypass_health_authority_verification = $4,
+				namespace = $5
 			WHERE
-				LOWER(app_package_name) = LOWER($5)
+				LOWER(app_package_name) = LOWER($6)
 			`, m.AppPackageName, m.AllAllowedRegions(),
 			m.AllAllowedHealthAuthorityIDs(), m.BypassHealthAuthorityVerification,
-			priorKey)
+			m.Namespace, priorKey)
 		if err != nil {
 			return fmt.Errorf("updating authorizedapp: %w", err)
 		}
@@ -118,7 +121,8 @@ func (aa *AuthorizedAppDB) GetAllAuthorizedApps(ctx context.Context, sm secrets.
 	query := `
 		SELECT
 			LOWER(app_package_name), allowed_regions,
-			allowed_health_authority_ids, bypass_health_authority_verification
+			allowed_health_authority_ids, bypass_health_authority_verification,
+			namespace
 		FROM
 			AuthorizedApp
 		ORDER BY app_package_name ASC`
@@ -156,7 +160,8 @@ func (aa *AuthorizedAppDB) GetAuthorizedApp(ctx context.Context, sm secrets.Secr
 	query := `
 		SELECT
 			LOWER(app_package_name), allowed_regions,
-			allowed_health_authority_ids, bypass_health_authority_verification
+			allowed_health_authority_ids, bypass_health_authority_verification,
+			namespace
 		FROM
 			AuthorizedApp
 		WHERE LOWER(app_package_name) = LOWER($1)`
@@ -173,6 +178,7 @@ func scanOneAuthorizedApp(ctx context.Context, row pgx.Row, sm secrets.SecretMan
 	if err := row.Scan(
 		&config.AppPackageName, &allowedRegions,
 		&allowedHealthAuthorityIDs, &config.BypassHealthAuthorityVerification,
+		&config.Namespace,
 	); err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, nil
@@ -33,6 +33,10 @@ type AuthorizedApp struct {
 	// that this app can obtain and verify diagnosis verification certificates from.
 	AllowedHealthAuthorityIDs         map[int64]struct{}
 	BypassHealthAuthorityVerification bool
+
+	// Namespace isolates the exposures published by this app from those of
+	// other tenants sharing the deployment. Empty is the default namespace.
+	Namespace string
 }
 
 func NewAuthorizedApp() *AuthorizedApp {
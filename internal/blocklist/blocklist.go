@@ -0,0 +1,158 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package blocklist holds diagnosis keys which are known to be malicious, so
+// that they are neither stored nor served.
+package blocklist
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/google/exposure-notifications-server/internal/logging"
+)
+
+// Config is the configuration for the key blocklist.
+type Config struct {
+	// File lists blocked keys, one base64 encoded key per line. Blank lines and
+	// lines starting with # are ignored. If empty, no keys are blocked.
+	File string `envconfig:"KEY_BLOCKLIST_FILE"`
+}
+
+// Blocklist is a reloadable set of blocked keys. A nil *Blocklist blocks
+// nothing. It is safe for concurrent use.
+type Blocklist struct {
+	path string
+
+	mu   sync.RWMutex
+	keys map[string]struct{}
+}
+
+// New returns a Blocklist of the given keys, which cannot be reloaded.
+func New(keys ...[]byte) *Blocklist {
+	b := &Blocklist{keys: make(map[string]struct{}, len(keys))}
+	for _, k := range keys {
+		b.keys[string(k)] = struct{}{}
+	}
+	return b
+}
+
+// Load reads the blocklist in config.File. If config.File is empty, the
+// blocklist is empty.
+func Load(ctx context.Context, config *Config) (*Blocklist, error) {
+	b := &Blocklist{path: config.File, keys: map[string]struct{}{}}
+	if b.path == "" {
+		return b, nil
+	}
+	n, err := b.Reload(ctx)
+	if err != nil {
+		return nil, err
+	}
+	logging.FromContext(ctx).Infof("Loaded %d blocked keys from %s", n, b.path)
+	return b, nil
+}
+
+// Reload reads the blocklist file again, replacing the blocked keys, and
+// returns how many keys are now blocked. On error, the previous keys remain
+// blocked.
+func (b *Blocklist) Reload(ctx context.Context) (int, error) {
+	if b == nil || b.path == "" {
+		return b.Len(), nil
+	}
+	data, err := ioutil.ReadFile(b.path)
+	if err != nil {
+		return 0, fmt.Errorf("reading key blocklist: %w", err)
+	}
+	keys, err := parse(data)
+	if err != nil {
+		return 0, fmt.Errorf("parsing key blocklist %s: %w", b.path, err)
+	}
+
+	b.mu.Lock()
+	b.keys = keys
+	b.mu.Unlock()
+	return len(keys), nil
+}
+
+func parse(data []byte) (map[string]struct{}, error) {
+	keys := make(map[string]struct{})
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		keys[string(key)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Contains returns true if key is blocked.
+func (b *Blocklist) Contains(key []byte) bool {
+	if b == nil {
+		return false
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	// Converting in the map index expression does not allocate.
+	_, ok := b.keys[string(key)]
+	return ok
+}
+
+// Len returns the number of blocked keys.
+func (b *Blocklist) Len() int {
+	if b == nil {
+		return 0
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.keys)
+}
+
+// ReloadHandler returns a handler which reloads the blocklist on POST.
+// Reloading is harmless to repeat, but where possible the handler should only
+// be reachable by administrators.
+func (b *Blocklist) ReloadHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := logging.FromContext(ctx)
+
+		if r.Method != http.MethodPost {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		n, err := b.Reload(ctx)
+		if err != nil {
+			logger.Errorf("Failed to reload key blocklist: %v", err)
+			http.Error(w, "Failed to reload key blocklist, check logs.", http.StatusInternalServerError)
+			return
+		}
+		logger.Infof("Reloaded key blocklist, %d keys blocked", n)
+		fmt.Fprintf(w, "%d keys blocked\n", n)
+	})
+}
@@ -0,0 +1,105 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blocklist
+
+import (
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBlocklist(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "blocklist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keys.txt")
+
+	write := func(t *testing.T, contents string) {
+		t.Helper()
+		if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	encode := func(key string) string {
+		return base64.StdEncoding.EncodeToString([]byte(key))
+	}
+	reload := func(t *testing.T, b *Blocklist, method string) int {
+		t.Helper()
+		w := httptest.NewRecorder()
+		b.ReloadHandler().ServeHTTP(w, httptest.NewRequest(method, "/admin/reload-blocklist", nil))
+		return w.Code
+	}
+
+	write(t, "# Known bad keys.\n"+encode("aaa")+"\n\n  "+encode("bbb")+"  \n")
+	b, err := Load(ctx, &Config{File: path})
+	if err != nil {
+		t.Fatalf("Load() returned err=%v", err)
+	}
+	if !b.Contains([]byte("aaa")) || !b.Contains([]byte("bbb")) || b.Contains([]byte("ccc")) || b.Len() != 2 {
+		t.Errorf("got aaa=%v bbb=%v ccc=%v len=%d, want true, true, false, 2",
+			b.Contains([]byte("aaa")), b.Contains([]byte("bbb")), b.Contains([]byte("ccc")), b.Len())
+	}
+
+	t.Run("reload", func(t *testing.T) {
+		write(t, encode("ccc")+"\n")
+		if code := reload(t, b, http.MethodGet); code != http.StatusMethodNotAllowed {
+			t.Errorf("GET returned %d, want %d", code, http.StatusMethodNotAllowed)
+		}
+		if code := reload(t, b, http.MethodPost); code != http.StatusOK {
+			t.Fatalf("POST returned %d, want %d", code, http.StatusOK)
+		}
+		if b.Contains([]byte("aaa")) || !b.Contains([]byte("ccc")) {
+			t.Errorf("after reload got aaa=%v ccc=%v, want false, true", b.Contains([]byte("aaa")), b.Contains([]byte("ccc")))
+		}
+	})
+
+	t.Run("invalid file keeps keys", func(t *testing.T) {
+		write(t, "not base64!\n")
+		if code := reload(t, b, http.MethodPost); code != http.StatusInternalServerError {
+			t.Errorf("POST returned %d, want %d", code, http.StatusInternalServerError)
+		}
+		if !b.Contains([]byte("ccc")) {
+			t.Errorf("blocked keys were lost on a failed reload")
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		if _, err := Load(ctx, &Config{File: filepath.Join(dir, "missing.txt")}); err == nil {
+			t.Errorf("Load() of a missing file returned no error")
+		}
+	})
+}
+
+func TestNilBlocklist(t *testing.T) {
+	var b *Blocklist
+	if b.Contains([]byte("aaa")) || b.Len() != 0 {
+		t.Errorf("nil blocklist blocks keys")
+	}
+	empty, err := Load(context.Background(), &Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if empty.Contains([]byte("aaa")) {
+		t.Errorf("empty blocklist blocks keys")
+	}
+}
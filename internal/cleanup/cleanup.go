@@ -89,7 +89,60 @@ func (h *exposureCleanupHandler) ServeHTTP(w http.ResponseWriter, r *http.Reques
 	}
 
 	metrics.WriteInt64("cleanup-exposures-deleted", true, count)
-	logger.Infof("cleanup run complete, deleted %v records.", count)
+
+	// Keys with their own expiry may be removed before the TTL.
+	expired, err := h.database.DeleteExpiredExposures(timeoutCtx, time.Now())
+	if err != nil {
+		message := fmt.Sprintf("Failed deleting expired exposures: %v", err)
+		logger.Error(message)
+		metrics.WriteInt("cleanup-exposures-expired-delete-failed", true, 1)
+		span.SetStatus(trace.Status{Code: trace.StatusCodeInternal, Message: message})
+		http.Error(w, "internal processing error", http.StatusInternalServerError)
+		return
+	}
+
+	metrics.WriteInt64("cleanup-exposures-expired-deleted", true, expired)
+
+	// Tombstones for purged keys are only useful while partners may still hold the key.
+	tombstones, err := h.database.DeleteTombstones(timeoutCtx, cutoff)
+	if err != nil {
+		message := fmt.Sprintf("Failed deleting tombstones: %v", err)
+		logger.Error(message)
+		metrics.WriteInt("cleanup-tombstones-delete-failed", true, 1)
+		span.SetStatus(trace.Status{Code: trace.StatusCodeInternal, Message: message})
+		http.Error(w, "internal processing error", http.StatusInternalServerError)
+		return
+	}
+
+	metrics.WriteInt64("cleanup-tombstones-deleted", true, tombstones)
+
+	// Publish results are only kept to answer retries for a short time.
+	results, err := h.database.DeleteExpiredPublishResults(timeoutCtx, time.Now())
+	if err != nil {
+		message := fmt.Sprintf("Failed deleting publish results: %v", err)
+		logger.Error(message)
+		metrics.WriteInt("cleanup-publish-results-delete-failed", true, 1)
+		span.SetStatus(trace.Status{Code: trace.StatusCodeInternal, Message: message})
+		http.Error(w, "internal processing error", http.StatusInternalServerError)
+		return
+	}
+
+	metrics.WriteInt64("cleanup-publish-results-deleted", true, results)
+
+	// The ingest log only needs individual events recently; older ones are merged.
+	if h.config.IngestLogCompactAfter > 0 {
+		compacted, err := h.database.CompactIngestLog(timeoutCtx, time.Now().Add(-h.config.IngestLogCompactAfter))
+		if err != nil {
+			message := fmt.Sprintf("Failed compacting ingest log: %v", err)
+			logger.Error(message)
+			metrics.WriteInt("cleanup-ingest-log-compact-failed", true, 1)
+			span.SetStatus(trace.Status{Code: trace.StatusCodeInternal, Message: message})
+			http.Error(w, "internal processing error", http.StatusInternalServerError)
+			return
+		}
+		metrics.WriteInt("cleanup-ingest-events-compacted", true, compacted)
+	}
+	logger.Infof("cleanup run complete, deleted %v records, %v expired records and %v tombstones.", count, expired, tombstones)
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -38,6 +38,11 @@ type Config struct {
 	Port    string        `envconfig:"PORT" default:"8080"`
 	Timeout time.Duration `envconfig:"CLEANUP_TIMEOUT" default:"10m"`
 	TTL     time.Duration `envconfig:"CLEANUP_TTL" default:"336h"`
+
+	// IngestLogCompactAfter is how long ingest events are kept individually
+	// before the exposure cleanup merges them into one event per region and
+	// day. Zero disables compaction.
+	IngestLogCompactAfter time.Duration `envconfig:"INGEST_LOG_COMPACT_AFTER" default:"24h"`
 }
 
 func (c *Config) BlobstoreConfig() *storage.Config {
@@ -34,6 +34,12 @@ type Config struct {
 	PoolMaxConnLife    time.Duration `envconfig:"DB_POOL_MAX_CONN_LIFETIME"`
 	PoolMaxConnIdle    time.Duration `envconfig:"DB_POOL_MAX_CONN_IDLE_TIME"`
 	PoolHealthCheck    time.Duration `envconfig:"DB_POOL_HEALTH_CHECK_PERIOD"`
+	PoolStatsInterval  time.Duration `envconfig:"DB_POOL_STATS_INTERVAL" default:"10s"`
+
+	// ReadDSN is an optional connection string for a read replica, which is
+	// used by read-only queries that tolerate up to ReadMaxLag of replication lag.
+	ReadDSN    string        `envconfig:"DB_READ_DSN"`
+	ReadMaxLag time.Duration `envconfig:"DB_READ_MAX_LAG" default:"30s"`
 }
 
 func (c *Config) DB() *Config {
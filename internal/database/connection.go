@@ -29,6 +29,16 @@ import (
 
 type DB struct {
 	Pool *pgxpool.Pool
+
+	// ReadPool is a pool for a read replica, or nil if none is configured.
+	// Use Reader rather than accessing it directly.
+	ReadPool *pgxpool.Pool
+
+	maxReplicaLag time.Duration
+	replicaLag    func(context.Context) (time.Duration, error)
+
+	// stopStats stops recording the pool statistics, if started.
+	stopStats context.CancelFunc
 }
 
 // NewFromEnv sets up the database connections using the configuration in the
@@ -46,16 +56,76 @@ func NewFromEnv(ctx context.Context, config *Config) (*DB, error) {
 		return nil, fmt.Errorf("creating connection pool: %v", err)
 	}
 
-	return &DB{Pool: pool}, nil
+	db := &DB{Pool: pool}
+	if config.ReadDSN != "" {
+		logger.Infof("Creating read replica connection pool.")
+		readPool, err := pgxpool.Connect(ctx, config.ReadDSN)
+		if err != nil {
+			pool.Close()
+			return nil, fmt.Errorf("creating read replica connection pool: %v", err)
+		}
+		db.ReadPool = readPool
+		db.maxReplicaLag = config.ReadMaxLag
+		db.replicaLag = db.queryReplicaLag
+	}
+	if config.PoolStatsInterval > 0 {
+		statsCtx, cancel := context.WithCancel(context.Background())
+		db.stopStats = cancel
+		go db.recordPoolStats(statsCtx, config.PoolStatsInterval)
+	}
+	return db, nil
 }
 
 // Close releases database connections.
 func (db *DB) Close(ctx context.Context) {
 	logger := logging.FromContext(ctx)
 	logger.Infof("Closing connection pool.")
+	if db.stopStats != nil {
+		db.stopStats()
+	}
+	if db.ReadPool != nil {
+		db.ReadPool.Close()
+	}
 	db.Pool.Close()
 }
 
+// Reader returns the pool to use for read-only queries which tolerate
+// replication lag. This is the read replica if one is configured and it is no
+// more than the configured maximum lag behind the primary, and the primary
+// otherwise.
+func (db *DB) Reader(ctx context.Context) *pgxpool.Pool {
+	if db.ReadPool == nil {
+		return db.Pool
+	}
+	lag, err := db.replicaLag(ctx)
+	if err != nil {
+		logging.FromContext(ctx).Warnf("checking read replica lag, using primary: %v", err)
+		return db.Pool
+	}
+	if lag > db.maxReplicaLag {
+		logging.FromContext(ctx).Warnf("read replica lag %v exceeds %v, using primary", lag, db.maxReplicaLag)
+		return db.Pool
+	}
+	return db.ReadPool
+}
+
+// queryReplicaLag returns how far the read replica is behind the primary. A
+// replica which has replayed all the WAL it has received is not lagging, even
+// if there have been no recent writes.
+func (db *DB) queryReplicaLag(ctx context.Context) (time.Duration, error) {
+	var seconds float64
+	if err := db.ReadPool.QueryRow(ctx, `
+		SELECT
+			CASE
+				WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+				ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+			END::DOUBLE PRECISION
+		`).Scan(&seconds); err != nil {
+		return 0, fmt.Errorf("querying replica lag: %w", err)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
 // dbConnectionString builds a connection string suitable for the pgx Postgres driver, using the
 // values of vars.
 func dbConnectionString(config *Config) string {
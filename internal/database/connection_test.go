@@ -15,10 +15,13 @@
 package database
 
 import (
+	"context"
+	"errors"
 	"testing"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/jackc/pgx/v4/pgxpool"
 )
 
 func TestDBValues(t *testing.T) {
@@ -61,3 +64,46 @@ func TestDBValues(t *testing.T) {
 		})
 	}
 }
+
+func TestReader(t *testing.T) {
+	// The pools are only compared, never used.
+	primary, replica := new(pgxpool.Pool), new(pgxpool.Pool)
+	lagFunc := func(lag time.Duration, err error) func(context.Context) (time.Duration, error) {
+		return func(context.Context) (time.Duration, error) { return lag, err }
+	}
+
+	testCases := []struct {
+		name string
+		db   *DB
+		want *pgxpool.Pool
+	}{
+		{
+			name: "no replica",
+			db:   &DB{Pool: primary},
+			want: primary,
+		},
+		{
+			name: "replica",
+			db:   &DB{Pool: primary, ReadPool: replica, maxReplicaLag: 30 * time.Second, replicaLag: lagFunc(time.Second, nil)},
+			want: replica,
+		},
+		{
+			name: "replica lagging",
+			db:   &DB{Pool: primary, ReadPool: replica, maxReplicaLag: 30 * time.Second, replicaLag: lagFunc(time.Minute, nil)},
+			want: primary,
+		},
+		{
+			name: "replica lag unknown",
+			db:   &DB{Pool: primary, ReadPool: replica, maxReplicaLag: 30 * time.Second, replicaLag: lagFunc(0, errors.New("boom"))},
+			want: primary,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.db.Reader(context.Background()); got != tc.want {
+				t.Errorf("Reader() returned the wrong pool, want primary=%t", tc.want == primary)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+)
+
+var (
+	mPoolAcquiredConns = stats.Int64("database/pool/acquired_conns", "Number of connections currently in use", stats.UnitDimensionless)
+	mPoolIdleConns     = stats.Int64("database/pool/idle_conns", "Number of idle connections in the pool", stats.UnitDimensionless)
+	mPoolWaits         = stats.Int64("database/pool/waits", "Number of acquires that had to wait for a connection", stats.UnitDimensionless)
+)
+
+// PoolViews are the OpenCensus views describing the database connection pool.
+// They must be registered with view.Register to be exported.
+var PoolViews = []*view.View{
+	{
+		Name:        "database/pool/acquired_conns",
+		Description: mPoolAcquiredConns.Description(),
+		Measure:     mPoolAcquiredConns,
+		Aggregation: view.LastValue(),
+	},
+	{
+		Name:        "database/pool/idle_conns",
+		Description: mPoolIdleConns.Description(),
+		Measure:     mPoolIdleConns,
+		Aggregation: view.LastValue(),
+	},
+	{
+		Name:        "database/pool/waits",
+		Description: mPoolWaits.Description(),
+		Measure:     mPoolWaits,
+		Aggregation: view.Sum(),
+	},
+}
+
+// poolStat is the subset of *pgxpool.Stat that is recorded.
+type poolStat interface {
+	AcquiredConns() int32
+	IdleConns() int32
+	EmptyAcquireCount() int64
+}
+
+// poolStatsRecorder records connection pool statistics to the PoolViews.
+type poolStatsRecorder struct {
+	lastWaits int64
+}
+
+// record records the current pool statistics. The pool reports waits as a
+// running total, so only the waits since the previous call are recorded.
+func (r *poolStatsRecorder) record(ctx context.Context, stat poolStat) {
+	waits := stat.EmptyAcquireCount()
+	stats.Record(ctx,
+		mPoolAcquiredConns.M(int64(stat.AcquiredConns())),
+		mPoolIdleConns.M(int64(stat.IdleConns())),
+		mPoolWaits.M(waits-r.lastWaits))
+	r.lastWaits = waits
+}
+
+// recordPoolStats records the pool statistics every interval until ctx is done.
+func (db *DB) recordPoolStats(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var r poolStatsRecorder
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.record(ctx, db.Pool.Stat())
+		}
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"go.opencensus.io/stats/view"
+)
+
+type fakePoolStat struct {
+	acquired, idle int32
+	waits          int64
+}
+
+func (s *fakePoolStat) AcquiredConns() int32     { return s.acquired }
+func (s *fakePoolStat) IdleConns() int32         { return s.idle }
+func (s *fakePoolStat) EmptyAcquireCount() int64 { return s.waits }
+
+// poolViewValue returns the current value of the named pool view.
+func poolViewValue(t *testing.T, name string) float64 {
+	t.Helper()
+
+	rows, err := view.RetrieveData(name)
+	if err != nil {
+		t.Fatalf("RetrieveData(%q): %v", name, err)
+	}
+	if len(rows) != 1 {
+		t.Fatalf("RetrieveData(%q) returned %d rows, want 1", name, len(rows))
+	}
+	switch data := rows[0].Data.(type) {
+	case *view.LastValueData:
+		return data.Value
+	case *view.SumData:
+		return data.Value
+	default:
+		t.Fatalf("unexpected data type %T for %q", data, name)
+		return 0
+	}
+}
+
+func registerPoolViews(t *testing.T) {
+	t.Helper()
+
+	if err := view.Register(PoolViews...); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { view.Unregister(PoolViews...) })
+}
+
+func TestPoolStatsRecorder(t *testing.T) {
+	registerPoolViews(t)
+
+	ctx := context.Background()
+	var r poolStatsRecorder
+
+	r.record(ctx, &fakePoolStat{acquired: 3, idle: 1, waits: 2})
+	r.record(ctx, &fakePoolStat{acquired: 1, idle: 4, waits: 5})
+
+	if got, want := poolViewValue(t, "database/pool/acquired_conns"), 1.0; got != want {
+		t.Errorf("acquired_conns=%v, want %v", got, want)
+	}
+	if got, want := poolViewValue(t, "database/pool/idle_conns"), 4.0; got != want {
+		t.Errorf("idle_conns=%v, want %v", got, want)
+	}
+	// The pool reports a running total, which must not be double counted.
+	if got, want := poolViewValue(t, "database/pool/waits"), 5.0; got != want {
+		t.Errorf("waits=%v, want %v", got, want)
+	}
+}
+
+func TestPoolStatsConcurrentAcquire(t *testing.T) {
+	db := NewTestDatabase(t)
+	registerPoolViews(t)
+
+	ctx := context.Background()
+	const workers = 4
+
+	// Hold several connections at once, and record while they are in use.
+	var r poolStatsRecorder
+	var acquired, release sync.WaitGroup
+	acquired.Add(workers)
+	release.Add(1)
+	errCh := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			conn, err := db.Pool.Acquire(ctx)
+			if err != nil {
+				errCh <- err
+				acquired.Done()
+				return
+			}
+			defer conn.Release()
+			if _, err := conn.Exec(ctx, "SELECT 1"); err != nil {
+				errCh <- err
+			}
+			acquired.Done()
+			release.Wait()
+		}()
+	}
+	acquired.Wait()
+	r.record(ctx, db.Pool.Stat())
+	if got := poolViewValue(t, "database/pool/acquired_conns"); got < workers {
+		t.Errorf("acquired_conns=%v while %d connections are held, want at least %d", got, workers, workers)
+	}
+
+	release.Done()
+	close(errCh)
+	for err := range errCh {
+		t.Fatal(err)
+	}
+}
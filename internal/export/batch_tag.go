@@ -0,0 +1,88 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/export/model"
+)
+
+// BatchTag identifies the server build which wrote an export file or index
+// file. It is recorded in the object's blobstore metadata, under
+// storage.BatchTagKey, rather than in the file itself, so clients parse the
+// files as before.
+type BatchTag struct {
+	// Version is the configured BatchTagVersion of the server.
+	Version string
+	// WrittenAt is when the file was written, to the second.
+	WrittenAt time.Time
+	// BatchID and BatchNum are the batch, and the file's number within it. An
+	// index file has BatchNum 0 and the ID of the batch which rewrote it.
+	BatchID  int64
+	BatchNum int
+}
+
+// String returns the tag in the form "<version>/<written at>/<batch>-<num>",
+// e.g. "v0.4.1/2020-05-01T10:00:00Z/42-1". The version may itself contain
+// slashes.
+func (t *BatchTag) String() string {
+	return fmt.Sprintf("%s/%s/%d-%d", t.Version, t.WrittenAt.UTC().Format(time.RFC3339), t.BatchID, t.BatchNum)
+}
+
+// ParseBatchTag parses a tag returned by BatchTag.String.
+func ParseBatchTag(s string) (*BatchTag, error) {
+	i := strings.LastIndex(s, "/")
+	if i < 0 {
+		return nil, fmt.Errorf("batch tag %q has no sequence", s)
+	}
+	rest, sequence := s[:i], s[i+1:]
+	j := strings.LastIndex(rest, "/")
+	if j <= 0 {
+		return nil, fmt.Errorf("batch tag %q has no version or timestamp", s)
+	}
+	version, written := rest[:j], rest[j+1:]
+
+	writtenAt, err := time.Parse(time.RFC3339, written)
+	if err != nil {
+		return nil, fmt.Errorf("batch tag %q: timestamp: %w", s, err)
+	}
+	parts := strings.Split(sequence, "-")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("batch tag %q: sequence %q is not <batch>-<num>", s, sequence)
+	}
+	batchID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("batch tag %q: batch: %w", s, err)
+	}
+	batchNum, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("batch tag %q: batch number: %w", s, err)
+	}
+	return &BatchTag{Version: version, WrittenAt: writtenAt, BatchID: batchID, BatchNum: batchNum}, nil
+}
+
+// batchTag returns the tag for file batchNum of eb written now, or the empty
+// string if the server has no BatchTagVersion.
+func (s *Server) batchTag(eb *model.ExportBatch, batchNum int, now time.Time) string {
+	if s.config.BatchTagVersion == "" {
+		return ""
+	}
+	tag := &BatchTag{Version: s.config.BatchTagVersion, WrittenAt: now.Truncate(time.Second), BatchID: eb.BatchID, BatchNum: batchNum}
+	return tag.String()
+}
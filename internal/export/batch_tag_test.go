@@ -0,0 +1,120 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/export/model"
+	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"github.com/google/exposure-notifications-server/internal/storage"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseBatchTag(t *testing.T) {
+	t.Parallel()
+
+	want := &BatchTag{
+		Version:   "release/v0.4.1",
+		WrittenAt: time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC),
+		BatchID:   42,
+		BatchNum:  3,
+	}
+	encoded := want.String()
+	if encoded != "release/v0.4.1/2020-05-01T10:00:00Z/42-3" {
+		t.Errorf("String() = %q", encoded)
+	}
+	got, err := ParseBatchTag(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	for _, bad := range []string{
+		"",
+		"v1",
+		"/2020-05-01T10:00:00Z/42-3",
+		"v1/yesterday/42-3",
+		"v1/2020-05-01T10:00:00Z/42",
+		"v1/2020-05-01T10:00:00Z/x-3",
+		"v1/2020-05-01T10:00:00Z/42-x",
+	} {
+		if _, err := ParseBatchTag(bad); err == nil {
+			t.Errorf("ParseBatchTag(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+// TestCreateFileBatchTag checks that an export file is written with a
+// parseable tag for its batch, and without one if no version is configured.
+func TestCreateFileBatchTag(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	blobstore, err := storage.NewMemory(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	memory := blobstore.(*storage.Memory)
+	env := serverenv.New(ctx, serverenv.WithBlobStorage(blobstore))
+
+	eb := &model.ExportBatch{
+		BatchID:         7,
+		BucketName:      "bucket",
+		FilenameRoot:    "root",
+		StartTimestamp:  time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC),
+		EndTimestamp:    time.Date(2020, 5, 1, 1, 0, 0, 0, time.UTC),
+		OutputRegion:    "US",
+		ProtocolVersion: model.ExportProtocolV1,
+	}
+	exposures := []*publishmodel.Exposure{
+		{ExposureKey: []byte("ABC"), IntervalNumber: 18, IntervalCount: 144, TransmissionRisk: 4},
+	}
+
+	for _, version := range []string{"v0.4.1", ""} {
+		s := &Server{config: &Config{BatchTagVersion: version}, env: env}
+		before := time.Now().Truncate(time.Second)
+		name, err := s.createFile(ctx, createFileInfo{exposures: exposures, exportBatch: eb, batchNum: 2, batchSize: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		o, ok := memory.Object(eb.BucketName, name)
+		if !ok {
+			t.Fatalf("file %q was not written", name)
+		}
+
+		if version == "" {
+			if o.Metadata.BatchTag != "" {
+				t.Errorf("without a version, got tag %q", o.Metadata.BatchTag)
+			}
+			continue
+		}
+		tag, err := ParseBatchTag(o.Metadata.BatchTag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tag.Version != version || tag.BatchID != eb.BatchID || tag.BatchNum != 2 {
+			t.Errorf("unexpected tag: %+v", tag)
+		}
+		if tag.WrittenAt.Before(before) || tag.WrittenAt.After(time.Now()) {
+			t.Errorf("tag written at %v, want about now", tag.WrittenAt)
+		}
+	}
+}
@@ -25,6 +25,7 @@ import (
 	coredb "github.com/google/exposure-notifications-server/internal/database"
 	"github.com/google/exposure-notifications-server/internal/export/database"
 	"github.com/google/exposure-notifications-server/internal/export/model"
+	publishdb "github.com/google/exposure-notifications-server/internal/publish/database"
 	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
 
 	"github.com/google/exposure-notifications-server/internal/logging"
@@ -100,11 +101,30 @@ func (s *Server) maybeCreateBatches(ctx context.Context, ec *model.ExportConfig,
 	logger := logging.FromContext(ctx)
 	metrics := s.env.MetricsExporter(ctx)
 
+	if ec.Schedule != "" {
+		scheduled, ok, err := scheduledTime(ctx, ec, now, database.New(s.db).HasUnfinishedExportBatches)
+		if err != nil {
+			return 0, fmt.Errorf("checking schedule for config %d: %w", ec.ConfigID, err)
+		}
+		if !ok {
+			metrics.WriteInt("export-batcher-schedule-overlap", true, 1)
+			logger.Infof("Previous run for config %d is still exporting, skipping", ec.ConfigID)
+			return 0, nil
+		}
+		now = scheduled
+	}
+
 	latestEnd, err := database.New(s.db).LatestExportBatchEnd(ctx, ec)
 	if err != nil {
 		return 0, fmt.Errorf("fetching most recent batch for config %d: %w", ec.ConfigID, err)
 	}
 
+	// Withheld periods are only revisited if they follow the latest batch, so
+	// without any batches, start from the beginning of the config instead.
+	if ec.MinKeysPerWindow > 0 && latestEnd.Before(sanityDate) && ec.From.After(sanityDate) {
+		latestEnd = ec.From.Truncate(ec.Period)
+	}
+
 	ranges := makeBatchRanges(ec.Period, latestEnd, now, s.config.TruncateWindow)
 	if len(ranges) == 0 {
 		metrics.WriteInt("export-batcher-no-work", true, 1)
@@ -112,6 +132,29 @@ func (s *Server) maybeCreateBatches(ctx context.Context, ec *model.ExportConfig,
 		return 0, nil
 	}
 
+	if ec.MinKeysPerWindow > 0 {
+		countKeys, err := s.exposureCounter(ctx, ec)
+		if err != nil {
+			return 0, fmt.Errorf("counting keys for config %d: %w", ec.ConfigID, err)
+		}
+		merged, withheld, err := mergeSmallRanges(ranges, ec.MinKeysPerWindow, countKeys)
+		if err != nil {
+			return 0, fmt.Errorf("counting keys for config %d: %w", ec.ConfigID, err)
+		}
+		withheldSince := ranges[0].start
+		if len(merged) > 0 {
+			withheldSince = merged[len(merged)-1].end
+		}
+		if withheldSince.Before(ranges[len(ranges)-1].end) {
+			logger.Infof("Withholding %d key(s) for config %d since %v, fewer than the minimum of %d", withheld, ec.ConfigID, withheldSince, ec.MinKeysPerWindow)
+			metrics.WriteInt("export-batcher-withheld", true, 1)
+		}
+		ranges = merged
+		if len(ranges) == 0 {
+			return 0, nil
+		}
+	}
+
 	var batches []*model.ExportBatch
 	for _, br := range ranges {
 		infoIds := make([]int64, len(ec.SignatureInfoIDs))
@@ -126,6 +169,10 @@ func (s *Server) maybeCreateBatches(ctx context.Context, ec *model.ExportConfig,
 			InputRegions:     ec.InputRegions,
 			Status:           model.ExportBatchOpen,
 			SignatureInfoIDs: infoIds,
+			MaxKeysPerBatch:  ec.MaxKeysPerBatch,
+			ProtocolVersion:  ec.EffectiveProtocolVersion(),
+			Mode:             ec.EffectiveMode(),
+			Preset:           ec.Preset,
 		})
 	}
 
@@ -138,10 +185,104 @@ func (s *Server) maybeCreateBatches(ctx context.Context, ec *model.ExportConfig,
 	return len(batches), nil
 }
 
+// scheduledTime returns the latest run of ec's schedule at or before now, to
+// create batches as of that time rather than now. It returns false if batches
+// for ec are still being exported, so that runs do not overlap.
+func scheduledTime(ctx context.Context, ec *model.ExportConfig, now time.Time, hasUnfinished func(context.Context, int64) (bool, error)) (time.Time, bool, error) {
+	schedule, err := model.ParseSchedule(ec.Schedule)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	scheduled, ok := schedule.Prev(now)
+	if !ok {
+		return time.Time{}, false, fmt.Errorf("schedule %q has no run before %v", ec.Schedule, now)
+	}
+	unfinished, err := hasUnfinished(ctx, ec.ConfigID)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return scheduled, !unfinished, nil
+}
+
 type batchRange struct {
 	start, end time.Time
 }
 
+// exposureCounter returns a function which counts the exposures ec would
+// export in a batch range, after the same preset and delta filtering as the
+// export worker. Ranges must be counted in order: only the first reaches back
+// for keys a delta config has not exported yet, so that they are counted once.
+func (s *Server) exposureCounter(ctx context.Context, ec *model.ExportConfig) (func(batchRange) (int, error), error) {
+	var preset *model.ExportPreset
+	if ec.Preset != "" {
+		var err error
+		if preset, err = s.exportdb.GetExportPreset(ctx, ec.Preset); err != nil {
+			return nil, fmt.Errorf("loading export preset %q: %w", ec.Preset, err)
+		}
+	}
+	delta := ec.EffectiveMode() == model.ExportModeDelta
+	var watermarks map[string]time.Time
+	if delta {
+		var err error
+		if watermarks, err = s.exportdb.ExportWatermarks(ctx, ec.ConfigID); err != nil {
+			return nil, fmt.Errorf("loading export watermarks: %w", err)
+		}
+	}
+
+	regions := ec.EffectiveInputRegions()
+	first := true
+	return func(br batchRange) (int, error) {
+		criteria := publishdb.IterateExposuresCriteria{
+			SinceTimestamp:      br.start,
+			UntilTimestamp:      br.end,
+			IncludeRegions:      regions,
+			NotExpiredAt:        time.Now(),
+			OnlyLocalProvenance: false, // include federated ids
+		}
+		if delta && first {
+			criteria.SinceTimestamp = deltaSince(br.start, regions, watermarks)
+		}
+		first = false
+
+		var exposures []*publishmodel.Exposure
+		if err := s.iterateExposures(ctx, criteria, func(exp *publishmodel.Exposure) error {
+			exposures = append(exposures, exp)
+			return nil
+		}); err != nil {
+			return 0, err
+		}
+		return len(batchExposures(exposures, preset, delta, regions, watermarks)), nil
+	}, nil
+}
+
+// mergeSmallRanges combines consecutive ranges until each contains at least
+// minKeys keys, as reported by countKeys. Trailing ranges which together
+// contain fewer than minKeys keys are withheld: they are not returned, and
+// their key count is returned as withheld. Since no batch covers them, they
+// are considered again the next time batches are created.
+func mergeSmallRanges(ranges []batchRange, minKeys int, countKeys func(batchRange) (int, error)) ([]batchRange, int, error) {
+	var merged []batchRange
+	var pending *batchRange
+	count := 0
+	for _, br := range ranges {
+		n, err := countKeys(br)
+		if err != nil {
+			return nil, 0, err
+		}
+		if pending == nil {
+			pending = &batchRange{start: br.start}
+		}
+		pending.end = br.end
+		count += n
+
+		if count >= minKeys {
+			merged = append(merged, *pending)
+			pending, count = nil, 0
+		}
+	}
+	return merged, count, nil
+}
+
 var sanityDate = time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
 
 func makeBatchRanges(period time.Duration, latestEnd, now time.Time, truncateWindow time.Duration) []batchRange {
@@ -15,10 +15,14 @@
 package export
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/google/exposure-notifications-server/internal/export/model"
+	"github.com/google/go-cmp/cmp"
 )
 
 type simpleBatchRange struct {
@@ -159,3 +163,134 @@ func toSimpleBatchRange(t *testing.T, batches []batchRange) []simpleBatchRange {
 	}
 	return simple
 }
+
+// TestMergeSmallRanges tests that periods with fewer than the minimum number of keys are withheld
+// until enough keys accumulate, and are then exported together.
+func TestMergeSmallRanges(t *testing.T) {
+	const minKeys = 8
+
+	// Number of keys published in each hour.
+	keys := map[string]int{
+		"12-10 07:00": 3,
+		"12-10 08:00": 1,
+		"12-10 09:00": 4,
+		"12-10 10:00": 12,
+		"12-10 11:00": 2,
+	}
+	countKeys := func(br batchRange) (int, error) {
+		n := 0
+		for start := br.start; start.Before(br.end); start = start.Add(time.Hour) {
+			n += keys[toSimpleTime(t, start)]
+		}
+		return n, nil
+	}
+
+	testCases := []struct {
+		name         string
+		latestEnd    string
+		now          string
+		want         []simpleBatchRange
+		wantWithheld int
+	}{
+		{
+			name:         "sub-threshold window is withheld",
+			latestEnd:    "12-10 07:00",
+			now:          "12-10 08:11",
+			wantWithheld: 3,
+		},
+		{
+			name:         "still below threshold",
+			latestEnd:    "12-10 07:00",
+			now:          "12-10 09:11",
+			wantWithheld: 4,
+		},
+		{
+			name:      "withheld windows exported once over threshold",
+			latestEnd: "12-10 07:00",
+			now:       "12-10 10:11",
+			want:      []simpleBatchRange{{"12-10 07:00", "12-10 10:00"}},
+		},
+		{
+			name:      "over threshold window is exported alone",
+			latestEnd: "12-10 10:00",
+			now:       "12-10 11:11",
+			want:      []simpleBatchRange{{"12-10 10:00", "12-10 11:00"}},
+		},
+		{
+			name:         "trailing window withheld",
+			latestEnd:    "12-10 09:00",
+			now:          "12-10 12:11",
+			want:         []simpleBatchRange{{"12-10 09:00", "12-10 11:00"}},
+			wantWithheld: 2,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ranges := makeBatchRanges(time.Hour, fromSimpleTime(t, tc.latestEnd), fromSimpleTime(t, tc.now), time.Hour)
+			got, withheld, err := mergeSmallRanges(ranges, minKeys, countKeys)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, toSimpleBatchRange(t, got), cmp.AllowUnexported(simpleBatchRange{})); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+			if withheld != tc.wantWithheld {
+				t.Errorf("withheld %d keys, want %d", withheld, tc.wantWithheld)
+			}
+		})
+	}
+}
+
+func TestScheduledTime(t *testing.T) {
+	ctx := context.Background()
+	now := fromSimpleTime(t, "12-10 09:11")
+
+	testCases := []struct {
+		name       string
+		schedule   string
+		unfinished bool
+		want       string
+		wantOK     bool
+	}{
+		{
+			name:     "every 4 hours",
+			schedule: "0 */4 * * *",
+			want:     "12-10 08:00",
+			wantOK:   true,
+		},
+		{
+			name:     "nightly",
+			schedule: "30 2 * * *",
+			want:     "12-10 02:30",
+			wantOK:   true,
+		},
+		{
+			name:       "previous run unfinished",
+			schedule:   "0 */4 * * *",
+			unfinished: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ec := &model.ExportConfig{ConfigID: 7, Schedule: tc.schedule}
+			hasUnfinished := func(_ context.Context, configID int64) (bool, error) {
+				if configID != ec.ConfigID {
+					t.Errorf("checked config %d, want %d", configID, ec.ConfigID)
+				}
+				return tc.unfinished, nil
+			}
+			got, ok, err := scheduledTime(ctx, ec, now, hasUnfinished)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tc.wantOK {
+				t.Fatalf("scheduledTime() ok=%v, want %v", ok, tc.wantOK)
+			}
+			if ok && toSimpleTime(t, got) != tc.want {
+				t.Errorf("scheduledTime()=%s, want %s", toSimpleTime(t, got), tc.want)
+			}
+		})
+	}
+}
@@ -47,6 +47,39 @@ type Config struct {
 	TruncateWindow time.Duration `envconfig:"TRUNCATE_WINDOW" default:"1h"`
 	MinWindowAge   time.Duration `envconfig:"MIN_WINDOW_AGE" default:"2h"`
 	TTL            time.Duration `envconfig:"CLEANUP_TTL" default:"336h"`
+
+	// EmitEmptyBatches writes a signed export file with no keys for a batch
+	// that had no keys in its window, instead of writing no file at all. This
+	// lets clients tell a quiet window apart from a stalled export.
+	EmitEmptyBatches bool `envconfig:"EXPORT_EMIT_EMPTY_BATCHES" default:"false"`
+
+	// MaxConcurrentReads bounds how many exposure reads an export instance
+	// runs at once, across concurrent requests, to protect the database when
+	// many batches are processed together. Further reads wait for a slot.
+	// Writing and uploading export files is not limited by it. Zero means no
+	// limit.
+	MaxConcurrentReads int `envconfig:"EXPORT_MAX_CONCURRENT_READS" default:"0"`
+
+	// SignatureCheckWindow is how far back, by batch end, the signature check
+	// job verifies export files in storage. SignatureCheckSampleRate is the
+	// fraction of those files it verifies on each run; 1 verifies all of them
+	// and 0 disables the check.
+	SignatureCheckWindow     time.Duration `envconfig:"SIGNATURE_CHECK_WINDOW" default:"72h"`
+	SignatureCheckSampleRate float64       `envconfig:"SIGNATURE_CHECK_SAMPLE_RATE" default:"1"`
+
+	// Metadata set on the files written to the blobstore. If RetentionDays is
+	// positive, export files are marked so a bucket lifecycle rule can delete
+	// them; the index file is rewritten in place and is never marked.
+	ContentType      string `envconfig:"EXPORT_CONTENT_TYPE" default:"application/zip"`
+	IndexContentType string `envconfig:"EXPORT_INDEX_CONTENT_TYPE" default:"text/plain; charset=utf-8"`
+	CacheControl     string `envconfig:"EXPORT_CACHE_CONTROL" default:"no-cache, max-age=0"`
+	RetentionDays    int    `envconfig:"EXPORT_RETENTION_DAYS"`
+
+	// BatchTagVersion, if set, is the server's build version, e.g. its image
+	// tag. Export files and the index are then tagged in the blobstore with it,
+	// the time they were written and their batch, see BatchTag, so that a
+	// problematic file can be traced to the build which wrote it.
+	BatchTagVersion string `envconfig:"EXPORT_BATCH_TAG_VERSION"`
 }
 
 func (c *Config) BlobstoreConfig() *storage.Config {
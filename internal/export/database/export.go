@@ -43,18 +43,20 @@ func (db *ExportDB) AddExportConfig(ctx context.Context, ec *model.ExportConfig)
 	if err := ec.Validate(); err != nil {
 		return err
 	}
+	ec.ProtocolVersion = ec.EffectiveProtocolVersion()
+	ec.Mode = ec.EffectiveMode()
 
 	thru := db.db.NullableTime(ec.Thru)
 	return db.db.InTx(ctx, pgx.Serializable, func(tx pgx.Tx) error {
 		row := tx.QueryRow(ctx, `
 			INSERT INTO
 				ExportConfig
-				(bucket_name, filename_root, period_seconds, output_region, from_timestamp, thru_timestamp, signature_info_ids, input_regions)
+				(bucket_name, filename_root, period_seconds, output_region, from_timestamp, thru_timestamp, signature_info_ids, input_regions, max_keys_per_batch, min_keys_per_window, protocol_version, schedule, mode, preset)
 			VALUES
-				($1, $2, $3, $4, $5, $6, $7, $8)
+				($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
 			RETURNING config_id
 		`, ec.BucketName, ec.FilenameRoot, int(ec.Period.Seconds()), ec.OutputRegion,
-			ec.From, thru, ec.SignatureInfoIDs, ec.InputRegions)
+			ec.From, thru, ec.SignatureInfoIDs, ec.InputRegions, ec.MaxKeysPerBatch, ec.MinKeysPerWindow, ec.ProtocolVersion, ec.Schedule, ec.Mode, ec.Preset)
 
 		if err := row.Scan(&ec.ConfigID); err != nil {
 			return fmt.Errorf("fetching config_id: %w", err)
@@ -68,6 +70,8 @@ func (db *ExportDB) UpdateExportConfig(ctx context.Context, ec *model.ExportConf
 	if err := ec.Validate(); err != nil {
 		return err
 	}
+	ec.ProtocolVersion = ec.EffectiveProtocolVersion()
+	ec.Mode = ec.EffectiveMode()
 
 	thru := db.db.NullableTime(ec.Thru)
 	return db.db.InTx(ctx, pgx.Serializable, func(tx pgx.Tx) error {
@@ -75,10 +79,10 @@ func (db *ExportDB) UpdateExportConfig(ctx context.Context, ec *model.ExportConf
 			UPDATE
 				ExportConfig
 			SET
-				bucket_name = $1, filename_root = $2, period_seconds = $3, output_region = $4, from_timestamp = $5, thru_timestamp = $6, signature_info_ids = $7, input_regions = $8
-			WHERE config_id = $9
+				bucket_name = $1, filename_root = $2, period_seconds = $3, output_region = $4, from_timestamp = $5, thru_timestamp = $6, signature_info_ids = $7, input_regions = $8, max_keys_per_batch = $9, min_keys_per_window = $10, protocol_version = $11, schedule = $12, mode = $13, preset = $14
+			WHERE config_id = $15
 		`, ec.BucketName, ec.FilenameRoot, int(ec.Period.Seconds()), ec.OutputRegion,
-			ec.From, thru, ec.SignatureInfoIDs, ec.InputRegions, ec.ConfigID)
+			ec.From, thru, ec.SignatureInfoIDs, ec.InputRegions, ec.MaxKeysPerBatch, ec.MinKeysPerWindow, ec.ProtocolVersion, ec.Schedule, ec.Mode, ec.Preset, ec.ConfigID)
 		if err != nil {
 			return fmt.Errorf("updating signatureinfo: %w", err)
 		}
@@ -98,7 +102,7 @@ func (db *ExportDB) GetExportConfig(ctx context.Context, id int64) (*model.Expor
 
 	row := conn.QueryRow(ctx, `
 		SELECT
-			config_id, bucket_name, filename_root, period_seconds, output_region, from_timestamp, thru_timestamp, signature_info_ids, input_regions
+			config_id, bucket_name, filename_root, period_seconds, output_region, from_timestamp, thru_timestamp, signature_info_ids, input_regions, max_keys_per_batch, min_keys_per_window, protocol_version, schedule, mode, preset
 		FROM
 			ExportConfig
 		WHERE
@@ -116,7 +120,7 @@ func (db *ExportDB) GetAllExportConfigs(ctx context.Context) ([]*model.ExportCon
 
 	rows, err := conn.Query(ctx, `
 		SELECT
-			config_id, bucket_name, filename_root, period_seconds, output_region, from_timestamp, thru_timestamp, signature_info_ids, input_regions
+			config_id, bucket_name, filename_root, period_seconds, output_region, from_timestamp, thru_timestamp, signature_info_ids, input_regions, max_keys_per_batch, min_keys_per_window, protocol_version, schedule, mode, preset
 		FROM
 			ExportConfig`)
 	if err != nil {
@@ -154,7 +158,7 @@ func (db *ExportDB) IterateExportConfigs(ctx context.Context, t time.Time, f fun
 
 	rows, err := conn.Query(ctx, `
 		SELECT
-			config_id, bucket_name, filename_root, period_seconds, output_region, from_timestamp, thru_timestamp, signature_info_ids, input_regions
+			config_id, bucket_name, filename_root, period_seconds, output_region, from_timestamp, thru_timestamp, signature_info_ids, input_regions, max_keys_per_batch, min_keys_per_window, protocol_version, schedule, mode, preset
 		FROM
 			ExportConfig
 		WHERE
@@ -184,7 +188,7 @@ func scanOneExportConfig(row pgx.Row) (*model.ExportConfig, error) {
 		periodSeconds int
 		thru          *time.Time
 	)
-	if err := row.Scan(&m.ConfigID, &m.BucketName, &m.FilenameRoot, &periodSeconds, &m.OutputRegion, &m.From, &thru, &m.SignatureInfoIDs, &m.InputRegions); err != nil {
+	if err := row.Scan(&m.ConfigID, &m.BucketName, &m.FilenameRoot, &periodSeconds, &m.OutputRegion, &m.From, &thru, &m.SignatureInfoIDs, &m.InputRegions, &m.MaxKeysPerBatch, &m.MinKeysPerWindow, &m.ProtocolVersion, &m.Schedule, &m.Mode, &m.Preset); err != nil {
 		return nil, err
 	}
 	m.Period = time.Duration(periodSeconds) * time.Second
@@ -343,6 +347,56 @@ func scanOneSignatureInfo(row pgx.Row) (*model.SignatureInfo, error) {
 	return &info, nil
 }
 
+// AddExportPreset creates or replaces the ExportPreset with the preset's name.
+func (db *ExportDB) AddExportPreset(ctx context.Context, p *model.ExportPreset) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	return db.db.InTx(ctx, pgx.Serializable, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO
+				ExportPreset
+				(preset_name, verified_only, min_transmission_risk)
+			VALUES
+				($1, $2, $3)
+			ON CONFLICT (preset_name) DO UPDATE
+				SET verified_only = $2, min_transmission_risk = $3
+			`, p.Name, p.VerifiedOnly, p.MinTransmissionRisk)
+		if err != nil {
+			return fmt.Errorf("upserting export preset: %w", err)
+		}
+		return nil
+	})
+}
+
+// GetExportPreset returns the ExportPreset with the given name, or
+// database.ErrNotFound if there is none.
+func (db *ExportDB) GetExportPreset(ctx context.Context, name string) (*model.ExportPreset, error) {
+	conn, err := db.db.Pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acquiring connection: %w", err)
+	}
+	defer conn.Release()
+
+	row := conn.QueryRow(ctx, `
+		SELECT
+			preset_name, verified_only, min_transmission_risk
+		FROM
+			ExportPreset
+		WHERE
+			preset_name = $1
+		`, name)
+
+	var p model.ExportPreset
+	if err := row.Scan(&p.Name, &p.VerifiedOnly, &p.MinTransmissionRisk); err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, database.ErrNotFound
+		}
+		return nil, err
+	}
+	return &p, nil
+}
+
 // LatestExportBatchEnd returns the end time of the most recent ExportBatch for
 // a given ExportConfig. It returns the zero time if no previous ExportBatch
 // exists.
@@ -375,6 +429,35 @@ func (db *ExportDB) LatestExportBatchEnd(ctx context.Context, ec *model.ExportCo
 	return latestEnd, nil
 }
 
+// HasUnfinishedExportBatches returns true if any ExportBatch for the given
+// ExportConfig is still open or pending.
+func (db *ExportDB) HasUnfinishedExportBatches(ctx context.Context, configID int64) (bool, error) {
+	conn, err := db.db.Pool.Acquire(ctx)
+	if err != nil {
+		return false, fmt.Errorf("acquiring connection: %w", err)
+	}
+	defer conn.Release()
+
+	row := conn.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT
+				1
+			FROM
+				ExportBatch
+			WHERE
+				config_id = $1
+				AND
+				status IN ($2, $3)
+		)
+		`, configID, model.ExportBatchOpen, model.ExportBatchPending)
+
+	var unfinished bool
+	if err := row.Scan(&unfinished); err != nil {
+		return false, fmt.Errorf("scanning result: %w", err)
+	}
+	return unfinished, nil
+}
+
 // AddExportBatches inserts new export batches.
 func (db *ExportDB) AddExportBatches(ctx context.Context, batches []*model.ExportBatch) error {
 	return db.db.InTx(ctx, pgx.Serializable, func(tx pgx.Tx) error {
@@ -382,9 +465,9 @@ func (db *ExportDB) AddExportBatches(ctx context.Context, batches []*model.Expor
 		_, err := tx.Prepare(ctx, stmtName, `
 			INSERT INTO
 				ExportBatch
-				(config_id, bucket_name, filename_root, start_timestamp, end_timestamp, output_region, status, signature_info_ids, input_regions)
+				(config_id, bucket_name, filename_root, start_timestamp, end_timestamp, output_region, status, signature_info_ids, input_regions, max_keys_per_batch, protocol_version, mode, preset)
 			VALUES
-				($1, $2, $3, $4, $5, $6, $7, $8, $9)
+				($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
 		`)
 		if err != nil {
 			return err
@@ -392,7 +475,7 @@ func (db *ExportDB) AddExportBatches(ctx context.Context, batches []*model.Expor
 
 		for _, eb := range batches {
 			if _, err := tx.Exec(ctx, stmtName,
-				eb.ConfigID, eb.BucketName, eb.FilenameRoot, eb.StartTimestamp, eb.EndTimestamp, eb.OutputRegion, eb.Status, eb.SignatureInfoIDs, eb.InputRegions); err != nil {
+				eb.ConfigID, eb.BucketName, eb.FilenameRoot, eb.StartTimestamp, eb.EndTimestamp, eb.OutputRegion, eb.Status, eb.SignatureInfoIDs, eb.InputRegions, eb.MaxKeysPerBatch, eb.ProtocolVersion, eb.Mode, eb.Preset); err != nil {
 				return err
 			}
 		}
@@ -523,7 +606,7 @@ type queryRowFn func(ctx context.Context, query string, args ...interface{}) pgx
 func lookupExportBatch(ctx context.Context, batchID int64, queryRow queryRowFn) (*model.ExportBatch, error) {
 	row := queryRow(ctx, `
 		SELECT
-			batch_id, config_id, bucket_name, filename_root, start_timestamp, end_timestamp, output_region, status, lease_expires, signature_info_ids, input_regions
+			batch_id, config_id, bucket_name, filename_root, start_timestamp, end_timestamp, output_region, status, lease_expires, signature_info_ids, input_regions, max_keys_per_batch, protocol_version, mode, preset
 		FROM
 			ExportBatch
 		WHERE
@@ -533,7 +616,7 @@ func lookupExportBatch(ctx context.Context, batchID int64, queryRow queryRowFn)
 
 	var expires *time.Time
 	eb := model.ExportBatch{}
-	if err := row.Scan(&eb.BatchID, &eb.ConfigID, &eb.BucketName, &eb.FilenameRoot, &eb.StartTimestamp, &eb.EndTimestamp, &eb.OutputRegion, &eb.Status, &expires, &eb.SignatureInfoIDs, &eb.InputRegions); err != nil {
+	if err := row.Scan(&eb.BatchID, &eb.ConfigID, &eb.BucketName, &eb.FilenameRoot, &eb.StartTimestamp, &eb.EndTimestamp, &eb.OutputRegion, &eb.Status, &expires, &eb.SignatureInfoIDs, &eb.InputRegions, &eb.MaxKeysPerBatch, &eb.ProtocolVersion, &eb.Mode, &eb.Preset); err != nil {
 		if err == pgx.ErrNoRows {
 			return nil, database.ErrNotFound
 		}
@@ -546,7 +629,9 @@ func lookupExportBatch(ctx context.Context, batchID int64, queryRow queryRowFn)
 }
 
 // FinalizeBatch writes the ExportFile records and marks the ExportBatch as complete.
-func (db *ExportDB) FinalizeBatch(ctx context.Context, eb *model.ExportBatch, files []string, batchSize int) error {
+// For a delta batch, watermarks holds the latest CreatedAt exported in each
+// input region, and the config's export watermarks are advanced to it.
+func (db *ExportDB) FinalizeBatch(ctx context.Context, eb *model.ExportBatch, files []string, batchSize int, watermarks map[string]time.Time) error {
 	return db.db.InTx(ctx, pgx.Serializable, func(tx pgx.Tx) error {
 		// Update ExportFile for the files created.
 		for i, file := range files {
@@ -569,6 +654,21 @@ func (db *ExportDB) FinalizeBatch(ctx context.Context, eb *model.ExportBatch, fi
 			}
 		}
 
+		for region, createdAt := range watermarks {
+			_, err := tx.Exec(ctx, `
+				INSERT INTO
+					ExportWatermark
+					(config_id, region, max_created_at)
+				VALUES
+					($1, $2, $3)
+				ON CONFLICT (config_id, region) DO UPDATE
+					SET max_created_at = GREATEST(ExportWatermark.max_created_at, $3)
+			`, eb.ConfigID, region, createdAt)
+			if err != nil {
+				return fmt.Errorf("updating export watermark: %w", err)
+			}
+		}
+
 		// Update ExportBatch to mark it complete.
 		if err := completeBatch(ctx, tx, eb.BatchID); err != nil {
 			return fmt.Errorf("marking batch %v complete: %w", eb.BatchID, err)
@@ -577,6 +677,44 @@ func (db *ExportDB) FinalizeBatch(ctx context.Context, eb *model.ExportBatch, fi
 	})
 }
 
+// ExportWatermarks returns, for each input region, the latest CreatedAt of a
+// key exported by a delta batch of the given ExportConfig. Regions which have
+// not been exported yet are absent.
+func (db *ExportDB) ExportWatermarks(ctx context.Context, configID int64) (map[string]time.Time, error) {
+	conn, err := db.db.Pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acquiring connection: %w", err)
+	}
+	defer conn.Release()
+
+	rows, err := conn.Query(ctx, `
+		SELECT
+			region, max_created_at
+		FROM
+			ExportWatermark
+		WHERE
+			config_id = $1
+	`, configID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	watermarks := make(map[string]time.Time)
+	for rows.Next() {
+		var region string
+		var createdAt time.Time
+		if err := rows.Scan(&region, &createdAt); err != nil {
+			return nil, err
+		}
+		watermarks[region] = createdAt
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating rows: %w", err)
+	}
+	return watermarks, nil
+}
+
 // LookupExportFiles returns a list of completed and unexpired export files.
 func (db *ExportDB) LookupExportFiles(ctx context.Context, ttl time.Duration) ([]string, error) {
 	conn, err := db.db.Pool.Acquire(ctx)
@@ -619,6 +757,48 @@ func (db *ExportDB) LookupExportFiles(ctx context.Context, ttl time.Duration) ([
 	return filenames, nil
 }
 
+// LookupRecentExportFiles returns the completed export files of batches which
+// ended at or after since, oldest first.
+func (db *ExportDB) LookupRecentExportFiles(ctx context.Context, since time.Time) ([]*model.ExportFile, error) {
+	conn, err := db.db.Pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acquiring connection: %w", err)
+	}
+	defer conn.Release()
+
+	rows, err := conn.Query(ctx, `
+		SELECT
+			ef.bucket_name, ef.filename, ef.batch_id, ef.output_region, ef.batch_num, ef.batch_size, ef.status, ef.input_regions
+		FROM
+			ExportFile ef
+		INNER JOIN
+			ExportBatch eb ON (eb.batch_id = ef.batch_id)
+		WHERE
+			eb.end_timestamp >= $1
+		AND
+			ef.status = $2
+		ORDER BY
+			eb.end_timestamp, ef.batch_id, ef.batch_num
+		`, since, model.ExportBatchComplete)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []*model.ExportFile
+	for rows.Next() {
+		if rows.Err() != nil {
+			return nil, rows.Err()
+		}
+		var ef model.ExportFile
+		if err := rows.Scan(&ef.BucketName, &ef.Filename, &ef.BatchID, &ef.OutputRegion, &ef.BatchNum, &ef.BatchSize, &ef.Status, &ef.InputRegions); err != nil {
+			return nil, err
+		}
+		files = append(files, &ef)
+	}
+	return files, nil
+}
+
 type joinedExportBatchFile struct {
 	bucketName  string
 	filename    string
@@ -158,6 +158,10 @@ func TestAddGetUpdateExportConfig(t *testing.T) {
 	want.Thru = time.Time{}
 	want.SignatureInfoIDs = []int64{1, 2, 3, 4, 5}
 	want.InputRegions = []string{"US", "CA"}
+	want.MaxKeysPerBatch = 10
+	want.MinKeysPerWindow = 50
+	want.ProtocolVersion = model.ExportProtocolV15
+	want.Schedule = "0 */4 * * *"
 
 	if err := exportDB.UpdateExportConfig(ctx, want); err != nil {
 		t.Fatal(err)
@@ -172,6 +176,35 @@ func TestAddGetUpdateExportConfig(t *testing.T) {
 	}
 }
 
+func TestAddGetExportPreset(t *testing.T) {
+	t.Parallel()
+
+	testDB := database.NewTestDatabase(t)
+	ctx := context.Background()
+	exportDB := New(testDB)
+
+	if _, err := exportDB.GetExportPreset(ctx, "app-a"); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("GetExportPreset() of a missing preset returned err=%v, want %v", err, database.ErrNotFound)
+	}
+
+	want := &model.ExportPreset{Name: "app-a", VerifiedOnly: true}
+	if err := exportDB.AddExportPreset(ctx, want); err != nil {
+		t.Fatal(err)
+	}
+	// Adding a preset with the same name replaces it.
+	want.MinTransmissionRisk = 3
+	if err := exportDB.AddExportPreset(ctx, want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := exportDB.GetExportPreset(ctx, "app-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
 func TestIterateExportConfigs(t *testing.T) {
 	t.Parallel()
 
@@ -387,7 +420,7 @@ func TestFinalizeBatch(t *testing.T) {
 	// Finalize the batch.
 	files := []string{"file1.txt", "file2.txt"}
 	batchSize := 10
-	if err := exportDB.FinalizeBatch(ctx, eb, files, batchSize); err != nil {
+	if err := exportDB.FinalizeBatch(ctx, eb, files, batchSize, nil); err != nil {
 		t.Fatal(err)
 	}
 
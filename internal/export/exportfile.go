@@ -43,8 +43,28 @@ const (
 var (
 	fixedHeader      = []byte("EK Export v1    ")
 	fixedHeaderWidth = 16
+
+	// fixedHeaders are the headers of each export protocol version. Every
+	// header is fixedHeaderWidth bytes, padded with spaces.
+	fixedHeaders = map[string][]byte{
+		model.ExportProtocolV1:  fixedHeader,
+		model.ExportProtocolV15: []byte("EK Export v1.5  "),
+	}
 )
 
+// exportHeader returns the fixed header for the given protocol version. The
+// empty version is ExportProtocolV1.
+func exportHeader(version string) ([]byte, error) {
+	if version == "" {
+		version = model.ExportProtocolV1
+	}
+	header, ok := fixedHeaders[version]
+	if !ok {
+		return nil, fmt.Errorf("unsupported protocol version %q", version)
+	}
+	return header, nil
+}
+
 type Signer struct {
 	SignatureInfo *model.SignatureInfo
 	Signer        crypto.Signer
@@ -117,9 +137,8 @@ func unmarshalContent(file *zip.File) (*export.TemporaryExposureKeyExport, error
 		return nil, err
 	}
 
-	prefix := content[:fixedHeaderWidth]
-	if !bytes.Equal(prefix, fixedHeader) {
-		return nil, fmt.Errorf("unknown prefix: %v", string(prefix))
+	if _, err := exportFileVersion(content); err != nil {
+		return nil, err
 	}
 
 	message := new(export.TemporaryExposureKeyExport)
@@ -131,8 +150,28 @@ func unmarshalContent(file *zip.File) (*export.TemporaryExposureKeyExport, error
 	return message, nil
 }
 
+// exportFileVersion returns the protocol version of the export.bin content,
+// identified by its header.
+func exportFileVersion(content []byte) (string, error) {
+	if len(content) < fixedHeaderWidth {
+		return "", fmt.Errorf("content too short for header: %d bytes", len(content))
+	}
+	prefix := content[:fixedHeaderWidth]
+	for version, header := range fixedHeaders {
+		if bytes.Equal(prefix, header) {
+			return version, nil
+		}
+	}
+	return "", fmt.Errorf("unknown prefix: %v", string(prefix))
+}
+
 func marshalContents(eb *model.ExportBatch, exposures []*publishmodel.Exposure, batchNum int32, batchSize int32, signers []*Signer) ([]byte, error) {
-	exportBytes := fixedHeader
+	header, err := exportHeader(eb.ProtocolVersion)
+	if err != nil {
+		return nil, err
+	}
+	// Copy the header so that appending the contents never writes into it.
+	exportBytes := append([]byte(nil), header...)
 	if len(exportBytes) != fixedHeaderWidth {
 		return nil, fmt.Errorf("incorrect header length: %d", len(exportBytes))
 	}
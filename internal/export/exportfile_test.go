@@ -15,8 +15,17 @@
 package export
 
 import (
+	"archive/zip"
+	"bytes"
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
 	"io"
+	"io/ioutil"
+	"math/big"
 	"testing"
 	"time"
 
@@ -129,6 +138,113 @@ func TestMarshalUnmarshalExportFile(t *testing.T) {
 	}
 }
 
+func TestExportFileProtocolVersions(t *testing.T) {
+	t.Parallel()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signers := []*Signer{{
+		SignatureInfo: &model.SignatureInfo{SigningKeyVersion: "1", SigningKeyID: "310"},
+		Signer:        key,
+	}}
+	exposures := []*publishmodel.Exposure{
+		{ExposureKey: []byte("ABC"), IntervalNumber: 18, IntervalCount: 144, TransmissionRisk: 8},
+	}
+
+	// The header each client population expects at the start of export.bin.
+	cases := []struct {
+		version string
+		header  string
+	}{
+		{version: "", header: "EK Export v1    "},
+		{version: model.ExportProtocolV1, header: "EK Export v1    "},
+		{version: model.ExportProtocolV15, header: "EK Export v1.5  "},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.version, func(t *testing.T) {
+			t.Parallel()
+
+			batch := &model.ExportBatch{
+				StartTimestamp:  time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC),
+				EndTimestamp:    time.Date(2020, 5, 1, 1, 0, 0, 0, time.UTC),
+				OutputRegion:    "US",
+				ProtocolVersion: tc.version,
+			}
+			blob, err := MarshalExportFile(batch, exposures, 1, 1, signers)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			files := unzipForTest(t, blob)
+			bin, sig := files[exportBinaryName], files[exportSignatureName]
+			if got := string(bin[:fixedHeaderWidth]); got != tc.header {
+				t.Errorf("header: got %q, want %q", got, tc.header)
+			}
+
+			var teksl export.TEKSignatureList
+			if err := proto.Unmarshal(sig, &teksl); err != nil {
+				t.Fatal(err)
+			}
+			if len(teksl.Signatures) != 1 {
+				t.Fatalf("got %d signatures, want 1", len(teksl.Signatures))
+			}
+			var esig struct{ R, S *big.Int }
+			if _, err := asn1.Unmarshal(teksl.Signatures[0].Signature, &esig); err != nil {
+				t.Fatal(err)
+			}
+			// The signature covers the whole of export.bin, header included.
+			digest := sha256.Sum256(bin)
+			if !ecdsa.Verify(&key.PublicKey, digest[:], esig.R, esig.S) {
+				t.Error("signature does not verify over export.bin")
+			}
+
+			got, err := UnmarshalExportFile(blob)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := len(got.Keys); n != 1 {
+				t.Errorf("got %d keys, want 1", n)
+			}
+		})
+	}
+
+	t.Run("unsupported", func(t *testing.T) {
+		t.Parallel()
+
+		batch := &model.ExportBatch{ProtocolVersion: "v2"}
+		if _, err := MarshalExportFile(batch, exposures, 1, 1, signers); err == nil {
+			t.Error("expected error for unsupported protocol version")
+		}
+	})
+}
+
+func unzipForTest(t *testing.T, blob []byte) map[string][]byte {
+	t.Helper()
+
+	zr, err := zip.NewReader(bytes.NewReader(blob), int64(len(blob)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string][]byte)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = b
+	}
+	return files
+}
+
 type customTestSigner struct {
 	sig []byte
 	pub crypto.PublicKey
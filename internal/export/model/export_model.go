@@ -16,6 +16,7 @@ package model
 
 import (
 	"errors"
+	"fmt"
 	"strings"
 	"time"
 )
@@ -31,6 +32,24 @@ const (
 	oneDay = 24 * time.Hour
 )
 
+// Export file protocol versions. Version 1 files begin with the original
+// header, which every client accepts. Version 1.5 files carry the extended
+// header, which only newer clients accept. To serve both populations, create
+// one ExportConfig per version with different filename roots.
+const (
+	ExportProtocolV1  = "v1"
+	ExportProtocolV15 = "v1.5"
+)
+
+// Export modes. A full batch contains every key created in its period. A delta
+// batch contains only the keys created after the latest key its config has
+// already exported in each input region, so a key which is already exported is
+// not exported again, even if batches overlap.
+const (
+	ExportModeFull  = "full"
+	ExportModeDelta = "delta"
+)
+
 type ExportConfig struct {
 	ConfigID         int64         `db:"config_id"`
 	BucketName       string        `db:"bucket_name"`
@@ -41,6 +60,46 @@ type ExportConfig struct {
 	From             time.Time     `db:"from_timestamp"`
 	Thru             time.Time     `db:"thru_timestamp"`
 	SignatureInfoIDs []int64       `db:"signature_info_ids"`
+	// MaxKeysPerBatch limits the number of keys in each export file of a
+	// batch. If zero, the export server's EXPORT_FILE_MAX_RECORDS is used.
+	MaxKeysPerBatch int `db:"max_keys_per_batch"`
+	// MinKeysPerWindow withholds keys until at least this many have been
+	// published to the input regions. Periods with fewer keys are not batched,
+	// and are instead combined with the following period(s). If zero, every
+	// period is exported.
+	MinKeysPerWindow int `db:"min_keys_per_window"`
+	// ProtocolVersion is the export file format, ExportProtocolV1 or
+	// ExportProtocolV15. If empty, ExportProtocolV1 is used.
+	ProtocolVersion string `db:"protocol_version"`
+	// Schedule is a cron expression (see ParseSchedule) limiting when batches
+	// are created: each run creates the batches for the periods which ended
+	// before it. While batches from the previous run are still being exported,
+	// no new batches are created. If empty, batches are created as soon as each
+	// period ends.
+	Schedule string `db:"schedule"`
+	// Mode is ExportModeFull or ExportModeDelta. If empty, ExportModeFull is
+	// used.
+	Mode string `db:"mode"`
+	// Preset, if set, is the name of the ExportPreset filtering the exported
+	// keys.
+	Preset string `db:"preset"`
+}
+
+// EffectiveProtocolVersion returns ProtocolVersion, or ExportProtocolV1 if it
+// is unset.
+func (ec *ExportConfig) EffectiveProtocolVersion() string {
+	if ec.ProtocolVersion == "" {
+		return ExportProtocolV1
+	}
+	return ec.ProtocolVersion
+}
+
+// EffectiveMode returns Mode, or ExportModeFull if it is unset.
+func (ec *ExportConfig) EffectiveMode() string {
+	if ec.Mode == "" {
+		return ExportModeFull
+	}
+	return ec.Mode
 }
 
 // EffectiveInputRegions either returns `InputRegions` or if that array is
@@ -54,6 +113,23 @@ func (ec *ExportConfig) InputRegionsOnePerLine() string {
 }
 
 func (ec *ExportConfig) Validate() error {
+	if ec.MaxKeysPerBatch < 0 {
+		return errors.New("max keys per batch cannot be negative")
+	}
+	if ec.MinKeysPerWindow < 0 {
+		return errors.New("min keys per window cannot be negative")
+	}
+	if v := ec.EffectiveProtocolVersion(); v != ExportProtocolV1 && v != ExportProtocolV15 {
+		return fmt.Errorf("unsupported protocol version %q", v)
+	}
+	if m := ec.EffectiveMode(); m != ExportModeFull && m != ExportModeDelta {
+		return fmt.Errorf("unsupported mode %q", m)
+	}
+	if ec.Schedule != "" {
+		if _, err := ParseSchedule(ec.Schedule); err != nil {
+			return fmt.Errorf("invalid schedule: %w", err)
+		}
+	}
 	if ec.Period > oneDay {
 		return errors.New("maximum period is 24h")
 	}
@@ -105,6 +181,16 @@ type ExportBatch struct {
 	Status           string    `db:"status" json:"status"`
 	LeaseExpires     time.Time `db:"lease_expires" json:"leaseExpires"`
 	SignatureInfoIDs []int64   `db:"signature_info_ids"`
+	MaxKeysPerBatch  int       `db:"max_keys_per_batch" json:"maxKeysPerBatch"`
+	ProtocolVersion  string    `db:"protocol_version" json:"protocolVersion"`
+	Mode             string    `db:"mode" json:"mode"`
+	Preset           string    `db:"preset" json:"preset"`
+}
+
+// IsDelta returns true if the batch only contains keys not previously
+// exported by its config. See ExportModeDelta.
+func (eb *ExportBatch) IsDelta() bool {
+	return eb.Mode == ExportModeDelta
 }
 
 // EffectiveInputRegions either returns `InputRegions` or if that array is
@@ -113,6 +199,33 @@ func (eb *ExportBatch) EffectiveInputRegions() []string {
 	return effectiveInputRegions(eb.OutputRegion, eb.InputRegions)
 }
 
+// ExportPreset is a named filter on the keys an ExportConfig exports. Client
+// apps with different needs in the same region are each served by their own
+// ExportConfig, with its own filename root and signing keys, and a preset such
+// as "verified keys only", all exported from the same keys.
+type ExportPreset struct {
+	Name string `db:"preset_name"`
+	// VerifiedOnly only exports keys whose diagnosis was verified.
+	VerifiedOnly bool `db:"verified_only"`
+	// MinTransmissionRisk only exports keys with at least this transmission
+	// risk.
+	MinTransmissionRisk int `db:"min_transmission_risk"`
+}
+
+// Validate checks that the preset is well formed.
+func (p *ExportPreset) Validate() error {
+	if p.Name == "" {
+		return errors.New("preset name is required")
+	}
+	if len(p.Name) > 50 {
+		return fmt.Errorf("preset name %q is longer than 50 characters", p.Name)
+	}
+	if p.MinTransmissionRisk < 0 {
+		return fmt.Errorf("min transmission risk must be >= 0, got %d", p.MinTransmissionRisk)
+	}
+	return nil
+}
+
 type ExportFile struct {
 	BucketName   string   `db:"bucket_name"`
 	Filename     string   `db:"filename"`
@@ -0,0 +1,85 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	// A Wednesday.
+	now := time.Date(2020, 7, 15, 13, 37, 42, 0, time.UTC)
+
+	cases := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2020, 7, 15, 13, 37, 0, 0, time.UTC)},
+		{"0 */4 * * *", time.Date(2020, 7, 15, 12, 0, 0, 0, time.UTC)},
+		{"30 2 * * *", time.Date(2020, 7, 15, 2, 30, 0, 0, time.UTC)},
+		{"45 13 * * *", time.Date(2020, 7, 14, 13, 45, 0, 0, time.UTC)},
+		{"@daily", time.Date(2020, 7, 15, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2020, 7, 15, 13, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2020, 7, 12, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2020, 7, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2020, 7, 12, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 1-5", time.Date(2020, 7, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 0 20 * *", time.Date(2020, 6, 20, 0, 0, 0, 0, time.UTC)},
+		{"0,15 9-17/2 * * *", time.Date(2020, 7, 15, 13, 15, 0, 0, time.UTC)},
+		{"10/20 * * * *", time.Date(2020, 7, 15, 13, 30, 0, 0, time.UTC)},
+		{"0 0 1 1 *", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// Either the 20th or a Monday.
+		{"0 0 20 * 1", time.Date(2020, 7, 13, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.expr, func(t *testing.T) {
+			s, err := ParseSchedule(tc.expr)
+			if err != nil {
+				t.Fatalf("ParseSchedule(%q) returned err=%v", tc.expr, err)
+			}
+			got, ok := s.Prev(now)
+			if !ok || !got.Equal(tc.want) {
+				t.Errorf("Prev(%v)=%v, %v, want %v", now, got, ok, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseScheduleInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+		"@yearly",
+		"0 0 31 2 *",
+	} {
+		t.Run(expr, func(t *testing.T) {
+			if _, err := ParseSchedule(expr); err == nil {
+				t.Errorf("ParseSchedule(%q) returned no error", expr)
+			}
+		})
+	}
+}
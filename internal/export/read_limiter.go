@@ -0,0 +1,70 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"context"
+	"fmt"
+
+	publishdb "github.com/google/exposure-notifications-server/internal/publish/database"
+	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
+)
+
+// readLimiter bounds the number of exposure reads in progress at once, so
+// that many batches for different regions being exported together do not
+// overwhelm the database. It does not limit writing or uploading files.
+type readLimiter struct {
+	slots chan struct{}
+}
+
+// newReadLimiter creates a limiter admitting max concurrent reads. If max is
+// not positive, there is no limit and nil is returned.
+func newReadLimiter(max int) *readLimiter {
+	if max <= 0 {
+		return nil
+	}
+	return &readLimiter{slots: make(chan struct{}, max)}
+}
+
+// acquire waits for a slot, returning a function which must be called to
+// release it, and the number of reads in progress including this one. A nil
+// limiter admits every read immediately.
+func (l *readLimiter) acquire(ctx context.Context) (func(), int, error) {
+	if l == nil {
+		return func() {}, 0, nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return func() { <-l.slots }, len(l.slots), nil
+	case <-ctx.Done():
+		return nil, 0, fmt.Errorf("waiting to read exposures: %w", ctx.Err())
+	}
+}
+
+// iterateExposures reads exposures from the publish database once a read slot
+// is available.
+func (s *Server) iterateExposures(ctx context.Context, criteria publishdb.IterateExposuresCriteria, f func(*publishmodel.Exposure) error) error {
+	release, inFlight, err := s.reads.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if s.reads != nil {
+		s.env.MetricsExporter(ctx).WriteInt("export-read-concurrency", false, inFlight)
+	}
+
+	_, err = s.publishdb.IterateExposures(ctx, criteria, f)
+	return err
+}
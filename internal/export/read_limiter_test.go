@@ -0,0 +1,106 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReadLimiter(t *testing.T) {
+	const limit = 3
+	const regions = 20
+	ctx := context.Background()
+	limiter := newReadLimiter(limit)
+
+	// Queue a read for more regions than the limit, and track how many run at once.
+	var mu sync.Mutex
+	active, maxActive := 0, 0
+	var wg sync.WaitGroup
+	for i := 0; i < regions; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, inFlight, err := limiter.acquire(ctx)
+			if err != nil {
+				t.Errorf("acquire: %v", err)
+				return
+			}
+			defer release()
+			if inFlight < 1 || inFlight > limit {
+				t.Errorf("acquire reported %d reads in flight, want between 1 and %d", inFlight, limit)
+			}
+
+			mu.Lock()
+			active++
+			if active > maxActive {
+				maxActive = active
+			}
+			mu.Unlock()
+
+			time.Sleep(5 * time.Millisecond)
+
+			mu.Lock()
+			active--
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if maxActive > limit {
+		t.Errorf("%d reads ran at once, want at most %d", maxActive, limit)
+	}
+	if maxActive < limit {
+		t.Errorf("at most %d reads ran at once, want the limit of %d to be reached", maxActive, limit)
+	}
+}
+
+func TestReadLimiterCanceled(t *testing.T) {
+	limiter := newReadLimiter(1)
+	release, _, err := limiter.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A queued read gives up when its context ends.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, _, err := limiter.acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("acquire() with no free slot returned err=%v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// Once released, the slot can be used again.
+	release()
+	release, _, err = limiter.acquire(context.Background())
+	if err != nil {
+		t.Fatalf("acquire() after release returned err=%v", err)
+	}
+	release()
+}
+
+func TestReadLimiterUnlimited(t *testing.T) {
+	if limiter := newReadLimiter(0); limiter != nil {
+		t.Fatalf("newReadLimiter(0)=%v, want nil", limiter)
+	}
+	var limiter *readLimiter
+	release, _, err := limiter.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
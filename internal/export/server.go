@@ -36,6 +36,9 @@ func NewServer(config *Config, env *serverenv.ServerEnv) (*Server, error) {
 	if config.MinWindowAge < 0 {
 		return nil, fmt.Errorf("MIN_WINDOW_AGE must be a duration of >= 0")
 	}
+	if config.SignatureCheckSampleRate < 0 || config.SignatureCheckSampleRate > 1 {
+		return nil, fmt.Errorf("SIGNATURE_CHECK_SAMPLE_RATE must be >= 0 and <= 1")
+	}
 
 	return &Server{
 		db:        env.Database(),
@@ -43,6 +46,7 @@ func NewServer(config *Config, env *serverenv.ServerEnv) (*Server, error) {
 		publishdb: publishdb.New(env.Database()),
 		config:    config,
 		env:       env,
+		reads:     newReadLimiter(config.MaxConcurrentReads),
 	}, nil
 }
 
@@ -53,4 +57,5 @@ type Server struct {
 	publishdb *publishdb.PublishDB
 	config    *Config
 	env       *serverenv.ServerEnv
+	reads     *readLimiter // nil if reads are not limited
 }
@@ -0,0 +1,176 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+
+	coredb "github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/export/model"
+	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/metrics"
+)
+
+// signatureCheckDependencies are the data sources of checkSignatures.
+type signatureCheckDependencies struct {
+	recentFiles func(ctx context.Context, since time.Time) ([]*model.ExportFile, error)
+	lookupBatch func(ctx context.Context, batchID int64) (*model.ExportBatch, error)
+	signers     func(ctx context.Context, eb *model.ExportBatch) ([]*Signer, error)
+	getObject   func(ctx context.Context, bucket, objectName string) ([]byte, error)
+	sample      func() float64
+}
+
+// signatureCheckResult summarizes a run of checkSignatures.
+type signatureCheckResult struct {
+	Checked int
+	Failed  []string
+}
+
+// VerifySignaturesHandler verifies the signatures of the export files written
+// in the last SignatureCheckWindow against the public keys of their batches'
+// signature infos, so that a misconfigured signing key is noticed before
+// clients reject the files. Failures are logged and counted in the
+// export-signature-check-failed metric.
+func (s *Server) VerifySignaturesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), s.config.WorkerTimeout)
+	defer cancel()
+	logger := logging.FromContext(ctx)
+	metrics := s.env.MetricsExporter(ctx)
+
+	lock := "export_signature_check"
+	unlockFn, err := s.db.Lock(ctx, lock, s.config.WorkerTimeout)
+	if err != nil {
+		if errors.Is(err, coredb.ErrAlreadyLocked) {
+			msg := fmt.Sprintf("Lock %s already in use, no work will be performed", lock)
+			logger.Infof(msg)
+			fmt.Fprint(w, msg) // We return status 200 here so that Cloud Scheduler does not retry.
+			return
+		}
+		logger.Errorf("Could not acquire lock %s: %v", lock, err)
+		http.Error(w, fmt.Sprintf("Could not acquire lock %s, check logs.", lock), http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		if err := unlockFn(); err != nil {
+			logger.Errorf("failed to unlock: %v", err)
+		}
+	}()
+
+	deps := signatureCheckDependencies{
+		recentFiles: s.exportdb.LookupRecentExportFiles,
+		lookupBatch: s.exportdb.LookupExportBatch,
+		signers:     s.batchSigners,
+		getObject:   s.env.Blobstore().GetObject,
+		sample:      rand.Float64,
+	}
+	since := time.Now().Add(-s.config.SignatureCheckWindow)
+	result, err := checkSignatures(ctx, metrics, deps, since, s.config.SignatureCheckSampleRate)
+	if err != nil {
+		metrics.WriteInt("export-signature-check-error", true, 1)
+		logger.Errorf("checking export signatures: %v", err)
+		http.Error(w, "Failed to check export signatures, check logs.", http.StatusInternalServerError)
+		return
+	}
+	msg := fmt.Sprintf("Checked signatures of %d export files, %d failed", result.Checked, len(result.Failed))
+	logger.Info(msg)
+	fmt.Fprintln(w, msg)
+}
+
+// batchSigners returns a Signer for each of the signature infos of a batch,
+// including those which have since expired, since the batch may have been
+// signed with them.
+func (s *Server) batchSigners(ctx context.Context, eb *model.ExportBatch) ([]*Signer, error) {
+	sigInfos, err := s.exportdb.LookupSignatureInfos(ctx, eb.SignatureInfoIDs, time.Time{})
+	if err != nil {
+		return nil, fmt.Errorf("loading signature infos: %w", err)
+	}
+	signers := make([]*Signer, 0, len(sigInfos))
+	for _, si := range sigInfos {
+		signer, err := s.env.GetSignerForKey(ctx, si.SigningKey)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get signer for key %v: %w", si.SigningKey, err)
+		}
+		signers = append(signers, &Signer{SignatureInfo: si, Signer: signer})
+	}
+	return signers, nil
+}
+
+// checkSignatures verifies the signatures of export files of batches which
+// ended at or after since. If sampleRate is less than 1, each file is only
+// checked with that probability. A file failing verification, or which can't
+// be read, is recorded in the result; an error is returned only if the files
+// can't be listed.
+func checkSignatures(ctx context.Context, metrics metrics.Exporter, deps signatureCheckDependencies, since time.Time, sampleRate float64) (*signatureCheckResult, error) {
+	logger := logging.FromContext(ctx)
+
+	files, err := deps.recentFiles(ctx, since)
+	if err != nil {
+		return nil, fmt.Errorf("listing export files: %w", err)
+	}
+
+	result := &signatureCheckResult{}
+	signers := make(map[int64][]*Signer)
+	fail := func(ef *model.ExportFile, format string, args ...interface{}) {
+		msg := fmt.Sprintf(format, args...)
+		logger.Errorw("Export signature check failed", "bucket", ef.BucketName, "filename", ef.Filename, "batch", ef.BatchID, "problem", msg)
+		result.Failed = append(result.Failed, ef.Filename)
+	}
+	for _, ef := range files {
+		if err := ctx.Err(); err != nil {
+			return result, nil
+		}
+		if sampleRate < 1 && deps.sample() >= sampleRate {
+			continue
+		}
+		result.Checked++
+
+		batchSigners, ok := signers[ef.BatchID]
+		if !ok {
+			eb, err := deps.lookupBatch(ctx, ef.BatchID)
+			if err != nil {
+				fail(ef, "looking up batch: %v", err)
+				continue
+			}
+			if batchSigners, err = deps.signers(ctx, eb); err != nil {
+				fail(ef, "loading signers: %v", err)
+				continue
+			}
+			signers[ef.BatchID] = batchSigners
+		}
+
+		blob, err := deps.getObject(ctx, ef.BucketName, ef.Filename)
+		if err != nil {
+			fail(ef, "reading file: %v", err)
+			continue
+		}
+		report, err := VerifyExportSignatures(blob, batchSigners)
+		if err != nil {
+			fail(ef, "%v", err)
+			continue
+		}
+		if !report.Valid() {
+			fail(ef, "%v", report.Problems)
+		}
+	}
+
+	metrics.WriteInt("export-signature-check-verified", true, result.Checked-len(result.Failed))
+	metrics.WriteInt("export-signature-check-failed", true, len(result.Failed))
+	return result, nil
+}
@@ -0,0 +1,167 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/export/model"
+	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"github.com/google/exposure-notifications-server/internal/storage"
+	"github.com/google/go-cmp/cmp"
+)
+
+// TestCheckSignatures verifies a set of export files in storage, some of which
+// are corrupted or signed with the wrong key.
+func TestCheckSignatures(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	metrics := serverenv.New(ctx).MetricsExporter(ctx)
+
+	newSigner := func(id string) *Signer {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &Signer{
+			SignatureInfo: &model.SignatureInfo{SigningKeyVersion: "1", SigningKeyID: id},
+			Signer:        key,
+		}
+	}
+	// Files may be signed with an expired key, but not with one which is not
+	// configured for their batch.
+	expired, current, rogue := newSigner("expired"), newSigner("current"), newSigner("rogue")
+	configured := []*Signer{expired, current}
+
+	blobstore, err := storage.NewMemory(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	batches := make(map[int64]*model.ExportBatch)
+	var files []*model.ExportFile
+	write := func(batchID int64, batchNum int, corrupt func([]byte) []byte, signers ...*Signer) {
+		eb, ok := batches[batchID]
+		if !ok {
+			eb = &model.ExportBatch{
+				BatchID:         batchID,
+				BucketName:      "bucket",
+				FilenameRoot:    "root",
+				StartTimestamp:  time.Date(2020, 5, 1, int(batchID), 0, 0, 0, time.UTC),
+				EndTimestamp:    time.Date(2020, 5, 1, int(batchID)+1, 0, 0, 0, time.UTC),
+				OutputRegion:    "US",
+				ProtocolVersion: model.ExportProtocolV1,
+			}
+			batches[batchID] = eb
+		}
+		exposures := []*publishmodel.Exposure{
+			{ExposureKey: []byte(fmt.Sprintf("key-%d-%d", batchID, batchNum)), IntervalNumber: 18, IntervalCount: 144, TransmissionRisk: 4},
+		}
+		blob, err := MarshalExportFile(eb, exposures, batchNum, 2, signers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ef := &model.ExportFile{BucketName: eb.BucketName, Filename: exportFilename(eb, batchNum), BatchID: batchID, BatchNum: batchNum, BatchSize: 2}
+		files = append(files, ef)
+		if corrupt == nil {
+			corrupt = func(b []byte) []byte { return b }
+		}
+		if blob = corrupt(blob); blob != nil {
+			if err := blobstore.CreateObject(ctx, ef.BucketName, ef.Filename, blob, false); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	tamper := func(blob []byte) []byte {
+		contents := unzipForTest(t, blob)
+		bin := append([]byte(nil), contents[exportBinaryName]...)
+		bin[len(bin)-1] ^= 0xff
+		contents[exportBinaryName] = bin
+		return zipForTest(t, contents)
+	}
+	missing := func([]byte) []byte { return nil }
+
+	write(1, 1, nil, expired)
+	write(1, 2, tamper, expired)
+	write(2, 1, nil, current, expired)
+	write(2, 2, nil, rogue)
+	write(3, 1, missing, current)
+	write(3, 2, func([]byte) []byte { return []byte("not a zip") }, current)
+
+	lookups := 0
+	deps := signatureCheckDependencies{
+		recentFiles: func(context.Context, time.Time) ([]*model.ExportFile, error) {
+			return files, nil
+		},
+		lookupBatch: func(_ context.Context, batchID int64) (*model.ExportBatch, error) {
+			lookups++
+			return batches[batchID], nil
+		},
+		signers: func(context.Context, *model.ExportBatch) ([]*Signer, error) {
+			return configured, nil
+		},
+		getObject: blobstore.GetObject,
+	}
+
+	name := func(batchID int64, batchNum int) string {
+		return exportFilename(batches[batchID], batchNum)
+	}
+
+	t.Run("full scan", func(t *testing.T) {
+		lookups = 0
+		got, err := checkSignatures(ctx, metrics, deps, time.Time{}, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := &signatureCheckResult{
+			Checked: 6,
+			Failed:  []string{name(1, 2), name(2, 2), name(3, 1), name(3, 2)},
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("checkSignatures() mismatch (-want +got):\n%s", diff)
+		}
+		if lookups != 3 {
+			t.Errorf("looked up batches %d times, want once per batch", lookups)
+		}
+	})
+
+	t.Run("sampled", func(t *testing.T) {
+		samples := []float64{0.1, 0.9, 0.2, 0.8, 0.3, 0.7}
+		sampled := deps
+		sampled.sample = func() float64 {
+			v := samples[0]
+			samples = samples[1:]
+			return v
+		}
+		got, err := checkSignatures(ctx, metrics, sampled, time.Time{}, 0.5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := &signatureCheckResult{
+			Checked: 3,
+			Failed:  []string{name(3, 1)},
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("checkSignatures() mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
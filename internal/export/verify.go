@@ -0,0 +1,251 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+
+	"github.com/google/exposure-notifications-server/internal/export/model"
+	"github.com/google/exposure-notifications-server/internal/pb/export"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// ExportExpectations describes the export file a batch should have produced.
+type ExportExpectations struct {
+	Batch     *model.ExportBatch
+	BatchNum  int
+	BatchSize int
+	NumKeys   int
+}
+
+// ExportReport is the result of verifying an export file. The file is valid if
+// there are no Problems.
+type ExportReport struct {
+	ProtocolVersion   string
+	Region            string
+	BatchNum          int
+	BatchSize         int
+	NumKeys           int
+	SignaturesChecked int
+	Problems          []string
+}
+
+// Valid reports whether the export file passed every check.
+func (r *ExportReport) Valid() bool {
+	return len(r.Problems) == 0
+}
+
+func (r *ExportReport) addProblem(format string, args ...interface{}) {
+	r.Problems = append(r.Problems, fmt.Sprintf(format, args...))
+}
+
+// VerifyExportFile opens an export archive and checks it against want: the
+// header and batch fields, the number of keys, and a signature from each of
+// the signers over export.bin. Problems with the contents are recorded in the
+// report; an error is returned only if the archive cannot be read at all.
+func VerifyExportFile(blob []byte, want *ExportExpectations, signers []*Signer) (*ExportReport, error) {
+	files, err := readExportArchive(blob)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ExportReport{}
+	bin, ok := files[exportBinaryName]
+	if !ok {
+		report.addProblem("missing %v", exportBinaryName)
+		return report, nil
+	}
+	verifyContents(report, bin, want)
+
+	sig, ok := files[exportSignatureName]
+	if !ok {
+		report.addProblem("missing %v", exportSignatureName)
+		return report, nil
+	}
+	verifySignatures(report, bin, sig, want, signers)
+	return report, nil
+}
+
+// VerifyExportSignatures checks that an export file, already in storage, is
+// signed and that each of its signatures verifies against the public key of
+// the matching signer. Unlike VerifyExportFile it has no expectations of the
+// contents, and signers may include keys the file was not signed with, e.g.
+// keys which had expired when it was written.
+func VerifyExportSignatures(blob []byte, signers []*Signer) (*ExportReport, error) {
+	files, err := readExportArchive(blob)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ExportReport{}
+	bin, ok := files[exportBinaryName]
+	if !ok {
+		report.addProblem("missing %v", exportBinaryName)
+		return report, nil
+	}
+	sig, ok := files[exportSignatureName]
+	if !ok {
+		report.addProblem("missing %v", exportSignatureName)
+		return report, nil
+	}
+	var teksl export.TEKSignatureList
+	if err := proto.Unmarshal(sig, &teksl); err != nil {
+		report.addProblem("unmarshalling %v: %v", exportSignatureName, err)
+		return report, nil
+	}
+	if len(teksl.Signatures) == 0 {
+		report.addProblem("no signatures")
+		return report, nil
+	}
+
+	digest := sha256.Sum256(bin)
+	for _, teks := range teksl.Signatures {
+		si := teks.GetSignatureInfo()
+		var signer *Signer
+		for _, s := range signers {
+			if proto.Equal(createSignatureInfo(s.SignatureInfo), si) {
+				signer = s
+				break
+			}
+		}
+		if signer == nil {
+			report.addProblem("signature by unknown key %v version %v", si.GetVerificationKeyId(), si.GetVerificationKeyVersion())
+			continue
+		}
+		report.SignaturesChecked++
+		if err := verifySignature(signer, digest[:], teks.Signature); err != nil {
+			report.addProblem("signature for key %v: %v", si.GetVerificationKeyId(), err)
+		}
+	}
+	return report, nil
+}
+
+// readExportArchive returns the contents of each file in an export archive.
+func readExportArchive(blob []byte) (map[string][]byte, error) {
+	zr, err := zip.NewReader(bytes.NewReader(blob), int64(len(blob)))
+	if err != nil {
+		return nil, fmt.Errorf("can't read archive: %w", err)
+	}
+	files := make(map[string][]byte, len(zr.File))
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("opening %v: %w", f.Name, err)
+		}
+		b, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("reading %v: %w", f.Name, err)
+		}
+		files[f.Name] = b
+	}
+	return files, nil
+}
+
+func verifyContents(report *ExportReport, bin []byte, want *ExportExpectations) {
+	version, err := exportFileVersion(bin)
+	if err != nil {
+		report.addProblem("header: %v", err)
+		return
+	}
+	report.ProtocolVersion = version
+	if wantVersion := want.Batch.ProtocolVersion; wantVersion != "" && version != wantVersion {
+		report.addProblem("protocol version %v, want %v", version, wantVersion)
+	}
+
+	var teke export.TemporaryExposureKeyExport
+	if err := proto.Unmarshal(bin[fixedHeaderWidth:], &teke); err != nil {
+		report.addProblem("unmarshalling %v: %v", exportBinaryName, err)
+		return
+	}
+	report.Region = teke.GetRegion()
+	report.BatchNum = int(teke.GetBatchNum())
+	report.BatchSize = int(teke.GetBatchSize())
+	report.NumKeys = len(teke.Keys)
+
+	if report.Region != want.Batch.OutputRegion {
+		report.addProblem("region %q, want %q", report.Region, want.Batch.OutputRegion)
+	}
+	if got, start := teke.GetStartTimestamp(), uint64(want.Batch.StartTimestamp.Unix()); got != start {
+		report.addProblem("start timestamp %d, want %d", got, start)
+	}
+	if got, end := teke.GetEndTimestamp(), uint64(want.Batch.EndTimestamp.Unix()); got != end {
+		report.addProblem("end timestamp %d, want %d", got, end)
+	}
+	if report.BatchNum != want.BatchNum || report.BatchSize != want.BatchSize {
+		report.addProblem("batch %d of %d, want %d of %d", report.BatchNum, report.BatchSize, want.BatchNum, want.BatchSize)
+	}
+	if report.NumKeys != want.NumKeys {
+		report.addProblem("%d keys, want %d", report.NumKeys, want.NumKeys)
+	}
+}
+
+func verifySignatures(report *ExportReport, bin, sig []byte, want *ExportExpectations, signers []*Signer) {
+	var teksl export.TEKSignatureList
+	if err := proto.Unmarshal(sig, &teksl); err != nil {
+		report.addProblem("unmarshalling %v: %v", exportSignatureName, err)
+		return
+	}
+
+	digest := sha256.Sum256(bin)
+	for _, s := range signers {
+		si := createSignatureInfo(s.SignatureInfo)
+		var found *export.TEKSignature
+		for _, teks := range teksl.Signatures {
+			if proto.Equal(teks.SignatureInfo, si) {
+				found = teks
+				break
+			}
+		}
+		if found == nil {
+			report.addProblem("no signature for key %v version %v", si.GetVerificationKeyId(), si.GetVerificationKeyVersion())
+			continue
+		}
+		report.SignaturesChecked++
+
+		if int(found.GetBatchNum()) != want.BatchNum || int(found.GetBatchSize()) != want.BatchSize {
+			report.addProblem("signature for key %v: batch %d of %d, want %d of %d", si.GetVerificationKeyId(),
+				found.GetBatchNum(), found.GetBatchSize(), want.BatchNum, want.BatchSize)
+		}
+		if err := verifySignature(s, digest[:], found.Signature); err != nil {
+			report.addProblem("signature for key %v: %v", si.GetVerificationKeyId(), err)
+		}
+	}
+}
+
+// verifySignature checks an ASN.1 ECDSA signature over digest against the
+// public key of s.
+func verifySignature(s *Signer, digest, sig []byte) error {
+	pub, ok := s.Signer.Public().(*ecdsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("unsupported public key type %T", s.Signer.Public())
+	}
+	var esig struct{ R, S *big.Int }
+	if _, err := asn1.Unmarshal(sig, &esig); err != nil {
+		return err
+	}
+	if !ecdsa.Verify(pub, digest, esig.R, esig.S) {
+		return fmt.Errorf("does not verify")
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/export/model"
+	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
+)
+
+func TestVerifyExportFile(t *testing.T) {
+	t.Parallel()
+
+	newSigner := func(id string) *Signer {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &Signer{
+			SignatureInfo: &model.SignatureInfo{SigningKeyVersion: "1", SigningKeyID: id},
+			Signer:        key,
+		}
+	}
+	signer := newSigner("310")
+
+	batch := &model.ExportBatch{
+		BatchID:         7,
+		StartTimestamp:  time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC),
+		EndTimestamp:    time.Date(2020, 5, 1, 1, 0, 0, 0, time.UTC),
+		OutputRegion:    "US",
+		ProtocolVersion: model.ExportProtocolV1,
+	}
+	exposures := []*publishmodel.Exposure{
+		{ExposureKey: []byte("ABC"), IntervalNumber: 18, IntervalCount: 144, TransmissionRisk: 8},
+		{ExposureKey: []byte("DEF"), IntervalNumber: 18, IntervalCount: 144, TransmissionRisk: 4},
+	}
+	blob, err := MarshalExportFile(batch, exposures, 1, 2, []*Signer{signer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &ExportExpectations{Batch: batch, BatchNum: 1, BatchSize: 2, NumKeys: len(exposures)}
+
+	t.Run("valid", func(t *testing.T) {
+		t.Parallel()
+
+		report, err := VerifyExportFile(blob, want, []*Signer{signer})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !report.Valid() {
+			t.Errorf("unexpected problems: %v", report.Problems)
+		}
+		if report.NumKeys != 2 || report.SignaturesChecked != 1 || report.ProtocolVersion != model.ExportProtocolV1 {
+			t.Errorf("unexpected report: %+v", report)
+		}
+	})
+
+	corrupt := func(name string, mutate func([]byte) []byte) []byte {
+		files := unzipForTest(t, blob)
+		files[name] = mutate(files[name])
+		return zipForTest(t, files)
+	}
+
+	cases := []struct {
+		name    string
+		blob    []byte
+		want    *ExportExpectations
+		signers []*Signer
+	}{
+		{
+			name: "tampered contents",
+			blob: corrupt(exportBinaryName, func(b []byte) []byte {
+				b = append([]byte(nil), b...)
+				b[len(b)-1] ^= 0xff
+				return b
+			}),
+			want:    want,
+			signers: []*Signer{signer},
+		},
+		{
+			name: "bad header",
+			blob: corrupt(exportBinaryName, func(b []byte) []byte {
+				return append([]byte("EK Export v9    "), b[fixedHeaderWidth:]...)
+			}),
+			want:    want,
+			signers: []*Signer{signer},
+		},
+		{
+			name:    "missing signature",
+			blob:    corrupt(exportSignatureName, func([]byte) []byte { return nil }),
+			want:    want,
+			signers: []*Signer{signer},
+		},
+		{
+			name:    "wrong key count",
+			blob:    blob,
+			want:    &ExportExpectations{Batch: batch, BatchNum: 1, BatchSize: 2, NumKeys: 3},
+			signers: []*Signer{signer},
+		},
+		{
+			name:    "wrong batch size",
+			blob:    blob,
+			want:    &ExportExpectations{Batch: batch, BatchNum: 1, BatchSize: 1, NumKeys: 2},
+			signers: []*Signer{signer},
+		},
+		{
+			name:    "unexpected signer",
+			blob:    blob,
+			want:    want,
+			signers: []*Signer{signer, newSigner("311")},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			report, err := VerifyExportFile(tc.blob, tc.want, tc.signers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Valid() {
+				t.Errorf("expected problems, got valid report %+v", report)
+			}
+		})
+	}
+
+	t.Run("not an archive", func(t *testing.T) {
+		t.Parallel()
+
+		if _, err := VerifyExportFile([]byte("garbage"), want, nil); err == nil {
+			t.Error("expected error")
+		}
+	})
+}
+
+func TestVerifyEmptyExportFile(t *testing.T) {
+	t.Parallel()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := &Signer{
+		SignatureInfo: &model.SignatureInfo{SigningKeyVersion: "1", SigningKeyID: "310"},
+		Signer:        key,
+	}
+	batch := &model.ExportBatch{
+		BatchID:         8,
+		StartTimestamp:  time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC),
+		EndTimestamp:    time.Date(2020, 5, 1, 1, 0, 0, 0, time.UTC),
+		OutputRegion:    "US",
+		ProtocolVersion: model.ExportProtocolV1,
+	}
+
+	// An empty batch is written as file 1 of 1 with no keys.
+	blob, err := MarshalExportFile(batch, nil, 1, 1, []*Signer{signer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := VerifyExportFile(blob, &ExportExpectations{Batch: batch, BatchNum: 1, BatchSize: 1, NumKeys: 0}, []*Signer{signer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Valid() {
+		t.Errorf("unexpected problems: %v", report.Problems)
+	}
+	if report.NumKeys != 0 || report.SignaturesChecked != 1 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+}
+
+// zipForTest archives the export binary and signature files which are present
+// in files.
+func zipForTest(t *testing.T, files map[string][]byte) []byte {
+	t.Helper()
+
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for _, n := range []string{exportBinaryName, exportSignatureName} {
+		if _, ok := files[n]; !ok {
+			continue
+		}
+		zf, err := zw.Create(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := zf.Write(files[n]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
@@ -33,6 +33,7 @@ import (
 	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
 
 	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/storage"
 	"github.com/google/exposure-notifications-server/internal/util"
 
 	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1alpha1"
@@ -93,41 +94,60 @@ func (s *Server) WorkerHandler(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) exportBatch(ctx context.Context, eb *model.ExportBatch, emitIndexForEmptyBatch bool) error {
 	logger := logging.FromContext(ctx)
-	logger.Infof("Processing export batch %d (root: %q, region: %s), max records per file %d", eb.BatchID, eb.FilenameRoot, eb.OutputRegion, s.config.MaxRecords)
+	logger.Infof("Processing export batch %d (root: %q, region: %s), max records per file %d", eb.BatchID, eb.FilenameRoot, eb.OutputRegion, maxKeysPerBatch(eb, s.config.MaxRecords))
 
 	criteria := publishdb.IterateExposuresCriteria{
 		SinceTimestamp:      eb.StartTimestamp,
 		UntilTimestamp:      eb.EndTimestamp,
 		IncludeRegions:      eb.EffectiveInputRegions(),
+		NotExpiredAt:        time.Now(),
 		OnlyLocalProvenance: false, // include federated ids
 	}
 
+	// A delta batch only exports keys newer than those already exported, which
+	// may include keys created before the batch started.
+	var watermarks map[string]time.Time
+	if eb.IsDelta() {
+		var err error
+		if watermarks, err = s.exportdb.ExportWatermarks(ctx, eb.ConfigID); err != nil {
+			return fmt.Errorf("loading export watermarks: %w", err)
+		}
+		criteria.SinceTimestamp = deltaSince(eb.StartTimestamp, criteria.IncludeRegions, watermarks)
+	}
+
 	// Build up groups of exposures in memory. We need to use memory so we can
 	// determine the total number of groups (which is embedded in each export
 	// file). This technique avoids SELECT COUNT which would lock the database
 	// slowing new uploads.
-	var groups [][]*publishmodel.Exposure
 	var exposures []*publishmodel.Exposure
 
-	_, err := s.publishdb.IterateExposures(ctx, criteria, func(exp *publishmodel.Exposure) error {
+	err := s.iterateExposures(ctx, criteria, func(exp *publishmodel.Exposure) error {
 		exposures = append(exposures, exp)
-		if len(exposures) == s.config.MaxRecords {
-			groups = append(groups, exposures)
-			exposures = nil
-		}
 		return nil
 	})
 
 	if err != nil {
 		return fmt.Errorf("iterating exposures: %w", err)
 	}
-	// Create a group for any remaining keys.
-	if len(exposures) > 0 {
-		groups = append(groups, exposures)
+	var preset *model.ExportPreset
+	if eb.Preset != "" {
+		if preset, err = s.exportdb.GetExportPreset(ctx, eb.Preset); err != nil {
+			return fmt.Errorf("loading export preset %q: %w", eb.Preset, err)
+		}
+	}
+	exposures = batchExposures(exposures, preset, eb.IsDelta(), criteria.IncludeRegions, watermarks)
+	if eb.IsDelta() {
+		watermarks = advanceWatermarks(exposures, criteria.IncludeRegions, watermarks)
+		logger.Infof("Delta export batch %d has %d new keys", eb.BatchID, len(exposures))
 	}
+	groups := splitExposures(exposures, maxKeysPerBatch(eb, s.config.MaxRecords))
 
 	if len(groups) == 0 {
 		logger.Infof("No records for export batch %d", eb.BatchID)
+		if s.config.EmitEmptyBatches {
+			// A single empty group produces one valid, signed file with no keys.
+			groups = append(groups, nil)
+		}
 	}
 
 	exposures, err = ensureMinNumExposures(exposures, eb.OutputRegion, s.config.MinRecords, s.config.PaddingRange)
@@ -175,13 +195,128 @@ func (s *Server) exportBatch(ctx context.Context, eb *model.ExportBatch, emitInd
 	}
 
 	// Write the files records in database and complete the batch.
-	if err := s.exportdb.FinalizeBatch(ctx, eb, objectNames, batchSize); err != nil {
+	if err := s.exportdb.FinalizeBatch(ctx, eb, objectNames, batchSize, watermarks); err != nil {
 		return fmt.Errorf("completing batch: %w", err)
 	}
 	logger.Infof("Batch %d completed", eb.BatchID)
 	return nil
 }
 
+// batchExposures returns the exposures a batch exports: those which pass the
+// preset's filters, if it has a preset, and for a delta batch, those not yet
+// exported according to watermarks. The batcher counts the same exposures to
+// enforce MinKeysPerWindow.
+func batchExposures(exposures []*publishmodel.Exposure, preset *model.ExportPreset, delta bool, regions []string, watermarks map[string]time.Time) []*publishmodel.Exposure {
+	if preset != nil {
+		exposures = presetExposures(exposures, preset)
+	}
+	if delta {
+		exposures = deltaExposures(exposures, regions, watermarks)
+	}
+	return exposures
+}
+
+// presetExposures returns the exposures which pass the preset's filters.
+func presetExposures(exposures []*publishmodel.Exposure, preset *model.ExportPreset) []*publishmodel.Exposure {
+	var kept []*publishmodel.Exposure
+	for _, exp := range exposures {
+		if preset.VerifiedOnly && exp.Unverified {
+			continue
+		}
+		if exp.TransmissionRisk < preset.MinTransmissionRisk {
+			continue
+		}
+		kept = append(kept, exp)
+	}
+	return kept
+}
+
+// deltaSince returns the earliest time from which a delta batch starting at
+// start may have keys to export. A key created before start which has not been
+// exported in one of its regions is exported late, rather than never.
+func deltaSince(start time.Time, regions []string, watermarks map[string]time.Time) time.Time {
+	for _, region := range regions {
+		wm, ok := watermarks[region]
+		if !ok {
+			// Never exported, so only the batch's own period is exported.
+			continue
+		}
+		if wm.Before(start) {
+			start = wm
+		}
+	}
+	return start
+}
+
+// deltaExposures returns the exposures created after the watermark of at least
+// one of the given input regions they are in. Regions without a watermark have
+// not been exported, so all of their exposures are returned.
+func deltaExposures(exposures []*publishmodel.Exposure, regions []string, watermarks map[string]time.Time) []*publishmodel.Exposure {
+	var delta []*publishmodel.Exposure
+	for _, exp := range exposures {
+		for _, region := range exportedRegions(exp, regions) {
+			if exp.CreatedAt.After(watermarks[region]) {
+				delta = append(delta, exp)
+				break
+			}
+		}
+	}
+	return delta
+}
+
+// advanceWatermarks returns the watermarks after exporting exposures: for each
+// input region, the latest CreatedAt exported in it. Regions with nothing
+// exported are left out, so their watermarks are not changed.
+func advanceWatermarks(exposures []*publishmodel.Exposure, regions []string, watermarks map[string]time.Time) map[string]time.Time {
+	advanced := make(map[string]time.Time)
+	for _, exp := range exposures {
+		for _, region := range exportedRegions(exp, regions) {
+			if exp.CreatedAt.After(watermarks[region]) && exp.CreatedAt.After(advanced[region]) {
+				advanced[region] = exp.CreatedAt
+			}
+		}
+	}
+	return advanced
+}
+
+// exportedRegions returns the input regions the exposure is exported for.
+func exportedRegions(exp *publishmodel.Exposure, regions []string) []string {
+	var in []string
+	for _, region := range regions {
+		for _, r := range exp.Regions {
+			if r == region {
+				in = append(in, region)
+				break
+			}
+		}
+	}
+	return in
+}
+
+// maxKeysPerBatch returns the maximum number of keys in a single export file
+// for the batch, falling back to def if the batch does not set one.
+func maxKeysPerBatch(eb *model.ExportBatch, def int) int {
+	if eb.MaxKeysPerBatch > 0 {
+		return eb.MaxKeysPerBatch
+	}
+	return def
+}
+
+// splitExposures splits exposures into consecutive groups of at most maxKeys
+// each, one group per export file.
+func splitExposures(exposures []*publishmodel.Exposure, maxKeys int) [][]*publishmodel.Exposure {
+	var groups [][]*publishmodel.Exposure
+	for len(exposures) > maxKeys {
+		groups = append(groups, exposures[:maxKeys:maxKeys])
+		exposures = exposures[maxKeys:]
+	}
+	// Create a group for any remaining keys.
+	if len(exposures) > 0 {
+		groups = append(groups, exposures)
+	}
+	return groups
+}
+
 type createFileInfo struct {
 	exposures      []*publishmodel.Exposure
 	exportBatch    *model.ExportBatch
@@ -207,17 +342,48 @@ func (s *Server) createFile(ctx context.Context, cfi createFileInfo) (string, er
 	if err != nil {
 		return "", fmt.Errorf("marshalling export file: %w", err)
 	}
+	s.selfCheck(ctx, data, &ExportExpectations{
+		Batch:     cfi.exportBatch,
+		BatchNum:  cfi.batchNum,
+		BatchSize: cfi.batchSize,
+		NumKeys:   len(cfi.exposures),
+	}, signers)
 
 	objectName := exportFilename(cfi.exportBatch, cfi.batchNum)
 	logger.Infof("Created file %v, signed with %v keys", objectName, len(signers))
 	ctx, cancel := context.WithTimeout(ctx, blobOperationTimeout)
 	defer cancel()
-	if err := s.env.Blobstore().CreateObject(ctx, cfi.exportBatch.BucketName, objectName, data, false); err != nil {
+	metadata := &storage.ObjectMetadata{
+		ContentType:   s.config.ContentType,
+		CacheControl:  s.config.CacheControl,
+		RetentionDays: s.config.RetentionDays,
+		BatchTag:      s.batchTag(cfi.exportBatch, cfi.batchNum, time.Now()),
+	}
+	if err := s.env.Blobstore().PutObject(ctx, cfi.exportBatch.BucketName, objectName, data, metadata); err != nil {
 		return "", fmt.Errorf("creating file %s in bucket %s: %w", objectName, cfi.exportBatch.BucketName, err)
 	}
 	return objectName, nil
 }
 
+// selfCheck verifies a freshly produced export file, logging and counting any
+// problems. The file is still written, since it was produced by the same code
+// that clients already accept; the check exists to surface regressions.
+func (s *Server) selfCheck(ctx context.Context, data []byte, want *ExportExpectations, signers []*Signer) {
+	logger := logging.FromContext(ctx)
+	metrics := s.env.MetricsExporter(ctx)
+
+	report, err := VerifyExportFile(data, want, signers)
+	if err != nil {
+		logger.Errorf("Export self-check for batch %d file %d failed: %v", want.Batch.BatchID, want.BatchNum, err)
+		metrics.WriteInt("export-selfcheck-failed", true, 1)
+		return
+	}
+	if !report.Valid() {
+		logger.Errorw("Export self-check found problems", "batch", want.Batch.BatchID, "batchNum", want.BatchNum, "problems", report.Problems)
+		metrics.WriteInt("export-selfcheck-failed", true, 1)
+	}
+}
+
 // retryingCreateIndex create the index file. The index file includes _all_
 // batches for an ExportConfig, so multiple workers may be racing to update it.
 // We use a lock to make them line up after one another.
@@ -284,7 +450,12 @@ func (s *Server) createIndex(ctx context.Context, eb *model.ExportBatch, newObje
 	indexObjectName := exportIndexFilename(eb)
 	ctx, cancel := context.WithTimeout(ctx, blobOperationTimeout)
 	defer cancel()
-	if err := s.env.Blobstore().CreateObject(ctx, eb.BucketName, indexObjectName, data, false); err != nil {
+	metadata := &storage.ObjectMetadata{
+		ContentType:  s.config.IndexContentType,
+		CacheControl: s.config.CacheControl,
+		BatchTag:     s.batchTag(eb, 0, time.Now()),
+	}
+	if err := s.env.Blobstore().PutObject(ctx, eb.BucketName, indexObjectName, data, metadata); err != nil {
 		return "", 0, fmt.Errorf("creating file %s in bucket %s: %w", indexObjectName, eb.BucketName, err)
 	}
 	return indexObjectName, len(objects), nil
@@ -18,9 +18,12 @@ import (
 	"crypto/rand"
 	"fmt"
 	"testing"
+	"time"
 
+	exportmodel "github.com/google/exposure-notifications-server/internal/export/model"
 	"github.com/google/exposure-notifications-server/internal/publish/model"
 	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1alpha1"
+	"github.com/google/go-cmp/cmp"
 )
 
 func TestRandomInt(t *testing.T) {
@@ -116,3 +119,181 @@ func TestEnsureMinExposures(t *testing.T) {
 		}
 	}
 }
+
+func TestSplitExposuresMaxKeysPerBatch(t *testing.T) {
+	exposures := make([]*model.Exposure, 0)
+	for i := 0; i < 15; i++ {
+		exposures = addExposure(t, exposures, int32(123456+i), 144, 1)
+	}
+	eb := &exportmodel.ExportBatch{
+		StartTimestamp:  time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC),
+		EndTimestamp:    time.Date(2020, 5, 1, 1, 0, 0, 0, time.UTC),
+		OutputRegion:    "US",
+		MaxKeysPerBatch: 10,
+	}
+
+	groups := splitExposures(exposures, maxKeysPerBatch(eb, 30000))
+	if got, want := len(groups), 2; got != want {
+		t.Fatalf("wrong number of batches, got %d, want %d", got, want)
+	}
+
+	wantKeys := []int{10, 5}
+	for i, group := range groups {
+		blob, err := MarshalExportFile(eb, group, i+1, len(groups), nil)
+		if err != nil {
+			t.Fatalf("MarshalExportFile: %v", err)
+		}
+		got, err := UnmarshalExportFile(blob)
+		if err != nil {
+			t.Fatalf("UnmarshalExportFile: %v", err)
+		}
+		if got.GetBatchNum() != int32(i+1) || got.GetBatchSize() != 2 {
+			t.Errorf("batch %d: got batch_num=%d batch_size=%d, want batch_num=%d batch_size=2", i, got.GetBatchNum(), got.GetBatchSize(), i+1)
+		}
+		if len(got.Keys) != wantKeys[i] {
+			t.Errorf("batch %d: got %d keys, want %d", i, len(got.Keys), wantKeys[i])
+		}
+	}
+}
+
+func TestMaxKeysPerBatch(t *testing.T) {
+	if got := maxKeysPerBatch(&exportmodel.ExportBatch{}, 30000); got != 30000 {
+		t.Errorf("unset MaxKeysPerBatch: got %d, want 30000", got)
+	}
+	if got := maxKeysPerBatch(&exportmodel.ExportBatch{MaxKeysPerBatch: 10}, 30000); got != 10 {
+		t.Errorf("MaxKeysPerBatch=10: got %d, want 10", got)
+	}
+}
+
+// TestDeltaExports exports the same overlapping range twice, with new keys
+// arriving in between, and checks that the second export only has the new keys.
+func TestDeltaExports(t *testing.T) {
+	start := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	regions := []string{"US", "CA"}
+	exposure := func(key string, createdAt time.Duration, regions ...string) *model.Exposure {
+		return &model.Exposure{ExposureKey: []byte(key), Regions: regions, CreatedAt: start.Add(createdAt)}
+	}
+	keys := func(exposures []*model.Exposure) []string {
+		var keys []string
+		for _, e := range exposures {
+			keys = append(keys, string(e.ExposureKey))
+		}
+		return keys
+	}
+
+	first := []*model.Exposure{
+		exposure("aaa", time.Hour, "US"),
+		exposure("bbb", 2*time.Hour, "US", "CA"),
+	}
+	watermarks := map[string]time.Time{}
+	if got := deltaSince(start, regions, watermarks); !got.Equal(start) {
+		t.Errorf("deltaSince with no watermarks: got %v, want %v", got, start)
+	}
+	exported := deltaExposures(first, regions, watermarks)
+	if diff := cmp.Diff([]string{"aaa", "bbb"}, keys(exported)); diff != "" {
+		t.Errorf("first export mismatch (-want +got):\n%s", diff)
+	}
+	watermarks = advanceWatermarks(exported, regions, watermarks)
+	want := map[string]time.Time{"US": start.Add(2 * time.Hour), "CA": start.Add(2 * time.Hour)}
+	if diff := cmp.Diff(want, watermarks); diff != "" {
+		t.Errorf("watermarks mismatch (-want +got):\n%s", diff)
+	}
+
+	// The second batch starts later, but a key created before it started which
+	// was not exported is still new.
+	second := append(first,
+		exposure("ccc", 3*time.Hour, "US"),
+		exposure("ddd", 2*time.Hour, "MX"),
+		exposure("eee", 4*time.Hour, "CA", "MX"))
+	if got, want := deltaSince(start.Add(3*time.Hour), regions, watermarks), start.Add(2*time.Hour); !got.Equal(want) {
+		t.Errorf("deltaSince: got %v, want %v", got, want)
+	}
+	exported = deltaExposures(second, regions, watermarks)
+	if diff := cmp.Diff([]string{"ccc", "eee"}, keys(exported)); diff != "" {
+		t.Errorf("second export mismatch (-want +got):\n%s", diff)
+	}
+	want = map[string]time.Time{"US": start.Add(3 * time.Hour), "CA": start.Add(4 * time.Hour)}
+	if diff := cmp.Diff(want, advanceWatermarks(exported, regions, watermarks)); diff != "" {
+		t.Errorf("advanced watermarks mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// TestPresetExposures filters one region's keys with two presets, as for two
+// client apps served from the same region.
+func TestPresetExposures(t *testing.T) {
+	exposures := []*model.Exposure{
+		{ExposureKey: []byte("aaa"), TransmissionRisk: 2},
+		{ExposureKey: []byte("bbb"), TransmissionRisk: 6, Unverified: true},
+		{ExposureKey: []byte("ccc"), TransmissionRisk: 6},
+		{ExposureKey: []byte("ddd"), TransmissionRisk: 1, Unverified: true},
+	}
+	keys := func(exposures []*model.Exposure) []string {
+		var keys []string
+		for _, e := range exposures {
+			keys = append(keys, string(e.ExposureKey))
+		}
+		return keys
+	}
+
+	cases := []struct {
+		preset *exportmodel.ExportPreset
+		want   []string
+	}{
+		{
+			preset: &exportmodel.ExportPreset{Name: "app-a", VerifiedOnly: true},
+			want:   []string{"aaa", "ccc"},
+		},
+		{
+			preset: &exportmodel.ExportPreset{Name: "app-b"},
+			want:   []string{"aaa", "bbb", "ccc", "ddd"},
+		},
+		{
+			preset: &exportmodel.ExportPreset{Name: "high-risk", MinTransmissionRisk: 5},
+			want:   []string{"bbb", "ccc"},
+		},
+	}
+	for _, c := range cases {
+		if diff := cmp.Diff(c.want, keys(presetExposures(exposures, c.preset))); diff != "" {
+			t.Errorf("preset %s mismatch (-want +got):\n%s", c.preset.Name, diff)
+		}
+	}
+}
+
+// TestBatchExposures checks the exposures a batch exports, which the batcher
+// also counts against MinKeysPerWindow, with and without a preset and delta.
+func TestBatchExposures(t *testing.T) {
+	start := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	regions := []string{"US"}
+	exposures := []*model.Exposure{
+		{ExposureKey: []byte("aaa"), Regions: regions, CreatedAt: start.Add(time.Hour)},
+		{ExposureKey: []byte("bbb"), Regions: regions, CreatedAt: start.Add(2 * time.Hour), Unverified: true},
+		{ExposureKey: []byte("ccc"), Regions: regions, CreatedAt: start.Add(3 * time.Hour)},
+	}
+	watermarks := map[string]time.Time{"US": start.Add(time.Hour)}
+	verified := &exportmodel.ExportPreset{Name: "verified", VerifiedOnly: true}
+	keys := func(exposures []*model.Exposure) []string {
+		var keys []string
+		for _, e := range exposures {
+			keys = append(keys, string(e.ExposureKey))
+		}
+		return keys
+	}
+
+	cases := []struct {
+		name   string
+		preset *exportmodel.ExportPreset
+		delta  bool
+		want   []string
+	}{
+		{name: "full", want: []string{"aaa", "bbb", "ccc"}},
+		{name: "preset", preset: verified, want: []string{"aaa", "ccc"}},
+		{name: "delta", delta: true, want: []string{"bbb", "ccc"}},
+		{name: "preset delta", preset: verified, delta: true, want: []string{"ccc"}},
+	}
+	for _, c := range cases {
+		got := batchExposures(exposures, c.preset, c.delta, regions, watermarks)
+		if diff := cmp.Diff(c.want, keys(got)); diff != "" {
+			t.Errorf("%s mismatch (-want +got):\n%s", c.name, diff)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federationin
+
+import (
+	"strings"
+
+	"google.golang.org/grpc/encoding"
+
+	// Register the gzip and zstd codecs, so that they can be negotiated with partners.
+	_ "github.com/google/exposure-notifications-server/internal/zstd"
+	_ "google.golang.org/grpc/encoding/gzip"
+)
+
+// selectCompressor returns the first codec in preferences which is registered with gRPC, or
+// encoding.Identity if there is none. The partner's preferences are used if it has any, otherwise
+// the defaults. The server compresses its response with the codec the request was sent with, so
+// this selects the compression in both directions.
+func selectCompressor(partner, defaults []string) string {
+	preferences := partner
+	if len(preferences) == 0 {
+		preferences = defaults
+	}
+	for _, name := range preferences {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == encoding.Identity {
+			return encoding.Identity
+		}
+		if encoding.GetCompressor(name) != nil {
+			return name
+		}
+	}
+	return encoding.Identity
+}
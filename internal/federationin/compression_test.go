@@ -0,0 +1,125 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federationin
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/google/exposure-notifications-server/internal/pb"
+
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/protobuf/proto"
+)
+
+// nopCompressor is a codec registered only in tests, so that selection among several registered
+// codecs can be tested.
+type nopCompressor struct{}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+func (nopCompressor) Compress(w io.Writer) (io.WriteCloser, error) { return nopWriteCloser{w}, nil }
+func (nopCompressor) Decompress(r io.Reader) (io.Reader, error)    { return r, nil }
+func (nopCompressor) Name() string                                 { return "test-nop" }
+
+func init() {
+	encoding.RegisterCompressor(nopCompressor{})
+}
+
+func TestSelectCompressor(t *testing.T) {
+	testCases := []struct {
+		name     string
+		partner  []string
+		defaults []string
+		want     string
+	}{
+		{name: "none", want: encoding.Identity},
+		{name: "default", defaults: []string{"gzip"}, want: "gzip"},
+		{name: "partner preferred over default", partner: []string{"test-nop", "gzip"}, defaults: []string{"gzip"}, want: "test-nop"},
+		{name: "first registered", partner: []string{"br", " GZIP "}, want: "gzip"},
+		{name: "zstd", partner: []string{"zstd", "gzip"}, defaults: []string{"gzip"}, want: "zstd"},
+		{name: "none registered", partner: []string{"br", "lz4"}, defaults: []string{"gzip"}, want: encoding.Identity},
+		{name: "explicit identity", partner: []string{"identity", "gzip"}, want: encoding.Identity},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := selectCompressor(tc.partner, tc.defaults); got != tc.want {
+				t.Errorf("selectCompressor(%q, %q) = %q, want %q", tc.partner, tc.defaults, got, tc.want)
+			}
+		})
+	}
+}
+
+// BenchmarkCompression compares the registered codecs, compressing a fetch response of 100k keys with
+// each and reporting the compressed size.
+func BenchmarkCompression(b *testing.B) {
+	response := &pb.FederationFetchResponse{}
+	for r := 0; r < 10; r++ {
+		cti := &pb.ContactTracingInfo{TransmissionRisk: int32(r % 8)}
+		for i := 0; i < 10000; i++ {
+			key := make([]byte, 16)
+			copy(key, fmt.Sprintf("%08d%08d", r, i*7919))
+			cti.ExposureKeys = append(cti.ExposureKeys, &pb.ExposureKey{ExposureKey: key, IntervalNumber: int32(2650000 + i%14*144), IntervalCount: 144})
+		}
+		response.Response = append(response.Response, &pb.ContactTracingResponse{
+			RegionIdentifiers:  []string{fmt.Sprintf("R%d", r)},
+			ContactTracingInfo: []*pb.ContactTracingInfo{cti},
+		})
+	}
+	msg, err := proto.Marshal(response)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, name := range []string{"gzip", "zstd"} {
+		b.Run(name, func(b *testing.B) {
+			compressor := encoding.GetCompressor(name)
+			if compressor == nil {
+				b.Fatalf("%s is not registered", name)
+			}
+			var buf bytes.Buffer
+			b.SetBytes(int64(len(msg)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				buf.Reset()
+				w, err := compressor.Compress(&buf)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := w.Write(msg); err != nil {
+					b.Fatal(err)
+				}
+				if err := w.Close(); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(len(msg))/float64(buf.Len()), "ratio")
+			r, err := compressor.Decompress(&buf)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := io.Copy(ioutil.Discard, r); err != nil {
+				b.Fatal(err)
+			}
+		})
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"regexp"
 	"time"
 
+	"github.com/google/exposure-notifications-server/internal/blocklist"
 	"github.com/google/exposure-notifications-server/internal/database"
 	"github.com/google/exposure-notifications-server/internal/secrets"
 	"github.com/google/exposure-notifications-server/internal/setup"
@@ -36,11 +37,13 @@ var (
 )
 
 // Compile-time check to assert this config matches requirements.
+var _ setup.BlocklistConfigProvider = (*Config)(nil)
 var _ setup.DatabaseConfigProvider = (*Config)(nil)
 var _ setup.SecretManagerConfigProvider = (*Config)(nil)
 
 // Config is the configuration for federation-pull components (data pulled from other servers).
 type Config struct {
+	Blocklist     blocklist.Config
 	Database      database.Config
 	SecretManager secrets.Config
 
@@ -58,6 +61,24 @@ type Config struct {
 	// CredentialsFile points to a JSON credentials file. If running on Managed Cloud Run,
 	// or if using $GOOGLE_APPLICATION_CREDENTIALS, leave this value empty.
 	CredentialsFile string `envconfig:"CREDENTIALS_FILE"`
+
+	// Compression lists the codecs, "gzip" or "zstd", to request responses in, most preferred first,
+	// for queries which do not list their own. Codecs which are not registered are skipped, and if none
+	// is, responses are not compressed. The partner must have the codec registered too.
+	Compression []string `envconfig:"COMPRESSION"`
+
+	// GroupInsertsByRegion, if true, inserts each page of pulled keys as one database batch per
+	// set of regions, rather than one statement per key.
+	GroupInsertsByRegion bool `envconfig:"GROUP_INSERTS_BY_REGION" default:"false"`
+
+	// MaxIntervalCount clamps the IntervalCount of pulled keys, so that keys a partner sends with a
+	// longer validity than clients accept are stored in spec rather than served and then rejected.
+	// Zero stores IntervalCounts unchanged.
+	MaxIntervalCount int32 `envconfig:"MAX_INTERVAL_COUNT" default:"144"`
+}
+
+func (c *Config) BlocklistConfig() *blocklist.Config {
+	return &c.Blocklist
 }
 
 func (c *Config) DatabaseConfig() *database.Config {
@@ -0,0 +1,111 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/federationin/model"
+)
+
+// WriteDeadLetter stores a key which failed validation on ingest. The ID of dl is set on success.
+func (db *FederationInDB) WriteDeadLetter(ctx context.Context, dl *model.DeadLetter) error {
+	conn, err := db.db.Pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("acquiring connection: %w", err)
+	}
+	defer conn.Release()
+
+	row := conn.QueryRow(ctx, `
+		INSERT INTO
+			DeadLetterKey
+			(query_id, sync_id, exposure_key, transmission_risk, regions, interval_number, interval_count, reason, created_at)
+		VALUES
+			($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING dead_letter_id
+		`, dl.QueryID, dl.SyncID, dl.ExposureKey, dl.TransmissionRisk, dl.Regions, dl.IntervalNumber, dl.IntervalCount, dl.Reason, dl.CreatedAt)
+	if err := row.Scan(&dl.ID); err != nil {
+		return fmt.Errorf("inserting dead letter: %w", err)
+	}
+	return nil
+}
+
+// PendingDeadLetters returns up to limit dead letters with IDs greater than afterID which have not
+// been reprocessed, in ID order.
+func (db *FederationInDB) PendingDeadLetters(ctx context.Context, afterID int64, limit int) ([]*model.DeadLetter, error) {
+	conn, err := db.db.Pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acquiring connection: %w", err)
+	}
+	defer conn.Release()
+
+	rows, err := conn.Query(ctx, `
+		SELECT
+			dead_letter_id, query_id, sync_id, exposure_key, transmission_risk, regions, interval_number, interval_count, reason, created_at
+		FROM
+			DeadLetterKey
+		WHERE
+			dead_letter_id > $1 AND reprocessed_at IS NULL
+		ORDER BY dead_letter_id
+		LIMIT $2
+		`, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("querying dead letters: %w", err)
+	}
+	defer rows.Close()
+
+	var letters []*model.DeadLetter
+	for rows.Next() {
+		var dl model.DeadLetter
+		if err := rows.Scan(&dl.ID, &dl.QueryID, &dl.SyncID, &dl.ExposureKey, &dl.TransmissionRisk, &dl.Regions,
+			&dl.IntervalNumber, &dl.IntervalCount, &dl.Reason, &dl.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scanning results: %w", err)
+		}
+		letters = append(letters, &dl)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating dead letters: %w", err)
+	}
+	return letters, nil
+}
+
+// MarkDeadLetterReprocessed records that a dead letter was promoted to an exposure. If the dead
+// letter does not exist or was already reprocessed, ErrNotFound is returned.
+func (db *FederationInDB) MarkDeadLetterReprocessed(ctx context.Context, id int64, reprocessedAt time.Time) error {
+	conn, err := db.db.Pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("acquiring connection: %w", err)
+	}
+	defer conn.Release()
+
+	result, err := conn.Exec(ctx, `
+		UPDATE
+			DeadLetterKey
+		SET
+			reprocessed_at = $1
+		WHERE
+			dead_letter_id = $2 AND reprocessed_at IS NULL
+		`, reprocessedAt, id)
+	if err != nil {
+		return fmt.Errorf("updating dead letter: %w", err)
+	}
+	if result.RowsAffected() != 1 {
+		return database.ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/federationin/model"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDeadLetters(t *testing.T) {
+	t.Parallel()
+
+	testDB := database.NewTestDatabase(t)
+	db := New(testDB)
+	ctx := context.Background()
+
+	created := time.Date(2020, 5, 6, 0, 0, 0, 0, time.UTC)
+	var want []*model.DeadLetter
+	for i, risk := range []int{9, -1} {
+		dl := &model.DeadLetter{
+			QueryID:          "qid",
+			SyncID:           7,
+			ExposureKey:      []byte{byte(i), 1, 2, 3},
+			TransmissionRisk: risk,
+			Regions:          []string{"US"},
+			IntervalNumber:   100,
+			IntervalCount:    144,
+			Reason:           "invalid transmission risk",
+			CreatedAt:        created,
+		}
+		if err := db.WriteDeadLetter(ctx, dl); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, dl)
+	}
+
+	got, err := db.PendingDeadLetters(ctx, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+	got, err = db.PendingDeadLetters(ctx, want[0].ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want[1:], got); diff != "" {
+		t.Errorf("after first ID mismatch (-want, +got):\n%s", diff)
+	}
+
+	if err := db.MarkDeadLetterReprocessed(ctx, want[0].ID, created.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.MarkDeadLetterReprocessed(ctx, want[0].ID, created.Add(time.Hour)); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("marking twice returned err=%v, want %v", err, database.ErrNotFound)
+	}
+	got, err = db.PendingDeadLetters(ctx, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want[1:], got); diff != "" {
+		t.Errorf("after reprocessing mismatch (-want, +got):\n%s", diff)
+	}
+}
@@ -58,7 +58,7 @@ func (db *FederationInDB) GetFederationInQuery(ctx context.Context, queryID stri
 func getFederationInQuery(ctx context.Context, queryID string, queryRow queryRowFn) (*model.FederationInQuery, error) {
 	row := queryRow(ctx, `
 		SELECT
-			query_id, server_addr, oidc_audience, include_regions, exclude_regions, last_timestamp
+			query_id, server_addr, oidc_audience, include_regions, exclude_regions, last_timestamp, compression
 		FROM
 			FederationInQuery 
 		WHERE 
@@ -67,7 +67,7 @@ func getFederationInQuery(ctx context.Context, queryID string, queryRow queryRow
 
 	// See https://www.opsdash.com/blog/postgres-arrays-golang.html for working with Postgres arrays in Go.
 	q := model.FederationInQuery{}
-	if err := row.Scan(&q.QueryID, &q.ServerAddr, &q.Audience, &q.IncludeRegions, &q.ExcludeRegions, &q.LastTimestamp); err != nil {
+	if err := row.Scan(&q.QueryID, &q.ServerAddr, &q.Audience, &q.IncludeRegions, &q.ExcludeRegions, &q.LastTimestamp, &q.Compression); err != nil {
 		if err == pgx.ErrNoRows {
 			return nil, database.ErrNotFound
 		}
@@ -82,15 +82,15 @@ func (db *FederationInDB) AddFederationInQuery(ctx context.Context, q *model.Fed
 		query := `
 			INSERT INTO
 				FederationInQuery
-				(query_id, server_addr, oidc_audience, include_regions, exclude_regions, last_timestamp)
+				(query_id, server_addr, oidc_audience, include_regions, exclude_regions, last_timestamp, compression)
 			VALUES
-				($1, $2, $3, $4, $5, $6)
+				($1, $2, $3, $4, $5, $6, $7)
 			ON CONFLICT
 				(query_id)
 			DO UPDATE
-				SET server_addr = $2, oidc_audience = $3, include_regions = $4, exclude_regions = $5, last_timestamp = $6
+				SET server_addr = $2, oidc_audience = $3, include_regions = $4, exclude_regions = $5, last_timestamp = $6, compression = $7
 		`
-		_, err := tx.Exec(ctx, query, q.QueryID, q.ServerAddr, q.Audience, q.IncludeRegions, q.ExcludeRegions, q.LastTimestamp)
+		_, err := tx.Exec(ctx, query, q.QueryID, q.ServerAddr, q.Audience, q.IncludeRegions, q.ExcludeRegions, q.LastTimestamp, q.Compression)
 		if err != nil {
 			return fmt.Errorf("upserting federation query: %w", err)
 		}
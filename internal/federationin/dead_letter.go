@@ -0,0 +1,184 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federationin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	coredb "github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/federationin/database"
+	"github.com/google/exposure-notifications-server/internal/federationin/model"
+	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/metrics"
+	publishdb "github.com/google/exposure-notifications-server/internal/publish/database"
+	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+
+	"go.opencensus.io/trace"
+)
+
+const reprocessLock = "federationin_dead_letters"
+
+var (
+	reprocessBatchSize = 500
+)
+
+type (
+	pendingDeadLettersFn func(ctx context.Context, afterID int64, limit int) ([]*model.DeadLetter, error)
+	markReprocessedFn    func(ctx context.Context, id int64, reprocessedAt time.Time) error
+)
+
+type reprocessDependencies struct {
+	pendingDeadLetters pendingDeadLettersFn
+	insertExposures    insertExposuresFn
+	markReprocessed    markReprocessedFn
+	validateKey        validateKeyFn
+}
+
+func newDeadLetter(queryID string, exp *publishmodel.Exposure, reason error, createdAt time.Time) *model.DeadLetter {
+	return &model.DeadLetter{
+		QueryID:          queryID,
+		SyncID:           exp.FederationSyncID,
+		ExposureKey:      exp.ExposureKey,
+		TransmissionRisk: exp.TransmissionRisk,
+		Regions:          exp.Regions,
+		IntervalNumber:   exp.IntervalNumber,
+		IntervalCount:    exp.IntervalCount,
+		Reason:           reason.Error(),
+		CreatedAt:        createdAt,
+	}
+}
+
+// NewReprocessHandler returns a handler that re-validates the keys stored as dead letters, and
+// promotes those which now pass validation to exposures, e.g. after a validation bug is fixed.
+func NewReprocessHandler(env *serverenv.ServerEnv, config *Config) http.Handler {
+	return &reprocessHandler{
+		env:       env,
+		db:        database.New(env.Database()),
+		publishdb: publishdb.New(env.Database()).GroupInsertsByRegion(config.GroupInsertsByRegion),
+		config:    config,
+	}
+}
+
+type reprocessHandler struct {
+	env       *serverenv.ServerEnv
+	db        *database.FederationInDB
+	publishdb *publishdb.PublishDB
+	config    *Config
+}
+
+func (h *reprocessHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, span := trace.StartSpan(r.Context(), "(*federationin.reprocessHandler).ServeHTTP")
+	defer span.End()
+
+	logger := logging.FromContext(ctx)
+
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	unlockFn, err := h.db.Lock(ctx, reprocessLock, h.config.Timeout)
+	if err != nil {
+		if errors.Is(err, coredb.ErrAlreadyLocked) {
+			msg := fmt.Sprintf("Lock %s already in use. No work will be performed.", reprocessLock)
+			logger.Infof(msg)
+			fmt.Fprint(w, msg)
+			return
+		}
+		internalErrorf(ctx, w, "Could not acquire lock %s: %v", reprocessLock, err)
+		return
+	}
+	defer func() {
+		if err := unlockFn(); err != nil {
+			logger.Errorf("failed to unlock: %v", err)
+		}
+	}()
+
+	deps := reprocessDependencies{
+		pendingDeadLetters: h.db.PendingDeadLetters,
+		insertExposures:    h.publishdb.InsertExposures,
+		markReprocessed:    h.db.MarkDeadLetterReprocessed,
+		validateKey:        validateKey,
+	}
+	promoted, remaining, err := reprocess(ctx, h.env.MetricsExporter(ctx), deps, time.Now(), h.config.TruncateWindow)
+	if err != nil {
+		internalErrorf(ctx, w, "Reprocessing dead letters failed after promoting %d keys: %v", promoted, err)
+		return
+	}
+	logger.Infof("Promoted %d dead letters, %d still fail validation", promoted, remaining)
+	fmt.Fprintf(w, "%d keys promoted, %d still invalid\n", promoted, remaining)
+}
+
+// reprocess validates every pending dead letter again, inserting those which pass as exposures
+// created now, and marking them reprocessed. It returns the number of keys promoted, and the number
+// which still fail validation. Inserting exposures is idempotent, so a dead letter promoted but not
+// marked is promoted harmlessly again on the next run.
+func reprocess(ctx context.Context, metrics metrics.Exporter, deps reprocessDependencies, now time.Time, truncateWindow time.Duration) (promoted, remaining int, err error) {
+	logger := logging.FromContext(ctx)
+	createdAt := publishmodel.TruncateWindow(now, truncateWindow)
+
+	var afterID int64
+	for {
+		letters, err := deps.pendingDeadLetters(ctx, afterID, reprocessBatchSize)
+		if err != nil {
+			return promoted, remaining, fmt.Errorf("listing dead letters: %w", err)
+		}
+
+		var exposures []*publishmodel.Exposure
+		var ids []int64
+		for _, dl := range letters {
+			afterID = dl.ID
+			exposure := &publishmodel.Exposure{
+				TransmissionRisk: dl.TransmissionRisk,
+				ExposureKey:      dl.ExposureKey,
+				Regions:          dl.Regions,
+				FederationSyncID: dl.SyncID,
+				IntervalNumber:   dl.IntervalNumber,
+				IntervalCount:    dl.IntervalCount,
+				CreatedAt:        createdAt,
+				LocalProvenance:  false,
+			}
+			if err := deps.validateKey(exposure); err != nil {
+				logger.Debugf("dead letter %d still invalid: %v", dl.ID, err)
+				remaining++
+				continue
+			}
+			exposures = append(exposures, exposure)
+			ids = append(ids, dl.ID)
+		}
+
+		if len(exposures) > 0 {
+			if err := deps.insertExposures(ctx, exposures); err != nil {
+				return promoted, remaining, fmt.Errorf("inserting %d exposures: %w", len(exposures), err)
+			}
+			for _, id := range ids {
+				if err := deps.markReprocessed(ctx, id, now); err != nil {
+					return promoted, remaining, fmt.Errorf("marking dead letter %d reprocessed: %w", id, err)
+				}
+				promoted++
+			}
+			metrics.WriteInt("federation-dead-letter-promoted", true, len(ids))
+		}
+
+		if len(letters) < reprocessBatchSize {
+			return promoted, remaining, nil
+		}
+	}
+}
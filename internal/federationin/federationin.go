@@ -27,6 +27,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/google/exposure-notifications-server/internal/blocklist"
 	coredb "github.com/google/exposure-notifications-server/internal/database"
 	"github.com/google/exposure-notifications-server/internal/federationin/database"
 	"github.com/google/exposure-notifications-server/internal/federationin/model"
@@ -42,6 +43,7 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/oauth"
+	"google.golang.org/grpc/encoding"
 
 	"go.opencensus.io/plugin/ocgrpc"
 	"go.opencensus.io/trace"
@@ -59,12 +61,27 @@ type (
 	fetchFn               func(context.Context, *pb.FederationFetchRequest, ...grpc.CallOption) (*pb.FederationFetchResponse, error)
 	insertExposuresFn     func(context.Context, []*publishmodel.Exposure) error
 	startFederationSyncFn func(context.Context, *model.FederationInQuery, time.Time) (int64, database.FinalizeSyncFn, error)
+	writeDeadLetterFn     func(context.Context, *model.DeadLetter) error
+	validateKeyFn         func(*publishmodel.Exposure) error
 )
 
 type pullDependencies struct {
 	fetch               fetchFn
 	insertExposures     insertExposuresFn
 	startFederationSync startFederationSyncFn
+	writeDeadLetter     writeDeadLetterFn
+	validateKey         validateKeyFn
+	keyBlocklist        *blocklist.Blocklist // nil if no keys are blocked
+	maxIntervalCount    int32                // IntervalCounts above it are clamped to it; zero leaves them unchanged
+}
+
+// validateKey is the check each federated key must pass to be stored. Keys which fail it are
+// stored as dead letters instead.
+func validateKey(exp *publishmodel.Exposure) error {
+	if exp.TransmissionRisk < verifyapi.MinTransmissionRisk || exp.TransmissionRisk > verifyapi.MaxTransmissionRisk {
+		return fmt.Errorf("invalid transmission risk %d, must be in [%d, %d]", exp.TransmissionRisk, verifyapi.MinTransmissionRisk, verifyapi.MaxTransmissionRisk)
+	}
+	return nil
 }
 
 // NewHandler returns a handler that will fetch server-to-server
@@ -73,7 +90,7 @@ func NewHandler(env *serverenv.ServerEnv, config *Config) http.Handler {
 	return &handler{
 		env:       env,
 		db:        database.New(env.Database()),
-		publishdb: publishdb.New(env.Database()),
+		publishdb: publishdb.New(env.Database()).GroupInsertsByRegion(config.GroupInsertsByRegion),
 		config:    config,
 	}
 }
@@ -177,6 +194,10 @@ func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		TokenSource: ts,
 	}))
 
+	if compressor := selectCompressor(query.Compression, h.config.Compression); compressor != encoding.Identity {
+		dialOpts = append(dialOpts, grpc.WithDefaultCallOptions(grpc.UseCompressor(compressor)))
+	}
+
 	logger.Infof("Dialing %s", query.ServerAddr)
 	conn, err := grpc.Dial(query.ServerAddr, dialOpts...)
 	if err != nil {
@@ -193,6 +214,10 @@ func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		fetch:               client.Fetch,
 		insertExposures:     h.publishdb.InsertExposures,
 		startFederationSync: h.db.StartFederationInSync,
+		writeDeadLetter:     h.db.WriteDeadLetter,
+		validateKey:         validateKey,
+		keyBlocklist:        h.env.KeyBlocklist(),
+		maxIntervalCount:    h.config.MaxIntervalCount,
 	}
 	batchStart := time.Now()
 	if err := pull(timeoutContext, metrics, deps, query, batchStart, h.config.TruncateWindow); err != nil {
@@ -264,12 +289,13 @@ func pull(ctx context.Context, metrics metrics.Exporter, deps pullDependencies,
 
 			for _, cti := range ctr.ContactTracingInfo {
 				for _, key := range cti.ExposureKeys {
-					if cti.TransmissionRisk < verifyapi.MinTransmissionRisk || cti.TransmissionRisk > verifyapi.MaxTransmissionRisk {
-						logger.Errorf("invalid transmission risk %v - dropping record.", cti.TransmissionRisk)
+					if deps.keyBlocklist.Contains(key.ExposureKey) {
+						logger.Warnf("blocklisted key - dropping record.")
+						metrics.WriteInt("federation-pull-blocklisted", true, 1)
 						continue
 					}
 
-					exposures = append(exposures, &publishmodel.Exposure{
+					exposure := &publishmodel.Exposure{
 						TransmissionRisk: int(cti.TransmissionRisk),
 						ExposureKey:      key.ExposureKey,
 						Regions:          upperRegions,
@@ -278,7 +304,22 @@ func pull(ctx context.Context, metrics metrics.Exporter, deps pullDependencies,
 						IntervalCount:    key.IntervalCount,
 						CreatedAt:        createdAt,
 						LocalProvenance:  false,
-					})
+					}
+					// Some partners send keys valid for longer than a day, which clients reject.
+					if max := deps.maxIntervalCount; max > 0 && exposure.IntervalCount > max {
+						logger.Infof("key has IntervalCount %d - clamping to %d.", exposure.IntervalCount, max)
+						metrics.WriteInt("federation-pull-interval-count-clamped", true, 1)
+						exposure.IntervalCount = max
+					}
+					if err := deps.validateKey(exposure); err != nil {
+						logger.Errorf("%v - storing record as dead letter.", err)
+						metrics.WriteInt("federation-pull-dead-letter", true, 1)
+						if err := deps.writeDeadLetter(ctx, newDeadLetter(q.QueryID, exposure, err, batchStart)); err != nil {
+							return fmt.Errorf("writing dead letter: %w", err)
+						}
+						continue
+					}
+					exposures = append(exposures, exposure)
 
 					if len(exposures) == fetchBatchSize {
 						if err := deps.insertExposures(ctx, exposures); err != nil {
@@ -16,9 +16,11 @@ package federationin
 
 import (
 	"context"
+	"fmt"
 	"testing"
 	"time"
 
+	"github.com/google/exposure-notifications-server/internal/blocklist"
 	"github.com/google/exposure-notifications-server/internal/federationin/database"
 	"github.com/google/exposure-notifications-server/internal/federationin/model"
 	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
@@ -75,6 +77,32 @@ func (idb *publishDB) insertExposures(ctx context.Context, exposures []*publishm
 	return nil
 }
 
+// deadLetterDB mocks the database, recording and serving dead letters.
+type deadLetterDB struct {
+	letters []*model.DeadLetter
+}
+
+func (ddb *deadLetterDB) writeDeadLetter(ctx context.Context, dl *model.DeadLetter) error {
+	dl.ID = int64(len(ddb.letters) + 1)
+	ddb.letters = append(ddb.letters, dl)
+	return nil
+}
+
+func (ddb *deadLetterDB) pendingDeadLetters(ctx context.Context, afterID int64, limit int) ([]*model.DeadLetter, error) {
+	var pending []*model.DeadLetter
+	for _, dl := range ddb.letters {
+		if dl.ID > afterID && dl.ReprocessedAt.IsZero() && len(pending) < limit {
+			pending = append(pending, dl)
+		}
+	}
+	return pending, nil
+}
+
+func (ddb *deadLetterDB) markReprocessed(ctx context.Context, id int64, reprocessedAt time.Time) error {
+	ddb.letters[id-1].ReprocessedAt = reprocessedAt
+	return nil
+}
+
 // syncDB mocks the database, recording start and complete invocations for a sync record.
 type syncDB struct {
 	syncStarted   bool
@@ -101,8 +129,10 @@ func TestFederationPull(t *testing.T) {
 	testCases := []struct {
 		name             string
 		batchSize        int
+		keyBlocklist     *blocklist.Blocklist
 		fetchResponses   []*pb.FederationFetchResponse
 		wantExposures    []*publishmodel.Exposure
+		wantDeadLetters  []*model.DeadLetter
 		wantTokens       []string
 		wantMaxTimestamp time.Time
 	}{
@@ -147,6 +177,35 @@ func TestFederationPull(t *testing.T) {
 			wantTokens:       []string{""},
 			wantMaxTimestamp: time.Unix(400, 0),
 		},
+		{
+			name:         "blocklisted keys dropped",
+			keyBlocklist: blocklist.New(bbb.ExposureKey, ddd.ExposureKey),
+			fetchResponses: []*pb.FederationFetchResponse{
+				{
+					Response: []*pb.ContactTracingResponse{
+						{
+							ContactTracingInfo: []*pb.ContactTracingInfo{
+								{TransmissionRisk: 1, ExposureKeys: []*pb.ExposureKey{aaa, bbb}},
+							},
+							RegionIdentifiers: []string{"US"},
+						},
+						{
+							ContactTracingInfo: []*pb.ContactTracingInfo{
+								{TransmissionRisk: 2, ExposureKeys: []*pb.ExposureKey{ccc, ddd}},
+							},
+							RegionIdentifiers: []string{"US", "CA"},
+						},
+					},
+					FetchResponseKeyTimestamp: 400,
+				},
+			},
+			wantExposures: []*publishmodel.Exposure{
+				makeRemoteExposure(aaa, 1, "US"),
+				makeRemoteExposure(ccc, 2, "CA", "US"),
+			},
+			wantTokens:       []string{""},
+			wantMaxTimestamp: time.Unix(400, 0),
+		},
 		{
 			name: "invalid transmission risk",
 			fetchResponses: []*pb.FederationFetchResponse{
@@ -178,6 +237,12 @@ func TestFederationPull(t *testing.T) {
 				makeRemoteExposure(aaa, 1, "US"),
 				makeRemoteExposure(bbb, 1, "US"),
 			},
+			wantDeadLetters: []*model.DeadLetter{
+				{ID: 1, SyncID: syncID, ExposureKey: ccc.ExposureKey, TransmissionRisk: -1, Regions: []string{"CA", "US"}, IntervalNumber: 3,
+					Reason: "invalid transmission risk -1, must be in [0, 8]"},
+				{ID: 2, SyncID: syncID, ExposureKey: ddd.ExposureKey, TransmissionRisk: 9, Regions: []string{"US"}, IntervalNumber: 4,
+					Reason: "invalid transmission risk 9, must be in [0, 8]"},
+			},
 			wantTokens:       []string{""},
 			wantMaxTimestamp: time.Unix(400, 0),
 		},
@@ -270,6 +335,7 @@ func TestFederationPull(t *testing.T) {
 			remote := remoteFetchServer{responses: tc.fetchResponses}
 			idb := publishDB{}
 			sdb := syncDB{}
+			ddb := deadLetterDB{}
 			batchStart := time.Now()
 			if tc.batchSize > 0 {
 				oldBatchSize := fetchBatchSize
@@ -280,6 +346,9 @@ func TestFederationPull(t *testing.T) {
 				fetch:               remote.fetch,
 				insertExposures:     idb.insertExposures,
 				startFederationSync: sdb.startFederationSync,
+				writeDeadLetter:     ddb.writeDeadLetter,
+				validateKey:         validateKey,
+				keyBlocklist:        tc.keyBlocklist,
 			}
 
 			err := pull(ctx, metrics.NewLogsBasedFromContext(ctx), deps, query, batchStart, time.Hour)
@@ -290,6 +359,9 @@ func TestFederationPull(t *testing.T) {
 			if diff := cmp.Diff(tc.wantExposures, idb.exposures, cmpopts.IgnoreFields(publishmodel.Exposure{}, "CreatedAt")); diff != "" {
 				t.Errorf("exposures mismatch (-want +got):\n%s", diff)
 			}
+			if diff := cmp.Diff(tc.wantDeadLetters, ddb.letters, cmpopts.IgnoreFields(model.DeadLetter{}, "CreatedAt")); diff != "" {
+				t.Errorf("dead letters mismatch (-want +got):\n%s", diff)
+			}
 			if diff := cmp.Diff(tc.wantTokens, remote.gotTokens); diff != "" {
 				t.Errorf("tokens mismatch (-want +got):\n%s", diff)
 			}
@@ -312,6 +384,133 @@ func TestFederationPull(t *testing.T) {
 	}
 }
 
+// TestFederationPullClampIntervalCount checks that IntervalCounts above the configured maximum are
+// clamped, and others are stored unchanged.
+func TestFederationPullClampIntervalCount(t *testing.T) {
+	keys := []*pb.ExposureKey{
+		{ExposureKey: []byte("aaa"), IntervalNumber: 1, IntervalCount: 1},
+		{ExposureKey: []byte("bbb"), IntervalNumber: 2, IntervalCount: 144},
+		{ExposureKey: []byte("ccc"), IntervalNumber: 3, IntervalCount: 145},
+		{ExposureKey: []byte("ddd"), IntervalNumber: 4, IntervalCount: 1000},
+	}
+	testCases := []struct {
+		name             string
+		maxIntervalCount int32
+		want             []int32
+	}{
+		{name: "clamped", maxIntervalCount: 144, want: []int32{1, 144, 144, 144}},
+		{name: "disabled", maxIntervalCount: 0, want: []int32{1, 144, 145, 1000}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			remote := remoteFetchServer{responses: []*pb.FederationFetchResponse{{
+				Response: []*pb.ContactTracingResponse{{
+					ContactTracingInfo: []*pb.ContactTracingInfo{{TransmissionRisk: 1, ExposureKeys: keys}},
+					RegionIdentifiers:  []string{"US"},
+				}},
+			}}}
+			idb := publishDB{}
+			sdb := syncDB{}
+			ddb := deadLetterDB{}
+			deps := pullDependencies{
+				fetch:               remote.fetch,
+				insertExposures:     idb.insertExposures,
+				startFederationSync: sdb.startFederationSync,
+				writeDeadLetter:     ddb.writeDeadLetter,
+				validateKey:         validateKey,
+				maxIntervalCount:    tc.maxIntervalCount,
+			}
+			if err := pull(ctx, metrics.NewLogsBasedFromContext(ctx), deps, &model.FederationInQuery{}, time.Now(), time.Hour); err != nil {
+				t.Fatalf("pull returned err=%v, want err=nil", err)
+			}
+
+			var got []int32
+			for _, exp := range idb.exposures {
+				got = append(got, exp.IntervalCount)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("interval counts mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestReprocessDeadLetters stores dead letters, then reprocesses them after the validation rule
+// is relaxed.
+func TestReprocessDeadLetters(t *testing.T) {
+	ctx := context.Background()
+	metrics := metrics.NewLogsBasedFromContext(ctx)
+	oldBatchSize := reprocessBatchSize
+	reprocessBatchSize = 1
+	defer func() { reprocessBatchSize = oldBatchSize }()
+
+	remote := remoteFetchServer{responses: []*pb.FederationFetchResponse{{
+		Response: []*pb.ContactTracingResponse{{
+			ContactTracingInfo: []*pb.ContactTracingInfo{
+				{TransmissionRisk: 9, ExposureKeys: []*pb.ExposureKey{aaa}},
+				{TransmissionRisk: 12, ExposureKeys: []*pb.ExposureKey{bbb}},
+			},
+			RegionIdentifiers: []string{"US"},
+		}},
+	}}}
+	idb := publishDB{}
+	sdb := syncDB{}
+	ddb := deadLetterDB{}
+	pullDeps := pullDependencies{
+		fetch:               remote.fetch,
+		insertExposures:     idb.insertExposures,
+		startFederationSync: sdb.startFederationSync,
+		writeDeadLetter:     ddb.writeDeadLetter,
+		validateKey:         validateKey,
+	}
+	if err := pull(ctx, metrics, pullDeps, &model.FederationInQuery{QueryID: "qid"}, time.Now(), time.Hour); err != nil {
+		t.Fatalf("pull returned err=%v, want err=nil", err)
+	}
+	if len(idb.exposures) != 0 || len(ddb.letters) != 2 {
+		t.Fatalf("pull stored %d exposures and %d dead letters, want 0 and 2", len(idb.exposures), len(ddb.letters))
+	}
+
+	deps := reprocessDependencies{
+		pendingDeadLetters: ddb.pendingDeadLetters,
+		insertExposures:    idb.insertExposures,
+		markReprocessed:    ddb.markReprocessed,
+		validateKey:        validateKey,
+	}
+	now := time.Date(2020, 5, 1, 10, 30, 0, 0, time.UTC)
+
+	// With the rule unchanged, nothing is promoted.
+	promoted, remaining, err := reprocess(ctx, metrics, deps, now, time.Hour)
+	if err != nil || promoted != 0 || remaining != 2 {
+		t.Fatalf("reprocess() = %d, %d, %v, want 0, 2, nil", promoted, remaining, err)
+	}
+
+	// Transmission risks up to 10 are now accepted.
+	deps.validateKey = func(exp *publishmodel.Exposure) error {
+		if exp.TransmissionRisk > 10 {
+			return fmt.Errorf("invalid transmission risk %d", exp.TransmissionRisk)
+		}
+		return nil
+	}
+	promoted, remaining, err = reprocess(ctx, metrics, deps, now, time.Hour)
+	if err != nil || promoted != 1 || remaining != 1 {
+		t.Fatalf("reprocess() = %d, %d, %v, want 1, 1, nil", promoted, remaining, err)
+	}
+	want := makeRemoteExposure(aaa, 9, "US")
+	want.CreatedAt = time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+	if diff := cmp.Diff([]*publishmodel.Exposure{want}, idb.exposures); diff != "" {
+		t.Errorf("exposures mismatch (-want +got):\n%s", diff)
+	}
+	if !ddb.letters[0].ReprocessedAt.Equal(now) || !ddb.letters[1].ReprocessedAt.IsZero() {
+		t.Errorf("reprocessed at %v and %v, want %v and zero", ddb.letters[0].ReprocessedAt, ddb.letters[1].ReprocessedAt, now)
+	}
+
+	// A promoted key is not promoted again.
+	if promoted, _, err := reprocess(ctx, metrics, deps, now, time.Hour); err != nil || promoted != 0 {
+		t.Errorf("reprocess() again promoted %d, err=%v, want 0, nil", promoted, err)
+	}
+}
+
 func makeExposure(diagKey *pb.ExposureKey, diagStatus int, regions ...string) *publishmodel.Exposure {
 	return &publishmodel.Exposure{
 		Regions:          regions,
@@ -26,6 +26,9 @@ type FederationInQuery struct {
 	IncludeRegions []string  `db:"include_regions"`
 	ExcludeRegions []string  `db:"exclude_regions"`
 	LastTimestamp  time.Time `db:"last_timestamp"`
+	// Compression lists the codecs, "gzip" or "zstd", to request responses in, most preferred first. The
+	// first one registered is used. If empty, Config.Compression applies.
+	Compression []string `db:"compression"`
 }
 
 // FederationInSync is the result of a federation query pulled from other servers.
@@ -38,6 +41,23 @@ type FederationInSync struct {
 	MaxTimestamp time.Time `db:"max_timestamp"`
 }
 
+// DeadLetter is a federated key which failed validation on ingest, kept with the reason so that it
+// can be investigated and reprocessed once the cause is fixed.
+type DeadLetter struct {
+	ID               int64     `db:"dead_letter_id"`
+	QueryID          string    `db:"query_id"`
+	SyncID           int64     `db:"sync_id"`
+	ExposureKey      []byte    `db:"exposure_key"`
+	TransmissionRisk int       `db:"transmission_risk"`
+	Regions          []string  `db:"regions"`
+	IntervalNumber   int32     `db:"interval_number"`
+	IntervalCount    int32     `db:"interval_count"`
+	Reason           string    `db:"reason"`
+	CreatedAt        time.Time `db:"created_at"`
+	// ReprocessedAt is when the key was promoted to an exposure, or zero if it has not been.
+	ReprocessedAt time.Time `db:"reprocessed_at"`
+}
+
 // FederationOutAuthorization is an authorized client that reads federation data from this server.
 type FederationOutAuthorization struct {
 	Issuer  string `db:"oidc_issuer"`
@@ -47,4 +67,35 @@ type FederationOutAuthorization struct {
 	Note           string   `db:"note"`
 	IncludeRegions []string `db:"include_regions"`
 	ExcludeRegions []string `db:"exclude_regions"`
+	// Namespace restricts the client to exposures published in that namespace.
+	Namespace string `db:"namespace"`
+	// AllowHistorical permits the client to make "as of" fetches of past key sets.
+	AllowHistorical bool `db:"allow_historical"`
+	// AllowBackfill permits the client to override the time up to which keys are served, e.g. to replay
+	// a fixed window during a backfill.
+	AllowBackfill bool `db:"allow_backfill"`
+	// AllowUpload permits the client to push keys through the Upload endpoint, into the regions it
+	// includes. Being allowed to fetch does not allow uploads.
+	AllowUpload bool `db:"allow_upload"`
+	// MaxResponseBytes, if positive, overrides the server's limit on the size of a fetch response,
+	// e.g. for a client whose gRPC maximum receive size is lower.
+	MaxResponseBytes int `db:"max_response_bytes"`
+	// MinKeyAge, if positive, withholds keys from the client until they are at least this old, e.g.
+	// for a partner which must leave time for keys to be revoked. It applies on top of the server's
+	// truncation window.
+	MinKeyAge time.Duration `db:"min_key_age_seconds"`
+}
+
+// FederationOutAudit is a record of a single fetch served to a federation client.
+type FederationOutAudit struct {
+	AuditID         int64     `db:"audit_id"`
+	Issuer          string    `db:"oidc_issuer"`
+	Subject         string    `db:"oidc_subject"`
+	IncludeRegions  []string  `db:"include_regions"`
+	ExcludeRegions  []string  `db:"exclude_regions"`
+	SinceTimestamp  time.Time `db:"since_timestamp"`
+	UntilTimestamp  time.Time `db:"until_timestamp"`
+	KeysServed      int       `db:"keys_served"`
+	PartialResponse bool      `db:"partial_response"`
+	FetchedAt       time.Time `db:"fetched_at"`
 }
@@ -0,0 +1,128 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federationout
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/pb"
+	publishdb "github.com/google/exposure-notifications-server/internal/publish/database"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// responseCache holds recent small fetch responses, so that partners polling
+// the same regions on the same cadence do not each query the database. An
+// entry is only served while the watermark of its regions, the latest
+// CreatedAt published in them, is unchanged, so new keys are never missed.
+type responseCache struct {
+	ttl     time.Duration
+	size    int
+	maxKeys int
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+}
+
+type cacheEntry struct {
+	response *pb.FederationFetchResponse
+	keys     int
+	latest   time.Time
+	expires  time.Time
+}
+
+// newResponseCache creates a cache of up to size responses, each of at most
+// maxKeys keys, served for up to ttl. If ttl or size is not positive, there is
+// no cache and nil is returned.
+func newResponseCache(size int, ttl time.Duration, maxKeys int) *responseCache {
+	if ttl <= 0 || size <= 0 {
+		return nil
+	}
+	return &responseCache{
+		ttl:     ttl,
+		size:    size,
+		maxKeys: maxKeys,
+		entries: make(map[string]*cacheEntry, size),
+	}
+}
+
+// responseCacheKey identifies a fetch by everything which determines its response, other than the
+// data itself. Requests for tombstones are not cached, since revocations do not move the watermark.
+// Requests with a knownKeys filter are not cached either, since each filter is particular to a client.
+func responseCacheKey(req *pb.FederationFetchRequest, criteria publishdb.IterateExposuresCriteria, maxBytes int) (string, bool) {
+	if req.IncludeTombstones || req.KnownKeys != nil {
+		return "", false
+	}
+	regions := normalizeRegions(append([]string(nil), criteria.IncludeRegions...))
+	exclude := normalizeRegions(append([]string(nil), req.ExcludeRegionIdentifiers...))
+	return fmt.Sprintf("%q|%s|%s|%d|%d|%q|%d|%t|%t|%t|%t|%d|%t",
+		criteria.Namespace, strings.Join(regions, ","), strings.Join(exclude, ","),
+		criteria.SinceTimestamp.Unix(), criteria.UntilTimestamp.Unix(), criteria.LastCursor,
+		maxBytes, req.ExplodeRegions, req.Debug, req.OrderByRisk, req.DedupKeys, criteria.MinTransmissionRisk,
+		criteria.Reverse), true
+}
+
+// get returns a copy of the response cached under key, and the number of keys it served, if it has
+// not expired and latest is the watermark it was cached at.
+func (c *responseCache) get(key string, latest, now time.Time) (*pb.FederationFetchResponse, int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, 0, false
+	}
+	if !now.Before(e.expires) || !e.latest.Equal(latest) {
+		delete(c.entries, key)
+		return nil, 0, false
+	}
+	return proto.Clone(e.response).(*pb.FederationFetchResponse), e.keys, true
+}
+
+// put caches a complete response which served keys keys, computed at watermark latest. Responses
+// with more than maxKeys keys are not cached. If the cache is full, expired entries are dropped,
+// then the entry closest to expiry.
+func (c *responseCache) put(key string, latest, now time.Time, response *pb.FederationFetchResponse, keys int) {
+	if response.PartialResponse || keys > c.maxKeys {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.size {
+		var oldest string
+		for k, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+				continue
+			}
+			if oldest == "" || e.expires.Before(c.entries[oldest].expires) {
+				oldest = k
+			}
+		}
+		if len(c.entries) >= c.size {
+			delete(c.entries, oldest)
+		}
+	}
+	c.entries[key] = &cacheEntry{
+		response: proto.Clone(response).(*pb.FederationFetchResponse),
+		keys:     keys,
+		latest:   latest,
+		expires:  now.Add(c.ttl),
+	}
+}
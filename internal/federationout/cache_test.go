@@ -0,0 +1,122 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federationout
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/pb"
+	"github.com/google/exposure-notifications-server/internal/publish/database"
+	"github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/testing/protocmp"
+)
+
+// TestFetchCache fetches the same regions repeatedly, checking when the database is queried.
+func TestFetchCache(t *testing.T) {
+	ctx := context.Background()
+	server := Server{env: serverenv.New(ctx), config: &Config{}, cache: newResponseCache(10, time.Minute, 100)}
+	fetchUntil := time.Unix(1000, 0)
+
+	elements := []interface{}{makeExposure(aaa, 1, "US"), makeExposure(bbb, 1, "US")}
+	queries := 0
+	deps := testDeps(nil)
+	deps.iterateExposures = func(ctx context.Context, criteria database.IterateExposuresCriteria, f func(*model.Exposure) error) (string, error) {
+		queries++
+		return iterFunc(elements)(ctx, criteria, f)
+	}
+	deps.latestCreatedAt = latestFunc(time.Unix(200, 0))
+
+	fetch := func(name string, req *pb.FederationFetchRequest, wantQueries int, wantKeys ...*pb.ExposureKey) {
+		t.Helper()
+		got, err := server.fetch(ctx, req, deps, fetchUntil)
+		if err != nil {
+			t.Fatalf("%s: fetch() returned err=%v, want err=nil", name, err)
+		}
+		if queries != wantQueries {
+			t.Errorf("%s: %d queries, want %d", name, queries, wantQueries)
+		}
+		want := &pb.FederationFetchResponse{
+			Response: []*pb.ContactTracingResponse{{
+				RegionIdentifiers:  []string{"US"},
+				ContactTracingInfo: []*pb.ContactTracingInfo{{TransmissionRisk: 1, ExposureKeys: wantKeys}},
+			}},
+			FetchResponseKeyTimestamp: int64(wantKeys[len(wantKeys)-1].IntervalNumber * 100),
+			KeyCount:                  int64(len(wantKeys)),
+			RegionCount:               1,
+		}
+		if diff := cmp.Diff(want, got, protocmp.Transform()); diff != "" {
+			t.Errorf("%s: response mismatch (-want +got):\n%s", name, diff)
+		}
+	}
+
+	fetch("first", &pb.FederationFetchRequest{RegionIdentifiers: []string{"US"}}, 1, aaa, bbb)
+	fetch("hit", &pb.FederationFetchRequest{RegionIdentifiers: []string{"us"}}, 1, aaa, bbb)
+
+	// A key is published, so the cached response is stale.
+	elements = append(elements, makeExposure(ccc, 1, "US"))
+	deps.latestCreatedAt = latestFunc(time.Unix(300, 0))
+	fetch("watermark advanced", &pb.FederationFetchRequest{RegionIdentifiers: []string{"US"}}, 2, aaa, bbb, ccc)
+	fetch("hit after watermark", &pb.FederationFetchRequest{RegionIdentifiers: []string{"US"}}, 2, aaa, bbb, ccc)
+
+	// Each page of a paged fetch is cached separately.
+	elements = elements[1:]
+	fetch("cursor", &pb.FederationFetchRequest{RegionIdentifiers: []string{"US"}, NextFetchToken: "aaa_cursor"}, 3, bbb, ccc)
+	fetch("hit with cursor", &pb.FederationFetchRequest{RegionIdentifiers: []string{"US"}, NextFetchToken: "aaa_cursor"}, 3, bbb, ccc)
+	elements = elements[1:]
+	fetch("other cursor", &pb.FederationFetchRequest{RegionIdentifiers: []string{"US"}, NextFetchToken: "bbb_cursor"}, 4, ccc)
+}
+
+// TestResponseCache tests which responses are cached, and for how long.
+func TestResponseCache(t *testing.T) {
+	if c := newResponseCache(10, 0, 100); c != nil {
+		t.Errorf("newResponseCache with no TTL = %v, want nil", c)
+	}
+
+	now := time.Unix(1000, 0)
+	latest := time.Unix(500, 0)
+	c := newResponseCache(2, time.Minute, 10)
+	response := &pb.FederationFetchResponse{KeyCount: 3}
+
+	c.put("partial", latest, now, &pb.FederationFetchResponse{PartialResponse: true}, 3)
+	c.put("large", latest, now, response, 11)
+	for _, key := range []string{"partial", "large"} {
+		if _, _, ok := c.get(key, latest, now); ok {
+			t.Errorf("%s response was cached", key)
+		}
+	}
+
+	c.put("a", latest, now, response, 3)
+	if got, keys, ok := c.get("a", latest, now.Add(59*time.Second)); !ok || keys != 3 || !proto.Equal(got, response) {
+		t.Errorf("get(a) = %v, %d, %v, want %v, 3, true", got, keys, ok, response)
+	}
+	if _, _, ok := c.get("a", latest, now.Add(time.Minute)); ok {
+		t.Errorf("expired response was served")
+	}
+
+	// The entry closest to expiry is evicted when the cache is full.
+	c.put("a", latest, now, response, 3)
+	c.put("b", latest, now.Add(time.Second), response, 3)
+	c.put("c", latest, now.Add(2*time.Second), response, 3)
+	for key, want := range map[string]bool{"a": false, "b": true, "c": true} {
+		if _, _, ok := c.get(key, latest, now.Add(2*time.Second)); ok != want {
+			t.Errorf("get(%s) found=%v, want %v", key, ok, want)
+		}
+	}
+}
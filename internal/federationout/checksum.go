@@ -0,0 +1,221 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federationout
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"strings"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/federationin/model"
+	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/pb"
+	publishdb "github.com/google/exposure-notifications-server/internal/publish/database"
+	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
+	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1alpha1"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// defaultChecksumBucket is the bucket width if the request does not set one.
+	defaultChecksumBucket = 24 * time.Hour
+
+	// maxChecksumBuckets bounds the size of a checksum tree.
+	maxChecksumBuckets = 1024
+)
+
+// Checksum implements the FederationServer Checksum endpoint.
+func (s Server) Checksum(ctx context.Context, req *pb.ChecksumRequest) (*pb.ChecksumResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.fetchTimeout(ctx))
+	defer cancel()
+	logger := logging.FromContext(ctx)
+	metrics := s.env.MetricsExporter(ctx)
+
+	deps := fetchDependencies{
+		iterateExposures: s.exposures.IterateExposures,
+		filters:          publishdb.SupportedFilters(s.exposures),
+	}
+	response, err := s.checksum(ctx, req, deps, publishmodel.TruncateWindow(time.Now(), s.config.TruncateWindow))
+	if err != nil {
+		metrics.WriteInt("federation-checksum-failed", true, 1)
+		logger.Errorf("Checksum error: %v", err)
+		return nil, fetchStatus(err)
+	}
+	return response, nil
+}
+
+func (s Server) checksum(ctx context.Context, req *pb.ChecksumRequest, deps fetchDependencies, fetchUntil time.Time) (*pb.ChecksumResponse, error) {
+	logger := logging.FromContext(ctx)
+
+	regions := make([]string, len(req.RegionIdentifiers))
+	for i, region := range req.RegionIdentifiers {
+		regions[i] = strings.ToUpper(region)
+	}
+	var excludeRegions []string
+	var namespace string
+	if auth, ok := ctx.Value(authKey{}).(*model.FederationOutAuthorization); ok {
+		namespace = auth.Namespace
+		regions = intersect(regions, auth.IncludeRegions)
+		excludeRegions = auth.ExcludeRegions
+	}
+	if len(regions) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "regionIdentifiers is required")
+	}
+	regions = normalizeRegions(regions)
+
+	since := time.Unix(req.SinceTimestamp, 0)
+	until := fetchUntil
+	if req.UntilTimestamp != 0 && time.Unix(req.UntilTimestamp, 0).Before(until) {
+		until = time.Unix(req.UntilTimestamp, 0)
+	}
+	if !since.Before(until) {
+		return nil, status.Errorf(codes.InvalidArgument, "sinceTimestamp %d must be before untilTimestamp %d", since.Unix(), until.Unix())
+	}
+	bucket := defaultChecksumBucket
+	if req.BucketSeconds < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "bucketSeconds must be positive, got %d", req.BucketSeconds)
+	}
+	if req.BucketSeconds > 0 {
+		bucket = time.Duration(req.BucketSeconds) * time.Second
+	}
+	numBuckets := int((until.Sub(since) + bucket - 1) / bucket)
+	if numBuckets > maxChecksumBuckets {
+		return nil, status.Errorf(codes.InvalidArgument, "%d buckets requested, at most %d are allowed", numBuckets, maxChecksumBuckets)
+	}
+
+	// Sum each bucket for each requested region.
+	matchers := make([]*regionMatcher, len(regions))
+	leaves := make([][]*pb.ChecksumNode, len(regions))
+	for i, region := range regions {
+		matchers[i] = newRegionMatcher([]string{region})
+		leaves[i] = make([]*pb.ChecksumNode, numBuckets)
+		for b := range leaves[i] {
+			start := since.Add(time.Duration(b) * bucket)
+			end := start.Add(bucket)
+			if end.After(until) {
+				end = until
+			}
+			leaves[i][b] = &pb.ChecksumNode{StartTimestamp: start.Unix(), EndTimestamp: end.Unix(), Checksum: make([]byte, sha256.Size)}
+		}
+	}
+	includedRegions := newRegionMatcher(regions)
+	excludedRegions := newRegionMatcher(excludeRegions)
+
+	criteria := publishdb.IterateExposuresCriteria{
+		SinceTimestamp:      since,
+		UntilTimestamp:      until,
+		NotExpiredAt:        time.Now(),
+		OnlyLocalProvenance: true,
+		Namespace:           namespace,
+	}
+	if len(regions) == 1 {
+		criteria.IncludeRegions = regions
+	}
+	_, err := deps.iterateExposures(ctx, criteria, func(inf *publishmodel.Exposure) error {
+		// Skip what fetch would not serve.
+		if len(inf.ExposureKey) == 0 || len(inf.Regions) == 0 || !inf.LocalProvenance || s.keyBlocklist.Contains(inf.ExposureKey) {
+			return nil
+		}
+		if !deps.filters.Namespace && inf.Namespace != namespace {
+			return nil
+		}
+		if !deps.filters.Expiry && !inf.ExpiresAt.IsZero() && !inf.ExpiresAt.After(criteria.NotExpiredAt) {
+			return nil
+		}
+		if !deps.filters.Timestamps && !inf.CreatedAt.Before(until) {
+			return nil
+		}
+		if s.keyTransform != nil {
+			var keep bool
+			if inf, keep = s.keyTransform(inf); !keep {
+				return nil
+			}
+		}
+		excluded, err := s.excluded(ctx, inf.Regions, includedRegions, excludedRegions)
+		if err != nil || excluded {
+			return err
+		}
+
+		start := time.Unix(int64(inf.IntervalNumber)*int64(verifyapi.IntervalLength.Seconds()), 0)
+		if start.Before(since) || !start.Before(until) {
+			return nil
+		}
+		b := int(start.Sub(since) / bucket)
+		sum := keyChecksum(inf)
+		for i, m := range matchers {
+			if !m.matchesAny(inf.Regions) {
+				continue
+			}
+			leaf := leaves[i][b]
+			leaf.KeyCount++
+			for j := range sum {
+				leaf.Checksum[j] ^= sum[j]
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, &fetchError{kind: ErrIterate, err: err}
+	}
+
+	response := &pb.ChecksumResponse{UntilTimestamp: until.Unix()}
+	for i, region := range regions {
+		response.Regions = append(response.Regions, &pb.RegionChecksum{
+			RegionIdentifier: region,
+			Root:             checksumTree(leaves[i]),
+		})
+	}
+	logger.Infof("Checksummed %d regions in %d buckets of %v", len(regions), numBuckets, bucket)
+	return response, nil
+}
+
+// keyChecksum returns SHA-256(exposureKey || intervalNumber || intervalCount).
+func keyChecksum(inf *publishmodel.Exposure) [sha256.Size]byte {
+	b := make([]byte, 0, len(inf.ExposureKey)+8)
+	b = append(b, inf.ExposureKey...)
+	b = append(b, make([]byte, 8)...)
+	binary.BigEndian.PutUint32(b[len(inf.ExposureKey):], uint32(inf.IntervalNumber))
+	binary.BigEndian.PutUint32(b[len(inf.ExposureKey)+4:], uint32(inf.IntervalCount))
+	return sha256.Sum256(b)
+}
+
+// checksumTree combines leaves, which must not be empty, into a tree and returns its root. Adjacent
+// pairs are combined level by level; an unpaired last node moves up unchanged.
+func checksumTree(nodes []*pb.ChecksumNode) *pb.ChecksumNode {
+	for len(nodes) > 1 {
+		next := make([]*pb.ChecksumNode, 0, (len(nodes)+1)/2)
+		for i := 0; i < len(nodes); i += 2 {
+			if i+1 == len(nodes) {
+				next = append(next, nodes[i])
+				continue
+			}
+			left, right := nodes[i], nodes[i+1]
+			sum := sha256.Sum256(append(append([]byte{}, left.Checksum...), right.Checksum...))
+			next = append(next, &pb.ChecksumNode{
+				StartTimestamp: left.StartTimestamp,
+				EndTimestamp:   right.EndTimestamp,
+				KeyCount:       left.KeyCount + right.KeyCount,
+				Checksum:       sum[:],
+				Children:       []*pb.ChecksumNode{left, right},
+			})
+		}
+		nodes = next
+	}
+	return nodes[0]
+}
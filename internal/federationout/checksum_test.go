@@ -0,0 +1,167 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federationout
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/pb"
+	"github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/testing/protocmp"
+)
+
+func TestChecksum(t *testing.T) {
+	ctx := context.Background()
+	day := 24 * time.Hour
+	until := time.Unix(0, 0).Add(3 * day)
+
+	// One interval is 10 minutes, so a day is 144 intervals.
+	exp := func(key string, intervalNumber int32, regions ...string) *model.Exposure {
+		return &model.Exposure{
+			ExposureKey:     []byte(key),
+			IntervalNumber:  intervalNumber,
+			IntervalCount:   144,
+			Regions:         regions,
+			CreatedAt:       time.Unix(int64(intervalNumber)*600, 0).Add(time.Hour),
+			LocalProvenance: true,
+		}
+	}
+	base := []*model.Exposure{
+		exp("day0-a", 0, "US"),
+		exp("day0-b", 10, "US", "CA"),
+		exp("day1-a", 144, "US"),
+		exp("day2-a", 300, "US"),
+		exp("day1-ca", 150, "CA"),
+	}
+
+	checksum := func(t *testing.T, exposures []*model.Exposure) *pb.ChecksumNode {
+		t.Helper()
+		elements := make([]interface{}, len(exposures))
+		for i, e := range exposures {
+			copied := *e
+			elements[i] = &copied
+		}
+		server := Server{env: serverenv.New(ctx), config: &Config{}}
+		req := &pb.ChecksumRequest{RegionIdentifiers: []string{"us"}, SinceTimestamp: 0}
+		resp, err := server.checksum(ctx, req, testDeps(elements), until)
+		if err != nil {
+			t.Fatalf("checksum() returned err=%v, want err=nil", err)
+		}
+		if len(resp.Regions) != 1 || resp.Regions[0].RegionIdentifier != "US" {
+			t.Fatalf("got regions %v, want [US]", resp.Regions)
+		}
+		return resp.Regions[0].Root
+	}
+
+	// leaves returns the bucket nodes of the tree, in order.
+	var leaves func(n *pb.ChecksumNode) []*pb.ChecksumNode
+	leaves = func(n *pb.ChecksumNode) []*pb.ChecksumNode {
+		if len(n.Children) == 0 {
+			return []*pb.ChecksumNode{n}
+		}
+		var out []*pb.ChecksumNode
+		for _, c := range n.Children {
+			out = append(out, leaves(c)...)
+		}
+		return out
+	}
+
+	want := checksum(t, base)
+	if want.KeyCount != 4 {
+		t.Errorf("root keyCount=%d, want 4", want.KeyCount)
+	}
+	if got := len(leaves(want)); got != 3 {
+		t.Fatalf("got %d buckets, want 3", got)
+	}
+
+	t.Run("stable", func(t *testing.T) {
+		// Order and keys in other regions do not matter.
+		reordered := []*model.Exposure{base[4], base[3], base[1], base[0], base[2], exp("day1-mx", 150, "MX")}
+		if diff := cmp.Diff(want, checksum(t, reordered), protocmp.Transform()); diff != "" {
+			t.Errorf("checksum mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("bucket changed", func(t *testing.T) {
+		changed := append([]*model.Exposure{}, base...)
+		changed[2] = exp("day1-b", 144, "US")
+		got := checksum(t, changed)
+
+		if bytes.Equal(want.Checksum, got.Checksum) {
+			t.Errorf("root checksum did not change")
+		}
+		wantLeaves, gotLeaves := leaves(want), leaves(got)
+		for i, changed := range []bool{false, true, false} {
+			if same := bytes.Equal(wantLeaves[i].Checksum, gotLeaves[i].Checksum); same == changed {
+				t.Errorf("bucket %d: checksum changed=%v, want %v", i, !same, changed)
+			}
+		}
+	})
+
+	t.Run("key added", func(t *testing.T) {
+		got := checksum(t, append(append([]*model.Exposure{}, base...), exp("day2-b", 400, "US")))
+		gotLeaves := leaves(got)
+		if gotLeaves[2].KeyCount != 2 {
+			t.Errorf("bucket 2 keyCount=%d, want 2", gotLeaves[2].KeyCount)
+		}
+		if bytes.Equal(leaves(want)[2].Checksum, gotLeaves[2].Checksum) {
+			t.Errorf("bucket 2 checksum did not change")
+		}
+	})
+}
+
+func TestChecksumInvalid(t *testing.T) {
+	ctx := context.Background()
+	until := time.Unix(0, 0).Add(72 * time.Hour)
+
+	testCases := []struct {
+		name string
+		req  *pb.ChecksumRequest
+	}{
+		{
+			name: "no regions",
+			req:  &pb.ChecksumRequest{},
+		},
+		{
+			name: "empty range",
+			req:  &pb.ChecksumRequest{RegionIdentifiers: []string{"US"}, SinceTimestamp: until.Unix()},
+		},
+		{
+			name: "negative bucket",
+			req:  &pb.ChecksumRequest{RegionIdentifiers: []string{"US"}, BucketSeconds: -1},
+		},
+		{
+			name: "too many buckets",
+			req:  &pb.ChecksumRequest{RegionIdentifiers: []string{"US"}, BucketSeconds: 60},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := Server{env: serverenv.New(ctx), config: &Config{}}
+			_, err := server.checksum(ctx, tc.req, testDeps(nil), until)
+			if code := status.Code(err); code != codes.InvalidArgument {
+				t.Errorf("checksum() returned %v, want InvalidArgument", err)
+			}
+		})
+	}
+}
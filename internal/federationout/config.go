@@ -17,17 +17,21 @@ package federationout
 import (
 	"time"
 
+	"github.com/google/exposure-notifications-server/internal/blocklist"
 	"github.com/google/exposure-notifications-server/internal/database"
+	publishdb "github.com/google/exposure-notifications-server/internal/publish/database"
 	"github.com/google/exposure-notifications-server/internal/secrets"
 	"github.com/google/exposure-notifications-server/internal/setup"
 )
 
 // Compile-time check to assert this config matches requirements.
+var _ setup.BlocklistConfigProvider = (*Config)(nil)
 var _ setup.DatabaseConfigProvider = (*Config)(nil)
 var _ setup.SecretManagerConfigProvider = (*Config)(nil)
 
 // Config is the configuration for the federation components (data sent to other servers).
 type Config struct {
+	Blocklist     blocklist.Config
 	Database      database.Config
 	SecretManager secrets.Config
 
@@ -35,6 +39,188 @@ type Config struct {
 	Timeout        time.Duration `envconfig:"RPC_TIMEOUT" default:"5m"`
 	TruncateWindow time.Duration `envconfig:"TRUNCATE_WINDOW" default:"1h"`
 
+	// MaxTimeout allows clients to override Timeout, e.g. for large batch jobs, by sending the
+	// x-fetch-timeout metadata with a duration such as "15m". Requested timeouts above MaxTimeout are
+	// clamped to it. Zero ignores the metadata. The client's own gRPC deadline still applies.
+	MaxTimeout time.Duration `envconfig:"MAX_RPC_TIMEOUT" default:"0s"`
+
+	// MaxConnectionAge, if positive, is how long a client connection is kept before the server asks
+	// the client to reconnect, so that long-lived partner connections are rebalanced. Fetches in
+	// progress are drained for up to MaxConnectionAgeGrace before the connection is closed; zero
+	// waits for them however long they take. A positive grace must cover the longest fetch.
+	MaxConnectionAge      time.Duration `envconfig:"MAX_CONNECTION_AGE" default:"0s"`
+	MaxConnectionAgeGrace time.Duration `envconfig:"MAX_CONNECTION_AGE_GRACE" default:"0s"`
+
+	// KeepaliveTime and KeepaliveTimeout are how long a connection may be idle before the server
+	// pings the client, and how long it waits for the ping to be acknowledged before closing the
+	// connection. Zero uses the gRPC defaults.
+	KeepaliveTime    time.Duration `envconfig:"KEEPALIVE_TIME" default:"0s"`
+	KeepaliveTimeout time.Duration `envconfig:"KEEPALIVE_TIMEOUT" default:"0s"`
+
+	// SinceOverlap is subtracted from a client's lastFetchResponseKeyTimestamp, so that each fetch
+	// re-reads the tail of the previous one. This guarantees that a key committed late, with a
+	// CreatedAt before the client's timestamp, is still served. Clients must tolerate seeing the
+	// same key again, e.g. by ignoring keys they already have.
+	SinceOverlap time.Duration `envconfig:"SINCE_OVERLAP" default:"0s"`
+
+	// MinRemainingTime is the minimum time that must be left on the request deadline for a
+	// fetch to be attempted. Requests arriving with less time are rejected with DeadlineExceeded
+	// rather than doing partial work. Zero, the default, disables the check.
+	MinRemainingTime time.Duration `envconfig:"MIN_REMAINING_TIME" default:"0s"`
+
+	// IteratorTimeout is the maximum time to wait for the database to produce the next exposure.
+	// If exceeded, the fetch is cancelled, so that a stuck query cannot hold the request open. Zero,
+	// the default, disables the check.
+	IteratorTimeout time.Duration `envconfig:"ITERATOR_TIMEOUT" default:"0s"`
+
+	// SlowFetchRate, if positive, cuts a fetch to a partial response early when the database is
+	// producing keys slowly, rather than holding the connection open until the deadline. Once
+	// SlowFetchCutoff, a fraction from 0 to 1, of the fetch's time has passed, the fetch is cut as soon
+	// as fewer than SlowFetchRate keys per second were produced over the last SlowFetchWindow. A fetch
+	// producing keys faster runs until its deadline.
+	SlowFetchRate   float64       `envconfig:"SLOW_FETCH_RATE" default:"0"`
+	SlowFetchWindow time.Duration `envconfig:"SLOW_FETCH_WINDOW" default:"10s"`
+	SlowFetchCutoff float64       `envconfig:"SLOW_FETCH_CUTOFF" default:"0.5"`
+
+	// MaxResponseGroups is the maximum number of distinct region sets (ContactTracingResponse
+	// entries) in a single fetch response. When the limit is reached, a partial response is
+	// returned with a nextFetchToken. Zero, the default, means no limit.
+	MaxResponseGroups int `envconfig:"MAX_RESPONSE_GROUPS" default:"0"`
+
+	// MaxResponseBytes is the maximum size of the keys in a single fetch response, e.g. 3145728 to
+	// stay within the client's gRPC maximum receive size (4MiB by default). When the limit would be
+	// exceeded, a partial response is returned with a nextFetchToken. A client's authorization may
+	// override it. Revocations, which are only sent with the final page, are not counted. Zero, the
+	// default, means no limit.
+	MaxResponseBytes int `envconfig:"MAX_RESPONSE_BYTES" default:"0"`
+
+	// MaxKeyFilterBytes and MaxKeyFilterHashCount bound the knownKeys bloom filter a client may send
+	// to skip keys it already holds, since testing each key costs MaxKeyFilterHashCount lookups. The
+	// default admits a filter of about a million keys at a 1% false positive rate. Requests with a
+	// larger filter are rejected. A MaxKeyFilterBytes of zero disables knownKeys.
+	MaxKeyFilterBytes     int `envconfig:"MAX_KEY_FILTER_BYTES" default:"1310720"`
+	MaxKeyFilterHashCount int `envconfig:"MAX_KEY_FILTER_HASH_COUNT" default:"10"`
+
+	// StrictExclude controls how excluded regions are applied. By default (lenient), a key is
+	// skipped only if ALL of its regions are excluded, so a key published to both an included and an
+	// excluded region is still served. If StrictExclude is true, a key is skipped if ANY of its
+	// regions is excluded.
+	StrictExclude bool `envconfig:"STRICT_EXCLUDE" default:"false"`
+
+	// RegionPrecedence decides a region which is both included and excluded, e.g. when a client
+	// requests a region its authorization excludes. With "exclude-wins" the region is excluded; with
+	// "include-wins" it is treated as not excluded, but only if it is included by the same region or
+	// wildcard which excludes it: including "US-*" does not override excluding "US-CA".
+	RegionPrecedence string `envconfig:"REGION_PRECEDENCE" default:"exclude-wins"`
+
+	// StrictValidation rejects, with InvalidArgument, a fetch whose filters exclude every region it
+	// includes, e.g. from the client's authorization, rather than returning an empty response. Under
+	// lenient StrictExclude such a fetch would still serve keys also published to other regions, but
+	// it is almost certainly misconfigured either way.
+	StrictValidation bool `envconfig:"STRICT_VALIDATION" default:"false"`
+
+	// StrictIntervalCount skips keys whose IntervalCount is inconsistent with their age when they
+	// were published, e.g. a key from a previous day which does not cover the full day. Such keys
+	// are normally rejected on publish, but may predate enabling the check there.
+	StrictIntervalCount bool `envconfig:"STRICT_INTERVAL_COUNT" default:"false"`
+
+	// MaxRegions is the maximum number of regions a fetch may include, and separately exclude, so that
+	// a pathologically long region list is rejected before any work is done on it. Zero, the default,
+	// means no limit.
+	MaxRegions int `envconfig:"MAX_REGIONS" default:"0"`
+
+	// MaxRegionsPerKey is the maximum number of regions a single key may have. Stored keys with more,
+	// e.g. from a malformed upload, are skipped by fetch rather than sorted and grouped, and uploaded
+	// keys with more are rejected. Zero, the default, means no limit.
+	MaxRegionsPerKey int `envconfig:"MAX_REGIONS_PER_KEY" default:"0"`
+
+	// MaxConcurrentFetches is the maximum number of fetches processed at once. Requests over the limit
+	// wait up to ConcurrentFetchWait for a slot and are then rejected with ResourceExhausted. Zero,
+	// the default, means no limit.
+	MaxConcurrentFetches int           `envconfig:"MAX_CONCURRENT_FETCHES" default:"0"`
+	ConcurrentFetchWait  time.Duration `envconfig:"CONCURRENT_FETCH_WAIT" default:"10s"`
+
+	// ResponseCacheTTL, if non-zero, caches complete fetch responses of at most ResponseCacheMaxKeys
+	// keys for this long, up to ResponseCacheSize responses, so that identical polls by many partners
+	// do not each query the database. A cached response is only served while nothing newer has been
+	// published in its regions. Responses including tombstones are not cached.
+	ResponseCacheTTL     time.Duration `envconfig:"RESPONSE_CACHE_TTL" default:"0s"`
+	ResponseCacheSize    int           `envconfig:"RESPONSE_CACHE_SIZE" default:"1000"`
+	ResponseCacheMaxKeys int           `envconfig:"RESPONSE_CACHE_MAX_KEYS" default:"100"`
+
+	// CursorKey is a base64 encoded AES key (16, 24 or 32 bytes) used to encrypt and authenticate
+	// the nextFetchToken returned to clients, so that forged tokens are rejected. If empty, tokens are
+	// returned as is. To rotate, move the current key to PreviousCursorKey and set a new CursorKey;
+	// tokens sealed with either key are accepted. Both may be secret:// references.
+	CursorKey         string `envconfig:"CURSOR_KEY"`
+	PreviousCursorKey string `envconfig:"PREVIOUS_CURSOR_KEY"`
+
+	// StrictKeyBytes skips keys which are not exactly 16 raw bytes, such as keys mistakenly stored as
+	// base64 text by a faulty migration, rather than serving them malformed.
+	StrictKeyBytes bool `envconfig:"STRICT_KEY_BYTES" default:"false"`
+
+	// PartnerMetrics also writes the paging and refresh counters, such as federation-fetch-resumed,
+	// once per client, named with the client's OIDC subject appended, e.g.
+	// federation-fetch-resumed/<subject>. Only enable it if the number of clients is small.
+	PartnerMetrics bool `envconfig:"PARTNER_METRICS" default:"false"`
+
+	// PartialStreakThreshold is the number of consecutive partial responses to a client after which a
+	// warning is logged and federation-fetch-partial-streak is counted, and again after each further
+	// PartialStreakThreshold, so that operators can advise the client. A complete response resets the
+	// count. Zero disables tracking.
+	PartialStreakThreshold int `envconfig:"PARTIAL_STREAK_THRESHOLD" default:"20"`
+
+	// ExplainQueries logs the database query plan for a sample of fetches, to help find missing
+	// indexes for particular region and time combinations. ExplainSampleRate is the fraction of
+	// fetches, from 0 to 1, that are explained. Explaining runs the query twice.
+	ExplainQueries    bool    `envconfig:"EXPLAIN_QUERIES" default:"false"`
+	ExplainSampleRate float64 `envconfig:"EXPLAIN_SAMPLE_RATE" default:"0.01"`
+
+	// DumpSkippedKeys logs the details of a sample of the keys a fetch skips, with the key itself
+	// redacted to a short prefix, and why it was skipped, so that a rise in skipped keys can be
+	// investigated from representative examples. SkippedKeySampleRate is the fraction of skipped keys,
+	// from 0 to 1, that are logged.
+	DumpSkippedKeys      bool    `envconfig:"DUMP_SKIPPED_KEYS" default:"false"`
+	SkippedKeySampleRate float64 `envconfig:"SKIPPED_KEY_SAMPLE_RATE" default:"0.001"`
+
+	// AllowUploads enables the Upload endpoint, through which partners push keys rather than having them
+	// fetched. Only authenticated partners whose authorization has AllowUpload may upload, and only into
+	// the regions it includes. Uploaded keys are validated like published keys, and must be no older
+	// than UploadMaxIntervalAge.
+	AllowUploads         bool          `envconfig:"ALLOW_UPLOADS" default:"false"`
+	UploadMaxIntervalAge time.Duration `envconfig:"UPLOAD_MAX_INTERVAL_AGE" default:"360h"`
+
+	// UploadPreserveCreatedAt keeps the createdTimestamp sent with an uploaded key, so that it is
+	// batched by when the source server received it rather than when it was uploaded here. The
+	// timestamp must be between the start of the key's interval and the time of the upload;
+	// otherwise, or if false, the upload time is used. It is off by default, since a key created in a
+	// window which has already been batched for export is not exported.
+	UploadPreserveCreatedAt bool `envconfig:"UPLOAD_PRESERVE_CREATED_AT" default:"false"`
+
+	// MaxIntervalCount clamps the IntervalCount of uploaded keys, as federationin does for pulled keys,
+	// so that keys a partner sends with a longer validity than clients accept are stored in spec rather
+	// than rejected. Zero validates IntervalCounts unchanged.
+	MaxIntervalCount int32 `envconfig:"MAX_INTERVAL_COUNT" default:"144"`
+
+	// RegionKeyLimits bounds how many keys are accepted per region per day, counted together with
+	// keys published in the same database. An upload which would exceed a limit fails with
+	// ResourceExhausted; batches stored before it remain stored.
+	RegionKeyLimits publishdb.RegionKeyLimits
+
+	// GroupInsertsByRegion, if true, inserts each batch of uploaded keys as one database batch per
+	// set of regions, rather than one statement per key.
+	GroupInsertsByRegion bool `envconfig:"GROUP_INSERTS_BY_REGION" default:"false"`
+
+	// RevocationGracePeriod is how long after a key is purged it is still served in revokedKeys, so
+	// that clients fetching at least that often receive the revocation. Older revocations are
+	// dropped from responses, and their tombstones are later deleted by cleanup. Zero serves them
+	// until they are deleted.
+	RevocationGracePeriod time.Duration `envconfig:"REVOCATION_GRACE_PERIOD" default:"0s"`
+
+	// AdminPort, if set, serves HTTP administration endpoints, such as POST /admin/reload-blocklist to
+	// reload the key blocklist. It must not be reachable by federation clients.
+	AdminPort string `envconfig:"ADMIN_PORT"`
+
 	// AllowAnyClient, if true, removes authentication requirements on the federation endpoint.
 	// In practise, this is only useful in local testing.
 	AllowAnyClient bool `envconfig:"ALLOW_ANY_CLIENT" default:"false"`
@@ -46,6 +232,10 @@ type Config struct {
 	TLSKeyFile  string `envconfig:"TLS_KEY_FILE"`
 }
 
+func (c *Config) BlocklistConfig() *blocklist.Config {
+	return &c.Blocklist
+}
+
 func (c *Config) DatabaseConfig() *database.Config {
 	return &c.Database
 }
@@ -0,0 +1,129 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federationout
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/google/exposure-notifications-server/internal/base64util"
+)
+
+// errForgedCursor is returned when a nextFetchToken was not sealed by this server.
+var errForgedCursor = errors.New("cursor failed authentication")
+
+// cursorCodec encrypts and authenticates the nextFetchToken handed to federation clients,
+// so that a client can neither inspect nor forge the underlying database cursor.
+//
+// Tokens are always sealed with the current key. During key rotation, tokens sealed with
+// the previous key are still accepted so that in-flight paginated fetches can complete.
+type cursorCodec struct {
+	keys []cipher.AEAD // current key first
+}
+
+// newCursorCodec creates a cursorCodec from base64 encoded AES keys. previous may be empty.
+// If current is empty, cursor encryption is disabled and nil is returned.
+func newCursorCodec(current, previous string) (*cursorCodec, error) {
+	if current == "" {
+		if previous != "" {
+			return nil, fmt.Errorf("previous cursor key provided without a current key")
+		}
+		return nil, nil
+	}
+
+	c := &cursorCodec{}
+	for _, k := range []string{current, previous} {
+		if k == "" {
+			continue
+		}
+		aead, err := newCursorAEAD(k)
+		if err != nil {
+			return nil, err
+		}
+		c.keys = append(c.keys, aead)
+	}
+	return c, nil
+}
+
+func newCursorAEAD(encoded string) (cipher.AEAD, error) {
+	key, err := base64util.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("decoding cursor key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("creating cursor cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("creating cursor cipher: %w", err)
+	}
+	return aead, nil
+}
+
+// seal encrypts cursor with the current key. The namespace is bound to the token as
+// additional data, so a token issued to one namespace cannot be replayed in another.
+func (c *cursorCodec) seal(cursor, namespace string) (string, error) {
+	if cursor == "" {
+		return "", nil
+	}
+	aead := c.keys[0]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", fmt.Errorf("generating nonce: %w", err)
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(cursor), []byte(namespace))
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// open decrypts a token produced by seal, trying each configured key in turn.
+func (c *cursorCodec) open(token, namespace string) (string, error) {
+	if token == "" {
+		return "", nil
+	}
+	sealed, err := base64util.DecodeString(token)
+	if err != nil {
+		return "", fmt.Errorf("decoding cursor: %w", err)
+	}
+	for _, aead := range c.keys {
+		if len(sealed) < aead.NonceSize() {
+			continue
+		}
+		nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+		if plain, err := aead.Open(nil, nonce, ciphertext, []byte(namespace)); err == nil {
+			return string(plain), nil
+		}
+	}
+	return "", errForgedCursor
+}
+
+// OpenCursor returns the database cursor sealed in a nextFetchToken that was issued to a client in
+// namespace, given the server's CursorKey and PreviousCursorKey. If cursor encryption is disabled
+// (key is empty) the token is returned as is. It is intended for operator tooling.
+func OpenCursor(token, namespace, key, previousKey string) (string, error) {
+	c, err := newCursorCodec(key, previousKey)
+	if err != nil {
+		return "", err
+	}
+	if c == nil {
+		return token, nil
+	}
+	return c.open(token, namespace)
+}
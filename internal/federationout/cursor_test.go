@@ -0,0 +1,197 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federationout
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/pb"
+	"github.com/google/exposure-notifications-server/internal/publish/database"
+	"github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	cursorKey1 = base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	cursorKey2 = base64.StdEncoding.EncodeToString([]byte("fedcba9876543210fedcba9876543210"))
+)
+
+func mustCursorCodec(t *testing.T, current, previous string) *cursorCodec {
+	t.Helper()
+	c, err := newCursorCodec(current, previous)
+	if err != nil {
+		t.Fatalf("newCursorCodec: %v", err)
+	}
+	return c
+}
+
+// TestNewCursorCodec tests the validation of configured cursor keys.
+func TestNewCursorCodec(t *testing.T) {
+	testCases := []struct {
+		name     string
+		current  string
+		previous string
+		wantNil  bool
+		wantErr  bool
+	}{
+		{name: "disabled", wantNil: true},
+		{name: "current only", current: cursorKey1},
+		{name: "rotating", current: cursorKey2, previous: cursorKey1},
+		{name: "previous without current", previous: cursorKey1, wantErr: true},
+		{name: "not base64", current: "not*base64", wantErr: true},
+		{name: "bad key length", current: base64.StdEncoding.EncodeToString([]byte("short")), wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := newCursorCodec(tc.current, tc.previous)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("newCursorCodec() err=%v, wantErr=%t", err, tc.wantErr)
+			}
+			if !tc.wantErr && (c == nil) != tc.wantNil {
+				t.Errorf("newCursorCodec() returned %v, wantNil=%t", c, tc.wantNil)
+			}
+		})
+	}
+}
+
+// TestCursorCodec tests sealing and opening cursors, including across a key rotation.
+func TestCursorCodec(t *testing.T) {
+	old := mustCursorCodec(t, cursorKey1, "")
+	token, err := old.seal("12345", "ns")
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	if strings.Contains(token, "12345") {
+		t.Errorf("sealed token %q exposes the cursor", token)
+	}
+	tampered := []byte(token)
+	tampered[len(tampered)-1] ^= 'A' ^ 'B'
+
+	testCases := []struct {
+		name      string
+		codec     *cursorCodec
+		token     string
+		namespace string
+		want      string
+		wantErr   bool
+	}{
+		{name: "valid", codec: old, token: token, namespace: "ns", want: "12345"},
+		{name: "empty", codec: old, token: "", namespace: "ns"},
+		{name: "tampered", codec: old, token: string(tampered), namespace: "ns", wantErr: true},
+		{name: "forged", codec: old, token: "MTIzNDU", namespace: "ns", wantErr: true},
+		{name: "other namespace", codec: old, token: token, namespace: "other", wantErr: true},
+		{name: "during rotation", codec: mustCursorCodec(t, cursorKey2, cursorKey1), token: token, namespace: "ns", want: "12345"},
+		{name: "after rotation", codec: mustCursorCodec(t, cursorKey2, ""), token: token, namespace: "ns", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.codec.open(tc.token, tc.namespace)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("open() returned %q, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("open() returned err=%v", err)
+			}
+			if got != tc.want {
+				t.Errorf("open() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+
+	// Tokens are always issued with the current key.
+	rotated := mustCursorCodec(t, cursorKey2, cursorKey1)
+	token, err = rotated.seal("12345", "ns")
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	if _, err := mustCursorCodec(t, cursorKey2, "").open(token, "ns"); err != nil {
+		t.Errorf("token sealed during rotation not accepted by new key: %v", err)
+	}
+}
+
+// TestFetchCursorEncryption tests that fetch() seals the nextFetchToken it returns, accepts it on
+// the following request and rejects a forged token with InvalidArgument.
+func TestFetchCursorEncryption(t *testing.T) {
+	ctx := context.Background()
+	env := serverenv.New(ctx)
+	server := Server{env: env, config: &Config{MaxResponseGroups: 1}, cursors: mustCursorCodec(t, cursorKey1, "")}
+
+	deps := testDeps([]interface{}{
+		makeExposure(aaa, 1, "US"),
+		makeExposure(bbb, 1, "CA"),
+	})
+	got, err := server.fetch(ctx, &pb.FederationFetchRequest{}, deps, time.Now())
+	if err != nil {
+		t.Fatalf("fetch() returned err=%v, want err=nil", err)
+	}
+	if !got.PartialResponse || got.NextFetchToken == "" {
+		t.Fatalf("fetch() returned %v, want a partial response", got)
+	}
+	if got.NextFetchToken == "bbb_cursor" {
+		t.Fatalf("fetch() returned an unsealed nextFetchToken")
+	}
+
+	// The sealed token decodes to the database cursor.
+	var gotCursor string
+	deps.iterateExposures = func(_ context.Context, criteria database.IterateExposuresCriteria, _ func(*model.Exposure) error) (string, error) {
+		gotCursor = criteria.LastCursor
+		return "", nil
+	}
+	if _, err := server.fetch(ctx, &pb.FederationFetchRequest{NextFetchToken: got.NextFetchToken}, deps, time.Now()); err != nil {
+		t.Fatalf("fetch() with valid token returned err=%v", err)
+	}
+	if gotCursor != "bbb_cursor" {
+		t.Errorf("iterated with cursor %q, want %q", gotCursor, "bbb_cursor")
+	}
+
+	// A client supplied cursor is rejected.
+	_, err = server.fetch(ctx, &pb.FederationFetchRequest{NextFetchToken: "bbb_cursor"}, deps, time.Now())
+	if !errors.Is(err, ErrCursor) {
+		t.Fatalf("fetch() with forged token returned err=%v, want %v", err, ErrCursor)
+	}
+	if code := status.Code(fetchStatus(err)); code != codes.InvalidArgument {
+		t.Errorf("fetchStatus() code=%v, want=%v", code, codes.InvalidArgument)
+	}
+}
+
+// TestOpenCursor tests opening tokens for operator tooling.
+func TestOpenCursor(t *testing.T) {
+	token, err := mustCursorCodec(t, cursorKey1, "").seal("12345", "ns")
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+
+	if got, err := OpenCursor(token, "ns", cursorKey2, cursorKey1); err != nil || got != "12345" {
+		t.Errorf("OpenCursor() = %q, %v, want %q", got, err, "12345")
+	}
+	if got, err := OpenCursor("12345", "ns", "", ""); err != nil || got != "12345" {
+		t.Errorf("OpenCursor() without keys = %q, %v, want %q", got, err, "12345")
+	}
+	if _, err := OpenCursor(token+"x", "ns", cursorKey1, ""); err == nil {
+		t.Errorf("OpenCursor() of corrupt token succeeded")
+	}
+}
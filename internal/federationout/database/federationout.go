@@ -17,6 +17,7 @@ package database
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/google/exposure-notifications-server/internal/database"
 	"github.com/google/exposure-notifications-server/internal/federationin/model"
@@ -39,15 +40,15 @@ func (db *FederationOutDB) AddFederationOutAuthorization(ctx context.Context, au
 		q := `
 			INSERT INTO
 				FederationOutAuthorization
-				(oidc_issuer, oidc_subject, oidc_audience, note, include_regions, exclude_regions)
+				(oidc_issuer, oidc_subject, oidc_audience, note, include_regions, exclude_regions, namespace, allow_historical, max_response_bytes, allow_backfill, min_key_age_seconds, allow_upload)
 			VALUES
-				($1, $2, $3, $4, $5, $6)
+				($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
 			ON CONFLICT ON CONSTRAINT
 				federation_authorization_pk
 			DO UPDATE
-				SET oidc_audience = $3, note = $4, include_regions = $5, exclude_regions = $6
+				SET oidc_audience = $3, note = $4, include_regions = $5, exclude_regions = $6, namespace = $7, allow_historical = $8, max_response_bytes = $9, allow_backfill = $10, min_key_age_seconds = $11, allow_upload = $12
 		`
-		_, err := tx.Exec(ctx, q, auth.Issuer, auth.Subject, auth.Audience, auth.Note, auth.IncludeRegions, auth.ExcludeRegions)
+		_, err := tx.Exec(ctx, q, auth.Issuer, auth.Subject, auth.Audience, auth.Note, auth.IncludeRegions, auth.ExcludeRegions, auth.Namespace, auth.AllowHistorical, auth.MaxResponseBytes, auth.AllowBackfill, int(auth.MinKeyAge.Seconds()), auth.AllowUpload)
 		if err != nil {
 			return fmt.Errorf("upserting federation authorization: %w", err)
 		}
@@ -65,7 +66,7 @@ func (db *FederationOutDB) GetFederationOutAuthorization(ctx context.Context, is
 
 	row := conn.QueryRow(ctx, `
 		SELECT
-			oidc_issuer, oidc_subject, oidc_audience, note, include_regions, exclude_regions
+			oidc_issuer, oidc_subject, oidc_audience, note, include_regions, exclude_regions, namespace, allow_historical, max_response_bytes, allow_backfill, min_key_age_seconds, allow_upload
 		FROM
 			FederationOutAuthorization
 		WHERE
@@ -75,11 +76,61 @@ func (db *FederationOutDB) GetFederationOutAuthorization(ctx context.Context, is
 		LIMIT 1
 		`, issuer, subject)
 	auth := model.FederationOutAuthorization{}
-	if err := row.Scan(&auth.Issuer, &auth.Subject, &auth.Audience, &auth.Note, &auth.IncludeRegions, &auth.ExcludeRegions); err != nil {
+	var minKeyAgeSeconds int
+	if err := row.Scan(&auth.Issuer, &auth.Subject, &auth.Audience, &auth.Note, &auth.IncludeRegions, &auth.ExcludeRegions, &auth.Namespace, &auth.AllowHistorical, &auth.MaxResponseBytes, &auth.AllowBackfill, &minKeyAgeSeconds, &auth.AllowUpload); err != nil {
 		if err == pgx.ErrNoRows {
 			return nil, database.ErrNotFound
 		}
 		return nil, fmt.Errorf("scanning results: %w", err)
 	}
+	auth.MinKeyAge = time.Duration(minKeyAgeSeconds) * time.Second
 	return &auth, nil
 }
+
+// WriteFetchAudit records a fetch served to a federation client. On success, audit.AuditID is set.
+func (db *FederationOutDB) WriteFetchAudit(ctx context.Context, audit *model.FederationOutAudit) error {
+	conn, err := db.db.Pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("acquiring connection: %w", err)
+	}
+	defer conn.Release()
+
+	row := conn.QueryRow(ctx, `
+		INSERT INTO
+			FederationOutAudit
+			(oidc_issuer, oidc_subject, include_regions, exclude_regions, since_timestamp, until_timestamp,
+			 keys_served, partial_response, fetched_at)
+		VALUES
+			($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING audit_id
+		`, audit.Issuer, audit.Subject, audit.IncludeRegions, audit.ExcludeRegions, audit.SinceTimestamp, audit.UntilTimestamp,
+		audit.KeysServed, audit.PartialResponse, audit.FetchedAt)
+	if err := row.Scan(&audit.AuditID); err != nil {
+		return fmt.Errorf("inserting federation audit: %w", err)
+	}
+	return nil
+}
+
+// Generation returns the generation of the key database, which changes whenever it is rebuilt.
+func (db *FederationOutDB) Generation(ctx context.Context) (string, error) {
+	conn, err := db.db.Pool.Acquire(ctx)
+	if err != nil {
+		return "", fmt.Errorf("acquiring connection: %w", err)
+	}
+	defer conn.Release()
+
+	var generation string
+	row := conn.QueryRow(ctx, `
+		SELECT
+			generation
+		FROM
+			ServerGeneration
+		`)
+	if err := row.Scan(&generation); err != nil {
+		if err == pgx.ErrNoRows {
+			return "", database.ErrNotFound
+		}
+		return "", fmt.Errorf("reading server generation: %w", err)
+	}
+	return generation, nil
+}
@@ -18,11 +18,13 @@ import (
 	"context"
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/google/exposure-notifications-server/internal/database"
 	"github.com/google/exposure-notifications-server/internal/federationin/model"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 )
 
 // TestFederationOutAuthorization tests the functions accessing the FederationOutAuthorization table.
@@ -39,6 +41,8 @@ func TestFederationOutAuthorization(t *testing.T) {
 		Note:           "some note",
 		IncludeRegions: []string{"MX"},
 		ExcludeRegions: []string{"CA"},
+		MinKeyAge:      24 * time.Hour,
+		AllowUpload:    true,
 	}
 
 	// GetFederationOutAuthorization should fail if not found.
@@ -70,3 +74,76 @@ func TestFederationOutAuthorization(t *testing.T) {
 		t.Errorf("mismatch (-want, +got):\n%s", diff)
 	}
 }
+
+// TestWriteFetchAudit tests writing to the FederationOutAudit table.
+func TestWriteFetchAudit(t *testing.T) {
+	t.Parallel()
+
+	testDB := database.NewTestDatabase(t)
+	ctx := context.Background()
+
+	fetchedAt := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	want := &model.FederationOutAudit{
+		Issuer:          "iss",
+		Subject:         "sub",
+		IncludeRegions:  []string{"US"},
+		ExcludeRegions:  []string{"CA"},
+		SinceTimestamp:  fetchedAt.Add(-2 * time.Hour),
+		UntilTimestamp:  fetchedAt.Add(-1 * time.Hour),
+		KeysServed:      42,
+		PartialResponse: true,
+		FetchedAt:       fetchedAt,
+	}
+	if err := New(testDB).WriteFetchAudit(ctx, want); err != nil {
+		t.Fatal(err)
+	}
+	if want.AuditID == 0 {
+		t.Errorf("WriteFetchAudit did not set AuditID")
+	}
+
+	got := &model.FederationOutAudit{}
+	row := testDB.Pool.QueryRow(ctx, `
+		SELECT
+			audit_id, oidc_issuer, oidc_subject, include_regions, exclude_regions, since_timestamp, until_timestamp,
+			keys_served, partial_response, fetched_at
+		FROM
+			FederationOutAudit
+		WHERE
+			audit_id = $1
+		`, want.AuditID)
+	if err := row.Scan(&got.AuditID, &got.Issuer, &got.Subject, &got.IncludeRegions, &got.ExcludeRegions, &got.SinceTimestamp,
+		&got.UntilTimestamp, &got.KeysServed, &got.PartialResponse, &got.FetchedAt); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got, cmpopts.EquateApproxTime(time.Microsecond)); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestGeneration(t *testing.T) {
+	t.Parallel()
+
+	testDB := database.NewTestDatabase(t)
+	ctx := context.Background()
+	db := New(testDB)
+
+	first, err := db.Generation(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == "" {
+		t.Fatalf("Generation returned an empty generation")
+	}
+
+	// A rebuilt database has a new generation.
+	if _, err := testDB.Pool.Exec(ctx, `UPDATE ServerGeneration SET generation = md5(random()::text)`); err != nil {
+		t.Fatal(err)
+	}
+	second, err := db.Generation(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second == first {
+		t.Errorf("Generation after reset = %q, want a new generation", second)
+	}
+}
@@ -16,15 +16,21 @@
 package federationout
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"math/rand"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/google/exposure-notifications-server/internal/blocklist"
 	"github.com/google/exposure-notifications-server/internal/federationin/model"
 	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/metrics"
 	"github.com/google/exposure-notifications-server/internal/pb"
 
 	coredb "github.com/google/exposure-notifications-server/internal/database"
@@ -33,64 +39,445 @@ import (
 	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
 
 	"github.com/google/exposure-notifications-server/internal/serverenv"
+	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1alpha1"
+	"go.opencensus.io/plugin/ocgrpc"
 	"google.golang.org/api/idtoken"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/stats"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	// Register the gzip and zstd codecs, so that partners may request compressed responses.
+	_ "github.com/google/exposure-notifications-server/internal/zstd"
+	_ "google.golang.org/grpc/encoding/gzip"
+)
+
+const (
+	authHeader    = "authorization"
+	bearer        = "Bearer"
+	timeoutHeader = "x-fetch-timeout"
+
+	// Trailers summarizing a fetch response.
+	keyCountTrailer    = "x-fetch-key-count"
+	regionCountTrailer = "x-fetch-region-count"
+
+	// regionCheckInterval is how many regions of a single key are filtered between checks for
+	// cancellation.
+	regionCheckInterval = 256
+
+	// auditTimeout bounds writing the audit record, which happens after the fetch deadline may have passed.
+	auditTimeout = 5 * time.Second
 )
 
+// Values of Config.RegionPrecedence.
 const (
-	authHeader = "authorization"
-	bearer     = "Bearer"
+	RegionPrecedenceExcludeWins = "exclude-wins"
+	RegionPrecedenceIncludeWins = "include-wins"
 )
 
+var (
+	// ErrQuery indicates that the exposure database could not be queried.
+	ErrQuery = errors.New("querying exposures")
+
+	// ErrIterate indicates that iterating the query results failed part way through.
+	ErrIterate = errors.New("iterating results")
+
+	// ErrCursor indicates that the nextFetchToken on the request could not be used.
+	ErrCursor = errors.New("invalid fetch token")
+)
+
+// errIteratorStalled is returned when the exposure iterator makes no progress within Config.IteratorTimeout.
+var errIteratorStalled = errors.New("exposure iterator stalled")
+
+// errResponseGroupLimit is returned from the iteration callback to stop once
+// the response contains Config.MaxResponseGroups region sets.
+var errResponseGroupLimit = errors.New("response group limit reached")
+
+// errResponseSizeLimit is returned from the iteration callback to stop before
+// the response would exceed its size limit, see Server.maxResponseBytes.
+var errResponseSizeLimit = errors.New("response size limit reached")
+
+// responseSizeReserve is set aside from the response size limit for the fields set once the keys
+// are added, such as nextFetchToken and the summary counts.
+const responseSizeReserve = 256
+
+// fetchError classifies a fetch failure as one of ErrQuery, ErrIterate or ErrCursor,
+// while preserving the underlying cause.
+type fetchError struct {
+	kind error
+	err  error
+}
+
+func (e *fetchError) Error() string {
+	return fmt.Sprintf("%v: %v", e.kind, e.err)
+}
+
+func (e *fetchError) Unwrap() error {
+	return e.err
+}
+
+func (e *fetchError) Is(target error) bool {
+	return target == e.kind
+}
+
 // Compile time assert that this server implements the required grpc interface.
 var _ pb.FederationServer = (*Server)(nil)
 
 type iterateExposuresFunc func(context.Context, publishdb.IterateExposuresCriteria, func(*publishmodel.Exposure) error) (string, error)
+type latestCreatedAtFunc func(context.Context, []string) (time.Time, error)
+type writeFetchAuditFunc func(context.Context, *model.FederationOutAudit) error
+type iterateTombstonesFunc func(context.Context, publishdb.IterateTombstonesCriteria, func(*publishmodel.ExposureTombstone) error) error
+
+// ctiKey identifies a ContactTracingInfo within the response being assembled.
+// Keying on the ContactTracingResponse itself avoids building a string for every exposure.
+type ctiKey struct {
+	ctr              *pb.ContactTracingResponse
+	transmissionRisk int
+}
+
+type fetchDependencies struct {
+	iterateExposures  iterateExposuresFunc
+	iterateTombstones iterateTombstonesFunc
+	latestCreatedAt   latestCreatedAtFunc
+	writeFetchAudit   writeFetchAuditFunc
+
+	// filters are the criteria iterateExposures applies itself; the rest are applied in memory.
+	filters publishdb.FilterSupport
+}
+
+// KeyTransformFunc post-processes an exposure before it is served to a federation client.
+// It returns the exposure to serve, which may be modified, or false to drop the exposure.
+type KeyTransformFunc func(*publishmodel.Exposure) (*publishmodel.Exposure, bool)
+
+// Option configures optional behavior of the Server.
+type Option func(*Server)
+
+// WithKeyTransform installs a KeyTransformFunc which is invoked on every exposure before it is served.
+func WithKeyTransform(f KeyTransformFunc) Option {
+	return func(s *Server) {
+		s.keyTransform = f
+	}
+}
+
+// ExposureStore is where the server reads the exposures it serves, and the
+// watermarks and tombstones that go with them, such as a publishdb.PublishDB or
+// a publishdb.ShardedExposures.
+type ExposureStore interface {
+	publishdb.ExposureIterator
+	publishdb.LatestReporter
+	publishdb.OldestReporter
+	publishdb.TombstoneIterator
+}
+
+// Compile time assert that both stores can be served from.
+var (
+	_ ExposureStore = (*publishdb.PublishDB)(nil)
+	_ ExposureStore = (*publishdb.ShardedExposures)(nil)
+)
+
+// WithExposureIterator serves exposures from it, such as a
+// publishdb.ShardedExposures, instead of the server's database.
+func WithExposureIterator(it ExposureStore) Option {
+	return func(s *Server) {
+		s.exposures = it
+	}
+}
+
+// WithGeneration sets the generation of the key database the server serves from, see
+// database.FederationOutDB.Generation. Tokens issued by the server are bound to it, so that clients
+// resuming from a previous generation are told to start over rather than resume into a rebuilt
+// database. Without it, generations are not tracked.
+func WithGeneration(generation string) Option {
+	return func(s *Server) {
+		s.generation = generation
+	}
+}
+
+// StatsHandler returns the gRPC stats handler for the federation server. Partners are authenticated,
+// so the trace context they propagate in the grpc-trace-bin metadata is trusted: the server's spans
+// continue the sender's trace, rather than starting a new one linked to it.
+func StatsHandler() stats.Handler {
+	return &ocgrpc.ServerHandler{IsPublicEndpoint: false}
+}
+
+// KeepaliveOptions returns the gRPC server options which recycle and probe client connections, as
+// configured.
+func KeepaliveOptions(config *Config) []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			MaxConnectionAge:      config.MaxConnectionAge,
+			MaxConnectionAgeGrace: config.MaxConnectionAgeGrace,
+			Time:                  config.KeepaliveTime,
+			Timeout:               config.KeepaliveTimeout,
+		}),
+	}
+}
 
 // NewServer builds a new FederationServer.
-func NewServer(env *serverenv.ServerEnv, config *Config) pb.FederationServer {
-	return &Server{
-		env:       env,
-		db:        database.New(env.Database()),
-		publishdb: publishdb.New(env.Database()),
-		config:    config,
+func NewServer(env *serverenv.ServerEnv, config *Config, opts ...Option) (pb.FederationServer, error) {
+	switch config.RegionPrecedence {
+	case "", RegionPrecedenceExcludeWins, RegionPrecedenceIncludeWins:
+	default:
+		return nil, fmt.Errorf("unknown region precedence %q", config.RegionPrecedence)
+	}
+	// Draining a recycled connection must not cut off the fetches on it.
+	longest := config.Timeout
+	if config.MaxTimeout > longest {
+		longest = config.MaxTimeout
+	}
+	if grace := config.MaxConnectionAgeGrace; grace > 0 && grace < longest {
+		return nil, fmt.Errorf("max connection age grace %v would cut off fetches, which may take up to %v", grace, longest)
+	}
+	if config.SlowFetchCutoff < 0 || config.SlowFetchCutoff > 1 {
+		return nil, fmt.Errorf("slow fetch cutoff must be between 0 and 1, got %v", config.SlowFetchCutoff)
 	}
+	cursors, err := newCursorCodec(config.CursorKey, config.PreviousCursorKey)
+	if err != nil {
+		return nil, fmt.Errorf("newCursorCodec: %w", err)
+	}
+	s := &Server{
+		env:          env,
+		db:           database.New(env.Database()),
+		publishdb:    publishdb.New(env.Database()).GroupInsertsByRegion(config.GroupInsertsByRegion),
+		config:       config,
+		keyBlocklist: env.KeyBlocklist(),
+		cursors:      cursors,
+		limiter:      newFetchLimiter(config.MaxConcurrentFetches, config.ConcurrentFetchWait),
+		cache:        newResponseCache(config.ResponseCacheSize, config.ResponseCacheTTL, config.ResponseCacheMaxKeys),
+		partials:     newPartialTracker(config.PartialStreakThreshold),
+	}
+	if config.DumpSkippedKeys {
+		s.skips = newSkipSampler(config.SkippedKeySampleRate)
+	}
+	s.exposures = s.publishdb
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s, nil
 }
 
 type Server struct {
-	env       *serverenv.ServerEnv
-	db        *database.FederationOutDB
-	publishdb *publishdb.PublishDB
-	config    *Config
+	env          *serverenv.ServerEnv
+	db           *database.FederationOutDB
+	publishdb    *publishdb.PublishDB
+	exposures    ExposureStore
+	config       *Config
+	keyTransform KeyTransformFunc
+	keyBlocklist *blocklist.Blocklist // nil if no keys are blocked
+	cursors      *cursorCodec         // nil if cursor encryption is disabled
+	limiter      *fetchLimiter        // nil if concurrent fetches are not limited
+	cache        *responseCache       // nil if responses are not cached
+	generation   string               // empty if generations are not tracked
+	partials     *partialTracker      // nil if partial responses are not tracked
+	skips        *skipSampler         // nil if skipped keys are not logged
 }
 
 type authKey struct{}
 
 // Fetch implements the FederationServer Fetch endpoint.
 func (s Server) Fetch(ctx context.Context, req *pb.FederationFetchRequest) (*pb.FederationFetchResponse, error) {
-	ctx, cancel := context.WithTimeout(ctx, s.config.Timeout)
+	timeout := s.fetchTimeout(ctx)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 	logger := logging.FromContext(ctx)
-	response, err := s.fetch(ctx, req, s.publishdb.IterateExposures, publishmodel.TruncateWindow(time.Now(), s.config.TruncateWindow)) // Don't fetch the current window, which isn't complete yet. TODO(squee1945): should I double this for safety?
+	metrics := s.env.MetricsExporter(ctx)
+
+	if timeout != s.config.Timeout {
+		logger.Infof("Using client requested timeout %v", timeout)
+		metrics.WriteInt("federation-fetch-timeout-override", true, 1)
+	}
+
+	if s.limiter != nil {
+		release, err := s.limiter.acquire(ctx)
+		if err != nil {
+			metrics.WriteInt("federation-fetch-rejected", true, 1)
+			logger.Warnf("Fetch rejected: %v", err)
+			return nil, err
+		}
+		defer release()
+		metrics.WriteInt("federation-fetch-concurrency", false, s.limiter.inFlight())
+	}
+
+	deps := fetchDependencies{
+		iterateExposures:  s.exposures.IterateExposures,
+		iterateTombstones: s.exposures.IterateTombstones,
+		latestCreatedAt:   s.exposures.LatestCreatedAt,
+		writeFetchAudit:   s.db.WriteFetchAudit,
+		filters:           publishdb.SupportedFilters(s.exposures),
+	}
+	response, err := s.fetch(ctx, req, deps, publishmodel.TruncateWindow(time.Now(), s.config.TruncateWindow)) // Don't fetch the current window, which isn't complete yet. TODO(squee1945): should I double this for safety?
 	if err != nil {
-		s.env.MetricsExporter(ctx).WriteInt("federation-fetch-failed", true, 1)
+		metrics.WriteInt("federation-fetch-failed", true, 1)
 		logger.Errorf("Fetch error: %v", err)
-		return nil, errors.New("internal error")
+		return nil, fetchStatus(err)
+	}
+	trailer := metadata.Pairs(
+		keyCountTrailer, strconv.FormatInt(response.KeyCount, 10),
+		regionCountTrailer, strconv.Itoa(int(response.RegionCount)))
+	if err := grpc.SetTrailer(ctx, trailer); err != nil {
+		logger.Warnf("Failed to set trailer: %v", err)
 	}
 	return response, nil
 }
 
-func (s Server) fetch(ctx context.Context, req *pb.FederationFetchRequest, itFunc iterateExposuresFunc, fetchUntil time.Time) (*pb.FederationFetchResponse, error) {
+// summarize sets the KeyCount and RegionCount of response from the keys it contains.
+func summarize(response *pb.FederationFetchResponse) {
+	regions := make(map[string]struct{})
+	response.KeyCount = 0
+	for _, ctr := range response.Response {
+		for _, region := range ctr.RegionIdentifiers {
+			regions[region] = struct{}{}
+		}
+		for _, cti := range ctr.ContactTracingInfo {
+			response.KeyCount += int64(len(cti.ExposureKeys))
+		}
+	}
+	response.RegionCount = int32(len(regions))
+}
+
+// orderByRisk orders the ContactTracingInfos of each ContactTracingResponse by transmission risk,
+// highest first, and the keys of each by interval, breaking ties on the key so that the order is
+// deterministic.
+func orderByRisk(response *pb.FederationFetchResponse) {
+	for _, ctr := range response.Response {
+		sort.Slice(ctr.ContactTracingInfo, func(i, j int) bool {
+			return ctr.ContactTracingInfo[i].TransmissionRisk > ctr.ContactTracingInfo[j].TransmissionRisk
+		})
+		for _, cti := range ctr.ContactTracingInfo {
+			keys := cti.ExposureKeys
+			sort.Slice(keys, func(i, j int) bool {
+				if keys[i].IntervalNumber != keys[j].IntervalNumber {
+					return keys[i].IntervalNumber < keys[j].IntervalNumber
+				}
+				if keys[i].IntervalCount != keys[j].IntervalCount {
+					return keys[i].IntervalCount < keys[j].IntervalCount
+				}
+				return bytes.Compare(keys[i].ExposureKey, keys[j].ExposureKey) < 0
+			})
+		}
+	}
+}
+
+// dedupKeys merges the copies of each key, identified by its bytes and interval number, which are
+// served under several region groups into one, served under the union of those groups. A merged key
+// keeps the highest transmission risk of its copies. Groups are kept in the order they first appear.
+// It returns the number of copies removed.
+func dedupKeys(response *pb.FederationFetchResponse) int {
+	type keyID struct {
+		key            string
+		intervalNumber int32
+	}
+	type dedupedKey struct {
+		key     *pb.ExposureKey
+		risk    int32
+		regions []string
+	}
+
+	var keys []*dedupedKey
+	index := make(map[keyID]*dedupedKey)
+	removed := 0
+	for _, ctr := range response.Response {
+		for _, cti := range ctr.ContactTracingInfo {
+			for _, key := range cti.ExposureKeys {
+				id := keyID{key: string(key.ExposureKey), intervalNumber: key.IntervalNumber}
+				dk := index[id]
+				if dk == nil {
+					dk = &dedupedKey{key: key, risk: cti.TransmissionRisk, regions: append([]string(nil), ctr.RegionIdentifiers...)}
+					index[id] = dk
+					keys = append(keys, dk)
+					continue
+				}
+				removed++
+				if cti.TransmissionRisk > dk.risk {
+					dk.risk = cti.TransmissionRisk
+				}
+				dk.regions = append(dk.regions, ctr.RegionIdentifiers...)
+			}
+		}
+	}
+	if removed == 0 {
+		return 0
+	}
+
+	ctrMap := map[string]*pb.ContactTracingResponse{}
+	ctiMap := map[ctiKey]*pb.ContactTracingInfo{}
+	var ctrKey []byte
+	response.Response = nil
+	for _, dk := range keys {
+		regions := normalizeRegions(dk.regions)
+		ctrKey = appendRegionsKey(ctrKey[:0], regions)
+		ctr := ctrMap[string(ctrKey)]
+		if ctr == nil {
+			ctr = &pb.ContactTracingResponse{RegionIdentifiers: regions}
+			ctrMap[string(ctrKey)] = ctr
+			response.Response = append(response.Response, ctr)
+		}
+		ck := ctiKey{ctr: ctr, transmissionRisk: int(dk.risk)}
+		cti := ctiMap[ck]
+		if cti == nil {
+			cti = &pb.ContactTracingInfo{TransmissionRisk: dk.risk}
+			ctiMap[ck] = cti
+			ctr.ContactTracingInfo = append(ctr.ContactTracingInfo, cti)
+		}
+		cti.ExposureKeys = append(cti.ExposureKeys, dk.key)
+	}
+	return removed
+}
+
+// fetchStatus converts an error returned by fetch into a gRPC status error
+// suitable for returning to the caller.
+func fetchStatus(err error) error {
+	switch {
+	case errors.Is(err, ErrCursor):
+		return status.Error(codes.InvalidArgument, "invalid nextFetchToken")
+	case errors.Is(err, ErrQuery):
+		return status.Error(codes.Unavailable, "exposures unavailable")
+	case errors.Is(err, ErrIterate):
+		return status.Error(codes.Internal, "internal error")
+	case errors.Is(err, publishdb.ErrRegionKeyLimit):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	return status.Error(codes.Internal, "internal error")
+}
+
+func (s Server) fetch(ctx context.Context, req *pb.FederationFetchRequest, deps fetchDependencies, fetchUntil time.Time) (*pb.FederationFetchResponse, error) {
 	logger := logging.FromContext(ctx)
 	metrics := s.env.MetricsExporter(ctx)
 
+	// If the caller's deadline is shorter than our own timeout, context.WithTimeout does not extend it.
+	// Rather than return a near-empty partial response, fail fast so the caller can retry with more time.
+	if deadline, ok := ctx.Deadline(); ok && s.config.MinRemainingTime > 0 {
+		if remaining := time.Until(deadline); remaining < s.config.MinRemainingTime {
+			metrics.WriteInt("federation-fetch-insufficient-deadline", true, 1)
+			return nil, status.Errorf(codes.DeadlineExceeded, "insufficient time remaining on request deadline, got %v, need at least %v", remaining, s.config.MinRemainingTime)
+		}
+	}
+
+	// Bound the work done on the region lists before any other check can stop a runaway request.
+	if err := ctx.Err(); err != nil {
+		return nil, contextStatus(err)
+	}
+	if max := s.config.MaxRegions; max > 0 && (len(req.RegionIdentifiers) > max || len(req.ExcludeRegionIdentifiers) > max) {
+		metrics.WriteInt("federation-fetch-too-many-regions", true, 1)
+		return nil, status.Errorf(codes.InvalidArgument, "at most %d included and %d excluded regions are allowed, got %d and %d",
+			max, max, len(req.RegionIdentifiers), len(req.ExcludeRegionIdentifiers))
+	}
 	for i := range req.RegionIdentifiers {
+		if err := checkCanceled(ctx, i); err != nil {
+			return nil, contextStatus(err)
+		}
 		req.RegionIdentifiers[i] = strings.ToUpper(req.RegionIdentifiers[i])
 	}
 	for i := range req.ExcludeRegionIdentifiers {
+		if err := checkCanceled(ctx, i); err != nil {
+			return nil, contextStatus(err)
+		}
 		req.ExcludeRegionIdentifiers[i] = strings.ToUpper(req.ExcludeRegionIdentifiers[i])
 	}
 	metrics.WriteInt("federation-fetch-regions-requested", false, len(req.RegionIdentifiers))
@@ -99,114 +486,488 @@ func (s Server) fetch(ctx context.Context, req *pb.FederationFetchRequest, itFun
 	logger.Infof("Processing client request %#v", req)
 
 	// If there is a FederationAuthorization on the context, set the query to operate within its limits.
-	if auth, ok := ctx.Value(authKey{}).(*model.FederationOutAuthorization); ok {
+	var namespace string
+	auth, hasAuth := ctx.Value(authKey{}).(*model.FederationOutAuthorization)
+	if hasAuth {
+		// Clients only ever see exposures published in their own namespace.
+		namespace = auth.Namespace
 		// For included regions, we INTERSECT the requested included regions with the configured included regions.
 		req.RegionIdentifiers = intersect(req.RegionIdentifiers, auth.IncludeRegions)
 		// For excluded regions, we UNION the the requested excluded regions with the configured excluded regions.
 		req.ExcludeRegionIdentifiers = union(req.ExcludeRegionIdentifiers, auth.ExcludeRegions)
 	}
 
+	// A syncToken stands in for both the client's timestamp and its nextFetchToken.
+	var syncPos *syncPosition
+	scope := syncScope(req.RegionIdentifiers, req.ExcludeRegionIdentifiers)
+	if req.SyncToken != "" {
+		if req.LastFetchResponseKeyTimestamp != 0 || req.NextFetchToken != "" || req.RelativeSinceSeconds != 0 {
+			return nil, status.Error(codes.InvalidArgument, "syncToken must not be combined with lastFetchResponseKeyTimestamp, nextFetchToken or relativeSinceSeconds")
+		}
+		var err error
+		if syncPos, err = s.decodeSyncToken(req.SyncToken, namespace, scope); err != nil {
+			metrics.WriteInt("federation-fetch-invalid-sync-token", true, 1)
+			if errors.Is(err, errSyncTokenScope) {
+				return nil, status.Error(codes.InvalidArgument, "syncToken was issued for other regionIdentifiers or excludeRegionIdentifiers")
+			}
+			if errors.Is(err, errStaleGeneration) {
+				metrics.WriteInt("federation-fetch-stale-generation", true, 1)
+				return nil, status.Error(codes.FailedPrecondition, "syncToken was issued before the server's data was reset, start over with a full refresh")
+			}
+			return nil, status.Error(codes.InvalidArgument, "invalid syncToken")
+		}
+		req.LastFetchResponseKeyTimestamp = syncPos.Since
+	}
+
+	// Count how clients page and refresh, for capacity planning. A request is a full refresh if it
+	// starts over from the beginning of the retention period.
+	if req.NextFetchToken != "" || (syncPos != nil && syncPos.Cursor != "") {
+		s.countFetch(metrics, "federation-fetch-with-cursor", auth)
+	} else {
+		s.countFetch(metrics, "federation-fetch-without-cursor", auth)
+		if req.LastFetchResponseKeyTimestamp == 0 && req.RelativeSinceSeconds == 0 && req.AsOfTimestamp == 0 {
+			s.countFetch(metrics, "federation-fetch-full-refresh", auth)
+		}
+	}
+
+	// A region which is both included and excluded usually means a misconfigured client. Which wins is
+	// configured; under include-wins the database cannot apply the exclusion, so it is done in memory.
+	overlap := intersect(req.RegionIdentifiers, req.ExcludeRegionIdentifiers)
+	queryExcludeRegions := req.ExcludeRegionIdentifiers
+	if len(overlap) > 0 {
+		sort.Strings(overlap)
+		logger.Warnf("Regions %v are both included and excluded, applying %s", overlap, s.regionPrecedence())
+		metrics.WriteInt("federation-fetch-region-overlap", true, 1)
+		if s.regionPrecedence() == RegionPrecedenceIncludeWins {
+			queryExcludeRegions = nil
+		}
+	}
+
+	// Under exclude-wins, a request which excludes every region it includes can only be misconfigured.
+	if s.config.StrictValidation && s.regionPrecedence() == RegionPrecedenceExcludeWins && allExcluded(req.RegionIdentifiers, req.ExcludeRegionIdentifiers) {
+		metrics.WriteInt("federation-fetch-self-cancelling", true, 1)
+		return nil, status.Errorf(codes.InvalidArgument, "every requested region %v is excluded by %v", req.RegionIdentifiers, req.ExcludeRegionIdentifiers)
+	}
+
+	if req.DedupKeys && req.ExplodeRegions {
+		return nil, status.Error(codes.InvalidArgument, "dedupKeys and explodeRegions are mutually exclusive")
+	}
+
+	// The exposures cannot be reordered here, so the iterator must produce them newest first itself.
+	if req.ReverseOrder && !deps.filters.Reverse {
+		return nil, status.Error(codes.Unimplemented, "reverseOrder is not supported by this server")
+	}
+
+	if req.MinTransmissionRisk < verifyapi.MinTransmissionRisk || req.MinTransmissionRisk > verifyapi.MaxTransmissionRisk {
+		return nil, status.Errorf(codes.InvalidArgument, "minTransmissionRisk must be between %d and %d, got %d", verifyapi.MinTransmissionRisk, verifyapi.MaxTransmissionRisk, req.MinTransmissionRisk)
+	}
+
+	var knownKeys *keyFilter
+	if req.KnownKeys != nil {
+		var err error
+		if knownKeys, err = newKeyFilter(req.KnownKeys, s.config.MaxKeyFilterBytes, s.config.MaxKeyFilterHashCount); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+
+	// A backfill replays keys up to a fixed point rather than the last complete window. It may reach
+	// into the current window, so the client must be authorized for it, and it cannot reach the future.
+	if req.UntilTimestampOverride != 0 {
+		if hasAuth && !auth.AllowBackfill {
+			metrics.WriteInt("federation-fetch-backfill-denied", true, 1)
+			return nil, status.Error(codes.PermissionDenied, "client is not authorized for untilTimestampOverride fetches")
+		}
+		override := time.Unix(req.UntilTimestampOverride, 0)
+		if req.UntilTimestampOverride < 0 || override.After(time.Now()) {
+			return nil, status.Errorf(codes.InvalidArgument, "untilTimestampOverride %d must be positive and not after the present", req.UntilTimestampOverride)
+		}
+		fetchUntil = override
+	}
+
+	// A partner may be required to leave time for keys to be revoked, so keys are withheld from it
+	// until they are old enough. This holds for backfills too.
+	if hasAuth && auth.MinKeyAge > 0 {
+		if aged := publishmodel.TruncateWindow(time.Now().Add(-auth.MinKeyAge), s.config.TruncateWindow); aged.Before(fetchUntil) {
+			fetchUntil = aged
+		}
+	}
+
+	// A relative since-floor is measured back from the end of the last complete window, so a
+	// stateless client can ask for e.g. the last 7 days without tracking a timestamp.
+	since := time.Unix(req.LastFetchResponseKeyTimestamp, 0)
+	if req.LastFetchResponseKeyTimestamp != 0 && s.config.SinceOverlap > 0 {
+		since = since.Add(-s.config.SinceOverlap)
+		if since.Before(time.Unix(0, 0)) {
+			since = time.Unix(0, 0)
+		}
+	}
+	if req.RelativeSinceSeconds != 0 {
+		if req.LastFetchResponseKeyTimestamp != 0 {
+			return nil, status.Error(codes.InvalidArgument, "relativeSinceSeconds and lastFetchResponseKeyTimestamp are mutually exclusive")
+		}
+		if req.RelativeSinceSeconds < 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "relativeSinceSeconds must be positive, got %d", req.RelativeSinceSeconds)
+		}
+		since = fetchUntil.Add(-time.Duration(req.RelativeSinceSeconds) * time.Second)
+	}
+
+	// The client's own position, before any overlap, says whether it is caught up.
+	position := since
+	if req.LastFetchResponseKeyTimestamp != 0 {
+		position = time.Unix(req.LastFetchResponseKeyTimestamp, 0)
+	}
+
+	// An as-of fetch serves the keys as they were at a past time. Since this scans historical data, the
+	// client must be authorized for it.
+	if req.AsOfTimestamp != 0 {
+		if hasAuth && !auth.AllowHistorical {
+			metrics.WriteInt("federation-fetch-historical-denied", true, 1)
+			return nil, status.Error(codes.PermissionDenied, "client is not authorized for asOfTimestamp fetches")
+		}
+		if asOf := publishmodel.TruncateWindow(time.Unix(req.AsOfTimestamp, 0), s.config.TruncateWindow); asOf.Before(fetchUntil) {
+			fetchUntil = asOf
+		}
+	}
+
+	// Reject tokens which were not issued by this server, e.g. a client trying to page outside its scope.
+	lastCursor := req.NextFetchToken
+	var err error
+	if s.cursors != nil {
+		if lastCursor, err = s.cursors.open(req.NextFetchToken, namespace); err != nil {
+			metrics.WriteInt("federation-fetch-forged-cursor", true, 1)
+			return nil, &fetchError{kind: ErrCursor, err: err}
+		}
+	}
+	// A token from before the data was reset would resume into a database it does not describe.
+	if lastCursor, err = s.checkGeneration(lastCursor); err != nil {
+		metrics.WriteInt("federation-fetch-stale-generation", true, 1)
+		return nil, status.Error(codes.FailedPrecondition, "nextFetchToken was issued before the server's data was reset, start over with a full refresh")
+	}
+	if syncPos != nil {
+		lastCursor = syncPos.Cursor
+	}
+	if lastCursor != "" {
+		s.countFetch(metrics, "federation-fetch-resumed", auth)
+	}
+
+	// A client using syncTokens is given the token to resume from with every response.
+	if syncPos == nil {
+		syncPos = &syncPosition{Since: position.Unix(), Scope: scope}
+	}
+	setSyncToken := func(response *pb.FederationFetchResponse, cursor string) error {
+		if req.SyncToken == "" && !req.RequestSyncToken {
+			return nil
+		}
+		token, err := s.encodeSyncToken(nextSyncPosition(syncPos, response, cursor), namespace)
+		if err != nil {
+			return fmt.Errorf("encoding syncToken: %w", err)
+		}
+		response.SyncToken = token
+		return nil
+	}
+
 	criteria := publishdb.IterateExposuresCriteria{
 		IncludeRegions:      req.RegionIdentifiers,
-		ExcludeRegions:      req.ExcludeRegionIdentifiers,
-		SinceTimestamp:      time.Unix(req.LastFetchResponseKeyTimestamp, 0),
+		ExcludeRegions:      queryExcludeRegions,
+		SinceTimestamp:      since,
 		UntilTimestamp:      fetchUntil,
-		LastCursor:          req.NextFetchToken,
+		LastCursor:          lastCursor,
+		NotExpiredAt:        time.Now(),
+		MinTransmissionRisk: int(req.MinTransmissionRisk),
 		OnlyLocalProvenance: true, // Do not return results that came from other federation partners.
+		Namespace:           namespace,
+		ExplainQuery:        s.config.ExplainQueries && rand.Float64() < s.config.ExplainSampleRate,
+		Reverse:             req.ReverseOrder,
 	}
 
 	logger.Infof("Query criteria: %#v", criteria)
 
-	// Filter included countries in memory.
-	includedRegions := make(map[string]struct{}, len(req.RegionIdentifiers))
-	for _, region := range req.RegionIdentifiers {
-		includedRegions[region] = struct{}{}
+	var effective *pb.EffectiveCriteria
+	if req.Debug {
+		effective = &pb.EffectiveCriteria{
+			RegionIdentifiers:            criteria.IncludeRegions,
+			ExcludeRegionIdentifiers:     req.ExcludeRegionIdentifiers,
+			SinceTimestamp:               criteria.SinceTimestamp.Unix(),
+			UntilTimestamp:               criteria.UntilTimestamp.Unix(),
+			FullRefresh:                  req.LastFetchResponseKeyTimestamp == 0 && req.RelativeSinceSeconds == 0,
+			StrictExclude:                s.config.StrictExclude,
+			IncludeTombstones:            req.IncludeTombstones,
+			ExplodeRegions:               req.ExplodeRegions,
+			AsOfTimestamp:                req.AsOfTimestamp,
+			RelativeSinceSeconds:         req.RelativeSinceSeconds,
+			OverlappingRegionIdentifiers: overlap,
+			UntilTimestampOverride:       req.UntilTimestampOverride,
+			DedupKeys:                    req.DedupKeys,
+			MaxKeyFilterBytes:            int32(s.config.MaxKeyFilterBytes),
+			MaxKeyFilterHashCount:        int32(s.config.MaxKeyFilterHashCount),
+			MinTransmissionRisk:          req.MinTransmissionRisk,
+			ReverseOrder:                 req.ReverseOrder,
+		}
+		if len(overlap) > 0 {
+			effective.RegionPrecedence = s.regionPrecedence()
+		}
+	}
+
+	// If nothing has been published in the requested regions since the last fetch, skip the query entirely.
+	// SinceTimestamp is inclusive, but keys at exactly that timestamp were served by the previous fetch.
+	latest, err := deps.latestCreatedAt(ctx, req.RegionIdentifiers)
+	if err != nil {
+		return nil, &fetchError{kind: ErrQuery, err: fmt.Errorf("reading region watermarks: %w", err)}
+	}
+	if !latest.After(criteria.SinceTimestamp) {
+		metrics.WriteInt("federation-fetch-unchanged", false, 1)
+		logger.Infof("No keys published since %v, returning empty response.", criteria.SinceTimestamp)
+		response := &pb.FederationFetchResponse{AtLiveEdge: s.atLiveEdge(position, criteria.UntilTimestamp), Generation: s.generation}
+		if effective != nil {
+			effective.Unchanged = true
+			response.EffectiveCriteria = effective
+		}
+		if req.IncludeTombstones {
+			if err := s.addRevokedKeys(ctx, deps, req, criteria, response); err != nil {
+				return nil, err
+			}
+		}
+		if err := setSyncToken(response, ""); err != nil {
+			return nil, err
+		}
+		s.trackPartials(ctx, auth, response)
+		s.writeAudit(ctx, deps, criteria, response, 0)
+		return response, nil
 	}
 
-	// Filter excluded countries in memory, using a map for efficiency.
-	excludedRegions := make(map[string]struct{}, len(req.ExcludeRegionIdentifiers))
-	for _, region := range req.ExcludeRegionIdentifiers {
-		excludedRegions[region] = struct{}{}
+	// A recent response to the same request is still current if nothing was published since.
+	cacheKey, cacheable := responseCacheKey(req, criteria, s.maxResponseBytes(auth))
+	cacheable = cacheable && s.cache != nil
+	if cacheable {
+		if response, count, ok := s.cache.get(cacheKey, latest, time.Now()); ok {
+			metrics.WriteInt("federation-fetch-cache-hit", true, 1)
+			logger.Infof("Sent %d keys from cached response", count)
+			if err := setSyncToken(response, ""); err != nil {
+				return nil, err
+			}
+			s.trackPartials(ctx, auth, response)
+			s.writeAudit(ctx, deps, criteria, response, count)
+			return response, nil
+		}
+		metrics.WriteInt("federation-fetch-cache-miss", true, 1)
 	}
 
+	// Filter included and excluded countries in memory.
+	includedRegions := newRegionMatcher(req.RegionIdentifiers)
+	excludedRegions := newRegionMatcher(req.ExcludeRegionIdentifiers)
+
 	ctrMap := map[string]*pb.ContactTracingResponse{} // local index into the response being assembled; keyed on unique set of regions.
-	ctiMap := map[string]*pb.ContactTracingInfo{}     // local index into the response being assembled; keys on unique set of (ctrMap key, transmissionRisk, verificationAuthorityName)
-	response := &pb.FederationFetchResponse{}
+	ctiMap := map[ctiKey]*pb.ContactTracingInfo{}     // local index into the response being assembled; keys on unique set of (ContactTracingResponse, transmissionRisk)
+	var ctrKey []byte                                 // scratch space for ctrMap keys, reused across exposures
+	response := &pb.FederationFetchResponse{EffectiveCriteria: effective, Generation: s.generation}
 	count := 0
-	cursor, err := itFunc(ctx, criteria, func(inf *publishmodel.Exposure) error {
+	received := false
+	maxBytes := s.maxResponseBytes(auth)
+	size := proto.Size(response) + responseSizeReserve
+	iterate := deps.iterateExposures
+	if s.config.IteratorTimeout > 0 {
+		iterate = watchdog(iterate, s.config.IteratorTimeout)
+	}
+	pacer := newFetchPacer(ctx, s.config, time.Now())
+	cursor, err := iterate(ctx, criteria, func(inf *publishmodel.Exposure) error {
+		received = true
+
+		// Cut a fetch whose keys are arriving too slowly, leaving this key for the next page. The first
+		// key is always served, so that the client makes progress.
+		if pacer != nil && pacer.observe(time.Now()) && count > 0 {
+			return errSlowFetch
+		}
+
 		// If the diagnosis key is empty, it's malformed, so skip it.
 		if len(inf.ExposureKey) == 0 {
 			logger.Debugf("Exposure %s missing ExposureKey, skipping.", inf.ExposureKey)
+			s.skips.skipped(ctx, inf, "missing key")
+			return nil
+		}
+
+		// Skip keys which are not exactly KeyLength raw bytes, if configured. This catches keys stored in
+		// the wrong encoding, e.g. as base64 text, which would otherwise be served malformed.
+		if s.config.StrictKeyBytes && len(inf.ExposureKey) != verifyapi.KeyLength {
+			logger.Debugf("Exposure %x has %d bytes, want %d, skipping.", inf.ExposureKey, len(inf.ExposureKey), verifyapi.KeyLength)
+			s.skips.skipped(ctx, inf, "malformed key")
+			metrics.WriteInt("federation-fetch-malformed-key", true, 1)
 			return nil
 		}
 
 		// If there are no regions on the exposure, it's malformed, so skip it.
 		if len(inf.Regions) == 0 {
 			logger.Debugf("Exposure %s missing Regions, skipping.", inf.ExposureKey)
+			s.skips.skipped(ctx, inf, "missing regions")
+			return nil
+		}
+
+		// Skip keys with pathologically many regions before they are sorted and grouped.
+		if max := s.config.MaxRegionsPerKey; max > 0 && len(inf.Regions) > max {
+			logger.Debugf("Exposure %x has %d regions, at most %d are allowed, skipping.", inf.ExposureKey, len(inf.Regions), max)
+			s.skips.skipped(ctx, inf, "too many regions")
+			metrics.WriteInt("federation-fetch-too-many-key-regions", true, 1)
+			return nil
+		}
+
+		// Never serve keys known to be malicious, even if they were stored before being blocked.
+		if s.keyBlocklist.Contains(inf.ExposureKey) {
+			logger.Debugf("Exposure %x is blocklisted, skipping.", inf.ExposureKey)
+			s.skips.skipped(ctx, inf, "blocklisted")
+			metrics.WriteInt("federation-fetch-blocklisted", true, 1)
 			return nil
 		}
 
 		// Filter out non-LocalProvenance results; we should not re-federate.
-		// This may already be handled by the database query and is included here for completeness.
-		if !inf.LocalProvenance {
+		// This is skipped if the store's query already handles it.
+		if !deps.filters.LocalProvenance && !inf.LocalProvenance {
 			logger.Debugf("Exposure %s not LocalProvenance, skipping.", inf.ExposureKey)
+			s.skips.skipped(ctx, inf, "not local provenance")
 			return nil
 		}
 
-		// If all the regions on the record are excluded, skip it.
-		skip := true
-		for _, region := range inf.Regions {
-			if _, excluded := excludedRegions[region]; !excluded {
-				// At least one region for the exposure is NOT excluded, so we don't skip this record.
-				skip = false
-				break
-			}
+		// Skip keys created after the end of the query window, which is earlier than usual for as-of fetches.
+		// This is skipped if the store's query already handles it.
+		if !deps.filters.Timestamps && !inf.CreatedAt.Before(criteria.UntilTimestamp) {
+			logger.Debugf("Exposure %s created after %v, skipping.", inf.ExposureKey, criteria.UntilTimestamp)
+			s.skips.skipped(ctx, inf, "created after until timestamp")
+			return nil
 		}
-		if skip {
-			logger.Debugf("Exposure %s contains only excluded regions, skipping.", inf.ExposureKey)
+		if !deps.filters.Timestamps && inf.CreatedAt.Before(criteria.SinceTimestamp) {
+			logger.Debugf("Exposure %s created before %v, skipping.", inf.ExposureKey, criteria.SinceTimestamp)
+			s.skips.skipped(ctx, inf, "created before since timestamp")
 			return nil
 		}
 
-		// If filtering on a region (len(includedRegions) > 0) and none of the regions on the record are included, skip it.
-		if len(includedRegions) > 0 {
-			skip = true
-			for _, region := range inf.Regions {
-				if _, included := includedRegions[region]; included {
-					skip = false
-					break
-				}
+		// Skip keys past their individual expiry.
+		// This is skipped if the store's query already handles it.
+		if !deps.filters.Expiry && !inf.ExpiresAt.IsZero() && !inf.ExpiresAt.After(criteria.NotExpiredAt) {
+			logger.Debugf("Exposure %s expired at %v, skipping.", inf.ExposureKey, inf.ExpiresAt)
+			s.skips.skipped(ctx, inf, "expired")
+			return nil
+		}
+
+		// Skip keys below the requested risk.
+		// This is skipped if the store's query already handles it.
+		if !deps.filters.TransmissionRisk && inf.TransmissionRisk < criteria.MinTransmissionRisk {
+			logger.Debugf("Exposure %s has transmission risk %d, below %d, skipping.", inf.ExposureKey, inf.TransmissionRisk, criteria.MinTransmissionRisk)
+			s.skips.skipped(ctx, inf, "below minimum transmission risk")
+			return nil
+		}
+
+		// Skip keys with an IntervalCount which does not match their age, if configured.
+		if s.config.StrictIntervalCount {
+			if err := publishmodel.ValidateIntervalCount(inf.IntervalNumber, inf.IntervalCount, inf.CreatedAt); err != nil {
+				logger.Debugf("Exposure %s %v, skipping.", inf.ExposureKey, err)
+				s.skips.skipped(ctx, inf, err.Error())
+				metrics.WriteInt("federation-fetch-invalid-interval-count", true, 1)
+				return nil
 			}
-			if skip {
-				logger.Debugf("Exposure %s does not contain requested regions, skipping.", inf.ExposureKey)
+		}
+
+		// Never serve exposures from another namespace, even if the regions overlap.
+		// This is skipped if the store's query already handles it.
+		if !deps.filters.Namespace && inf.Namespace != namespace {
+			logger.Debugf("Exposure %s not in namespace %q, skipping.", inf.ExposureKey, namespace)
+			s.skips.skipped(ctx, inf, "other namespace")
+			return nil
+		}
+
+		// Apply the deployment specific transform, if any.
+		if s.keyTransform != nil {
+			transformed, keep := s.keyTransform(inf)
+			if !keep {
+				logger.Debugf("Exposure dropped by key transform, skipping.")
+				s.skips.skipped(ctx, inf, "dropped by key transform")
 				return nil
 			}
+			inf = transformed
 		}
 
-		// Find, or create, the ContactTracingResponse based on the unique set of regions.
-		sort.Strings(inf.Regions)
-		ctrKey := strings.Join(inf.Regions, "::")
-		ctr := ctrMap[ctrKey]
-		if ctr == nil {
-			ctr = &pb.ContactTracingResponse{RegionIdentifiers: inf.Regions}
-			ctrMap[ctrKey] = ctr
-			response.Response = append(response.Response, ctr)
+		// Sort and remove duplicate regions, so that e.g. [US, US, CA] and [CA, US] are grouped together.
+		inf.Regions = normalizeRegions(inf.Regions)
+
+		excluded, err := s.excluded(ctx, inf.Regions, includedRegions, excludedRegions)
+		if err != nil {
+			return err
+		}
+		if excluded {
+			logger.Debugf("Exposure %s contains excluded regions %v, skipping.", inf.ExposureKey, inf.Regions)
+			s.skips.skipped(ctx, inf, "excluded region")
+			return nil
 		}
 
-		// Find, or create, the ContactTracingInfo for (ctrKey, transmissionRisk).
-		ctiKey := fmt.Sprintf("%s::%d", ctrKey, inf.TransmissionRisk)
-		cti := ctiMap[ctiKey]
-		if cti == nil {
-			cti = &pb.ContactTracingInfo{TransmissionRisk: int32(inf.TransmissionRisk)}
-			ctiMap[ctiKey] = cti
-			ctr.ContactTracingInfo = append(ctr.ContactTracingInfo, cti)
+		// If filtering on a region and none of the regions on the record are included, skip it.
+		if !includedRegions.empty() && !includedRegions.matchesAny(inf.Regions) {
+			logger.Debugf("Exposure %s does not contain requested regions, skipping.", inf.ExposureKey)
+			s.skips.skipped(ctx, inf, "no requested region")
+			return nil
 		}
 
-		// Add the key to the ContactTracingInfo.
-		cti.ExposureKeys = append(cti.ExposureKeys, &pb.ExposureKey{
-			ExposureKey:    inf.ExposureKey,
-			IntervalNumber: inf.IntervalNumber,
-			IntervalCount:  inf.IntervalCount,
-		})
+		// Skip keys the client says it already holds. A false positive is the client's to accept.
+		if knownKeys != nil && knownKeys.contains(inf.ExposureKey) {
+			response.KnownKeyCount++
+			return nil
+		}
+
+		// By default the key is served once, grouped on its set of regions. If exploding regions, it is
+		// served once under each of its regions which was requested and not excluded.
+		groups := [][]string{inf.Regions}
+		if req.ExplodeRegions {
+			if groups, err = s.explodeRegions(ctx, inf.Regions, includedRegions, excludedRegions); err != nil {
+				return err
+			}
+		}
+
+		// Check the group limit up front, so that a key is never only partially added to the response.
+		if max := s.config.MaxResponseGroups; max > 0 {
+			added := 0
+			for _, regions := range groups {
+				ctrKey = appendRegionsKey(ctrKey[:0], regions)
+				if _, ok := ctrMap[string(ctrKey)]; !ok {
+					added++
+				}
+			}
+			if added > 0 && len(ctrMap)+added > max {
+				return errResponseGroupLimit
+			}
+		}
+
+		// Likewise check the size limit. The first key is always served, so that the client makes progress.
+		if maxBytes > 0 {
+			added := groupedKeySize(inf, groups, ctrMap, ctiMap, ctrKey)
+			if count > 0 && size+added > maxBytes {
+				return errResponseSizeLimit
+			}
+			size += added
+		}
+
+		for _, regions := range groups {
+			// Find, or create, the ContactTracingResponse based on the unique set of regions.
+			// Looking up a map with string(ctrKey) does not allocate; only new groups copy the key.
+			ctrKey = appendRegionsKey(ctrKey[:0], regions)
+			ctr := ctrMap[string(ctrKey)]
+			if ctr == nil {
+				ctr = &pb.ContactTracingResponse{RegionIdentifiers: regions}
+				ctrMap[string(ctrKey)] = ctr
+				response.Response = append(response.Response, ctr)
+			}
+
+			// Find, or create, the ContactTracingInfo for (ctr, transmissionRisk).
+			ck := ctiKey{ctr: ctr, transmissionRisk: inf.TransmissionRisk}
+			cti := ctiMap[ck]
+			if cti == nil {
+				cti = &pb.ContactTracingInfo{TransmissionRisk: int32(inf.TransmissionRisk)}
+				ctiMap[ck] = cti
+				ctr.ContactTracingInfo = append(ctr.ContactTracingInfo, cti)
+			}
+
+			// Add the key to the ContactTracingInfo.
+			cti.ExposureKeys = append(cti.ExposureKeys, &pb.ExposureKey{
+				ExposureKey:    inf.ExposureKey,
+				IntervalNumber: inf.IntervalNumber,
+				IntervalCount:  inf.IntervalCount,
+			})
+		}
 
 		created := inf.CreatedAt.Unix()
 		if created > response.FetchResponseKeyTimestamp {
@@ -218,21 +979,292 @@ func (s Server) fetch(ctx context.Context, req *pb.FederationFetchRequest, itFun
 	})
 	if err != nil {
 		metrics.WriteInt("federation-fetch-error", true, 1)
-		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		switch {
+		case errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled):
 			logger.Infof("Fetch request reached time out, returning partial response.")
 			response.PartialResponse = true
 			response.NextFetchToken = cursor
-		} else {
+		case errors.Is(err, errResponseGroupLimit):
+			logger.Infof("Fetch request reached %d response groups, returning partial response.", s.config.MaxResponseGroups)
+			metrics.WriteInt("federation-fetch-group-limit", true, 1)
+			response.PartialResponse = true
+			response.NextFetchToken = cursor
+		case errors.Is(err, errResponseSizeLimit):
+			logger.Infof("Fetch request reached %d bytes, returning partial response.", maxBytes)
+			metrics.WriteInt("federation-fetch-size-limit", true, 1)
+			response.PartialResponse = true
+			response.NextFetchToken = cursor
+		case errors.Is(err, errSlowFetch):
+			logger.Infof("Fetch request is producing keys slowly, returning partial response.")
+			metrics.WriteInt("federation-fetch-slow", true, 1)
+			response.PartialResponse = true
+			response.NextFetchToken = cursor
+		case errors.Is(err, errIteratorStalled):
+			metrics.WriteInt("federation-fetch-iterator-stalled", true, 1)
+			return nil, &fetchError{kind: ErrIterate, err: fmt.Errorf("no progress within %v: %w", s.config.IteratorTimeout, err)}
+		case errors.Is(err, publishdb.ErrInvalidCursor):
+			return nil, &fetchError{kind: ErrCursor, err: err}
+		case !received:
+			// No rows were received, so the query itself failed.
+			return nil, &fetchError{kind: ErrQuery, err: err}
+		default:
+			return nil, &fetchError{kind: ErrIterate, err: err}
+		}
+	}
+	response.NextFetchToken = s.stampGeneration(response.NextFetchToken)
+	if s.cursors != nil && response.NextFetchToken != "" {
+		if response.NextFetchToken, err = s.cursors.seal(response.NextFetchToken, namespace); err != nil {
+			return nil, fmt.Errorf("sealing cursor: %w", err)
+		}
+	}
+	// Revocations are sent with the final page, so that a client does not need to merge them across pages.
+	if req.IncludeTombstones && !response.PartialResponse {
+		if err := s.addRevokedKeys(ctx, deps, req, criteria, response); err != nil {
 			return nil, err
 		}
 	}
+	if response.PartialResponse {
+		s.countFetch(metrics, "federation-fetch-partial", auth)
+	}
+	response.AtLiveEdge = !response.PartialResponse && s.atLiveEdge(position, criteria.UntilTimestamp)
+	if req.DedupKeys {
+		if removed := dedupKeys(response); removed > 0 {
+			metrics.WriteInt("federation-fetch-deduplicated", true, removed)
+		}
+	}
+	if req.OrderByRisk {
+		orderByRisk(response)
+	}
+	summarize(response)
 	metrics.WriteInt("federation-fetch-count", false, count)
-	logger.Infof("Sent %d keys", count)
+	if response.KnownKeyCount > 0 {
+		metrics.WriteInt("federation-fetch-known-keys", true, int(response.KnownKeyCount))
+	}
+	logger.Infof("Sent %d keys, %d with each region group counted, in %d regions", count, response.KeyCount, response.RegionCount)
+	if cacheable {
+		s.cache.put(cacheKey, latest, time.Now(), response, count)
+	}
+	if err := setSyncToken(response, cursor); err != nil {
+		return nil, err
+	}
+	s.trackPartials(ctx, auth, response)
+	s.writeAudit(ctx, deps, criteria, response, count)
 	return response, nil
 }
 
+// atLiveEdge reports whether a client which has fetched up to position has every key created before
+// until. Keys are created at the start of their publish window, so the last complete window's keys
+// are at until minus TruncateWindow, and a client which has reached them is caught up.
+func (s Server) atLiveEdge(position, until time.Time) bool {
+	return !position.Before(until.Add(-s.config.TruncateWindow))
+}
+
+// countFetch increments the named counter and, if Config.PartnerMetrics is set, the same counter for
+// the client with the given authorization, which may be nil.
+func (s Server) countFetch(exporter metrics.Exporter, name string, auth *model.FederationOutAuthorization) {
+	exporter.WriteInt(name, true, 1)
+	if s.config.PartnerMetrics && auth != nil {
+		exporter.WriteInt(name+"/"+auth.Subject, true, 1)
+	}
+}
+
+// maxResponseBytes returns the size limit for a fetch response to the client with the given
+// authorization, which may be nil.
+func (s Server) maxResponseBytes(auth *model.FederationOutAuthorization) int {
+	if auth != nil && auth.MaxResponseBytes > 0 {
+		return auth.MaxResponseBytes
+	}
+	return s.config.MaxResponseBytes
+}
+
+// groupedKeySize returns an upper bound on how much the encoded response grows when inf is added
+// under each of groups. Each length prefix is counted at its largest, so that the sum of the
+// estimates never falls short of proto.Size.
+func groupedKeySize(inf *publishmodel.Exposure, groups [][]string, ctrMap map[string]*pb.ContactTracingResponse, ctiMap map[ctiKey]*pb.ContactTracingInfo, scratch []byte) int {
+	keySize := embeddedSize(proto.Size(&pb.ExposureKey{
+		ExposureKey:    inf.ExposureKey,
+		IntervalNumber: inf.IntervalNumber,
+		IntervalCount:  inf.IntervalCount,
+	}))
+	cti := &pb.ContactTracingInfo{TransmissionRisk: int32(inf.TransmissionRisk)}
+
+	total := 0
+	for _, regions := range groups {
+		total += keySize
+		scratch = appendRegionsKey(scratch[:0], regions)
+		ctr := ctrMap[string(scratch)]
+		if ctr == nil {
+			total += embeddedSize(proto.Size(&pb.ContactTracingResponse{RegionIdentifiers: regions}))
+		}
+		if ctr == nil || ctiMap[ctiKey{ctr: ctr, transmissionRisk: inf.TransmissionRisk}] == nil {
+			total += embeddedSize(proto.Size(cti))
+		}
+	}
+	return total
+}
+
+// embeddedSize returns the largest encoded size of a message of n bytes embedded in another: its
+// tag, a length prefix of up to 5 bytes, and the message itself.
+func embeddedSize(n int) int {
+	return 1 + 5 + n
+}
+
+// addRevokedKeys adds the keys purged within the time window of criteria to response.RevokedKeys.
+// Keys purged more than RevocationGracePeriod before the end of the window are no longer served. A
+// revocation is only served if its key would have been, by the regions of req.
+func (s Server) addRevokedKeys(ctx context.Context, deps fetchDependencies, req *pb.FederationFetchRequest, criteria publishdb.IterateExposuresCriteria, response *pb.FederationFetchResponse) error {
+	tc := publishdb.IterateTombstonesCriteria{
+		SinceTimestamp: criteria.SinceTimestamp,
+		UntilTimestamp: criteria.UntilTimestamp,
+		Namespace:      criteria.Namespace,
+	}
+	if grace := s.config.RevocationGracePeriod; grace > 0 {
+		if graceStart := criteria.UntilTimestamp.Add(-grace); tc.SinceTimestamp.Before(graceStart) {
+			tc.SinceTimestamp = graceStart
+		}
+	}
+	includedRegions := newRegionMatcher(req.RegionIdentifiers)
+	excludedRegions := newRegionMatcher(req.ExcludeRegionIdentifiers)
+	err := deps.iterateTombstones(ctx, tc, func(t *publishmodel.ExposureTombstone) error {
+		// A tombstone with no regions cannot be shown to be within the client's regions.
+		regions := normalizeRegions(t.Regions)
+		if len(regions) == 0 {
+			return nil
+		}
+		excluded, err := s.excluded(ctx, regions, includedRegions, excludedRegions)
+		if err != nil {
+			return err
+		}
+		if excluded || (!includedRegions.empty() && !includedRegions.matchesAny(regions)) {
+			return nil
+		}
+		response.RevokedKeys = append(response.RevokedKeys, &pb.ExposureKey{
+			ExposureKey:    t.ExposureKey,
+			IntervalNumber: t.IntervalNumber,
+			IntervalCount:  t.IntervalCount,
+		})
+		return nil
+	})
+	if err != nil {
+		return &fetchError{kind: ErrQuery, err: fmt.Errorf("reading tombstones: %w", err)}
+	}
+	s.env.MetricsExporter(ctx).WriteInt("federation-fetch-revoked-count", false, len(response.RevokedKeys))
+	return nil
+}
+
+// watchdog wraps an iterateExposuresFunc so that it fails with errIteratorStalled if no exposure
+// is produced within timeout of the iteration starting, or of the previous exposure. The underlying
+// iteration runs in its own goroutine so that the fetch is released even if it ignores cancellation.
+func watchdog(iterate iterateExposuresFunc, timeout time.Duration) iterateExposuresFunc {
+	return func(ctx context.Context, criteria publishdb.IterateExposuresCriteria, f func(*publishmodel.Exposure) error) (string, error) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		type result struct {
+			cursor string
+			err    error
+		}
+		var mu sync.Mutex
+		stalled := false
+		progress := make(chan struct{}, 1)
+		done := make(chan result, 1)
+
+		go func() {
+			cursor, err := iterate(ctx, criteria, func(inf *publishmodel.Exposure) error {
+				mu.Lock()
+				defer mu.Unlock()
+				// Once the watchdog has fired, the caller has moved on and f must not be called again.
+				if stalled {
+					return errIteratorStalled
+				}
+				select {
+				case progress <- struct{}{}:
+				default:
+				}
+				return f(inf)
+			})
+			done <- result{cursor: cursor, err: err}
+		}()
+
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		for {
+			select {
+			case r := <-done:
+				return r.cursor, r.err
+			case <-progress:
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(timeout)
+			case <-timer.C:
+				mu.Lock()
+				stalled = true
+				mu.Unlock()
+				return "", errIteratorStalled
+			}
+		}
+	}
+}
+
+// writeAudit records the fetch in the audit log. This is best-effort; failures are logged but do not fail the fetch.
+func (s Server) writeAudit(ctx context.Context, deps fetchDependencies, criteria publishdb.IterateExposuresCriteria, response *pb.FederationFetchResponse, keysServed int) {
+	logger := logging.FromContext(ctx)
+
+	audit := &model.FederationOutAudit{
+		IncludeRegions:  criteria.IncludeRegions,
+		ExcludeRegions:  criteria.ExcludeRegions,
+		SinceTimestamp:  criteria.SinceTimestamp,
+		UntilTimestamp:  criteria.UntilTimestamp,
+		KeysServed:      keysServed,
+		PartialResponse: response.PartialResponse,
+		FetchedAt:       time.Now(),
+	}
+	if auth, ok := ctx.Value(authKey{}).(*model.FederationOutAuthorization); ok {
+		audit.Issuer = auth.Issuer
+		audit.Subject = auth.Subject
+	}
+
+	// The fetch context may already be expired if this was a partial response, so use a fresh one.
+	auditCtx, cancel := context.WithTimeout(context.Background(), auditTimeout)
+	defer cancel()
+	if err := deps.writeFetchAudit(auditCtx, audit); err != nil {
+		s.env.MetricsExporter(ctx).WriteInt("federation-fetch-audit-failed", true, 1)
+		logger.Errorf("Failed to write fetch audit: %v", err)
+	}
+}
+
 // AuthInterceptor validates incoming OIDC bearer token and adds corresponding FederationAuthorization record to the context.
 func (s Server) AuthInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := s.authorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// StreamAuthInterceptor is the AuthInterceptor for streaming endpoints.
+func (s Server) StreamAuthInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := s.authorize(ss.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authorizedStream{ServerStream: ss, ctx: ctx})
+}
+
+// authorizedStream is a grpc.ServerStream whose context carries the FederationAuthorization.
+type authorizedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authorizedStream) Context() context.Context {
+	return s.ctx
+}
+
+// authorize validates the OIDC bearer token on ctx and returns ctx with the corresponding
+// FederationAuthorization added.
+func (s Server) authorize(ctx context.Context) (context.Context, error) {
 	logger := logging.FromContext(ctx)
 	metrics := s.env.MetricsExporter(ctx)
 
@@ -269,8 +1301,29 @@ func (s Server) AuthInterceptor(ctx context.Context, req interface{}, info *grpc
 
 	// Store the FederationAuthorization on the context.
 	logger.Infof("Caller: issuer %q subject %q", auth.Issuer, auth.Subject)
-	ctx = context.WithValue(ctx, authKey{}, auth)
-	return handler(ctx, req)
+	return context.WithValue(ctx, authKey{}, auth), nil
+}
+
+// fetchTimeout returns the timeout for a fetch: the duration in the client's x-fetch-timeout metadata,
+// clamped to Config.MaxTimeout, or Config.Timeout if there is none or overrides are disabled.
+func (s Server) fetchTimeout(ctx context.Context) time.Duration {
+	if s.config.MaxTimeout <= 0 {
+		return s.config.Timeout
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(timeoutHeader)
+	if len(values) != 1 {
+		return s.config.Timeout
+	}
+	timeout, err := time.ParseDuration(values[0])
+	if err != nil || timeout <= 0 {
+		logging.FromContext(ctx).Warnf("Ignoring invalid %s %q", timeoutHeader, values[0])
+		return s.config.Timeout
+	}
+	if timeout > s.config.MaxTimeout {
+		return s.config.MaxTimeout
+	}
+	return timeout
 }
 
 func rawToken(ctx context.Context) (string, error) {
@@ -296,16 +1349,233 @@ func rawToken(ctx context.Context) (string, error) {
 	return rawToken, nil
 }
 
+// regionMatcher matches regions against a set of exact regions and prefixes,
+// where a prefix is given as a region ending in publishdb.RegionWildcard.
+type regionMatcher struct {
+	exact    map[string]struct{}
+	prefixes []string
+}
+
+func newRegionMatcher(regions []string) *regionMatcher {
+	m := &regionMatcher{exact: make(map[string]struct{}, len(regions))}
+	for _, region := range regions {
+		if strings.HasSuffix(region, publishdb.RegionWildcard) {
+			m.prefixes = append(m.prefixes, strings.TrimSuffix(region, publishdb.RegionWildcard))
+			continue
+		}
+		m.exact[region] = struct{}{}
+	}
+	return m
+}
+
+func (m *regionMatcher) empty() bool {
+	return len(m.exact) == 0 && len(m.prefixes) == 0
+}
+
+func (m *regionMatcher) matches(region string) bool {
+	if _, ok := m.exact[region]; ok {
+		return true
+	}
+	for _, prefix := range m.prefixes {
+		if strings.HasPrefix(region, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesUnlisted reports whether region is matched by an exact region or prefix of m which other does
+// not also have.
+func (m *regionMatcher) matchesUnlisted(region string, other *regionMatcher) bool {
+	if _, ok := m.exact[region]; ok {
+		if _, listed := other.exact[region]; !listed {
+			return true
+		}
+	}
+	for _, prefix := range m.prefixes {
+		if strings.HasPrefix(region, prefix) && !other.hasPrefix(prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *regionMatcher) hasPrefix(prefix string) bool {
+	for _, p := range m.prefixes {
+		if p == prefix {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesAny reports whether any of regions matches.
+func (m *regionMatcher) matchesAny(regions []string) bool {
+	for _, region := range regions {
+		if m.matches(region) {
+			return true
+		}
+	}
+	return false
+}
+
+// allExcluded reports whether regions is not empty and each of its regions, or wildcards, is matched
+// by excludeRegions.
+func allExcluded(regions, excludeRegions []string) bool {
+	if len(regions) == 0 {
+		return false
+	}
+	excluded := newRegionMatcher(excludeRegions)
+	for _, region := range regions {
+		if !excluded.matches(region) {
+			return false
+		}
+	}
+	return true
+}
+
+// regionPrecedence returns Config.RegionPrecedence, or its default.
+func (s Server) regionPrecedence() string {
+	if s.config.RegionPrecedence == "" {
+		return RegionPrecedenceExcludeWins
+	}
+	return s.config.RegionPrecedence
+}
+
+// regionExcluded reports whether region is excluded. Under include-wins precedence, an exclusion is
+// only overridden by the identical inclusion, so that e.g. including US-* does not override excluding
+// US-CA.
+func (s Server) regionExcluded(region string, includedRegions, excludedRegions *regionMatcher) bool {
+	if s.regionPrecedence() != RegionPrecedenceIncludeWins {
+		return excludedRegions.matches(region)
+	}
+	return excludedRegions.matchesUnlisted(region, includedRegions)
+}
+
+// contextStatus converts the error of a done context into a gRPC status error.
+func contextStatus(err error) error {
+	if errors.Is(err, context.Canceled) {
+		return status.Error(codes.Canceled, err.Error())
+	}
+	return status.Error(codes.DeadlineExceeded, err.Error())
+}
+
+// checkCanceled returns ctx.Err() on every regionCheckInterval'th region of a loop over the regions of a
+// single key, so that a key with very many regions does not delay cancellation.
+func checkCanceled(ctx context.Context, i int) error {
+	if i%regionCheckInterval != regionCheckInterval-1 {
+		return nil
+	}
+	return ctx.Err()
+}
+
+// excluded reports whether an exposure published to regions is excluded. If StrictExclude is set, it is
+// excluded if ANY of its regions is; otherwise only if ALL of them are. It returns ctx.Err() if ctx is
+// done before all regions are checked.
+func (s Server) excluded(ctx context.Context, regions []string, includedRegions, excludedRegions *regionMatcher) (bool, error) {
+	for i, region := range regions {
+		if err := checkCanceled(ctx, i); err != nil {
+			return false, err
+		}
+		excluded := s.regionExcluded(region, includedRegions, excludedRegions)
+		if s.config.StrictExclude && excluded {
+			return true, nil
+		}
+		if !s.config.StrictExclude && !excluded {
+			// At least one region for the exposure is NOT excluded, so we don't skip this record.
+			return false, nil
+		}
+	}
+	return !s.config.StrictExclude, nil
+}
+
+// narrower returns the narrower of two regions, either of which may be a wildcard, or
+// false if they do not overlap.
+func narrower(a, b string) (string, bool) {
+	aPrefix, aWild := strings.TrimSuffix(a, publishdb.RegionWildcard), strings.HasSuffix(a, publishdb.RegionWildcard)
+	bPrefix, bWild := strings.TrimSuffix(b, publishdb.RegionWildcard), strings.HasSuffix(b, publishdb.RegionWildcard)
+	switch {
+	case a == b:
+		return a, true
+	case aWild && strings.HasPrefix(b, aPrefix):
+		return b, true
+	case bWild && strings.HasPrefix(a, bPrefix):
+		return a, true
+	}
+	return "", false
+}
+
+// normalizeRegions sorts regions and removes duplicates, in place, so that e.g.
+// [US, US, CA] and [CA, US] are grouped together. The sort, which allocates,
+// is skipped if regions are already in order, as is common for single region
+// keys and keys published with a consistently ordered region list.
+func normalizeRegions(regions []string) []string {
+	if !sort.StringsAreSorted(regions) {
+		sort.Strings(regions)
+	}
+	return dedupSorted(regions)
+}
+
+// appendRegionsKey appends the ctrMap key for a normalized set of regions to buf.
+func appendRegionsKey(buf []byte, regions []string) []byte {
+	for i, region := range regions {
+		if i > 0 {
+			buf = append(buf, "::"...)
+		}
+		buf = append(buf, region...)
+	}
+	return buf
+}
+
+// explodeRegions returns a single region group for each of regions which is
+// included (or all, if no regions were requested) and not excluded.
+func (s Server) explodeRegions(ctx context.Context, regions []string, included, excluded *regionMatcher) ([][]string, error) {
+	groups := make([][]string, 0, len(regions))
+	for i, region := range regions {
+		if err := checkCanceled(ctx, i); err != nil {
+			return nil, err
+		}
+		if s.regionExcluded(region, included, excluded) {
+			continue
+		}
+		if !included.empty() && !included.matches(region) {
+			continue
+		}
+		groups = append(groups, []string{region})
+	}
+	return groups, nil
+}
+
+// dedupSorted removes adjacent duplicates from a sorted slice, in place.
+func dedupSorted(ss []string) []string {
+	if len(ss) < 2 {
+		return ss
+	}
+	n := 1
+	for i := 1; i < len(ss); i++ {
+		if ss[i] != ss[n-1] {
+			ss[n] = ss[i]
+			n++
+		}
+	}
+	return ss[:n]
+}
+
+// intersect returns the regions in both aa and bb. Wildcards are honored, so
+// intersecting "US-*" with "US-CA" yields "US-CA".
 func intersect(aa, bb []string) []string {
 	if len(aa) == 0 || len(bb) == 0 {
 		return nil
 	}
 	var result []string
+	seen := map[string]struct{}{}
 	for _, a := range aa {
 		for _, b := range bb {
-			if a == b {
-				result = append(result, a)
-				break
+			if n, ok := narrower(a, b); ok {
+				if _, dup := seen[n]; !dup {
+					seen[n] = struct{}{}
+					result = append(result, n)
+				}
 			}
 		}
 	}
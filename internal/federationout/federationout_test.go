@@ -15,27 +15,42 @@
 package federationout
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/google/exposure-notifications-server/internal/blocklist"
+	fedmodel "github.com/google/exposure-notifications-server/internal/federationin/model"
+	"github.com/google/exposure-notifications-server/internal/metrics"
 	"github.com/google/exposure-notifications-server/internal/publish/database"
 
 	"github.com/google/exposure-notifications-server/internal/publish/model"
 
 	"github.com/google/exposure-notifications-server/internal/pb"
 	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	"google.golang.org/protobuf/testing/protocmp"
 )
 
 var (
-	// listsAsSets are cmp.Options to remove list ordering from diffs of protos.
+	// listsAsSets are cmp.Options to remove list ordering from diffs of protos. The summary counts,
+	// which follow from the keys, are checked separately by TestFetchSummary.
 	listsAsSets = []cmp.Option{
 		protocmp.Transform(),
+		protocmp.IgnoreFields(&pb.FederationFetchResponse{}, "keyCount", "regionCount"),
 		protocmp.SortRepeatedFields(&pb.FederationFetchResponse{}, "response"),
 		protocmp.SortRepeatedFields(&pb.ContactTracingResponse{}, "contactTracingInfo"),
 		protocmp.SortRepeatedFields(&pb.ContactTracingInfo{}, "exposureKeys"),
@@ -75,6 +90,8 @@ func iterFunc(elements []interface{}) iterateExposuresFunc {
 				}
 			case timeout:
 				return cursor, context.Canceled
+			case error:
+				return cursor, v
 			default:
 				panic("bad element")
 			}
@@ -83,6 +100,39 @@ func iterFunc(elements []interface{}) iterateExposuresFunc {
 	}
 }
 
+// latestFunc returns a latestCreatedAtFunc that always reports the given time.
+func latestFunc(latest time.Time) latestCreatedAtFunc {
+	return func(context.Context, []string) (time.Time, error) {
+		return latest, nil
+	}
+}
+
+// tombstoneFunc returns an iterateTombstonesFunc that iterates over the given tombstones, filtered
+// by their DeletedAt as the database would.
+func tombstoneFunc(tombstones []*model.ExposureTombstone) iterateTombstonesFunc {
+	return func(_ context.Context, criteria database.IterateTombstonesCriteria, f func(*model.ExposureTombstone) error) error {
+		for _, t := range tombstones {
+			if t.DeletedAt.Before(criteria.SinceTimestamp) || (!criteria.UntilTimestamp.IsZero() && !t.DeletedAt.Before(criteria.UntilTimestamp)) {
+				continue
+			}
+			if err := f(t); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// testDeps returns fetchDependencies that iterate over the given elements, with all other dependencies stubbed out.
+func testDeps(elements []interface{}) fetchDependencies {
+	return fetchDependencies{
+		iterateExposures:  iterFunc(elements),
+		iterateTombstones: tombstoneFunc(nil),
+		latestCreatedAt:   latestFunc(time.Now()),
+		writeFetchAudit:   func(context.Context, *fedmodel.FederationOutAudit) error { return nil },
+	}
+}
+
 // TestFetch tests the fetch() function.
 func TestFetch(t *testing.T) {
 	testCases := []struct {
@@ -248,9 +298,10 @@ func TestFetch(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			ctx := context.Background()
 			env := serverenv.New(ctx)
-			server := Server{env: env}
+			server := Server{env: env, config: &Config{}}
 			req := &pb.FederationFetchRequest{ExcludeRegionIdentifiers: tc.excludeRegions}
-			got, err := server.fetch(context.Background(), req, iterFunc(tc.iterations), time.Now())
+			deps := testDeps(tc.iterations)
+			got, err := server.fetch(context.Background(), req, deps, time.Now())
 			if err != nil {
 				t.Fatalf("fetch() returned err=%v, want err=nil", err)
 			}
@@ -261,171 +312,2994 @@ func TestFetch(t *testing.T) {
 	}
 }
 
-// TestRawToken tests rawToken().
-func TestRawToken(t *testing.T) {
-	want := "Abc123"
-	md := metadata.New(map[string]string{"authorization": fmt.Sprintf("Bearer %s", want)})
-	ctx := metadata.NewIncomingContext(context.Background(), md)
-
-	got, err := rawToken(ctx)
-	if err != nil {
-		t.Fatal(err)
+// TestFetchDeadline tests that fetch() respects the deadline on the incoming context.
+func TestFetchDeadline(t *testing.T) {
+	testCases := []struct {
+		name         string
+		timeout      time.Duration
+		minRemaining time.Duration
+		wantCode     codes.Code
+		wantIterate  bool
+	}{
+		{
+			name:         "sufficient time remaining",
+			timeout:      time.Minute,
+			minRemaining: time.Second,
+			wantCode:     codes.OK,
+			wantIterate:  true,
+		},
+		{
+			name:         "almost expired",
+			timeout:      time.Millisecond,
+			minRemaining: time.Second,
+			wantCode:     codes.DeadlineExceeded,
+			wantIterate:  false,
+		},
 	}
 
-	if got != want {
-		t.Errorf("rawToken()=%q, want=%q", got, want)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), tc.timeout)
+			defer cancel()
+			wantDeadline, _ := ctx.Deadline()
+
+			env := serverenv.New(ctx)
+			server := Server{env: env, config: &Config{MinRemainingTime: tc.minRemaining}}
+			iterated := false
+			itFunc := func(ctx context.Context, _ database.IterateExposuresCriteria, _ func(*model.Exposure) error) (string, error) {
+				iterated = true
+				if gotDeadline, ok := ctx.Deadline(); !ok || !gotDeadline.Equal(wantDeadline) {
+					t.Errorf("iterator deadline=%v, want=%v", gotDeadline, wantDeadline)
+				}
+				return "", nil
+			}
+
+			deps := testDeps(nil)
+			deps.iterateExposures = itFunc
+			_, err := server.fetch(ctx, &pb.FederationFetchRequest{}, deps, time.Now())
+			if got := status.Code(err); got != tc.wantCode {
+				t.Errorf("fetch() returned code=%v, want=%v (err=%v)", got, tc.wantCode, err)
+			}
+			if iterated != tc.wantIterate {
+				t.Errorf("iterated=%t, want=%t", iterated, tc.wantIterate)
+			}
+		})
 	}
 }
 
-// TestRawTokenErrors tests error responses from rawToken().
-func TestRawTokenErrors(t *testing.T) {
+// TestFetchUnchanged tests that fetch() skips iteration when no keys have been published since the last fetch.
+func TestFetchUnchanged(t *testing.T) {
 	testCases := []struct {
-		name  string
-		mdMap map[string][]string
+		name        string
+		latest      time.Time
+		wantIterate bool
 	}{
 		{
-			name:  "no metadata",
-			mdMap: map[string][]string{},
-		},
-		{
-			name:  "missing authorization header",
-			mdMap: map[string][]string{"not-auth": {"foo"}},
+			name:        "never published",
+			latest:      time.Time{},
+			wantIterate: false,
 		},
 		{
-			name:  "empty authorization header",
-			mdMap: map[string][]string{"authorization": {}},
+			name:        "published before last fetch",
+			latest:      time.Unix(400, 0),
+			wantIterate: false,
 		},
 		{
-			name:  "too many authorization headers",
-			mdMap: map[string][]string{"authorization": {"Bearer foo", "Bearer bar"}},
+			name:        "published at last fetch",
+			latest:      time.Unix(500, 0),
+			wantIterate: false,
 		},
 		{
-			name:  "incorrect authorization prefix",
-			mdMap: map[string][]string{"authorization": {"not-Bearer foo"}},
+			name:        "published after last fetch",
+			latest:      time.Unix(600, 0),
+			wantIterate: true,
 		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			ctx := metadata.NewIncomingContext(context.Background(), tc.mdMap)
-			_, gotErr := rawToken(ctx)
-			if gotErr == nil {
-				t.Errorf("missing error response")
+			ctx := context.Background()
+			env := serverenv.New(ctx)
+			server := Server{env: env, config: &Config{}}
+			iterated := false
+			deps := testDeps(nil)
+			deps.iterateExposures = func(context.Context, database.IterateExposuresCriteria, func(*model.Exposure) error) (string, error) {
+				iterated = true
+				return "", nil
+			}
+			deps.latestCreatedAt = latestFunc(tc.latest)
+			req := &pb.FederationFetchRequest{RegionIdentifiers: []string{"US"}, LastFetchResponseKeyTimestamp: 500}
+			got, err := server.fetch(ctx, req, deps, time.Now())
+			if err != nil {
+				t.Fatalf("fetch() returned err=%v, want err=nil", err)
+			}
+			if iterated != tc.wantIterate {
+				t.Errorf("iterated=%t, want=%t", iterated, tc.wantIterate)
+			}
+			if diff := cmp.Diff(&pb.FederationFetchResponse{}, got, listsAsSets...); diff != "" {
+				t.Errorf("fetch() mismatch (-want +got):\n%s", diff)
 			}
 		})
 	}
 }
 
-// TestIntersect tests intersect().
-func TestIntersect(t *testing.T) {
+// TestFetchStrictExclude tests excluded region handling for a key in both an included and an excluded region.
+func TestFetchStrictExclude(t *testing.T) {
 	testCases := []struct {
-		name string
-		aa   []string
-		bb   []string
-		want []string
+		name   string
+		strict bool
+		want   *pb.FederationFetchResponse
 	}{
 		{
-			name: "empty",
-			aa:   []string{},
-			bb:   []string{},
-			want: nil,
-		},
-		{
-			name: "aa only values",
-			aa:   []string{"1", "2"},
-			bb:   []string{},
-			want: nil,
+			name:   "lenient",
+			strict: false,
+			want: &pb.FederationFetchResponse{
+				Response: []*pb.ContactTracingResponse{
+					{
+						RegionIdentifiers: []string{"US"},
+						ContactTracingInfo: []*pb.ContactTracingInfo{
+							{TransmissionRisk: 1, ExposureKeys: []*pb.ExposureKey{aaa}},
+						},
+					},
+					{
+						RegionIdentifiers: []string{"CA", "US"},
+						ContactTracingInfo: []*pb.ContactTracingInfo{
+							{TransmissionRisk: 1, ExposureKeys: []*pb.ExposureKey{bbb}},
+						},
+					},
+				},
+				FetchResponseKeyTimestamp: 200,
+			},
 		},
 		{
-			name: "bb only values",
-			aa:   []string{},
-			bb:   []string{"1", "2"},
-			want: nil,
+			name:   "strict",
+			strict: true,
+			want: &pb.FederationFetchResponse{
+				Response: []*pb.ContactTracingResponse{
+					{
+						RegionIdentifiers: []string{"US"},
+						ContactTracingInfo: []*pb.ContactTracingInfo{
+							{TransmissionRisk: 1, ExposureKeys: []*pb.ExposureKey{aaa}},
+						},
+					},
+				},
+				FetchResponseKeyTimestamp: 100,
+			},
 		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			env := serverenv.New(ctx)
+			server := Server{env: env, config: &Config{StrictExclude: tc.strict}}
+			deps := testDeps([]interface{}{
+				makeExposure(aaa, 1, "US"),
+				makeExposure(bbb, 1, "US", "CA"),
+				makeExposure(ccc, 1, "CA"),
+			})
+			req := &pb.FederationFetchRequest{
+				RegionIdentifiers:        []string{"US"},
+				ExcludeRegionIdentifiers: []string{"CA"},
+			}
+			got, err := server.fetch(ctx, req, deps, time.Now())
+			if err != nil {
+				t.Fatalf("fetch() returned err=%v, want err=nil", err)
+			}
+			if diff := cmp.Diff(tc.want, got, listsAsSets...); diff != "" {
+				t.Errorf("fetch() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestFetchRegionWildcards tests prefix matching of included and excluded regions.
+func TestFetchRegionWildcards(t *testing.T) {
+	testCases := []struct {
+		name           string
+		includeRegions []string
+		excludeRegions []string
+		want           *pb.FederationFetchResponse
+	}{
 		{
-			name: "mutually exclusive",
-			aa:   []string{"1", "2"},
-			bb:   []string{"7", "8", "9"},
-			want: nil,
+			name:           "include prefix",
+			includeRegions: []string{"us-*"},
+			want: &pb.FederationFetchResponse{
+				Response: []*pb.ContactTracingResponse{
+					{
+						RegionIdentifiers: []string{"US-CA"},
+						ContactTracingInfo: []*pb.ContactTracingInfo{
+							{TransmissionRisk: 1, ExposureKeys: []*pb.ExposureKey{aaa}},
+						},
+					},
+					{
+						RegionIdentifiers: []string{"US-NY"},
+						ContactTracingInfo: []*pb.ContactTracingInfo{
+							{TransmissionRisk: 1, ExposureKeys: []*pb.ExposureKey{bbb}},
+						},
+					},
+				},
+				FetchResponseKeyTimestamp: 200,
+			},
 		},
 		{
-			name: "full overlap",
-			aa:   []string{"1", "2"},
-			bb:   []string{"1", "2"},
-			want: []string{"1", "2"},
+			name:           "exclude prefix",
+			excludeRegions: []string{"US-*"},
+			want: &pb.FederationFetchResponse{
+				Response: []*pb.ContactTracingResponse{
+					{
+						RegionIdentifiers: []string{"US"},
+						ContactTracingInfo: []*pb.ContactTracingInfo{
+							{TransmissionRisk: 1, ExposureKeys: []*pb.ExposureKey{ccc}},
+						},
+					},
+					{
+						RegionIdentifiers: []string{"CA"},
+						ContactTracingInfo: []*pb.ContactTracingInfo{
+							{TransmissionRisk: 1, ExposureKeys: []*pb.ExposureKey{ddd}},
+						},
+					},
+				},
+				FetchResponseKeyTimestamp: 400,
+			},
 		},
 		{
-			name: "partial overlap",
-			aa:   []string{"1", "2", "3"},
-			bb:   []string{"2", "3", "4", "5"},
-			want: []string{"2", "3"},
+			name:           "include prefix and exclude exact",
+			includeRegions: []string{"US*"},
+			excludeRegions: []string{"US-NY"},
+			want: &pb.FederationFetchResponse{
+				Response: []*pb.ContactTracingResponse{
+					{
+						RegionIdentifiers: []string{"US-CA"},
+						ContactTracingInfo: []*pb.ContactTracingInfo{
+							{TransmissionRisk: 1, ExposureKeys: []*pb.ExposureKey{aaa}},
+						},
+					},
+					{
+						RegionIdentifiers: []string{"US"},
+						ContactTracingInfo: []*pb.ContactTracingInfo{
+							{TransmissionRisk: 1, ExposureKeys: []*pb.ExposureKey{ccc}},
+						},
+					},
+				},
+				FetchResponseKeyTimestamp: 300,
+			},
 		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			got := intersect(tc.aa, tc.bb)
-
-			if diff := cmp.Diff(tc.want, got); diff != "" {
-				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			ctx := context.Background()
+			env := serverenv.New(ctx)
+			server := Server{env: env, config: &Config{}}
+			deps := testDeps([]interface{}{
+				makeExposure(aaa, 1, "US-CA"),
+				makeExposure(bbb, 1, "US-NY"),
+				makeExposure(ccc, 1, "US"),
+				makeExposure(ddd, 1, "CA"),
+			})
+			req := &pb.FederationFetchRequest{
+				RegionIdentifiers:        tc.includeRegions,
+				ExcludeRegionIdentifiers: tc.excludeRegions,
+			}
+			got, err := server.fetch(ctx, req, deps, time.Now())
+			if err != nil {
+				t.Fatalf("fetch() returned err=%v, want err=nil", err)
+			}
+			if diff := cmp.Diff(tc.want, got, listsAsSets...); diff != "" {
+				t.Errorf("fetch() mismatch (-want +got):\n%s", diff)
 			}
 		})
 	}
 }
 
-// TestUnion tests union().
-func TestUnion(t *testing.T) {
+// TestFetchEffectiveCriteria tests that the effective criteria are echoed when requested.
+func TestFetchEffectiveCriteria(t *testing.T) {
+	until := time.Unix(1000, 0)
 	testCases := []struct {
-		name string
-		aa   []string
-		bb   []string
-		want []string
+		name   string
+		req    *pb.FederationFetchRequest
+		auth   *fedmodel.FederationOutAuthorization
+		latest time.Time
+		want   *pb.EffectiveCriteria
 	}{
 		{
-			name: "empty",
-			aa:   []string{},
-			bb:   []string{},
-			want: []string{},
-		},
-		{
-			name: "aa only values",
-			aa:   []string{"1", "2"},
-			bb:   []string{},
-			want: []string{"1", "2"},
-		},
-		{
-			name: "bb only values",
-			aa:   []string{},
-			bb:   []string{"1", "2"},
-			want: []string{"1", "2"},
+			name: "not requested",
+			req:  &pb.FederationFetchRequest{RegionIdentifiers: []string{"us"}},
+			want: nil,
 		},
 		{
-			name: "mutually exclusive",
-			aa:   []string{"1", "2"},
-			bb:   []string{"7", "8", "9"},
-			want: []string{"1", "2", "7", "8", "9"},
+			name: "normalized regions",
+			req: &pb.FederationFetchRequest{
+				RegionIdentifiers:             []string{"us", "ca"},
+				ExcludeRegionIdentifiers:      []string{"mx"},
+				LastFetchResponseKeyTimestamp: 50,
+				Debug:                         true,
+			},
+			auth: &fedmodel.FederationOutAuthorization{
+				IncludeRegions: []string{"US"},
+				ExcludeRegions: []string{"GB"},
+			},
+			want: &pb.EffectiveCriteria{
+				RegionIdentifiers:        []string{"US"},
+				ExcludeRegionIdentifiers: []string{"GB", "MX"},
+				SinceTimestamp:           50,
+				UntilTimestamp:           1000,
+			},
 		},
 		{
-			name: "full overlap",
-			aa:   []string{"1", "2"},
-			bb:   []string{"1", "2"},
-			want: []string{"1", "2"},
+			name: "full refresh",
+			req:  &pb.FederationFetchRequest{Debug: true},
+			want: &pb.EffectiveCriteria{
+				UntilTimestamp: 1000,
+				FullRefresh:    true,
+			},
 		},
 		{
-			name: "partial overlap",
-			aa:   []string{"1", "2", "3"},
-			bb:   []string{"2", "3", "4", "5"},
-			want: []string{"1", "2", "3", "4", "5"},
+			name:   "unchanged",
+			req:    &pb.FederationFetchRequest{LastFetchResponseKeyTimestamp: 500, Debug: true},
+			latest: time.Unix(400, 0),
+			want: &pb.EffectiveCriteria{
+				SinceTimestamp: 500,
+				UntilTimestamp: 1000,
+				Unchanged:      true,
+			},
 		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			got := union(tc.aa, tc.bb)
-
-			if diff := cmp.Diff(tc.want, got); diff != "" {
-				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			ctx := context.Background()
+			if tc.auth != nil {
+				ctx = context.WithValue(ctx, authKey{}, tc.auth)
+			}
+			env := serverenv.New(ctx)
+			server := Server{env: env, config: &Config{}}
+			deps := testDeps([]interface{}{makeExposure(aaa, 1, "US")})
+			if !tc.latest.IsZero() {
+				deps.latestCreatedAt = latestFunc(tc.latest)
+			}
+			got, err := server.fetch(ctx, tc.req, deps, until)
+			if err != nil {
+				t.Fatalf("fetch() returned err=%v, want err=nil", err)
+			}
+			if diff := cmp.Diff(tc.want, got.EffectiveCriteria, protocmp.Transform()); diff != "" {
+				t.Errorf("EffectiveCriteria mismatch (-want +got):\n%s", diff)
 			}
 		})
 	}
 }
+
+// TestFetchKeyTransform tests that the key transform hook can drop and modify keys.
+func TestFetchKeyTransform(t *testing.T) {
+	ctx := context.Background()
+	env := serverenv.New(ctx)
+	transform := func(e *model.Exposure) (*model.Exposure, bool) {
+		if string(e.ExposureKey) == "bbb" {
+			return nil, false
+		}
+		e.TransmissionRisk = 5
+		return e, true
+	}
+	server := Server{env: env, config: &Config{}}
+	WithKeyTransform(transform)(&server)
+
+	deps := testDeps([]interface{}{
+		makeExposure(aaa, 1, "US"),
+		makeExposure(bbb, 1, "US"),
+		makeExposure(ccc, 2, "US"),
+	})
+	got, err := server.fetch(ctx, &pb.FederationFetchRequest{}, deps, time.Now())
+	if err != nil {
+		t.Fatalf("fetch() returned err=%v, want err=nil", err)
+	}
+	want := &pb.FederationFetchResponse{
+		Response: []*pb.ContactTracingResponse{
+			{
+				RegionIdentifiers: []string{"US"},
+				ContactTracingInfo: []*pb.ContactTracingInfo{
+					{TransmissionRisk: 5, ExposureKeys: []*pb.ExposureKey{aaa, ccc}},
+				},
+			},
+		},
+		FetchResponseKeyTimestamp: 300,
+	}
+	if diff := cmp.Diff(want, got, listsAsSets...); diff != "" {
+		t.Errorf("fetch() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// TestFetchDuplicateRegions tests that duplicate regions on an exposure are collapsed before grouping.
+func TestFetchDuplicateRegions(t *testing.T) {
+	ctx := context.Background()
+	env := serverenv.New(ctx)
+	server := Server{env: env, config: &Config{}}
+	deps := testDeps([]interface{}{
+		makeExposure(aaa, 1, "US", "US", "CA"),
+		makeExposure(bbb, 1, "CA", "US"),
+		makeExposure(ccc, 1, "CA", "CA"),
+	})
+	got, err := server.fetch(ctx, &pb.FederationFetchRequest{}, deps, time.Now())
+	if err != nil {
+		t.Fatalf("fetch() returned err=%v, want err=nil", err)
+	}
+	want := &pb.FederationFetchResponse{
+		Response: []*pb.ContactTracingResponse{
+			{
+				RegionIdentifiers: []string{"CA", "US"},
+				ContactTracingInfo: []*pb.ContactTracingInfo{
+					{TransmissionRisk: 1, ExposureKeys: []*pb.ExposureKey{aaa, bbb}},
+				},
+			},
+			{
+				RegionIdentifiers: []string{"CA"},
+				ContactTracingInfo: []*pb.ContactTracingInfo{
+					{TransmissionRisk: 1, ExposureKeys: []*pb.ExposureKey{ccc}},
+				},
+			},
+		},
+		FetchResponseKeyTimestamp: 300,
+	}
+	if diff := cmp.Diff(want, got, listsAsSets...); diff != "" {
+		t.Errorf("fetch() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// TestFetchAsOf tests that an as-of fetch excludes keys created after the (truncated) as-of time,
+// and is only permitted for authorized clients.
+func TestFetchAsOf(t *testing.T) {
+	until := time.Unix(1000, 0)
+	testCases := []struct {
+		name      string
+		auth      *fedmodel.FederationOutAuthorization
+		asOf      int64
+		wantKeys  []*pb.ExposureKey
+		wantUntil int64
+		wantCode  codes.Code
+	}{
+		{
+			name:      "live",
+			wantKeys:  []*pb.ExposureKey{aaa, bbb, ccc, ddd},
+			wantUntil: 1000,
+		},
+		{
+			name:      "as of past time",
+			asOf:      250,
+			wantKeys:  []*pb.ExposureKey{aaa}, // Truncated to 200, and bbb was created at exactly 200.
+			wantUntil: 200,
+		},
+		{
+			name:      "as of future time",
+			asOf:      5000,
+			wantKeys:  []*pb.ExposureKey{aaa, bbb, ccc, ddd},
+			wantUntil: 1000,
+		},
+		{
+			name:      "authorized client",
+			auth:      &fedmodel.FederationOutAuthorization{IncludeRegions: []string{"US"}, AllowHistorical: true},
+			asOf:      350,
+			wantKeys:  []*pb.ExposureKey{aaa, bbb},
+			wantUntil: 300,
+		},
+		{
+			name:     "unauthorized client",
+			auth:     &fedmodel.FederationOutAuthorization{IncludeRegions: []string{"US"}},
+			asOf:     350,
+			wantCode: codes.PermissionDenied,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.auth != nil {
+				ctx = context.WithValue(ctx, authKey{}, tc.auth)
+			}
+			env := serverenv.New(ctx)
+			server := Server{env: env, config: &Config{TruncateWindow: 100 * time.Second}}
+			deps := testDeps([]interface{}{
+				makeExposure(aaa, 1, "US"),
+				makeExposure(bbb, 1, "US"),
+				makeExposure(ccc, 1, "US"),
+				makeExposure(ddd, 1, "US"),
+			})
+			got, err := server.fetch(ctx, &pb.FederationFetchRequest{AsOfTimestamp: tc.asOf, Debug: true}, deps, until)
+			if tc.wantCode != codes.OK {
+				if code := status.Code(err); code != tc.wantCode {
+					t.Fatalf("fetch() returned err=%v, want code %v", err, tc.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("fetch() returned err=%v, want err=nil", err)
+			}
+
+			var gotKeys []*pb.ExposureKey
+			for _, ctr := range got.Response {
+				for _, cti := range ctr.ContactTracingInfo {
+					gotKeys = append(gotKeys, cti.ExposureKeys...)
+				}
+			}
+			if diff := cmp.Diff(tc.wantKeys, gotKeys, protocmp.Transform()); diff != "" {
+				t.Errorf("keys mismatch (-want +got):\n%s", diff)
+			}
+			if got.EffectiveCriteria.UntilTimestamp != tc.wantUntil {
+				t.Errorf("untilTimestamp=%d, want %d", got.EffectiveCriteria.UntilTimestamp, tc.wantUntil)
+			}
+		})
+	}
+}
+
+// TestFetchUntilOverride tests that untilTimestampOverride replaces the end of the last complete
+// window, is only permitted for authorized clients, and cannot be in the future.
+func TestFetchUntilOverride(t *testing.T) {
+	until := time.Unix(1000, 0)
+	testCases := []struct {
+		name      string
+		auth      *fedmodel.FederationOutAuthorization
+		override  int64
+		wantKeys  []*pb.ExposureKey
+		wantUntil int64
+		wantCode  codes.Code
+	}{
+		{
+			name:      "before window end",
+			override:  250,
+			wantKeys:  []*pb.ExposureKey{aaa, bbb}, // Not truncated, unlike asOfTimestamp.
+			wantUntil: 250,
+		},
+		{
+			name:      "after window end",
+			override:  1500,
+			wantKeys:  []*pb.ExposureKey{aaa, bbb, ccc, ddd},
+			wantUntil: 1500,
+		},
+		{
+			name:      "authorized client",
+			auth:      &fedmodel.FederationOutAuthorization{IncludeRegions: []string{"US"}, AllowBackfill: true},
+			override:  350,
+			wantKeys:  []*pb.ExposureKey{aaa, bbb, ccc},
+			wantUntil: 350,
+		},
+		{
+			name:     "unauthorized client",
+			auth:     &fedmodel.FederationOutAuthorization{IncludeRegions: []string{"US"}, AllowHistorical: true},
+			override: 350,
+			wantCode: codes.PermissionDenied,
+		},
+		{
+			name:     "beyond now",
+			override: time.Now().Add(time.Hour).Unix(),
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "negative",
+			override: -1,
+			wantCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.auth != nil {
+				ctx = context.WithValue(ctx, authKey{}, tc.auth)
+			}
+			server := Server{env: serverenv.New(ctx), config: &Config{TruncateWindow: 100 * time.Second}}
+			deps := testDeps([]interface{}{
+				makeExposure(aaa, 1, "US"),
+				makeExposure(bbb, 1, "US"),
+				makeExposure(ccc, 1, "US"),
+				makeExposure(ddd, 1, "US"),
+			})
+			got, err := server.fetch(ctx, &pb.FederationFetchRequest{RegionIdentifiers: []string{"US"}, UntilTimestampOverride: tc.override, Debug: true}, deps, until)
+			if tc.wantCode != codes.OK {
+				if code := status.Code(err); code != tc.wantCode {
+					t.Fatalf("fetch() returned err=%v, want code %v", err, tc.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("fetch() returned err=%v, want err=nil", err)
+			}
+
+			var gotKeys []*pb.ExposureKey
+			for _, ctr := range got.Response {
+				for _, cti := range ctr.ContactTracingInfo {
+					gotKeys = append(gotKeys, cti.ExposureKeys...)
+				}
+			}
+			if diff := cmp.Diff(tc.wantKeys, gotKeys, protocmp.Transform()); diff != "" {
+				t.Errorf("keys mismatch (-want +got):\n%s", diff)
+			}
+			if got.EffectiveCriteria.UntilTimestamp != tc.wantUntil || got.EffectiveCriteria.UntilTimestampOverride != tc.override {
+				t.Errorf("untilTimestamp=%d, untilTimestampOverride=%d, want %d, %d",
+					got.EffectiveCriteria.UntilTimestamp, got.EffectiveCriteria.UntilTimestampOverride, tc.wantUntil, tc.override)
+			}
+		})
+	}
+}
+
+// TestFetchRelativeSince tests that relativeSinceSeconds sets the since-floor relative to the end of
+// the last complete window, and that it cannot be combined with lastFetchResponseKeyTimestamp.
+func TestFetchRelativeSince(t *testing.T) {
+	until := time.Unix(1000, 0)
+	testCases := []struct {
+		name            string
+		req             *pb.FederationFetchRequest
+		wantSince       int64
+		wantFullRefresh bool
+		wantCode        codes.Code
+	}{
+		{
+			name:            "full refresh",
+			req:             &pb.FederationFetchRequest{},
+			wantSince:       0,
+			wantFullRefresh: true,
+		},
+		{
+			name:      "last fetch timestamp",
+			req:       &pb.FederationFetchRequest{LastFetchResponseKeyTimestamp: 400},
+			wantSince: 400,
+		},
+		{
+			name:      "relative window",
+			req:       &pb.FederationFetchRequest{RelativeSinceSeconds: 300},
+			wantSince: 700,
+		},
+		{
+			name:     "mutually exclusive",
+			req:      &pb.FederationFetchRequest{RelativeSinceSeconds: 300, LastFetchResponseKeyTimestamp: 400},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "negative",
+			req:      &pb.FederationFetchRequest{RelativeSinceSeconds: -300},
+			wantCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			env := serverenv.New(ctx)
+			server := Server{env: env, config: &Config{TruncateWindow: 100 * time.Second}}
+
+			var gotCriteria database.IterateExposuresCriteria
+			deps := testDeps([]interface{}{makeExposure(aaa, 1, "US")})
+			iterate := deps.iterateExposures
+			deps.iterateExposures = func(ctx context.Context, criteria database.IterateExposuresCriteria, f func(*model.Exposure) error) (string, error) {
+				gotCriteria = criteria
+				return iterate(ctx, criteria, f)
+			}
+
+			tc.req.Debug = true
+			got, err := server.fetch(ctx, tc.req, deps, until)
+			if tc.wantCode != codes.OK {
+				if code := status.Code(err); code != tc.wantCode {
+					t.Fatalf("fetch() returned err=%v, want code %v", err, tc.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("fetch() returned err=%v, want err=nil", err)
+			}
+
+			if since := gotCriteria.SinceTimestamp.Unix(); since != tc.wantSince {
+				t.Errorf("query sinceTimestamp=%d, want %d", since, tc.wantSince)
+			}
+			if got.EffectiveCriteria.SinceTimestamp != tc.wantSince {
+				t.Errorf("effective sinceTimestamp=%d, want %d", got.EffectiveCriteria.SinceTimestamp, tc.wantSince)
+			}
+			if got.EffectiveCriteria.FullRefresh != tc.wantFullRefresh {
+				t.Errorf("fullRefresh=%v, want %v", got.EffectiveCriteria.FullRefresh, tc.wantFullRefresh)
+			}
+		})
+	}
+}
+
+// TestFetchExplainQuery tests that query plans are only requested when enabled, and at the sample rate.
+func TestFetchExplainQuery(t *testing.T) {
+	testCases := []struct {
+		name       string
+		enabled    bool
+		sampleRate float64
+		want       bool
+	}{
+		{name: "disabled", enabled: false, sampleRate: 1},
+		{name: "enabled and sampled", enabled: true, sampleRate: 1, want: true},
+		{name: "enabled and not sampled", enabled: true, sampleRate: 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			env := serverenv.New(ctx)
+			server := Server{env: env, config: &Config{ExplainQueries: tc.enabled, ExplainSampleRate: tc.sampleRate}}
+
+			var got bool
+			deps := testDeps([]interface{}{makeExposure(aaa, 1, "US")})
+			iterate := deps.iterateExposures
+			deps.iterateExposures = func(ctx context.Context, criteria database.IterateExposuresCriteria, f func(*model.Exposure) error) (string, error) {
+				got = criteria.ExplainQuery
+				return iterate(ctx, criteria, f)
+			}
+
+			if _, err := server.fetch(ctx, &pb.FederationFetchRequest{}, deps, time.Now()); err != nil {
+				t.Fatalf("fetch() returned err=%v, want err=nil", err)
+			}
+			if got != tc.want {
+				t.Errorf("ExplainQuery=%v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// TestFetchSinceOverlap tests that a key committed after a fetch, with a CreatedAt before that fetch's
+// fetchResponseKeyTimestamp, is served by the next fetch when an overlap is configured.
+func TestFetchSinceOverlap(t *testing.T) {
+	ctx := context.Background()
+	until := time.Unix(1000, 0)
+
+	// serve iterates the exposures that have been committed, honoring the since-floor.
+	var committed []*model.Exposure
+	serve := func(_ context.Context, criteria database.IterateExposuresCriteria, f func(*model.Exposure) error) (string, error) {
+		for _, e := range committed {
+			if e.CreatedAt.Before(criteria.SinceTimestamp) {
+				continue
+			}
+			if err := f(e); err != nil {
+				return "", err
+			}
+		}
+		return "", nil
+	}
+	keys := func(resp *pb.FederationFetchResponse) []*pb.ExposureKey {
+		var ks []*pb.ExposureKey
+		for _, ctr := range resp.Response {
+			for _, cti := range ctr.ContactTracingInfo {
+				ks = append(ks, cti.ExposureKeys...)
+			}
+		}
+		return ks
+	}
+
+	testCases := []struct {
+		name    string
+		overlap time.Duration
+		want    []*pb.ExposureKey
+	}{
+		{
+			name: "no overlap",
+			want: []*pb.ExposureKey{ccc}, // The late key aaa is skipped.
+		},
+		{
+			name:    "overlap",
+			overlap: 250 * time.Second,
+			want:    []*pb.ExposureKey{aaa, bbb, ccc}, // bbb and ccc are served again, for the client to ignore.
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			env := serverenv.New(ctx)
+			server := Server{env: env, config: &Config{SinceOverlap: tc.overlap}}
+			deps := testDeps(nil)
+			deps.iterateExposures = serve
+
+			// The first fetch runs before aaa, which is on the window boundary, is committed.
+			committed = []*model.Exposure{makeExposure(bbb, 1, "US"), makeExposure(ccc, 1, "US")}
+			first, err := server.fetch(ctx, &pb.FederationFetchRequest{}, deps, until)
+			if err != nil {
+				t.Fatalf("first fetch() returned err=%v, want err=nil", err)
+			}
+			if first.FetchResponseKeyTimestamp != 300 {
+				t.Fatalf("first fetchResponseKeyTimestamp=%d, want 300", first.FetchResponseKeyTimestamp)
+			}
+
+			// aaa is committed late, with a CreatedAt before the client's timestamp.
+			committed = append([]*model.Exposure{makeExposure(aaa, 1, "US")}, committed...)
+			second, err := server.fetch(ctx, &pb.FederationFetchRequest{LastFetchResponseKeyTimestamp: first.FetchResponseKeyTimestamp}, deps, until)
+			if err != nil {
+				t.Fatalf("second fetch() returned err=%v, want err=nil", err)
+			}
+			if diff := cmp.Diff(tc.want, keys(second), protocmp.Transform()); diff != "" {
+				t.Errorf("keys mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestFetchExpiresAt tests that keys past their individual ExpiresAt are not served.
+func TestFetchExpiresAt(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	expired := makeExposure(aaa, 1, "US")
+	expired.ExpiresAt = now.Add(-time.Hour)
+	unexpired := makeExposure(bbb, 1, "US")
+	unexpired.ExpiresAt = now.Add(time.Hour)
+
+	var criteria database.IterateExposuresCriteria
+	deps := testDeps([]interface{}{expired, unexpired})
+	iterate := deps.iterateExposures
+	deps.iterateExposures = func(ctx context.Context, c database.IterateExposuresCriteria, f func(*model.Exposure) error) (string, error) {
+		criteria = c
+		return iterate(ctx, c, f)
+	}
+
+	server := Server{env: serverenv.New(ctx), config: &Config{}}
+	resp, err := server.fetch(ctx, &pb.FederationFetchRequest{}, deps, time.Unix(1000, 0))
+	if err != nil {
+		t.Fatalf("fetch() returned err=%v, want err=nil", err)
+	}
+
+	if criteria.NotExpiredAt.Before(now) {
+		t.Errorf("NotExpiredAt=%v, want at or after %v", criteria.NotExpiredAt, now)
+	}
+	want := []*pb.ContactTracingResponse{
+		{
+			RegionIdentifiers: []string{"US"},
+			ContactTracingInfo: []*pb.ContactTracingInfo{
+				{TransmissionRisk: 1, ExposureKeys: []*pb.ExposureKey{bbb}},
+			},
+		},
+	}
+	if diff := cmp.Diff(want, resp.Response, protocmp.Transform()); diff != "" {
+		t.Errorf("response mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// TestFetchFilterSupport tests that fetch filters in memory only the criteria the store does not apply.
+func TestFetchFilterSupport(t *testing.T) {
+	ctx := context.Background()
+
+	local := makeExposure(aaa, 1, "US")
+	federated := makeExposure(bbb, 1, "US")
+	federated.LocalProvenance = false
+	otherNamespace := makeExposure(ccc, 1, "US")
+	otherNamespace.Namespace = "tenant-b"
+	expired := makeExposure(ddd, 1, "US")
+	expired.ExpiresAt = time.Now().Add(-time.Hour)
+
+	testCases := []struct {
+		name    string
+		filters database.FilterSupport
+		want    []*pb.ExposureKey
+	}{
+		{
+			name:    "no support",
+			filters: database.FilterSupport{},
+			want:    []*pb.ExposureKey{aaa},
+		},
+		{
+			// The store is trusted to have filtered already, so nothing is dropped again.
+			name:    "full support",
+			filters: database.FullFilterSupport,
+			want:    []*pb.ExposureKey{aaa, bbb, ccc, ddd},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			deps := testDeps([]interface{}{local, federated, otherNamespace, expired})
+			deps.filters = tc.filters
+
+			server := Server{env: serverenv.New(ctx), config: &Config{}}
+			resp, err := server.fetch(ctx, &pb.FederationFetchRequest{}, deps, time.Unix(1000, 0))
+			if err != nil {
+				t.Fatalf("fetch() returned err=%v, want err=nil", err)
+			}
+			want := []*pb.ContactTracingResponse{
+				{
+					RegionIdentifiers: []string{"US"},
+					ContactTracingInfo: []*pb.ContactTracingInfo{
+						{TransmissionRisk: 1, ExposureKeys: tc.want},
+					},
+				},
+			}
+			if diff := cmp.Diff(want, resp.Response, protocmp.Transform()); diff != "" {
+				t.Errorf("response mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestFetchMinTransmissionRisk tests that only keys at or above the requested risk are served, whether
+// the store or fetch filters them.
+func TestFetchMinTransmissionRisk(t *testing.T) {
+	ctx := context.Background()
+	exposures := []*model.Exposure{
+		makeExposure(aaa, 1, "US"),
+		makeExposure(bbb, 4, "US"),
+		makeExposure(ccc, 5, "US"),
+		makeExposure(ddd, 8, "US"),
+	}
+	want := []*pb.ContactTracingResponse{
+		{
+			RegionIdentifiers: []string{"US"},
+			ContactTracingInfo: []*pb.ContactTracingInfo{
+				{TransmissionRisk: 5, ExposureKeys: []*pb.ExposureKey{ccc}},
+				{TransmissionRisk: 8, ExposureKeys: []*pb.ExposureKey{ddd}},
+			},
+		},
+	}
+
+	testCases := []struct {
+		name    string
+		filters database.FilterSupport
+	}{
+		{name: "filtered in memory", filters: database.FilterSupport{}},
+		{name: "filtered by store", filters: database.FullFilterSupport},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			deps := testDeps(nil)
+			deps.filters = tc.filters
+			deps.iterateExposures = func(_ context.Context, criteria database.IterateExposuresCriteria, f func(*model.Exposure) error) (string, error) {
+				for _, e := range exposures {
+					copied := *e
+					// A store which supports the filter applies it to the query.
+					if tc.filters.TransmissionRisk && copied.TransmissionRisk < criteria.MinTransmissionRisk {
+						continue
+					}
+					if err := f(&copied); err != nil {
+						return "", err
+					}
+				}
+				return "", nil
+			}
+
+			server := Server{env: serverenv.New(ctx), config: &Config{}}
+			resp, err := server.fetch(ctx, &pb.FederationFetchRequest{MinTransmissionRisk: 5}, deps, time.Unix(1000, 0))
+			if err != nil {
+				t.Fatalf("fetch() returned err=%v, want err=nil", err)
+			}
+			if diff := cmp.Diff(want, resp.Response, protocmp.Transform()); diff != "" {
+				t.Errorf("response mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	for _, risk := range []int32{-1, 9} {
+		server := Server{env: serverenv.New(ctx), config: &Config{}}
+		_, err := server.fetch(ctx, &pb.FederationFetchRequest{MinTransmissionRisk: risk}, testDeps(nil), time.Unix(1000, 0))
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("fetch() with minTransmissionRisk %d returned err=%v, want InvalidArgument", risk, err)
+		}
+	}
+}
+
+// TestFetchRegionPrecedence tests regions which are both included and excluded under each precedence.
+func TestFetchRegionPrecedence(t *testing.T) {
+	ctx := context.Background()
+	exposures := []interface{}{
+		makeExposure(aaa, 1, "US"),
+		makeExposure(bbb, 1, "CA"),
+		makeExposure(ccc, 1, "CA", "MX"),
+	}
+
+	testCases := []struct {
+		name       string
+		precedence string
+		want       []*pb.ExposureKey
+	}{
+		{
+			name:       "exclude wins",
+			precedence: RegionPrecedenceExcludeWins,
+			want:       []*pb.ExposureKey{aaa, ccc}, // ccc is also in MX, which is not excluded.
+		},
+		{
+			name:       "include wins",
+			precedence: RegionPrecedenceIncludeWins,
+			want:       []*pb.ExposureKey{aaa, bbb, ccc},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var criteria database.IterateExposuresCriteria
+			deps := testDeps(exposures)
+			iterate := deps.iterateExposures
+			deps.iterateExposures = func(ctx context.Context, c database.IterateExposuresCriteria, f func(*model.Exposure) error) (string, error) {
+				criteria = c
+				return iterate(ctx, c, f)
+			}
+
+			server := Server{env: serverenv.New(ctx), config: &Config{RegionPrecedence: tc.precedence}}
+			req := &pb.FederationFetchRequest{
+				RegionIdentifiers:        []string{"US", "CA"},
+				ExcludeRegionIdentifiers: []string{"CA"},
+				Debug:                    true,
+			}
+			resp, err := server.fetch(ctx, req, deps, time.Unix(1000, 0))
+			if err != nil {
+				t.Fatalf("fetch() returned err=%v, want err=nil", err)
+			}
+
+			var got []*pb.ExposureKey
+			for _, ctr := range resp.Response {
+				for _, cti := range ctr.ContactTracingInfo {
+					got = append(got, cti.ExposureKeys...)
+				}
+			}
+			sort.Slice(got, func(i, j int) bool { return bytes.Compare(got[i].ExposureKey, got[j].ExposureKey) < 0 })
+			if diff := cmp.Diff(tc.want, got, protocmp.Transform()); diff != "" {
+				t.Errorf("keys mismatch (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff([]string{"CA"}, resp.EffectiveCriteria.OverlappingRegionIdentifiers); diff != "" {
+				t.Errorf("overlap mismatch (-want +got):\n%s", diff)
+			}
+			if got := resp.EffectiveCriteria.RegionPrecedence; got != tc.precedence {
+				t.Errorf("regionPrecedence=%q, want %q", got, tc.precedence)
+			}
+			// The database cannot apply an exclusion which an inclusion overrides.
+			if wantQueryExclude := tc.precedence == RegionPrecedenceExcludeWins; (len(criteria.ExcludeRegions) > 0) != wantQueryExclude {
+				t.Errorf("query ExcludeRegions=%v, want excluded in query=%v", criteria.ExcludeRegions, wantQueryExclude)
+			}
+		})
+	}
+}
+
+// TestRegionExcludedIncludeWins tests that under include-wins, only the identical inclusion overrides
+// an exclusion, and not a wildcard which is broader or narrower.
+func TestRegionExcludedIncludeWins(t *testing.T) {
+	testCases := []struct {
+		name    string
+		include []string
+		exclude []string
+		region  string
+		want    bool
+	}{
+		{name: "not excluded", include: []string{"US-*"}, exclude: []string{"CA"}, region: "US-CA", want: false},
+		{name: "same region", include: []string{"US-CA"}, exclude: []string{"US-CA"}, region: "US-CA", want: false},
+		{name: "same wildcard", include: []string{"US-*"}, exclude: []string{"US-*"}, region: "US-CA", want: false},
+		{name: "wildcard include, exact exclude", include: []string{"US-*"}, exclude: []string{"US-CA"}, region: "US-CA", want: true},
+		{name: "exact include, wildcard exclude", include: []string{"US-CA"}, exclude: []string{"US-*"}, region: "US-CA", want: true},
+		{name: "exact exclude within same wildcard", include: []string{"US-*"}, exclude: []string{"US-*", "US-CA"}, region: "US-CA", want: true},
+		{name: "other region within same wildcard", include: []string{"US-*"}, exclude: []string{"US-*", "US-CA"}, region: "US-NY", want: false},
+	}
+
+	server := Server{config: &Config{RegionPrecedence: RegionPrecedenceIncludeWins}}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := server.regionExcluded(tc.region, newRegionMatcher(tc.include), newRegionMatcher(tc.exclude))
+			if got != tc.want {
+				t.Errorf("regionExcluded(%q) with include %v, exclude %v = %v, want %v", tc.region, tc.include, tc.exclude, got, tc.want)
+			}
+		})
+	}
+}
+
+// TestFetchStrictValidation tests that a fetch which excludes every region it includes is rejected if configured.
+func TestFetchStrictValidation(t *testing.T) {
+	testCases := []struct {
+		name       string
+		strict     bool
+		precedence string
+		include    []string
+		exclude    []string
+		auth       *fedmodel.FederationOutAuthorization
+		wantCode   codes.Code
+	}{
+		{
+			name:    "not strict",
+			include: []string{"US", "CA"},
+			exclude: []string{"CA", "US"},
+		},
+		{
+			name:     "all excluded",
+			strict:   true,
+			include:  []string{"US", "CA"},
+			exclude:  []string{"CA", "US"},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "excluded by authorization",
+			strict:   true,
+			include:  []string{"US"},
+			auth:     &fedmodel.FederationOutAuthorization{IncludeRegions: []string{"US"}, ExcludeRegions: []string{"US"}},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "excluded by wildcard",
+			strict:   true,
+			include:  []string{"US-CA", "US-NY"},
+			exclude:  []string{"US-*"},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:    "wildcard partly excluded",
+			strict:  true,
+			include: []string{"US-*"},
+			exclude: []string{"US-CA"},
+		},
+		{
+			name:    "one region remains",
+			strict:  true,
+			include: []string{"US", "CA"},
+			exclude: []string{"CA"},
+		},
+		{
+			name:       "include wins",
+			strict:     true,
+			precedence: RegionPrecedenceIncludeWins,
+			include:    []string{"US"},
+			exclude:    []string{"US"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.auth != nil {
+				ctx = context.WithValue(ctx, authKey{}, tc.auth)
+			}
+			server := Server{env: serverenv.New(ctx), config: &Config{StrictValidation: tc.strict, RegionPrecedence: tc.precedence}}
+			req := &pb.FederationFetchRequest{RegionIdentifiers: tc.include, ExcludeRegionIdentifiers: tc.exclude}
+			_, err := server.fetch(ctx, req, testDeps([]interface{}{makeExposure(aaa, 1, "US")}), time.Unix(1000, 0))
+			if got := status.Code(err); got != tc.wantCode {
+				t.Errorf("fetch() returned err=%v, want code %v", err, tc.wantCode)
+			}
+		})
+	}
+}
+
+// TestFetchExplodeRegions tests that explodeRegions serves a multi-region key under each applicable
+// requested region, rather than under its set of regions.
+func TestFetchExplodeRegions(t *testing.T) {
+	exposures := []interface{}{
+		makeExposure(aaa, 1, "US", "CA"),
+		makeExposure(bbb, 1, "US"),
+		makeExposure(ccc, 2, "CA", "MX"),
+	}
+
+	testCases := []struct {
+		name string
+		req  *pb.FederationFetchRequest
+		want []*pb.ContactTracingResponse
+	}{
+		{
+			name: "grouped by region set",
+			req:  &pb.FederationFetchRequest{},
+			want: []*pb.ContactTracingResponse{
+				{
+					RegionIdentifiers: []string{"CA", "US"},
+					ContactTracingInfo: []*pb.ContactTracingInfo{
+						{TransmissionRisk: 1, ExposureKeys: []*pb.ExposureKey{aaa}},
+					},
+				},
+				{
+					RegionIdentifiers: []string{"US"},
+					ContactTracingInfo: []*pb.ContactTracingInfo{
+						{TransmissionRisk: 1, ExposureKeys: []*pb.ExposureKey{bbb}},
+					},
+				},
+				{
+					RegionIdentifiers: []string{"CA", "MX"},
+					ContactTracingInfo: []*pb.ContactTracingInfo{
+						{TransmissionRisk: 2, ExposureKeys: []*pb.ExposureKey{ccc}},
+					},
+				},
+			},
+		},
+		{
+			name: "exploded",
+			req:  &pb.FederationFetchRequest{ExplodeRegions: true},
+			want: []*pb.ContactTracingResponse{
+				{
+					RegionIdentifiers: []string{"CA"},
+					ContactTracingInfo: []*pb.ContactTracingInfo{
+						{TransmissionRisk: 1, ExposureKeys: []*pb.ExposureKey{aaa}},
+						{TransmissionRisk: 2, ExposureKeys: []*pb.ExposureKey{ccc}},
+					},
+				},
+				{
+					RegionIdentifiers: []string{"US"},
+					ContactTracingInfo: []*pb.ContactTracingInfo{
+						{TransmissionRisk: 1, ExposureKeys: []*pb.ExposureKey{aaa, bbb}},
+					},
+				},
+				{
+					RegionIdentifiers: []string{"MX"},
+					ContactTracingInfo: []*pb.ContactTracingInfo{
+						{TransmissionRisk: 2, ExposureKeys: []*pb.ExposureKey{ccc}},
+					},
+				},
+			},
+		},
+		{
+			name: "exploded to requested regions",
+			req:  &pb.FederationFetchRequest{RegionIdentifiers: []string{"US", "MX"}, ExplodeRegions: true},
+			want: []*pb.ContactTracingResponse{
+				{
+					RegionIdentifiers: []string{"US"},
+					ContactTracingInfo: []*pb.ContactTracingInfo{
+						{TransmissionRisk: 1, ExposureKeys: []*pb.ExposureKey{aaa, bbb}},
+					},
+				},
+				{
+					RegionIdentifiers: []string{"MX"},
+					ContactTracingInfo: []*pb.ContactTracingInfo{
+						{TransmissionRisk: 2, ExposureKeys: []*pb.ExposureKey{ccc}},
+					},
+				},
+			},
+		},
+		{
+			name: "exploded without excluded regions",
+			req:  &pb.FederationFetchRequest{ExcludeRegionIdentifiers: []string{"CA"}, ExplodeRegions: true},
+			want: []*pb.ContactTracingResponse{
+				{
+					RegionIdentifiers: []string{"US"},
+					ContactTracingInfo: []*pb.ContactTracingInfo{
+						{TransmissionRisk: 1, ExposureKeys: []*pb.ExposureKey{aaa, bbb}},
+					},
+				},
+				{
+					RegionIdentifiers: []string{"MX"},
+					ContactTracingInfo: []*pb.ContactTracingInfo{
+						{TransmissionRisk: 2, ExposureKeys: []*pb.ExposureKey{ccc}},
+					},
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			env := serverenv.New(ctx)
+			server := Server{env: env, config: &Config{}}
+			got, err := server.fetch(ctx, tc.req, testDeps(exposures), time.Now())
+			if err != nil {
+				t.Fatalf("fetch() returned err=%v, want err=nil", err)
+			}
+			want := &pb.FederationFetchResponse{Response: tc.want, FetchResponseKeyTimestamp: 300}
+			if diff := cmp.Diff(want, got, listsAsSets...); diff != "" {
+				t.Errorf("fetch() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestFetchSummary tests that the summary counts match the keys in the response, counting keys served
+// under several region groups once per group, rather than the exposures scanned.
+func TestFetchSummary(t *testing.T) {
+	exposures := []interface{}{
+		makeExposure(aaa, 1, "US", "CA"),
+		makeExposure(bbb, 1, "US"),
+		makeExposure(ccc, 2, "CA", "MX"),
+		makeExposure(ddd, 2, "FR"),
+	}
+
+	testCases := []struct {
+		name            string
+		req             *pb.FederationFetchRequest
+		wantKeyCount    int64
+		wantRegionCount int32
+	}{
+		{
+			name:            "grouped by region set",
+			req:             &pb.FederationFetchRequest{},
+			wantKeyCount:    4,
+			wantRegionCount: 4,
+		},
+		{
+			name:            "exploded",
+			req:             &pb.FederationFetchRequest{ExplodeRegions: true},
+			wantKeyCount:    6,
+			wantRegionCount: 4,
+		},
+		{
+			name:            "excluded keys not counted",
+			req:             &pb.FederationFetchRequest{ExcludeRegionIdentifiers: []string{"FR", "MX"}, ExplodeRegions: true},
+			wantKeyCount:    4,
+			wantRegionCount: 2,
+		},
+		{
+			name:         "nothing served",
+			req:          &pb.FederationFetchRequest{RegionIdentifiers: []string{"DE"}},
+			wantKeyCount: 0,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			server := Server{env: serverenv.New(ctx), config: &Config{}}
+			got, err := server.fetch(ctx, tc.req, testDeps(exposures), time.Now())
+			if err != nil {
+				t.Fatalf("fetch() returned err=%v, want err=nil", err)
+			}
+			if got.KeyCount != tc.wantKeyCount || got.RegionCount != tc.wantRegionCount {
+				t.Errorf("got keyCount=%d regionCount=%d, want %d and %d", got.KeyCount, got.RegionCount, tc.wantKeyCount, tc.wantRegionCount)
+			}
+
+			emitted := 0
+			for _, ctr := range got.Response {
+				for _, cti := range ctr.ContactTracingInfo {
+					emitted += len(cti.ExposureKeys)
+				}
+			}
+			if got.KeyCount != int64(emitted) {
+				t.Errorf("keyCount=%d, but %d keys were emitted", got.KeyCount, emitted)
+			}
+		})
+	}
+}
+
+// TestFetchBlocklist tests that blocklisted keys are not served.
+func TestFetchBlocklist(t *testing.T) {
+	ctx := context.Background()
+	exposures := []interface{}{
+		makeExposure(aaa, 1, "US"),
+		makeExposure(bbb, 1, "US"),
+		makeExposure(ccc, 2, "CA"),
+	}
+	server := Server{env: serverenv.New(ctx), config: &Config{}, keyBlocklist: blocklist.New(bbb.ExposureKey, []byte("zzz"))}
+	got, err := server.fetch(ctx, &pb.FederationFetchRequest{}, testDeps(exposures), time.Now())
+	if err != nil {
+		t.Fatalf("fetch() returned err=%v, want err=nil", err)
+	}
+
+	want := &pb.FederationFetchResponse{
+		Response: []*pb.ContactTracingResponse{
+			{
+				RegionIdentifiers: []string{"US"},
+				ContactTracingInfo: []*pb.ContactTracingInfo{
+					{TransmissionRisk: 1, ExposureKeys: []*pb.ExposureKey{aaa}},
+				},
+			},
+			{
+				RegionIdentifiers: []string{"CA"},
+				ContactTracingInfo: []*pb.ContactTracingInfo{
+					{TransmissionRisk: 2, ExposureKeys: []*pb.ExposureKey{ccc}},
+				},
+			},
+		},
+		FetchResponseKeyTimestamp: 300,
+	}
+	if diff := cmp.Diff(want, got, listsAsSets...); diff != "" {
+		t.Errorf("fetch() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// TestFetchCancelWideRows tests that cancellation is noticed while filtering the regions of a key with
+// very many of them, rather than only between keys, and that a partial response is returned.
+// TestFetchRegionGuards checks that a canceled fetch, or one with too many regions, is rejected before
+// any database work.
+func TestFetchRegionGuards(t *testing.T) {
+	many := make([]string, 11)
+	for i := range many {
+		many[i] = fmt.Sprintf("R%02d", i)
+	}
+
+	testCases := []struct {
+		name     string
+		canceled bool
+		req      *pb.FederationFetchRequest
+		want     codes.Code
+	}{
+		{
+			name:     "canceled",
+			canceled: true,
+			req:      &pb.FederationFetchRequest{RegionIdentifiers: []string{"US"}},
+			want:     codes.Canceled,
+		},
+		{
+			name: "too many included regions",
+			req:  &pb.FederationFetchRequest{RegionIdentifiers: many},
+			want: codes.InvalidArgument,
+		},
+		{
+			name: "too many excluded regions",
+			req:  &pb.FederationFetchRequest{RegionIdentifiers: []string{"US"}, ExcludeRegionIdentifiers: many},
+			want: codes.InvalidArgument,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tc.canceled {
+				cancel()
+			}
+
+			deps := testDeps(nil)
+			deps.latestCreatedAt = func(context.Context, []string) (time.Time, error) {
+				t.Errorf("region watermarks were read")
+				return time.Time{}, nil
+			}
+			deps.iterateExposures = func(context.Context, database.IterateExposuresCriteria, func(*model.Exposure) error) (string, error) {
+				t.Errorf("exposures were queried")
+				return "", nil
+			}
+
+			server := Server{env: serverenv.New(ctx), config: &Config{MaxRegions: 10}}
+			_, err := server.fetch(ctx, tc.req, deps, time.Now())
+			if got := status.Code(err); got != tc.want {
+				t.Errorf("fetch() returned err=%v with code %v, want %v", err, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestFetchCancelWideRows(t *testing.T) {
+	wide := make([]string, 20*regionCheckInterval)
+	for i := range wide {
+		wide[i] = fmt.Sprintf("R%05d", i)
+	}
+
+	testCases := []struct {
+		name string
+		req  *pb.FederationFetchRequest
+	}{
+		{
+			name: "excluded regions",
+			req:  &pb.FederationFetchRequest{ExcludeRegionIdentifiers: []string{"R*"}},
+		},
+		{
+			name: "exploded regions",
+			req:  &pb.FederationFetchRequest{ExplodeRegions: true},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			deps := testDeps(nil)
+			deps.iterateExposures = func(ctx context.Context, _ database.IterateExposuresCriteria, f func(*model.Exposure) error) (string, error) {
+				if err := f(makeExposure(aaa, 1, "US")); err != nil {
+					return "", err
+				}
+				cancel()
+				if err := f(makeExposure(bbb, 1, append([]string(nil), wide...)...)); err != nil {
+					return "cursor", err
+				}
+				t.Errorf("wide key was processed after cancellation")
+				return "", nil
+			}
+
+			server := Server{env: serverenv.New(ctx), config: &Config{}}
+			got, err := server.fetch(ctx, tc.req, deps, time.Now())
+			if err != nil {
+				t.Fatalf("fetch() returned err=%v, want err=nil", err)
+			}
+			want := &pb.FederationFetchResponse{
+				Response: []*pb.ContactTracingResponse{
+					{
+						RegionIdentifiers: []string{"US"},
+						ContactTracingInfo: []*pb.ContactTracingInfo{
+							{TransmissionRisk: 1, ExposureKeys: []*pb.ExposureKey{aaa}},
+						},
+					},
+				},
+				PartialResponse:           true,
+				NextFetchToken:            "cursor",
+				FetchResponseKeyTimestamp: 100,
+			}
+			if diff := cmp.Diff(want, got, listsAsSets...); diff != "" {
+				t.Errorf("fetch() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestFetchNamespace tests that fetch() is scoped to the namespace of the authorized client.
+func TestFetchNamespace(t *testing.T) {
+	inNamespace := func(diagKey *pb.ExposureKey, namespace string) *model.Exposure {
+		e := makeExposure(diagKey, 1, "US")
+		e.Namespace = namespace
+		return e
+	}
+	iterations := []interface{}{
+		inNamespace(aaa, ""),
+		inNamespace(bbb, "tenant-a"),
+		inNamespace(ccc, "tenant-b"),
+		inNamespace(ddd, "tenant-a"),
+	}
+
+	testCases := []struct {
+		name     string
+		auth     *fedmodel.FederationOutAuthorization
+		wantKeys []*pb.ExposureKey
+		wantTime int64
+	}{
+		{
+			name:     "no authorization",
+			wantKeys: []*pb.ExposureKey{aaa},
+			wantTime: 100,
+		},
+		{
+			name:     "default namespace",
+			auth:     &fedmodel.FederationOutAuthorization{IncludeRegions: []string{"US"}},
+			wantKeys: []*pb.ExposureKey{aaa},
+			wantTime: 100,
+		},
+		{
+			name:     "tenant-a",
+			auth:     &fedmodel.FederationOutAuthorization{Namespace: "tenant-a"},
+			wantKeys: []*pb.ExposureKey{bbb, ddd},
+			wantTime: 400,
+		},
+		{
+			name:     "tenant-b",
+			auth:     &fedmodel.FederationOutAuthorization{Namespace: "tenant-b"},
+			wantKeys: []*pb.ExposureKey{ccc},
+			wantTime: 300,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.auth != nil {
+				ctx = context.WithValue(ctx, authKey{}, tc.auth)
+			}
+			env := serverenv.New(ctx)
+			server := Server{env: env, config: &Config{}}
+
+			var gotNamespace string
+			deps := testDeps(iterations)
+			iterate := deps.iterateExposures
+			deps.iterateExposures = func(ctx context.Context, criteria database.IterateExposuresCriteria, f func(*model.Exposure) error) (string, error) {
+				gotNamespace = criteria.Namespace
+				return iterate(ctx, criteria, f)
+			}
+
+			got, err := server.fetch(ctx, &pb.FederationFetchRequest{}, deps, time.Now())
+			if err != nil {
+				t.Fatalf("fetch() returned err=%v, want err=nil", err)
+			}
+			wantNamespace := ""
+			if tc.auth != nil {
+				wantNamespace = tc.auth.Namespace
+			}
+			if gotNamespace != wantNamespace {
+				t.Errorf("criteria.Namespace=%q, want=%q", gotNamespace, wantNamespace)
+			}
+			want := &pb.FederationFetchResponse{
+				Response: []*pb.ContactTracingResponse{
+					{
+						RegionIdentifiers: []string{"US"},
+						ContactTracingInfo: []*pb.ContactTracingInfo{
+							{TransmissionRisk: 1, ExposureKeys: tc.wantKeys},
+						},
+					},
+				},
+				FetchResponseKeyTimestamp: tc.wantTime,
+			}
+			if diff := cmp.Diff(want, got, listsAsSets...); diff != "" {
+				t.Errorf("fetch() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestFetchTombstones tests that purged keys are returned as revocations when requested.
+func TestFetchTombstones(t *testing.T) {
+	tombstones := []*model.ExposureTombstone{
+		{ExposureKey: ddd.ExposureKey, IntervalNumber: ddd.IntervalNumber, Regions: []string{"US"}, DeletedAt: time.Unix(500, 0)},
+	}
+	iterations := []interface{}{
+		makeExposure(aaa, 1, "US"),
+	}
+	testCases := []struct {
+		name              string
+		includeTombstones bool
+		latest            time.Time
+		iterations        []interface{}
+		tombstoneErr      error
+		wantRevoked       []*pb.ExposureKey
+		wantPartial       bool
+		wantErr           error
+	}{
+		{
+			name:       "tombstones not requested",
+			latest:     time.Unix(100, 0),
+			iterations: iterations,
+		},
+		{
+			name:              "tombstones requested",
+			includeTombstones: true,
+			latest:            time.Unix(100, 0),
+			iterations:        iterations,
+			wantRevoked:       []*pb.ExposureKey{ddd},
+		},
+		{
+			name:              "unchanged",
+			includeTombstones: true,
+			latest:            time.Unix(0, 0),
+			wantRevoked:       []*pb.ExposureKey{ddd},
+		},
+		{
+			name:              "partial response",
+			includeTombstones: true,
+			latest:            time.Unix(100, 0),
+			iterations:        append(iterations, timeout{}),
+			wantPartial:       true,
+		},
+		{
+			name:              "tombstone error",
+			includeTombstones: true,
+			latest:            time.Unix(100, 0),
+			iterations:        iterations,
+			tombstoneErr:      errors.New("boom"),
+			wantErr:           ErrQuery,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			env := serverenv.New(ctx)
+			server := Server{env: env, config: &Config{}}
+			deps := testDeps(tc.iterations)
+			deps.latestCreatedAt = latestFunc(tc.latest)
+			deps.iterateTombstones = tombstoneFunc(tombstones)
+			if tc.tombstoneErr != nil {
+				deps.iterateTombstones = func(context.Context, database.IterateTombstonesCriteria, func(*model.ExposureTombstone) error) error {
+					return tc.tombstoneErr
+				}
+			}
+
+			req := &pb.FederationFetchRequest{IncludeTombstones: tc.includeTombstones}
+			got, err := server.fetch(ctx, req, deps, time.Now())
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("fetch() returned err=%v, want err=%v", err, tc.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.PartialResponse != tc.wantPartial {
+				t.Errorf("PartialResponse=%t, want=%t", got.PartialResponse, tc.wantPartial)
+			}
+			if diff := cmp.Diff(tc.wantRevoked, got.RevokedKeys, protocmp.Transform()); diff != "" {
+				t.Errorf("RevokedKeys mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestFetchTombstoneRegions tests that revocations are filtered by region like the keys they revoke.
+func TestFetchTombstoneRegions(t *testing.T) {
+	tombstone := func(diagKey *pb.ExposureKey, regions ...string) *model.ExposureTombstone {
+		return &model.ExposureTombstone{ExposureKey: diagKey.ExposureKey, IntervalNumber: diagKey.IntervalNumber, Regions: regions, DeletedAt: time.Unix(500, 0)}
+	}
+	tombstones := []*model.ExposureTombstone{
+		tombstone(aaa, "US"),
+		tombstone(bbb, "CA"),
+		tombstone(ccc, "US", "CA"),
+		tombstone(ddd),
+	}
+
+	testCases := []struct {
+		name        string
+		auth        *fedmodel.FederationOutAuthorization
+		req         *pb.FederationFetchRequest
+		wantRevoked []*pb.ExposureKey
+	}{
+		{
+			name:        "all regions",
+			req:         &pb.FederationFetchRequest{},
+			wantRevoked: []*pb.ExposureKey{aaa, bbb, ccc},
+		},
+		{
+			name:        "requested regions",
+			req:         &pb.FederationFetchRequest{RegionIdentifiers: []string{"CA"}},
+			wantRevoked: []*pb.ExposureKey{bbb, ccc},
+		},
+		{
+			name:        "excluded regions",
+			req:         &pb.FederationFetchRequest{ExcludeRegionIdentifiers: []string{"CA"}},
+			wantRevoked: []*pb.ExposureKey{aaa, ccc},
+		},
+		{
+			name:        "authorized regions",
+			auth:        &fedmodel.FederationOutAuthorization{IncludeRegions: []string{"US"}},
+			req:         &pb.FederationFetchRequest{RegionIdentifiers: []string{"US", "CA"}},
+			wantRevoked: []*pb.ExposureKey{aaa, ccc},
+		},
+		{
+			name:        "authorization excludes",
+			auth:        &fedmodel.FederationOutAuthorization{IncludeRegions: []string{"US", "CA"}, ExcludeRegions: []string{"US"}},
+			req:         &pb.FederationFetchRequest{RegionIdentifiers: []string{"US", "CA"}},
+			wantRevoked: []*pb.ExposureKey{bbb, ccc},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.auth != nil {
+				ctx = context.WithValue(ctx, authKey{}, tc.auth)
+			}
+			server := Server{env: serverenv.New(ctx), config: &Config{}}
+			deps := testDeps(nil)
+			deps.latestCreatedAt = latestFunc(time.Unix(0, 0))
+			deps.iterateTombstones = tombstoneFunc(tombstones)
+
+			tc.req.IncludeTombstones = true
+			got, err := server.fetch(ctx, tc.req, deps, time.Unix(1000, 0))
+			if err != nil {
+				t.Fatalf("fetch() returned err=%v, want err=nil", err)
+			}
+			if diff := cmp.Diff(tc.wantRevoked, got.RevokedKeys, protocmp.Transform()); diff != "" {
+				t.Errorf("RevokedKeys mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestFetchRevocationGrace tests that revocations are only served within the grace period.
+func TestFetchRevocationGrace(t *testing.T) {
+	fetchUntil := time.Unix(100000, 0)
+	grace := time.Hour
+	tombstone := func(diagKey *pb.ExposureKey, deletedAt time.Time) *model.ExposureTombstone {
+		return &model.ExposureTombstone{ExposureKey: diagKey.ExposureKey, IntervalNumber: diagKey.IntervalNumber, Regions: []string{"US"}, DeletedAt: deletedAt}
+	}
+	tombstones := []*model.ExposureTombstone{
+		tombstone(aaa, fetchUntil.Add(-grace-time.Second)),
+		tombstone(bbb, fetchUntil.Add(-grace)),
+		tombstone(ccc, fetchUntil.Add(-time.Second)),
+	}
+
+	testCases := []struct {
+		name        string
+		grace       time.Duration
+		wantRevoked []*pb.ExposureKey
+	}{
+		{
+			name:        "no grace period",
+			wantRevoked: []*pb.ExposureKey{aaa, bbb, ccc},
+		},
+		{
+			name:        "grace period",
+			grace:       grace,
+			wantRevoked: []*pb.ExposureKey{bbb, ccc},
+		},
+		{
+			name:  "all expired",
+			grace: time.Second / 2,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			server := Server{env: serverenv.New(ctx), config: &Config{RevocationGracePeriod: tc.grace}}
+			deps := testDeps(nil)
+			deps.latestCreatedAt = latestFunc(time.Unix(0, 0))
+			deps.iterateTombstones = tombstoneFunc(tombstones)
+
+			req := &pb.FederationFetchRequest{IncludeTombstones: true}
+			got, err := server.fetch(ctx, req, deps, fetchUntil)
+			if err != nil {
+				t.Fatalf("fetch() returned err=%v, want err=nil", err)
+			}
+			if diff := cmp.Diff(tc.wantRevoked, got.RevokedKeys, protocmp.Transform()); diff != "" {
+				t.Errorf("RevokedKeys mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestFetchStrictIntervalCount tests that keys with an IntervalCount inconsistent with their age are skipped if configured.
+func TestFetchStrictIntervalCount(t *testing.T) {
+	createdAt := time.Date(2020, 5, 2, 10, 0, 0, 0, time.UTC)
+	today := model.IntervalNumber(createdAt.Truncate(24 * time.Hour))
+	withInterval := func(diagKey *pb.ExposureKey, intervalNumber, intervalCount int32) *model.Exposure {
+		e := makeExposure(diagKey, 1, "US")
+		e.IntervalNumber = intervalNumber
+		e.IntervalCount = intervalCount
+		e.CreatedAt = createdAt
+		return e
+	}
+	full := &pb.ExposureKey{ExposureKey: aaa.ExposureKey, IntervalNumber: today - 144, IntervalCount: 144}
+	partial := &pb.ExposureKey{ExposureKey: bbb.ExposureKey, IntervalNumber: today, IntervalCount: 60}
+	mismatched := &pb.ExposureKey{ExposureKey: ccc.ExposureKey, IntervalNumber: today - 144, IntervalCount: 60}
+	iterations := []interface{}{
+		withInterval(full, full.IntervalNumber, full.IntervalCount),
+		withInterval(partial, partial.IntervalNumber, partial.IntervalCount),
+		withInterval(mismatched, mismatched.IntervalNumber, mismatched.IntervalCount),
+	}
+
+	testCases := []struct {
+		name     string
+		strict   bool
+		wantKeys []*pb.ExposureKey
+	}{
+		{
+			name:     "lenient",
+			wantKeys: []*pb.ExposureKey{full, partial, mismatched},
+		},
+		{
+			name:     "strict",
+			strict:   true,
+			wantKeys: []*pb.ExposureKey{full, partial},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			env := serverenv.New(ctx)
+			server := Server{env: env, config: &Config{StrictIntervalCount: tc.strict}}
+			got, err := server.fetch(ctx, &pb.FederationFetchRequest{}, testDeps(iterations), time.Now())
+			if err != nil {
+				t.Fatalf("fetch() returned err=%v, want err=nil", err)
+			}
+			want := &pb.FederationFetchResponse{
+				Response: []*pb.ContactTracingResponse{
+					{
+						RegionIdentifiers: []string{"US"},
+						ContactTracingInfo: []*pb.ContactTracingInfo{
+							{TransmissionRisk: 1, ExposureKeys: tc.wantKeys},
+						},
+					},
+				},
+				FetchResponseKeyTimestamp: createdAt.Unix(),
+			}
+			if diff := cmp.Diff(want, got, listsAsSets...); diff != "" {
+				t.Errorf("fetch() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestFetchStrictKeyBytes tests that keys which are not 16 raw bytes are skipped if configured.
+func TestFetchStrictKeyBytes(t *testing.T) {
+	raw := []byte("0123456789abcdef")
+	valid := &pb.ExposureKey{ExposureKey: raw, IntervalNumber: 1}
+	// The same key, stored as base64 text rather than raw bytes.
+	encoded := &pb.ExposureKey{ExposureKey: []byte(base64.StdEncoding.EncodeToString(raw)), IntervalNumber: 1}
+	if len(encoded.ExposureKey) != 24 {
+		t.Fatalf("base64 encoded key has %d bytes, want 24", len(encoded.ExposureKey))
+	}
+	iterations := []interface{}{
+		makeExposure(valid, 1, "US"),
+		makeExposure(encoded, 1, "US"),
+	}
+
+	testCases := []struct {
+		name     string
+		strict   bool
+		wantKeys []*pb.ExposureKey
+	}{
+		{
+			name:     "lenient",
+			wantKeys: []*pb.ExposureKey{valid, encoded},
+		},
+		{
+			name:     "strict",
+			strict:   true,
+			wantKeys: []*pb.ExposureKey{valid},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			env := serverenv.New(ctx)
+			server := Server{env: env, config: &Config{StrictKeyBytes: tc.strict}}
+			got, err := server.fetch(ctx, &pb.FederationFetchRequest{}, testDeps(iterations), time.Now())
+			if err != nil {
+				t.Fatalf("fetch() returned err=%v, want err=nil", err)
+			}
+			want := &pb.FederationFetchResponse{
+				Response: []*pb.ContactTracingResponse{
+					{
+						RegionIdentifiers: []string{"US"},
+						ContactTracingInfo: []*pb.ContactTracingInfo{
+							{TransmissionRisk: 1, ExposureKeys: tc.wantKeys},
+						},
+					},
+				},
+				FetchResponseKeyTimestamp: 100,
+			}
+			if diff := cmp.Diff(want, got, listsAsSets...); diff != "" {
+				t.Errorf("fetch() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestFetchGroupLimit tests that fetch() returns a partial response once MaxResponseGroups is reached.
+func TestFetchGroupLimit(t *testing.T) {
+	ctx := context.Background()
+	env := serverenv.New(ctx)
+	server := Server{env: env, config: &Config{MaxResponseGroups: 2}}
+	deps := testDeps([]interface{}{
+		makeExposure(aaa, 1, "US"),
+		makeExposure(bbb, 1, "US", "CA"),
+		makeExposure(ccc, 1, "US"), // Existing group, still included.
+		makeExposure(ddd, 1, "US", "GB"),
+	})
+	got, err := server.fetch(ctx, &pb.FederationFetchRequest{}, deps, time.Now())
+	if err != nil {
+		t.Fatalf("fetch() returned err=%v, want err=nil", err)
+	}
+	want := &pb.FederationFetchResponse{
+		Response: []*pb.ContactTracingResponse{
+			{
+				RegionIdentifiers: []string{"US"},
+				ContactTracingInfo: []*pb.ContactTracingInfo{
+					{TransmissionRisk: 1, ExposureKeys: []*pb.ExposureKey{aaa, ccc}},
+				},
+			},
+			{
+				RegionIdentifiers: []string{"CA", "US"},
+				ContactTracingInfo: []*pb.ContactTracingInfo{
+					{TransmissionRisk: 1, ExposureKeys: []*pb.ExposureKey{bbb}},
+				},
+			},
+		},
+		PartialResponse:           true,
+		FetchResponseKeyTimestamp: 300,
+		NextFetchToken:            "ddd_cursor",
+	}
+	if diff := cmp.Diff(want, got, listsAsSets...); diff != "" {
+		t.Errorf("fetch() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// TestFetchSizeLimit tests that a client's own response size limit overrides the server's.
+func TestFetchSizeLimit(t *testing.T) {
+	var iterations []interface{}
+	for i := 0; i < 200; i++ {
+		key := &pb.ExposureKey{ExposureKey: []byte(fmt.Sprintf("%016d", i)), IntervalNumber: int32(i + 1), IntervalCount: 144}
+		regions := []string{"US"}
+		if i%3 == 0 {
+			regions = []string{"CA", "US"}
+		}
+		iterations = append(iterations, makeExposure(key, i%4, regions...))
+	}
+
+	testCases := []struct {
+		name        string
+		global      int
+		partner     int
+		wantPartial bool
+	}{
+		{name: "no limit"},
+		{name: "global limit", global: 1024, wantPartial: true},
+		{name: "partner limit", global: 1 << 20, partner: 1024, wantPartial: true},
+		{name: "partner limit above global", global: 1024, partner: 1 << 20},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			auth := &fedmodel.FederationOutAuthorization{MaxResponseBytes: tc.partner}
+			ctx := context.WithValue(context.Background(), authKey{}, auth)
+			server := Server{env: serverenv.New(ctx), config: &Config{MaxResponseBytes: tc.global}}
+
+			got, err := server.fetch(ctx, &pb.FederationFetchRequest{}, testDeps(iterations), time.Now())
+			if err != nil {
+				t.Fatalf("fetch() returned err=%v, want err=nil", err)
+			}
+			if got.PartialResponse != tc.wantPartial {
+				t.Fatalf("PartialResponse=%t, want=%t", got.PartialResponse, tc.wantPartial)
+			}
+			if !tc.wantPartial {
+				if got.KeyCount != 200 {
+					t.Errorf("served %d keys, want 200", got.KeyCount)
+				}
+				return
+			}
+			if got.KeyCount == 0 || got.NextFetchToken == "" {
+				t.Errorf("served %d keys with nextFetchToken %q, want some keys and a token", got.KeyCount, got.NextFetchToken)
+			}
+			if size := proto.Size(got); size > 1024 {
+				t.Errorf("response is %d bytes, want at most 1024", size)
+			}
+		})
+	}
+}
+
+// countingExporter is a metrics.Exporter which sums the values written to each metric.
+type countingExporter struct {
+	counts map[string]int64
+}
+
+func (e *countingExporter) WriteBool(string, bool) {}
+func (e *countingExporter) WriteInt(name string, _ bool, value int) {
+	e.counts[name] += int64(value)
+}
+func (e *countingExporter) WriteInt64(name string, _ bool, value int64) {
+	e.counts[name] += value
+}
+func (e *countingExporter) WriteIntDistribution(string, bool, []int)         {}
+func (e *countingExporter) WriteFloat64(string, bool, float64)               {}
+func (e *countingExporter) WriteFloat64Distribution(string, bool, []float64) {}
+
+// TestFetchPagingMetrics tests the counters of how clients page through and refresh keys.
+func TestFetchPagingMetrics(t *testing.T) {
+	auth := &fedmodel.FederationOutAuthorization{Subject: "partner-a"}
+	ctx := context.WithValue(context.Background(), authKey{}, auth)
+	exporter := &countingExporter{counts: map[string]int64{}}
+	env := serverenv.New(ctx, serverenv.WithMetricsExporter(func(context.Context) metrics.Exporter { return exporter }))
+	server := Server{env: env, config: &Config{MaxResponseGroups: 1, PartnerMetrics: true}}
+	deps := testDeps([]interface{}{
+		makeExposure(aaa, 1, "US"),
+		makeExposure(bbb, 1, "CA"),
+	})
+
+	requests := []*pb.FederationFetchRequest{
+		{},                                   // Full refresh, cut short by the group limit.
+		{NextFetchToken: "aaa_cursor"},       // Resumes it.
+		{LastFetchResponseKeyTimestamp: 200}, // Incremental.
+		{RelativeSinceSeconds: 3600},         // Relative, not a full refresh.
+	}
+	for i, req := range requests {
+		if _, err := server.fetch(ctx, req, deps, time.Now()); err != nil {
+			t.Fatalf("fetch() of request %d returned err=%v, want err=nil", i, err)
+		}
+	}
+
+	want := map[string]int64{
+		"federation-fetch-with-cursor":              1,
+		"federation-fetch-without-cursor":           3,
+		"federation-fetch-full-refresh":             1,
+		"federation-fetch-resumed":                  1,
+		"federation-fetch-partial":                  2,
+		"federation-fetch-with-cursor/partner-a":    1,
+		"federation-fetch-without-cursor/partner-a": 3,
+		"federation-fetch-full-refresh/partner-a":   1,
+		"federation-fetch-resumed/partner-a":        1,
+		"federation-fetch-partial/partner-a":        2,
+	}
+	for name, count := range want {
+		if got := exporter.counts[name]; got != count {
+			t.Errorf("%s=%d, want %d", name, got, count)
+		}
+	}
+}
+
+// TestFetchIteratorStalled tests that the watchdog cancels a fetch whose iterator stops making progress.
+func TestFetchIteratorStalled(t *testing.T) {
+	testCases := []struct {
+		name    string
+		delays  []time.Duration
+		hang    bool
+		wantErr error
+	}{
+		{
+			name:   "slow but progressing",
+			delays: []time.Duration{20 * time.Millisecond, 20 * time.Millisecond, 20 * time.Millisecond},
+		},
+		{
+			name:    "hangs before first exposure",
+			hang:    true,
+			wantErr: errIteratorStalled,
+		},
+		{
+			name:    "hangs after an exposure",
+			delays:  []time.Duration{0},
+			hang:    true,
+			wantErr: errIteratorStalled,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			env := serverenv.New(ctx)
+			server := Server{env: env, config: &Config{IteratorTimeout: 200 * time.Millisecond}}
+
+			// release unblocks a hung iterator once the test is complete. The iterator deliberately
+			// ignores context cancellation.
+			release := make(chan struct{})
+			defer close(release)
+
+			// The hung iterator outlives fetch(), so it must not reference tc.
+			delays, hang := tc.delays, tc.hang
+			deps := testDeps(nil)
+			deps.iterateExposures = func(_ context.Context, _ database.IterateExposuresCriteria, f func(*model.Exposure) error) (string, error) {
+				for i, delay := range delays {
+					time.Sleep(delay)
+					key := &pb.ExposureKey{ExposureKey: []byte(fmt.Sprintf("key%d", i)), IntervalNumber: int32(i)}
+					if err := f(makeExposure(key, 1, "US")); err != nil {
+						return "", err
+					}
+				}
+				if hang {
+					<-release
+				}
+				return "", nil
+			}
+
+			_, err := server.fetch(ctx, &pb.FederationFetchRequest{}, deps, time.Now())
+			if tc.wantErr == nil {
+				if err != nil {
+					t.Fatalf("fetch() returned err=%v, want err=nil", err)
+				}
+				return
+			}
+			if !errors.Is(err, tc.wantErr) || !errors.Is(err, ErrIterate) {
+				t.Errorf("fetch() returned err=%v, want %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+// TestFetchErrors tests that each class of fetch() failure maps to the expected status code.
+func TestFetchErrors(t *testing.T) {
+	testCases := []struct {
+		name       string
+		latestErr  error
+		iterations []interface{}
+		wantErr    error
+		wantCode   codes.Code
+	}{
+		{
+			name:      "watermark query fails",
+			latestErr: errors.New("connection refused"),
+			wantErr:   ErrQuery,
+			wantCode:  codes.Unavailable,
+		},
+		{
+			name:       "exposure query fails",
+			iterations: []interface{}{errors.New("connection refused")},
+			wantErr:    ErrQuery,
+			wantCode:   codes.Unavailable,
+		},
+		{
+			name:       "iteration fails",
+			iterations: []interface{}{makeExposure(aaa, 1, "US"), errors.New("scanning row")},
+			wantErr:    ErrIterate,
+			wantCode:   codes.Internal,
+		},
+		{
+			name:       "invalid cursor",
+			iterations: []interface{}{fmt.Errorf("decoding: %w", database.ErrInvalidCursor)},
+			wantErr:    ErrCursor,
+			wantCode:   codes.InvalidArgument,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			env := serverenv.New(ctx)
+			server := Server{env: env, config: &Config{}}
+			deps := testDeps(tc.iterations)
+			deps.latestCreatedAt = func(context.Context, []string) (time.Time, error) {
+				return time.Now(), tc.latestErr
+			}
+			_, err := server.fetch(ctx, &pb.FederationFetchRequest{}, deps, time.Now())
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("fetch() returned err=%v, want %v", err, tc.wantErr)
+			}
+			if got := status.Code(fetchStatus(err)); got != tc.wantCode {
+				t.Errorf("fetchStatus() code=%v, want=%v", got, tc.wantCode)
+			}
+		})
+	}
+}
+
+// TestFetchAudit tests that fetch() writes an audit record, and that failing to do so does not fail the fetch.
+func TestFetchAudit(t *testing.T) {
+	testCases := []struct {
+		name     string
+		auditErr error
+	}{
+		{
+			name: "audit written",
+		},
+		{
+			name:     "audit fails",
+			auditErr: errors.New("connection refused"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			auth := &fedmodel.FederationOutAuthorization{Issuer: "iss", Subject: "sub", IncludeRegions: []string{"US", "CA"}}
+			ctx := context.WithValue(context.Background(), authKey{}, auth)
+			env := serverenv.New(ctx)
+			server := Server{env: env, config: &Config{}}
+			var got *fedmodel.FederationOutAudit
+			deps := testDeps([]interface{}{
+				makeExposure(aaa, 1, "US"),
+				makeExposure(bbb, 1, "CA"),
+				makeExposure(ccc, 1, "US"),
+				timeout{},
+			})
+			deps.writeFetchAudit = func(_ context.Context, audit *fedmodel.FederationOutAudit) error {
+				got = audit
+				return tc.auditErr
+			}
+			until := time.Unix(1000, 0)
+			req := &pb.FederationFetchRequest{
+				RegionIdentifiers:             []string{"US", "CA"},
+				ExcludeRegionIdentifiers:      []string{"MX"},
+				LastFetchResponseKeyTimestamp: 50,
+			}
+			resp, err := server.fetch(ctx, req, deps, until)
+			if err != nil {
+				t.Fatalf("fetch() returned err=%v, want err=nil", err)
+			}
+			if !resp.PartialResponse {
+				t.Errorf("fetch() PartialResponse=false, want true")
+			}
+
+			want := &fedmodel.FederationOutAudit{
+				Issuer:          "iss",
+				Subject:         "sub",
+				IncludeRegions:  []string{"US", "CA"},
+				ExcludeRegions:  []string{"MX"},
+				SinceTimestamp:  time.Unix(50, 0),
+				UntilTimestamp:  until,
+				KeysServed:      3,
+				PartialResponse: true,
+			}
+			if diff := cmp.Diff(want, got, cmpopts.IgnoreFields(fedmodel.FederationOutAudit{}, "FetchedAt")); diff != "" {
+				t.Errorf("audit mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// generateExposures returns n deterministic exposures spread across a range of region sets and transmission risks.
+func generateExposures(n int) []interface{} {
+	regions := []string{"US", "CA", "MX", "GB", "FR", "DE"}
+	rng := rand.New(rand.NewSource(1))
+	exposures := make([]interface{}, 0, n)
+	for i := 0; i < n; i++ {
+		var rs []string
+		for _, r := range regions {
+			if rng.Intn(3) == 0 {
+				rs = append(rs, r)
+			}
+		}
+		if len(rs) == 0 {
+			rs = []string{regions[rng.Intn(len(regions))]}
+		}
+		key := &pb.ExposureKey{ExposureKey: []byte(fmt.Sprintf("key%08d", i)), IntervalNumber: int32(i)}
+		exposures = append(exposures, makeExposure(key, rng.Intn(8)+1, rs...))
+	}
+	return exposures
+}
+
+// legacyGroup is the original fmt.Sprintf keyed grouping from fetch(), retained as a reference implementation.
+func legacyGroup(exposures []interface{}) *pb.FederationFetchResponse {
+	ctrMap := map[string]*pb.ContactTracingResponse{}
+	ctiMap := map[string]*pb.ContactTracingInfo{}
+	response := &pb.FederationFetchResponse{}
+	for _, el := range exposures {
+		inf := el.(*model.Exposure)
+		regions := append([]string(nil), inf.Regions...)
+		sort.Strings(regions)
+		ctrKey := strings.Join(regions, "::")
+		ctr := ctrMap[ctrKey]
+		if ctr == nil {
+			ctr = &pb.ContactTracingResponse{RegionIdentifiers: regions}
+			ctrMap[ctrKey] = ctr
+			response.Response = append(response.Response, ctr)
+		}
+		ctiKey := fmt.Sprintf("%s::%d", ctrKey, inf.TransmissionRisk)
+		cti := ctiMap[ctiKey]
+		if cti == nil {
+			cti = &pb.ContactTracingInfo{TransmissionRisk: int32(inf.TransmissionRisk)}
+			ctiMap[ctiKey] = cti
+			ctr.ContactTracingInfo = append(ctr.ContactTracingInfo, cti)
+		}
+		cti.ExposureKeys = append(cti.ExposureKeys, &pb.ExposureKey{
+			ExposureKey:    inf.ExposureKey,
+			IntervalNumber: inf.IntervalNumber,
+			IntervalCount:  inf.IntervalCount,
+		})
+		if created := inf.CreatedAt.Unix(); created > response.FetchResponseKeyTimestamp {
+			response.FetchResponseKeyTimestamp = created
+		}
+	}
+	return response
+}
+
+// TestFetchGrouping tests that fetch() groups exposures exactly as the reference implementation does.
+func TestFetchGrouping(t *testing.T) {
+	testCases := []struct {
+		name   string
+		config *Config
+	}{
+		{name: "no group limit", config: &Config{}},
+		{name: "group limit not reached", config: &Config{MaxResponseGroups: 1000}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			env := serverenv.New(ctx)
+			server := Server{env: env, config: tc.config}
+
+			// Group a separate copy, since fetch() sorts regions in place.
+			want := legacyGroup(generateExposures(5000))
+			summarize(want)
+			got, err := server.fetch(ctx, &pb.FederationFetchRequest{}, testDeps(generateExposures(5000)), time.Now())
+			if err != nil {
+				t.Fatalf("fetch() returned err=%v, want err=nil", err)
+			}
+			// Ordering is deterministic for a given input, so compare without treating lists as sets.
+			if diff := cmp.Diff(want, got, protocmp.Transform()); diff != "" {
+				t.Errorf("fetch() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// BenchmarkGroupKeys measures the per-exposure cost of normalizing regions and looking up the
+// ContactTracingResponse for them, comparing against the sort.Strings and strings.Join approach.
+func BenchmarkGroupKeys(b *testing.B) {
+	exposures := generateExposures(10000)
+	regions := make([][]string, len(exposures))
+	for i, e := range exposures {
+		regions[i] = e.(*model.Exposure).Regions
+	}
+
+	b.Run("join", func(b *testing.B) {
+		ctrMap := map[string]*pb.ContactTracingResponse{}
+		scratch := make([]string, 0, 8)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			// Copy the regions each time, as they would be read from the database.
+			rs := append(scratch[:0], regions[i%len(regions)]...)
+			sort.Strings(rs)
+			rs = dedupSorted(rs)
+			key := strings.Join(rs, "::")
+			if _, ok := ctrMap[key]; !ok {
+				ctrMap[key] = &pb.ContactTracingResponse{}
+			}
+		}
+	})
+
+	b.Run("buffer", func(b *testing.B) {
+		ctrMap := map[string]*pb.ContactTracingResponse{}
+		scratch := make([]string, 0, 8)
+		var key []byte
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			rs := append(scratch[:0], regions[i%len(regions)]...)
+			rs = normalizeRegions(rs)
+			key = appendRegionsKey(key[:0], rs)
+			if _, ok := ctrMap[string(key)]; !ok {
+				ctrMap[string(key)] = &pb.ContactTracingResponse{}
+			}
+		}
+	})
+}
+
+// BenchmarkFetch measures grouping cost over a large number of exposures.
+func BenchmarkFetch(b *testing.B) {
+	ctx := context.Background()
+	env := serverenv.New(ctx)
+	exposures := generateExposures(1000000)
+	deps := testDeps(exposures)
+
+	for _, max := range []int{0, 1000} {
+		b.Run(fmt.Sprintf("MaxResponseGroups=%d", max), func(b *testing.B) {
+			server := Server{env: env, config: &Config{MaxResponseGroups: max}}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := server.fetch(ctx, &pb.FederationFetchRequest{}, deps, time.Now()); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// TestRawToken tests rawToken().
+// TestFetchTimeout tests that the client's timeout override is honored up to the configured maximum.
+func TestFetchTimeout(t *testing.T) {
+	testCases := []struct {
+		name       string
+		maxTimeout time.Duration
+		md         map[string][]string
+		want       time.Duration
+	}{
+		{
+			name:       "no override",
+			maxTimeout: time.Hour,
+			want:       5 * time.Minute,
+		},
+		{
+			name:       "override extends",
+			maxTimeout: time.Hour,
+			md:         map[string][]string{timeoutHeader: {"20m"}},
+			want:       20 * time.Minute,
+		},
+		{
+			name:       "override clamped",
+			maxTimeout: time.Hour,
+			md:         map[string][]string{timeoutHeader: {"3h"}},
+			want:       time.Hour,
+		},
+		{
+			name: "overrides disabled",
+			md:   map[string][]string{timeoutHeader: {"20m"}},
+			want: 5 * time.Minute,
+		},
+		{
+			name:       "invalid override",
+			maxTimeout: time.Hour,
+			md:         map[string][]string{timeoutHeader: {"soon"}},
+			want:       5 * time.Minute,
+		},
+		{
+			name:       "negative override",
+			maxTimeout: time.Hour,
+			md:         map[string][]string{timeoutHeader: {"-1m"}},
+			want:       5 * time.Minute,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.md != nil {
+				ctx = metadata.NewIncomingContext(ctx, metadata.MD(tc.md))
+			}
+			server := Server{config: &Config{Timeout: 5 * time.Minute, MaxTimeout: tc.maxTimeout}}
+			if got := server.fetchTimeout(ctx); got != tc.want {
+				t.Errorf("fetchTimeout()=%v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRawToken(t *testing.T) {
+	want := "Abc123"
+	md := metadata.New(map[string]string{"authorization": fmt.Sprintf("Bearer %s", want)})
+	ctx := metadata.NewIncomingContext(context.Background(), md)
+
+	got, err := rawToken(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got != want {
+		t.Errorf("rawToken()=%q, want=%q", got, want)
+	}
+}
+
+// TestRawTokenErrors tests error responses from rawToken().
+func TestRawTokenErrors(t *testing.T) {
+	testCases := []struct {
+		name  string
+		mdMap map[string][]string
+	}{
+		{
+			name:  "no metadata",
+			mdMap: map[string][]string{},
+		},
+		{
+			name:  "missing authorization header",
+			mdMap: map[string][]string{"not-auth": {"foo"}},
+		},
+		{
+			name:  "empty authorization header",
+			mdMap: map[string][]string{"authorization": {}},
+		},
+		{
+			name:  "too many authorization headers",
+			mdMap: map[string][]string{"authorization": {"Bearer foo", "Bearer bar"}},
+		},
+		{
+			name:  "incorrect authorization prefix",
+			mdMap: map[string][]string{"authorization": {"not-Bearer foo"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := metadata.NewIncomingContext(context.Background(), tc.mdMap)
+			_, gotErr := rawToken(ctx)
+			if gotErr == nil {
+				t.Errorf("missing error response")
+			}
+		})
+	}
+}
+
+// TestDedupSorted tests dedupSorted().
+func TestDedupSorted(t *testing.T) {
+	testCases := []struct {
+		name string
+		ss   []string
+		want []string
+	}{
+		{
+			name: "empty",
+			ss:   []string{},
+			want: []string{},
+		},
+		{
+			name: "single",
+			ss:   []string{"US"},
+			want: []string{"US"},
+		},
+		{
+			name: "no duplicates",
+			ss:   []string{"CA", "MX", "US"},
+			want: []string{"CA", "MX", "US"},
+		},
+		{
+			name: "duplicates",
+			ss:   []string{"CA", "CA", "MX", "US", "US", "US"},
+			want: []string{"CA", "MX", "US"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := dedupSorted(tc.ss)
+
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestIntersect tests intersect().
+func TestIntersect(t *testing.T) {
+	testCases := []struct {
+		name string
+		aa   []string
+		bb   []string
+		want []string
+	}{
+		{
+			name: "empty",
+			aa:   []string{},
+			bb:   []string{},
+			want: nil,
+		},
+		{
+			name: "aa only values",
+			aa:   []string{"1", "2"},
+			bb:   []string{},
+			want: nil,
+		},
+		{
+			name: "bb only values",
+			aa:   []string{},
+			bb:   []string{"1", "2"},
+			want: nil,
+		},
+		{
+			name: "mutually exclusive",
+			aa:   []string{"1", "2"},
+			bb:   []string{"7", "8", "9"},
+			want: nil,
+		},
+		{
+			name: "full overlap",
+			aa:   []string{"1", "2"},
+			bb:   []string{"1", "2"},
+			want: []string{"1", "2"},
+		},
+		{
+			name: "partial overlap",
+			aa:   []string{"1", "2", "3"},
+			bb:   []string{"2", "3", "4", "5"},
+			want: []string{"2", "3"},
+		},
+		{
+			name: "aa wildcard",
+			aa:   []string{"US-*"},
+			bb:   []string{"US-CA", "US-NY", "CA"},
+			want: []string{"US-CA", "US-NY"},
+		},
+		{
+			name: "bb wildcard",
+			aa:   []string{"US-CA", "CA"},
+			bb:   []string{"US-*"},
+			want: []string{"US-CA"},
+		},
+		{
+			name: "both wildcards",
+			aa:   []string{"US*"},
+			bb:   []string{"US-*"},
+			want: []string{"US-*"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := intersect(tc.aa, tc.bb)
+
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestUnion tests union().
+func TestUnion(t *testing.T) {
+	testCases := []struct {
+		name string
+		aa   []string
+		bb   []string
+		want []string
+	}{
+		{
+			name: "empty",
+			aa:   []string{},
+			bb:   []string{},
+			want: []string{},
+		},
+		{
+			name: "aa only values",
+			aa:   []string{"1", "2"},
+			bb:   []string{},
+			want: []string{"1", "2"},
+		},
+		{
+			name: "bb only values",
+			aa:   []string{},
+			bb:   []string{"1", "2"},
+			want: []string{"1", "2"},
+		},
+		{
+			name: "mutually exclusive",
+			aa:   []string{"1", "2"},
+			bb:   []string{"7", "8", "9"},
+			want: []string{"1", "2", "7", "8", "9"},
+		},
+		{
+			name: "full overlap",
+			aa:   []string{"1", "2"},
+			bb:   []string{"1", "2"},
+			want: []string{"1", "2"},
+		},
+		{
+			name: "partial overlap",
+			aa:   []string{"1", "2", "3"},
+			bb:   []string{"2", "3", "4", "5"},
+			want: []string{"1", "2", "3", "4", "5"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := union(tc.aa, tc.bb)
+
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestFetchOrderByRisk checks that orderByRisk serves the riskiest keys first, each in interval order.
+func TestFetchOrderByRisk(t *testing.T) {
+	ctx := context.Background()
+	elements := []interface{}{
+		makeExposure(ddd, 2, "US"),
+		makeExposure(ccc, 5, "US"),
+		makeExposure(aaa, 1, "US"),
+		makeExposure(bbb, 5, "US"),
+	}
+
+	testCases := []struct {
+		name        string
+		orderByRisk bool
+		want        []*pb.ContactTracingInfo
+	}{
+		{
+			name: "publish order",
+			want: []*pb.ContactTracingInfo{
+				{TransmissionRisk: 2, ExposureKeys: []*pb.ExposureKey{ddd}},
+				{TransmissionRisk: 5, ExposureKeys: []*pb.ExposureKey{ccc, bbb}},
+				{TransmissionRisk: 1, ExposureKeys: []*pb.ExposureKey{aaa}},
+			},
+		},
+		{
+			name:        "risk order",
+			orderByRisk: true,
+			want: []*pb.ContactTracingInfo{
+				{TransmissionRisk: 5, ExposureKeys: []*pb.ExposureKey{bbb, ccc}},
+				{TransmissionRisk: 2, ExposureKeys: []*pb.ExposureKey{ddd}},
+				{TransmissionRisk: 1, ExposureKeys: []*pb.ExposureKey{aaa}},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := &pb.FederationFetchRequest{RegionIdentifiers: []string{"US"}, OrderByRisk: tc.orderByRisk}
+			server := Server{env: serverenv.New(ctx), config: &Config{}}
+			got, err := server.fetch(ctx, req, testDeps(elements), time.Now())
+			if err != nil {
+				t.Fatalf("fetch() returned err=%v, want err=nil", err)
+			}
+			if len(got.Response) != 1 {
+				t.Fatalf("got %d region groups, want 1", len(got.Response))
+			}
+			if diff := cmp.Diff(tc.want, got.Response[0].ContactTracingInfo, protocmp.Transform()); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestFetchDedupKeys stores the same key under two region sets, and checks that dedupKeys serves it
+// once, under their union.
+func TestFetchDedupKeys(t *testing.T) {
+	ctx := context.Background()
+	elements := []interface{}{
+		makeExposure(aaa, 1, "US"),
+		makeExposure(bbb, 2, "US", "CA"),
+		makeExposure(aaa, 3, "CA", "MX"),
+		makeExposure(ccc, 1, "MX"),
+	}
+
+	testCases := []struct {
+		name      string
+		dedup     bool
+		want      []*pb.ContactTracingResponse
+		wantCount int64
+	}{
+		{
+			name: "grouped by region set",
+			want: []*pb.ContactTracingResponse{
+				{RegionIdentifiers: []string{"US"}, ContactTracingInfo: []*pb.ContactTracingInfo{{TransmissionRisk: 1, ExposureKeys: []*pb.ExposureKey{aaa}}}},
+				{RegionIdentifiers: []string{"CA", "US"}, ContactTracingInfo: []*pb.ContactTracingInfo{{TransmissionRisk: 2, ExposureKeys: []*pb.ExposureKey{bbb}}}},
+				{RegionIdentifiers: []string{"CA", "MX"}, ContactTracingInfo: []*pb.ContactTracingInfo{{TransmissionRisk: 3, ExposureKeys: []*pb.ExposureKey{aaa}}}},
+				{RegionIdentifiers: []string{"MX"}, ContactTracingInfo: []*pb.ContactTracingInfo{{TransmissionRisk: 1, ExposureKeys: []*pb.ExposureKey{ccc}}}},
+			},
+			wantCount: 4,
+		},
+		{
+			name:  "deduplicated",
+			dedup: true,
+			want: []*pb.ContactTracingResponse{
+				// aaa keeps the higher of its transmission risks.
+				{RegionIdentifiers: []string{"CA", "MX", "US"}, ContactTracingInfo: []*pb.ContactTracingInfo{{TransmissionRisk: 3, ExposureKeys: []*pb.ExposureKey{aaa}}}},
+				{RegionIdentifiers: []string{"CA", "US"}, ContactTracingInfo: []*pb.ContactTracingInfo{{TransmissionRisk: 2, ExposureKeys: []*pb.ExposureKey{bbb}}}},
+				{RegionIdentifiers: []string{"MX"}, ContactTracingInfo: []*pb.ContactTracingInfo{{TransmissionRisk: 1, ExposureKeys: []*pb.ExposureKey{ccc}}}},
+			},
+			wantCount: 3,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := &pb.FederationFetchRequest{RegionIdentifiers: []string{"US", "CA", "MX"}, DedupKeys: tc.dedup}
+			server := Server{env: serverenv.New(ctx), config: &Config{}}
+			got, err := server.fetch(ctx, req, testDeps(elements), time.Now())
+			if err != nil {
+				t.Fatalf("fetch() returned err=%v, want err=nil", err)
+			}
+			if diff := cmp.Diff(tc.want, got.Response, protocmp.Transform()); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+			if got.KeyCount != tc.wantCount {
+				t.Errorf("keyCount=%d, want %d", got.KeyCount, tc.wantCount)
+			}
+		})
+	}
+
+	req := &pb.FederationFetchRequest{RegionIdentifiers: []string{"US"}, DedupKeys: true, ExplodeRegions: true}
+	server := Server{env: serverenv.New(ctx), config: &Config{}}
+	if _, err := server.fetch(ctx, req, testDeps(elements), time.Now()); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("fetch() with explodeRegions returned err=%v, want code %v", err, codes.InvalidArgument)
+	}
+}
+
+// TestFetchTooManyKeyRegions checks that a key with more than MaxRegionsPerKey regions is skipped.
+func TestFetchTooManyKeyRegions(t *testing.T) {
+	ctx := context.Background()
+	many := make([]string, 5000)
+	for i := range many {
+		many[i] = fmt.Sprintf("R%d", i)
+	}
+	many[0] = "US"
+	elements := []interface{}{
+		makeExposure(aaa, 1, "US"),
+		makeExposure(bbb, 1, many...),
+		makeExposure(ccc, 1, "US"),
+	}
+
+	server := Server{env: serverenv.New(ctx), config: &Config{MaxRegionsPerKey: 100}}
+	got, err := server.fetch(ctx, &pb.FederationFetchRequest{RegionIdentifiers: []string{"US"}}, testDeps(elements), time.Now())
+	if err != nil {
+		t.Fatalf("fetch() returned err=%v, want err=nil", err)
+	}
+	want := []*pb.ContactTracingResponse{{
+		RegionIdentifiers:  []string{"US"},
+		ContactTracingInfo: []*pb.ContactTracingInfo{{TransmissionRisk: 1, ExposureKeys: []*pb.ExposureKey{aaa, ccc}}},
+	}}
+	if diff := cmp.Diff(want, got.Response, protocmp.Transform()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// TestFetchAtLiveEdge checks that atLiveEdge is only set when the client has reached the last complete
+// publish window, not whenever no keys match.
+func TestFetchAtLiveEdge(t *testing.T) {
+	ctx := context.Background()
+	elements := []interface{}{
+		makeExposure(aaa, 1, "US"),
+		makeExposure(bbb, 1, "US"),
+		makeExposure(ccc, 1, "US"),
+		makeExposure(ddd, 1, "US"),
+	}
+
+	testCases := []struct {
+		name     string
+		since    int64
+		until    int64
+		wantKeys int64
+		want     bool
+	}{
+		{name: "behind", since: 300, until: 1000, wantKeys: 2, want: false},
+		{name: "no keys since, behind", since: 500, until: 1000, want: false},
+		{name: "caught up", since: 900, until: 1000, want: true},
+		{name: "caught up to last window", since: 400, until: 500, want: true},
+		{name: "served last window", since: 300, until: 500, wantKeys: 2, want: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := Server{env: serverenv.New(ctx), config: &Config{TruncateWindow: 100 * time.Second}}
+			deps := testDeps(elements)
+			deps.latestCreatedAt = latestFunc(time.Unix(400, 0))
+			req := &pb.FederationFetchRequest{RegionIdentifiers: []string{"US"}, LastFetchResponseKeyTimestamp: tc.since}
+			got, err := server.fetch(ctx, req, deps, time.Unix(tc.until, 0))
+			if err != nil {
+				t.Fatalf("fetch() returned err=%v, want err=nil", err)
+			}
+			if got.KeyCount != tc.wantKeys || got.AtLiveEdge != tc.want {
+				t.Errorf("keyCount=%d, atLiveEdge=%v, want %d, %v", got.KeyCount, got.AtLiveEdge, tc.wantKeys, tc.want)
+			}
+		})
+	}
+}
+
+// TestFetchMinKeyAge tests that keys younger than a partner's minimum key age are withheld until
+// they age, while other partners receive them.
+func TestFetchMinKeyAge(t *testing.T) {
+	now := time.Now()
+	recent := makeExposure(aaa, 1, "US")
+	recent.CreatedAt = now.Add(-12 * time.Hour)
+	aged := makeExposure(bbb, 1, "US")
+	aged.CreatedAt = now.Add(-48 * time.Hour)
+	elements := []*model.Exposure{aged, recent}
+
+	testCases := []struct {
+		name     string
+		auth     *fedmodel.FederationOutAuthorization
+		wantKeys []string
+	}{
+		{
+			name:     "no lag",
+			auth:     &fedmodel.FederationOutAuthorization{IncludeRegions: []string{"US"}},
+			wantKeys: []string{"aaa", "bbb"},
+		},
+		{
+			name:     "24h lag",
+			auth:     &fedmodel.FederationOutAuthorization{IncludeRegions: []string{"US"}, MinKeyAge: 24 * time.Hour},
+			wantKeys: []string{"bbb"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.WithValue(context.Background(), authKey{}, tc.auth)
+			server := Server{env: serverenv.New(ctx), config: &Config{TruncateWindow: time.Hour}}
+			got, err := server.fetch(ctx, &pb.FederationFetchRequest{RegionIdentifiers: []string{"US"}}, pagedDeps(&elements), now)
+			if err != nil {
+				t.Fatalf("fetch() returned err=%v, want err=nil", err)
+			}
+			if diff := cmp.Diff(tc.wantKeys, servedKeys(got)); diff != "" {
+				t.Errorf("keys mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// orderedDeps returns fetchDependencies over elements which, like the database, iterate in creation
+// order, or its reverse, and resume from a Cursor issued in the same order.
+func orderedDeps(elements []*model.Exposure) fetchDependencies {
+	deps := testDeps(nil)
+	deps.filters = database.FullFilterSupport
+	deps.iterateExposures = func(_ context.Context, criteria database.IterateExposuresCriteria, f func(*model.Exposure) error) (string, error) {
+		var after *database.Cursor
+		if criteria.LastCursor != "" {
+			c, err := database.DecodeCursor(criteria.LastCursor)
+			if err != nil {
+				return "", err
+			}
+			if c.Reverse != criteria.Reverse {
+				return "", database.ErrInvalidCursor
+			}
+			after = c
+		}
+		sorted := append([]*model.Exposure(nil), elements...)
+		sort.Slice(sorted, func(i, j int) bool {
+			return sorted[i].CreatedAt.Before(sorted[j].CreatedAt) != criteria.Reverse
+		})
+		last := database.Cursor{Reverse: criteria.Reverse}
+		for _, exp := range sorted {
+			if after != nil && !after.Start() && (exp.CreatedAt.After(after.CreatedAt) == criteria.Reverse || exp.CreatedAt.Equal(after.CreatedAt)) {
+				continue
+			}
+			if err := f(exp); err != nil {
+				last.IssuedAt = time.Now()
+				return last.Encode(), err
+			}
+			last.CreatedAt, last.ExposureKey = exp.CreatedAt, exp.ExposureKey
+		}
+		return "", nil
+	}
+	return deps
+}
+
+// TestFetchReverseOrder pages through keys newest first, checking that every key is served once, in
+// order, and that a token from one order cannot resume the other.
+func TestFetchReverseOrder(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	exp := func(key string, age time.Duration, region string) *model.Exposure {
+		return &model.Exposure{ExposureKey: []byte(key), Regions: []string{region}, CreatedAt: now.Add(-age), LocalProvenance: true}
+	}
+	// Alternating regions and a limit of one region group per response force a page per key.
+	elements := []*model.Exposure{
+		exp("aaa", 5*time.Hour, "US"),
+		exp("bbb", 4*time.Hour, "CA"),
+		exp("ccc", 3*time.Hour, "US"),
+		exp("ddd", 2*time.Hour, "CA"),
+		exp("eee", 1*time.Hour, "US"),
+	}
+	server := Server{env: serverenv.New(ctx), config: &Config{MaxResponseGroups: 1}}
+	deps := orderedDeps(elements)
+
+	req := &pb.FederationFetchRequest{ReverseOrder: true}
+	var got []string
+	var timestamp int64
+	for i := 0; ; i++ {
+		if i > len(elements) {
+			t.Fatal("paging did not finish")
+		}
+		response, err := server.fetch(ctx, req, deps, now)
+		if err != nil {
+			t.Fatalf("fetch() returned err=%v, want err=nil", err)
+		}
+		got = append(got, servedKeys(response)...)
+		if response.FetchResponseKeyTimestamp > timestamp {
+			timestamp = response.FetchResponseKeyTimestamp
+		}
+		if !response.PartialResponse {
+			break
+		}
+		req = &pb.FederationFetchRequest{ReverseOrder: true, NextFetchToken: response.NextFetchToken}
+
+		// The token continues backward, so it cannot resume a forward fetch.
+		_, err = server.fetch(ctx, &pb.FederationFetchRequest{NextFetchToken: response.NextFetchToken}, deps, now)
+		if !errors.Is(err, ErrCursor) {
+			t.Errorf("forward fetch with a reverse token: got err=%v, want ErrCursor", err)
+		}
+	}
+	if diff := cmp.Diff([]string{"eee", "ddd", "ccc", "bbb", "aaa"}, got); diff != "" {
+		t.Errorf("keys mismatch (-want +got):\n%s", diff)
+	}
+	if want := elements[4].CreatedAt.Unix(); timestamp != want {
+		t.Errorf("largest fetchResponseKeyTimestamp: got %d, want %d", timestamp, want)
+	}
+
+	// An iterator which cannot produce the newest keys first cannot serve the request.
+	_, err := server.fetch(ctx, &pb.FederationFetchRequest{ReverseOrder: true}, testDeps(nil), now)
+	if status.Code(err) != codes.Unimplemented {
+		t.Errorf("reverse fetch from an unsupported iterator: got err=%v, want Unimplemented", err)
+	}
+}
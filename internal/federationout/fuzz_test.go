@@ -0,0 +1,265 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federationout
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/pb"
+	"github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	// fuzzKeyRegions are the regions keys are published to, and fuzzRequestRegions those a request may
+	// include or exclude, including a wildcard.
+	fuzzKeyRegions     = []string{"US", "CA", "MX", "US-NY", "US-CA", "GB"}
+	fuzzRequestRegions = []string{"US", "CA", "MX", "US-NY", "US-*", "GB", "FR"}
+
+	// fetchFuzzSeeds are inputs for fuzzFetchInput covering known tricky cases. Each is a header of
+	// included regions, excluded regions and flags, then three bytes per key: its regions, its
+	// transmission risk, and flags.
+	fetchFuzzSeeds = [][]byte{
+		// No keys.
+		{0x00, 0x00, 0x00},
+		// A key with no regions, and one with an empty exposure key.
+		{0x00, 0x00, 0x00, 0x00, 1, 0x00, 0x01, 2, 0x01},
+		// A key in an included and an excluded region, with and without strict exclusion.
+		{0x03, 0x02, 0x00, 0x03, 1, 0x00},
+		{0x03, 0x02, 0x01, 0x03, 1, 0x00},
+		// A key only in excluded regions.
+		{0x00, 0x06, 0x00, 0x06, 3, 0x00},
+		// Wildcard include and exclude of a subregion.
+		{0x10, 0x08, 0x00, 0x08, 1, 0x00, 0x10, 2, 0x00, 0x19, 4, 0x00},
+		// Regions both included and excluded.
+		{0x05, 0x05, 0x00, 0x05, 1, 0x00, 0x01, 1, 0x00},
+		// Federated keys and keys in another namespace.
+		{0x00, 0x00, 0x00, 0x01, 1, 0x02, 0x01, 1, 0x04, 0x01, 1, 0x00},
+		// Duplicate regions on a key, which must group with the deduplicated set.
+		{0x00, 0x00, 0x00, 0x03, 5, 0x08, 0x03, 5, 0x00},
+		// Exploded regions, with one excluded.
+		{0x00, 0x02, 0x02, 0x07, 6, 0x00, 0x03, 6, 0x00},
+	}
+)
+
+// fuzzFetchInput decodes data into stored exposures, a fetch request, and whether to exclude keys in
+// ANY excluded region. Missing bytes decode as zero, so that every input is valid.
+func fuzzFetchInput(data []byte) ([]*model.Exposure, *pb.FederationFetchRequest, bool) {
+	next := func() byte {
+		if len(data) == 0 {
+			return 0
+		}
+		b := data[0]
+		data = data[1:]
+		return b
+	}
+	pick := func(alphabet []string, mask byte) []string {
+		var regions []string
+		for i, region := range alphabet {
+			if mask&(1<<uint(i)) != 0 {
+				regions = append(regions, region)
+			}
+		}
+		return regions
+	}
+
+	req := &pb.FederationFetchRequest{
+		RegionIdentifiers:        pick(fuzzRequestRegions, next()),
+		ExcludeRegionIdentifiers: pick(fuzzRequestRegions, next()),
+	}
+	flags := next()
+	strict := flags&0x01 != 0
+	req.ExplodeRegions = flags&0x02 != 0
+
+	var exposures []*model.Exposure
+	for i := 0; len(data) > 0 && i < 64; i++ {
+		regions, risk, flags := next(), next(), next()
+		exp := &model.Exposure{
+			ExposureKey:      []byte(fmt.Sprintf("%016d", i)),
+			Regions:          pick(fuzzKeyRegions, regions),
+			TransmissionRisk: int(risk % 9),
+			IntervalNumber:   int32(i),
+			IntervalCount:    144,
+			CreatedAt:        time.Unix(int64(100*(i+1)), 0),
+			LocalProvenance:  flags&0x02 == 0,
+		}
+		if flags&0x01 != 0 {
+			exp.ExposureKey = nil
+		}
+		if flags&0x04 != 0 {
+			exp.Namespace = "other"
+		}
+		if flags&0x08 != 0 && len(exp.Regions) > 0 {
+			exp.Regions = append(exp.Regions, exp.Regions[0])
+		}
+		exposures = append(exposures, exp)
+	}
+	return exposures, req, strict
+}
+
+// matchesRegion reports whether region matches any of patterns, which may end in a wildcard.
+func matchesRegion(patterns []string, region string) bool {
+	for _, p := range patterns {
+		if p == region || (strings.HasSuffix(p, "*") && strings.HasPrefix(region, strings.TrimSuffix(p, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// wantRegions returns the regions of exp under which fetch should serve it, or nil if it should
+// not be served. Under the default exclude-wins precedence, a region both included and excluded is
+// excluded.
+func wantRegions(exp *model.Exposure, req *pb.FederationFetchRequest, strict bool) []string {
+	if len(exp.ExposureKey) == 0 || len(exp.Regions) == 0 || !exp.LocalProvenance || exp.Namespace != "" {
+		return nil
+	}
+	include, exclude := req.RegionIdentifiers, req.ExcludeRegionIdentifiers
+
+	var kept []string
+	anyIncluded := false
+	for _, region := range exp.Regions {
+		if matchesRegion(include, region) {
+			anyIncluded = true
+		}
+		if matchesRegion(exclude, region) {
+			if strict {
+				return nil
+			}
+			continue
+		}
+		kept = append(kept, region)
+	}
+	if len(kept) == 0 || (len(include) > 0 && !anyIncluded) {
+		return nil
+	}
+	if !req.ExplodeRegions {
+		return normalizeRegions(append([]string(nil), exp.Regions...))
+	}
+	var exploded []string
+	for _, region := range kept {
+		if len(include) == 0 || matchesRegion(include, region) {
+			exploded = append(exploded, region)
+		}
+	}
+	return normalizeRegions(exploded)
+}
+
+// checkFetch fetches the exposures and requests decoded from data twice, and checks the invariants of
+// the response.
+func checkFetch(t testing.TB, data []byte) {
+	t.Helper()
+	ctx := context.Background()
+	server := Server{env: serverenv.New(ctx), config: &Config{}}
+
+	fetch := func() (*pb.FederationFetchResponse, []*model.Exposure, *pb.FederationFetchRequest, bool) {
+		exposures, req, strict := fuzzFetchInput(data)
+		elements := make([]interface{}, len(exposures))
+		for i, exp := range exposures {
+			elements[i] = exp
+		}
+		// fetch modifies the request and exposures, so the oracle is given its own copies.
+		_, oracleReq, _ := fuzzFetchInput(data)
+		oracleExposures, _, _ := fuzzFetchInput(data)
+		server.config.StrictExclude = strict
+		got, err := server.fetch(ctx, req, testDeps(elements), time.Unix(1e6, 0))
+		if err != nil {
+			t.Fatalf("fetch(%x) returned err=%v, want err=nil", data, err)
+		}
+		return got, oracleExposures, oracleReq, strict
+	}
+	got, exposures, req, strict := fetch()
+	if again, _, _, _ := fetch(); !proto.Equal(got, again) {
+		t.Errorf("fetch(%x) is not deterministic:\n%v\n%v", data, got, again)
+	}
+
+	// Grouping: each region set is normalized and appears once, and each transmission risk once in it.
+	served := make(map[string][]string)
+	groups := make(map[string]bool)
+	keyCount := 0
+	for _, ctr := range got.Response {
+		regions := ctr.RegionIdentifiers
+		if len(regions) == 0 || !sort.StringsAreSorted(regions) || len(normalizeRegions(append([]string(nil), regions...))) != len(regions) {
+			t.Errorf("fetch(%x) served group %v, want sorted, unique, non-empty regions", data, regions)
+		}
+		if req.ExplodeRegions && len(regions) != 1 {
+			t.Errorf("fetch(%x) served group %v, want single regions when exploding", data, regions)
+		}
+		group := strings.Join(regions, ",")
+		if groups[group] {
+			t.Errorf("fetch(%x) served group %v twice", data, regions)
+		}
+		groups[group] = true
+
+		risks := make(map[int32]bool)
+		for _, cti := range ctr.ContactTracingInfo {
+			if risks[cti.TransmissionRisk] || len(cti.ExposureKeys) == 0 {
+				t.Errorf("fetch(%x) served risk %d in group %v twice or without keys", data, cti.TransmissionRisk, regions)
+			}
+			risks[cti.TransmissionRisk] = true
+			for _, key := range cti.ExposureKeys {
+				keyCount++
+				i := int(key.IntervalNumber)
+				if i < 0 || i >= len(exposures) || string(exposures[i].ExposureKey) != string(key.ExposureKey) {
+					t.Errorf("fetch(%x) served unknown key %x", data, key.ExposureKey)
+					continue
+				}
+				if int32(exposures[i].TransmissionRisk) != cti.TransmissionRisk {
+					t.Errorf("fetch(%x) served key %d with risk %d, want %d", data, i, cti.TransmissionRisk, exposures[i].TransmissionRisk)
+				}
+				served[string(key.ExposureKey)] = append(served[string(key.ExposureKey)], regions...)
+			}
+		}
+	}
+	if got.KeyCount != int64(keyCount) {
+		t.Errorf("fetch(%x) keyCount=%d, want %d", data, got.KeyCount, keyCount)
+	}
+
+	// Filtering: exactly the keys matching the request are served, under the expected regions. In
+	// particular, no key only in excluded regions is served.
+	for i, exp := range exposures {
+		want := wantRegions(exp, req, strict)
+		gotRegions := normalizeRegions(served[string(exp.ExposureKey)])
+		if len(exp.ExposureKey) == 0 {
+			gotRegions = nil
+		}
+		if strings.Join(want, ",") != strings.Join(gotRegions, ",") {
+			t.Errorf("fetch(%x) served key %d (regions %v, local %v, namespace %q) under %v, want %v",
+				data, i, exp.Regions, exp.LocalProvenance, exp.Namespace, gotRegions, want)
+		}
+	}
+}
+
+// TestFetchInvariants checks the invariants of fetch over the seed corpus and pseudo-random inputs.
+func TestFetchInvariants(t *testing.T) {
+	for _, seed := range fetchFuzzSeeds {
+		checkFetch(t, seed)
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		data := make([]byte, 3+3*r.Intn(20))
+		r.Read(data)
+		checkFetch(t, data)
+	}
+}
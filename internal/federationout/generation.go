@@ -0,0 +1,53 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federationout
+
+import (
+	"errors"
+	"strings"
+)
+
+// errStaleGeneration is returned when a nextFetchToken or syncToken was issued by a previous
+// generation of the key database.
+var errStaleGeneration = errors.New("token was issued by a previous generation of the key database")
+
+// generationSeparator separates the generation from the database cursor in a nextFetchToken. It does
+// not occur in either.
+const generationSeparator = "."
+
+// stampGeneration binds cursor to the server's generation, see WithGeneration.
+func (s Server) stampGeneration(cursor string) string {
+	if s.generation == "" || cursor == "" {
+		return cursor
+	}
+	return s.generation + generationSeparator + cursor
+}
+
+// checkGeneration returns the database cursor in a cursor stamped by stampGeneration, or
+// errStaleGeneration if it was stamped by another generation. Cursors issued before generations
+// were tracked carry none, and are accepted as is.
+func (s Server) checkGeneration(cursor string) (string, error) {
+	if s.generation == "" {
+		return cursor, nil
+	}
+	i := strings.Index(cursor, generationSeparator)
+	if i < 0 {
+		return cursor, nil
+	}
+	if cursor[:i] != s.generation {
+		return "", errStaleGeneration
+	}
+	return cursor[i+len(generationSeparator):], nil
+}
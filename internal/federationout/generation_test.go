@@ -0,0 +1,107 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federationout
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/pb"
+	"github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TestFetchGenerationChange simulates the key database being rebuilt between pages, checking that
+// tokens from the previous generation are rejected and a full refresh succeeds.
+func TestFetchGenerationChange(t *testing.T) {
+	for _, cursors := range []*cursorCodec{nil, mustCursorCodec(t, cursorKey1, "")} {
+		ctx := context.Background()
+		// A limit of one region group per response forces a page per group.
+		server := Server{env: serverenv.New(ctx), config: &Config{MaxResponseGroups: 1}, cursors: cursors, generation: "gen1"}
+		fetchUntil := time.Unix(1000, 0)
+
+		elements := []*model.Exposure{makeExposure(aaa, 1, "US"), makeExposure(bbb, 1, "CA")}
+		deps := pagedDeps(&elements)
+
+		got, err := server.fetch(ctx, &pb.FederationFetchRequest{RequestSyncToken: true}, deps, fetchUntil)
+		if err != nil {
+			t.Fatalf("fetch() returned err=%v, want err=nil", err)
+		}
+		if got.Generation != "gen1" {
+			t.Errorf("fetch() returned generation %q, want %q", got.Generation, "gen1")
+		}
+		if !got.PartialResponse || got.NextFetchToken == "" || got.SyncToken == "" {
+			t.Fatalf("fetch() returned %v, want a partial response with tokens", got)
+		}
+		nextFetchToken, syncToken := got.NextFetchToken, got.SyncToken
+
+		// The same generation resumes from either token.
+		for _, req := range []*pb.FederationFetchRequest{{NextFetchToken: nextFetchToken}, {SyncToken: syncToken}} {
+			got, err := server.fetch(ctx, req, deps, fetchUntil)
+			if err != nil {
+				t.Fatalf("fetch(%v) returned err=%v, want err=nil", req, err)
+			}
+			if keys := servedKeys(got); len(keys) != 1 || keys[0] != "bbb" {
+				t.Errorf("fetch(%v) served %v, want [bbb]", req, keys)
+			}
+		}
+
+		// The database is rebuilt, so the tokens no longer describe it.
+		server.generation = "gen2"
+		for _, req := range []*pb.FederationFetchRequest{{NextFetchToken: nextFetchToken}, {SyncToken: syncToken}} {
+			if _, err := server.fetch(ctx, req, deps, fetchUntil); status.Code(err) != codes.FailedPrecondition {
+				t.Errorf("fetch(%v) returned err=%v, want %v", req, err, codes.FailedPrecondition)
+			}
+		}
+
+		got, err = server.fetch(ctx, &pb.FederationFetchRequest{}, deps, fetchUntil)
+		if err != nil {
+			t.Fatalf("full refresh returned err=%v, want err=nil", err)
+		}
+		if got.Generation != "gen2" {
+			t.Errorf("full refresh returned generation %q, want %q", got.Generation, "gen2")
+		}
+	}
+}
+
+// TestCheckGeneration tests reading the database cursor from a stamped cursor.
+func TestCheckGeneration(t *testing.T) {
+	server := Server{generation: "gen1"}
+	testCases := []struct {
+		name    string
+		cursor  string
+		want    string
+		wantErr error
+	}{
+		{name: "empty", cursor: "", want: ""},
+		{name: "stamped", cursor: server.stampGeneration("abc_cursor"), want: "abc_cursor"},
+		{name: "unstamped", cursor: "abc_cursor", want: "abc_cursor"},
+		{name: "other generation", cursor: "gen0.abc_cursor", wantErr: errStaleGeneration},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := server.checkGeneration(tc.cursor)
+			if err != tc.wantErr {
+				t.Fatalf("checkGeneration(%q) returned err=%v, want %v", tc.cursor, err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Errorf("checkGeneration(%q) = %q, want %q", tc.cursor, got, tc.want)
+			}
+		})
+	}
+}
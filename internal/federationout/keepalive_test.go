@@ -0,0 +1,98 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federationout
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/pb"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// countingListener counts the connections it accepts.
+type countingListener struct {
+	net.Listener
+	accepted int32
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		atomic.AddInt32(&l.accepted, 1)
+	}
+	return conn, err
+}
+
+// slowFetchServer answers every fetch with an empty response after a delay.
+type slowFetchServer struct {
+	pb.UnimplementedFederationServer
+	delay time.Duration
+}
+
+func (s *slowFetchServer) Fetch(ctx context.Context, _ *pb.FederationFetchRequest) (*pb.FederationFetchResponse, error) {
+	select {
+	case <-time.After(s.delay):
+		return &pb.FederationFetchResponse{}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// TestKeepaliveMaxConnectionAge checks that a connection is recycled once it reaches its maximum age,
+// and that a fetch in progress at that time still completes.
+func TestKeepaliveMaxConnectionAge(t *testing.T) {
+	ctx := context.Background()
+	buf := bufconn.Listen(1 << 20)
+	listener := &countingListener{Listener: buf}
+	grpcServer := grpc.NewServer(KeepaliveOptions(&Config{MaxConnectionAge: 100 * time.Millisecond})...)
+	pb.RegisterFederationServer(grpcServer, &slowFetchServer{delay: 500 * time.Millisecond})
+	go grpcServer.Serve(listener)
+	defer grpcServer.Stop()
+
+	conn, err := grpc.DialContext(ctx, "bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return buf.Dial() }),
+		grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("grpc.DialContext: %v", err)
+	}
+	defer conn.Close()
+	client := pb.NewFederationClient(conn)
+
+	// The fetch outlives the connection's maximum age, so is drained rather than cut off.
+	if _, err := client.Fetch(ctx, &pb.FederationFetchRequest{}); err != nil {
+		t.Fatalf("Fetch across the maximum connection age returned err=%v, want err=nil", err)
+	}
+	// The aged connection is closed, so the next fetch reconnects.
+	if _, err := client.Fetch(ctx, &pb.FederationFetchRequest{}, grpc.WaitForReady(true)); err != nil {
+		t.Fatalf("Fetch after the maximum connection age returned err=%v, want err=nil", err)
+	}
+	if got := atomic.LoadInt32(&listener.accepted); got < 2 {
+		t.Errorf("accepted %d connections, want the aged connection to be replaced", got)
+	}
+}
+
+func TestNewServerConnectionAgeGrace(t *testing.T) {
+	ctx := context.Background()
+	config := &Config{Timeout: 5 * time.Minute, MaxConnectionAge: time.Hour, MaxConnectionAgeGrace: time.Minute}
+	if _, err := NewServer(serverenv.New(ctx), config); err == nil {
+		t.Errorf("NewServer() with a grace shorter than the fetch timeout returned err=nil, want an error")
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federationout
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/google/exposure-notifications-server/internal/pb"
+)
+
+// keyFilter is a bloom filter of exposure keys, in the layout described on pb.KeyFilter.
+type keyFilter struct {
+	bits      []byte
+	hashCount uint64
+}
+
+// newKeyFilter checks that f is within the given limits, and returns the filter it describes.
+func newKeyFilter(f *pb.KeyFilter, maxBytes, maxHashCount int) (*keyFilter, error) {
+	if maxBytes <= 0 {
+		return nil, fmt.Errorf("knownKeys is not supported by this server")
+	}
+	if len(f.Bits) == 0 || len(f.Bits) > maxBytes {
+		return nil, fmt.Errorf("knownKeys has %d bytes, must be between 1 and %d", len(f.Bits), maxBytes)
+	}
+	if f.HashCount <= 0 || int(f.HashCount) > maxHashCount {
+		return nil, fmt.Errorf("knownKeys has hashCount %d, must be between 1 and %d", f.HashCount, maxHashCount)
+	}
+	return &keyFilter{bits: f.Bits, hashCount: uint64(f.HashCount)}, nil
+}
+
+// positions calls fn with each bit position of key, stopping and returning false if fn does.
+func (f *keyFilter) positions(key []byte, fn func(bit uint64) bool) bool {
+	sum := sha256.Sum256(key)
+	h1 := binary.BigEndian.Uint64(sum[0:8])
+	h2 := binary.BigEndian.Uint64(sum[8:16])
+	m := uint64(len(f.bits)) * 8
+	for i := uint64(0); i < f.hashCount; i++ {
+		if !fn((h1 + i*h2) % m) {
+			return false
+		}
+	}
+	return true
+}
+
+// add sets the bits of key.
+func (f *keyFilter) add(key []byte) {
+	f.positions(key, func(bit uint64) bool {
+		f.bits[bit/8] |= 1 << (bit % 8)
+		return true
+	})
+}
+
+// contains reports whether key may have been added. It is never false for a key which was added.
+func (f *keyFilter) contains(key []byte) bool {
+	return f.positions(key, func(bit uint64) bool {
+		return f.bits[bit/8]&(1<<(bit%8)) != 0
+	})
+}
@@ -0,0 +1,113 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federationout
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/pb"
+	"github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TestFetchKnownKeys sends a filter of half the keys, and checks that only about the other half is served.
+func TestFetchKnownKeys(t *testing.T) {
+	ctx := context.Background()
+	server := Server{env: serverenv.New(ctx), config: &Config{MaxKeyFilterBytes: 4096, MaxKeyFilterHashCount: 10}}
+
+	const n = 2000
+	var elements []interface{}
+	known := &keyFilter{bits: make([]byte, 2048), hashCount: 7} // About 16 bits per known key.
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key-%06d-000000", i))
+		elements = append(elements, &model.Exposure{
+			ExposureKey:     key,
+			Regions:         []string{"US"},
+			IntervalNumber:  int32(i),
+			CreatedAt:       time.Unix(100, 0),
+			LocalProvenance: true,
+		})
+		if i%2 == 0 {
+			known.add(key)
+		}
+	}
+
+	req := &pb.FederationFetchRequest{KnownKeys: &pb.KeyFilter{Bits: known.bits, HashCount: int32(known.hashCount)}}
+	got, err := server.fetch(ctx, req, testDeps(elements), time.Unix(1000, 0))
+	if err != nil {
+		t.Fatalf("fetch() returned err=%v, want err=nil", err)
+	}
+
+	served := servedKeys(got)
+	if got.KeyCount+got.KnownKeyCount != n {
+		t.Errorf("served %d and skipped %d known keys, want %d in total", got.KeyCount, got.KnownKeyCount, n)
+	}
+	// There are no false negatives, so no known key is served. False positives skip a few others.
+	for _, key := range served {
+		var i int
+		if _, err := fmt.Sscanf(key, "key-%06d", &i); err != nil {
+			t.Fatal(err)
+		}
+		if i%2 == 0 {
+			t.Errorf("served known key %q", key)
+		}
+	}
+	if len(served) < n/2*95/100 || len(served) > n/2 {
+		t.Errorf("served %d keys, want about %d", len(served), n/2)
+	}
+}
+
+func TestFetchKnownKeysLimits(t *testing.T) {
+	ctx := context.Background()
+	server := Server{env: serverenv.New(ctx), config: &Config{MaxKeyFilterBytes: 16, MaxKeyFilterHashCount: 4}}
+	deps := testDeps([]interface{}{makeExposure(aaa, 1, "US")})
+
+	testCases := []struct {
+		name   string
+		filter *pb.KeyFilter
+	}{
+		{name: "empty", filter: &pb.KeyFilter{HashCount: 1}},
+		{name: "too large", filter: &pb.KeyFilter{Bits: make([]byte, 17), HashCount: 1}},
+		{name: "no hashes", filter: &pb.KeyFilter{Bits: make([]byte, 16)}},
+		{name: "too many hashes", filter: &pb.KeyFilter{Bits: make([]byte, 16), HashCount: 5}},
+	}
+	for _, tc := range testCases {
+		_, err := server.fetch(ctx, &pb.FederationFetchRequest{KnownKeys: tc.filter}, deps, time.Unix(1000, 0))
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("%s: fetch() returned err=%v, want InvalidArgument", tc.name, err)
+		}
+	}
+
+	// The limits are reported on a debug fetch, so a client can size its filter.
+	got, err := server.fetch(ctx, &pb.FederationFetchRequest{Debug: true}, deps, time.Unix(1000, 0))
+	if err != nil {
+		t.Fatalf("fetch() returned err=%v, want err=nil", err)
+	}
+	if c := got.EffectiveCriteria; c.MaxKeyFilterBytes != 16 || c.MaxKeyFilterHashCount != 4 {
+		t.Errorf("effectiveCriteria reports limits of %d bytes and %d hashes, want 16 and 4", c.MaxKeyFilterBytes, c.MaxKeyFilterHashCount)
+	}
+
+	// A server with no limit does not support filters.
+	server.config.MaxKeyFilterBytes = 0
+	_, err = server.fetch(ctx, &pb.FederationFetchRequest{KnownKeys: &pb.KeyFilter{Bits: make([]byte, 1), HashCount: 1}}, deps, time.Unix(1000, 0))
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("fetch() with knownKeys unsupported returned err=%v, want InvalidArgument", err)
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federationout
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fetchLimiter bounds the number of fetches in progress at once, since each
+// fetch can hold a database connection and a large response in memory.
+type fetchLimiter struct {
+	slots chan struct{}
+	wait  time.Duration
+}
+
+// newFetchLimiter creates a limiter admitting max concurrent fetches. Requests
+// over the limit wait up to wait for a slot. If max is not positive, there is
+// no limit and nil is returned.
+func newFetchLimiter(max int, wait time.Duration) *fetchLimiter {
+	if max <= 0 {
+		return nil
+	}
+	return &fetchLimiter{
+		slots: make(chan struct{}, max),
+		wait:  wait,
+	}
+}
+
+// acquire reserves a slot, returning a function which must be called to release it.
+// If no slot becomes available in time, a ResourceExhausted error is returned.
+func (l *fetchLimiter) acquire(ctx context.Context) (func(), error) {
+	release := func() { <-l.slots }
+
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	if l.wait <= 0 {
+		return nil, status.Error(codes.ResourceExhausted, "too many concurrent fetches")
+	}
+
+	timer := time.NewTimer(l.wait)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	case <-timer.C:
+		return nil, status.Errorf(codes.ResourceExhausted, "too many concurrent fetches, waited %v", l.wait)
+	case <-ctx.Done():
+		return nil, status.Errorf(codes.ResourceExhausted, "too many concurrent fetches: %v", ctx.Err())
+	}
+}
+
+// inFlight returns the number of fetches currently holding a slot.
+func (l *fetchLimiter) inFlight() int {
+	return len(l.slots)
+}
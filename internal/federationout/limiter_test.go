@@ -0,0 +1,91 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federationout
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/pb"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TestFetchLimiter tests that the limiter admits up to its limit and then queues or rejects.
+func TestFetchLimiter(t *testing.T) {
+	ctx := context.Background()
+
+	if l := newFetchLimiter(0, time.Second); l != nil {
+		t.Errorf("newFetchLimiter(0) = %v, want nil", l)
+	}
+
+	l := newFetchLimiter(2, 50*time.Millisecond)
+	var releases []func()
+	for i := 0; i < 2; i++ {
+		release, err := l.acquire(ctx)
+		if err != nil {
+			t.Fatalf("acquire() #%d returned err=%v", i, err)
+		}
+		releases = append(releases, release)
+	}
+	if got := l.inFlight(); got != 2 {
+		t.Errorf("inFlight() = %d, want 2", got)
+	}
+
+	// Saturated: the request waits, then is rejected.
+	start := time.Now()
+	if _, err := l.acquire(ctx); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("acquire() on saturated limiter returned err=%v, want ResourceExhausted", err)
+	}
+	if waited := time.Since(start); waited < 50*time.Millisecond {
+		t.Errorf("acquire() returned after %v, want to wait at least %v", waited, 50*time.Millisecond)
+	}
+
+	// Saturated: a queued request is admitted once a slot is released.
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		releases[0]()
+	}()
+	release, err := l.acquire(ctx)
+	if err != nil {
+		t.Fatalf("acquire() after release returned err=%v", err)
+	}
+	release()
+	releases[1]()
+	if got := l.inFlight(); got != 0 {
+		t.Errorf("inFlight() = %d, want 0", got)
+	}
+}
+
+// TestFetchRejectedWhenSaturated tests that Fetch rejects requests over the concurrency limit
+// with ResourceExhausted, without querying the database.
+func TestFetchRejectedWhenSaturated(t *testing.T) {
+	ctx := context.Background()
+	env := serverenv.New(ctx)
+	server := Server{env: env, config: &Config{Timeout: time.Minute}, limiter: newFetchLimiter(1, 0)}
+
+	release, err := server.limiter.acquire(ctx)
+	if err != nil {
+		t.Fatalf("acquire() returned err=%v", err)
+	}
+	defer release()
+
+	_, err = server.Fetch(ctx, &pb.FederationFetchRequest{})
+	if code := status.Code(err); code != codes.ResourceExhausted {
+		t.Errorf("Fetch() returned err=%v, want code %v", err, codes.ResourceExhausted)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federationout
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// errSlowFetch is returned by the exposure callback to cut a fetch whose keys are being produced too
+// slowly to be worth waiting for.
+var errSlowFetch = errors.New("keys produced too slowly")
+
+// pacerBuckets is the number of buckets a fetchPacer's window is divided into.
+const pacerBuckets = 10
+
+// fetchPacer measures how fast a fetch's iterator produces keys, over a window sliding in steps of a
+// tenth of its length, so that a fetch making slow progress can be cut to a partial response well
+// before its deadline. A fast fetch is left to run until the deadline, as before.
+type fetchPacer struct {
+	start   time.Time
+	cutoff  time.Time     // slow fetches are not cut before this
+	window  time.Duration // the window over which the rate is measured
+	width   time.Duration // window / pacerBuckets
+	minKeys float64       // keys the window must hold for the fetch not to be slow
+
+	counts [pacerBuckets]int // keys produced, by bucket, ring indexed by bucket number
+	last   int64             // number of the most recent bucket
+}
+
+// newFetchPacer creates a pacer for a fetch starting at start under ctx, as configured. It returns
+// nil if pacing is disabled or ctx has no deadline.
+func newFetchPacer(ctx context.Context, config *Config, start time.Time) *fetchPacer {
+	if config.SlowFetchRate <= 0 || config.SlowFetchWindow <= 0 {
+		return nil
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return nil
+	}
+	window := config.SlowFetchWindow
+	width := window / pacerBuckets
+	if width <= 0 {
+		width = 1
+	}
+	return &fetchPacer{
+		start:   start,
+		cutoff:  start.Add(time.Duration(config.SlowFetchCutoff * float64(deadline.Sub(start)))),
+		window:  window,
+		width:   width,
+		minKeys: config.SlowFetchRate * window.Seconds(),
+	}
+}
+
+// observe records a key produced at now, and reports whether the fetch is producing keys too slowly:
+// past the cutoff, with fewer keys than the rate requires over the last window. The rate is not
+// judged before a whole window has elapsed.
+func (p *fetchPacer) observe(now time.Time) bool {
+	bucket := int64(now.Sub(p.start) / p.width)
+	if bucket > p.last {
+		// Clear the buckets which have slid out of the window since the previous key.
+		for i := p.last + 1; i <= bucket && i <= p.last+pacerBuckets; i++ {
+			p.counts[i%pacerBuckets] = 0
+		}
+		p.last = bucket
+	}
+	p.counts[p.last%pacerBuckets]++
+
+	if now.Before(p.cutoff) || now.Sub(p.start) < p.window {
+		return false
+	}
+	total := 0
+	for _, n := range p.counts {
+		total += n
+	}
+	return float64(total) < p.minKeys
+}
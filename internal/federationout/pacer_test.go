@@ -0,0 +1,133 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federationout
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/metrics"
+	"github.com/google/exposure-notifications-server/internal/pb"
+	"github.com/google/exposure-notifications-server/internal/publish/database"
+	"github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+)
+
+func TestFetchPacer(t *testing.T) {
+	t.Parallel()
+
+	start := time.Now()
+	ctx, cancel := context.WithDeadline(context.Background(), start.Add(10*time.Second))
+	defer cancel()
+	config := &Config{SlowFetchRate: 5, SlowFetchWindow: time.Second, SlowFetchCutoff: 0.2}
+
+	if p := newFetchPacer(context.Background(), config, start); p != nil {
+		t.Errorf("newFetchPacer() without a deadline = %+v, want nil", p)
+	}
+	if p := newFetchPacer(ctx, &Config{SlowFetchWindow: time.Second}, start); p != nil {
+		t.Errorf("newFetchPacer() without a rate = %+v, want nil", p)
+	}
+
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+
+	// One key a second is slow, but is only judged so from the 2s cutoff.
+	p := newFetchPacer(ctx, config, start)
+	for i := 0; i < 2; i++ {
+		if p.observe(at(time.Duration(i) * time.Second)) {
+			t.Fatalf("observe(%ds) = true before the cutoff", i)
+		}
+	}
+	if !p.observe(at(2 * time.Second)) {
+		t.Errorf("observe(2s) = false, want slow")
+	}
+
+	// Ten keys a second is fast enough, however late.
+	p = newFetchPacer(ctx, config, start)
+	for d := time.Duration(0); d < 9*time.Second; d += 100 * time.Millisecond {
+		if p.observe(at(d)) {
+			t.Fatalf("observe(%v) = true, want fast", d)
+		}
+	}
+
+	// Keys from before the window slid on no longer count after a pause.
+	if !p.observe(at(10*time.Second + 500*time.Millisecond)) {
+		t.Errorf("observe after a pause = false, want slow")
+	}
+}
+
+// TestFetchSlowPartial runs a slow and a fast iterator under the same deadline, checking that the
+// slow one is cut to a partial response well before the deadline, and the fast one is not.
+func TestFetchSlowPartial(t *testing.T) {
+	t.Parallel()
+
+	const timeout = time.Second
+	config := &Config{SlowFetchRate: 100, SlowFetchWindow: 100 * time.Millisecond, SlowFetchCutoff: 0.25}
+
+	// stream produces keys every interval until its context is done.
+	stream := func(interval time.Duration) iterateExposuresFunc {
+		return func(ctx context.Context, _ database.IterateExposuresCriteria, f func(*model.Exposure) error) (string, error) {
+			var cursor string
+			for i := 1; ; i++ {
+				select {
+				case <-ctx.Done():
+					return cursor, ctx.Err()
+				case <-time.After(interval):
+				}
+				exp := makeExposure(&pb.ExposureKey{ExposureKey: []byte("aaa"), IntervalNumber: int32(i)}, 1, "US")
+				if err := f(exp); err != nil {
+					return cursor, err
+				}
+				cursor = string(exp.ExposureKey) + "_cursor"
+			}
+		}
+	}
+
+	run := func(t *testing.T, interval time.Duration) (*pb.FederationFetchResponse, int64, time.Duration) {
+		exporter := &countingExporter{counts: map[string]int64{}}
+		env := serverenv.New(context.Background(), serverenv.WithMetricsExporter(func(context.Context) metrics.Exporter { return exporter }))
+		server := Server{env: env, config: config}
+		deps := testDeps(nil)
+		deps.iterateExposures = stream(interval)
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		start := time.Now()
+		got, err := server.fetch(ctx, &pb.FederationFetchRequest{}, deps, time.Now())
+		if err != nil {
+			t.Fatalf("fetch() returned err=%v, want err=nil", err)
+		}
+		if !got.PartialResponse || got.NextFetchToken == "" {
+			t.Errorf("fetch() = partial %t, token %q, want a partial response", got.PartialResponse, got.NextFetchToken)
+		}
+		return got, exporter.counts["federation-fetch-slow"], time.Since(start)
+	}
+
+	_, slowCuts, slowTook := run(t, 30*time.Millisecond)
+	_, fastCuts, fastTook := run(t, time.Millisecond)
+
+	if slowCuts != 1 {
+		t.Errorf("slow fetch counted %d slow cuts, want 1", slowCuts)
+	}
+	if fastCuts != 0 {
+		t.Errorf("fast fetch counted %d slow cuts, want 0", fastCuts)
+	}
+	if slowTook >= timeout*3/4 {
+		t.Errorf("slow fetch took %v, want it cut well before the %v deadline", slowTook, timeout)
+	}
+	if fastTook < timeout*3/4 {
+		t.Errorf("fast fetch took %v, want it to run close to the %v deadline", fastTook, timeout)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federationout
+
+import (
+	"context"
+	"sync"
+
+	"github.com/google/exposure-notifications-server/internal/federationin/model"
+	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/pb"
+)
+
+// partialTracker counts the consecutive partial responses served to each client. A client whose
+// fetches keep ending in partial responses, rather than occasionally paging through a backlog,
+// likely fetches too large a window or has too short a timeout.
+type partialTracker struct {
+	threshold int
+
+	mu      sync.Mutex
+	streaks map[string]int // by OIDC subject
+}
+
+// newPartialTracker creates a tracker which warns once a client has been served threshold
+// consecutive partial responses. If threshold is not positive, nothing is tracked and nil is
+// returned.
+func newPartialTracker(threshold int) *partialTracker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &partialTracker{
+		threshold: threshold,
+		streaks:   make(map[string]int),
+	}
+}
+
+// observe records a response served to subject, returning the client's streak of consecutive
+// partial responses. A complete response ends the streak.
+func (p *partialTracker) observe(subject string, partial bool) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !partial {
+		delete(p.streaks, subject)
+		return 0
+	}
+	p.streaks[subject]++
+	return p.streaks[subject]
+}
+
+// trackPartials records response against the client with the given authorization, warning each
+// time the client's streak of partial responses reaches a multiple of the threshold. Clients
+// without an authorization are not tracked.
+func (s Server) trackPartials(ctx context.Context, auth *model.FederationOutAuthorization, response *pb.FederationFetchResponse) {
+	if s.partials == nil || auth == nil {
+		return
+	}
+	streak := s.partials.observe(auth.Subject, response.PartialResponse)
+	if streak == 0 || streak%s.partials.threshold != 0 {
+		return
+	}
+	logging.FromContext(ctx).Warnf("Client %q has been served %d partial responses in a row, its fetch window may be too large or its timeout too short", auth.Subject, streak)
+	s.env.MetricsExporter(ctx).WriteInt("federation-fetch-partial-streak", true, 1)
+}
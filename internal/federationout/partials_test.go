@@ -0,0 +1,80 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federationout
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	fedmodel "github.com/google/exposure-notifications-server/internal/federationin/model"
+	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/metrics"
+	"github.com/google/exposure-notifications-server/internal/pb"
+	"github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// TestFetchPartialStreak drives consecutive partial responses to a client, checking that the
+// warning fires at the threshold and that a complete response resets the streak.
+func TestFetchPartialStreak(t *testing.T) {
+	core, logs := observer.New(zap.WarnLevel)
+	ctx := logging.WithLogger(context.Background(), zap.New(core).Sugar())
+	ctx = context.WithValue(ctx, authKey{}, &fedmodel.FederationOutAuthorization{Subject: "partner-a"})
+	exporter := &countingExporter{counts: map[string]int64{}}
+	env := serverenv.New(ctx, serverenv.WithMetricsExporter(func(context.Context) metrics.Exporter { return exporter }))
+	// A limit of one region group per response forces a page per group.
+	server := Server{env: env, config: &Config{MaxResponseGroups: 1}, partials: newPartialTracker(2)}
+	fetchUntil := time.Unix(1000, 0)
+
+	elements := []*model.Exposure{makeExposure(aaa, 1, "US"), makeExposure(bbb, 1, "CA")}
+	deps := pagedDeps(&elements)
+
+	fetch := func(req *pb.FederationFetchRequest, wantPartial bool, wantWarnings int) *pb.FederationFetchResponse {
+		t.Helper()
+		got, err := server.fetch(ctx, req, deps, fetchUntil)
+		if err != nil {
+			t.Fatalf("fetch() returned err=%v, want err=nil", err)
+		}
+		if got.PartialResponse != wantPartial {
+			t.Fatalf("fetch() partialResponse=%v, want %v", got.PartialResponse, wantPartial)
+		}
+		if n := logs.FilterMessageSnippet("partial responses in a row").Len(); n != wantWarnings {
+			t.Errorf("logged %d warnings, want %d", n, wantWarnings)
+		}
+		if n := exporter.counts["federation-fetch-partial-streak"]; n != int64(wantWarnings) {
+			t.Errorf("federation-fetch-partial-streak=%d, want %d", n, wantWarnings)
+		}
+		return got
+	}
+
+	// The client keeps starting over, never completing a fetch.
+	fetch(&pb.FederationFetchRequest{}, true, 0)
+	fetch(&pb.FederationFetchRequest{}, true, 1)
+	got := fetch(&pb.FederationFetchRequest{}, true, 1)
+
+	// Completing the fetch resets the streak.
+	fetch(&pb.FederationFetchRequest{NextFetchToken: got.NextFetchToken}, false, 1)
+	fetch(&pb.FederationFetchRequest{}, true, 1)
+	fetch(&pb.FederationFetchRequest{}, true, 2)
+}
+
+func TestPartialTrackerDisabled(t *testing.T) {
+	if p := newPartialTracker(0); p != nil {
+		t.Errorf("newPartialTracker(0) = %v, want nil", p)
+	}
+}
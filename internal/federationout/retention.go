@@ -0,0 +1,107 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federationout
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/federationin/model"
+	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/pb"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type oldestCreatedAtFunc func(ctx context.Context, region, namespace string, notExpiredAt time.Time) (time.Time, error)
+
+type retentionDependencies struct {
+	latestCreatedAt latestCreatedAtFunc
+	oldestCreatedAt oldestCreatedAtFunc
+}
+
+// Retention implements the FederationServer Retention endpoint.
+func (s Server) Retention(ctx context.Context, req *pb.RetentionRequest) (*pb.RetentionResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.fetchTimeout(ctx))
+	defer cancel()
+	logger := logging.FromContext(ctx)
+	metrics := s.env.MetricsExporter(ctx)
+
+	deps := retentionDependencies{
+		latestCreatedAt: s.exposures.LatestCreatedAt,
+		oldestCreatedAt: s.exposures.OldestCreatedAt,
+	}
+	response, err := s.retention(ctx, req, deps, time.Now())
+	if err != nil {
+		metrics.WriteInt("federation-retention-failed", true, 1)
+		logger.Errorf("Retention error: %v", err)
+		return nil, fetchStatus(err)
+	}
+	return response, nil
+}
+
+func (s Server) retention(ctx context.Context, req *pb.RetentionRequest, deps retentionDependencies, now time.Time) (*pb.RetentionResponse, error) {
+	logger := logging.FromContext(ctx)
+
+	regions := make([]string, len(req.RegionIdentifiers))
+	for i, region := range req.RegionIdentifiers {
+		regions[i] = strings.ToUpper(region)
+	}
+	var excludeRegions []string
+	var namespace string
+	if auth, ok := ctx.Value(authKey{}).(*model.FederationOutAuthorization); ok {
+		namespace = auth.Namespace
+		regions = intersect(regions, auth.IncludeRegions)
+		excludeRegions = auth.ExcludeRegions
+	}
+	if len(regions) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "regionIdentifiers is required")
+	}
+	regions = normalizeRegions(regions)
+	excludedRegions := newRegionMatcher(excludeRegions)
+
+	response := &pb.RetentionResponse{}
+	for _, region := range regions {
+		// Regions the client may not fetch are not reported.
+		if excludedRegions.matches(region) {
+			continue
+		}
+		retention := &pb.RegionRetention{RegionIdentifier: region}
+		response.Regions = append(response.Regions, retention)
+
+		// The watermark is cheap to read, and if it is unset nothing was ever published in the region.
+		latest, err := deps.latestCreatedAt(ctx, []string{region})
+		if err != nil {
+			return nil, &fetchError{kind: ErrQuery, err: fmt.Errorf("reading region watermarks: %w", err)}
+		}
+		if latest.IsZero() {
+			continue
+		}
+		oldest, err := deps.oldestCreatedAt(ctx, region, namespace, now)
+		if err != nil {
+			return nil, &fetchError{kind: ErrQuery, err: fmt.Errorf("reading oldest key: %w", err)}
+		}
+		if oldest.IsZero() {
+			continue
+		}
+		retention.OldestTimestamp = oldest.Unix()
+		retention.LatestTimestamp = latest.Unix()
+	}
+	logger.Infof("Reported retention of %d regions", len(response.Regions))
+	return response, nil
+}
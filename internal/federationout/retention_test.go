@@ -0,0 +1,149 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federationout
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	fedmodel "github.com/google/exposure-notifications-server/internal/federationin/model"
+	"github.com/google/exposure-notifications-server/internal/pb"
+	"github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/testing/protocmp"
+)
+
+// retentionDeps serves the oldest and latest keys of exposures, as the database would.
+func retentionDeps(exposures []*model.Exposure) retentionDependencies {
+	return retentionDependencies{
+		latestCreatedAt: func(_ context.Context, regions []string) (time.Time, error) {
+			var latest time.Time
+			matcher := newRegionMatcher(regions)
+			for _, e := range exposures {
+				if e.LocalProvenance && matcher.matchesAny(e.Regions) && e.CreatedAt.After(latest) {
+					latest = e.CreatedAt
+				}
+			}
+			return latest, nil
+		},
+		oldestCreatedAt: func(_ context.Context, region, namespace string, notExpiredAt time.Time) (time.Time, error) {
+			var oldest time.Time
+			matcher := newRegionMatcher([]string{region})
+			for _, e := range exposures {
+				if !e.LocalProvenance || e.Namespace != namespace || !matcher.matchesAny(e.Regions) {
+					continue
+				}
+				if !e.ExpiresAt.IsZero() && !e.ExpiresAt.After(notExpiredAt) {
+					continue
+				}
+				if oldest.IsZero() || e.CreatedAt.Before(oldest) {
+					oldest = e.CreatedAt
+				}
+			}
+			return oldest, nil
+		},
+	}
+}
+
+func TestRetention(t *testing.T) {
+	now := time.Unix(100000, 0)
+	exp := func(createdAt int64, regions ...string) *model.Exposure {
+		return &model.Exposure{Regions: regions, CreatedAt: time.Unix(createdAt, 0), LocalProvenance: true}
+	}
+	expired := exp(100, "US")
+	expired.ExpiresAt = now.Add(-time.Hour)
+	federated := exp(200, "CA")
+	federated.LocalProvenance = false
+	exposures := []*model.Exposure{
+		expired,   // No longer servable, so it does not hold back US.
+		federated, // Never served.
+		exp(1000, "US", "CA"),
+		exp(3000, "US-WA"),
+		exp(4000, "CA"),
+		exp(5000, "US"),
+		exp(2000, "US-OR"),
+	}
+
+	testCases := []struct {
+		name string
+		auth *fedmodel.FederationOutAuthorization
+		req  *pb.RetentionRequest
+		want *pb.RetentionResponse
+	}{
+		{
+			name: "differing oldest keys",
+			req:  &pb.RetentionRequest{RegionIdentifiers: []string{"us", "CA", "US-WA", "MX"}},
+			want: &pb.RetentionResponse{Regions: []*pb.RegionRetention{
+				{RegionIdentifier: "CA", OldestTimestamp: 1000, LatestTimestamp: 4000},
+				{RegionIdentifier: "MX"},
+				{RegionIdentifier: "US", OldestTimestamp: 1000, LatestTimestamp: 5000},
+				{RegionIdentifier: "US-WA", OldestTimestamp: 3000, LatestTimestamp: 3000},
+			}},
+		},
+		{
+			name: "wildcard",
+			req:  &pb.RetentionRequest{RegionIdentifiers: []string{"US-*"}},
+			want: &pb.RetentionResponse{Regions: []*pb.RegionRetention{
+				{RegionIdentifier: "US-*", OldestTimestamp: 2000, LatestTimestamp: 3000},
+			}},
+		},
+		{
+			name: "authorized regions only",
+			auth: &fedmodel.FederationOutAuthorization{IncludeRegions: []string{"US-*", "CA"}, ExcludeRegions: []string{"CA"}},
+			req:  &pb.RetentionRequest{RegionIdentifiers: []string{"US-WA", "CA", "MX"}},
+			want: &pb.RetentionResponse{Regions: []*pb.RegionRetention{
+				{RegionIdentifier: "US-WA", OldestTimestamp: 3000, LatestTimestamp: 3000},
+			}},
+		},
+		{
+			name: "other namespace",
+			auth: &fedmodel.FederationOutAuthorization{IncludeRegions: []string{"US"}, Namespace: "tenant"},
+			req:  &pb.RetentionRequest{RegionIdentifiers: []string{"US"}},
+			want: &pb.RetentionResponse{Regions: []*pb.RegionRetention{
+				{RegionIdentifier: "US"},
+			}},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.auth != nil {
+				ctx = context.WithValue(ctx, authKey{}, tc.auth)
+			}
+			server := Server{env: serverenv.New(ctx), config: &Config{}}
+			got, err := server.retention(ctx, tc.req, retentionDeps(exposures), now)
+			if err != nil {
+				t.Fatalf("retention() returned err=%v, want err=nil", err)
+			}
+			if diff := cmp.Diff(tc.want, got, protocmp.Transform()); diff != "" {
+				t.Errorf("retention() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRetentionInvalid(t *testing.T) {
+	ctx := context.Background()
+	server := Server{env: serverenv.New(ctx), config: &Config{}}
+
+	_, err := server.retention(ctx, &pb.RetentionRequest{}, retentionDeps(nil), time.Now())
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("retention() with no regions returned err=%v, want InvalidArgument", err)
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federationout
+
+import (
+	"context"
+	"encoding/hex"
+	"math/rand"
+
+	"github.com/google/exposure-notifications-server/internal/logging"
+	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
+)
+
+// skippedKeyPrefixBytes is how much of a skipped key is logged, enough to tell keys apart in the
+// logs without disclosing them.
+const skippedKeyPrefixBytes = 4
+
+// skipSampler logs a sample of the keys skipped by fetch.
+type skipSampler struct {
+	rate   float64
+	random func() float64
+}
+
+// newSkipSampler creates a sampler logging the given fraction of skipped keys. If rate is not
+// positive, no keys are logged and nil is returned.
+func newSkipSampler(rate float64) *skipSampler {
+	if rate <= 0 {
+		return nil
+	}
+	return &skipSampler{rate: rate, random: rand.Float64}
+}
+
+// skipped records that fetch skipped inf for the given reason, logging its details if it is sampled.
+func (s *skipSampler) skipped(ctx context.Context, inf *publishmodel.Exposure, reason string) {
+	if s == nil || s.random() >= s.rate {
+		return
+	}
+	prefix := inf.ExposureKey
+	if len(prefix) > skippedKeyPrefixBytes {
+		prefix = prefix[:skippedKeyPrefixBytes]
+	}
+	logging.FromContext(ctx).Infof("Sampled skipped key %s…: reason=%q regions=%v transmissionRisk=%d intervalNumber=%d intervalCount=%d createdAt=%v localProvenance=%v namespace=%q",
+		hex.EncodeToString(prefix), reason, inf.Regions, inf.TransmissionRisk, inf.IntervalNumber, inf.IntervalCount, inf.CreatedAt.UTC(), inf.LocalProvenance, inf.Namespace)
+}
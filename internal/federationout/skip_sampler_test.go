@@ -0,0 +1,75 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federationout
+
+import (
+	"context"
+	"encoding/hex"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/pb"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// TestFetchSkippedKeySampling skips many keys in a fetch, checking that about the configured
+// fraction of them is logged, with the key redacted.
+func TestFetchSkippedKeySampling(t *testing.T) {
+	const (
+		keys = 10000
+		rate = 0.05
+	)
+	core, logs := observer.New(zap.InfoLevel)
+	ctx := logging.WithLogger(context.Background(), zap.New(core).Sugar())
+	sampler := newSkipSampler(rate)
+	sampler.random = rand.New(rand.NewSource(1)).Float64
+	server := Server{env: serverenv.New(ctx), config: &Config{}, skips: sampler}
+
+	key := []byte("0123456789abcdef")
+	var elements []interface{}
+	for i := 0; i < keys; i++ {
+		elements = append(elements, makeExposure(&pb.ExposureKey{ExposureKey: key, IntervalNumber: 1}, 1)) // No regions, so skipped.
+	}
+	if _, err := server.fetch(ctx, &pb.FederationFetchRequest{}, testDeps(elements), time.Now()); err != nil {
+		t.Fatalf("fetch() returned err=%v, want err=nil", err)
+	}
+
+	sampled := logs.FilterMessageSnippet("Sampled skipped key")
+	if got, want := sampled.Len(), keys*rate; float64(got) < want*0.8 || float64(got) > want*1.2 {
+		t.Errorf("logged %d skipped keys, want about %v", got, want)
+	}
+	for _, entry := range sampled.All() {
+		if strings.Contains(entry.Message, hex.EncodeToString(key)) {
+			t.Fatalf("logged the full key: %s", entry.Message)
+		}
+		if !strings.Contains(entry.Message, `reason="missing regions"`) {
+			t.Fatalf("logged %q, want the reason the key was skipped", entry.Message)
+		}
+	}
+}
+
+func TestSkipSamplerDisabled(t *testing.T) {
+	s := newSkipSampler(0)
+	if s != nil {
+		t.Fatalf("newSkipSampler(0) = %v, want nil", s)
+	}
+	// A nil sampler logs nothing.
+	s.skipped(context.Background(), makeExposure(aaa, 1, "US"), "test")
+}
@@ -0,0 +1,119 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federationout
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/exposure-notifications-server/internal/base64util"
+	"github.com/google/exposure-notifications-server/internal/pb"
+)
+
+// errSyncTokenScope is returned when a syncToken is sent with other regions than it was issued for.
+var errSyncTokenScope = errors.New("syncToken was issued for other regions")
+
+// syncPosition is the client position carried by a syncToken.
+type syncPosition struct {
+	// Since is the lastFetchResponseKeyTimestamp the fetch resumes from.
+	Since int64 `json:"s"`
+
+	// Cursor, if set, is the database cursor of a partial response being paged through, and Watermark
+	// the latest fetchResponseKeyTimestamp of its pages so far. Since becomes Watermark once the last
+	// page is served.
+	Cursor    string `json:"c,omitempty"`
+	Watermark int64  `json:"w,omitempty"`
+
+	// Scope binds the token to the regions it was issued for, see syncScope.
+	Scope string `json:"r"`
+
+	// Generation binds the token to the key database it was issued from, see WithGeneration.
+	Generation string `json:"g,omitempty"`
+}
+
+// syncScope identifies the included and excluded regions of a fetch, after authorization.
+func syncScope(regions, excludeRegions []string) string {
+	include := normalizeRegions(append([]string(nil), regions...))
+	exclude := normalizeRegions(append([]string(nil), excludeRegions...))
+	sum := sha256.Sum256([]byte(strings.Join(include, ",") + "|" + strings.Join(exclude, ",")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// encodeSyncToken turns a position into a syncToken. If cursor encryption is enabled, it is sealed
+// like a nextFetchToken, so that the client can neither read nor forge it.
+func (s Server) encodeSyncToken(pos *syncPosition, namespace string) (string, error) {
+	stamped := *pos
+	stamped.Generation = s.generation
+	b, err := json.Marshal(&stamped)
+	if err != nil {
+		return "", fmt.Errorf("marshalling sync position: %w", err)
+	}
+	if s.cursors != nil {
+		return s.cursors.seal(string(b), namespace)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// decodeSyncToken returns the position in a syncToken issued by encodeSyncToken to a client in
+// namespace fetching scope.
+func (s Server) decodeSyncToken(token, namespace, scope string) (*syncPosition, error) {
+	var b []byte
+	if s.cursors != nil {
+		plain, err := s.cursors.open(token, namespace)
+		if err != nil {
+			return nil, err
+		}
+		b = []byte(plain)
+	} else {
+		var err error
+		if b, err = base64util.DecodeString(token); err != nil {
+			return nil, fmt.Errorf("decoding syncToken: %w", err)
+		}
+	}
+	var pos syncPosition
+	if err := json.Unmarshal(b, &pos); err != nil {
+		return nil, fmt.Errorf("unmarshalling syncToken: %w", err)
+	}
+	if pos.Scope != scope {
+		return nil, errSyncTokenScope
+	}
+	if s.generation != "" && pos.Generation != "" && pos.Generation != s.generation {
+		return nil, errStaleGeneration
+	}
+	return &pos, nil
+}
+
+// nextSyncPosition returns the position a client reaches with response, having fetched from pos.
+// A partial response keeps the position and advances the cursor, accumulating the watermark across
+// pages; a complete one moves the position to the latest key served.
+func nextSyncPosition(pos *syncPosition, response *pb.FederationFetchResponse, cursor string) *syncPosition {
+	watermark := pos.Watermark
+	if response.FetchResponseKeyTimestamp > watermark {
+		watermark = response.FetchResponseKeyTimestamp
+	}
+	if response.PartialResponse {
+		return &syncPosition{Since: pos.Since, Cursor: cursor, Watermark: watermark, Scope: pos.Scope}
+	}
+	since := pos.Since
+	if watermark > since {
+		since = watermark
+	}
+	return &syncPosition{Since: since, Scope: pos.Scope}
+}
@@ -0,0 +1,173 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federationout
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/pb"
+	"github.com/google/exposure-notifications-server/internal/publish/database"
+	"github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// pagedDeps returns fetchDependencies over *elements which, like the database, filter by timestamp
+// and resume from a cursor, which names the key to resume at.
+func pagedDeps(elements *[]*model.Exposure) fetchDependencies {
+	deps := testDeps(nil)
+	deps.iterateExposures = func(_ context.Context, criteria database.IterateExposuresCriteria, f func(*model.Exposure) error) (string, error) {
+		resume := strings.TrimSuffix(criteria.LastCursor, "_cursor")
+		for _, exp := range *elements {
+			if resume != "" {
+				if string(exp.ExposureKey) != resume {
+					continue
+				}
+				resume = ""
+			}
+			if exp.CreatedAt.Before(criteria.SinceTimestamp) || !exp.CreatedAt.Before(criteria.UntilTimestamp) {
+				continue
+			}
+			if err := f(exp); err != nil {
+				return string(exp.ExposureKey) + "_cursor", err
+			}
+		}
+		return "", nil
+	}
+	deps.latestCreatedAt = func(context.Context, []string) (time.Time, error) {
+		var latest time.Time
+		for _, exp := range *elements {
+			if exp.CreatedAt.After(latest) {
+				latest = exp.CreatedAt
+			}
+		}
+		return latest, nil
+	}
+	return deps
+}
+
+// servedKeys returns the keys in a response, sorted.
+func servedKeys(response *pb.FederationFetchResponse) []string {
+	var keys []string
+	for _, ctr := range response.Response {
+		for _, cti := range ctr.ContactTracingInfo {
+			for _, key := range cti.ExposureKeys {
+				keys = append(keys, string(key.ExposureKey))
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// TestFetchSyncToken pages and polls using only syncTokens, checking that every key is served.
+func TestFetchSyncToken(t *testing.T) {
+	for _, cursors := range []*cursorCodec{nil, mustCursorCodec(t, cursorKey1, "")} {
+		ctx := context.Background()
+		// A limit of one region group per response forces a page per group.
+		server := Server{env: serverenv.New(ctx), config: &Config{MaxResponseGroups: 1}, cursors: cursors}
+		fetchUntil := time.Unix(1000, 0)
+
+		elements := []*model.Exposure{makeExposure(aaa, 1, "US"), makeExposure(bbb, 1, "CA"), makeExposure(ccc, 1, "MX")}
+		deps := pagedDeps(&elements)
+
+		req := &pb.FederationFetchRequest{RequestSyncToken: true}
+		fetch := func(name string, wantPartial bool, wantKeys ...string) {
+			t.Helper()
+			got, err := server.fetch(ctx, req, deps, fetchUntil)
+			if err != nil {
+				t.Fatalf("%s: fetch() returned err=%v, want err=nil", name, err)
+			}
+			if keys := servedKeys(got); strings.Join(keys, ",") != strings.Join(wantKeys, ",") {
+				t.Errorf("%s: served %v, want %v", name, keys, wantKeys)
+			}
+			if got.PartialResponse != wantPartial {
+				t.Errorf("%s: partialResponse=%v, want %v", name, got.PartialResponse, wantPartial)
+			}
+			if got.SyncToken == "" {
+				t.Fatalf("%s: no syncToken in response", name)
+			}
+			if cursors != nil && strings.Contains(got.SyncToken, "cursor") {
+				t.Errorf("%s: sealed syncToken %q is readable", name, got.SyncToken)
+			}
+			req = &pb.FederationFetchRequest{SyncToken: got.SyncToken}
+		}
+
+		fetch("first page", true, "aaa")
+		fetch("second page", true, "bbb")
+		fetch("last page", false, "ccc")
+		fetch("caught up", false)
+
+		// Keys at the client's position are served again, as with lastFetchResponseKeyTimestamp.
+		elements = append(elements, makeExposure(ddd, 1, "MX"))
+		fetch("new key", false, "ccc", "ddd")
+		fetch("caught up again", false)
+	}
+}
+
+// TestFetchSyncTokenInvalid tests the requests a syncToken is rejected on.
+func TestFetchSyncTokenInvalid(t *testing.T) {
+	ctx := context.Background()
+	server := Server{env: serverenv.New(ctx), config: &Config{}, cursors: mustCursorCodec(t, cursorKey1, "")}
+	fetchUntil := time.Unix(1000, 0)
+	elements := []*model.Exposure{makeExposure(aaa, 1, "US")}
+	deps := pagedDeps(&elements)
+
+	got, err := server.fetch(ctx, &pb.FederationFetchRequest{RegionIdentifiers: []string{"US"}, RequestSyncToken: true}, deps, fetchUntil)
+	if err != nil {
+		t.Fatalf("fetch() returned err=%v, want err=nil", err)
+	}
+	token := got.SyncToken
+
+	testCases := []struct {
+		name string
+		req  *pb.FederationFetchRequest
+	}{
+		{
+			name: "other regions",
+			req:  &pb.FederationFetchRequest{RegionIdentifiers: []string{"US", "CA"}, SyncToken: token},
+		},
+		{
+			name: "with lastFetchResponseKeyTimestamp",
+			req:  &pb.FederationFetchRequest{RegionIdentifiers: []string{"US"}, SyncToken: token, LastFetchResponseKeyTimestamp: 100},
+		},
+		{
+			name: "with nextFetchToken",
+			req:  &pb.FederationFetchRequest{RegionIdentifiers: []string{"US"}, SyncToken: token, NextFetchToken: token},
+		},
+		{
+			name: "forged",
+			req:  &pb.FederationFetchRequest{RegionIdentifiers: []string{"US"}, SyncToken: "eyJzIjowfQ"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := server.fetch(ctx, tc.req, deps, fetchUntil)
+			if status.Code(err) != codes.InvalidArgument {
+				t.Errorf("fetch() returned err=%v, want %v", err, codes.InvalidArgument)
+			}
+		})
+	}
+
+	// Region order and case do not matter.
+	if _, err := server.fetch(ctx, &pb.FederationFetchRequest{RegionIdentifiers: []string{"us"}, SyncToken: token}, deps, fetchUntil); err != nil {
+		t.Errorf("fetch() with the same regions returned err=%v, want err=nil", err)
+	}
+}
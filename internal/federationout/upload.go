@@ -0,0 +1,233 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federationout
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/federationin/model"
+	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/pb"
+	publishdb "github.com/google/exposure-notifications-server/internal/publish/database"
+	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
+	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1alpha1"
+
+	"go.opencensus.io/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type insertExposuresFunc func(context.Context, []*publishmodel.Exposure) (int, error)
+type recvUploadFunc func() (*pb.FederationUploadRequest, error)
+
+// Upload implements the FederationServer Upload endpoint.
+func (s Server) Upload(stream pb.Federation_UploadServer) error {
+	// The stream's context carries the sender's trace, see StatsHandler, so this span joins it.
+	ctx, span := trace.StartSpan(stream.Context(), "federationout.Upload")
+	defer span.End()
+	logger := logging.FromContext(ctx)
+	metrics := s.env.MetricsExporter(ctx)
+
+	if !s.config.AllowUploads {
+		return status.Error(codes.PermissionDenied, "uploads are not enabled")
+	}
+
+	insert := func(ctx context.Context, exposures []*publishmodel.Exposure) (int, error) {
+		return s.publishdb.InsertExposuresLimited(ctx, exposures, &s.config.RegionKeyLimits)
+	}
+	response, err := s.upload(ctx, stream.Recv, insert, time.Now())
+	if err != nil {
+		metrics.WriteInt("federation-upload-failed", true, 1)
+		logger.Errorf("Upload error: %v", err)
+		span.SetStatus(trace.Status{Code: trace.StatusCodeInternal, Message: err.Error()})
+		return fetchStatus(err)
+	}
+	span.AddAttributes(
+		trace.Int64Attribute("accepted", response.Accepted),
+		trace.Int64Attribute("rejected", response.Rejected),
+		trace.Int64Attribute("duplicate", response.Duplicate))
+	return stream.SendAndClose(response)
+}
+
+// upload reads keys from recv until the client closes the stream, storing the valid ones in batches.
+// Keys are validated as on publish, and must be in regions the client is authorized for.
+func (s Server) upload(ctx context.Context, recv recvUploadFunc, insertExposures insertExposuresFunc, now time.Time) (*pb.FederationUploadResponse, error) {
+	logger := logging.FromContext(ctx)
+	metrics := s.env.MetricsExporter(ctx)
+
+	// Writing is authorized separately from fetching, and only into regions the authorization names. A
+	// client admitted without an authorization, e.g. under AllowAnyClient, cannot upload at all.
+	auth, ok := ctx.Value(authKey{}).(*model.FederationOutAuthorization)
+	if !ok {
+		metrics.WriteInt("federation-upload-denied", true, 1)
+		return nil, status.Error(codes.PermissionDenied, "uploads require an authenticated client")
+	}
+	if !auth.AllowUpload {
+		metrics.WriteInt("federation-upload-denied", true, 1)
+		return nil, status.Error(codes.PermissionDenied, "client is not authorized to upload")
+	}
+	if len(auth.IncludeRegions) == 0 {
+		metrics.WriteInt("federation-upload-denied", true, 1)
+		return nil, status.Error(codes.PermissionDenied, "client's authorization includes no regions to upload to")
+	}
+	namespace := auth.Namespace
+	includedRegions := newRegionMatcher(auth.IncludeRegions)
+	excludedRegions := newRegionMatcher(auth.ExcludeRegions)
+
+	createdAt := publishmodel.TruncateWindow(now, s.config.TruncateWindow)
+	minIntervalNumber := publishmodel.IntervalNumber(now.Add(-s.config.UploadMaxIntervalAge))
+	maxIntervalNumber := publishmodel.IntervalNumber(now)
+
+	response := &pb.FederationUploadResponse{}
+	stamped := 0
+	seen := make(map[string]struct{})
+	var batch []*publishmodel.Exposure
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		inserted, err := insertExposures(ctx, batch)
+		if err != nil {
+			return fmt.Errorf("inserting %d exposures: %w", len(batch), err)
+		}
+		response.Accepted += int64(inserted)
+		response.Duplicate += int64(len(batch) - inserted)
+		batch = nil
+		return nil
+	}
+
+	for {
+		req, err := recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("receiving upload: %w", err)
+		}
+
+		if max := s.config.MaxRegionsPerKey; max > 0 && len(req.RegionIdentifiers) > max {
+			logger.Debugf("Rejecting uploaded key with %d regions, at most %d are allowed", len(req.RegionIdentifiers), max)
+			metrics.WriteInt("federation-upload-too-many-key-regions", true, 1)
+			response.Rejected++
+			continue
+		}
+		// Some partners send keys valid for longer than a day, which clients reject.
+		if max := s.config.MaxIntervalCount; max > 0 && req.ExposureKey != nil && req.ExposureKey.IntervalCount > max {
+			logger.Infof("key has IntervalCount %d - clamping to %d.", req.ExposureKey.IntervalCount, max)
+			metrics.WriteInt("federation-upload-interval-count-clamped", true, 1)
+			req.ExposureKey.IntervalCount = max
+		}
+		exposure, err := uploadedExposure(req, includedRegions, excludedRegions, createdAt, minIntervalNumber, maxIntervalNumber)
+		if err != nil {
+			logger.Debugf("Rejecting uploaded key: %v", err)
+			response.Rejected++
+			continue
+		}
+		if s.keyBlocklist.Contains(exposure.ExposureKey) {
+			logger.Debugf("Rejecting blocklisted key %x", exposure.ExposureKey)
+			metrics.WriteInt("federation-upload-blocklisted", true, 1)
+			response.Rejected++
+			continue
+		}
+		exposure.Namespace = namespace
+		if createdAt, ok := s.uploadCreatedAt(req, exposure, now); ok {
+			exposure.CreatedAt = createdAt
+		} else if req.CreatedTimestamp != 0 {
+			stamped++
+		}
+
+		key := string(exposure.ExposureKey)
+		if _, ok := seen[key]; ok {
+			response.Duplicate++
+			continue
+		}
+		seen[key] = struct{}{}
+
+		batch = append(batch, exposure)
+		if len(batch) == publishdb.InsertExposuresBatchSize {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+
+	metrics.WriteInt64("federation-upload-accepted", true, response.Accepted)
+	metrics.WriteInt64("federation-upload-rejected", true, response.Rejected)
+	metrics.WriteInt64("federation-upload-duplicate", true, response.Duplicate)
+	if stamped > 0 {
+		metrics.WriteInt("federation-upload-created-at-stamped", true, stamped)
+		logger.Warnf("Ignored createdTimestamp of %d uploaded keys", stamped)
+	}
+	logger.Infof("Upload complete, accepted %d, rejected %d, duplicate %d", response.Accepted, response.Rejected, response.Duplicate)
+	return response, nil
+}
+
+// uploadCreatedAt returns the source server's CreatedAt for an uploaded key, truncated like a local
+// one. It returns false if it is not set, not enabled, or outside the key's interval start and now.
+func (s Server) uploadCreatedAt(req *pb.FederationUploadRequest, exposure *publishmodel.Exposure, now time.Time) (time.Time, bool) {
+	if !s.config.UploadPreserveCreatedAt || req.CreatedTimestamp == 0 {
+		return time.Time{}, false
+	}
+	createdAt := time.Unix(req.CreatedTimestamp, 0).UTC()
+	intervalStart := time.Unix(int64(exposure.IntervalNumber)*int64(verifyapi.IntervalLength.Seconds()), 0)
+	if createdAt.Before(intervalStart) || createdAt.After(now) {
+		return time.Time{}, false
+	}
+	return publishmodel.TruncateWindow(createdAt, s.config.TruncateWindow), true
+}
+
+// uploadedExposure validates an uploaded key and converts it to an Exposure.
+func uploadedExposure(req *pb.FederationUploadRequest, includedRegions, excludedRegions *regionMatcher, createdAt time.Time, minIntervalNumber, maxIntervalNumber int32) (*publishmodel.Exposure, error) {
+	if req.ExposureKey == nil {
+		return nil, errors.New("missing exposureKey")
+	}
+	if len(req.RegionIdentifiers) == 0 {
+		return nil, errors.New("missing regionIdentifiers")
+	}
+	regions := make([]string, 0, len(req.RegionIdentifiers))
+	for _, region := range req.RegionIdentifiers {
+		region = strings.ToUpper(strings.TrimSpace(region))
+		if !includedRegions.empty() && !includedRegions.matches(region) {
+			return nil, fmt.Errorf("region %v is not authorized", region)
+		}
+		if excludedRegions.matches(region) {
+			return nil, fmt.Errorf("region %v is excluded", region)
+		}
+		regions = append(regions, region)
+	}
+
+	key := verifyapi.ExposureKey{
+		Key:              base64.StdEncoding.EncodeToString(req.ExposureKey.ExposureKey),
+		IntervalNumber:   req.ExposureKey.IntervalNumber,
+		IntervalCount:    req.ExposureKey.IntervalCount,
+		TransmissionRisk: int(req.TransmissionRisk),
+	}
+	exposure, err := publishmodel.TransformExposureKey(key, "", normalizeRegions(regions), createdAt, minIntervalNumber, maxIntervalNumber)
+	if err != nil {
+		return nil, err
+	}
+	// Uploaded keys came from a partner, so they are not re-federated.
+	exposure.LocalProvenance = false
+	return exposure, nil
+}
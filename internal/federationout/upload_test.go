@@ -0,0 +1,467 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federationout
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	fedmodel "github.com/google/exposure-notifications-server/internal/federationin/model"
+	"github.com/google/exposure-notifications-server/internal/pb"
+	"github.com/google/exposure-notifications-server/internal/publish/database"
+	"github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"github.com/google/go-cmp/cmp"
+	"go.opencensus.io/plugin/ocgrpc"
+	"go.opencensus.io/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/testing/protocmp"
+)
+
+// uploaderContext returns a context authorized to upload into regions.
+func uploaderContext(regions ...string) context.Context {
+	auth := &fedmodel.FederationOutAuthorization{IncludeRegions: regions, AllowUpload: true}
+	return context.WithValue(context.Background(), authKey{}, auth)
+}
+
+// TestUpload streams a few hundred keys, some invalid or duplicated, and checks what is stored.
+func TestUpload(t *testing.T) {
+	auth := &fedmodel.FederationOutAuthorization{IncludeRegions: []string{"US", "CA"}, Namespace: "tenant-a", AllowUpload: true}
+	ctx := context.WithValue(context.Background(), authKey{}, auth)
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	interval := model.IntervalNumber(now.Add(-48 * time.Hour))
+
+	var stream []*pb.FederationUploadRequest
+	upload := func(i int, regions ...string) *pb.FederationUploadRequest {
+		req := &pb.FederationUploadRequest{
+			RegionIdentifiers: regions,
+			TransmissionRisk:  2,
+			ExposureKey: &pb.ExposureKey{
+				ExposureKey:    []byte(fmt.Sprintf("%016d", i)),
+				IntervalNumber: interval,
+				IntervalCount:  144,
+			},
+		}
+		stream = append(stream, req)
+		return req
+	}
+	for i := 0; i < 600; i++ {
+		switch {
+		case i%50 == 0:
+			upload(i, "US").ExposureKey.ExposureKey = []byte("short")
+		case i%50 == 1:
+			upload(i, "MX") // Not authorized.
+		case i%50 == 2:
+			upload(i, "us").TransmissionRisk = 99
+		default:
+			upload(i, "us", "CA")
+		}
+	}
+	// Resend keys within the stream; two of them were also stored before the upload.
+	upload(3, "US")
+	upload(4, "US")
+	upload(5, "US")
+	stored := map[string]bool{fmt.Sprintf("%016d", 4): true, fmt.Sprintf("%016d", 5): true}
+
+	var batches [][]*model.Exposure
+	insert := func(_ context.Context, exposures []*model.Exposure) (int, error) {
+		batches = append(batches, exposures)
+		inserted := 0
+		for _, e := range exposures {
+			if !stored[string(e.ExposureKey)] {
+				stored[string(e.ExposureKey)] = true
+				inserted++
+			}
+		}
+		return inserted, nil
+	}
+	next := 0
+	recv := func() (*pb.FederationUploadRequest, error) {
+		if next == len(stream) {
+			return nil, io.EOF
+		}
+		next++
+		return stream[next-1], nil
+	}
+
+	server := Server{env: serverenv.New(ctx), config: &Config{TruncateWindow: time.Hour, UploadMaxIntervalAge: 14 * 24 * time.Hour}}
+	got, err := server.upload(ctx, recv, insert, now)
+	if err != nil {
+		t.Fatalf("upload() returned err=%v, want err=nil", err)
+	}
+
+	// 12 of each invalid kind; 3 keys are repeated in the stream and 2 were already stored.
+	want := &pb.FederationUploadResponse{Accepted: 600 - 36 - 2, Rejected: 36, Duplicate: 3 + 2}
+	if diff := cmp.Diff(want, got, protocmp.Transform()); diff != "" {
+		t.Errorf("summary mismatch (-want +got):\n%s", diff)
+	}
+
+	total := 0
+	for _, batch := range batches {
+		if len(batch) > database.InsertExposuresBatchSize {
+			t.Errorf("batch of %d exposures, want at most %d", len(batch), database.InsertExposuresBatchSize)
+		}
+		total += len(batch)
+	}
+	if total != 600-36 {
+		t.Errorf("inserted %d exposures, want %d", total, 600-36)
+	}
+
+	e := batches[0][0]
+	if e.LocalProvenance || e.Namespace != "tenant-a" || !e.CreatedAt.Equal(now.Truncate(time.Hour)) {
+		t.Errorf("got LocalProvenance=%v Namespace=%q CreatedAt=%v, want false, tenant-a, %v", e.LocalProvenance, e.Namespace, e.CreatedAt, now.Truncate(time.Hour))
+	}
+	if diff := cmp.Diff([]string{"CA", "US"}, e.Regions); diff != "" {
+		t.Errorf("regions mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestUploadCreatedAt(t *testing.T) {
+	ctx := uploaderContext("US")
+	now := time.Date(2020, 6, 1, 12, 30, 0, 0, time.UTC)
+	intervalStart := now.Add(-48 * time.Hour).Truncate(24 * time.Hour)
+	received := now.Truncate(time.Hour)
+
+	testCases := []struct {
+		name     string
+		preserve bool
+		created  time.Time
+		want     time.Time
+	}{
+		{
+			name:     "preserved",
+			preserve: true,
+			created:  intervalStart.Add(26*time.Hour + 20*time.Minute),
+			want:     intervalStart.Add(26 * time.Hour),
+		},
+		{
+			name:     "not set",
+			preserve: true,
+			want:     received,
+		},
+		{
+			name:     "not enabled",
+			preserve: false,
+			created:  intervalStart.Add(26 * time.Hour),
+			want:     received,
+		},
+		{
+			name:     "before interval",
+			preserve: true,
+			created:  intervalStart.Add(-time.Minute),
+			want:     received,
+		},
+		{
+			name:     "in the future",
+			preserve: true,
+			created:  now.Add(time.Minute),
+			want:     received,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := &pb.FederationUploadRequest{
+				RegionIdentifiers: []string{"US"},
+				ExposureKey: &pb.ExposureKey{
+					ExposureKey:    []byte("0123456789abcdef"),
+					IntervalNumber: model.IntervalNumber(intervalStart),
+					IntervalCount:  144,
+				},
+			}
+			if !tc.created.IsZero() {
+				req.CreatedTimestamp = tc.created.Unix()
+			}
+			sent := false
+			recv := func() (*pb.FederationUploadRequest, error) {
+				if sent {
+					return nil, io.EOF
+				}
+				sent = true
+				return req, nil
+			}
+			var got []*model.Exposure
+			insert := func(_ context.Context, exposures []*model.Exposure) (int, error) {
+				got = append(got, exposures...)
+				return len(exposures), nil
+			}
+
+			config := &Config{TruncateWindow: time.Hour, UploadMaxIntervalAge: 14 * 24 * time.Hour, UploadPreserveCreatedAt: tc.preserve}
+			server := Server{env: serverenv.New(ctx), config: config}
+			if _, err := server.upload(ctx, recv, insert, now); err != nil {
+				t.Fatalf("upload() returned err=%v, want err=nil", err)
+			}
+			if len(got) != 1 {
+				t.Fatalf("inserted %d exposures, want 1", len(got))
+			}
+			if !got[0].CreatedAt.Equal(tc.want) {
+				t.Errorf("CreatedAt=%v, want %v", got[0].CreatedAt, tc.want)
+			}
+		})
+	}
+}
+
+// TestUploadRegionKeyLimit checks that an upload over a region's daily limit fails with ResourceExhausted.
+func TestUploadRegionKeyLimit(t *testing.T) {
+	ctx := uploaderContext("US")
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	req := &pb.FederationUploadRequest{
+		RegionIdentifiers: []string{"US"},
+		ExposureKey: &pb.ExposureKey{
+			ExposureKey:    []byte("0123456789abcdef"),
+			IntervalNumber: model.IntervalNumber(now.Add(-48 * time.Hour)),
+			IntervalCount:  144,
+		},
+	}
+	sent := false
+	recv := func() (*pb.FederationUploadRequest, error) {
+		if sent {
+			return nil, io.EOF
+		}
+		sent = true
+		return req, nil
+	}
+	insert := func(context.Context, []*model.Exposure) (int, error) {
+		return 0, fmt.Errorf("%w: region US has 101 keys on 2020-06-01, limit is 100", database.ErrRegionKeyLimit)
+	}
+
+	server := Server{env: serverenv.New(ctx), config: &Config{TruncateWindow: time.Hour, UploadMaxIntervalAge: 14 * 24 * time.Hour}}
+	_, err := server.upload(ctx, recv, insert, now)
+	if got := status.Code(fetchStatus(err)); got != codes.ResourceExhausted {
+		t.Errorf("upload() returned err=%v with code %v, want %v", err, got, codes.ResourceExhausted)
+	}
+}
+
+// TestUploadTooManyRegions tests that keys with more than MaxRegionsPerKey regions are rejected.
+func TestUploadTooManyRegions(t *testing.T) {
+	ctx := uploaderContext("US", "CA", "MX", "GB")
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	var stream []*pb.FederationUploadRequest
+	for i, regions := range [][]string{{"US", "CA"}, {"US", "CA", "MX"}, {"US", "CA", "MX", "GB"}} {
+		stream = append(stream, &pb.FederationUploadRequest{
+			RegionIdentifiers: regions,
+			ExposureKey: &pb.ExposureKey{
+				ExposureKey:    []byte(fmt.Sprintf("%016d", i)),
+				IntervalNumber: model.IntervalNumber(now.Add(-48 * time.Hour)),
+				IntervalCount:  144,
+			},
+		})
+	}
+	recv := func() (*pb.FederationUploadRequest, error) {
+		if len(stream) == 0 {
+			return nil, io.EOF
+		}
+		req := stream[0]
+		stream = stream[1:]
+		return req, nil
+	}
+	insert := func(_ context.Context, exposures []*model.Exposure) (int, error) {
+		return len(exposures), nil
+	}
+
+	server := Server{env: serverenv.New(ctx), config: &Config{TruncateWindow: time.Hour, UploadMaxIntervalAge: 14 * 24 * time.Hour, MaxRegionsPerKey: 3}}
+	got, err := server.upload(ctx, recv, insert, now)
+	if err != nil {
+		t.Fatalf("upload() returned err=%v, want err=nil", err)
+	}
+	want := &pb.FederationUploadResponse{Accepted: 2, Rejected: 1}
+	if diff := cmp.Diff(want, got, protocmp.Transform()); diff != "" {
+		t.Errorf("summary mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// spanRecorder is a trace.Exporter which keeps the spans it is sent.
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []*trace.SpanData
+}
+
+func (r *spanRecorder) ExportSpan(s *trace.SpanData) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, s)
+}
+
+// TestUploadTraceContext uploads over gRPC within a sender's span, and checks that the receiver's
+// span continues the sender's trace.
+func TestUploadTraceContext(t *testing.T) {
+	recorder := &spanRecorder{}
+	trace.RegisterExporter(recorder)
+	defer trace.UnregisterExporter(recorder)
+
+	ctx := context.Background()
+	server := &Server{env: serverenv.New(ctx), config: &Config{AllowUploads: true}}
+	listener := bufconn.Listen(1 << 20)
+	// Stand in for StreamAuthInterceptor, authorizing the partner to upload.
+	authorize := func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &authorizedStream{ServerStream: ss, ctx: context.WithValue(ss.Context(), authKey{}, &fedmodel.FederationOutAuthorization{IncludeRegions: []string{"US"}, AllowUpload: true})})
+	}
+	grpcServer := grpc.NewServer(grpc.StatsHandler(StatsHandler()), grpc.StreamInterceptor(authorize))
+	pb.RegisterFederationServer(grpcServer, server)
+	go grpcServer.Serve(listener)
+	defer grpcServer.Stop()
+
+	conn, err := grpc.DialContext(ctx, "bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+		grpc.WithInsecure(),
+		grpc.WithStatsHandler(&ocgrpc.ClientHandler{StartOptions: trace.StartOptions{Sampler: trace.AlwaysSample()}}))
+	if err != nil {
+		t.Fatalf("grpc.DialContext: %v", err)
+	}
+	defer conn.Close()
+
+	sendCtx, send := trace.StartSpan(ctx, "partner.Send", trace.WithSampler(trace.AlwaysSample()))
+	stream, err := pb.NewFederationClient(conn).Upload(sendCtx)
+	if err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if _, err := stream.CloseAndRecv(); err != nil {
+		t.Fatalf("CloseAndRecv: %v", err)
+	}
+	send.End()
+	grpcServer.GracefulStop()
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	byID := make(map[trace.SpanID]*trace.SpanData)
+	var upload *trace.SpanData
+	for _, s := range recorder.spans {
+		byID[s.SpanID] = s
+		if s.Name == "federationout.Upload" {
+			upload = s
+		}
+	}
+	if upload == nil {
+		t.Fatalf("no federationout.Upload span among %d spans", len(recorder.spans))
+	}
+
+	sender := send.SpanContext()
+	if upload.TraceID != sender.TraceID {
+		t.Errorf("Upload span in trace %v, want the sender's trace %v", upload.TraceID, sender.TraceID)
+	}
+	// Walk up from the receiver's span: it must reach the sender's span, crossing from the
+	// receiver to the sender exactly once.
+	remote := 0
+	for s := upload; s.SpanID != sender.SpanID; {
+		if len(s.Links) > 0 {
+			t.Errorf("span %s links to %v, want it to continue the trace", s.Name, s.Links)
+		}
+		if s.HasRemoteParent {
+			remote++
+		}
+		parent, ok := byID[s.ParentSpanID]
+		if !ok {
+			t.Fatalf("span %s has parent %v, which is not in the sender's trace", s.Name, s.ParentSpanID)
+		}
+		s = parent
+	}
+	if remote != 1 {
+		t.Errorf("found %d remote parents between the receiver and sender spans, want 1", remote)
+	}
+}
+
+// TestUploadPermission checks that only clients authorized to upload, into named regions, may upload.
+func TestUploadPermission(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		name string
+		auth *fedmodel.FederationOutAuthorization
+		want codes.Code
+	}{
+		{name: "no authorization", want: codes.PermissionDenied},
+		{name: "fetch only", auth: &fedmodel.FederationOutAuthorization{IncludeRegions: []string{"US"}}, want: codes.PermissionDenied},
+		{name: "no regions", auth: &fedmodel.FederationOutAuthorization{AllowUpload: true}, want: codes.PermissionDenied},
+		{name: "allowed", auth: &fedmodel.FederationOutAuthorization{IncludeRegions: []string{"US"}, AllowUpload: true}, want: codes.OK},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.auth != nil {
+				ctx = context.WithValue(ctx, authKey{}, tc.auth)
+			}
+			recv := func() (*pb.FederationUploadRequest, error) { return nil, io.EOF }
+			inserted := false
+			insert := func(_ context.Context, exposures []*model.Exposure) (int, error) {
+				inserted = true
+				return len(exposures), nil
+			}
+			server := Server{env: serverenv.New(ctx), config: &Config{TruncateWindow: time.Hour, UploadMaxIntervalAge: 14 * 24 * time.Hour}}
+			_, err := server.upload(ctx, recv, insert, now)
+			if got := status.Code(err); got != tc.want {
+				t.Errorf("upload() returned err=%v, want code %v", err, tc.want)
+			}
+			if inserted {
+				t.Errorf("upload() inserted keys from an empty stream")
+			}
+		})
+	}
+}
+
+// TestUploadMaxIntervalCount tests that IntervalCounts above MaxIntervalCount are clamped before the
+// key is validated, and rejected if clamping is disabled.
+func TestUploadMaxIntervalCount(t *testing.T) {
+	ctx := uploaderContext("US")
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, max := range []int32{144, 0} {
+		req := &pb.FederationUploadRequest{
+			RegionIdentifiers: []string{"US"},
+			ExposureKey: &pb.ExposureKey{
+				ExposureKey:    []byte("0123456789abcdef"),
+				IntervalNumber: model.IntervalNumber(now.Add(-48 * time.Hour).Truncate(24 * time.Hour)),
+				IntervalCount:  288,
+			},
+		}
+		sent := false
+		recv := func() (*pb.FederationUploadRequest, error) {
+			if sent {
+				return nil, io.EOF
+			}
+			sent = true
+			return req, nil
+		}
+		var got []*model.Exposure
+		insert := func(_ context.Context, exposures []*model.Exposure) (int, error) {
+			got = append(got, exposures...)
+			return len(exposures), nil
+		}
+
+		server := Server{env: serverenv.New(ctx), config: &Config{TruncateWindow: time.Hour, UploadMaxIntervalAge: 14 * 24 * time.Hour, MaxIntervalCount: max}}
+		summary, err := server.upload(ctx, recv, insert, now)
+		if err != nil {
+			t.Fatalf("upload() returned err=%v, want err=nil", err)
+		}
+		if max == 0 {
+			if summary.Rejected != 1 || len(got) != 0 {
+				t.Errorf("without clamping, got %+v, want the key rejected", summary)
+			}
+			continue
+		}
+		if len(got) != 1 {
+			t.Fatalf("inserted %d exposures, want 1", len(got))
+		}
+		if got[0].IntervalCount != max {
+			t.Errorf("IntervalCount=%d, want %d", got[0].IntervalCount, max)
+		}
+	}
+}
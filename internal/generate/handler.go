@@ -38,7 +38,11 @@ func NewHandler(ctx context.Context, config *Config, env *serverenv.ServerEnv) (
 		return nil, fmt.Errorf("missing database in server environment")
 	}
 
-	transformer, err := model.NewTransformer(config.MaxKeysOnPublish, config.MaxIntervalAge, config.TruncateWindow, false)
+	transformer, err := model.NewTransformer(&model.TransformerConfig{
+		MaxExposureKeys:     config.MaxKeysOnPublish,
+		MaxIntervalStartAge: config.MaxIntervalAge,
+		TruncateWindow:      config.TruncateWindow,
+	})
 	if err != nil {
 		return nil, fmt.Errorf("model.NewTransformer: %w", err)
 	}
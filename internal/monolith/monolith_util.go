@@ -23,6 +23,7 @@ import (
 	"go.opencensus.io/plugin/ochttp"
 
 	"github.com/google/exposure-notifications-server/internal/authorizedapp"
+	"github.com/google/exposure-notifications-server/internal/blocklist"
 	"github.com/google/exposure-notifications-server/internal/cleanup"
 	"github.com/google/exposure-notifications-server/internal/database"
 	"github.com/google/exposure-notifications-server/internal/export"
@@ -41,12 +42,14 @@ import (
 
 var _ setup.AuthorizedAppConfigProvider = (*MonoConfig)(nil)
 var _ setup.BlobstoreConfigProvider = (*MonoConfig)(nil)
+var _ setup.BlocklistConfigProvider = (*MonoConfig)(nil)
 var _ setup.DatabaseConfigProvider = (*MonoConfig)(nil)
 var _ setup.KeyManagerConfigProvider = (*MonoConfig)(nil)
 var _ setup.SecretManagerConfigProvider = (*MonoConfig)(nil)
 
 type MonoConfig struct {
 	AuthorizedApp authorizedapp.Config
+	Blocklist     blocklist.Config
 	Storage       storage.Config
 	Cleanup       cleanup.Config
 	Database      database.Config
@@ -67,6 +70,10 @@ func (c *MonoConfig) BlobstoreConfig() *storage.Config {
 	return &c.Storage
 }
 
+func (c *MonoConfig) BlocklistConfig() *blocklist.Config {
+	return &c.Blocklist
+}
+
 func (c *MonoConfig) DatabaseConfig() *database.Config {
 	return &c.Database
 }
@@ -126,6 +133,9 @@ func RunServer(ctx context.Context) (*MonoConfig, error) {
 	}
 	mux.HandleFunc("/publish", handlers.WithMinimumLatency(config.Publish.MinRequestDuration, publishServer))
 
+	// Admin
+	mux.Handle("/admin/reload-blocklist", env.KeyBlocklist().ReloadHandler())
+
 	instrumentedHandler := &ochttp.Handler{Handler: mux}
 
 	logger.Infof("monolith running at :%s", config.Port)
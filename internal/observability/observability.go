@@ -25,6 +25,9 @@ import (
 	"go.opencensus.io/plugin/ochttp"
 	"go.opencensus.io/stats/view"
 	"go.opencensus.io/trace"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	publishdb "github.com/google/exposure-notifications-server/internal/publish/database"
 )
 
 func init() {
@@ -57,6 +60,14 @@ func initExporter() {
 	if err := view.Register(gRPCViews...); err != nil {
 		panic(err)
 	}
+	// Register the database connection pool views.
+	if err := view.Register(database.PoolViews...); err != nil {
+		panic(err)
+	}
+	// Register the views counting exposure keys stored in a legacy encoding.
+	if err := view.Register(publishdb.LegacyKeyViews...); err != nil {
+		panic(err)
+	}
 }
 
 type traceAndViewExporter interface {
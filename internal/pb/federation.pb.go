@@ -52,12 +52,72 @@ type FederationFetchRequest struct {
 	// fetchType is not used in the federation API and will be removed.
 	//
 	// Deprecated: Do not use.
-	FetchType                     string   `protobuf:"bytes,1,opt,name=fetchType,proto3" json:"fetchType,omitempty"`
-	RegionIdentifiers             []string `protobuf:"bytes,2,rep,name=regionIdentifiers,proto3" json:"regionIdentifiers,omitempty"`
-	ExcludeRegionIdentifiers      []string `protobuf:"bytes,3,rep,name=excludeRegionIdentifiers,proto3" json:"excludeRegionIdentifiers,omitempty"`
-	LastFetchResponseKeyTimestamp int64    `protobuf:"varint,4,opt,name=lastFetchResponseKeyTimestamp,proto3" json:"lastFetchResponseKeyTimestamp,omitempty"` // required
+	FetchType string `protobuf:"bytes,1,opt,name=fetchType,proto3" json:"fetchType,omitempty"`
+	// regionIdentifiers and excludeRegionIdentifiers may end in '*' to match all regions with that prefix, e.g. "US-*".
+	RegionIdentifiers        []string `protobuf:"bytes,2,rep,name=regionIdentifiers,proto3" json:"regionIdentifiers,omitempty"`
+	ExcludeRegionIdentifiers []string `protobuf:"bytes,3,rep,name=excludeRegionIdentifiers,proto3" json:"excludeRegionIdentifiers,omitempty"`
+	// lastFetchResponseKeyTimestamp is the fetchResponseKeyTimestamp of the previous fetch. The server may
+	// serve keys from somewhat before it, so clients must ignore keys they have already received.
+	LastFetchResponseKeyTimestamp int64 `protobuf:"varint,4,opt,name=lastFetchResponseKeyTimestamp,proto3" json:"lastFetchResponseKeyTimestamp,omitempty"` // required
 	// regionIdentifiers, excludeRegionIdentifiers, lastFetchResponseKeyTimestamp must be stable to send a fetchToken.
 	NextFetchToken string `protobuf:"bytes,5,opt,name=nextFetchToken,proto3" json:"nextFetchToken,omitempty"`
+	// debug requests that the response include the effectiveCriteria used by the server.
+	Debug bool `protobuf:"varint,6,opt,name=debug,proto3" json:"debug,omitempty"`
+	// includeTombstones requests that keys purged by the server since lastFetchResponseKeyTimestamp
+	// be returned in revokedKeys, so that they can be deleted by the client too.
+	IncludeTombstones bool `protobuf:"varint,7,opt,name=includeTombstones,proto3" json:"includeTombstones,omitempty"`
+	// explodeRegions requests that a key published to several regions be returned once under each
+	// requested region it applies to, rather than once under its set of regions. For example, a key
+	// published to {US, CA} is returned in both the [US] and [CA] responses.
+	ExplodeRegions bool `protobuf:"varint,8,opt,name=explodeRegions,proto3" json:"explodeRegions,omitempty"`
+	// asOfTimestamp, if set, requests the keys as they were at that time (truncated to the server's
+	// publish window), rather than up to the present. Keys created after it are not returned. This
+	// requires the client to be authorized for historical fetches.
+	AsOfTimestamp int64 `protobuf:"varint,9,opt,name=asOfTimestamp,proto3" json:"asOfTimestamp,omitempty"`
+	// relativeSinceSeconds, if set, requests the keys published in that many seconds before the end of
+	// the server's last complete publish window, e.g. 604800 for the last 7 days. It lets a client fetch
+	// without tracking lastFetchResponseKeyTimestamp, and must not be combined with it.
+	RelativeSinceSeconds int64 `protobuf:"varint,10,opt,name=relativeSinceSeconds,proto3" json:"relativeSinceSeconds,omitempty"`
+	// orderByRisk requests that within each ContactTracingResponse, contactTracingInfo be ordered by
+	// transmissionRisk, highest first, and the keys of each by intervalNumber, so that a client can
+	// process the riskiest keys first. By default both are in the order the keys were published.
+	OrderByRisk bool `protobuf:"varint,11,opt,name=orderByRisk,proto3" json:"orderByRisk,omitempty"`
+	// untilTimestampOverride, if set, replaces the end of the server's last complete publish window as
+	// the time up to which keys are served, e.g. to replay a fixed window to a partner during a
+	// backfill. It must not be after the present, and requires the client to be authorized for
+	// backfills.
+	UntilTimestampOverride int64 `protobuf:"varint,12,opt,name=untilTimestampOverride,proto3" json:"untilTimestampOverride,omitempty"`
+	// dedupKeys requests that a key stored several times under different region sets, e.g. by separate
+	// uploads, be returned once, under the union of those region sets, rather than once under each.
+	// Keys are only merged within a single response, not across the pages of a partial response. It
+	// cannot be combined with explodeRegions.
+	DedupKeys bool `protobuf:"varint,13,opt,name=dedupKeys,proto3" json:"dedupKeys,omitempty"`
+	// syncToken is the syncToken of the previous response. It captures the client's position, both the
+	// timestamp and any partial response being paged through, so a client can resume from it alone in
+	// place of lastFetchResponseKeyTimestamp and nextFetchToken, which must not be sent with it. It is
+	// bound to regionIdentifiers and excludeRegionIdentifiers, which must be unchanged. A client with no
+	// token starts from lastFetchResponseKeyTimestamp or relativeSinceSeconds, or from the beginning,
+	// and sets requestSyncToken.
+	SyncToken string `protobuf:"bytes,14,opt,name=syncToken,proto3" json:"syncToken,omitempty"`
+	// requestSyncToken asks for a syncToken in the response. It is implied by sending a syncToken.
+	RequestSyncToken bool `protobuf:"varint,15,opt,name=requestSyncToken,proto3" json:"requestSyncToken,omitempty"`
+	// knownKeys, if set, is a bloom filter of keys the client already holds. Keys which it indicates are
+	// present are not returned, so a client which has most keys, e.g. after a restore, transfers only
+	// the rest. A false positive means the client does not receive a key it lacks, so the filter must
+	// be sized for a false positive rate the client accepts. The server rejects filters larger than it
+	// allows; a debug fetch reports its limits in effectiveCriteria.
+	KnownKeys *KeyFilter `protobuf:"bytes,16,opt,name=knownKeys,proto3" json:"knownKeys,omitempty"`
+	// minTransmissionRisk, if set, requests only keys with at least this transmissionRisk, e.g. for a
+	// partner which only scores higher risk keys. It must be between 0 and 8.
+	MinTransmissionRisk int32 `protobuf:"varint,17,opt,name=minTransmissionRisk,proto3" json:"minTransmissionRisk,omitempty"`
+	// reverseOrder requests the newest keys first, paging from the end of the fetch window back toward
+	// lastFetchResponseKeyTimestamp, so that a client can act on recent keys sooner. Paging is gapless
+	// and resumable, as in the default order, but a nextFetchToken or syncToken carrying a position in
+	// one order is rejected with INVALID_ARGUMENT in the other: reverseOrder must be unchanged while
+	// paging. The fetchResponseKeyTimestamp to resume from next is the largest of the pages, which is
+	// the first page's in this order. If the server does not support it, the fetch fails with
+	// UNIMPLEMENTED.
+	ReverseOrder bool `protobuf:"varint,18,opt,name=reverseOrder,proto3" json:"reverseOrder,omitempty"`
 }
 
 func (x *FederationFetchRequest) Reset() {
@@ -128,6 +188,155 @@ func (x *FederationFetchRequest) GetNextFetchToken() string {
 	return ""
 }
 
+func (x *FederationFetchRequest) GetDebug() bool {
+	if x != nil {
+		return x.Debug
+	}
+	return false
+}
+
+func (x *FederationFetchRequest) GetIncludeTombstones() bool {
+	if x != nil {
+		return x.IncludeTombstones
+	}
+	return false
+}
+
+func (x *FederationFetchRequest) GetExplodeRegions() bool {
+	if x != nil {
+		return x.ExplodeRegions
+	}
+	return false
+}
+
+func (x *FederationFetchRequest) GetAsOfTimestamp() int64 {
+	if x != nil {
+		return x.AsOfTimestamp
+	}
+	return 0
+}
+
+func (x *FederationFetchRequest) GetRelativeSinceSeconds() int64 {
+	if x != nil {
+		return x.RelativeSinceSeconds
+	}
+	return 0
+}
+
+func (x *FederationFetchRequest) GetOrderByRisk() bool {
+	if x != nil {
+		return x.OrderByRisk
+	}
+	return false
+}
+
+func (x *FederationFetchRequest) GetUntilTimestampOverride() int64 {
+	if x != nil {
+		return x.UntilTimestampOverride
+	}
+	return 0
+}
+
+func (x *FederationFetchRequest) GetDedupKeys() bool {
+	if x != nil {
+		return x.DedupKeys
+	}
+	return false
+}
+
+func (x *FederationFetchRequest) GetSyncToken() string {
+	if x != nil {
+		return x.SyncToken
+	}
+	return ""
+}
+
+func (x *FederationFetchRequest) GetRequestSyncToken() bool {
+	if x != nil {
+		return x.RequestSyncToken
+	}
+	return false
+}
+
+func (x *FederationFetchRequest) GetKnownKeys() *KeyFilter {
+	if x != nil {
+		return x.KnownKeys
+	}
+	return nil
+}
+
+func (x *FederationFetchRequest) GetMinTransmissionRisk() int32 {
+	if x != nil {
+		return x.MinTransmissionRisk
+	}
+	return 0
+}
+
+func (x *FederationFetchRequest) GetReverseOrder() bool {
+	if x != nil {
+		return x.ReverseOrder
+	}
+	return false
+}
+
+// KeyFilter is a bloom filter of exposure keys. Key k is added by setting, for i in [0, hashCount),
+// bit (h1 + i*h2) mod m, where h1 and h2 are the first and second big-endian uint64 of SHA-256(k),
+// and m is 8 times the length of bits. Bit b is the (b mod 8)th least significant bit of bits[b/8].
+type KeyFilter struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Bits      []byte `protobuf:"bytes,1,opt,name=bits,proto3" json:"bits,omitempty"`
+	HashCount int32  `protobuf:"varint,2,opt,name=hashCount,proto3" json:"hashCount,omitempty"`
+}
+
+func (x *KeyFilter) Reset() {
+	*x = KeyFilter{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_internal_pb_federation_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *KeyFilter) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*KeyFilter) ProtoMessage() {}
+
+func (x *KeyFilter) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_pb_federation_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use KeyFilter.ProtoReflect.Descriptor instead.
+func (*KeyFilter) Descriptor() ([]byte, []int) {
+	return file_internal_pb_federation_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *KeyFilter) GetBits() []byte {
+	if x != nil {
+		return x.Bits
+	}
+	return nil
+}
+
+func (x *KeyFilter) GetHashCount() int32 {
+	if x != nil {
+		return x.HashCount
+	}
+	return 0
+}
+
 type FederationFetchResponse struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -137,12 +346,40 @@ type FederationFetchResponse struct {
 	PartialResponse           bool                      `protobuf:"varint,2,opt,name=partialResponse,proto3" json:"partialResponse,omitempty"`                     // required
 	NextFetchToken            string                    `protobuf:"bytes,3,opt,name=nextFetchToken,proto3" json:"nextFetchToken,omitempty"`                        // nextFetchToken will be present if partialResponse==true
 	FetchResponseKeyTimestamp int64                     `protobuf:"varint,4,opt,name=fetchResponseKeyTimestamp,proto3" json:"fetchResponseKeyTimestamp,omitempty"` // required
+	// effectiveCriteria will be present if debug==true on the request.
+	EffectiveCriteria *EffectiveCriteria `protobuf:"bytes,5,opt,name=effectiveCriteria,proto3" json:"effectiveCriteria,omitempty"`
+	// revokedKeys are keys which have been purged by the server and should no longer be served.
+	// Only exposureKey, intervalNumber and intervalCount are present. revokedKeys will only be
+	// present if includeTombstones==true on the request, and are not returned on a partial response.
+	RevokedKeys []*ExposureKey `protobuf:"bytes,6,rep,name=revokedKeys,proto3" json:"revokedKeys,omitempty"`
+	// keyCount is the number of exposure keys in response, counting a key served under several region
+	// groups once per group. regionCount is the number of distinct regions in response. The same
+	// counts are sent as the x-fetch-key-count and x-fetch-region-count trailers.
+	KeyCount    int64 `protobuf:"varint,7,opt,name=keyCount,proto3" json:"keyCount,omitempty"`
+	RegionCount int32 `protobuf:"varint,8,opt,name=regionCount,proto3" json:"regionCount,omitempty"`
+	// atLiveEdge is true if the client is caught up: the response is complete, and the request started
+	// within the server's last complete publish window, so no further keys can be served before the
+	// next window closes. The client may back off polling until then. An empty response without
+	// atLiveEdge only means that no keys matched the request.
+	AtLiveEdge bool `protobuf:"varint,9,opt,name=atLiveEdge,proto3" json:"atLiveEdge,omitempty"`
+	// syncToken is an opaque token from which the client's next fetch resumes, see
+	// FederationFetchRequest.syncToken. It is only present if the request set requestSyncToken or
+	// syncToken. Fetching with it, page after page and then poll after poll,
+	// serves every key in the requested regions at least once.
+	SyncToken string `protobuf:"bytes,10,opt,name=syncToken,proto3" json:"syncToken,omitempty"`
+	// knownKeyCount is the number of keys which were not returned because the request's knownKeys
+	// filter indicated the client already holds them.
+	KnownKeyCount int64 `protobuf:"varint,11,opt,name=knownKeyCount,proto3" json:"knownKeyCount,omitempty"`
+	// generation identifies the server's key database. It changes when the database is wiped and
+	// rebuilt, after which nextFetchTokens and syncTokens from the previous generation are rejected
+	// with FAILED_PRECONDITION, and the client must start over with a full refresh.
+	Generation string `protobuf:"bytes,12,opt,name=generation,proto3" json:"generation,omitempty"`
 }
 
 func (x *FederationFetchResponse) Reset() {
 	*x = FederationFetchResponse{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_internal_pb_federation_proto_msgTypes[1]
+		mi := &file_internal_pb_federation_proto_msgTypes[2]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -155,7 +392,7 @@ func (x *FederationFetchResponse) String() string {
 func (*FederationFetchResponse) ProtoMessage() {}
 
 func (x *FederationFetchResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_internal_pb_federation_proto_msgTypes[1]
+	mi := &file_internal_pb_federation_proto_msgTypes[2]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -168,7 +405,7 @@ func (x *FederationFetchResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use FederationFetchResponse.ProtoReflect.Descriptor instead.
 func (*FederationFetchResponse) Descriptor() ([]byte, []int) {
-	return file_internal_pb_federation_proto_rawDescGZIP(), []int{1}
+	return file_internal_pb_federation_proto_rawDescGZIP(), []int{2}
 }
 
 func (x *FederationFetchResponse) GetResponse() []*ContactTracingResponse {
@@ -199,32 +436,123 @@ func (x *FederationFetchResponse) GetFetchResponseKeyTimestamp() int64 {
 	return 0
 }
 
-type ContactTracingResponse struct {
+func (x *FederationFetchResponse) GetEffectiveCriteria() *EffectiveCriteria {
+	if x != nil {
+		return x.EffectiveCriteria
+	}
+	return nil
+}
+
+func (x *FederationFetchResponse) GetRevokedKeys() []*ExposureKey {
+	if x != nil {
+		return x.RevokedKeys
+	}
+	return nil
+}
+
+func (x *FederationFetchResponse) GetKeyCount() int64 {
+	if x != nil {
+		return x.KeyCount
+	}
+	return 0
+}
+
+func (x *FederationFetchResponse) GetRegionCount() int32 {
+	if x != nil {
+		return x.RegionCount
+	}
+	return 0
+}
+
+func (x *FederationFetchResponse) GetAtLiveEdge() bool {
+	if x != nil {
+		return x.AtLiveEdge
+	}
+	return false
+}
+
+func (x *FederationFetchResponse) GetSyncToken() string {
+	if x != nil {
+		return x.SyncToken
+	}
+	return ""
+}
+
+func (x *FederationFetchResponse) GetKnownKeyCount() int64 {
+	if x != nil {
+		return x.KnownKeyCount
+	}
+	return 0
+}
+
+func (x *FederationFetchResponse) GetGeneration() string {
+	if x != nil {
+		return x.Generation
+	}
+	return ""
+}
+
+// EffectiveCriteria describes how the server interpreted a FederationFetchRequest.
+type EffectiveCriteria struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	ContactTracingInfo []*ContactTracingInfo `protobuf:"bytes,1,rep,name=contactTracingInfo,proto3" json:"contactTracingInfo,omitempty"`
-	RegionIdentifiers  []string              `protobuf:"bytes,2,rep,name=regionIdentifiers,proto3" json:"regionIdentifiers,omitempty"`
+	// Regions after normalization and after applying the client's authorization.
+	RegionIdentifiers        []string `protobuf:"bytes,1,rep,name=regionIdentifiers,proto3" json:"regionIdentifiers,omitempty"`
+	ExcludeRegionIdentifiers []string `protobuf:"bytes,2,rep,name=excludeRegionIdentifiers,proto3" json:"excludeRegionIdentifiers,omitempty"`
+	// The time window queried; since is inclusive and until is exclusive.
+	SinceTimestamp int64 `protobuf:"varint,3,opt,name=sinceTimestamp,proto3" json:"sinceTimestamp,omitempty"`
+	UntilTimestamp int64 `protobuf:"varint,4,opt,name=untilTimestamp,proto3" json:"untilTimestamp,omitempty"`
+	// fullRefresh is true if the request had no lastFetchResponseKeyTimestamp.
+	FullRefresh bool `protobuf:"varint,5,opt,name=fullRefresh,proto3" json:"fullRefresh,omitempty"`
+	// strictExclude is true if a key is excluded when ANY of its regions is excluded.
+	StrictExclude bool `protobuf:"varint,6,opt,name=strictExclude,proto3" json:"strictExclude,omitempty"`
+	// unchanged is true if the query was skipped because no keys were published since sinceTimestamp.
+	Unchanged bool `protobuf:"varint,7,opt,name=unchanged,proto3" json:"unchanged,omitempty"`
+	// includeTombstones is true if revokedKeys were requested.
+	IncludeTombstones bool `protobuf:"varint,8,opt,name=includeTombstones,proto3" json:"includeTombstones,omitempty"`
+	// explodeRegions is true if keys are grouped by single region rather than by region set.
+	ExplodeRegions bool `protobuf:"varint,9,opt,name=explodeRegions,proto3" json:"explodeRegions,omitempty"`
+	// asOfTimestamp is the requested asOfTimestamp, if any. untilTimestamp reflects it after truncation.
+	AsOfTimestamp int64 `protobuf:"varint,10,opt,name=asOfTimestamp,proto3" json:"asOfTimestamp,omitempty"`
+	// relativeSinceSeconds is the requested relativeSinceSeconds, if any. sinceTimestamp reflects it.
+	RelativeSinceSeconds int64 `protobuf:"varint,11,opt,name=relativeSinceSeconds,proto3" json:"relativeSinceSeconds,omitempty"`
+	// overlappingRegionIdentifiers are regions which are both included and excluded, e.g. requested by the
+	// client but excluded by its authorization. If there are any, regionPrecedence, "exclude-wins" or
+	// "include-wins", says how they were treated.
+	OverlappingRegionIdentifiers []string `protobuf:"bytes,12,rep,name=overlappingRegionIdentifiers,proto3" json:"overlappingRegionIdentifiers,omitempty"`
+	RegionPrecedence             string   `protobuf:"bytes,13,opt,name=regionPrecedence,proto3" json:"regionPrecedence,omitempty"`
+	// untilTimestampOverride is the requested untilTimestampOverride, if any. untilTimestamp reflects it.
+	UntilTimestampOverride int64 `protobuf:"varint,14,opt,name=untilTimestampOverride,proto3" json:"untilTimestampOverride,omitempty"`
+	// dedupKeys is true if keys stored under several region sets were merged.
+	DedupKeys bool `protobuf:"varint,15,opt,name=dedupKeys,proto3" json:"dedupKeys,omitempty"`
+	// maxKeyFilterBytes and maxKeyFilterHashCount are the largest knownKeys filter the server accepts.
+	// A maxKeyFilterBytes of zero means knownKeys is not supported.
+	MaxKeyFilterBytes     int32 `protobuf:"varint,16,opt,name=maxKeyFilterBytes,proto3" json:"maxKeyFilterBytes,omitempty"`
+	MaxKeyFilterHashCount int32 `protobuf:"varint,17,opt,name=maxKeyFilterHashCount,proto3" json:"maxKeyFilterHashCount,omitempty"`
+	// minTransmissionRisk is the requested minTransmissionRisk, if any.
+	MinTransmissionRisk int32 `protobuf:"varint,18,opt,name=minTransmissionRisk,proto3" json:"minTransmissionRisk,omitempty"`
+	ReverseOrder        bool  `protobuf:"varint,19,opt,name=reverseOrder,proto3" json:"reverseOrder,omitempty"`
 }
 
-func (x *ContactTracingResponse) Reset() {
-	*x = ContactTracingResponse{}
+func (x *EffectiveCriteria) Reset() {
+	*x = EffectiveCriteria{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_internal_pb_federation_proto_msgTypes[2]
+		mi := &file_internal_pb_federation_proto_msgTypes[3]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
 }
 
-func (x *ContactTracingResponse) String() string {
+func (x *EffectiveCriteria) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*ContactTracingResponse) ProtoMessage() {}
+func (*EffectiveCriteria) ProtoMessage() {}
 
-func (x *ContactTracingResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_internal_pb_federation_proto_msgTypes[2]
+func (x *EffectiveCriteria) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_pb_federation_proto_msgTypes[3]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -235,52 +563,785 @@ func (x *ContactTracingResponse) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use ContactTracingResponse.ProtoReflect.Descriptor instead.
-func (*ContactTracingResponse) Descriptor() ([]byte, []int) {
-	return file_internal_pb_federation_proto_rawDescGZIP(), []int{2}
+// Deprecated: Use EffectiveCriteria.ProtoReflect.Descriptor instead.
+func (*EffectiveCriteria) Descriptor() ([]byte, []int) {
+	return file_internal_pb_federation_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *EffectiveCriteria) GetRegionIdentifiers() []string {
+	if x != nil {
+		return x.RegionIdentifiers
+	}
+	return nil
+}
+
+func (x *EffectiveCriteria) GetExcludeRegionIdentifiers() []string {
+	if x != nil {
+		return x.ExcludeRegionIdentifiers
+	}
+	return nil
+}
+
+func (x *EffectiveCriteria) GetSinceTimestamp() int64 {
+	if x != nil {
+		return x.SinceTimestamp
+	}
+	return 0
+}
+
+func (x *EffectiveCriteria) GetUntilTimestamp() int64 {
+	if x != nil {
+		return x.UntilTimestamp
+	}
+	return 0
+}
+
+func (x *EffectiveCriteria) GetFullRefresh() bool {
+	if x != nil {
+		return x.FullRefresh
+	}
+	return false
+}
+
+func (x *EffectiveCriteria) GetStrictExclude() bool {
+	if x != nil {
+		return x.StrictExclude
+	}
+	return false
+}
+
+func (x *EffectiveCriteria) GetUnchanged() bool {
+	if x != nil {
+		return x.Unchanged
+	}
+	return false
+}
+
+func (x *EffectiveCriteria) GetIncludeTombstones() bool {
+	if x != nil {
+		return x.IncludeTombstones
+	}
+	return false
+}
+
+func (x *EffectiveCriteria) GetExplodeRegions() bool {
+	if x != nil {
+		return x.ExplodeRegions
+	}
+	return false
+}
+
+func (x *EffectiveCriteria) GetAsOfTimestamp() int64 {
+	if x != nil {
+		return x.AsOfTimestamp
+	}
+	return 0
+}
+
+func (x *EffectiveCriteria) GetRelativeSinceSeconds() int64 {
+	if x != nil {
+		return x.RelativeSinceSeconds
+	}
+	return 0
+}
+
+func (x *EffectiveCriteria) GetOverlappingRegionIdentifiers() []string {
+	if x != nil {
+		return x.OverlappingRegionIdentifiers
+	}
+	return nil
+}
+
+func (x *EffectiveCriteria) GetRegionPrecedence() string {
+	if x != nil {
+		return x.RegionPrecedence
+	}
+	return ""
+}
+
+func (x *EffectiveCriteria) GetUntilTimestampOverride() int64 {
+	if x != nil {
+		return x.UntilTimestampOverride
+	}
+	return 0
+}
+
+func (x *EffectiveCriteria) GetDedupKeys() bool {
+	if x != nil {
+		return x.DedupKeys
+	}
+	return false
+}
+
+func (x *EffectiveCriteria) GetMaxKeyFilterBytes() int32 {
+	if x != nil {
+		return x.MaxKeyFilterBytes
+	}
+	return 0
+}
+
+func (x *EffectiveCriteria) GetMaxKeyFilterHashCount() int32 {
+	if x != nil {
+		return x.MaxKeyFilterHashCount
+	}
+	return 0
+}
+
+func (x *EffectiveCriteria) GetMinTransmissionRisk() int32 {
+	if x != nil {
+		return x.MinTransmissionRisk
+	}
+	return 0
+}
+
+func (x *EffectiveCriteria) GetReverseOrder() bool {
+	if x != nil {
+		return x.ReverseOrder
+	}
+	return false
+}
+
+type ContactTracingResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	ContactTracingInfo []*ContactTracingInfo `protobuf:"bytes,1,rep,name=contactTracingInfo,proto3" json:"contactTracingInfo,omitempty"`
+	RegionIdentifiers  []string              `protobuf:"bytes,2,rep,name=regionIdentifiers,proto3" json:"regionIdentifiers,omitempty"`
+}
+
+func (x *ContactTracingResponse) Reset() {
+	*x = ContactTracingResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_internal_pb_federation_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ContactTracingResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ContactTracingResponse) ProtoMessage() {}
+
+func (x *ContactTracingResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_pb_federation_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ContactTracingResponse.ProtoReflect.Descriptor instead.
+func (*ContactTracingResponse) Descriptor() ([]byte, []int) {
+	return file_internal_pb_federation_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ContactTracingResponse) GetContactTracingInfo() []*ContactTracingInfo {
+	if x != nil {
+		return x.ContactTracingInfo
+	}
+	return nil
+}
+
+func (x *ContactTracingResponse) GetRegionIdentifiers() []string {
+	if x != nil {
+		return x.RegionIdentifiers
+	}
+	return nil
+}
+
+type ContactTracingInfo struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Transmission risk is an integer with valid values from 1-8.
+	TransmissionRisk int32          `protobuf:"varint,1,opt,name=transmissionRisk,proto3" json:"transmissionRisk,omitempty"` // required
+	ExposureKeys     []*ExposureKey `protobuf:"bytes,2,rep,name=exposureKeys,proto3" json:"exposureKeys,omitempty"`
+}
+
+func (x *ContactTracingInfo) Reset() {
+	*x = ContactTracingInfo{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_internal_pb_federation_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ContactTracingInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ContactTracingInfo) ProtoMessage() {}
+
+func (x *ContactTracingInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_pb_federation_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ContactTracingInfo.ProtoReflect.Descriptor instead.
+func (*ContactTracingInfo) Descriptor() ([]byte, []int) {
+	return file_internal_pb_federation_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ContactTracingInfo) GetTransmissionRisk() int32 {
+	if x != nil {
+		return x.TransmissionRisk
+	}
+	return 0
+}
+
+func (x *ContactTracingInfo) GetExposureKeys() []*ExposureKey {
+	if x != nil {
+		return x.ExposureKeys
+	}
+	return nil
+}
+
+type ExposureKey struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	ExposureKey    []byte `protobuf:"bytes,1,opt,name=exposureKey,proto3" json:"exposureKey,omitempty"`        // required
+	IntervalNumber int32  `protobuf:"varint,2,opt,name=intervalNumber,proto3" json:"intervalNumber,omitempty"` // required
+	IntervalCount  int32  `protobuf:"varint,3,opt,name=intervalCount,proto3" json:"intervalCount,omitempty"`   // required
+}
+
+func (x *ExposureKey) Reset() {
+	*x = ExposureKey{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_internal_pb_federation_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ExposureKey) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExposureKey) ProtoMessage() {}
+
+func (x *ExposureKey) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_pb_federation_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExposureKey.ProtoReflect.Descriptor instead.
+func (*ExposureKey) Descriptor() ([]byte, []int) {
+	return file_internal_pb_federation_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ExposureKey) GetExposureKey() []byte {
+	if x != nil {
+		return x.ExposureKey
+	}
+	return nil
+}
+
+func (x *ExposureKey) GetIntervalNumber() int32 {
+	if x != nil {
+		return x.IntervalNumber
+	}
+	return 0
+}
+
+func (x *ExposureKey) GetIntervalCount() int32 {
+	if x != nil {
+		return x.IntervalCount
+	}
+	return 0
+}
+
+// ChecksumRequest asks for a checksum tree over the keys the client would fetch, so that a client whose
+// copy has diverged can find where without transferring all keys.
+//
+// The keys summarised are those published before untilTimestamp whose interval starts (intervalNumber
+// * 600 seconds) in [sinceTimestamp, untilTimestamp). They are divided into buckets of bucketSeconds by
+// interval start. A bucket's checksum is the XOR of SHA-256(exposureKey || intervalNumber ||
+// intervalCount) over its keys, with the integers as 4 byte big endian, so it does not depend on order.
+type ChecksumRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// regionIdentifiers may end in '*' to match all regions with that prefix. A tree is returned for each.
+	RegionIdentifiers []string `protobuf:"bytes,1,rep,name=regionIdentifiers,proto3" json:"regionIdentifiers,omitempty"`
+	SinceTimestamp    int64    `protobuf:"varint,2,opt,name=sinceTimestamp,proto3" json:"sinceTimestamp,omitempty"` // required
+	// untilTimestamp defaults to the end of the server's last complete publish window.
+	UntilTimestamp int64 `protobuf:"varint,3,opt,name=untilTimestamp,proto3" json:"untilTimestamp,omitempty"`
+	// bucketSeconds defaults to one day.
+	BucketSeconds int64 `protobuf:"varint,4,opt,name=bucketSeconds,proto3" json:"bucketSeconds,omitempty"`
+}
+
+func (x *ChecksumRequest) Reset() {
+	*x = ChecksumRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_internal_pb_federation_proto_msgTypes[7]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ChecksumRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ChecksumRequest) ProtoMessage() {}
+
+func (x *ChecksumRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_pb_federation_proto_msgTypes[7]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ChecksumRequest.ProtoReflect.Descriptor instead.
+func (*ChecksumRequest) Descriptor() ([]byte, []int) {
+	return file_internal_pb_federation_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *ChecksumRequest) GetRegionIdentifiers() []string {
+	if x != nil {
+		return x.RegionIdentifiers
+	}
+	return nil
+}
+
+func (x *ChecksumRequest) GetSinceTimestamp() int64 {
+	if x != nil {
+		return x.SinceTimestamp
+	}
+	return 0
+}
+
+func (x *ChecksumRequest) GetUntilTimestamp() int64 {
+	if x != nil {
+		return x.UntilTimestamp
+	}
+	return 0
+}
+
+func (x *ChecksumRequest) GetBucketSeconds() int64 {
+	if x != nil {
+		return x.BucketSeconds
+	}
+	return 0
+}
+
+type ChecksumResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Regions []*RegionChecksum `protobuf:"bytes,1,rep,name=regions,proto3" json:"regions,omitempty"`
+	// The untilTimestamp used, after applying the default.
+	UntilTimestamp int64 `protobuf:"varint,2,opt,name=untilTimestamp,proto3" json:"untilTimestamp,omitempty"`
+}
+
+func (x *ChecksumResponse) Reset() {
+	*x = ChecksumResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_internal_pb_federation_proto_msgTypes[8]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ChecksumResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ChecksumResponse) ProtoMessage() {}
+
+func (x *ChecksumResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_pb_federation_proto_msgTypes[8]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ChecksumResponse.ProtoReflect.Descriptor instead.
+func (*ChecksumResponse) Descriptor() ([]byte, []int) {
+	return file_internal_pb_federation_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ChecksumResponse) GetRegions() []*RegionChecksum {
+	if x != nil {
+		return x.Regions
+	}
+	return nil
+}
+
+func (x *ChecksumResponse) GetUntilTimestamp() int64 {
+	if x != nil {
+		return x.UntilTimestamp
+	}
+	return 0
+}
+
+type RegionChecksum struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	RegionIdentifier string        `protobuf:"bytes,1,opt,name=regionIdentifier,proto3" json:"regionIdentifier,omitempty"`
+	Root             *ChecksumNode `protobuf:"bytes,2,opt,name=root,proto3" json:"root,omitempty"`
+}
+
+func (x *RegionChecksum) Reset() {
+	*x = RegionChecksum{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_internal_pb_federation_proto_msgTypes[9]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RegionChecksum) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RegionChecksum) ProtoMessage() {}
+
+func (x *RegionChecksum) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_pb_federation_proto_msgTypes[9]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RegionChecksum.ProtoReflect.Descriptor instead.
+func (*RegionChecksum) Descriptor() ([]byte, []int) {
+	return file_internal_pb_federation_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *RegionChecksum) GetRegionIdentifier() string {
+	if x != nil {
+		return x.RegionIdentifier
+	}
+	return ""
+}
+
+func (x *RegionChecksum) GetRoot() *ChecksumNode {
+	if x != nil {
+		return x.Root
+	}
+	return nil
+}
+
+// ChecksumNode summarises the keys with interval start in [startTimestamp, endTimestamp). Leaves are
+// buckets. The checksum of an inner node is SHA-256 of its children's checksums, in order; pairs of
+// adjacent nodes are combined level by level, and an unpaired last node moves up a level unchanged.
+type ChecksumNode struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	StartTimestamp int64           `protobuf:"varint,1,opt,name=startTimestamp,proto3" json:"startTimestamp,omitempty"`
+	EndTimestamp   int64           `protobuf:"varint,2,opt,name=endTimestamp,proto3" json:"endTimestamp,omitempty"`
+	KeyCount       int64           `protobuf:"varint,3,opt,name=keyCount,proto3" json:"keyCount,omitempty"`
+	Checksum       []byte          `protobuf:"bytes,4,opt,name=checksum,proto3" json:"checksum,omitempty"`
+	Children       []*ChecksumNode `protobuf:"bytes,5,rep,name=children,proto3" json:"children,omitempty"`
+}
+
+func (x *ChecksumNode) Reset() {
+	*x = ChecksumNode{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_internal_pb_federation_proto_msgTypes[10]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ChecksumNode) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ChecksumNode) ProtoMessage() {}
+
+func (x *ChecksumNode) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_pb_federation_proto_msgTypes[10]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ChecksumNode.ProtoReflect.Descriptor instead.
+func (*ChecksumNode) Descriptor() ([]byte, []int) {
+	return file_internal_pb_federation_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *ChecksumNode) GetStartTimestamp() int64 {
+	if x != nil {
+		return x.StartTimestamp
+	}
+	return 0
+}
+
+func (x *ChecksumNode) GetEndTimestamp() int64 {
+	if x != nil {
+		return x.EndTimestamp
+	}
+	return 0
+}
+
+func (x *ChecksumNode) GetKeyCount() int64 {
+	if x != nil {
+		return x.KeyCount
+	}
+	return 0
+}
+
+func (x *ChecksumNode) GetChecksum() []byte {
+	if x != nil {
+		return x.Checksum
+	}
+	return nil
+}
+
+func (x *ChecksumNode) GetChildren() []*ChecksumNode {
+	if x != nil {
+		return x.Children
+	}
+	return nil
+}
+
+type RetentionRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// regionIdentifiers may end in '*' to match all regions with that prefix. A retention is returned for each.
+	RegionIdentifiers []string `protobuf:"bytes,1,rep,name=regionIdentifiers,proto3" json:"regionIdentifiers,omitempty"`
+}
+
+func (x *RetentionRequest) Reset() {
+	*x = RetentionRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_internal_pb_federation_proto_msgTypes[11]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RetentionRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RetentionRequest) ProtoMessage() {}
+
+func (x *RetentionRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_pb_federation_proto_msgTypes[11]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RetentionRequest.ProtoReflect.Descriptor instead.
+func (*RetentionRequest) Descriptor() ([]byte, []int) {
+	return file_internal_pb_federation_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *RetentionRequest) GetRegionIdentifiers() []string {
+	if x != nil {
+		return x.RegionIdentifiers
+	}
+	return nil
+}
+
+type RetentionResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Regions []*RegionRetention `protobuf:"bytes,1,rep,name=regions,proto3" json:"regions,omitempty"`
+}
+
+func (x *RetentionResponse) Reset() {
+	*x = RetentionResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_internal_pb_federation_proto_msgTypes[12]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RetentionResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RetentionResponse) ProtoMessage() {}
+
+func (x *RetentionResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_pb_federation_proto_msgTypes[12]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RetentionResponse.ProtoReflect.Descriptor instead.
+func (*RetentionResponse) Descriptor() ([]byte, []int) {
+	return file_internal_pb_federation_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *RetentionResponse) GetRegions() []*RegionRetention {
+	if x != nil {
+		return x.Regions
+	}
+	return nil
+}
+
+type RegionRetention struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	RegionIdentifier string `protobuf:"bytes,1,opt,name=regionIdentifier,proto3" json:"regionIdentifier,omitempty"`
+	// oldestTimestamp is the earliest creation time of a key which can still be fetched in the region, as
+	// of the response. Fetching from before it returns no more keys. It moves forward as keys expire
+	// and are deleted. It is zero if the region has no keys.
+	OldestTimestamp int64 `protobuf:"varint,2,opt,name=oldestTimestamp,proto3" json:"oldestTimestamp,omitempty"`
+	// latestTimestamp is the creation time of the most recent key in the region, or zero if there are none.
+	LatestTimestamp int64 `protobuf:"varint,3,opt,name=latestTimestamp,proto3" json:"latestTimestamp,omitempty"`
+}
+
+func (x *RegionRetention) Reset() {
+	*x = RegionRetention{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_internal_pb_federation_proto_msgTypes[13]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RegionRetention) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RegionRetention) ProtoMessage() {}
+
+func (x *RegionRetention) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_pb_federation_proto_msgTypes[13]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RegionRetention.ProtoReflect.Descriptor instead.
+func (*RegionRetention) Descriptor() ([]byte, []int) {
+	return file_internal_pb_federation_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *RegionRetention) GetRegionIdentifier() string {
+	if x != nil {
+		return x.RegionIdentifier
+	}
+	return ""
 }
 
-func (x *ContactTracingResponse) GetContactTracingInfo() []*ContactTracingInfo {
+func (x *RegionRetention) GetOldestTimestamp() int64 {
 	if x != nil {
-		return x.ContactTracingInfo
+		return x.OldestTimestamp
 	}
-	return nil
+	return 0
 }
 
-func (x *ContactTracingResponse) GetRegionIdentifiers() []string {
+func (x *RegionRetention) GetLatestTimestamp() int64 {
 	if x != nil {
-		return x.RegionIdentifiers
+		return x.LatestTimestamp
 	}
-	return nil
+	return 0
 }
 
-type ContactTracingInfo struct {
+// FederationUploadRequest is one key of an Upload stream.
+type FederationUploadRequest struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	// Transmission risk is an integer with valid values from 1-8.
-	TransmissionRisk int32          `protobuf:"varint,1,opt,name=transmissionRisk,proto3" json:"transmissionRisk,omitempty"` // required
-	ExposureKeys     []*ExposureKey `protobuf:"bytes,2,rep,name=exposureKeys,proto3" json:"exposureKeys,omitempty"`
+	RegionIdentifiers []string     `protobuf:"bytes,1,rep,name=regionIdentifiers,proto3" json:"regionIdentifiers,omitempty"` // required
+	TransmissionRisk  int32        `protobuf:"varint,2,opt,name=transmissionRisk,proto3" json:"transmissionRisk,omitempty"`  // required
+	ExposureKey       *ExposureKey `protobuf:"bytes,3,opt,name=exposureKey,proto3" json:"exposureKey,omitempty"`             // required
+	// createdTimestamp is when the key was first published to the source server, in seconds since
+	// the epoch. If set and plausible, it is kept rather than stamping the time of the upload.
+	CreatedTimestamp int64 `protobuf:"varint,4,opt,name=createdTimestamp,proto3" json:"createdTimestamp,omitempty"`
 }
 
-func (x *ContactTracingInfo) Reset() {
-	*x = ContactTracingInfo{}
+func (x *FederationUploadRequest) Reset() {
+	*x = FederationUploadRequest{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_internal_pb_federation_proto_msgTypes[3]
+		mi := &file_internal_pb_federation_proto_msgTypes[14]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
 }
 
-func (x *ContactTracingInfo) String() string {
+func (x *FederationUploadRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*ContactTracingInfo) ProtoMessage() {}
+func (*FederationUploadRequest) ProtoMessage() {}
 
-func (x *ContactTracingInfo) ProtoReflect() protoreflect.Message {
-	mi := &file_internal_pb_federation_proto_msgTypes[3]
+func (x *FederationUploadRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_pb_federation_proto_msgTypes[14]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -291,52 +1352,70 @@ func (x *ContactTracingInfo) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use ContactTracingInfo.ProtoReflect.Descriptor instead.
-func (*ContactTracingInfo) Descriptor() ([]byte, []int) {
-	return file_internal_pb_federation_proto_rawDescGZIP(), []int{3}
+// Deprecated: Use FederationUploadRequest.ProtoReflect.Descriptor instead.
+func (*FederationUploadRequest) Descriptor() ([]byte, []int) {
+	return file_internal_pb_federation_proto_rawDescGZIP(), []int{14}
 }
 
-func (x *ContactTracingInfo) GetTransmissionRisk() int32 {
+func (x *FederationUploadRequest) GetRegionIdentifiers() []string {
+	if x != nil {
+		return x.RegionIdentifiers
+	}
+	return nil
+}
+
+func (x *FederationUploadRequest) GetTransmissionRisk() int32 {
 	if x != nil {
 		return x.TransmissionRisk
 	}
 	return 0
 }
 
-func (x *ContactTracingInfo) GetExposureKeys() []*ExposureKey {
+func (x *FederationUploadRequest) GetExposureKey() *ExposureKey {
 	if x != nil {
-		return x.ExposureKeys
+		return x.ExposureKey
 	}
 	return nil
 }
 
-type ExposureKey struct {
+func (x *FederationUploadRequest) GetCreatedTimestamp() int64 {
+	if x != nil {
+		return x.CreatedTimestamp
+	}
+	return 0
+}
+
+// FederationUploadResponse summarises an Upload stream once the client closes it.
+type FederationUploadResponse struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	ExposureKey    []byte `protobuf:"bytes,1,opt,name=exposureKey,proto3" json:"exposureKey,omitempty"`        // required
-	IntervalNumber int32  `protobuf:"varint,2,opt,name=intervalNumber,proto3" json:"intervalNumber,omitempty"` // required
-	IntervalCount  int32  `protobuf:"varint,3,opt,name=intervalCount,proto3" json:"intervalCount,omitempty"`   // required
+	// accepted keys were stored.
+	Accepted int64 `protobuf:"varint,1,opt,name=accepted,proto3" json:"accepted,omitempty"`
+	// rejected keys failed validation or were outside the client's authorized regions.
+	Rejected int64 `protobuf:"varint,2,opt,name=rejected,proto3" json:"rejected,omitempty"`
+	// duplicate keys were already stored, or appeared earlier in the stream.
+	Duplicate int64 `protobuf:"varint,3,opt,name=duplicate,proto3" json:"duplicate,omitempty"`
 }
 
-func (x *ExposureKey) Reset() {
-	*x = ExposureKey{}
+func (x *FederationUploadResponse) Reset() {
+	*x = FederationUploadResponse{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_internal_pb_federation_proto_msgTypes[4]
+		mi := &file_internal_pb_federation_proto_msgTypes[15]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
 }
 
-func (x *ExposureKey) String() string {
+func (x *FederationUploadResponse) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*ExposureKey) ProtoMessage() {}
+func (*FederationUploadResponse) ProtoMessage() {}
 
-func (x *ExposureKey) ProtoReflect() protoreflect.Message {
-	mi := &file_internal_pb_federation_proto_msgTypes[4]
+func (x *FederationUploadResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_pb_federation_proto_msgTypes[15]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -347,28 +1426,28 @@ func (x *ExposureKey) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use ExposureKey.ProtoReflect.Descriptor instead.
-func (*ExposureKey) Descriptor() ([]byte, []int) {
-	return file_internal_pb_federation_proto_rawDescGZIP(), []int{4}
+// Deprecated: Use FederationUploadResponse.ProtoReflect.Descriptor instead.
+func (*FederationUploadResponse) Descriptor() ([]byte, []int) {
+	return file_internal_pb_federation_proto_rawDescGZIP(), []int{15}
 }
 
-func (x *ExposureKey) GetExposureKey() []byte {
+func (x *FederationUploadResponse) GetAccepted() int64 {
 	if x != nil {
-		return x.ExposureKey
+		return x.Accepted
 	}
-	return nil
+	return 0
 }
 
-func (x *ExposureKey) GetIntervalNumber() int32 {
+func (x *FederationUploadResponse) GetRejected() int64 {
 	if x != nil {
-		return x.IntervalNumber
+		return x.Rejected
 	}
 	return 0
 }
 
-func (x *ExposureKey) GetIntervalCount() int32 {
+func (x *FederationUploadResponse) GetDuplicate() int64 {
 	if x != nil {
-		return x.IntervalCount
+		return x.Duplicate
 	}
 	return 0
 }
@@ -377,8 +1456,8 @@ var File_internal_pb_federation_proto protoreflect.FileDescriptor
 
 var file_internal_pb_federation_proto_rawDesc = []byte{
 	0x0a, 0x1c, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x6e, 0x61, 0x6c, 0x2f, 0x70, 0x62, 0x2f, 0x66, 0x65,
-	0x64, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0x92,
-	0x02, 0x0a, 0x16, 0x46, 0x65, 0x64, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x46, 0x65, 0x74,
+	0x64, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0x9a,
+	0x06, 0x0a, 0x16, 0x46, 0x65, 0x64, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x46, 0x65, 0x74,
 	0x63, 0x68, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x20, 0x0a, 0x09, 0x66, 0x65, 0x74,
 	0x63, 0x68, 0x54, 0x79, 0x70, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x42, 0x02, 0x18, 0x01,
 	0x52, 0x09, 0x66, 0x65, 0x74, 0x63, 0x68, 0x54, 0x79, 0x70, 0x65, 0x12, 0x2c, 0x0a, 0x11, 0x72,
@@ -395,54 +1474,249 @@ var file_internal_pb_federation_proto_rawDesc = []byte{
 	0x65, 0x79, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x12, 0x26, 0x0a, 0x0e, 0x6e,
 	0x65, 0x78, 0x74, 0x46, 0x65, 0x74, 0x63, 0x68, 0x54, 0x6f, 0x6b, 0x65, 0x6e, 0x18, 0x05, 0x20,
 	0x01, 0x28, 0x09, 0x52, 0x0e, 0x6e, 0x65, 0x78, 0x74, 0x46, 0x65, 0x74, 0x63, 0x68, 0x54, 0x6f,
-	0x6b, 0x65, 0x6e, 0x22, 0xde, 0x01, 0x0a, 0x17, 0x46, 0x65, 0x64, 0x65, 0x72, 0x61, 0x74, 0x69,
-	0x6f, 0x6e, 0x46, 0x65, 0x74, 0x63, 0x68, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12,
-	0x33, 0x0a, 0x08, 0x72, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x18, 0x01, 0x20, 0x03, 0x28,
-	0x0b, 0x32, 0x17, 0x2e, 0x43, 0x6f, 0x6e, 0x74, 0x61, 0x63, 0x74, 0x54, 0x72, 0x61, 0x63, 0x69,
-	0x6e, 0x67, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x52, 0x08, 0x72, 0x65, 0x73, 0x70,
-	0x6f, 0x6e, 0x73, 0x65, 0x12, 0x28, 0x0a, 0x0f, 0x70, 0x61, 0x72, 0x74, 0x69, 0x61, 0x6c, 0x52,
-	0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0f, 0x70,
-	0x61, 0x72, 0x74, 0x69, 0x61, 0x6c, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x26,
-	0x0a, 0x0e, 0x6e, 0x65, 0x78, 0x74, 0x46, 0x65, 0x74, 0x63, 0x68, 0x54, 0x6f, 0x6b, 0x65, 0x6e,
-	0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0e, 0x6e, 0x65, 0x78, 0x74, 0x46, 0x65, 0x74, 0x63,
-	0x68, 0x54, 0x6f, 0x6b, 0x65, 0x6e, 0x12, 0x3c, 0x0a, 0x19, 0x66, 0x65, 0x74, 0x63, 0x68, 0x52,
-	0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x4b, 0x65, 0x79, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74,
-	0x61, 0x6d, 0x70, 0x18, 0x04, 0x20, 0x01, 0x28, 0x03, 0x52, 0x19, 0x66, 0x65, 0x74, 0x63, 0x68,
-	0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x4b, 0x65, 0x79, 0x54, 0x69, 0x6d, 0x65, 0x73,
-	0x74, 0x61, 0x6d, 0x70, 0x22, 0x8b, 0x01, 0x0a, 0x16, 0x43, 0x6f, 0x6e, 0x74, 0x61, 0x63, 0x74,
-	0x54, 0x72, 0x61, 0x63, 0x69, 0x6e, 0x67, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12,
-	0x43, 0x0a, 0x12, 0x63, 0x6f, 0x6e, 0x74, 0x61, 0x63, 0x74, 0x54, 0x72, 0x61, 0x63, 0x69, 0x6e,
-	0x67, 0x49, 0x6e, 0x66, 0x6f, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x13, 0x2e, 0x43, 0x6f,
-	0x6e, 0x74, 0x61, 0x63, 0x74, 0x54, 0x72, 0x61, 0x63, 0x69, 0x6e, 0x67, 0x49, 0x6e, 0x66, 0x6f,
-	0x52, 0x12, 0x63, 0x6f, 0x6e, 0x74, 0x61, 0x63, 0x74, 0x54, 0x72, 0x61, 0x63, 0x69, 0x6e, 0x67,
-	0x49, 0x6e, 0x66, 0x6f, 0x12, 0x2c, 0x0a, 0x11, 0x72, 0x65, 0x67, 0x69, 0x6f, 0x6e, 0x49, 0x64,
-	0x65, 0x6e, 0x74, 0x69, 0x66, 0x69, 0x65, 0x72, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x09, 0x52,
-	0x11, 0x72, 0x65, 0x67, 0x69, 0x6f, 0x6e, 0x49, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x66, 0x69, 0x65,
-	0x72, 0x73, 0x22, 0x72, 0x0a, 0x12, 0x43, 0x6f, 0x6e, 0x74, 0x61, 0x63, 0x74, 0x54, 0x72, 0x61,
-	0x63, 0x69, 0x6e, 0x67, 0x49, 0x6e, 0x66, 0x6f, 0x12, 0x2a, 0x0a, 0x10, 0x74, 0x72, 0x61, 0x6e,
-	0x73, 0x6d, 0x69, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x52, 0x69, 0x73, 0x6b, 0x18, 0x01, 0x20, 0x01,
-	0x28, 0x05, 0x52, 0x10, 0x74, 0x72, 0x61, 0x6e, 0x73, 0x6d, 0x69, 0x73, 0x73, 0x69, 0x6f, 0x6e,
-	0x52, 0x69, 0x73, 0x6b, 0x12, 0x30, 0x0a, 0x0c, 0x65, 0x78, 0x70, 0x6f, 0x73, 0x75, 0x72, 0x65,
-	0x4b, 0x65, 0x79, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0c, 0x2e, 0x45, 0x78, 0x70,
-	0x6f, 0x73, 0x75, 0x72, 0x65, 0x4b, 0x65, 0x79, 0x52, 0x0c, 0x65, 0x78, 0x70, 0x6f, 0x73, 0x75,
-	0x72, 0x65, 0x4b, 0x65, 0x79, 0x73, 0x22, 0x7d, 0x0a, 0x0b, 0x45, 0x78, 0x70, 0x6f, 0x73, 0x75,
-	0x72, 0x65, 0x4b, 0x65, 0x79, 0x12, 0x20, 0x0a, 0x0b, 0x65, 0x78, 0x70, 0x6f, 0x73, 0x75, 0x72,
-	0x65, 0x4b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x0b, 0x65, 0x78, 0x70, 0x6f,
-	0x73, 0x75, 0x72, 0x65, 0x4b, 0x65, 0x79, 0x12, 0x26, 0x0a, 0x0e, 0x69, 0x6e, 0x74, 0x65, 0x72,
-	0x76, 0x61, 0x6c, 0x4e, 0x75, 0x6d, 0x62, 0x65, 0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x05, 0x52,
-	0x0e, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x76, 0x61, 0x6c, 0x4e, 0x75, 0x6d, 0x62, 0x65, 0x72, 0x12,
-	0x24, 0x0a, 0x0d, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x76, 0x61, 0x6c, 0x43, 0x6f, 0x75, 0x6e, 0x74,
-	0x18, 0x03, 0x20, 0x01, 0x28, 0x05, 0x52, 0x0d, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x76, 0x61, 0x6c,
-	0x43, 0x6f, 0x75, 0x6e, 0x74, 0x32, 0x4a, 0x0a, 0x0a, 0x46, 0x65, 0x64, 0x65, 0x72, 0x61, 0x74,
-	0x69, 0x6f, 0x6e, 0x12, 0x3c, 0x0a, 0x05, 0x46, 0x65, 0x74, 0x63, 0x68, 0x12, 0x17, 0x2e, 0x46,
+	0x6b, 0x65, 0x6e, 0x12, 0x14, 0x0a, 0x05, 0x64, 0x65, 0x62, 0x75, 0x67, 0x18, 0x06, 0x20, 0x01,
+	0x28, 0x08, 0x52, 0x05, 0x64, 0x65, 0x62, 0x75, 0x67, 0x12, 0x2c, 0x0a, 0x11, 0x69, 0x6e, 0x63,
+	0x6c, 0x75, 0x64, 0x65, 0x54, 0x6f, 0x6d, 0x62, 0x73, 0x74, 0x6f, 0x6e, 0x65, 0x73, 0x18, 0x07,
+	0x20, 0x01, 0x28, 0x08, 0x52, 0x11, 0x69, 0x6e, 0x63, 0x6c, 0x75, 0x64, 0x65, 0x54, 0x6f, 0x6d,
+	0x62, 0x73, 0x74, 0x6f, 0x6e, 0x65, 0x73, 0x12, 0x26, 0x0a, 0x0e, 0x65, 0x78, 0x70, 0x6c, 0x6f,
+	0x64, 0x65, 0x52, 0x65, 0x67, 0x69, 0x6f, 0x6e, 0x73, 0x18, 0x08, 0x20, 0x01, 0x28, 0x08, 0x52,
+	0x0e, 0x65, 0x78, 0x70, 0x6c, 0x6f, 0x64, 0x65, 0x52, 0x65, 0x67, 0x69, 0x6f, 0x6e, 0x73, 0x12,
+	0x24, 0x0a, 0x0d, 0x61, 0x73, 0x4f, 0x66, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70,
+	0x18, 0x09, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0d, 0x61, 0x73, 0x4f, 0x66, 0x54, 0x69, 0x6d, 0x65,
+	0x73, 0x74, 0x61, 0x6d, 0x70, 0x12, 0x32, 0x0a, 0x14, 0x72, 0x65, 0x6c, 0x61, 0x74, 0x69, 0x76,
+	0x65, 0x53, 0x69, 0x6e, 0x63, 0x65, 0x53, 0x65, 0x63, 0x6f, 0x6e, 0x64, 0x73, 0x18, 0x0a, 0x20,
+	0x01, 0x28, 0x03, 0x52, 0x14, 0x72, 0x65, 0x6c, 0x61, 0x74, 0x69, 0x76, 0x65, 0x53, 0x69, 0x6e,
+	0x63, 0x65, 0x53, 0x65, 0x63, 0x6f, 0x6e, 0x64, 0x73, 0x12, 0x20, 0x0a, 0x0b, 0x6f, 0x72, 0x64,
+	0x65, 0x72, 0x42, 0x79, 0x52, 0x69, 0x73, 0x6b, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0b,
+	0x6f, 0x72, 0x64, 0x65, 0x72, 0x42, 0x79, 0x52, 0x69, 0x73, 0x6b, 0x12, 0x36, 0x0a, 0x16, 0x75,
+	0x6e, 0x74, 0x69, 0x6c, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x4f, 0x76, 0x65,
+	0x72, 0x72, 0x69, 0x64, 0x65, 0x18, 0x0c, 0x20, 0x01, 0x28, 0x03, 0x52, 0x16, 0x75, 0x6e, 0x74,
+	0x69, 0x6c, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x4f, 0x76, 0x65, 0x72, 0x72,
+	0x69, 0x64, 0x65, 0x12, 0x1c, 0x0a, 0x09, 0x64, 0x65, 0x64, 0x75, 0x70, 0x4b, 0x65, 0x79, 0x73,
+	0x18, 0x0d, 0x20, 0x01, 0x28, 0x08, 0x52, 0x09, 0x64, 0x65, 0x64, 0x75, 0x70, 0x4b, 0x65, 0x79,
+	0x73, 0x12, 0x1c, 0x0a, 0x09, 0x73, 0x79, 0x6e, 0x63, 0x54, 0x6f, 0x6b, 0x65, 0x6e, 0x18, 0x0e,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x73, 0x79, 0x6e, 0x63, 0x54, 0x6f, 0x6b, 0x65, 0x6e, 0x12,
+	0x2a, 0x0a, 0x10, 0x72, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x53, 0x79, 0x6e, 0x63, 0x54, 0x6f,
+	0x6b, 0x65, 0x6e, 0x18, 0x0f, 0x20, 0x01, 0x28, 0x08, 0x52, 0x10, 0x72, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x53, 0x79, 0x6e, 0x63, 0x54, 0x6f, 0x6b, 0x65, 0x6e, 0x12, 0x28, 0x0a, 0x09, 0x6b,
+	0x6e, 0x6f, 0x77, 0x6e, 0x4b, 0x65, 0x79, 0x73, 0x18, 0x10, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0a,
+	0x2e, 0x4b, 0x65, 0x79, 0x46, 0x69, 0x6c, 0x74, 0x65, 0x72, 0x52, 0x09, 0x6b, 0x6e, 0x6f, 0x77,
+	0x6e, 0x4b, 0x65, 0x79, 0x73, 0x12, 0x30, 0x0a, 0x13, 0x6d, 0x69, 0x6e, 0x54, 0x72, 0x61, 0x6e,
+	0x73, 0x6d, 0x69, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x52, 0x69, 0x73, 0x6b, 0x18, 0x11, 0x20, 0x01,
+	0x28, 0x05, 0x52, 0x13, 0x6d, 0x69, 0x6e, 0x54, 0x72, 0x61, 0x6e, 0x73, 0x6d, 0x69, 0x73, 0x73,
+	0x69, 0x6f, 0x6e, 0x52, 0x69, 0x73, 0x6b, 0x12, 0x22, 0x0a, 0x0c, 0x72, 0x65, 0x76, 0x65, 0x72,
+	0x73, 0x65, 0x4f, 0x72, 0x64, 0x65, 0x72, 0x18, 0x12, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0c, 0x72,
+	0x65, 0x76, 0x65, 0x72, 0x73, 0x65, 0x4f, 0x72, 0x64, 0x65, 0x72, 0x22, 0x3d, 0x0a, 0x09, 0x4b,
+	0x65, 0x79, 0x46, 0x69, 0x6c, 0x74, 0x65, 0x72, 0x12, 0x12, 0x0a, 0x04, 0x62, 0x69, 0x74, 0x73,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x04, 0x62, 0x69, 0x74, 0x73, 0x12, 0x1c, 0x0a, 0x09,
+	0x68, 0x61, 0x73, 0x68, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x05, 0x52,
+	0x09, 0x68, 0x61, 0x73, 0x68, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x22, 0x92, 0x04, 0x0a, 0x17, 0x46,
 	0x65, 0x64, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x46, 0x65, 0x74, 0x63, 0x68, 0x52, 0x65,
-	0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x18, 0x2e, 0x46, 0x65, 0x64, 0x65, 0x72, 0x61, 0x74, 0x69,
-	0x6f, 0x6e, 0x46, 0x65, 0x74, 0x63, 0x68, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22,
-	0x00, 0x42, 0x40, 0x5a, 0x3e, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f,
-	0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2f, 0x65, 0x78, 0x70, 0x6f, 0x73, 0x75, 0x72, 0x65, 0x2d,
-	0x6e, 0x6f, 0x74, 0x69, 0x66, 0x69, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x2d, 0x73, 0x65,
-	0x72, 0x76, 0x65, 0x72, 0x2f, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x6e, 0x61, 0x6c, 0x2f, 0x70, 0x62,
-	0x3b, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x33, 0x0a, 0x08, 0x72, 0x65, 0x73, 0x70, 0x6f, 0x6e,
+	0x73, 0x65, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x17, 0x2e, 0x43, 0x6f, 0x6e, 0x74, 0x61,
+	0x63, 0x74, 0x54, 0x72, 0x61, 0x63, 0x69, 0x6e, 0x67, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73,
+	0x65, 0x52, 0x08, 0x72, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x28, 0x0a, 0x0f, 0x70,
+	0x61, 0x72, 0x74, 0x69, 0x61, 0x6c, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x08, 0x52, 0x0f, 0x70, 0x61, 0x72, 0x74, 0x69, 0x61, 0x6c, 0x52, 0x65, 0x73,
+	0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x26, 0x0a, 0x0e, 0x6e, 0x65, 0x78, 0x74, 0x46, 0x65, 0x74,
+	0x63, 0x68, 0x54, 0x6f, 0x6b, 0x65, 0x6e, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0e, 0x6e,
+	0x65, 0x78, 0x74, 0x46, 0x65, 0x74, 0x63, 0x68, 0x54, 0x6f, 0x6b, 0x65, 0x6e, 0x12, 0x3c, 0x0a,
+	0x19, 0x66, 0x65, 0x74, 0x63, 0x68, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x4b, 0x65,
+	0x79, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x18, 0x04, 0x20, 0x01, 0x28, 0x03,
+	0x52, 0x19, 0x66, 0x65, 0x74, 0x63, 0x68, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x4b,
+	0x65, 0x79, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x12, 0x40, 0x0a, 0x11, 0x65,
+	0x66, 0x66, 0x65, 0x63, 0x74, 0x69, 0x76, 0x65, 0x43, 0x72, 0x69, 0x74, 0x65, 0x72, 0x69, 0x61,
+	0x18, 0x05, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x12, 0x2e, 0x45, 0x66, 0x66, 0x65, 0x63, 0x74, 0x69,
+	0x76, 0x65, 0x43, 0x72, 0x69, 0x74, 0x65, 0x72, 0x69, 0x61, 0x52, 0x11, 0x65, 0x66, 0x66, 0x65,
+	0x63, 0x74, 0x69, 0x76, 0x65, 0x43, 0x72, 0x69, 0x74, 0x65, 0x72, 0x69, 0x61, 0x12, 0x2e, 0x0a,
+	0x0b, 0x72, 0x65, 0x76, 0x6f, 0x6b, 0x65, 0x64, 0x4b, 0x65, 0x79, 0x73, 0x18, 0x06, 0x20, 0x03,
+	0x28, 0x0b, 0x32, 0x0c, 0x2e, 0x45, 0x78, 0x70, 0x6f, 0x73, 0x75, 0x72, 0x65, 0x4b, 0x65, 0x79,
+	0x52, 0x0b, 0x72, 0x65, 0x76, 0x6f, 0x6b, 0x65, 0x64, 0x4b, 0x65, 0x79, 0x73, 0x12, 0x1a, 0x0a,
+	0x08, 0x6b, 0x65, 0x79, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x18, 0x07, 0x20, 0x01, 0x28, 0x03, 0x52,
+	0x08, 0x6b, 0x65, 0x79, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x12, 0x20, 0x0a, 0x0b, 0x72, 0x65, 0x67,
+	0x69, 0x6f, 0x6e, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x18, 0x08, 0x20, 0x01, 0x28, 0x05, 0x52, 0x0b,
+	0x72, 0x65, 0x67, 0x69, 0x6f, 0x6e, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x12, 0x1e, 0x0a, 0x0a, 0x61,
+	0x74, 0x4c, 0x69, 0x76, 0x65, 0x45, 0x64, 0x67, 0x65, 0x18, 0x09, 0x20, 0x01, 0x28, 0x08, 0x52,
+	0x0a, 0x61, 0x74, 0x4c, 0x69, 0x76, 0x65, 0x45, 0x64, 0x67, 0x65, 0x12, 0x1c, 0x0a, 0x09, 0x73,
+	0x79, 0x6e, 0x63, 0x54, 0x6f, 0x6b, 0x65, 0x6e, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09,
+	0x73, 0x79, 0x6e, 0x63, 0x54, 0x6f, 0x6b, 0x65, 0x6e, 0x12, 0x24, 0x0a, 0x0d, 0x6b, 0x6e, 0x6f,
+	0x77, 0x6e, 0x4b, 0x65, 0x79, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x03,
+	0x52, 0x0d, 0x6b, 0x6e, 0x6f, 0x77, 0x6e, 0x4b, 0x65, 0x79, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x12,
+	0x1e, 0x0a, 0x0a, 0x67, 0x65, 0x6e, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x0c, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x0a, 0x67, 0x65, 0x6e, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x22,
+	0xe3, 0x06, 0x0a, 0x11, 0x45, 0x66, 0x66, 0x65, 0x63, 0x74, 0x69, 0x76, 0x65, 0x43, 0x72, 0x69,
+	0x74, 0x65, 0x72, 0x69, 0x61, 0x12, 0x2c, 0x0a, 0x11, 0x72, 0x65, 0x67, 0x69, 0x6f, 0x6e, 0x49,
+	0x64, 0x65, 0x6e, 0x74, 0x69, 0x66, 0x69, 0x65, 0x72, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x09,
+	0x52, 0x11, 0x72, 0x65, 0x67, 0x69, 0x6f, 0x6e, 0x49, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x66, 0x69,
+	0x65, 0x72, 0x73, 0x12, 0x3a, 0x0a, 0x18, 0x65, 0x78, 0x63, 0x6c, 0x75, 0x64, 0x65, 0x52, 0x65,
+	0x67, 0x69, 0x6f, 0x6e, 0x49, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x66, 0x69, 0x65, 0x72, 0x73, 0x18,
+	0x02, 0x20, 0x03, 0x28, 0x09, 0x52, 0x18, 0x65, 0x78, 0x63, 0x6c, 0x75, 0x64, 0x65, 0x52, 0x65,
+	0x67, 0x69, 0x6f, 0x6e, 0x49, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x66, 0x69, 0x65, 0x72, 0x73, 0x12,
+	0x26, 0x0a, 0x0e, 0x73, 0x69, 0x6e, 0x63, 0x65, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d,
+	0x70, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0e, 0x73, 0x69, 0x6e, 0x63, 0x65, 0x54, 0x69,
+	0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x12, 0x26, 0x0a, 0x0e, 0x75, 0x6e, 0x74, 0x69, 0x6c,
+	0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x18, 0x04, 0x20, 0x01, 0x28, 0x03, 0x52,
+	0x0e, 0x75, 0x6e, 0x74, 0x69, 0x6c, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x12,
+	0x20, 0x0a, 0x0b, 0x66, 0x75, 0x6c, 0x6c, 0x52, 0x65, 0x66, 0x72, 0x65, 0x73, 0x68, 0x18, 0x05,
+	0x20, 0x01, 0x28, 0x08, 0x52, 0x0b, 0x66, 0x75, 0x6c, 0x6c, 0x52, 0x65, 0x66, 0x72, 0x65, 0x73,
+	0x68, 0x12, 0x24, 0x0a, 0x0d, 0x73, 0x74, 0x72, 0x69, 0x63, 0x74, 0x45, 0x78, 0x63, 0x6c, 0x75,
+	0x64, 0x65, 0x18, 0x06, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0d, 0x73, 0x74, 0x72, 0x69, 0x63, 0x74,
+	0x45, 0x78, 0x63, 0x6c, 0x75, 0x64, 0x65, 0x12, 0x1c, 0x0a, 0x09, 0x75, 0x6e, 0x63, 0x68, 0x61,
+	0x6e, 0x67, 0x65, 0x64, 0x18, 0x07, 0x20, 0x01, 0x28, 0x08, 0x52, 0x09, 0x75, 0x6e, 0x63, 0x68,
+	0x61, 0x6e, 0x67, 0x65, 0x64, 0x12, 0x2c, 0x0a, 0x11, 0x69, 0x6e, 0x63, 0x6c, 0x75, 0x64, 0x65,
+	0x54, 0x6f, 0x6d, 0x62, 0x73, 0x74, 0x6f, 0x6e, 0x65, 0x73, 0x18, 0x08, 0x20, 0x01, 0x28, 0x08,
+	0x52, 0x11, 0x69, 0x6e, 0x63, 0x6c, 0x75, 0x64, 0x65, 0x54, 0x6f, 0x6d, 0x62, 0x73, 0x74, 0x6f,
+	0x6e, 0x65, 0x73, 0x12, 0x26, 0x0a, 0x0e, 0x65, 0x78, 0x70, 0x6c, 0x6f, 0x64, 0x65, 0x52, 0x65,
+	0x67, 0x69, 0x6f, 0x6e, 0x73, 0x18, 0x09, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0e, 0x65, 0x78, 0x70,
+	0x6c, 0x6f, 0x64, 0x65, 0x52, 0x65, 0x67, 0x69, 0x6f, 0x6e, 0x73, 0x12, 0x24, 0x0a, 0x0d, 0x61,
+	0x73, 0x4f, 0x66, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x18, 0x0a, 0x20, 0x01,
+	0x28, 0x03, 0x52, 0x0d, 0x61, 0x73, 0x4f, 0x66, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d,
+	0x70, 0x12, 0x32, 0x0a, 0x14, 0x72, 0x65, 0x6c, 0x61, 0x74, 0x69, 0x76, 0x65, 0x53, 0x69, 0x6e,
+	0x63, 0x65, 0x53, 0x65, 0x63, 0x6f, 0x6e, 0x64, 0x73, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x03, 0x52,
+	0x14, 0x72, 0x65, 0x6c, 0x61, 0x74, 0x69, 0x76, 0x65, 0x53, 0x69, 0x6e, 0x63, 0x65, 0x53, 0x65,
+	0x63, 0x6f, 0x6e, 0x64, 0x73, 0x12, 0x42, 0x0a, 0x1c, 0x6f, 0x76, 0x65, 0x72, 0x6c, 0x61, 0x70,
+	0x70, 0x69, 0x6e, 0x67, 0x52, 0x65, 0x67, 0x69, 0x6f, 0x6e, 0x49, 0x64, 0x65, 0x6e, 0x74, 0x69,
+	0x66, 0x69, 0x65, 0x72, 0x73, 0x18, 0x0c, 0x20, 0x03, 0x28, 0x09, 0x52, 0x1c, 0x6f, 0x76, 0x65,
+	0x72, 0x6c, 0x61, 0x70, 0x70, 0x69, 0x6e, 0x67, 0x52, 0x65, 0x67, 0x69, 0x6f, 0x6e, 0x49, 0x64,
+	0x65, 0x6e, 0x74, 0x69, 0x66, 0x69, 0x65, 0x72, 0x73, 0x12, 0x2a, 0x0a, 0x10, 0x72, 0x65, 0x67,
+	0x69, 0x6f, 0x6e, 0x50, 0x72, 0x65, 0x63, 0x65, 0x64, 0x65, 0x6e, 0x63, 0x65, 0x18, 0x0d, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x10, 0x72, 0x65, 0x67, 0x69, 0x6f, 0x6e, 0x50, 0x72, 0x65, 0x63, 0x65,
+	0x64, 0x65, 0x6e, 0x63, 0x65, 0x12, 0x36, 0x0a, 0x16, 0x75, 0x6e, 0x74, 0x69, 0x6c, 0x54, 0x69,
+	0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x4f, 0x76, 0x65, 0x72, 0x72, 0x69, 0x64, 0x65, 0x18,
+	0x0e, 0x20, 0x01, 0x28, 0x03, 0x52, 0x16, 0x75, 0x6e, 0x74, 0x69, 0x6c, 0x54, 0x69, 0x6d, 0x65,
+	0x73, 0x74, 0x61, 0x6d, 0x70, 0x4f, 0x76, 0x65, 0x72, 0x72, 0x69, 0x64, 0x65, 0x12, 0x1c, 0x0a,
+	0x09, 0x64, 0x65, 0x64, 0x75, 0x70, 0x4b, 0x65, 0x79, 0x73, 0x18, 0x0f, 0x20, 0x01, 0x28, 0x08,
+	0x52, 0x09, 0x64, 0x65, 0x64, 0x75, 0x70, 0x4b, 0x65, 0x79, 0x73, 0x12, 0x2c, 0x0a, 0x11, 0x6d,
+	0x61, 0x78, 0x4b, 0x65, 0x79, 0x46, 0x69, 0x6c, 0x74, 0x65, 0x72, 0x42, 0x79, 0x74, 0x65, 0x73,
+	0x18, 0x10, 0x20, 0x01, 0x28, 0x05, 0x52, 0x11, 0x6d, 0x61, 0x78, 0x4b, 0x65, 0x79, 0x46, 0x69,
+	0x6c, 0x74, 0x65, 0x72, 0x42, 0x79, 0x74, 0x65, 0x73, 0x12, 0x34, 0x0a, 0x15, 0x6d, 0x61, 0x78,
+	0x4b, 0x65, 0x79, 0x46, 0x69, 0x6c, 0x74, 0x65, 0x72, 0x48, 0x61, 0x73, 0x68, 0x43, 0x6f, 0x75,
+	0x6e, 0x74, 0x18, 0x11, 0x20, 0x01, 0x28, 0x05, 0x52, 0x15, 0x6d, 0x61, 0x78, 0x4b, 0x65, 0x79,
+	0x46, 0x69, 0x6c, 0x74, 0x65, 0x72, 0x48, 0x61, 0x73, 0x68, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x12,
+	0x30, 0x0a, 0x13, 0x6d, 0x69, 0x6e, 0x54, 0x72, 0x61, 0x6e, 0x73, 0x6d, 0x69, 0x73, 0x73, 0x69,
+	0x6f, 0x6e, 0x52, 0x69, 0x73, 0x6b, 0x18, 0x12, 0x20, 0x01, 0x28, 0x05, 0x52, 0x13, 0x6d, 0x69,
+	0x6e, 0x54, 0x72, 0x61, 0x6e, 0x73, 0x6d, 0x69, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x52, 0x69, 0x73,
+	0x6b, 0x12, 0x22, 0x0a, 0x0c, 0x72, 0x65, 0x76, 0x65, 0x72, 0x73, 0x65, 0x4f, 0x72, 0x64, 0x65,
+	0x72, 0x18, 0x13, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0c, 0x72, 0x65, 0x76, 0x65, 0x72, 0x73, 0x65,
+	0x4f, 0x72, 0x64, 0x65, 0x72, 0x22, 0x8b, 0x01, 0x0a, 0x16, 0x43, 0x6f, 0x6e, 0x74, 0x61, 0x63,
+	0x74, 0x54, 0x72, 0x61, 0x63, 0x69, 0x6e, 0x67, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65,
+	0x12, 0x43, 0x0a, 0x12, 0x63, 0x6f, 0x6e, 0x74, 0x61, 0x63, 0x74, 0x54, 0x72, 0x61, 0x63, 0x69,
+	0x6e, 0x67, 0x49, 0x6e, 0x66, 0x6f, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x13, 0x2e, 0x43,
+	0x6f, 0x6e, 0x74, 0x61, 0x63, 0x74, 0x54, 0x72, 0x61, 0x63, 0x69, 0x6e, 0x67, 0x49, 0x6e, 0x66,
+	0x6f, 0x52, 0x12, 0x63, 0x6f, 0x6e, 0x74, 0x61, 0x63, 0x74, 0x54, 0x72, 0x61, 0x63, 0x69, 0x6e,
+	0x67, 0x49, 0x6e, 0x66, 0x6f, 0x12, 0x2c, 0x0a, 0x11, 0x72, 0x65, 0x67, 0x69, 0x6f, 0x6e, 0x49,
+	0x64, 0x65, 0x6e, 0x74, 0x69, 0x66, 0x69, 0x65, 0x72, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x09,
+	0x52, 0x11, 0x72, 0x65, 0x67, 0x69, 0x6f, 0x6e, 0x49, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x66, 0x69,
+	0x65, 0x72, 0x73, 0x22, 0x72, 0x0a, 0x12, 0x43, 0x6f, 0x6e, 0x74, 0x61, 0x63, 0x74, 0x54, 0x72,
+	0x61, 0x63, 0x69, 0x6e, 0x67, 0x49, 0x6e, 0x66, 0x6f, 0x12, 0x2a, 0x0a, 0x10, 0x74, 0x72, 0x61,
+	0x6e, 0x73, 0x6d, 0x69, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x52, 0x69, 0x73, 0x6b, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x05, 0x52, 0x10, 0x74, 0x72, 0x61, 0x6e, 0x73, 0x6d, 0x69, 0x73, 0x73, 0x69, 0x6f,
+	0x6e, 0x52, 0x69, 0x73, 0x6b, 0x12, 0x30, 0x0a, 0x0c, 0x65, 0x78, 0x70, 0x6f, 0x73, 0x75, 0x72,
+	0x65, 0x4b, 0x65, 0x79, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0c, 0x2e, 0x45, 0x78,
+	0x70, 0x6f, 0x73, 0x75, 0x72, 0x65, 0x4b, 0x65, 0x79, 0x52, 0x0c, 0x65, 0x78, 0x70, 0x6f, 0x73,
+	0x75, 0x72, 0x65, 0x4b, 0x65, 0x79, 0x73, 0x22, 0x7d, 0x0a, 0x0b, 0x45, 0x78, 0x70, 0x6f, 0x73,
+	0x75, 0x72, 0x65, 0x4b, 0x65, 0x79, 0x12, 0x20, 0x0a, 0x0b, 0x65, 0x78, 0x70, 0x6f, 0x73, 0x75,
+	0x72, 0x65, 0x4b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x0b, 0x65, 0x78, 0x70,
+	0x6f, 0x73, 0x75, 0x72, 0x65, 0x4b, 0x65, 0x79, 0x12, 0x26, 0x0a, 0x0e, 0x69, 0x6e, 0x74, 0x65,
+	0x72, 0x76, 0x61, 0x6c, 0x4e, 0x75, 0x6d, 0x62, 0x65, 0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x05,
+	0x52, 0x0e, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x76, 0x61, 0x6c, 0x4e, 0x75, 0x6d, 0x62, 0x65, 0x72,
+	0x12, 0x24, 0x0a, 0x0d, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x76, 0x61, 0x6c, 0x43, 0x6f, 0x75, 0x6e,
+	0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x05, 0x52, 0x0d, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x76, 0x61,
+	0x6c, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x22, 0xb5, 0x01, 0x0a, 0x0f, 0x43, 0x68, 0x65, 0x63, 0x6b,
+	0x73, 0x75, 0x6d, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x2c, 0x0a, 0x11, 0x72, 0x65,
+	0x67, 0x69, 0x6f, 0x6e, 0x49, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x66, 0x69, 0x65, 0x72, 0x73, 0x18,
+	0x01, 0x20, 0x03, 0x28, 0x09, 0x52, 0x11, 0x72, 0x65, 0x67, 0x69, 0x6f, 0x6e, 0x49, 0x64, 0x65,
+	0x6e, 0x74, 0x69, 0x66, 0x69, 0x65, 0x72, 0x73, 0x12, 0x26, 0x0a, 0x0e, 0x73, 0x69, 0x6e, 0x63,
+	0x65, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03,
+	0x52, 0x0e, 0x73, 0x69, 0x6e, 0x63, 0x65, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70,
+	0x12, 0x26, 0x0a, 0x0e, 0x75, 0x6e, 0x74, 0x69, 0x6c, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61,
+	0x6d, 0x70, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0e, 0x75, 0x6e, 0x74, 0x69, 0x6c, 0x54,
+	0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x12, 0x24, 0x0a, 0x0d, 0x62, 0x75, 0x63, 0x6b,
+	0x65, 0x74, 0x53, 0x65, 0x63, 0x6f, 0x6e, 0x64, 0x73, 0x18, 0x04, 0x20, 0x01, 0x28, 0x03, 0x52,
+	0x0d, 0x62, 0x75, 0x63, 0x6b, 0x65, 0x74, 0x53, 0x65, 0x63, 0x6f, 0x6e, 0x64, 0x73, 0x22, 0x65,
+	0x0a, 0x10, 0x43, 0x68, 0x65, 0x63, 0x6b, 0x73, 0x75, 0x6d, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e,
+	0x73, 0x65, 0x12, 0x29, 0x0a, 0x07, 0x72, 0x65, 0x67, 0x69, 0x6f, 0x6e, 0x73, 0x18, 0x01, 0x20,
+	0x03, 0x28, 0x0b, 0x32, 0x0f, 0x2e, 0x52, 0x65, 0x67, 0x69, 0x6f, 0x6e, 0x43, 0x68, 0x65, 0x63,
+	0x6b, 0x73, 0x75, 0x6d, 0x52, 0x07, 0x72, 0x65, 0x67, 0x69, 0x6f, 0x6e, 0x73, 0x12, 0x26, 0x0a,
+	0x0e, 0x75, 0x6e, 0x74, 0x69, 0x6c, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0e, 0x75, 0x6e, 0x74, 0x69, 0x6c, 0x54, 0x69, 0x6d, 0x65,
+	0x73, 0x74, 0x61, 0x6d, 0x70, 0x22, 0x5f, 0x0a, 0x0e, 0x52, 0x65, 0x67, 0x69, 0x6f, 0x6e, 0x43,
+	0x68, 0x65, 0x63, 0x6b, 0x73, 0x75, 0x6d, 0x12, 0x2a, 0x0a, 0x10, 0x72, 0x65, 0x67, 0x69, 0x6f,
+	0x6e, 0x49, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x66, 0x69, 0x65, 0x72, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x10, 0x72, 0x65, 0x67, 0x69, 0x6f, 0x6e, 0x49, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x66,
+	0x69, 0x65, 0x72, 0x12, 0x21, 0x0a, 0x04, 0x72, 0x6f, 0x6f, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28,
+	0x0b, 0x32, 0x0d, 0x2e, 0x43, 0x68, 0x65, 0x63, 0x6b, 0x73, 0x75, 0x6d, 0x4e, 0x6f, 0x64, 0x65,
+	0x52, 0x04, 0x72, 0x6f, 0x6f, 0x74, 0x22, 0xbd, 0x01, 0x0a, 0x0c, 0x43, 0x68, 0x65, 0x63, 0x6b,
+	0x73, 0x75, 0x6d, 0x4e, 0x6f, 0x64, 0x65, 0x12, 0x26, 0x0a, 0x0e, 0x73, 0x74, 0x61, 0x72, 0x74,
+	0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52,
+	0x0e, 0x73, 0x74, 0x61, 0x72, 0x74, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x12,
+	0x22, 0x0a, 0x0c, 0x65, 0x6e, 0x64, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0c, 0x65, 0x6e, 0x64, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74,
+	0x61, 0x6d, 0x70, 0x12, 0x1a, 0x0a, 0x08, 0x6b, 0x65, 0x79, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x18,
+	0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x08, 0x6b, 0x65, 0x79, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x12,
+	0x1a, 0x0a, 0x08, 0x63, 0x68, 0x65, 0x63, 0x6b, 0x73, 0x75, 0x6d, 0x18, 0x04, 0x20, 0x01, 0x28,
+	0x0c, 0x52, 0x08, 0x63, 0x68, 0x65, 0x63, 0x6b, 0x73, 0x75, 0x6d, 0x12, 0x29, 0x0a, 0x08, 0x63,
+	0x68, 0x69, 0x6c, 0x64, 0x72, 0x65, 0x6e, 0x18, 0x05, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0d, 0x2e,
+	0x43, 0x68, 0x65, 0x63, 0x6b, 0x73, 0x75, 0x6d, 0x4e, 0x6f, 0x64, 0x65, 0x52, 0x08, 0x63, 0x68,
+	0x69, 0x6c, 0x64, 0x72, 0x65, 0x6e, 0x22, 0x40, 0x0a, 0x10, 0x52, 0x65, 0x74, 0x65, 0x6e, 0x74,
+	0x69, 0x6f, 0x6e, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x2c, 0x0a, 0x11, 0x72, 0x65,
+	0x67, 0x69, 0x6f, 0x6e, 0x49, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x66, 0x69, 0x65, 0x72, 0x73, 0x18,
+	0x01, 0x20, 0x03, 0x28, 0x09, 0x52, 0x11, 0x72, 0x65, 0x67, 0x69, 0x6f, 0x6e, 0x49, 0x64, 0x65,
+	0x6e, 0x74, 0x69, 0x66, 0x69, 0x65, 0x72, 0x73, 0x22, 0x3f, 0x0a, 0x11, 0x52, 0x65, 0x74, 0x65,
+	0x6e, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x2a, 0x0a,
+	0x07, 0x72, 0x65, 0x67, 0x69, 0x6f, 0x6e, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x10,
+	0x2e, 0x52, 0x65, 0x67, 0x69, 0x6f, 0x6e, 0x52, 0x65, 0x74, 0x65, 0x6e, 0x74, 0x69, 0x6f, 0x6e,
+	0x52, 0x07, 0x72, 0x65, 0x67, 0x69, 0x6f, 0x6e, 0x73, 0x22, 0x91, 0x01, 0x0a, 0x0f, 0x52, 0x65,
+	0x67, 0x69, 0x6f, 0x6e, 0x52, 0x65, 0x74, 0x65, 0x6e, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x2a, 0x0a,
+	0x10, 0x72, 0x65, 0x67, 0x69, 0x6f, 0x6e, 0x49, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x66, 0x69, 0x65,
+	0x72, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x10, 0x72, 0x65, 0x67, 0x69, 0x6f, 0x6e, 0x49,
+	0x64, 0x65, 0x6e, 0x74, 0x69, 0x66, 0x69, 0x65, 0x72, 0x12, 0x28, 0x0a, 0x0f, 0x6f, 0x6c, 0x64,
+	0x65, 0x73, 0x74, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x03, 0x52, 0x0f, 0x6f, 0x6c, 0x64, 0x65, 0x73, 0x74, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74,
+	0x61, 0x6d, 0x70, 0x12, 0x28, 0x0a, 0x0f, 0x6c, 0x61, 0x74, 0x65, 0x73, 0x74, 0x54, 0x69, 0x6d,
+	0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0f, 0x6c, 0x61,
+	0x74, 0x65, 0x73, 0x74, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x22, 0xcf, 0x01,
+	0x0a, 0x17, 0x46, 0x65, 0x64, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x55, 0x70, 0x6c, 0x6f,
+	0x61, 0x64, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x2c, 0x0a, 0x11, 0x72, 0x65, 0x67,
+	0x69, 0x6f, 0x6e, 0x49, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x66, 0x69, 0x65, 0x72, 0x73, 0x18, 0x01,
+	0x20, 0x03, 0x28, 0x09, 0x52, 0x11, 0x72, 0x65, 0x67, 0x69, 0x6f, 0x6e, 0x49, 0x64, 0x65, 0x6e,
+	0x74, 0x69, 0x66, 0x69, 0x65, 0x72, 0x73, 0x12, 0x2a, 0x0a, 0x10, 0x74, 0x72, 0x61, 0x6e, 0x73,
+	0x6d, 0x69, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x52, 0x69, 0x73, 0x6b, 0x18, 0x02, 0x20, 0x01, 0x28,
+	0x05, 0x52, 0x10, 0x74, 0x72, 0x61, 0x6e, 0x73, 0x6d, 0x69, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x52,
+	0x69, 0x73, 0x6b, 0x12, 0x2e, 0x0a, 0x0b, 0x65, 0x78, 0x70, 0x6f, 0x73, 0x75, 0x72, 0x65, 0x4b,
+	0x65, 0x79, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0c, 0x2e, 0x45, 0x78, 0x70, 0x6f, 0x73,
+	0x75, 0x72, 0x65, 0x4b, 0x65, 0x79, 0x52, 0x0b, 0x65, 0x78, 0x70, 0x6f, 0x73, 0x75, 0x72, 0x65,
+	0x4b, 0x65, 0x79, 0x12, 0x2a, 0x0a, 0x10, 0x63, 0x72, 0x65, 0x61, 0x74, 0x65, 0x64, 0x54, 0x69,
+	0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x18, 0x04, 0x20, 0x01, 0x28, 0x03, 0x52, 0x10, 0x63,
+	0x72, 0x65, 0x61, 0x74, 0x65, 0x64, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x22,
+	0x70, 0x0a, 0x18, 0x46, 0x65, 0x64, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x55, 0x70, 0x6c,
+	0x6f, 0x61, 0x64, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x1a, 0x0a, 0x08, 0x61,
+	0x63, 0x63, 0x65, 0x70, 0x74, 0x65, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x08, 0x61,
+	0x63, 0x63, 0x65, 0x70, 0x74, 0x65, 0x64, 0x12, 0x1a, 0x0a, 0x08, 0x72, 0x65, 0x6a, 0x65, 0x63,
+	0x74, 0x65, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x08, 0x72, 0x65, 0x6a, 0x65, 0x63,
+	0x74, 0x65, 0x64, 0x12, 0x1c, 0x0a, 0x09, 0x64, 0x75, 0x70, 0x6c, 0x69, 0x63, 0x61, 0x74, 0x65,
+	0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x09, 0x64, 0x75, 0x70, 0x6c, 0x69, 0x63, 0x61, 0x74,
+	0x65, 0x32, 0xf6, 0x01, 0x0a, 0x0a, 0x46, 0x65, 0x64, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e,
+	0x12, 0x3c, 0x0a, 0x05, 0x46, 0x65, 0x74, 0x63, 0x68, 0x12, 0x17, 0x2e, 0x46, 0x65, 0x64, 0x65,
+	0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x46, 0x65, 0x74, 0x63, 0x68, 0x52, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x1a, 0x18, 0x2e, 0x46, 0x65, 0x64, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x46,
+	0x65, 0x74, 0x63, 0x68, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00, 0x12, 0x31,
+	0x0a, 0x08, 0x43, 0x68, 0x65, 0x63, 0x6b, 0x73, 0x75, 0x6d, 0x12, 0x10, 0x2e, 0x43, 0x68, 0x65,
+	0x63, 0x6b, 0x73, 0x75, 0x6d, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x11, 0x2e, 0x43,
+	0x68, 0x65, 0x63, 0x6b, 0x73, 0x75, 0x6d, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22,
+	0x00, 0x12, 0x34, 0x0a, 0x09, 0x52, 0x65, 0x74, 0x65, 0x6e, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x11,
+	0x2e, 0x52, 0x65, 0x74, 0x65, 0x6e, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73,
+	0x74, 0x1a, 0x12, 0x2e, 0x52, 0x65, 0x74, 0x65, 0x6e, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x65, 0x73,
+	0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00, 0x12, 0x41, 0x0a, 0x06, 0x55, 0x70, 0x6c, 0x6f, 0x61,
+	0x64, 0x12, 0x18, 0x2e, 0x46, 0x65, 0x64, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x55, 0x70,
+	0x6c, 0x6f, 0x61, 0x64, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x19, 0x2e, 0x46, 0x65,
+	0x64, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x52, 0x65,
+	0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00, 0x28, 0x01, 0x42, 0x40, 0x5a, 0x3e, 0x67, 0x69,
+	0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2f,
+	0x65, 0x78, 0x70, 0x6f, 0x73, 0x75, 0x72, 0x65, 0x2d, 0x6e, 0x6f, 0x74, 0x69, 0x66, 0x69, 0x63,
+	0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x2d, 0x73, 0x65, 0x72, 0x76, 0x65, 0x72, 0x2f, 0x69, 0x6e,
+	0x74, 0x65, 0x72, 0x6e, 0x61, 0x6c, 0x2f, 0x70, 0x62, 0x3b, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -457,25 +1731,50 @@ func file_internal_pb_federation_proto_rawDescGZIP() []byte {
 	return file_internal_pb_federation_proto_rawDescData
 }
 
-var file_internal_pb_federation_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
+var file_internal_pb_federation_proto_msgTypes = make([]protoimpl.MessageInfo, 16)
 var file_internal_pb_federation_proto_goTypes = []interface{}{
-	(*FederationFetchRequest)(nil),  // 0: FederationFetchRequest
-	(*FederationFetchResponse)(nil), // 1: FederationFetchResponse
-	(*ContactTracingResponse)(nil),  // 2: ContactTracingResponse
-	(*ContactTracingInfo)(nil),      // 3: ContactTracingInfo
-	(*ExposureKey)(nil),             // 4: ExposureKey
+	(*FederationFetchRequest)(nil),   // 0: FederationFetchRequest
+	(*KeyFilter)(nil),                // 1: KeyFilter
+	(*FederationFetchResponse)(nil),  // 2: FederationFetchResponse
+	(*EffectiveCriteria)(nil),        // 3: EffectiveCriteria
+	(*ContactTracingResponse)(nil),   // 4: ContactTracingResponse
+	(*ContactTracingInfo)(nil),       // 5: ContactTracingInfo
+	(*ExposureKey)(nil),              // 6: ExposureKey
+	(*ChecksumRequest)(nil),          // 7: ChecksumRequest
+	(*ChecksumResponse)(nil),         // 8: ChecksumResponse
+	(*RegionChecksum)(nil),           // 9: RegionChecksum
+	(*ChecksumNode)(nil),             // 10: ChecksumNode
+	(*RetentionRequest)(nil),         // 11: RetentionRequest
+	(*RetentionResponse)(nil),        // 12: RetentionResponse
+	(*RegionRetention)(nil),          // 13: RegionRetention
+	(*FederationUploadRequest)(nil),  // 14: FederationUploadRequest
+	(*FederationUploadResponse)(nil), // 15: FederationUploadResponse
 }
 var file_internal_pb_federation_proto_depIdxs = []int32{
-	2, // 0: FederationFetchResponse.response:type_name -> ContactTracingResponse
-	3, // 1: ContactTracingResponse.contactTracingInfo:type_name -> ContactTracingInfo
-	4, // 2: ContactTracingInfo.exposureKeys:type_name -> ExposureKey
-	0, // 3: Federation.Fetch:input_type -> FederationFetchRequest
-	1, // 4: Federation.Fetch:output_type -> FederationFetchResponse
-	4, // [4:5] is the sub-list for method output_type
-	3, // [3:4] is the sub-list for method input_type
-	3, // [3:3] is the sub-list for extension type_name
-	3, // [3:3] is the sub-list for extension extendee
-	0, // [0:3] is the sub-list for field type_name
+	1,  // 0: FederationFetchRequest.knownKeys:type_name -> KeyFilter
+	4,  // 1: FederationFetchResponse.response:type_name -> ContactTracingResponse
+	3,  // 2: FederationFetchResponse.effectiveCriteria:type_name -> EffectiveCriteria
+	6,  // 3: FederationFetchResponse.revokedKeys:type_name -> ExposureKey
+	5,  // 4: ContactTracingResponse.contactTracingInfo:type_name -> ContactTracingInfo
+	6,  // 5: ContactTracingInfo.exposureKeys:type_name -> ExposureKey
+	9,  // 6: ChecksumResponse.regions:type_name -> RegionChecksum
+	10, // 7: RegionChecksum.root:type_name -> ChecksumNode
+	10, // 8: ChecksumNode.children:type_name -> ChecksumNode
+	13, // 9: RetentionResponse.regions:type_name -> RegionRetention
+	6,  // 10: FederationUploadRequest.exposureKey:type_name -> ExposureKey
+	0,  // 11: Federation.Fetch:input_type -> FederationFetchRequest
+	7,  // 12: Federation.Checksum:input_type -> ChecksumRequest
+	11, // 13: Federation.Retention:input_type -> RetentionRequest
+	14, // 14: Federation.Upload:input_type -> FederationUploadRequest
+	2,  // 15: Federation.Fetch:output_type -> FederationFetchResponse
+	8,  // 16: Federation.Checksum:output_type -> ChecksumResponse
+	12, // 17: Federation.Retention:output_type -> RetentionResponse
+	15, // 18: Federation.Upload:output_type -> FederationUploadResponse
+	15, // [15:19] is the sub-list for method output_type
+	11, // [11:15] is the sub-list for method input_type
+	11, // [11:11] is the sub-list for extension type_name
+	11, // [11:11] is the sub-list for extension extendee
+	0,  // [0:11] is the sub-list for field type_name
 }
 
 func init() { file_internal_pb_federation_proto_init() }
@@ -497,7 +1796,7 @@ func file_internal_pb_federation_proto_init() {
 			}
 		}
 		file_internal_pb_federation_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*FederationFetchResponse); i {
+			switch v := v.(*KeyFilter); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -509,7 +1808,7 @@ func file_internal_pb_federation_proto_init() {
 			}
 		}
 		file_internal_pb_federation_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*ContactTracingResponse); i {
+			switch v := v.(*FederationFetchResponse); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -521,7 +1820,7 @@ func file_internal_pb_federation_proto_init() {
 			}
 		}
 		file_internal_pb_federation_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*ContactTracingInfo); i {
+			switch v := v.(*EffectiveCriteria); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -533,6 +1832,30 @@ func file_internal_pb_federation_proto_init() {
 			}
 		}
 		file_internal_pb_federation_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ContactTracingResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_internal_pb_federation_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ContactTracingInfo); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_internal_pb_federation_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
 			switch v := v.(*ExposureKey); i {
 			case 0:
 				return &v.state
@@ -544,6 +1867,114 @@ func file_internal_pb_federation_proto_init() {
 				return nil
 			}
 		}
+		file_internal_pb_federation_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ChecksumRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_internal_pb_federation_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ChecksumResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_internal_pb_federation_proto_msgTypes[9].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*RegionChecksum); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_internal_pb_federation_proto_msgTypes[10].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ChecksumNode); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_internal_pb_federation_proto_msgTypes[11].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*RetentionRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_internal_pb_federation_proto_msgTypes[12].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*RetentionResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_internal_pb_federation_proto_msgTypes[13].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*RegionRetention); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_internal_pb_federation_proto_msgTypes[14].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*FederationUploadRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_internal_pb_federation_proto_msgTypes[15].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*FederationUploadResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
@@ -551,7 +1982,7 @@ func file_internal_pb_federation_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_internal_pb_federation_proto_rawDesc,
 			NumEnums:      0,
-			NumMessages:   5,
+			NumMessages:   16,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -578,6 +2009,11 @@ const _ = grpc.SupportPackageIsVersion6
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
 type FederationClient interface {
 	Fetch(ctx context.Context, in *FederationFetchRequest, opts ...grpc.CallOption) (*FederationFetchResponse, error)
+	Checksum(ctx context.Context, in *ChecksumRequest, opts ...grpc.CallOption) (*ChecksumResponse, error)
+	// Retention reports how far back keys can be fetched in each region.
+	Retention(ctx context.Context, in *RetentionRequest, opts ...grpc.CallOption) (*RetentionResponse, error)
+	// Upload stores keys streamed by a partner, as an alternative to the server fetching them.
+	Upload(ctx context.Context, opts ...grpc.CallOption) (Federation_UploadClient, error)
 }
 
 type federationClient struct {
@@ -597,9 +2033,66 @@ func (c *federationClient) Fetch(ctx context.Context, in *FederationFetchRequest
 	return out, nil
 }
 
+func (c *federationClient) Checksum(ctx context.Context, in *ChecksumRequest, opts ...grpc.CallOption) (*ChecksumResponse, error) {
+	out := new(ChecksumResponse)
+	err := c.cc.Invoke(ctx, "/Federation/Checksum", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *federationClient) Retention(ctx context.Context, in *RetentionRequest, opts ...grpc.CallOption) (*RetentionResponse, error) {
+	out := new(RetentionResponse)
+	err := c.cc.Invoke(ctx, "/Federation/Retention", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *federationClient) Upload(ctx context.Context, opts ...grpc.CallOption) (Federation_UploadClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Federation_serviceDesc.Streams[0], "/Federation/Upload", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &federationUploadClient{stream}
+	return x, nil
+}
+
+type Federation_UploadClient interface {
+	Send(*FederationUploadRequest) error
+	CloseAndRecv() (*FederationUploadResponse, error)
+	grpc.ClientStream
+}
+
+type federationUploadClient struct {
+	grpc.ClientStream
+}
+
+func (x *federationUploadClient) Send(m *FederationUploadRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *federationUploadClient) CloseAndRecv() (*FederationUploadResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(FederationUploadResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // FederationServer is the server API for Federation service.
 type FederationServer interface {
 	Fetch(context.Context, *FederationFetchRequest) (*FederationFetchResponse, error)
+	Checksum(context.Context, *ChecksumRequest) (*ChecksumResponse, error)
+	// Retention reports how far back keys can be fetched in each region.
+	Retention(context.Context, *RetentionRequest) (*RetentionResponse, error)
+	// Upload stores keys streamed by a partner, as an alternative to the server fetching them.
+	Upload(Federation_UploadServer) error
 }
 
 // UnimplementedFederationServer can be embedded to have forward compatible implementations.
@@ -609,6 +2102,15 @@ type UnimplementedFederationServer struct {
 func (*UnimplementedFederationServer) Fetch(context.Context, *FederationFetchRequest) (*FederationFetchResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Fetch not implemented")
 }
+func (*UnimplementedFederationServer) Checksum(context.Context, *ChecksumRequest) (*ChecksumResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Checksum not implemented")
+}
+func (*UnimplementedFederationServer) Retention(context.Context, *RetentionRequest) (*RetentionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Retention not implemented")
+}
+func (*UnimplementedFederationServer) Upload(Federation_UploadServer) error {
+	return status.Errorf(codes.Unimplemented, "method Upload not implemented")
+}
 
 func RegisterFederationServer(s *grpc.Server, srv FederationServer) {
 	s.RegisterService(&_Federation_serviceDesc, srv)
@@ -632,6 +2134,68 @@ func _Federation_Fetch_Handler(srv interface{}, ctx context.Context, dec func(in
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Federation_Checksum_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ChecksumRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FederationServer).Checksum(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Federation/Checksum",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FederationServer).Checksum(ctx, req.(*ChecksumRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Federation_Retention_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RetentionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FederationServer).Retention(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Federation/Retention",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FederationServer).Retention(ctx, req.(*RetentionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Federation_Upload_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(FederationServer).Upload(&federationUploadServer{stream})
+}
+
+type Federation_UploadServer interface {
+	SendAndClose(*FederationUploadResponse) error
+	Recv() (*FederationUploadRequest, error)
+	grpc.ServerStream
+}
+
+type federationUploadServer struct {
+	grpc.ServerStream
+}
+
+func (x *federationUploadServer) SendAndClose(m *FederationUploadResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *federationUploadServer) Recv() (*FederationUploadRequest, error) {
+	m := new(FederationUploadRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 var _Federation_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "Federation",
 	HandlerType: (*FederationServer)(nil),
@@ -640,7 +2204,21 @@ var _Federation_serviceDesc = grpc.ServiceDesc{
 			MethodName: "Fetch",
 			Handler:    _Federation_Fetch_Handler,
 		},
+		{
+			MethodName: "Checksum",
+			Handler:    _Federation_Checksum_Handler,
+		},
+		{
+			MethodName: "Retention",
+			Handler:    _Federation_Retention_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Upload",
+			Handler:       _Federation_Upload_Handler,
+			ClientStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "internal/pb/federation.proto",
 }
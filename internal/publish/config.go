@@ -19,13 +19,16 @@ import (
 	"time"
 
 	"github.com/google/exposure-notifications-server/internal/authorizedapp"
+	"github.com/google/exposure-notifications-server/internal/blocklist"
 	"github.com/google/exposure-notifications-server/internal/database"
+	publishdb "github.com/google/exposure-notifications-server/internal/publish/database"
 	"github.com/google/exposure-notifications-server/internal/secrets"
 	"github.com/google/exposure-notifications-server/internal/setup"
 )
 
 // Compile-time check to assert this config matches requirements.
 var _ setup.AuthorizedAppConfigProvider = (*Config)(nil)
+var _ setup.BlocklistConfigProvider = (*Config)(nil)
 var _ setup.DatabaseConfigProvider = (*Config)(nil)
 var _ setup.SecretManagerConfigProvider = (*Config)(nil)
 
@@ -33,15 +36,83 @@ var _ setup.SecretManagerConfigProvider = (*Config)(nil)
 // the publish components.
 type Config struct {
 	AuthorizedApp authorizedapp.Config
+	Blocklist     blocklist.Config
 	Database      database.Config
 	SecretManager secrets.Config
 
+	// RegionKeyLimits bounds how many keys are accepted per region per day.
+	// Publishes which would exceed a limit are rejected with 429.
+	RegionKeyLimits publishdb.RegionKeyLimits
+
+	// GroupInsertsByRegion, if true, inserts the keys of a publish as one
+	// database batch per set of regions, rather than one statement per key.
+	GroupInsertsByRegion bool `envconfig:"GROUP_INSERTS_BY_REGION" default:"false"`
+
 	Port               string        `envconfig:"PORT" default:"8080"`
 	MinRequestDuration time.Duration `envconfig:"TARGET_REQUEST_DURATION" default:"5s"`
 	MaxKeysOnPublish   int           `envconfig:"MAX_KEYS_ON_PUBLISH" default:"15"`
 	MaxIntervalAge     time.Duration `envconfig:"MAX_INTERVAL_AGE_ON_PUBLISH" default:"360h"`
 	TruncateWindow     time.Duration `envconfig:"TRUNCATE_WINDOW" default:"1h"`
 
+	// StrictIntervalCount rejects keys which started before the current UTC day
+	// unless they have an IntervalCount of 144.
+	StrictIntervalCount bool `envconfig:"STRICT_INTERVAL_COUNT" default:"false"`
+
+	// MaxIntervalSpan, if non-zero, rejects publishes whose keys cover more
+	// than this from the start of the earliest to the end of the latest. A
+	// device's keys normally cover about 14 days.
+	MaxIntervalSpan time.Duration `envconfig:"MAX_INTERVAL_SPAN_ON_PUBLISH"`
+
+	// MaxKeyAge, if non-zero, rejects publishes containing a key which ended
+	// more than this long ago. It should match the cleanup TTL, since older
+	// keys would only be skipped and deleted. Keys partially within it are
+	// accepted.
+	MaxKeyAge time.Duration `envconfig:"MAX_KEY_AGE_ON_PUBLISH" default:"336h"`
+
+	// MissingIntervalCount controls keys published with no IntervalCount.
+	// "reject" rejects the publish, "skip" drops the key, and "default" gives a
+	// key which started at least a day ago an IntervalCount of 144, dropping
+	// more recent ones.
+	MissingIntervalCount string `envconfig:"MISSING_INTERVAL_COUNT" default:"reject"`
+
+	// IntervalAlignment controls full day keys, with an IntervalCount of 144,
+	// whose IntervalNumber is not on a UTC day boundary. "off" accepts them,
+	// "reject" rejects the publish, and "snap" moves the key to the nearest
+	// day boundary.
+	IntervalAlignment string `envconfig:"INTERVAL_ALIGNMENT" default:"off"`
+
+	// MaxRegionsOnPublish rejects publishes listing more regions than this, so
+	// that a malformed upload cannot store keys which are expensive to serve.
+	// Zero, the default, means no limit.
+	MaxRegionsOnPublish int `envconfig:"MAX_REGIONS_ON_PUBLISH" default:"0"`
+
+	// VerificationOutagePolicy controls uploads whose diagnosis certificate
+	// cannot be verified because the verification backend is unavailable.
+	// "fail-closed" rejects them so that the client retries later. "fail-open"
+	// accepts them with Exposure.Unverified set, so that they can be
+	// quarantined.
+	VerificationOutagePolicy string `envconfig:"VERIFICATION_OUTAGE_POLICY" default:"fail-closed"`
+
+	// VerifiedKeyRetention and UnverifiedKeyRetention, if non-zero, set how
+	// long after publication a key expires, by whether its diagnosis
+	// certificate was verified. Expired keys are no longer served or exported
+	// and are deleted by the cleanup job ahead of the global TTL.
+	VerifiedKeyRetention   time.Duration `envconfig:"VERIFIED_KEY_RETENTION"`
+	UnverifiedKeyRetention time.Duration `envconfig:"UNVERIFIED_KEY_RETENTION"`
+
+	// IdempotencyKeyTTL is how long the result of a successful publish sent
+	// with an idempotencyKey is kept, so that a retry with the same key is
+	// answered with it rather than processed again. Zero ignores
+	// idempotencyKey.
+	IdempotencyKeyTTL time.Duration `envconfig:"IDEMPOTENCY_KEY_TTL" default:"24h"`
+
+	// IngestWebhookURL, if set, receives a POST after each successfully
+	// inserted batch of exposures. Delivery is asynchronous and retried.
+	IngestWebhookURL         string        `envconfig:"INGEST_WEBHOOK_URL"`
+	IngestWebhookTimeout     time.Duration `envconfig:"INGEST_WEBHOOK_TIMEOUT" default:"5s"`
+	IngestWebhookRetryBase   time.Duration `envconfig:"INGEST_WEBHOOK_RETRY_BASE" default:"1s"`
+	IngestWebhookMaxAttempts int           `envconfig:"INGEST_WEBHOOK_MAX_ATTEMPTS" default:"5"`
+
 	// Flags for local development and testing.
 	DebugAPIResponses   bool `envconfig:"DEBUG_API_RESPONSES"`
 	DebugAllowRestOfDay bool `envconfig:"DEBUG_ALLOW_REST_OF_DAY"`
@@ -51,6 +122,10 @@ func (c *Config) AuthorizedAppConfig() *authorizedapp.Config {
 	return &c.AuthorizedApp
 }
 
+func (c *Config) BlocklistConfig() *blocklist.Config {
+	return &c.Blocklist
+}
+
 func (c *Config) DatabaseConfig() *database.Config {
 	return &c.Database
 }
@@ -0,0 +1,134 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/base64util"
+)
+
+// Cursor is the position of an interrupted IterateExposures call. Exposures are
+// iterated in (created_at, exposure_key) order, or its reverse, and a cursor
+// resumes the iteration after the last exposure that was processed, so it
+// remains correct even if earlier exposures are deleted in the meantime.
+type Cursor struct {
+	// CreatedAt and ExposureKey identify the last exposure processed. The key
+	// breaks ties between exposures created at the same time. Both are zero if
+	// no exposure was processed.
+	CreatedAt   time.Time
+	ExposureKey []byte
+
+	// IssuedAt is the time the cursor was returned by IterateExposures.
+	IssuedAt time.Time
+
+	// Reverse is set on cursors from a reverse iteration, which continue
+	// toward older exposures. They cannot resume a forward iteration, nor the
+	// other way around.
+	Reverse bool
+
+	// storedKey is ExposureKey as the text stored in the database, which is
+	// what orders the exposures. It differs from the canonical encoding for
+	// keys stored in a legacy encoding, until migration 000051 has rewritten
+	// them. If empty, the canonical encoding is used.
+	storedKey string
+}
+
+// cursorJSON is the serialized form of a Cursor. Timestamps are in
+// microseconds, the precision of the database.
+type cursorJSON struct {
+	CreatedAt   int64  `json:"t,omitempty"`
+	ExposureKey string `json:"k,omitempty"`
+	IssuedAt    int64  `json:"i"`
+	Reverse     bool   `json:"r,omitempty"`
+}
+
+// Encode returns the opaque string form of the cursor, as passed in
+// IterateExposuresCriteria.LastCursor.
+func (c *Cursor) Encode() string {
+	cj := cursorJSON{
+		ExposureKey: c.storedExposureKey(),
+		IssuedAt:    toMicros(c.IssuedAt),
+		Reverse:     c.Reverse,
+	}
+	if !c.CreatedAt.IsZero() {
+		cj.CreatedAt = toMicros(c.CreatedAt)
+	}
+	// Marshalling a struct of strings and ints cannot fail.
+	b, _ := json.Marshal(cj)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// storedExposureKey returns the stored text of the cursor's exposure key.
+func (c *Cursor) storedExposureKey() string {
+	if c.storedKey != "" {
+		return c.storedKey
+	}
+	return encodeExposureKey(c.ExposureKey)
+}
+
+// Start reports whether the cursor is positioned before the first exposure.
+func (c *Cursor) Start() bool {
+	return c.CreatedAt.IsZero() && len(c.ExposureKey) == 0
+}
+
+// Expired reports whether the cursor was issued more than maxAge before now.
+// Old cursors still decode, but the exposures around their position may have
+// since been cleaned up. A maxAge of zero never expires.
+func (c *Cursor) Expired(now time.Time, maxAge time.Duration) bool {
+	return maxAge > 0 && now.Sub(c.IssuedAt) > maxAge
+}
+
+// DecodeCursor parses a cursor returned by IterateExposures. Errors wrap
+// ErrInvalidCursor.
+func DecodeCursor(encoded string) (*Cursor, error) {
+	b, err := base64util.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+	var cj cursorJSON
+	if err := json.Unmarshal(b, &cj); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+	if cj.IssuedAt <= 0 {
+		return nil, fmt.Errorf("%w: missing issued at time", ErrInvalidCursor)
+	}
+	key, err := decodeExposureKey(cj.ExposureKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: exposure key: %v", ErrInvalidCursor, err)
+	}
+	if (cj.CreatedAt == 0) != (len(key) == 0) {
+		return nil, fmt.Errorf("%w: incomplete position", ErrInvalidCursor)
+	}
+
+	c := &Cursor{IssuedAt: fromMicros(cj.IssuedAt), Reverse: cj.Reverse}
+	if cj.CreatedAt != 0 {
+		c.CreatedAt = fromMicros(cj.CreatedAt)
+		c.ExposureKey = key
+		c.storedKey = cj.ExposureKey
+	}
+	return c, nil
+}
+
+func toMicros(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Microsecond)
+}
+
+func fromMicros(us int64) time.Time {
+	return time.Unix(0, us*int64(time.Microsecond)).UTC()
+}
@@ -0,0 +1,173 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	issued := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name   string
+		cursor *Cursor
+	}{
+		{
+			name: "position",
+			cursor: &Cursor{
+				CreatedAt:   time.Date(2020, 6, 1, 10, 0, 0, 123000, time.UTC),
+				ExposureKey: []byte("0123456789abcdef"),
+				IssuedAt:    issued,
+			},
+		},
+		{
+			name:   "start",
+			cursor: &Cursor{IssuedAt: issued},
+		},
+		{
+			name: "reverse",
+			cursor: &Cursor{
+				CreatedAt:   time.Date(2020, 6, 1, 10, 0, 0, 123000, time.UTC),
+				ExposureKey: []byte("0123456789abcdef"),
+				IssuedAt:    issued,
+				Reverse:     true,
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := DecodeCursor(tc.cursor.Encode())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.cursor, got, cmpopts.IgnoreUnexported(Cursor{})); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDecodeCursorInvalid(t *testing.T) {
+	t.Parallel()
+
+	encode := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	cases := []struct {
+		name    string
+		encoded string
+	}{
+		{name: "not base64", encoded: "not*base64"},
+		{name: "offset cursor", encoded: encode("2")},
+		{name: "not json object", encoded: encode(`["t", "k"]`)},
+		{name: "missing issued at", encoded: encode(`{"t":1590000000000000,"k":"QUJD"}`)},
+		{name: "missing key", encoded: encode(`{"t":1590000000000000,"i":1590000000000000}`)},
+		{name: "missing created at", encoded: encode(`{"k":"QUJD","i":1590000000000000}`)},
+		{name: "bad key", encoded: encode(`{"t":1590000000000000,"k":"%%%","i":1590000000000000}`)},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if _, err := DecodeCursor(tc.encoded); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("DecodeCursor(%q) got err=%v, want %v", tc.encoded, err, ErrInvalidCursor)
+			}
+		})
+	}
+}
+
+func TestCursorExpired(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	c := &Cursor{IssuedAt: now.Add(-2 * time.Hour)}
+	if c.Expired(now, 0) {
+		t.Errorf("cursor expired with no max age")
+	}
+	if c.Expired(now, 3*time.Hour) {
+		t.Errorf("cursor expired within max age")
+	}
+	if !c.Expired(now, time.Hour) {
+		t.Errorf("cursor not expired after max age")
+	}
+}
+
+func TestGenerateExposureQueryCursor(t *testing.T) {
+	t.Parallel()
+
+	createdAt := time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC)
+	cursor := &Cursor{CreatedAt: createdAt, ExposureKey: []byte("ABC"), IssuedAt: createdAt}
+	q, args, err := generateExposureQuery(IterateExposuresCriteria{LastCursor: cursor.Encode()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(q, "(created_at, exposure_key) > ($2, $3)") {
+		t.Errorf("query %q does not resume after the cursor", q)
+	}
+	if diff := cmp.Diff([]interface{}{"", createdAt, "QUJD"}, args); diff != "" {
+		t.Errorf("args mismatch (-want, +got):\n%s", diff)
+	}
+
+	// A reverse cursor resumes before its position, newest first, and only in
+	// a reverse iteration.
+	reverse := &Cursor{CreatedAt: createdAt, ExposureKey: []byte("ABC"), IssuedAt: createdAt, Reverse: true}
+	q, _, err = generateExposureQuery(IterateExposuresCriteria{LastCursor: reverse.Encode(), Reverse: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(q, "(created_at, exposure_key) < ($2, $3)") || !strings.Contains(q, "ORDER BY created_at DESC, exposure_key DESC") {
+		t.Errorf("query %q does not resume backward from the cursor", q)
+	}
+	if _, _, err := generateExposureQuery(IterateExposuresCriteria{LastCursor: reverse.Encode()}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("reverse cursor in a forward query: got %v, want ErrInvalidCursor", err)
+	}
+	if _, _, err := generateExposureQuery(IterateExposuresCriteria{LastCursor: cursor.Encode(), Reverse: true}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("forward cursor in a reverse query: got %v, want ErrInvalidCursor", err)
+	}
+
+	// A cursor on a key stored in a legacy encoding resumes from the stored
+	// text, which is what orders the rows, rather than the canonical encoding.
+	legacy := &Cursor{CreatedAt: createdAt, ExposureKey: []byte("ABC>"), IssuedAt: createdAt, storedKey: "QUJDPg"}
+	_, args, err = generateExposureQuery(IterateExposuresCriteria{LastCursor: legacy.Encode()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]interface{}{"", createdAt, "QUJDPg"}, args); diff != "" {
+		t.Errorf("legacy args mismatch (-want, +got):\n%s", diff)
+	}
+
+	// A cursor at the start does not restrict the query.
+	start := &Cursor{IssuedAt: createdAt}
+	q, _, err = generateExposureQuery(IterateExposuresCriteria{LastCursor: start.Encode()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(q, "exposure_key) >") {
+		t.Errorf("query %q restricted by start cursor", q)
+	}
+}
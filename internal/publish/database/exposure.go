@@ -17,8 +17,9 @@ package database
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
-	"strconv"
+	"sort"
 	"strings"
 	"time"
 
@@ -28,6 +29,7 @@ import (
 	"github.com/google/exposure-notifications-server/internal/publish/model"
 
 	pgx "github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
 )
 
 const (
@@ -35,8 +37,19 @@ const (
 	InsertExposuresBatchSize = 500
 )
 
+// RegionWildcard, as the final character of a region in IterateExposuresCriteria,
+// matches any region that begins with the preceding prefix. e.g. "US-*" matches "US-CA".
+const RegionWildcard = "*"
+
+// ErrInvalidCursor indicates that the LastCursor passed to IterateExposures could not be decoded.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
 type PublishDB struct {
 	db *database.DB
+
+	// groupInserts sends inserts as one batch per distinct set of regions
+	// rather than one statement per exposure.
+	groupInserts bool
 }
 
 func New(db *database.DB) *PublishDB {
@@ -45,8 +58,19 @@ func New(db *database.DB) *PublishDB {
 	}
 }
 
+// GroupInsertsByRegion returns a PublishDB which, when inserting exposures,
+// groups them by their set of regions and sends each group to the database as
+// a single batch. Which exposures are stored is the same either way; grouping
+// only reduces round trips when a batch spans few regions.
+func (db *PublishDB) GroupInsertsByRegion(enabled bool) *PublishDB {
+	grouped := *db
+	grouped.groupInserts = enabled
+	return &grouped
+}
+
 // IterateExposuresCriteria is criteria to iterate exposures.
 type IterateExposuresCriteria struct {
+	// IncludeRegions and ExcludeRegions may end in RegionWildcard to match by prefix.
 	IncludeRegions []string
 	ExcludeRegions []string
 	SinceTimestamp time.Time
@@ -55,6 +79,33 @@ type IterateExposuresCriteria struct {
 
 	// OnlyLocalProvenance indicates that only exposures with LocalProvenance=true will be returned.
 	OnlyLocalProvenance bool
+
+	// NotExpiredAt, if set, skips exposures whose ExpiresAt is at or before it.
+	NotExpiredAt time.Time
+
+	// MinTransmissionRisk, if set, skips exposures with a lower TransmissionRisk.
+	MinTransmissionRisk int
+
+	// Namespace scopes the query to a single tenant. The empty string is the
+	// default namespace; exposures from other namespaces are never returned.
+	Namespace string
+
+	// Reverse iterates the newest exposures first, from UntilTimestamp back
+	// toward SinceTimestamp. Cursors record their direction, and a cursor from
+	// one direction is rejected in the other with ErrInvalidCursor.
+	Reverse bool
+
+	// ExplainQuery logs the query plan from EXPLAIN ANALYZE before iterating.
+	// This executes the query twice, so callers should only set it on a small
+	// sample of requests.
+	ExplainQuery bool
+}
+
+// SupportedFilters reports that PublishDB applies every filter in its query.
+// Region filters are not part of FilterSupport, since the query only narrows
+// the candidates for a single region and callers match regions themselves.
+func (db *PublishDB) SupportedFilters() FilterSupport {
+	return FullFilterSupport
 }
 
 // IterateExposures calls f on each Exposure in the database that matches the
@@ -66,23 +117,14 @@ type IterateExposuresCriteria struct {
 // criteria.LastCursor in a subsequent call to IterateExposures, will continue
 // the iteration at the failed row. If IterateExposures returns a nil error,
 // the first return value will be the empty string.
+//
+// The query is served by the read replica, if one is configured.
 func (db *PublishDB) IterateExposures(ctx context.Context, criteria IterateExposuresCriteria, f func(*model.Exposure) error) (cur string, err error) {
-	conn, err := db.db.Pool.Acquire(ctx)
+	conn, err := db.db.Reader(ctx).Acquire(ctx)
 	if err != nil {
 		return "", fmt.Errorf("acquiring connection: %v", err)
 	}
 	defer conn.Release()
-	offset := 0
-	if criteria.LastCursor != "" {
-		offsetStr, err := decodeCursor(criteria.LastCursor)
-		if err != nil {
-			return "", fmt.Errorf("decoding cursor: %v", err)
-		}
-		offset, err = strconv.Atoi(offsetStr)
-		if err != nil {
-			return "", fmt.Errorf("decoding cursor %v", err)
-		}
-	}
 
 	query, args, err := generateExposureQuery(criteria)
 	if err != nil {
@@ -91,10 +133,19 @@ func (db *PublishDB) IterateExposures(ctx context.Context, criteria IterateExpos
 	logging.FromContext(ctx).Debugf("Query: %s", query)
 	logging.FromContext(ctx).Debugf("Args: %v", args)
 
-	// TODO: this is a pretty weak cursor solution, but not too bad since we'll
-	// typically have queries ahead of the cleanup and before the current
-	// ingestion window, and those should be stable.
-	cursor := func() string { return encodeCursor(strconv.Itoa(offset)) }
+	if criteria.ExplainQuery {
+		// The plan is only diagnostic, so failing to get it does not fail the iteration.
+		if err := logQueryPlan(ctx, conn, query, args); err != nil {
+			logging.FromContext(ctx).Warnf("explaining query: %v", err)
+		}
+	}
+
+	// The cursor is positioned on the last exposure processed.
+	last := Cursor{Reverse: criteria.Reverse}
+	cursor := func() string {
+		last.IssuedAt = time.Now()
+		return last.Encode()
+	}
 
 	rows, err := conn.Query(ctx, query, args...)
 	if err != nil {
@@ -109,23 +160,27 @@ func (db *PublishDB) IterateExposures(ctx context.Context, criteria IterateExpos
 			m          model.Exposure
 			encodedKey string
 			syncID     *int64
+			expiresAt  *time.Time
 		)
 		if err := rows.Scan(&encodedKey, &m.TransmissionRisk, &m.AppPackageName, &m.Regions, &m.IntervalNumber,
-			&m.IntervalCount, &m.CreatedAt, &m.LocalProvenance, &syncID); err != nil {
+			&m.IntervalCount, &m.CreatedAt, &m.LocalProvenance, &syncID, &m.Namespace, &m.Unverified, &expiresAt); err != nil {
 			return cursor(), err
 		}
 		var err error
-		m.ExposureKey, err = decodeExposureKey(encodedKey)
+		m.ExposureKey, err = readExposureKey(ctx, encodedKey)
 		if err != nil {
 			return cursor(), err
 		}
 		if syncID != nil {
 			m.FederationSyncID = *syncID
 		}
+		if expiresAt != nil {
+			m.ExpiresAt = *expiresAt
+		}
 		if err := f(&m); err != nil {
 			return cursor(), err
 		}
-		offset++
+		last.CreatedAt, last.ExposureKey, last.storedKey = m.CreatedAt, m.ExposureKey, encodedKey
 	}
 	if err := rows.Err(); err != nil {
 		return cursor(), err
@@ -133,25 +188,61 @@ func (db *PublishDB) IterateExposures(ctx context.Context, criteria IterateExpos
 	return "", nil
 }
 
+// logQueryPlan runs EXPLAIN ANALYZE on query and logs the resulting plan.
+func logQueryPlan(ctx context.Context, conn *pgxpool.Conn, query string, args []interface{}) error {
+	rows, err := conn.Query(ctx, "EXPLAIN (ANALYZE, BUFFERS) "+query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var plan []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return err
+		}
+		plan = append(plan, line)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	logging.FromContext(ctx).Infow("Query plan", "query", query, "args", args, "plan", strings.Join(plan, "\n"))
+	return nil
+}
+
 func generateExposureQuery(criteria IterateExposuresCriteria) (string, []interface{}, error) {
 	var args []interface{}
 	q := `
 		SELECT
 			exposure_key, transmission_risk, LOWER(app_package_name), regions, interval_number, interval_count,
-			created_at, local_provenance, sync_id
+			created_at, local_provenance, sync_id, namespace, unverified, expires_at
 		FROM
 			Exposure
 		WHERE 1=1
 	`
 
+	args = append(args, criteria.Namespace)
+	q += fmt.Sprintf(" AND namespace = $%d", len(args))
+
 	if len(criteria.IncludeRegions) == 1 {
-		args = append(args, criteria.IncludeRegions)
-		q += fmt.Sprintf(" AND (regions && $%d)", len(args)) // Operation "&&" means "array overlaps / intersects"
+		if pattern, ok := regionLikePattern(criteria.IncludeRegions[0]); ok {
+			args = append(args, pattern)
+			q += fmt.Sprintf(" AND EXISTS (SELECT 1 FROM UNNEST(regions) AS region WHERE region LIKE $%d)", len(args))
+		} else {
+			args = append(args, criteria.IncludeRegions)
+			q += fmt.Sprintf(" AND (regions && $%d)", len(args)) // Operation "&&" means "array overlaps / intersects"
+		}
 	}
 
 	if len(criteria.ExcludeRegions) == 1 {
-		args = append(args, criteria.ExcludeRegions)
-		q += fmt.Sprintf(" AND NOT (regions && $%d)", len(args)) // Operation "&&" means "array overlaps / intersects"
+		if pattern, ok := regionLikePattern(criteria.ExcludeRegions[0]); ok {
+			args = append(args, pattern)
+			q += fmt.Sprintf(" AND NOT EXISTS (SELECT 1 FROM UNNEST(regions) AS region WHERE region LIKE $%d)", len(args))
+		} else {
+			args = append(args, criteria.ExcludeRegions)
+			q += fmt.Sprintf(" AND NOT (regions && $%d)", len(args)) // Operation "&&" means "array overlaps / intersects"
+		}
 	}
 
 	// It is important for StartTimestamp to be inclusive (as opposed to exclusive). When the exposure keys are
@@ -169,20 +260,44 @@ func generateExposureQuery(criteria IterateExposuresCriteria) (string, []interfa
 		q += fmt.Sprintf(" AND created_at < $%d", len(args))
 	}
 
+	if !criteria.NotExpiredAt.IsZero() {
+		args = append(args, criteria.NotExpiredAt)
+		q += fmt.Sprintf(" AND (expires_at IS NULL OR expires_at > $%d)", len(args))
+	}
+
+	if criteria.MinTransmissionRisk > 0 {
+		args = append(args, criteria.MinTransmissionRisk)
+		q += fmt.Sprintf(" AND transmission_risk >= $%d", len(args))
+	}
+
 	if criteria.OnlyLocalProvenance {
 		args = append(args, true)
 		q += fmt.Sprintf(" AND local_provenance = $%d", len(args))
 	}
 
-	q += " ORDER BY created_at"
-
 	if criteria.LastCursor != "" {
-		decoded, err := decodeCursor(criteria.LastCursor)
+		cursor, err := DecodeCursor(criteria.LastCursor)
 		if err != nil {
 			return "", nil, err
 		}
-		args = append(args, decoded)
-		q += fmt.Sprintf(" OFFSET $%d", len(args))
+		if cursor.Reverse != criteria.Reverse {
+			return "", nil, fmt.Errorf("%w: cursor was issued for the other iteration order", ErrInvalidCursor)
+		}
+		if !cursor.Start() {
+			op := ">"
+			if criteria.Reverse {
+				op = "<"
+			}
+			args = append(args, cursor.CreatedAt, cursor.storedExposureKey())
+			q += fmt.Sprintf(" AND (created_at, exposure_key) %s ($%d, $%d)", op, len(args)-1, len(args))
+		}
+	}
+
+	// The exposure key breaks ties, so that the order is stable for cursors.
+	if criteria.Reverse {
+		q += " ORDER BY created_at DESC, exposure_key DESC"
+	} else {
+		q += " ORDER BY created_at, exposure_key"
 	}
 	q = strings.ReplaceAll(q, "\n", " ")
 
@@ -191,34 +306,308 @@ func generateExposureQuery(criteria IterateExposuresCriteria) (string, []interfa
 
 // InsertExposures inserts a set of exposures.
 func (db *PublishDB) InsertExposures(ctx context.Context, exposures []*model.Exposure) error {
-	return db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+	_, err := db.InsertExposuresCount(ctx, exposures)
+	return err
+}
+
+// InsertExposuresCount inserts a set of exposures and returns the number
+// inserted. Exposures whose key is already stored are skipped.
+func (db *PublishDB) InsertExposuresCount(ctx context.Context, exposures []*model.Exposure) (int, error) {
+	return db.insertExposures(ctx, exposures, nil)
+}
+
+// insertExposures inserts a set of exposures and returns the number inserted.
+// If limits is not nil, the inserted keys are counted against them.
+func (db *PublishDB) insertExposures(ctx context.Context, exposures []*model.Exposure, limits *RegionKeyLimits) (int, error) {
+	inserted := 0
+	err := db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		inserted = 0
 		const stmtName = "insert exposures"
 		_, err := tx.Prepare(ctx, stmtName, `
 			INSERT INTO
 				Exposure
 			    (exposure_key, transmission_risk, app_package_name, regions, interval_number, interval_count,
-			     created_at, local_provenance, sync_id)
+			     created_at, local_provenance, sync_id, namespace, unverified, expires_at)
 			VALUES
-			  ($1, $2, LOWER($3), $4, $5, $6, $7, $8, $9)
+			  ($1, $2, LOWER($3), $4, $5, $6, $7, $8, $9, $10, $11, $12)
 			ON CONFLICT (exposure_key) DO NOTHING
 		`)
 		if err != nil {
 			return fmt.Errorf("preparing insert statement: %v", err)
 		}
 
+		fresh, err := dropLegacyStored(ctx, tx, exposures)
+		if err != nil {
+			return err
+		}
+
+		var counted []*model.Exposure
+		if db.groupInserts {
+			counted, err = insertExposureGroups(ctx, tx, stmtName, fresh)
+		} else {
+			counted, err = insertExposureRows(ctx, tx, stmtName, fresh)
+		}
+		if err != nil {
+			return err
+		}
+		inserted = len(counted)
+
+		if limits.enabled() {
+			if err := countRegionKeys(ctx, tx, counted, limits); err != nil {
+				return err
+			}
+		}
+		if err := logIngestEvents(ctx, tx, counted); err != nil {
+			return err
+		}
+
+		// Advance the per-region watermarks, which allow federation to skip
+		// scanning when nothing new has been published in a region.
+		watermarks := map[string]time.Time{}
 		for _, inf := range exposures {
-			var syncID *int64
-			if inf.FederationSyncID != 0 {
-				syncID = &inf.FederationSyncID
+			if !inf.LocalProvenance {
+				continue
 			}
-			_, err := tx.Exec(ctx, stmtName, encodeExposureKey(inf.ExposureKey), inf.TransmissionRisk, inf.AppPackageName, inf.Regions, inf.IntervalNumber, inf.IntervalCount,
-				inf.CreatedAt, inf.LocalProvenance, syncID)
+			for _, region := range inf.Regions {
+				if inf.CreatedAt.After(watermarks[region]) {
+					watermarks[region] = inf.CreatedAt
+				}
+			}
+		}
+		// Upsert in region order, so that concurrent inserts lock the watermarks in the same order
+		// and cannot deadlock.
+		regions := make([]string, 0, len(watermarks))
+		for region := range watermarks {
+			regions = append(regions, region)
+		}
+		sort.Strings(regions)
+		for _, region := range regions {
+			createdAt := watermarks[region]
+			_, err := tx.Exec(ctx, `
+				INSERT INTO
+					RegionWatermark
+					(region, max_created_at)
+				VALUES
+					($1, $2)
+				ON CONFLICT (region) DO UPDATE
+					SET max_created_at = GREATEST(RegionWatermark.max_created_at, $2)
+			`, region, createdAt)
 			if err != nil {
-				return fmt.Errorf("inserting exposure: %v", err)
+				return fmt.Errorf("updating region watermark: %v", err)
 			}
 		}
 		return nil
 	})
+	if err != nil {
+		return 0, err
+	}
+	return inserted, nil
+}
+
+func insertExposureArgs(inf *model.Exposure) []interface{} {
+	var syncID *int64
+	if inf.FederationSyncID != 0 {
+		syncID = &inf.FederationSyncID
+	}
+	var expiresAt *time.Time
+	if !inf.ExpiresAt.IsZero() {
+		expiresAt = &inf.ExpiresAt
+	}
+	return []interface{}{encodeExposureKey(inf.ExposureKey), inf.TransmissionRisk, inf.AppPackageName, inf.Regions, inf.IntervalNumber, inf.IntervalCount,
+		inf.CreatedAt, inf.LocalProvenance, syncID, inf.Namespace, inf.Unverified, expiresAt}
+}
+
+// insertExposureRows executes the prepared insert once per exposure, and
+// returns the exposures which were inserted.
+func insertExposureRows(ctx context.Context, tx pgx.Tx, stmtName string, exposures []*model.Exposure) ([]*model.Exposure, error) {
+	var inserted []*model.Exposure
+	for _, inf := range exposures {
+		result, err := tx.Exec(ctx, stmtName, insertExposureArgs(inf)...)
+		if err != nil {
+			return nil, fmt.Errorf("inserting exposure: %v", err)
+		}
+		if result.RowsAffected() > 0 {
+			inserted = append(inserted, inf)
+		}
+	}
+	return inserted, nil
+}
+
+// insertExposureGroups sends the prepared insert as one batch per distinct
+// set of regions, and returns the exposures which were inserted.
+//
+// Only the first exposure with each key is sent, as the later ones would
+// conflict with it. Otherwise reordering into groups could change which of
+// several exposures with the same key is stored.
+func insertExposureGroups(ctx context.Context, tx pgx.Tx, stmtName string, exposures []*model.Exposure) ([]*model.Exposure, error) {
+	var inserted []*model.Exposure
+	for _, group := range groupExposuresByRegions(exposures) {
+		batch := &pgx.Batch{}
+		for _, inf := range group {
+			batch.Queue(stmtName, insertExposureArgs(inf)...)
+		}
+
+		results := tx.SendBatch(ctx, batch)
+		for _, inf := range group {
+			result, err := results.Exec()
+			if err != nil {
+				results.Close()
+				return nil, fmt.Errorf("inserting exposure: %v", err)
+			}
+			if result.RowsAffected() > 0 {
+				inserted = append(inserted, inf)
+			}
+		}
+		if err := results.Close(); err != nil {
+			return nil, fmt.Errorf("inserting exposures: %v", err)
+		}
+	}
+	return inserted, nil
+}
+
+// groupExposuresByRegions splits exposures by their set of regions, keeping
+// only the first exposure with each key. Groups are in the order of their
+// first exposure, and each keeps the order of its exposures.
+func groupExposuresByRegions(exposures []*model.Exposure) [][]*model.Exposure {
+	var groups [][]*model.Exposure
+	index := make(map[string]int)
+	seen := make(map[string]struct{}, len(exposures))
+	for _, inf := range exposures {
+		key := string(inf.ExposureKey)
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+
+		regions := append([]string(nil), inf.Regions...)
+		sort.Strings(regions)
+		group := strings.Join(regions, ",")
+		i, ok := index[group]
+		if !ok {
+			i = len(groups)
+			index[group] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], inf)
+	}
+	return groups
+}
+
+// LatestCreatedAt returns the most recent CreatedAt of any exposure with
+// LocalProvenance=true in the given regions, or in any region if regions is
+// empty. Regions may end in RegionWildcard. If there are no such exposures,
+// the zero time is returned.
+func (db *PublishDB) LatestCreatedAt(ctx context.Context, regions []string) (time.Time, error) {
+	conn, err := db.db.Reader(ctx).Acquire(ctx)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("acquiring connection: %v", err)
+	}
+	defer conn.Release()
+
+	var args []interface{}
+	q := `
+		SELECT
+			MAX(max_created_at)
+		FROM
+			RegionWatermark
+	`
+	if len(regions) > 0 {
+		patterns := make([]string, 0, len(regions))
+		for _, region := range regions {
+			pattern, _ := regionLikePattern(region)
+			patterns = append(patterns, pattern)
+		}
+		args = append(args, patterns)
+		q += " WHERE region LIKE ANY($1)"
+	}
+
+	var latest *time.Time
+	if err := conn.QueryRow(ctx, q, args...).Scan(&latest); err != nil {
+		return time.Time{}, fmt.Errorf("querying region watermarks: %v", err)
+	}
+	if latest == nil {
+		return time.Time{}, nil
+	}
+	return *latest, nil
+}
+
+// OldestCreatedAt returns the earliest CreatedAt of any exposure with
+// LocalProvenance=true in namespace and region, which may end in
+// RegionWildcard, that has not expired at notExpiredAt. If there are no such
+// exposures, the zero time is returned.
+func (db *PublishDB) OldestCreatedAt(ctx context.Context, region, namespace string, notExpiredAt time.Time) (time.Time, error) {
+	conn, err := db.db.Reader(ctx).Acquire(ctx)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("acquiring connection: %v", err)
+	}
+	defer conn.Release()
+
+	pattern, _ := regionLikePattern(region)
+	var oldest *time.Time
+	err = conn.QueryRow(ctx, `
+		SELECT
+			MIN(created_at)
+		FROM
+			Exposure
+		WHERE
+			namespace = $1 AND local_provenance = TRUE AND
+			(expires_at IS NULL OR expires_at > $2) AND
+			EXISTS (SELECT 1 FROM UNNEST(regions) AS region WHERE region LIKE $3)
+	`, namespace, notExpiredAt, pattern).Scan(&oldest)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("querying oldest exposure: %v", err)
+	}
+	if oldest == nil {
+		return time.Time{}, nil
+	}
+	return *oldest, nil
+}
+
+// GetExposureByKey returns the stored exposure with the given key and
+// interval number, in any namespace, or database.ErrNotFound if there is none.
+// It reads from the primary, so that a key published moments ago is found.
+func (db *PublishDB) GetExposureByKey(ctx context.Context, key []byte, intervalNumber int32) (*model.Exposure, error) {
+	conn, err := db.db.Pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acquiring connection: %v", err)
+	}
+	defer conn.Release()
+
+	row := conn.QueryRow(ctx, `
+		SELECT
+			exposure_key, transmission_risk, app_package_name, regions, interval_number, interval_count,
+			created_at, local_provenance, sync_id, namespace, unverified, expires_at
+		FROM
+			Exposure
+		WHERE
+			exposure_key = $1
+		AND
+			interval_number = $2
+		`, encodeExposureKey(key), intervalNumber)
+
+	var (
+		m          model.Exposure
+		encodedKey string
+		syncID     *int64
+		expiresAt  *time.Time
+	)
+	if err := row.Scan(&encodedKey, &m.TransmissionRisk, &m.AppPackageName, &m.Regions, &m.IntervalNumber,
+		&m.IntervalCount, &m.CreatedAt, &m.LocalProvenance, &syncID, &m.Namespace, &m.Unverified, &expiresAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, database.ErrNotFound
+		}
+		return nil, fmt.Errorf("scanning results: %w", err)
+	}
+	if m.ExposureKey, err = readExposureKey(ctx, encodedKey); err != nil {
+		return nil, err
+	}
+	if syncID != nil {
+		m.FederationSyncID = *syncID
+	}
+	if expiresAt != nil {
+		m.ExpiresAt = *expiresAt
+	}
+	return &m, nil
 }
 
 // DeleteExposures deletes exposures created before "before" date. Returns the number of records deleted.
@@ -244,16 +633,165 @@ func (db *PublishDB) DeleteExposures(ctx context.Context, before time.Time) (int
 	return count, nil
 }
 
-func encodeCursor(s string) string {
-	return base64.StdEncoding.EncodeToString([]byte(s))
+// DeleteExpiredExposures deletes exposures whose ExpiresAt is at or before
+// now. Exposures without an ExpiresAt are left to DeleteExposures. Returns the
+// number of records deleted.
+func (db *PublishDB) DeleteExpiredExposures(ctx context.Context, now time.Time) (int64, error) {
+	var count int64
+	err := db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		result, err := tx.Exec(ctx, `
+			DELETE FROM
+				Exposure
+			WHERE
+				expires_at <= $1
+			`, now)
+		if err != nil {
+			return fmt.Errorf("deleting expired exposures: %v", err)
+		}
+		count = result.RowsAffected()
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// PurgeExposures deletes the exposures with the given keys, regardless of
+// age, leaving an ExposureTombstone for each so that the deletion can be
+// federated. Returns the number of exposures purged.
+func (db *PublishDB) PurgeExposures(ctx context.Context, keys [][]byte, now time.Time) (int64, error) {
+	encoded := make([]string, 0, len(keys))
+	for _, key := range keys {
+		encoded = append(encoded, encodeExposureKey(key))
+	}
+
+	var count int64
+	err := db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		row := tx.QueryRow(ctx, `
+			WITH purged AS (
+				DELETE FROM
+					Exposure
+				WHERE
+					exposure_key = ANY($1)
+				RETURNING exposure_key, interval_number, interval_count, namespace, regions
+			), tombstoned AS (
+				INSERT INTO
+					ExposureTombstone
+					(exposure_key, interval_number, interval_count, namespace, regions, deleted_at)
+				SELECT
+					exposure_key, interval_number, interval_count, namespace, regions, $2
+				FROM
+					purged
+				ON CONFLICT (exposure_key) DO NOTHING
+			)
+			SELECT COUNT(*) FROM purged
+			`, encoded, now)
+		if err := row.Scan(&count); err != nil {
+			return fmt.Errorf("purging exposures: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// IterateTombstonesCriteria is criteria to iterate tombstones.
+type IterateTombstonesCriteria struct {
+	SinceTimestamp time.Time
+	UntilTimestamp time.Time
+	Namespace      string
 }
 
-func decodeCursor(encoded string) (string, error) {
-	b, err := base64util.DecodeString(encoded)
+// IterateTombstones calls f on each ExposureTombstone in the given namespace
+// whose exposure was purged within [SinceTimestamp, UntilTimestamp). If f
+// returns an error, the iteration stops, and the returned error will match
+// f's error with errors.Is.
+func (db *PublishDB) IterateTombstones(ctx context.Context, criteria IterateTombstonesCriteria, f func(*model.ExposureTombstone) error) error {
+	conn, err := db.db.Reader(ctx).Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("acquiring connection: %v", err)
+	}
+	defer conn.Release()
+
+	args := []interface{}{criteria.Namespace}
+	q := `
+		SELECT
+			exposure_key, interval_number, interval_count, namespace, regions, deleted_at
+		FROM
+			ExposureTombstone
+		WHERE
+			namespace = $1
+	`
+	if !criteria.SinceTimestamp.IsZero() {
+		args = append(args, criteria.SinceTimestamp)
+		q += fmt.Sprintf(" AND deleted_at >= $%d", len(args))
+	}
+	if !criteria.UntilTimestamp.IsZero() {
+		args = append(args, criteria.UntilTimestamp)
+		q += fmt.Sprintf(" AND deleted_at < $%d", len(args))
+	}
+	q += " ORDER BY deleted_at"
+
+	rows, err := conn.Query(ctx, q, args...)
 	if err != nil {
-		return "", fmt.Errorf("decoding cursor: %v", err)
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			m          model.ExposureTombstone
+			encodedKey string
+		)
+		if err := rows.Scan(&encodedKey, &m.IntervalNumber, &m.IntervalCount, &m.Namespace, &m.Regions, &m.DeletedAt); err != nil {
+			return err
+		}
+		m.ExposureKey, err = readExposureKey(ctx, encodedKey)
+		if err != nil {
+			return err
+		}
+		if err := f(&m); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// DeleteTombstones deletes tombstones for exposures purged before "before". Returns the number of records deleted.
+func (db *PublishDB) DeleteTombstones(ctx context.Context, before time.Time) (int64, error) {
+	var count int64
+	err := db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		result, err := tx.Exec(ctx, `
+			DELETE FROM
+				ExposureTombstone
+			WHERE
+				deleted_at < $1
+			`, before)
+		if err != nil {
+			return fmt.Errorf("deleting tombstones: %v", err)
+		}
+		count = result.RowsAffected()
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// likeEscaper escapes the characters that are special in a SQL LIKE pattern.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// regionLikePattern converts a region to a SQL LIKE pattern, translating a
+// trailing RegionWildcard. The boolean result reports whether the region was
+// a wildcard.
+func regionLikePattern(region string) (string, bool) {
+	if !strings.HasSuffix(region, RegionWildcard) {
+		return likeEscaper.Replace(region), false
 	}
-	return string(b), nil
+	return likeEscaper.Replace(strings.TrimSuffix(region, RegionWildcard)) + "%", true
 }
 
 func encodeExposureKey(b []byte) string {
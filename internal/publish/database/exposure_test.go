@@ -18,10 +18,15 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/logging"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
 
 	"github.com/google/exposure-notifications-server/internal/publish/model"
 	"github.com/google/go-cmp/cmp"
@@ -95,6 +100,14 @@ func TestExposures(t *testing.T) {
 			IterateExposuresCriteria{IncludeRegions: []string{"CA"}, ExcludeRegions: []string{"MX"}},
 			[]int{1},
 		},
+		{
+			IterateExposuresCriteria{IncludeRegions: []string{"M*"}},
+			[]int{0, 2},
+		},
+		{
+			IterateExposuresCriteria{ExcludeRegions: []string{"C*"}},
+			[]int{3},
+		},
 		{
 			IterateExposuresCriteria{SinceTimestamp: exposures[2].CreatedAt},
 			[]int{2, 3}, // SinceTimestamp is inclusive
@@ -154,6 +167,42 @@ func listExposures(ctx context.Context, db *PublishDB, c IterateExposuresCriteri
 	return exps, nil
 }
 
+func TestIterateExposuresMinTransmissionRisk(t *testing.T) {
+	t.Parallel()
+
+	testDB := database.NewTestDatabase(t)
+	testPublishDB := New(testDB)
+	ctx := context.Background()
+
+	batchTime := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	var exposures []*model.Exposure
+	for risk := 0; risk <= 8; risk++ {
+		exposures = append(exposures, &model.Exposure{
+			ExposureKey:      []byte(fmt.Sprintf("risk-%d", risk)),
+			TransmissionRisk: risk,
+			Regions:          []string{"US"},
+			CreatedAt:        batchTime,
+			LocalProvenance:  true,
+		})
+	}
+	if err := testPublishDB.InsertExposures(ctx, exposures); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := listExposures(ctx, testPublishDB, IterateExposuresCriteria{MinTransmissionRisk: 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var risks []int
+	for _, e := range got {
+		risks = append(risks, e.TransmissionRisk)
+	}
+	sort.Ints(risks)
+	if diff := cmp.Diff([]int{6, 7, 8}, risks); diff != "" {
+		t.Errorf("transmission risks mismatch (-want, +got):\n%s", diff)
+	}
+}
+
 func TestIterateExposuresCursor(t *testing.T) {
 	t.Parallel()
 
@@ -161,8 +210,14 @@ func TestIterateExposuresCursor(t *testing.T) {
 	testPublishDB := New(testDB)
 	ctx, cancel := context.WithCancel(context.Background())
 
-	// Insert some Exposures.
+	// Insert some Exposures. They share a created_at time, so are iterated in
+	// order of their (base64 encoded) keys.
 	exposures := []*model.Exposure{
+		{
+			ExposureKey:    []byte("123"),
+			IntervalNumber: 218,
+			Regions:        []string{"MX", "CA"},
+		},
 		{
 			ExposureKey:    []byte("ABC"),
 			Regions:        []string{"US", "CA", "MX"},
@@ -173,11 +228,6 @@ func TestIterateExposuresCursor(t *testing.T) {
 			Regions:        []string{"CA"},
 			IntervalNumber: 118,
 		},
-		{
-			ExposureKey:    []byte("123"),
-			IntervalNumber: 218,
-			Regions:        []string{"MX", "CA"},
-		},
 	}
 	if err := testPublishDB.InsertExposures(ctx, exposures); err != nil {
 		t.Fatal(err)
@@ -197,8 +247,12 @@ func TestIterateExposuresCursor(t *testing.T) {
 	if diff := cmp.Diff(exposures[:2], seen); diff != "" {
 		t.Fatalf("exposures mismatch (-want, +got):\n%s", diff)
 	}
-	if want := encodeCursor("2"); cursor != want {
-		t.Fatalf("cursor: got %q, want %q", cursor, want)
+	decoded, err := DecodeCursor(cursor)
+	if err != nil {
+		t.Fatalf("DecodeCursor: %v", err)
+	}
+	if got, want := string(decoded.ExposureKey), "ABC"; got != want {
+		t.Fatalf("cursor exposure key: got %q, want %q", got, want)
 	}
 	// Resume from the cursor.
 	ctx = context.Background()
@@ -215,3 +269,643 @@ func TestIterateExposuresCursor(t *testing.T) {
 		t.Fatalf("cursor: got %q, want empty", cursor)
 	}
 }
+
+// TestIterateExposuresReverse pages through exposures newest first, one at a
+// time, checking that the pages are gapless and that the reverse cursor is
+// rejected by a forward iteration.
+func TestIterateExposuresReverse(t *testing.T) {
+	t.Parallel()
+
+	testDB := database.NewTestDatabase(t)
+	testPublishDB := New(testDB)
+	ctx := context.Background()
+
+	createdAt := time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC)
+	exposures := []*model.Exposure{
+		{ExposureKey: []byte("ABC"), Regions: []string{"US"}, IntervalNumber: 18, CreatedAt: createdAt},
+		{ExposureKey: []byte("DEF"), Regions: []string{"US"}, IntervalNumber: 118, CreatedAt: createdAt},
+		{ExposureKey: []byte("123"), Regions: []string{"US"}, IntervalNumber: 218, CreatedAt: createdAt.Add(time.Hour)},
+	}
+	if err := testPublishDB.InsertExposures(ctx, exposures); err != nil {
+		t.Fatal(err)
+	}
+
+	errStop := errors.New("stop")
+	var seen []string
+	criteria := IterateExposuresCriteria{Reverse: true}
+	for i := 0; ; i++ {
+		if i > len(exposures) {
+			t.Fatal("iteration did not finish")
+		}
+		n := 0
+		cursor, err := testPublishDB.IterateExposures(ctx, criteria, func(e *model.Exposure) error {
+			if n == 1 {
+				return errStop
+			}
+			n++
+			seen = append(seen, string(e.ExposureKey))
+			return nil
+		})
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, errStop) {
+			t.Fatal(err)
+		}
+		criteria.LastCursor = cursor
+	}
+	if diff := cmp.Diff([]string{"123", "DEF", "ABC"}, seen); diff != "" {
+		t.Fatalf("exposures mismatch (-want, +got):\n%s", diff)
+	}
+
+	// The last cursor continues backward, so it cannot resume a forward iteration.
+	_, err := testPublishDB.IterateExposures(ctx, IterateExposuresCriteria{LastCursor: criteria.LastCursor},
+		func(*model.Exposure) error { return nil })
+	if !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("reverse cursor in a forward iteration: got %v, want ErrInvalidCursor", err)
+	}
+}
+
+func TestIterateExposuresNamespace(t *testing.T) {
+	t.Parallel()
+
+	testDB := database.NewTestDatabase(t)
+	testPublishDB := New(testDB)
+	ctx := context.Background()
+
+	// Two tenants publish into the same region.
+	exposures := []*model.Exposure{
+		{
+			ExposureKey:     []byte("ABC"),
+			Regions:         []string{"US"},
+			IntervalNumber:  18,
+			LocalProvenance: true,
+			Namespace:       "tenant-a",
+		},
+		{
+			ExposureKey:     []byte("DEF"),
+			Regions:         []string{"US"},
+			IntervalNumber:  118,
+			LocalProvenance: true,
+			Namespace:       "tenant-b",
+		},
+		{
+			ExposureKey:     []byte("123"),
+			Regions:         []string{"US"},
+			IntervalNumber:  218,
+			LocalProvenance: true,
+		},
+	}
+	if err := testPublishDB.InsertExposures(ctx, exposures); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		namespace string
+		want      []*model.Exposure
+	}{
+		{"tenant-a", exposures[0:1]},
+		{"tenant-b", exposures[1:2]},
+		{"", exposures[2:3]},
+		{"tenant-c", nil},
+	} {
+		criteria := IterateExposuresCriteria{
+			IncludeRegions: []string{"US"},
+			Namespace:      test.namespace,
+		}
+		var got []*model.Exposure
+		if _, err := testPublishDB.IterateExposures(ctx, criteria, func(e *model.Exposure) error {
+			got = append(got, e)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("namespace %q: exposures mismatch (-want, +got):\n%s", test.namespace, diff)
+		}
+	}
+}
+
+func TestExpiredExposures(t *testing.T) {
+	t.Parallel()
+
+	testDB := database.NewTestDatabase(t)
+	testPublishDB := New(testDB)
+	ctx := context.Background()
+
+	now := time.Now().Truncate(time.Microsecond)
+	exposures := []*model.Exposure{
+		{
+			ExposureKey: []byte("ABC"),
+			Regions:     []string{"US"},
+			ExpiresAt:   now.Add(-time.Hour),
+		},
+		{
+			ExposureKey: []byte("DEF"),
+			Regions:     []string{"US"},
+			ExpiresAt:   now.Add(time.Hour),
+		},
+		{
+			ExposureKey: []byte("123"),
+			Regions:     []string{"US"},
+		},
+	}
+	if err := testPublishDB.InsertExposures(ctx, exposures); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := func(criteria IterateExposuresCriteria) []string {
+		var got []string
+		if _, err := testPublishDB.IterateExposures(ctx, criteria, func(e *model.Exposure) error {
+			got = append(got, string(e.ExposureKey))
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(got)
+		return got
+	}
+
+	if diff := cmp.Diff([]string{"123", "DEF"}, keys(IterateExposuresCriteria{NotExpiredAt: now})); diff != "" {
+		t.Errorf("unexpired exposures mismatch (-want, +got):\n%s", diff)
+	}
+
+	count, err := testPublishDB.DeleteExpiredExposures(ctx, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("DeleteExpiredExposures deleted %d, want 1", count)
+	}
+	if diff := cmp.Diff([]string{"123", "DEF"}, keys(IterateExposuresCriteria{})); diff != "" {
+		t.Errorf("remaining exposures mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestPurgeExposures(t *testing.T) {
+	t.Parallel()
+
+	testDB := database.NewTestDatabase(t)
+	testPublishDB := New(testDB)
+	ctx := context.Background()
+
+	createdAt := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	exposures := []*model.Exposure{
+		{
+			ExposureKey:     []byte("ABC"),
+			Regions:         []string{"US"},
+			IntervalNumber:  18,
+			IntervalCount:   144,
+			CreatedAt:       createdAt,
+			LocalProvenance: true,
+			Namespace:       "tenant-a",
+		},
+		{
+			ExposureKey:     []byte("DEF"),
+			Regions:         []string{"US"},
+			IntervalNumber:  118,
+			IntervalCount:   144,
+			CreatedAt:       createdAt,
+			LocalProvenance: true,
+			Namespace:       "tenant-a",
+		},
+	}
+	if err := testPublishDB.InsertExposures(ctx, exposures); err != nil {
+		t.Fatal(err)
+	}
+
+	deletedAt := createdAt.Add(time.Hour)
+	count, err := testPublishDB.PurgeExposures(ctx, [][]byte{[]byte("ABC"), []byte("XYZ")}, deletedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("PurgeExposures() purged %d exposures, want 1", count)
+	}
+
+	// The purged exposure is no longer served.
+	var remaining []*model.Exposure
+	if _, err := testPublishDB.IterateExposures(ctx, IterateExposuresCriteria{Namespace: "tenant-a"}, func(e *model.Exposure) error {
+		remaining = append(remaining, e)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(exposures[1:], remaining); diff != "" {
+		t.Errorf("exposures mismatch (-want, +got):\n%s", diff)
+	}
+
+	// A tombstone carrying only the key, interval and regions is left in its place.
+	criteria := IterateTombstonesCriteria{
+		SinceTimestamp: createdAt,
+		UntilTimestamp: deletedAt.Add(time.Second),
+		Namespace:      "tenant-a",
+	}
+	var tombstones []*model.ExposureTombstone
+	if err := testPublishDB.IterateTombstones(ctx, criteria, func(ts *model.ExposureTombstone) error {
+		tombstones = append(tombstones, ts)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want := []*model.ExposureTombstone{
+		{
+			ExposureKey:    []byte("ABC"),
+			IntervalNumber: 18,
+			IntervalCount:  144,
+			Namespace:      "tenant-a",
+			Regions:        []string{"US"},
+			DeletedAt:      deletedAt,
+		},
+	}
+	if diff := cmp.Diff(want, tombstones); diff != "" {
+		t.Errorf("tombstones mismatch (-want, +got):\n%s", diff)
+	}
+
+	// A key published and purged again is counted, although its tombstone already exists.
+	if err := testPublishDB.InsertExposures(ctx, exposures[:1]); err != nil {
+		t.Fatal(err)
+	}
+	count, err = testPublishDB.PurgeExposures(ctx, [][]byte{[]byte("ABC")}, deletedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("PurgeExposures() of a tombstoned key purged %d exposures, want 1", count)
+	}
+
+	// Tombstones are removed by cleanup.
+	deleted, err := testPublishDB.DeleteTombstones(ctx, deletedAt.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 1 {
+		t.Errorf("DeleteTombstones() deleted %d tombstones, want 1", deleted)
+	}
+}
+
+func TestGetExposureByKey(t *testing.T) {
+	t.Parallel()
+
+	testDB := database.NewTestDatabase(t)
+	testPublishDB := New(testDB)
+	ctx := context.Background()
+
+	createdAt := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	exposure := &model.Exposure{
+		ExposureKey:      []byte("ABC"),
+		TransmissionRisk: 3,
+		AppPackageName:   "com.example.app",
+		Regions:          []string{"CA", "US"},
+		IntervalNumber:   18,
+		IntervalCount:    144,
+		CreatedAt:        createdAt,
+		LocalProvenance:  true,
+		Namespace:        "tenant-a",
+		ExpiresAt:        createdAt.Add(24 * time.Hour),
+	}
+	if err := testPublishDB.InsertExposures(ctx, []*model.Exposure{exposure}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := testPublishDB.GetExposureByKey(ctx, []byte("ABC"), 18)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(exposure, got); diff != "" {
+		t.Errorf("exposure mismatch (-want, +got):\n%s", diff)
+	}
+
+	if _, err := testPublishDB.GetExposureByKey(ctx, []byte("ABC"), 19); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("GetExposureByKey() of the wrong interval returned err=%v, want %v", err, database.ErrNotFound)
+	}
+	if _, err := testPublishDB.GetExposureByKey(ctx, []byte("XYZ"), 18); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("GetExposureByKey() of an unknown key returned err=%v, want %v", err, database.ErrNotFound)
+	}
+}
+
+func TestLatestCreatedAt(t *testing.T) {
+	t.Parallel()
+
+	testDB := database.NewTestDatabase(t)
+	testPublishDB := New(testDB)
+	ctx := context.Background()
+
+	// With no exposures, there is no watermark.
+	got, err := testPublishDB.LatestCreatedAt(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.IsZero() {
+		t.Errorf("LatestCreatedAt() on empty database=%v, want zero time", got)
+	}
+
+	batchTime := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC).Truncate(time.Microsecond)
+	exposures := []*model.Exposure{
+		{
+			ExposureKey:     []byte("ABC"),
+			Regions:         []string{"US", "CA"},
+			CreatedAt:       batchTime,
+			LocalProvenance: true,
+		},
+		{
+			ExposureKey:     []byte("DEF"),
+			Regions:         []string{"CA"},
+			CreatedAt:       batchTime.Add(1 * time.Hour),
+			LocalProvenance: true,
+		},
+		{
+			ExposureKey:     []byte("123"),
+			Regions:         []string{"US", "MX"},
+			CreatedAt:       batchTime.Add(2 * time.Hour),
+			LocalProvenance: false, // Federated keys do not advance the watermark.
+		},
+	}
+	if err := testPublishDB.InsertExposures(ctx, exposures); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		regions []string
+		want    time.Time
+	}{
+		{nil, batchTime.Add(1 * time.Hour)},
+		{[]string{"US"}, batchTime},
+		{[]string{"CA"}, batchTime.Add(1 * time.Hour)},
+		{[]string{"US", "CA"}, batchTime.Add(1 * time.Hour)},
+		{[]string{"MX"}, time.Time{}},
+		{[]string{"U*"}, batchTime},
+		{[]string{"*"}, batchTime.Add(1 * time.Hour)},
+	} {
+		got, err := testPublishDB.LatestCreatedAt(ctx, test.regions)
+		if err != nil {
+			t.Fatalf("%v: %v", test.regions, err)
+		}
+		if !got.Equal(test.want) {
+			t.Errorf("LatestCreatedAt(%v)=%v, want %v", test.regions, got, test.want)
+		}
+	}
+}
+
+func TestOldestCreatedAt(t *testing.T) {
+	t.Parallel()
+
+	testDB := database.NewTestDatabase(t)
+	testPublishDB := New(testDB)
+	ctx := context.Background()
+
+	batchTime := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	now := batchTime.Add(24 * time.Hour)
+	exposures := []*model.Exposure{
+		{
+			ExposureKey:     []byte("EXP"),
+			Regions:         []string{"US"},
+			CreatedAt:       batchTime,
+			ExpiresAt:       now.Add(-time.Hour), // Expired, so not servable.
+			LocalProvenance: true,
+		},
+		{
+			ExposureKey:     []byte("FED"),
+			Regions:         []string{"CA"},
+			CreatedAt:       batchTime,
+			LocalProvenance: false, // Federated keys are not served.
+		},
+		{
+			ExposureKey:     []byte("TEN"),
+			Regions:         []string{"MX"},
+			CreatedAt:       batchTime,
+			LocalProvenance: true,
+			Namespace:       "tenant",
+		},
+		{
+			ExposureKey:     []byte("ABC"),
+			Regions:         []string{"US", "CA"},
+			CreatedAt:       batchTime.Add(1 * time.Hour),
+			LocalProvenance: true,
+		},
+		{
+			ExposureKey:     []byte("DEF"),
+			Regions:         []string{"US-WA"},
+			CreatedAt:       batchTime.Add(3 * time.Hour),
+			LocalProvenance: true,
+		},
+		{
+			ExposureKey:     []byte("GHI"),
+			Regions:         []string{"MX"},
+			CreatedAt:       batchTime.Add(4 * time.Hour),
+			LocalProvenance: true,
+		},
+	}
+	if err := testPublishDB.InsertExposures(ctx, exposures); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		region    string
+		namespace string
+		want      time.Time
+	}{
+		{"US", "", batchTime.Add(1 * time.Hour)},
+		{"CA", "", batchTime.Add(1 * time.Hour)},
+		{"US-WA", "", batchTime.Add(3 * time.Hour)},
+		{"US-*", "", batchTime.Add(3 * time.Hour)},
+		{"MX", "", batchTime.Add(4 * time.Hour)},
+		{"MX", "tenant", batchTime},
+		{"FR", "", time.Time{}},
+	} {
+		got, err := testPublishDB.OldestCreatedAt(ctx, test.region, test.namespace, now)
+		if err != nil {
+			t.Fatalf("%v: %v", test.region, err)
+		}
+		if !got.Equal(test.want) {
+			t.Errorf("OldestCreatedAt(%q, %q)=%v, want %v", test.region, test.namespace, got, test.want)
+		}
+	}
+}
+
+func TestIterateExposuresExplainQuery(t *testing.T) {
+	t.Parallel()
+
+	testDB := database.NewTestDatabase(t)
+	testPublishDB := New(testDB)
+
+	exposures := []*model.Exposure{
+		{
+			ExposureKey: []byte("ABC"),
+			Regions:     []string{"US"},
+			CreatedAt:   time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC),
+		},
+	}
+	if err := testPublishDB.InsertExposures(context.Background(), exposures); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, explain := range []bool{false, true} {
+		core, logs := observer.New(zap.InfoLevel)
+		ctx := logging.WithLogger(context.Background(), zap.New(core).Sugar())
+
+		var n int
+		if _, err := testPublishDB.IterateExposures(ctx, IterateExposuresCriteria{ExplainQuery: explain}, func(*model.Exposure) error {
+			n++
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if n != 1 {
+			t.Errorf("explain=%v: iterated %d exposures, want 1", explain, n)
+		}
+
+		plans := logs.FilterMessage("Query plan").All()
+		if explain && len(plans) != 1 {
+			t.Errorf("explain=%v: got %d plans logged, want 1", explain, len(plans))
+		}
+		if !explain && len(plans) != 0 {
+			t.Errorf("explain=%v: got %d plans logged, want none", explain, len(plans))
+		}
+		for _, entry := range plans {
+			if plan, _ := entry.ContextMap()["plan"].(string); !strings.Contains(plan, "actual time") {
+				t.Errorf("plan does not look like EXPLAIN ANALYZE output: %q", plan)
+			}
+		}
+	}
+}
+
+func TestRegionLikePattern(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		region       string
+		want         string
+		wantWildcard bool
+	}{
+		{"US", "US", false},
+		{"US-*", "US-%", true},
+		{"*", "%", true},
+		{"U_%", `U\_\%`, false},
+		{"U_*", `U\_%`, true},
+	} {
+		got, gotWildcard := regionLikePattern(test.region)
+		if got != test.want || gotWildcard != test.wantWildcard {
+			t.Errorf("regionLikePattern(%q)=(%q, %t), want (%q, %t)", test.region, got, gotWildcard, test.want, test.wantWildcard)
+		}
+	}
+}
+
+func TestGroupExposuresByRegions(t *testing.T) {
+	t.Parallel()
+
+	exposures := []*model.Exposure{
+		{ExposureKey: []byte("A"), Regions: []string{"US", "CA"}},
+		{ExposureKey: []byte("B"), Regions: []string{"MX"}},
+		{ExposureKey: []byte("C"), Regions: []string{"CA", "US"}},
+		{ExposureKey: []byte("B"), Regions: []string{"US", "CA"}},
+		{ExposureKey: []byte("D"), Regions: []string{"MX"}},
+	}
+
+	var got [][]string
+	for _, group := range groupExposuresByRegions(exposures) {
+		var keys []string
+		for _, e := range group {
+			keys = append(keys, string(e.ExposureKey)+":"+strings.Join(e.Regions, ","))
+		}
+		got = append(got, keys)
+	}
+	// The second B is dropped, as it would conflict with the first.
+	want := [][]string{
+		{"A:US,CA", "C:CA,US"},
+		{"B:MX", "D:MX"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+// groupedInsertExposures returns exposures in a few region sets, including
+// keys repeated with different regions, interleaved as a partner might send them.
+func groupedInsertExposures(n int, createdAt time.Time) []*model.Exposure {
+	regionSets := [][]string{{"US"}, {"CA", "US"}, {"MX"}, {"US", "CA"}}
+	exposures := make([]*model.Exposure, 0, n)
+	for i := 0; i < n; i++ {
+		key := i
+		if i%10 == 9 {
+			key = i - 5 // Repeat an earlier key.
+		}
+		exposures = append(exposures, &model.Exposure{
+			ExposureKey:     []byte(fmt.Sprintf("key-%06d-0000", key)),
+			Regions:         regionSets[i%len(regionSets)],
+			IntervalNumber:  int32(2650000 + i),
+			IntervalCount:   144,
+			CreatedAt:       createdAt,
+			LocalProvenance: true,
+		})
+	}
+	return exposures
+}
+
+func TestInsertExposuresGroupedByRegion(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	createdAt := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	exposures := groupedInsertExposures(100, createdAt)
+
+	type state struct {
+		inserted  int
+		exposures []*model.Exposure
+		latest    time.Time
+	}
+	insert := func(grouped bool) state {
+		db := New(database.NewTestDatabase(t)).GroupInsertsByRegion(grouped)
+
+		// Insert the first half twice, so some keys are already stored.
+		if _, err := db.InsertExposuresCount(ctx, exposures[:50]); err != nil {
+			t.Fatal(err)
+		}
+		inserted, err := db.InsertExposuresCount(ctx, exposures)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := listExposures(ctx, db, IterateExposuresCriteria{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		latest, err := db.LatestCreatedAt(ctx, []string{"CA"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return state{inserted: inserted, exposures: got, latest: latest}
+	}
+
+	want := insert(false)
+	got := insert(true)
+	if diff := cmp.Diff(want, got, cmp.AllowUnexported(state{})); diff != "" {
+		t.Errorf("grouped inserts mismatch (-ungrouped, +grouped):\n%s", diff)
+	}
+}
+
+func BenchmarkInsertExposures(b *testing.B) {
+	ctx := context.Background()
+	createdAt := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, grouped := range []bool{false, true} {
+		grouped := grouped
+		b.Run(fmt.Sprintf("grouped=%t", grouped), func(b *testing.B) {
+			testDB := database.NewTestDatabase(b)
+			db := New(testDB).GroupInsertsByRegion(grouped)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				if _, err := testDB.Pool.Exec(ctx, `DELETE FROM Exposure`); err != nil {
+					b.Fatal(err)
+				}
+				exposures := groupedInsertExposures(InsertExposuresBatchSize, createdAt)
+				b.StartTimer()
+
+				if _, err := db.InsertExposuresCount(ctx, exposures); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,274 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/publish/model"
+	pgx "github.com/jackc/pgx/v4"
+)
+
+// IngestEvent records the keys inserted in one region on one UTC day, by their
+// CreatedAt, by a single insert or, after compaction, by several. The log of
+// events is only ever appended to and compacted, so that the state derived
+// from ingest, the region watermarks and daily key counts, can be rebuilt from
+// it if it drifts.
+type IngestEvent struct {
+	ID              int64
+	Region          string
+	Day             time.Time
+	LocalProvenance bool
+	KeyCount        int
+	MaxCreatedAt    time.Time
+	LoggedAt        time.Time
+}
+
+type ingestEventKey struct {
+	region string
+	day    time.Time
+	local  bool
+}
+
+// ingestEvents summarizes inserted exposures as one event per region, day and
+// provenance.
+func ingestEvents(inserted []*model.Exposure) []*IngestEvent {
+	var events []*IngestEvent
+	for _, inf := range inserted {
+		day := inf.CreatedAt.UTC().Truncate(24 * time.Hour)
+		for _, region := range inf.Regions {
+			events = append(events, &IngestEvent{
+				Region:          region,
+				Day:             day,
+				LocalProvenance: inf.LocalProvenance,
+				KeyCount:        1,
+				MaxCreatedAt:    inf.CreatedAt,
+			})
+		}
+	}
+	return compactIngestEvents(events, time.Time{})
+}
+
+// compactIngestEvents merges the events for each region, day and provenance
+// into one, logged at loggedAt. Replaying the result derives the same state as
+// replaying events. The result is sorted by region, day and provenance.
+func compactIngestEvents(events []*IngestEvent, loggedAt time.Time) []*IngestEvent {
+	merged := make(map[ingestEventKey]*IngestEvent)
+	var compacted []*IngestEvent
+	for _, e := range events {
+		k := ingestEventKey{region: e.Region, day: e.Day.UTC(), local: e.LocalProvenance}
+		m, ok := merged[k]
+		if !ok {
+			m = &IngestEvent{Region: e.Region, Day: k.day, LocalProvenance: e.LocalProvenance, MaxCreatedAt: e.MaxCreatedAt, LoggedAt: loggedAt}
+			merged[k] = m
+			compacted = append(compacted, m)
+		}
+		m.KeyCount += e.KeyCount
+		if e.MaxCreatedAt.After(m.MaxCreatedAt) {
+			m.MaxCreatedAt = e.MaxCreatedAt
+		}
+	}
+	sort.Slice(compacted, func(i, j int) bool {
+		a, b := compacted[i], compacted[j]
+		if a.Region != b.Region {
+			return a.Region < b.Region
+		}
+		if !a.Day.Equal(b.Day) {
+			return a.Day.Before(b.Day)
+		}
+		return !a.LocalProvenance && b.LocalProvenance
+	})
+	return compacted
+}
+
+// replayIngestEvents derives the state which ingest maintains from events: the
+// newest CreatedAt of a local key in each region, and the number of keys
+// inserted in each region per day.
+func replayIngestEvents(events []*IngestEvent) (map[string]time.Time, map[regionDay]int) {
+	watermarks := make(map[string]time.Time)
+	counts := make(map[regionDay]int)
+	for _, e := range events {
+		if e.LocalProvenance && e.MaxCreatedAt.After(watermarks[e.Region]) {
+			watermarks[e.Region] = e.MaxCreatedAt
+		}
+		counts[regionDay{region: e.Region, day: e.Day.UTC()}] += e.KeyCount
+	}
+	return watermarks, counts
+}
+
+// logIngestEvents appends the events for inserted exposures to the log, in the
+// inserting transaction.
+func logIngestEvents(ctx context.Context, tx pgx.Tx, inserted []*model.Exposure) error {
+	for _, e := range ingestEvents(inserted) {
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO
+				IngestEvent
+				(region, day, local_provenance, key_count, max_created_at)
+			VALUES
+				($1, $2, $3, $4, $5)
+		`, e.Region, e.Day, e.LocalProvenance, e.KeyCount, e.MaxCreatedAt); err != nil {
+			return fmt.Errorf("logging ingest event: %w", err)
+		}
+	}
+	return nil
+}
+
+// readIngestEvents returns the events logged before the given time, or all
+// events if it is zero.
+func readIngestEvents(ctx context.Context, tx pgx.Tx, before time.Time) ([]*IngestEvent, error) {
+	q := `
+		SELECT
+			event_id, region, day, local_provenance, key_count, max_created_at, logged_at
+		FROM
+			IngestEvent
+	`
+	var args []interface{}
+	if !before.IsZero() {
+		q += " WHERE logged_at < $1"
+		args = append(args, before)
+	}
+	rows, err := tx.Query(ctx, q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("reading ingest events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []*IngestEvent
+	for rows.Next() {
+		var e IngestEvent
+		if err := rows.Scan(&e.ID, &e.Region, &e.Day, &e.LocalProvenance, &e.KeyCount, &e.MaxCreatedAt, &e.LoggedAt); err != nil {
+			return nil, fmt.Errorf("reading ingest events: %w", err)
+		}
+		events = append(events, &e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading ingest events: %w", err)
+	}
+	return events, nil
+}
+
+// CompactIngestLog merges the ingest events logged before the given time into
+// one event per region, day and provenance, so that the log grows with the
+// number of regions and days rather than with the number of inserts. It
+// returns the number of events removed.
+func (db *PublishDB) CompactIngestLog(ctx context.Context, before time.Time) (int, error) {
+	removed := 0
+	err := db.db.InTx(ctx, pgx.Serializable, func(tx pgx.Tx) error {
+		events, err := readIngestEvents(ctx, tx, before)
+		if err != nil {
+			return err
+		}
+		compacted := compactIngestEvents(events, before)
+		if len(compacted) == len(events) {
+			removed = 0
+			return nil
+		}
+
+		ids := make([]int64, len(events))
+		for i, e := range events {
+			ids[i] = e.ID
+		}
+		if _, err := tx.Exec(ctx, `DELETE FROM IngestEvent WHERE event_id = ANY($1)`, ids); err != nil {
+			return fmt.Errorf("deleting ingest events: %w", err)
+		}
+		for _, e := range compacted {
+			if _, err := tx.Exec(ctx, `
+				INSERT INTO
+					IngestEvent
+					(region, day, local_provenance, key_count, max_created_at, logged_at)
+				VALUES
+					($1, $2, $3, $4, $5, $6)
+			`, e.Region, e.Day, e.LocalProvenance, e.KeyCount, e.MaxCreatedAt, e.LoggedAt); err != nil {
+				return fmt.Errorf("inserting compacted ingest event: %w", err)
+			}
+		}
+		removed = len(events) - len(compacted)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+// RebuildFromIngestLog replaces the region watermarks and daily key counts of
+// every region in the ingest log with the state derived by replaying it, e.g.
+// after they were corrupted. Unlike RecomputeWatermarks, a watermark which is
+// ahead is lowered. Regions with no events are left unchanged, since their keys
+// predate the log. The watermarks changed are returned, sorted by region.
+//
+// The derived tables are locked while they are rebuilt, so that a concurrent
+// insert either is in the log read, or updates them after the rebuild.
+func (db *PublishDB) RebuildFromIngestLog(ctx context.Context) ([]*WatermarkCorrection, error) {
+	var corrections []*WatermarkCorrection
+	err := db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		corrections = nil
+		if _, err := tx.Exec(ctx, `LOCK TABLE RegionWatermark, RegionKeyCount IN EXCLUSIVE MODE`); err != nil {
+			return fmt.Errorf("locking derived tables: %w", err)
+		}
+		events, err := readIngestEvents(ctx, tx, time.Time{})
+		if err != nil {
+			return err
+		}
+		watermarks, counts := replayIngestEvents(events)
+
+		stored, err := readWatermarks(ctx, tx)
+		if err != nil {
+			return err
+		}
+		for region, createdAt := range watermarks {
+			previous := stored[region]
+			if previous.Equal(createdAt) {
+				continue
+			}
+			if _, err := tx.Exec(ctx, `
+				INSERT INTO
+					RegionWatermark
+					(region, max_created_at)
+				VALUES
+					($1, $2)
+				ON CONFLICT (region) DO UPDATE
+					SET max_created_at = $2
+			`, region, createdAt); err != nil {
+				return fmt.Errorf("rebuilding region watermark %v: %w", region, err)
+			}
+			corrections = append(corrections, &WatermarkCorrection{Region: region, Previous: previous, Corrected: createdAt})
+		}
+
+		for rd, n := range counts {
+			if _, err := tx.Exec(ctx, `
+				INSERT INTO
+					RegionKeyCount
+					(region, day, key_count)
+				VALUES
+					($1, $2, $3)
+				ON CONFLICT (region, day) DO UPDATE
+					SET key_count = $3
+			`, rd.region, rd.day, n); err != nil {
+				return fmt.Errorf("rebuilding region key count: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(corrections, func(i, j int) bool { return corrections[i].Region < corrections[j].Region })
+	return corrections, nil
+}
@@ -0,0 +1,143 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestIngestEvents(t *testing.T) {
+	day := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	exposures := []*model.Exposure{
+		{Regions: []string{"US", "CA"}, CreatedAt: day.Add(time.Hour), LocalProvenance: true},
+		{Regions: []string{"US"}, CreatedAt: day.Add(3 * time.Hour), LocalProvenance: true},
+		{Regions: []string{"US"}, CreatedAt: day.Add(5 * time.Hour), LocalProvenance: false},
+		{Regions: []string{"US"}, CreatedAt: day.Add(25 * time.Hour), LocalProvenance: true},
+	}
+	events := ingestEvents(exposures)
+	want := []*IngestEvent{
+		{Region: "CA", Day: day, LocalProvenance: true, KeyCount: 1, MaxCreatedAt: day.Add(time.Hour)},
+		{Region: "US", Day: day, LocalProvenance: false, KeyCount: 1, MaxCreatedAt: day.Add(5 * time.Hour)},
+		{Region: "US", Day: day, LocalProvenance: true, KeyCount: 2, MaxCreatedAt: day.Add(3 * time.Hour)},
+		{Region: "US", Day: day.Add(24 * time.Hour), LocalProvenance: true, KeyCount: 1, MaxCreatedAt: day.Add(25 * time.Hour)},
+	}
+	if diff := cmp.Diff(want, events); diff != "" {
+		t.Errorf("ingestEvents mismatch (-want +got):\n%s", diff)
+	}
+
+	// Federated keys are counted, but do not advance the watermark.
+	watermarks, counts := replayIngestEvents(events)
+	wantWatermarks := map[string]time.Time{"US": day.Add(25 * time.Hour), "CA": day.Add(time.Hour)}
+	if diff := cmp.Diff(wantWatermarks, watermarks); diff != "" {
+		t.Errorf("watermarks mismatch (-want +got):\n%s", diff)
+	}
+	wantCounts := map[regionDay]int{{"US", day}: 3, {"CA", day}: 1, {"US", day.Add(24 * time.Hour)}: 1}
+	if diff := cmp.Diff(wantCounts, counts, cmp.AllowUnexported(regionDay{})); diff != "" {
+		t.Errorf("counts mismatch (-want +got):\n%s", diff)
+	}
+
+	// Compacting the events of several inserts derives the same state.
+	logged := day.Add(48 * time.Hour)
+	compacted := compactIngestEvents(append(events, ingestEvents(exposures[:2])...), logged)
+	if len(compacted) != len(events) {
+		t.Errorf("compacted to %d events, want %d", len(compacted), len(events))
+	}
+	watermarks, counts = replayIngestEvents(compacted)
+	wantCounts[regionDay{"US", day}] += 2
+	wantCounts[regionDay{"CA", day}]++
+	if diff := cmp.Diff(wantWatermarks, watermarks); diff != "" {
+		t.Errorf("compacted watermarks mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(wantCounts, counts, cmp.AllowUnexported(regionDay{})); diff != "" {
+		t.Errorf("compacted counts mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// TestRebuildFromIngestLog corrupts the region watermarks and key counts, and rebuilds them from the
+// ingest log.
+func TestRebuildFromIngestLog(t *testing.T) {
+	t.Parallel()
+
+	testDB := database.NewTestDatabase(t)
+	testPublishDB := New(testDB)
+	ctx := context.Background()
+
+	day := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	limits := &RegionKeyLimits{Default: 100}
+	for i, exposures := range [][]*model.Exposure{
+		{
+			{ExposureKey: []byte("ABC"), Regions: []string{"US", "CA"}, CreatedAt: day.Add(time.Hour), LocalProvenance: true},
+			{ExposureKey: []byte("DEF"), Regions: []string{"CA"}, CreatedAt: day.Add(2 * time.Hour), LocalProvenance: true},
+		},
+		{
+			{ExposureKey: []byte("GHI"), Regions: []string{"US"}, CreatedAt: day.Add(3 * time.Hour), LocalProvenance: true},
+		},
+	} {
+		if _, err := testPublishDB.InsertExposuresLimited(ctx, exposures, limits); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+
+	// Compaction does not change what the log rebuilds.
+	if n, err := testPublishDB.CompactIngestLog(ctx, time.Now().Add(time.Hour)); err != nil || n != 1 {
+		t.Fatalf("CompactIngestLog() = %d, %v, want 1, nil", n, err)
+	}
+
+	// US is ahead, CA is missing, and the US count is wrong.
+	if _, err := testDB.Pool.Exec(ctx, `UPDATE RegionWatermark SET max_created_at = $1 WHERE region = 'US'`, day.Add(1000*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testDB.Pool.Exec(ctx, `DELETE FROM RegionWatermark WHERE region = 'CA'`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testDB.Pool.Exec(ctx, `UPDATE RegionKeyCount SET key_count = 99 WHERE region = 'US'`); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := testPublishDB.RebuildFromIngestLog(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*WatermarkCorrection{
+		{Region: "CA", Corrected: day.Add(2 * time.Hour)},
+		{Region: "US", Previous: day.Add(1000 * time.Hour), Corrected: day.Add(3 * time.Hour)},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("corrections mismatch (-want +got):\n%s", diff)
+	}
+
+	for region, want := range map[string]time.Time{"US": day.Add(3 * time.Hour), "CA": day.Add(2 * time.Hour)} {
+		latest, err := testPublishDB.LatestCreatedAt(ctx, []string{region})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !latest.Equal(want) {
+			t.Errorf("LatestCreatedAt(%s) = %v, want %v", region, latest, want)
+		}
+	}
+	var count int
+	if err := testDB.Pool.QueryRow(ctx, `SELECT key_count FROM RegionKeyCount WHERE region = 'US' AND day = $1`, day).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("US key count = %d, want 2", count)
+	}
+}
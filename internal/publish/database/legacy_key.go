@@ -0,0 +1,130 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/google/exposure-notifications-server/internal/publish/model"
+	pgx "github.com/jackc/pgx/v4"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+)
+
+var mLegacyKeyReads = stats.Int64("publish/exposure/legacy_key_reads", "Number of exposure keys read in a legacy encoding", stats.UnitDimensionless)
+
+// LegacyKeyViews are the OpenCensus views counting exposure keys stored in a
+// legacy encoding. They must be registered with view.Register to be exported.
+var LegacyKeyViews = []*view.View{
+	{
+		Name:        "publish/exposure/legacy_key_reads",
+		Description: mLegacyKeyReads.Description(),
+		Measure:     mLegacyKeyReads,
+		Aggregation: view.Sum(),
+	},
+}
+
+// readExposureKey decodes an exposure key read from the database. Keys are
+// stored in standard, padded base64, but rows written before this was enforced
+// may use the URL safe alphabet or omit the padding. Those are decoded to the
+// same bytes, and counted, until migration 000051 has rewritten them.
+func readExposureKey(ctx context.Context, encoded string) ([]byte, error) {
+	key, err := decodeExposureKey(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if isLegacyExposureKey(encoded, key) {
+		stats.Record(ctx, mLegacyKeyReads.M(1))
+	}
+	return key, nil
+}
+
+// isLegacyExposureKey reports whether encoded, which decodes to key, is not in
+// the canonical encoding written by encodeExposureKey.
+func isLegacyExposureKey(encoded string, key []byte) bool {
+	return encoded != encodeExposureKey(key)
+}
+
+// legacyExposureKeys returns the legacy encodings of key which differ from its
+// canonical one, and so which a row written before keys were normalized may
+// hold instead.
+func legacyExposureKeys(key []byte) []string {
+	seen := map[string]bool{encodeExposureKey(key): true}
+	var out []string
+	for _, enc := range []*base64.Encoding{base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		encoded := enc.EncodeToString(key)
+		if seen[encoded] {
+			continue
+		}
+		seen[encoded] = true
+		out = append(out, encoded)
+	}
+	return out
+}
+
+// dropLegacyStored returns the exposures whose key is not already stored in a
+// legacy encoding. The insert only conflicts with the canonical text, so
+// without this a key stored before migration 000051 would be stored again.
+func dropLegacyStored(ctx context.Context, tx pgx.Tx, exposures []*model.Exposure) ([]*model.Exposure, error) {
+	var encoded []string
+	for _, inf := range exposures {
+		encoded = append(encoded, legacyExposureKeys(inf.ExposureKey)...)
+	}
+	if len(encoded) == 0 {
+		return exposures, nil
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT
+			exposure_key
+		FROM
+			Exposure
+		WHERE
+			exposure_key = ANY($1)
+		`, encoded)
+	if err != nil {
+		return nil, fmt.Errorf("checking legacy keys: %w", err)
+	}
+	defer rows.Close()
+
+	stored := make(map[string]struct{})
+	for rows.Next() {
+		var encodedKey string
+		if err := rows.Scan(&encodedKey); err != nil {
+			return nil, fmt.Errorf("scanning legacy keys: %w", err)
+		}
+		key, err := decodeExposureKey(encodedKey)
+		if err != nil {
+			return nil, err
+		}
+		stored[string(key)] = struct{}{}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("checking legacy keys: %w", err)
+	}
+	if len(stored) == 0 {
+		return exposures, nil
+	}
+
+	fresh := make([]*model.Exposure, 0, len(exposures))
+	for _, inf := range exposures {
+		if _, ok := stored[string(inf.ExposureKey)]; !ok {
+			fresh = append(fresh, inf)
+		}
+	}
+	return fresh, nil
+}
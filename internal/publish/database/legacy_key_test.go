@@ -0,0 +1,256 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/go-cmp/cmp"
+	"go.opencensus.io/stats/view"
+)
+
+// legacyTestKey encodes with both '+' and '/' and padding, so that each
+// legacy encoding differs from the canonical one.
+var legacyTestKey = append(bytes.Repeat([]byte{0xfb, 0xff, 0xbf}, 5), 0xfb)
+
+// legacyKeyReads returns the number of legacy key reads recorded so far.
+func legacyKeyReads(t *testing.T) float64 {
+	t.Helper()
+
+	rows, err := view.RetrieveData("publish/exposure/legacy_key_reads")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) == 0 {
+		return 0
+	}
+	return rows[0].Data.(*view.SumData).Value
+}
+
+func TestReadExposureKey(t *testing.T) {
+	if err := view.Register(LegacyKeyViews...); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { view.Unregister(LegacyKeyViews...) })
+
+	ctx := context.Background()
+	cases := []struct {
+		name    string
+		encoded string
+		legacy  bool
+	}{
+		{"canonical", base64.StdEncoding.EncodeToString(legacyTestKey), false},
+		{"unpadded", base64.RawStdEncoding.EncodeToString(legacyTestKey), true},
+		{"url safe", base64.URLEncoding.EncodeToString(legacyTestKey), true},
+		{"url safe unpadded", base64.RawURLEncoding.EncodeToString(legacyTestKey), true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			before := legacyKeyReads(t)
+			got, err := readExposureKey(ctx, c.encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, legacyTestKey) {
+				t.Errorf("readExposureKey(%q) = %x, want %x", c.encoded, got, legacyTestKey)
+			}
+			want := 0.0
+			if c.legacy {
+				want = 1
+			}
+			if got := legacyKeyReads(t) - before; got != want {
+				t.Errorf("readExposureKey(%q) recorded %v legacy reads, want %v", c.encoded, got, want)
+			}
+		})
+	}
+}
+
+func TestIterateExposuresLegacyKeys(t *testing.T) {
+	t.Parallel()
+
+	testDB := database.NewTestDatabase(t)
+	testPublishDB := New(testDB)
+	ctx := context.Background()
+
+	migratedKey := append([]byte(nil), legacyTestKey...)
+	migratedKey[15] = 0xbf
+	createdAt := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	exposures := []*model.Exposure{
+		{ExposureKey: legacyTestKey, Regions: []string{"US"}, CreatedAt: createdAt, LocalProvenance: true},
+		{ExposureKey: migratedKey, Regions: []string{"US"}, CreatedAt: createdAt, LocalProvenance: true},
+	}
+	if err := testPublishDB.InsertExposures(ctx, exposures); err != nil {
+		t.Fatal(err)
+	}
+
+	// Store the first key as it was written before keys were normalized.
+	if _, err := testDB.Pool.Exec(ctx, `UPDATE Exposure SET exposure_key = $1 WHERE exposure_key = $2`,
+		base64.RawURLEncoding.EncodeToString(legacyTestKey), base64.StdEncoding.EncodeToString(legacyTestKey)); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := listExposures(ctx, testPublishDB, IterateExposuresCriteria{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys [][]byte
+	for _, e := range got {
+		keys = append(keys, e.ExposureKey)
+	}
+	want := [][]byte{legacyTestKey, migratedKey}
+	if diff := cmp.Diff(want, keys, cmp.Transformer("sort", func(in [][]byte) [][]byte {
+		out := append([][]byte(nil), in...)
+		sort.Slice(out, func(i, j int) bool { return bytes.Compare(out[i], out[j]) < 0 })
+		return out
+	})); diff != "" {
+		t.Errorf("keys mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestLegacyExposureKeys(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		key  []byte
+		want []string
+	}{
+		{
+			name: "url safe differs",
+			key:  legacyTestKey,
+			want: []string{
+				base64.RawStdEncoding.EncodeToString(legacyTestKey),
+				base64.URLEncoding.EncodeToString(legacyTestKey),
+				base64.RawURLEncoding.EncodeToString(legacyTestKey),
+			},
+		},
+		{
+			name: "only padding differs",
+			key:  []byte("0123456789abcdef"),
+			want: []string{"MDEyMzQ1Njc4OWFiY2RlZg"},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if diff := cmp.Diff(tc.want, legacyExposureKeys(tc.key)); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestIterateExposuresLegacyKeysPaging(t *testing.T) {
+	t.Parallel()
+
+	testDB := database.NewTestDatabase(t)
+	testPublishDB := New(testDB)
+	ctx := context.Background()
+
+	// Canonically, legacyTestKey ("+/+/...") sorts before otherKey ("+AAA..."),
+	// but stored URL safe ("-_-_...") it sorts after it.
+	otherKey := append([]byte{0xf8}, make([]byte, 15)...)
+	createdAt := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	exposures := []*model.Exposure{
+		{ExposureKey: legacyTestKey, Regions: []string{"US"}, CreatedAt: createdAt, LocalProvenance: true},
+		{ExposureKey: otherKey, Regions: []string{"US"}, CreatedAt: createdAt, LocalProvenance: true},
+	}
+	if err := testPublishDB.InsertExposures(ctx, exposures); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testDB.Pool.Exec(ctx, `UPDATE Exposure SET exposure_key = $1 WHERE exposure_key = $2`,
+		base64.RawURLEncoding.EncodeToString(legacyTestKey), base64.StdEncoding.EncodeToString(legacyTestKey)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, reverse := range []bool{false, true} {
+		// Page through one exposure at a time, by cancelling after each.
+		var keys [][]byte
+		cursor := ""
+		for page := 0; page <= len(exposures); page++ {
+			pageCtx, cancel := context.WithCancel(ctx)
+			var err error
+			cursor, err = testPublishDB.IterateExposures(pageCtx, IterateExposuresCriteria{LastCursor: cursor, Reverse: reverse},
+				func(e *model.Exposure) error {
+					keys = append(keys, e.ExposureKey)
+					cancel()
+					return nil
+				})
+			cancel()
+			if err != nil && !errors.Is(err, context.Canceled) {
+				t.Fatal(err)
+			}
+			if cursor == "" {
+				break
+			}
+		}
+
+		want := [][]byte{otherKey, legacyTestKey}
+		if reverse {
+			want = [][]byte{legacyTestKey, otherKey}
+		}
+		if diff := cmp.Diff(want, keys); diff != "" {
+			t.Errorf("reverse=%t: keys mismatch (-want, +got):\n%s", reverse, diff)
+		}
+	}
+}
+
+func TestInsertExposuresLegacyDuplicate(t *testing.T) {
+	t.Parallel()
+
+	testDB := database.NewTestDatabase(t)
+	testPublishDB := New(testDB)
+	ctx := context.Background()
+
+	createdAt := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	exposure := &model.Exposure{ExposureKey: legacyTestKey, Regions: []string{"US"}, CreatedAt: createdAt, LocalProvenance: true}
+	if err := testPublishDB.InsertExposures(ctx, []*model.Exposure{exposure}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testDB.Pool.Exec(ctx, `UPDATE Exposure SET exposure_key = $1 WHERE exposure_key = $2`,
+		base64.RawURLEncoding.EncodeToString(legacyTestKey), base64.StdEncoding.EncodeToString(legacyTestKey)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Re-uploading the key must not store it a second time in the canonical encoding.
+	for _, grouped := range []bool{false, true} {
+		inserted, err := testPublishDB.GroupInsertsByRegion(grouped).InsertExposuresCount(ctx, []*model.Exposure{exposure})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if inserted != 0 {
+			t.Errorf("grouped=%t: inserted %d exposures, want 0", grouped, inserted)
+		}
+	}
+
+	got, err := listExposures(ctx, testPublishDB, IterateExposuresCriteria{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Errorf("got %d stored exposures, want 1", len(got))
+	}
+}
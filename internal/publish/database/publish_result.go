@@ -0,0 +1,111 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	pgx "github.com/jackc/pgx/v4"
+)
+
+// PublishResult is the outcome of a publish request sent with an idempotency
+// key, kept until ExpiresAt so that a retry of the request with the same key
+// is answered with it rather than processed again.
+type PublishResult struct {
+	AppPackageName string
+	IdempotencyKey string
+	// RequestHash identifies the content of the request, so that a key reused
+	// for another request can be detected.
+	RequestHash []byte
+	Status      int
+	Message     string
+	ExpiresAt   time.Time
+}
+
+// GetPublishResult returns the result stored for the app's idempotency key,
+// if it has not expired at now. Otherwise database.ErrNotFound is returned.
+func (db *PublishDB) GetPublishResult(ctx context.Context, appPackageName, idempotencyKey string, now time.Time) (*PublishResult, error) {
+	conn, err := db.db.Pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acquiring connection: %v", err)
+	}
+	defer conn.Release()
+
+	r := PublishResult{AppPackageName: appPackageName, IdempotencyKey: idempotencyKey}
+	err = conn.QueryRow(ctx, `
+		SELECT
+			request_hash, status, message, expires_at
+		FROM
+			PublishResult
+		WHERE
+			app_package_name = $1 AND idempotency_key = $2 AND expires_at > $3
+		`, appPackageName, idempotencyKey, now).Scan(&r.RequestHash, &r.Status, &r.Message, &r.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, database.ErrNotFound
+		}
+		return nil, fmt.Errorf("scanning results: %w", err)
+	}
+	return &r, nil
+}
+
+// SavePublishResult stores the result of a publish request. If an unexpired
+// result is already stored for its idempotency key, e.g. by a concurrent
+// retry, that result is kept.
+func (db *PublishDB) SavePublishResult(ctx context.Context, r *PublishResult, now time.Time) error {
+	return db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO
+				PublishResult
+				(app_package_name, idempotency_key, request_hash, status, message, expires_at)
+			VALUES
+				($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (app_package_name, idempotency_key) DO UPDATE
+				SET request_hash = $3, status = $4, message = $5, expires_at = $6
+				WHERE PublishResult.expires_at <= $7
+			`, r.AppPackageName, r.IdempotencyKey, r.RequestHash, r.Status, r.Message, r.ExpiresAt, now)
+		if err != nil {
+			return fmt.Errorf("inserting publish result: %v", err)
+		}
+		return nil
+	})
+}
+
+// DeleteExpiredPublishResults deletes the publish results which expired at or
+// before now. Returns the number of records deleted.
+func (db *PublishDB) DeleteExpiredPublishResults(ctx context.Context, now time.Time) (int64, error) {
+	var count int64
+	err := db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		result, err := tx.Exec(ctx, `
+			DELETE FROM
+				PublishResult
+			WHERE
+				expires_at <= $1
+			`, now)
+		if err != nil {
+			return fmt.Errorf("deleting publish results: %v", err)
+		}
+		count = result.RowsAffected()
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
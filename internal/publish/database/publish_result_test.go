@@ -0,0 +1,91 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPublishResults(t *testing.T) {
+	t.Parallel()
+
+	testDB := database.NewTestDatabase(t)
+	testPublishDB := New(testDB)
+	ctx := context.Background()
+
+	now := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	first := &PublishResult{
+		AppPackageName: "com.example.app",
+		IdempotencyKey: "key",
+		RequestHash:    []byte("first"),
+		Status:         200,
+		Message:        "Inserted 1 exposures.",
+		ExpiresAt:      now.Add(time.Hour),
+	}
+	if err := testPublishDB.SavePublishResult(ctx, first, now); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := testPublishDB.GetPublishResult(ctx, "com.example.app", "key", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(first, got); diff != "" {
+		t.Errorf("GetPublishResult mismatch (-want, +got):\n%s", diff)
+	}
+
+	// Keys are scoped to their app.
+	if _, err := testPublishDB.GetPublishResult(ctx, "com.example.other", "key", now); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("GetPublishResult for another app: got err=%v, want %v", err, database.ErrNotFound)
+	}
+
+	// An unexpired result is not replaced.
+	second := *first
+	second.RequestHash = []byte("second")
+	second.ExpiresAt = now.Add(2 * time.Hour)
+	if err := testPublishDB.SavePublishResult(ctx, &second, now); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := testPublishDB.GetPublishResult(ctx, "com.example.app", "key", now); err != nil || string(got.RequestHash) != "first" {
+		t.Errorf("GetPublishResult after save over unexpired result: got %v, %v, want the first result", got, err)
+	}
+
+	// Once expired, it is not returned, and can be replaced.
+	later := now.Add(time.Hour)
+	if _, err := testPublishDB.GetPublishResult(ctx, "com.example.app", "key", later); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("GetPublishResult after expiry: got err=%v, want %v", err, database.ErrNotFound)
+	}
+	if err := testPublishDB.SavePublishResult(ctx, &second, later); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := testPublishDB.GetPublishResult(ctx, "com.example.app", "key", later); err != nil || string(got.RequestHash) != "second" {
+		t.Errorf("GetPublishResult after save over expired result: got %v, %v, want the second result", got, err)
+	}
+
+	// Expired results are deleted.
+	n, err := testPublishDB.DeleteExpiredPublishResults(ctx, now.Add(2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("DeleteExpiredPublishResults deleted %d, want 1", n)
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/logging"
+	pgx "github.com/jackc/pgx/v4"
+)
+
+// RegionDeletion counts the exposures changed by DeleteExposuresByRegion.
+type RegionDeletion struct {
+	// Deleted is the number of exposures published only to the region, which
+	// were deleted and replaced with tombstones.
+	Deleted int64
+	// Trimmed is the number of exposures also published to other regions,
+	// which had the region removed.
+	Trimmed int64
+}
+
+// DeleteExposuresByRegion removes every exposure published to region, e.g. when
+// a jurisdiction leaves the system. Exposures published only to region are
+// deleted, leaving an ExposureTombstone for each so that the deletion can be
+// federated, while exposures also published to other regions are kept with
+// region removed. Exposures are processed batchSize at a time, each batch in
+// its own transaction, so that no single transaction holds the table for long,
+// and each batch is logged for audit.
+func (db *PublishDB) DeleteExposuresByRegion(ctx context.Context, region string, batchSize int, now time.Time) (*RegionDeletion, error) {
+	if region == "" {
+		return nil, fmt.Errorf("region is required")
+	}
+	if batchSize <= 0 {
+		return nil, fmt.Errorf("batch size must be positive, got %d", batchSize)
+	}
+	logger := logging.FromContext(ctx)
+
+	total := &RegionDeletion{}
+	for {
+		var batch RegionDeletion
+		err := db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+			keys, err := lockRegionExposures(ctx, tx, region, batchSize)
+			if err != nil || len(keys) == 0 {
+				return err
+			}
+
+			row := tx.QueryRow(ctx, `
+				WITH purged AS (
+					DELETE FROM
+						Exposure
+					WHERE
+						exposure_key = ANY($1)
+					AND
+						cardinality(array_remove(regions, $2)) = 0
+					RETURNING exposure_key, interval_number, interval_count, namespace, regions
+				), tombstoned AS (
+					INSERT INTO
+						ExposureTombstone
+						(exposure_key, interval_number, interval_count, namespace, regions, deleted_at)
+					SELECT
+						exposure_key, interval_number, interval_count, namespace, regions, $3
+					FROM
+						purged
+					ON CONFLICT (exposure_key) DO NOTHING
+				)
+				SELECT COUNT(*) FROM purged
+				`, keys, region, now)
+			if err := row.Scan(&batch.Deleted); err != nil {
+				return fmt.Errorf("deleting exposures: %w", err)
+			}
+
+			result, err := tx.Exec(ctx, `
+				UPDATE
+					Exposure
+				SET
+					regions = array_remove(regions, $2)
+				WHERE
+					exposure_key = ANY($1)
+				`, keys, region)
+			if err != nil {
+				return fmt.Errorf("removing region from exposures: %w", err)
+			}
+			batch.Trimmed = result.RowsAffected()
+			return nil
+		})
+		if err != nil {
+			return total, err
+		}
+		if batch.Deleted == 0 && batch.Trimmed == 0 {
+			break
+		}
+		logger.Infof("Removed region %s from exposures: deleted %d, trimmed %d", region, batch.Deleted, batch.Trimmed)
+		total.Deleted += batch.Deleted
+		total.Trimmed += batch.Trimmed
+	}
+	logger.Infof("Removed region %s from all exposures: deleted %d, trimmed %d in total", region, total.Deleted, total.Trimmed)
+	return total, nil
+}
+
+// lockRegionExposures locks up to limit exposures published to region,
+// returning their encoded keys.
+func lockRegionExposures(ctx context.Context, tx pgx.Tx, region string, limit int) ([]string, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT
+			exposure_key
+		FROM
+			Exposure
+		WHERE
+			$1 = ANY(regions)
+		LIMIT $2
+		FOR UPDATE
+		`, region, limit)
+	if err != nil {
+		return nil, fmt.Errorf("selecting exposures: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, fmt.Errorf("selecting exposures: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("selecting exposures: %w", err)
+	}
+	return keys, nil
+}
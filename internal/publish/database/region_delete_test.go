@@ -0,0 +1,116 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDeleteExposuresByRegion(t *testing.T) {
+	t.Parallel()
+
+	testDB := database.NewTestDatabase(t)
+	testPublishDB := New(testDB)
+	ctx := context.Background()
+
+	createdAt := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	exposure := func(key string, regions ...string) *model.Exposure {
+		return &model.Exposure{
+			ExposureKey:     []byte(key),
+			Regions:         regions,
+			IntervalNumber:  18,
+			IntervalCount:   144,
+			CreatedAt:       createdAt,
+			LocalProvenance: true,
+		}
+	}
+	exposures := []*model.Exposure{
+		exposure("AAA", "XX"),
+		exposure("BBB", "XX"),
+		exposure("CCC", "CA", "XX", "US"),
+		exposure("DDD", "CA"),
+	}
+	if err := testPublishDB.InsertExposures(ctx, exposures); err != nil {
+		t.Fatal(err)
+	}
+
+	// A batch size of one forces a batch per exposure.
+	deletedAt := createdAt.Add(time.Hour)
+	got, err := testPublishDB.DeleteExposuresByRegion(ctx, "XX", 1, deletedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(&RegionDeletion{Deleted: 2, Trimmed: 1}, got); diff != "" {
+		t.Errorf("DeleteExposuresByRegion() mismatch (-want, +got):\n%s", diff)
+	}
+
+	// Single region exposures are gone, multi region ones lose only the region.
+	remaining, err := listExposures(ctx, testPublishDB, IterateExposuresCriteria{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*model.Exposure{
+		exposure("CCC", "CA", "US"),
+		exposure("DDD", "CA"),
+	}
+	if diff := cmp.Diff(want, remaining); diff != "" {
+		t.Errorf("exposures mismatch (-want, +got):\n%s", diff)
+	}
+
+	// The deleted exposures are tombstoned in the region, so that federation partners drop them too.
+	var tombstoned []string
+	if err := testPublishDB.IterateTombstones(ctx, IterateTombstonesCriteria{}, func(ts *model.ExposureTombstone) error {
+		tombstoned = append(tombstoned, string(ts.ExposureKey))
+		if diff := cmp.Diff([]string{"XX"}, ts.Regions); diff != "" {
+			t.Errorf("tombstone %s regions mismatch (-want, +got):\n%s", ts.ExposureKey, diff)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(tombstoned)
+	if diff := cmp.Diff([]string{"AAA", "BBB"}, tombstoned); diff != "" {
+		t.Errorf("tombstones mismatch (-want, +got):\n%s", diff)
+	}
+
+	// Nothing is left to remove.
+	got, err = testPublishDB.DeleteExposuresByRegion(ctx, "XX", 1, deletedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(&RegionDeletion{}, got); diff != "" {
+		t.Errorf("second DeleteExposuresByRegion() mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestDeleteExposuresByRegionInvalid(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	db := New(nil)
+	if _, err := db.DeleteExposuresByRegion(ctx, "", 100, time.Now()); err == nil {
+		t.Errorf("DeleteExposuresByRegion() without a region returned err=nil, want an error")
+	}
+	if _, err := db.DeleteExposuresByRegion(ctx, "XX", 0, time.Now()); err == nil {
+		t.Errorf("DeleteExposuresByRegion() with a zero batch size returned err=nil, want an error")
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/publish/model"
+	pgx "github.com/jackc/pgx/v4"
+)
+
+// ErrRegionKeyLimit is returned when inserting exposures would exceed the
+// daily key limit of one of their regions.
+var ErrRegionKeyLimit = errors.New("region daily key limit exceeded")
+
+// RegionKeyLimits bounds how many keys are accepted in each region per UTC
+// day, to bound storage and catch runaway uploaders. Keys are counted by their
+// CreatedAt, and only while a limit is set.
+type RegionKeyLimits struct {
+	// Default is the limit for regions not listed in Regions. Zero means no
+	// limit.
+	Default int `envconfig:"REGION_DAILY_KEY_LIMIT" default:"0"`
+
+	// Regions sets the limit of individual regions, e.g. "US:100000,CA:20000",
+	// overriding Default. Zero means no limit.
+	Regions map[string]int `envconfig:"REGION_DAILY_KEY_LIMITS"`
+}
+
+// Limit returns the daily key limit of region, or 0 if there is none.
+func (l *RegionKeyLimits) Limit(region string) int {
+	if l == nil {
+		return 0
+	}
+	if limit, ok := l.Regions[region]; ok {
+		return limit
+	}
+	return l.Default
+}
+
+func (l *RegionKeyLimits) enabled() bool {
+	if l == nil {
+		return false
+	}
+	for _, limit := range l.Regions {
+		if limit > 0 {
+			return true
+		}
+	}
+	return l.Default > 0
+}
+
+// InsertExposuresLimited inserts a set of exposures like InsertExposuresCount,
+// counting the inserted keys against each of their regions' daily limits. If
+// any limit would be exceeded, nothing is inserted and ErrRegionKeyLimit is
+// returned.
+func (db *PublishDB) InsertExposuresLimited(ctx context.Context, exposures []*model.Exposure, limits *RegionKeyLimits) (int, error) {
+	return db.insertExposures(ctx, exposures, limits)
+}
+
+type regionDay struct {
+	region string
+	day    time.Time
+}
+
+// countRegionKeys adds the inserted exposures to the daily count of each of
+// their regions, in the inserting transaction so that concurrent inserts are
+// counted exactly.
+func countRegionKeys(ctx context.Context, tx pgx.Tx, inserted []*model.Exposure, limits *RegionKeyLimits) error {
+	counts := make(map[regionDay]int)
+	for _, inf := range inserted {
+		day := inf.CreatedAt.UTC().Truncate(24 * time.Hour)
+		for _, region := range inf.Regions {
+			counts[regionDay{region: region, day: day}]++
+		}
+	}
+
+	// Upsert in (region, day) order, so that concurrent inserts lock the counts in the same order
+	// and cannot deadlock.
+	keys := make([]regionDay, 0, len(counts))
+	for rd := range counts {
+		keys = append(keys, rd)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].region != keys[j].region {
+			return keys[i].region < keys[j].region
+		}
+		return keys[i].day.Before(keys[j].day)
+	})
+
+	for _, rd := range keys {
+		n := counts[rd]
+		row := tx.QueryRow(ctx, `
+			INSERT INTO
+				RegionKeyCount
+				(region, day, key_count)
+			VALUES
+				($1, $2, $3)
+			ON CONFLICT (region, day) DO UPDATE
+				SET key_count = RegionKeyCount.key_count + $3
+			RETURNING key_count
+		`, rd.region, rd.day, n)
+		var total int
+		if err := row.Scan(&total); err != nil {
+			return fmt.Errorf("updating region key count: %w", err)
+		}
+		if limit := limits.Limit(rd.region); limit > 0 && total > limit {
+			return fmt.Errorf("%w: region %s has %d keys on %s, limit is %d", ErrRegionKeyLimit, rd.region, total, rd.day.Format("2006-01-02"), limit)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/publish/model"
+)
+
+func TestRegionKeyLimits(t *testing.T) {
+	var none *RegionKeyLimits
+	if none.enabled() || none.Limit("US") != 0 {
+		t.Errorf("nil limits: enabled=%v, Limit(US)=%d, want false, 0", none.enabled(), none.Limit("US"))
+	}
+
+	limits := &RegionKeyLimits{Default: 10, Regions: map[string]int{"US": 100, "CA": 0}}
+	for region, want := range map[string]int{"US": 100, "CA": 0, "MX": 10} {
+		if got := limits.Limit(region); got != want {
+			t.Errorf("Limit(%s) = %d, want %d", region, got, want)
+		}
+	}
+	if !limits.enabled() {
+		t.Errorf("enabled() = false, want true")
+	}
+}
+
+// TestInsertExposuresLimited inserts keys until a region's daily limit is crossed.
+func TestInsertExposuresLimited(t *testing.T) {
+	t.Parallel()
+
+	testDB := database.NewTestDatabase(t)
+	testPublishDB := New(testDB)
+	ctx := context.Background()
+
+	day := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	next := 0
+	exposures := func(n int, createdAt time.Time, regions ...string) []*model.Exposure {
+		var exposures []*model.Exposure
+		for i := 0; i < n; i++ {
+			next++
+			exposures = append(exposures, &model.Exposure{
+				ExposureKey:     []byte(fmt.Sprintf("%016d", next)),
+				Regions:         regions,
+				IntervalNumber:  18,
+				IntervalCount:   144,
+				CreatedAt:       createdAt,
+				LocalProvenance: true,
+			})
+		}
+		return exposures
+	}
+	limits := &RegionKeyLimits{Default: 5, Regions: map[string]int{"CA": 0}}
+
+	first := exposures(4, day.Add(time.Hour), "US", "CA")
+	if n, err := testPublishDB.InsertExposuresLimited(ctx, first, limits); err != nil || n != 4 {
+		t.Fatalf("InsertExposuresLimited() = %d, %v, want 4, nil", n, err)
+	}
+	// Keys which are already stored are not counted again.
+	if n, err := testPublishDB.InsertExposuresLimited(ctx, first, limits); err != nil || n != 0 {
+		t.Fatalf("InsertExposuresLimited() of stored keys = %d, %v, want 0, nil", n, err)
+	}
+
+	// Two more US keys cross the limit, and neither is stored.
+	over := exposures(2, day.Add(2*time.Hour), "US")
+	if _, err := testPublishDB.InsertExposuresLimited(ctx, over, limits); !errors.Is(err, ErrRegionKeyLimit) {
+		t.Fatalf("InsertExposuresLimited() over the limit returned err=%v, want %v", err, ErrRegionKeyLimit)
+	}
+	for _, exp := range over {
+		if _, err := testPublishDB.GetExposureByKey(ctx, exp.ExposureKey, exp.IntervalNumber); !errors.Is(err, database.ErrNotFound) {
+			t.Errorf("GetExposureByKey() of rejected key returned err=%v, want %v", err, database.ErrNotFound)
+		}
+	}
+
+	// CA is unlimited, and the limit resets the next day.
+	if _, err := testPublishDB.InsertExposuresLimited(ctx, exposures(10, day.Add(3*time.Hour), "CA"), limits); err != nil {
+		t.Errorf("InsertExposuresLimited() in unlimited region returned err=%v", err)
+	}
+	if _, err := testPublishDB.InsertExposuresLimited(ctx, exposures(5, day.Add(25*time.Hour), "US"), limits); err != nil {
+		t.Errorf("InsertExposuresLimited() on the next day returned err=%v", err)
+	}
+}
@@ -0,0 +1,402 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/base64util"
+	"github.com/google/exposure-notifications-server/internal/publish/model"
+)
+
+// ExposureIterator is implemented by anything that can iterate exposures, such
+// as a PublishDB or a ShardedExposures.
+type ExposureIterator interface {
+	IterateExposures(ctx context.Context, criteria IterateExposuresCriteria, f func(*model.Exposure) error) (string, error)
+}
+
+// FilterSupport reports which IterateExposuresCriteria filters an
+// ExposureIterator applies in its query. Callers must apply the others to the
+// exposures they receive.
+type FilterSupport struct {
+	// Timestamps covers SinceTimestamp and UntilTimestamp.
+	Timestamps      bool
+	LocalProvenance bool
+	Namespace       bool
+	// Expiry covers NotExpiredAt.
+	Expiry bool
+	// TransmissionRisk covers MinTransmissionRisk.
+	TransmissionRisk bool
+	// Reverse covers the Reverse order. Callers cannot reorder exposures
+	// themselves, so must not iterate in reverse without it.
+	Reverse bool
+}
+
+// FullFilterSupport is the FilterSupport of an iterator that applies every filter.
+var FullFilterSupport = FilterSupport{Timestamps: true, LocalProvenance: true, Namespace: true, Expiry: true, TransmissionRisk: true, Reverse: true}
+
+// FilterReporter is implemented by an ExposureIterator which reports the
+// filters it supports.
+type FilterReporter interface {
+	SupportedFilters() FilterSupport
+}
+
+// SupportedFilters returns the filters that it applies. An iterator that does
+// not implement FilterReporter is assumed to apply none.
+func SupportedFilters(it ExposureIterator) FilterSupport {
+	if r, ok := it.(FilterReporter); ok {
+		return r.SupportedFilters()
+	}
+	return FilterSupport{}
+}
+
+// LatestReporter is implemented by an ExposureIterator which reports when
+// exposures were last published, as PublishDB does.
+type LatestReporter interface {
+	LatestCreatedAt(ctx context.Context, regions []string) (time.Time, error)
+}
+
+// OldestReporter is implemented by an ExposureIterator which reports the
+// oldest exposure it still serves, as PublishDB does.
+type OldestReporter interface {
+	OldestCreatedAt(ctx context.Context, region, namespace string, notExpiredAt time.Time) (time.Time, error)
+}
+
+// TombstoneIterator is implemented by an ExposureIterator which also iterates
+// the tombstones of purged exposures, as PublishDB does.
+type TombstoneIterator interface {
+	IterateTombstones(ctx context.Context, criteria IterateTombstonesCriteria, f func(*model.ExposureTombstone) error) error
+}
+
+// ShardedExposures iterates exposures that are spread across several databases
+// by a hash of their region. An exposure must be stored on the shard of every
+// region it is published to (see ShardsForRegions), so that a query for any
+// one region finds it on that region's shard.
+//
+// Results from the shards are merged in the same (created_at, exposure_key)
+// order as a single database, or its reverse, and an exposure present on more
+// than one shard is returned once. Callers see the same contract as PublishDB.
+// Exposures are merged by their canonical key encoding, so shards must not
+// hold keys in a legacy encoding (see migration 000051).
+type ShardedExposures struct {
+	shards []ExposureIterator
+}
+
+// NewShardedExposures creates a ShardedExposures over the given shards. The
+// order of the shards determines the region assignment and must not change
+// while cursors are outstanding.
+func NewShardedExposures(shards ...ExposureIterator) (*ShardedExposures, error) {
+	if len(shards) == 0 {
+		return nil, errors.New("at least one shard is required")
+	}
+	return &ShardedExposures{shards: shards}, nil
+}
+
+// ShardForRegion returns the index of the shard that holds exposures for region.
+func (s *ShardedExposures) ShardForRegion(region string) int {
+	h := fnv.New32a()
+	h.Write([]byte(region))
+	return int(h.Sum32() % uint32(len(s.shards)))
+}
+
+// ShardsForRegions returns the sorted, distinct indexes of the shards an
+// exposure published to the given regions must be written to.
+func (s *ShardedExposures) ShardsForRegions(regions []string) []int {
+	want := make([]bool, len(s.shards))
+	for _, r := range regions {
+		want[s.ShardForRegion(r)] = true
+	}
+	var idx []int
+	for i, w := range want {
+		if w {
+			idx = append(idx, i)
+		}
+	}
+	return idx
+}
+
+// SupportedFilters returns the filters that every shard supports.
+func (s *ShardedExposures) SupportedFilters() FilterSupport {
+	fs := FullFilterSupport
+	for _, shard := range s.shards {
+		sf := SupportedFilters(shard)
+		fs.Timestamps = fs.Timestamps && sf.Timestamps
+		fs.LocalProvenance = fs.LocalProvenance && sf.LocalProvenance
+		fs.Namespace = fs.Namespace && sf.Namespace
+		fs.Expiry = fs.Expiry && sf.Expiry
+		fs.TransmissionRisk = fs.TransmissionRisk && sf.TransmissionRisk
+		fs.Reverse = fs.Reverse && sf.Reverse
+	}
+	return fs
+}
+
+// relevantShards returns the indexes of the shards that may hold exposures
+// matching criteria.
+func (s *ShardedExposures) relevantShards(criteria IterateExposuresCriteria) []int {
+	return s.shardsMatching(criteria.IncludeRegions)
+}
+
+// shardsMatching returns the indexes of the shards that may hold exposures in
+// any of regions. Wildcard regions can match regions on any shard, as can an
+// empty list.
+func (s *ShardedExposures) shardsMatching(regions []string) []int {
+	all := len(regions) == 0
+	for _, r := range regions {
+		if strings.HasSuffix(r, RegionWildcard) {
+			all = true
+		}
+	}
+	if !all {
+		return s.ShardsForRegions(regions)
+	}
+	idx := make([]int, len(s.shards))
+	for i := range idx {
+		idx[i] = i
+	}
+	return idx
+}
+
+// shardedCursor is the serialized form of a ShardedExposures cursor. It holds
+// the position within each shard, keyed by shard index; shards that had not
+// produced an exposure are absent and start from the beginning.
+type shardedCursor struct {
+	Shards map[int]string `json:"s"`
+}
+
+func decodeShardedCursor(encoded string) (map[int]string, error) {
+	if encoded == "" {
+		return nil, nil
+	}
+	b, err := base64util.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+	var sc shardedCursor
+	if err := json.Unmarshal(b, &sc); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+	return sc.Shards, nil
+}
+
+func encodeShardedCursor(positions map[int]string) string {
+	// Marshalling a map of strings cannot fail.
+	b, _ := json.Marshal(shardedCursor{Shards: positions})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// shardStream delivers the exposures of one shard to the merge.
+type shardStream struct {
+	index int
+	ch    chan *model.Exposure
+	err   error // set before ch is closed
+	head  *model.Exposure
+	pos   string // encoded Cursor after the last exposure consumed
+}
+
+// IterateExposures calls f on each matching exposure across the relevant
+// shards. It has the same contract as PublishDB.IterateExposures; the cursor
+// it returns records the position within every shard.
+func (s *ShardedExposures) IterateExposures(ctx context.Context, criteria IterateExposuresCriteria, f func(*model.Exposure) error) (string, error) {
+	positions, err := decodeShardedCursor(criteria.LastCursor)
+	if err != nil {
+		return "", err
+	}
+
+	less := exposureLess
+	if criteria.Reverse {
+		less = func(a, b *model.Exposure) bool { return exposureLess(b, a) }
+	}
+
+	indexes := s.relevantShards(criteria)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	streams := make([]*shardStream, 0, len(indexes))
+	for _, i := range indexes {
+		st := &shardStream{index: i, ch: make(chan *model.Exposure), pos: positions[i]}
+		streams = append(streams, st)
+
+		c := criteria
+		c.LastCursor = st.pos
+		shard := s.shards[i]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(st.ch)
+			_, st.err = shard.IterateExposures(ctx, c, func(e *model.Exposure) error {
+				select {
+				case st.ch <- e:
+					return nil
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			})
+		}()
+	}
+
+	cursor := func() string {
+		cancel()
+		wg.Wait()
+		out := make(map[int]string, len(streams))
+		for _, st := range streams {
+			if st.pos != "" {
+				out[st.index] = st.pos
+			}
+		}
+		return encodeShardedCursor(out)
+	}
+
+	// advance reads the next exposure of st into its head, returning the
+	// shard's error once it is exhausted.
+	advance := func(st *shardStream) error {
+		e, ok := <-st.ch
+		if !ok {
+			st.head = nil
+			return st.err
+		}
+		st.head = e
+		return nil
+	}
+	for _, st := range streams {
+		if err := advance(st); err != nil {
+			return cursor(), fmt.Errorf("shard %d: %w", st.index, err)
+		}
+	}
+
+	for {
+		// As in PublishDB, stop between exposures once ctx is done. Otherwise a
+		// shard which already sent its next exposure keeps the merge going.
+		if err := ctx.Err(); err != nil {
+			return cursor(), err
+		}
+
+		var next *model.Exposure
+		for _, st := range streams {
+			if st.head != nil && (next == nil || less(st.head, next)) {
+				next = st.head
+			}
+		}
+		if next == nil {
+			wg.Wait()
+			return "", nil
+		}
+
+		if err := f(next); err != nil {
+			return cursor(), err
+		}
+
+		pos := (&Cursor{CreatedAt: next.CreatedAt, ExposureKey: next.ExposureKey, IssuedAt: time.Now(), Reverse: criteria.Reverse}).Encode()
+		key := encodeExposureKey(next.ExposureKey)
+		for _, st := range streams {
+			if st.head == nil || !st.head.CreatedAt.Equal(next.CreatedAt) || encodeExposureKey(st.head.ExposureKey) != key {
+				continue
+			}
+			st.pos = pos
+			if err := advance(st); err != nil {
+				return cursor(), fmt.Errorf("shard %d: %w", st.index, err)
+			}
+		}
+	}
+}
+
+// exposureLess reports whether a sorts before b in the order exposures are
+// iterated: by creation time, then by encoded exposure key.
+func exposureLess(a, b *model.Exposure) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.Before(b.CreatedAt)
+	}
+	return encodeExposureKey(a.ExposureKey) < encodeExposureKey(b.ExposureKey)
+}
+
+// LatestCreatedAt returns the most recent LatestCreatedAt of the shards that
+// may hold exposures in regions. Every such shard must be a LatestReporter.
+func (s *ShardedExposures) LatestCreatedAt(ctx context.Context, regions []string) (time.Time, error) {
+	var latest time.Time
+	for _, i := range s.shardsMatching(regions) {
+		r, ok := s.shards[i].(LatestReporter)
+		if !ok {
+			return time.Time{}, fmt.Errorf("shard %d does not report its latest exposure", i)
+		}
+		t, err := r.LatestCreatedAt(ctx, regions)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("shard %d: %w", i, err)
+		}
+		if t.After(latest) {
+			latest = t
+		}
+	}
+	return latest, nil
+}
+
+// OldestCreatedAt returns the earliest OldestCreatedAt of the shards that may
+// hold exposures in region. Every such shard must be an OldestReporter.
+func (s *ShardedExposures) OldestCreatedAt(ctx context.Context, region, namespace string, notExpiredAt time.Time) (time.Time, error) {
+	var oldest time.Time
+	for _, i := range s.shardsMatching([]string{region}) {
+		r, ok := s.shards[i].(OldestReporter)
+		if !ok {
+			return time.Time{}, fmt.Errorf("shard %d does not report its oldest exposure", i)
+		}
+		t, err := r.OldestCreatedAt(ctx, region, namespace, notExpiredAt)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("shard %d: %w", i, err)
+		}
+		if !t.IsZero() && (oldest.IsZero() || t.Before(oldest)) {
+			oldest = t
+		}
+	}
+	return oldest, nil
+}
+
+// IterateTombstones calls f on each tombstone matching criteria across every
+// shard, in order of deletion. A tombstone present on more than one shard is
+// passed to f once. Every shard must be a TombstoneIterator.
+func (s *ShardedExposures) IterateTombstones(ctx context.Context, criteria IterateTombstonesCriteria, f func(*model.ExposureTombstone) error) error {
+	var tombstones []*model.ExposureTombstone
+	seen := make(map[string]struct{})
+	for i, shard := range s.shards {
+		it, ok := shard.(TombstoneIterator)
+		if !ok {
+			return fmt.Errorf("shard %d does not iterate tombstones", i)
+		}
+		if err := it.IterateTombstones(ctx, criteria, func(t *model.ExposureTombstone) error {
+			if _, ok := seen[string(t.ExposureKey)]; !ok {
+				seen[string(t.ExposureKey)] = struct{}{}
+				tombstones = append(tombstones, t)
+			}
+			return nil
+		}); err != nil {
+			return fmt.Errorf("shard %d: %w", i, err)
+		}
+	}
+
+	sort.SliceStable(tombstones, func(i, j int) bool { return tombstones[i].DeletedAt.Before(tombstones[j].DeletedAt) })
+	for _, t := range tombstones {
+		if err := f(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
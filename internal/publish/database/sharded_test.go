@@ -0,0 +1,475 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/go-cmp/cmp"
+)
+
+// memShard is an in-memory ExposureIterator that honors IncludeRegions,
+// LastCursor and Reverse the way PublishDB does.
+type memShard struct {
+	exposures  []*model.Exposure
+	tombstones []*model.ExposureTombstone
+	queried    int
+}
+
+func (m *memShard) LatestCreatedAt(ctx context.Context, regions []string) (time.Time, error) {
+	var latest time.Time
+	for _, e := range m.exposures {
+		if inRegions(e, regions) && e.CreatedAt.After(latest) {
+			latest = e.CreatedAt
+		}
+	}
+	return latest, nil
+}
+
+func (m *memShard) OldestCreatedAt(ctx context.Context, region, namespace string, notExpiredAt time.Time) (time.Time, error) {
+	var oldest time.Time
+	for _, e := range m.exposures {
+		if inRegions(e, []string{region}) && (oldest.IsZero() || e.CreatedAt.Before(oldest)) {
+			oldest = e.CreatedAt
+		}
+	}
+	return oldest, nil
+}
+
+func (m *memShard) IterateTombstones(ctx context.Context, criteria IterateTombstonesCriteria, f func(*model.ExposureTombstone) error) error {
+	for _, t := range m.tombstones {
+		if err := f(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// iteratorOnly hides everything but IterateExposures of an ExposureIterator.
+type iteratorOnly struct {
+	ExposureIterator
+}
+
+func (m *memShard) IterateExposures(ctx context.Context, criteria IterateExposuresCriteria, f func(*model.Exposure) error) (string, error) {
+	m.queried++
+	var after *model.Exposure
+	if criteria.LastCursor != "" {
+		c, err := DecodeCursor(criteria.LastCursor)
+		if err != nil {
+			return "", err
+		}
+		if !c.Start() {
+			after = &model.Exposure{CreatedAt: c.CreatedAt, ExposureKey: c.ExposureKey}
+		}
+	}
+
+	less := exposureLess
+	if criteria.Reverse {
+		less = func(a, b *model.Exposure) bool { return exposureLess(b, a) }
+	}
+
+	sorted := append([]*model.Exposure(nil), m.exposures...)
+	sort.Slice(sorted, func(i, j int) bool { return less(sorted[i], sorted[j]) })
+	for _, e := range sorted {
+		if after != nil && !less(after, e) {
+			continue
+		}
+		if !inRegions(e, criteria.IncludeRegions) {
+			continue
+		}
+		if err := f(e); err != nil {
+			return "unused", err
+		}
+	}
+	return "", nil
+}
+
+func inRegions(e *model.Exposure, include []string) bool {
+	if len(include) == 0 {
+		return true
+	}
+	for _, want := range include {
+		for _, r := range e.Regions {
+			if r == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestShardedExposures(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	base := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	exp := func(key string, minutes int, regions ...string) *model.Exposure {
+		return &model.Exposure{
+			ExposureKey: []byte(key),
+			CreatedAt:   base.Add(time.Duration(minutes) * time.Minute),
+			Regions:     regions,
+		}
+	}
+
+	var (
+		a = exp("aaa", 1, "MX")
+		b = exp("bbb", 2, "US")
+		c = exp("ccc", 2, "MX", "US") // stored on both shards
+		d = exp("ddd", 3, "US")
+		e = exp("eee", 4, "MX")
+	)
+	// Ties on CreatedAt are broken by the base64 form of the key, which puts
+	// ccc before bbb.
+	all := []*model.Exposure{a, c, b, d, e}
+
+	shards := []*memShard{{}, {}}
+	sharded, err := NewShardedExposures(shards[0], shards[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mx, us := sharded.ShardForRegion("MX"), sharded.ShardForRegion("US"); mx == us {
+		t.Fatalf("test regions MX and US must hash to different shards, both got %d", mx)
+	}
+	for _, x := range all {
+		for _, i := range sharded.ShardsForRegions(x.Regions) {
+			shards[i].exposures = append(shards[i].exposures, x)
+		}
+	}
+
+	keys := func(es []*model.Exposure) []string {
+		var ks []string
+		for _, e := range es {
+			ks = append(ks, string(e.ExposureKey))
+		}
+		return ks
+	}
+
+	t.Run("merged", func(t *testing.T) {
+		var got []*model.Exposure
+		cur, err := sharded.IterateExposures(ctx, IterateExposuresCriteria{}, func(e *model.Exposure) error {
+			got = append(got, e)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cur != "" {
+			t.Errorf("cursor: got %q, want empty", cur)
+		}
+		if diff := cmp.Diff(keys(all), keys(got)); diff != "" {
+			t.Errorf("mismatch (-want, +got):\n%s", diff)
+		}
+	})
+
+	t.Run("resumable", func(t *testing.T) {
+		errStop := errors.New("stop")
+		var got []*model.Exposure
+		criteria := IterateExposuresCriteria{}
+		for i := 0; ; i++ {
+			if i > len(all) {
+				t.Fatal("iteration did not finish")
+			}
+			n := 0
+			cur, err := sharded.IterateExposures(ctx, criteria, func(e *model.Exposure) error {
+				if n == 2 {
+					return errStop
+				}
+				n++
+				got = append(got, e)
+				return nil
+			})
+			if err == nil {
+				break
+			}
+			if !errors.Is(err, errStop) {
+				t.Fatal(err)
+			}
+			criteria.LastCursor = cur
+		}
+		if diff := cmp.Diff(keys(all), keys(got)); diff != "" {
+			t.Errorf("mismatch (-want, +got):\n%s", diff)
+		}
+	})
+
+	t.Run("reverse resumable", func(t *testing.T) {
+		errStop := errors.New("stop")
+		var got []*model.Exposure
+		criteria := IterateExposuresCriteria{Reverse: true}
+		for i := 0; ; i++ {
+			if i > len(all) {
+				t.Fatal("iteration did not finish")
+			}
+			n := 0
+			cur, err := sharded.IterateExposures(ctx, criteria, func(e *model.Exposure) error {
+				if n == 2 {
+					return errStop
+				}
+				n++
+				got = append(got, e)
+				return nil
+			})
+			if err == nil {
+				break
+			}
+			if !errors.Is(err, errStop) {
+				t.Fatal(err)
+			}
+			criteria.LastCursor = cur
+		}
+		want := []string{"eee", "ddd", "bbb", "ccc", "aaa"}
+		if diff := cmp.Diff(want, keys(got)); diff != "" {
+			t.Errorf("mismatch (-want, +got):\n%s", diff)
+		}
+	})
+
+	t.Run("single shard", func(t *testing.T) {
+		mx := sharded.ShardForRegion("MX")
+		before := shards[mx].queried
+
+		var got []*model.Exposure
+		if _, err := sharded.IterateExposures(ctx, IterateExposuresCriteria{IncludeRegions: []string{"US"}}, func(e *model.Exposure) error {
+			got = append(got, e)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"ccc", "bbb", "ddd"}
+		if diff := cmp.Diff(want, keys(got)); diff != "" {
+			t.Errorf("mismatch (-want, +got):\n%s", diff)
+		}
+		if shards[mx].queried != before {
+			t.Error("MX shard was queried for US exposures")
+		}
+	})
+
+	t.Run("invalid cursor", func(t *testing.T) {
+		_, err := sharded.IterateExposures(ctx, IterateExposuresCriteria{LastCursor: "!!"}, func(*model.Exposure) error { return nil })
+		if !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("got %v, want ErrInvalidCursor", err)
+		}
+	})
+}
+
+// TestShardedExposuresCanceled tests that the merge stops at the next exposure once the context is
+// canceled, and returns a cursor from which to resume.
+func TestShardedExposuresCanceled(t *testing.T) {
+	t.Parallel()
+
+	base := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	var exposures []*model.Exposure
+	for i := 0; i < 100; i++ {
+		exposures = append(exposures, &model.Exposure{
+			ExposureKey: []byte{byte(i)},
+			CreatedAt:   base.Add(time.Duration(i) * time.Minute),
+			Regions:     []string{"US"},
+		})
+	}
+	sharded, err := NewShardedExposures(&memShard{exposures: exposures[:50]}, &memShard{exposures: exposures[50:]})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	calls := 0
+	cursor, err := sharded.IterateExposures(ctx, IterateExposuresCriteria{}, func(*model.Exposure) error {
+		calls++
+		cancel()
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got err=%v, want %v", err, context.Canceled)
+	}
+	if calls != 1 {
+		t.Errorf("f was called %d times after cancellation, want 1", calls)
+	}
+	if cursor == "" {
+		t.Errorf("no cursor was returned")
+	}
+}
+
+func TestShardedLatestCreatedAt(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	base := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	shards := []*memShard{{}, {}}
+	sharded, err := NewShardedExposures(shards[0], shards[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	mx, us := sharded.ShardForRegion("MX"), sharded.ShardForRegion("US")
+	if mx == us {
+		t.Fatalf("test regions MX and US are on the same shard %d", mx)
+	}
+	shards[mx].exposures = []*model.Exposure{{ExposureKey: []byte("aaa"), CreatedAt: base.Add(time.Hour), Regions: []string{"MX"}}}
+	shards[us].exposures = []*model.Exposure{{ExposureKey: []byte("bbb"), CreatedAt: base, Regions: []string{"US"}}}
+
+	cases := []struct {
+		regions []string
+		want    time.Time
+	}{
+		{regions: []string{"US"}, want: base},
+		{regions: []string{"MX", "US"}, want: base.Add(time.Hour)},
+		{regions: nil, want: base.Add(time.Hour)},
+	}
+	for _, c := range cases {
+		got, err := sharded.LatestCreatedAt(ctx, c.regions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equal(c.want) {
+			t.Errorf("LatestCreatedAt(%v) = %v, want %v", c.regions, got, c.want)
+		}
+	}
+
+	bare, err := NewShardedExposures(iteratorOnly{&memShard{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bare.LatestCreatedAt(ctx, nil); err == nil {
+		t.Error("expected error from a shard which does not report its latest exposure")
+	}
+}
+
+func TestShardedOldestCreatedAt(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	base := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	shards := []*memShard{{}, {}}
+	sharded, err := NewShardedExposures(shards[0], shards[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	mx, us := sharded.ShardForRegion("MX"), sharded.ShardForRegion("US")
+	if mx == us {
+		t.Fatalf("test regions MX and US are on the same shard %d", mx)
+	}
+	shards[mx].exposures = []*model.Exposure{{ExposureKey: []byte("aaa"), CreatedAt: base, Regions: []string{"MX"}}}
+	shards[us].exposures = []*model.Exposure{{ExposureKey: []byte("bbb"), CreatedAt: base.Add(time.Hour), Regions: []string{"US"}}}
+
+	// Only the region's own shard is read, and it may have no exposures.
+	cases := []struct {
+		region string
+		want   time.Time
+	}{
+		{region: "US", want: base.Add(time.Hour)},
+		{region: "CA", want: time.Time{}},
+	}
+	for _, c := range cases {
+		got, err := sharded.OldestCreatedAt(ctx, c.region, "", base)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equal(c.want) {
+			t.Errorf("OldestCreatedAt(%q) = %v, want %v", c.region, got, c.want)
+		}
+	}
+
+	bare, err := NewShardedExposures(iteratorOnly{&memShard{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bare.OldestCreatedAt(ctx, "US", "", base); err == nil {
+		t.Error("expected error from a shard which does not report its oldest exposure")
+	}
+}
+
+func TestShardedTombstones(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	base := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	tombstone := func(key string, minutes int) *model.ExposureTombstone {
+		return &model.ExposureTombstone{ExposureKey: []byte(key), DeletedAt: base.Add(time.Duration(minutes) * time.Minute)}
+	}
+	both := tombstone("bbb", 2) // stored on both shards
+	sharded, err := NewShardedExposures(
+		&memShard{tombstones: []*model.ExposureTombstone{tombstone("aaa", 1), both}},
+		&memShard{tombstones: []*model.ExposureTombstone{both, tombstone("ccc", 0)}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	if err := sharded.IterateTombstones(ctx, IterateTombstonesCriteria{}, func(t *model.ExposureTombstone) error {
+		got = append(got, string(t.ExposureKey))
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"ccc", "aaa", "bbb"}, got); diff != "" {
+		t.Errorf("tombstones mismatch (-want, +got):\n%s", diff)
+	}
+
+	bare, err := NewShardedExposures(iteratorOnly{&memShard{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bare.IterateTombstones(ctx, IterateTombstonesCriteria{}, func(*model.ExposureTombstone) error { return nil }); err == nil {
+		t.Error("expected error from a shard which does not iterate tombstones")
+	}
+}
+
+// fullShard is a memShard which claims to apply every filter.
+type fullShard struct {
+	memShard
+}
+
+func (*fullShard) SupportedFilters() FilterSupport {
+	return FullFilterSupport
+}
+
+func TestSupportedFilters(t *testing.T) {
+	t.Parallel()
+
+	if got := SupportedFilters(&memShard{}); got != (FilterSupport{}) {
+		t.Errorf("iterator without FilterReporter: got %+v, want no support", got)
+	}
+	if got := SupportedFilters(New(nil)); got != FullFilterSupport {
+		t.Errorf("PublishDB: got %+v, want full support", got)
+	}
+
+	full, err := NewShardedExposures(&fullShard{}, &fullShard{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := SupportedFilters(full); got != FullFilterSupport {
+		t.Errorf("all shards supported: got %+v, want full support", got)
+	}
+
+	mixed, err := NewShardedExposures(&fullShard{}, &memShard{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := SupportedFilters(mixed); got != (FilterSupport{}) {
+		t.Errorf("one shard unsupported: got %+v, want no support", got)
+	}
+}
+
+func TestNewShardedExposures(t *testing.T) {
+	t.Parallel()
+
+	if _, err := NewShardedExposures(); err == nil {
+		t.Error("expected error with no shards")
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	pgx "github.com/jackc/pgx/v4"
+)
+
+// WatermarkCorrection records a region watermark that was repaired by
+// RecomputeWatermarks.
+type WatermarkCorrection struct {
+	Region string
+	// Previous is the stored watermark, or the zero time if the region had none.
+	Previous time.Time
+	// Corrected is the newest CreatedAt of a local exposure in the region.
+	Corrected time.Time
+}
+
+// RecomputeWatermarks rebuilds the per-region watermarks from the Exposure
+// table and repairs any that are behind, for example after a backfill that
+// wrote exposures directly. Exposures are read batchSize rows at a time so that
+// no single query holds the table for long.
+//
+// Watermarks are only ever advanced: a watermark ahead of the exposures (after
+// old exposures are deleted) is harmless, and lowering one could race with a
+// concurrent InsertExposures. The corrections made are returned, sorted by
+// region.
+func (db *PublishDB) RecomputeWatermarks(ctx context.Context, batchSize int) ([]*WatermarkCorrection, error) {
+	if batchSize <= 0 {
+		return nil, fmt.Errorf("batch size must be positive, got %d", batchSize)
+	}
+
+	latest, err := db.scanLatestCreatedAt(ctx, batchSize)
+	if err != nil {
+		return nil, err
+	}
+
+	var corrections []*WatermarkCorrection
+	err = db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		stored, err := readWatermarks(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		for region, createdAt := range latest {
+			previous, ok := stored[region]
+			if ok && !previous.Before(createdAt) {
+				continue
+			}
+			if _, err := tx.Exec(ctx, `
+				INSERT INTO
+					RegionWatermark
+					(region, max_created_at)
+				VALUES
+					($1, $2)
+				ON CONFLICT (region) DO UPDATE
+					SET max_created_at = GREATEST(RegionWatermark.max_created_at, $2)
+			`, region, createdAt); err != nil {
+				return fmt.Errorf("repairing region watermark %v: %w", region, err)
+			}
+			corrections = append(corrections, &WatermarkCorrection{Region: region, Previous: previous, Corrected: createdAt})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(corrections, func(i, j int) bool { return corrections[i].Region < corrections[j].Region })
+	return corrections, nil
+}
+
+// readWatermarks returns the stored watermark of each region.
+func readWatermarks(ctx context.Context, tx pgx.Tx) (map[string]time.Time, error) {
+	stored := make(map[string]time.Time)
+	rows, err := tx.Query(ctx, `SELECT region, max_created_at FROM RegionWatermark`)
+	if err != nil {
+		return nil, fmt.Errorf("reading region watermarks: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			region string
+			t      time.Time
+		)
+		if err := rows.Scan(&region, &t); err != nil {
+			return nil, fmt.Errorf("reading region watermarks: %w", err)
+		}
+		stored[region] = t
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading region watermarks: %w", err)
+	}
+	return stored, nil
+}
+
+// scanLatestCreatedAt returns the newest CreatedAt of a local exposure in each
+// region, reading the Exposure table in (created_at, exposure_key) order
+// batchSize rows at a time.
+func (db *PublishDB) scanLatestCreatedAt(ctx context.Context, batchSize int) (map[string]time.Time, error) {
+	conn, err := db.db.Pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acquiring connection: %w", err)
+	}
+	defer conn.Release()
+
+	latest := make(map[string]time.Time)
+	var (
+		lastCreatedAt time.Time
+		lastKey       string
+	)
+	for {
+		rows, err := conn.Query(ctx, `
+			SELECT
+				created_at, exposure_key, regions
+			FROM
+				Exposure
+			WHERE
+				local_provenance = true
+				AND (created_at, exposure_key) > ($1, $2)
+			ORDER BY
+				created_at, exposure_key
+			LIMIT $3
+		`, lastCreatedAt, lastKey, batchSize)
+		if err != nil {
+			return nil, fmt.Errorf("scanning exposures: %w", err)
+		}
+
+		n := 0
+		for rows.Next() {
+			var regions []string
+			if err := rows.Scan(&lastCreatedAt, &lastKey, &regions); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("scanning exposures: %w", err)
+			}
+			n++
+			// Rows are in created_at order, so the last seen is the newest.
+			for _, region := range regions {
+				latest[region] = lastCreatedAt
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("scanning exposures: %w", err)
+		}
+		if n < batchSize {
+			return latest, nil
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRecomputeWatermarks(t *testing.T) {
+	t.Parallel()
+
+	testDB := database.NewTestDatabase(t)
+	testPublishDB := New(testDB)
+	ctx := context.Background()
+
+	batchTime := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC).Truncate(time.Microsecond)
+	exposures := []*model.Exposure{
+		{
+			ExposureKey:     []byte("ABC"),
+			Regions:         []string{"US", "CA"},
+			CreatedAt:       batchTime,
+			LocalProvenance: true,
+		},
+		{
+			ExposureKey:     []byte("DEF"),
+			Regions:         []string{"CA"},
+			CreatedAt:       batchTime.Add(1 * time.Hour),
+			LocalProvenance: true,
+		},
+		{
+			ExposureKey:     []byte("GHI"),
+			Regions:         []string{"MX"},
+			CreatedAt:       batchTime.Add(2 * time.Hour),
+			LocalProvenance: true,
+		},
+		{
+			ExposureKey:     []byte("123"),
+			Regions:         []string{"US"},
+			CreatedAt:       batchTime.Add(3 * time.Hour),
+			LocalProvenance: false, // Federated keys do not advance the watermark.
+		},
+	}
+	if err := testPublishDB.InsertExposures(ctx, exposures); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a backfill that bypassed InsertExposures: CA is behind and MX is
+	// missing entirely.
+	if _, err := testDB.Pool.Exec(ctx, `UPDATE RegionWatermark SET max_created_at = $1 WHERE region = 'CA'`, batchTime.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testDB.Pool.Exec(ctx, `DELETE FROM RegionWatermark WHERE region = 'MX'`); err != nil {
+		t.Fatal(err)
+	}
+
+	// A batch size smaller than the table exercises paging.
+	got, err := testPublishDB.RecomputeWatermarks(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*WatermarkCorrection{
+		{Region: "CA", Previous: batchTime.Add(-time.Hour), Corrected: batchTime.Add(1 * time.Hour)},
+		{Region: "MX", Corrected: batchTime.Add(2 * time.Hour)},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("corrections mismatch (-want, +got):\n%s", diff)
+	}
+
+	for region, want := range map[string]time.Time{
+		"US": batchTime,
+		"CA": batchTime.Add(1 * time.Hour),
+		"MX": batchTime.Add(2 * time.Hour),
+	} {
+		got, err := testPublishDB.LatestCreatedAt(ctx, []string{region})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equal(want) {
+			t.Errorf("LatestCreatedAt(%v)=%v, want %v", region, got, want)
+		}
+	}
+
+	// Running again finds nothing to correct.
+	got, err = testPublishDB.RecomputeWatermarks(ctx, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("second recompute made corrections: %v", got)
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	aamodel "github.com/google/exposure-notifications-server/internal/authorizedapp/model"
+	coredb "github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/publish/database"
+	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1alpha1"
+)
+
+// maxIdempotencyKeyLength bounds the idempotency keys clients may send.
+const maxIdempotencyKeyLength = 128
+
+// publishResultStore keeps the results of publishes sent with an idempotency key.
+type publishResultStore interface {
+	GetPublishResult(ctx context.Context, appPackageName, idempotencyKey string, now time.Time) (*database.PublishResult, error)
+	SavePublishResult(ctx context.Context, r *database.PublishResult, now time.Time) error
+}
+
+// publishRequestHash identifies the content of a publish request. Padding,
+// which a client may regenerate on each retry, is not included.
+func publishRequestHash(data *verifyapi.Publish) ([]byte, error) {
+	content := *data
+	content.Padding = ""
+	content.IdempotencyKey = ""
+	b, err := json.Marshal(&content)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(b)
+	return sum[:], nil
+}
+
+// replayPublish looks up the result of an earlier request with the same
+// idempotency key. If there is one, the response to send instead of processing
+// the request is returned. Otherwise the hash of the request, to save with its
+// result, is returned, or nil if the request has no idempotency key.
+func (h *publishHandler) replayPublish(ctx context.Context, appConfig *aamodel.AuthorizedApp, data *verifyapi.Publish, now time.Time) ([]byte, *response) {
+	if data.IdempotencyKey == "" || h.config.IdempotencyKeyTTL <= 0 {
+		return nil, nil
+	}
+	logger := logging.FromContext(ctx)
+
+	if len(data.IdempotencyKey) > maxIdempotencyKeyLength {
+		message := fmt.Sprintf("idempotency key has %d characters, at most %d are allowed", len(data.IdempotencyKey), maxIdempotencyKeyLength)
+		return nil, &response{status: http.StatusBadRequest, message: message, metric: "publish-bad-idempotency-key", count: 1}
+	}
+	requestHash, err := publishRequestHash(data)
+	if err != nil {
+		logger.Errorf("hashing publish request: %v", err)
+		return nil, nil
+	}
+
+	// If the results cannot be read, the request is processed; key level
+	// deduplication still applies.
+	result, err := h.results.GetPublishResult(ctx, appConfig.AppPackageName, data.IdempotencyKey, now)
+	if errors.Is(err, coredb.ErrNotFound) {
+		return requestHash, nil
+	}
+	if err != nil {
+		logger.Errorf("reading publish result: %v", err)
+		h.serverenv.MetricsExporter(ctx).WriteInt("publish-idempotency-error", true, 1)
+		return requestHash, nil
+	}
+
+	if !bytes.Equal(result.RequestHash, requestHash) {
+		message := "idempotency key was already used for another request"
+		logger.Warn(message)
+		return nil, &response{status: http.StatusUnprocessableEntity, message: message, metric: "publish-idempotency-key-reused", count: 1}
+	}
+	logger.Infof("Replaying result of publish with idempotency key %q", data.IdempotencyKey)
+	return nil, &response{status: result.Status, message: result.Message, metric: "publish-idempotent-replay", count: 1}
+}
+
+// savePublishResult keeps resp as the result of a request with the given
+// hash, as returned by replayPublish, for retries to replay. Only successful
+// results are kept, so that a retry after a failure is processed again.
+func (h *publishHandler) savePublishResult(ctx context.Context, appConfig *aamodel.AuthorizedApp, data *verifyapi.Publish, requestHash []byte, resp response, now time.Time) {
+	if requestHash == nil || resp.status != http.StatusOK {
+		return
+	}
+	result := &database.PublishResult{
+		AppPackageName: appConfig.AppPackageName,
+		IdempotencyKey: data.IdempotencyKey,
+		RequestHash:    requestHash,
+		Status:         resp.status,
+		Message:        resp.message,
+		ExpiresAt:      now.Add(h.config.IdempotencyKeyTTL),
+	}
+	if err := h.results.SavePublishResult(ctx, result, now); err != nil {
+		// The keys are stored, so a retry would only insert nothing.
+		logging.FromContext(ctx).Errorf("saving publish result: %v", err)
+		h.serverenv.MetricsExporter(ctx).WriteInt("publish-idempotency-error", true, 1)
+	}
+}
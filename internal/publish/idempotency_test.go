@@ -0,0 +1,160 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	aamodel "github.com/google/exposure-notifications-server/internal/authorizedapp/model"
+	coredb "github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/publish/database"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1alpha1"
+)
+
+// fakeResultStore keeps publish results in memory, expiring them as the database would.
+type fakeResultStore struct {
+	results map[string]*database.PublishResult
+}
+
+func (s *fakeResultStore) GetPublishResult(_ context.Context, appPackageName, idempotencyKey string, now time.Time) (*database.PublishResult, error) {
+	r, ok := s.results[appPackageName+"/"+idempotencyKey]
+	if !ok || !r.ExpiresAt.After(now) {
+		return nil, coredb.ErrNotFound
+	}
+	return r, nil
+}
+
+func (s *fakeResultStore) SavePublishResult(_ context.Context, r *database.PublishResult, now time.Time) error {
+	key := r.AppPackageName + "/" + r.IdempotencyKey
+	if existing, ok := s.results[key]; ok && existing.ExpiresAt.After(now) {
+		return nil
+	}
+	s.results[key] = r
+	return nil
+}
+
+func TestIdempotentPublish(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	const ttl = time.Hour
+	h := &publishHandler{
+		config:    &Config{IdempotencyKeyTTL: ttl},
+		serverenv: serverenv.New(ctx),
+		results:   &fakeResultStore{results: map[string]*database.PublishResult{}},
+	}
+	app := &aamodel.AuthorizedApp{AppPackageName: "com.example.app"}
+	start := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	newRequest := func() *verifyapi.Publish {
+		return &verifyapi.Publish{
+			Keys:           []verifyapi.ExposureKey{{Key: "AAAAAAAAAAAAAAAAAAAAAA==", IntervalNumber: 100, IntervalCount: 144}},
+			Regions:        []string{"US"},
+			AppPackageName: app.AppPackageName,
+			Padding:        "first",
+			IdempotencyKey: "retry-me",
+		}
+	}
+
+	// publish runs the request the way handleRequest does, counting requests which are processed.
+	processed := 0
+	publish := func(data *verifyapi.Publish, now time.Time) response {
+		requestHash, resp := h.replayPublish(ctx, app, data, now)
+		if resp != nil {
+			return *resp
+		}
+		processed++
+		result := response{status: http.StatusOK, message: "Inserted 1 exposures.", metric: "publish-exposures-written", count: 1}
+		h.savePublishResult(ctx, app, data, requestHash, result, now)
+		return result
+	}
+
+	// A fresh submission is processed.
+	first := publish(newRequest(), start)
+	if first.status != http.StatusOK || processed != 1 {
+		t.Fatalf("fresh publish: got status %d after %d processed, want 200 after 1", first.status, processed)
+	}
+
+	// A retry, even with new padding, gets the original result without being processed.
+	retry := newRequest()
+	retry.Padding = "second"
+	got := publish(retry, start.Add(ttl/2))
+	if processed != 1 {
+		t.Errorf("idempotent retry was processed")
+	}
+	if got.status != first.status || got.message != first.message {
+		t.Errorf("idempotent retry: got %d %q, want %d %q", got.status, got.message, first.status, first.message)
+	}
+	if got.metric != "publish-idempotent-replay" {
+		t.Errorf("idempotent retry: got metric %q, want publish-idempotent-replay", got.metric)
+	}
+
+	// Reusing the key for another request is rejected.
+	other := newRequest()
+	other.Regions = []string{"CA"}
+	if got := publish(other, start.Add(ttl/2)); got.status != http.StatusUnprocessableEntity || processed != 1 {
+		t.Errorf("reused key: got status %d after %d processed, want %d after 1", got.status, processed, http.StatusUnprocessableEntity)
+	}
+
+	// Once the key expires, a retry is processed again.
+	if got := publish(newRequest(), start.Add(ttl)); got.status != http.StatusOK || processed != 2 {
+		t.Errorf("expired key: got status %d after %d processed, want 200 after 2", got.status, processed)
+	}
+}
+
+func TestIdempotentPublishNotKept(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := &fakeResultStore{results: map[string]*database.PublishResult{}}
+	h := &publishHandler{
+		config:    &Config{IdempotencyKeyTTL: time.Hour},
+		serverenv: serverenv.New(ctx),
+		results:   store,
+	}
+	app := &aamodel.AuthorizedApp{AppPackageName: "com.example.app"}
+	now := time.Now()
+
+	// Failures are not kept, so that a retry is processed again.
+	data := &verifyapi.Publish{IdempotencyKey: "failed"}
+	requestHash, resp := h.replayPublish(ctx, app, data, now)
+	if resp != nil {
+		t.Fatalf("replayPublish() with no result: got %+v, want nil", resp)
+	}
+	h.savePublishResult(ctx, app, data, requestHash, response{status: http.StatusServiceUnavailable}, now)
+	if len(store.results) != 0 {
+		t.Errorf("failed publish result was kept")
+	}
+
+	// Without an idempotency key, or with it disabled, nothing is looked up or kept.
+	if requestHash, resp := h.replayPublish(ctx, app, &verifyapi.Publish{}, now); requestHash != nil || resp != nil {
+		t.Errorf("replayPublish() without a key: got %x, %+v, want nil", requestHash, resp)
+	}
+	h.config.IdempotencyKeyTTL = 0
+	if requestHash, resp := h.replayPublish(ctx, app, &verifyapi.Publish{IdempotencyKey: "disabled"}, now); requestHash != nil || resp != nil {
+		t.Errorf("replayPublish() when disabled: got %x, %+v, want nil", requestHash, resp)
+	}
+	h.config.IdempotencyKeyTTL = time.Hour
+
+	// Overlong keys are rejected.
+	_, resp = h.replayPublish(ctx, app, &verifyapi.Publish{IdempotencyKey: strings.Repeat("k", maxIdempotencyKeyLength+1)}, now)
+	if resp == nil || resp.status != http.StatusBadRequest {
+		t.Errorf("replayPublish() with a long key: got %+v, want status %d", resp, http.StatusBadRequest)
+	}
+}
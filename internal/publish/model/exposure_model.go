@@ -15,6 +15,7 @@
 package model
 
 import (
+	"errors"
 	"fmt"
 	"sort"
 	"strings"
@@ -79,6 +80,27 @@ type Exposure struct {
 	CreatedAt        time.Time `db:"created_at"`
 	LocalProvenance  bool      `db:"local_provenance"`
 	FederationSyncID int64     `db:"sync_id"`
+	Namespace        string    `db:"namespace"`
+	// Unverified marks an exposure that was accepted without its diagnosis
+	// certificate being verified, because the verification backend was
+	// unavailable, so that it can be quarantined.
+	Unverified bool `db:"unverified"`
+	// ExpiresAt, if set, is when this exposure stops being served and becomes
+	// eligible for deletion, ahead of the global retention period.
+	ExpiresAt time.Time `db:"expires_at"`
+}
+
+// ExposureTombstone records that an exposure key was purged, so that the
+// deletion can be propagated to federation partners. It deliberately carries
+// only the key, its validity interval, and the regions it was published to, so
+// that the deletion is only federated to partners authorized for them.
+type ExposureTombstone struct {
+	ExposureKey    []byte    `db:"exposure_key"`
+	IntervalNumber int32     `db:"interval_number"`
+	IntervalCount  int32     `db:"interval_count"`
+	Namespace      string    `db:"namespace"`
+	Regions        []string  `db:"regions"`
+	DeletedAt      time.Time `db:"deleted_at"`
 }
 
 // IntervalNumber calculates the exposure notification system interval
@@ -92,29 +114,152 @@ func TruncateWindow(t time.Time, d time.Duration) time.Time {
 	return t.Truncate(d)
 }
 
+// Handling of published keys with no IntervalCount, see Transformer.
+const (
+	// MissingIntervalCountReject rejects the publish.
+	MissingIntervalCountReject = "reject"
+	// MissingIntervalCountSkip drops the key and accepts the rest of the publish.
+	MissingIntervalCountSkip = "skip"
+	// MissingIntervalCountDefault sets the IntervalCount of a key which started
+	// at least a day ago to a full day. Keys which started more recently are
+	// dropped, since their end cannot be inferred.
+	MissingIntervalCountDefault = "default"
+)
+
+// Handling of published full day keys whose IntervalNumber is not on a UTC day
+// boundary, i.e. not a multiple of MaxIntervalCount, see Transformer.
+const (
+	// IntervalAlignmentOff accepts the key as is.
+	IntervalAlignmentOff = "off"
+	// IntervalAlignmentReject rejects the publish.
+	IntervalAlignmentReject = "reject"
+	// IntervalAlignmentSnap moves the key's IntervalNumber to the nearest day
+	// boundary. The key is then validated as if it had been published so.
+	IntervalAlignmentSnap = "snap"
+)
+
 // Transformer represents a configured Publish -> Exposure[] transformer.
 type Transformer struct {
 	maxExposureKeys     int
 	maxIntervalStartAge time.Duration // How many intervals old does this server accept?
 	truncateWindow      time.Duration
-	debugAllowRestOfDay bool // raises end time of keys to the end of day, but doesn't embargo. For e2e testing only.
+	debugAllowRestOfDay bool          // raises end time of keys to the end of day, but doesn't embargo. For e2e testing only.
+	strictIntervalCount bool          // rejects keys whose IntervalCount is inconsistent with their age, see ValidateIntervalCount.
+	maxIntervalSpan     time.Duration // if > 0, how much time the keys of one publish may cover, see ValidateIntervalSpan.
+	maxKeyAge           time.Duration // if > 0, how long after it ends a key is accepted, see ValidateKeyAge.
+	missingCount        string        // how keys with no IntervalCount are handled, one of the MissingIntervalCount values.
+	alignment           string        // how misaligned full day keys are handled, one of the IntervalAlignment values.
+}
+
+// TransformerConfig configures a Transformer.
+type TransformerConfig struct {
+	// MaxExposureKeys is the most keys a single publish may contain.
+	MaxExposureKeys int
+	// MaxIntervalStartAge is how long ago a key may have started.
+	MaxIntervalStartAge time.Duration
+	// TruncateWindow is the window the creation time of keys is truncated to.
+	TruncateWindow time.Duration
+	// DebugAllowRestOfDay raises the end time of keys to the end of the day,
+	// but doesn't embargo them. For e2e testing only.
+	DebugAllowRestOfDay bool
+	// StrictIntervalCount rejects keys whose IntervalCount is inconsistent
+	// with their age, see ValidateIntervalCount.
+	StrictIntervalCount bool
+	// MaxIntervalSpan, if > 0, is how much time the keys of one publish may
+	// cover, see ValidateIntervalSpan.
+	MaxIntervalSpan time.Duration
+	// MaxKeyAge, if > 0, is how long after it ends a key is accepted, see
+	// ValidateKeyAge.
+	MaxKeyAge time.Duration
+	// MissingIntervalCount is how keys with no IntervalCount are handled, one
+	// of the MissingIntervalCount values. Empty means MissingIntervalCountReject.
+	MissingIntervalCount string
+	// IntervalAlignment is how misaligned full day keys are handled, one of
+	// the IntervalAlignment values. Empty means IntervalAlignmentOff.
+	IntervalAlignment string
 }
 
 // NewTransformer creates a transformer for turning publish API requests into
 // records for insertion into the database. On the call to TransformPublish
 // all data is validated according to the transformer that is used.
-func NewTransformer(maxExposureKeys int, maxIntervalStartAge time.Duration, truncateWindow time.Duration, allowRestOfDay bool) (*Transformer, error) {
-	if maxExposureKeys < 0 || maxExposureKeys > verifyapi.MaxKeysPerPublish {
-		return nil, fmt.Errorf("maxExposureKeys must be > 0 and <= %v, got %v", verifyapi.MaxKeysPerPublish, maxExposureKeys)
+func NewTransformer(config *TransformerConfig) (*Transformer, error) {
+	if config.MaxExposureKeys < 0 || config.MaxExposureKeys > verifyapi.MaxKeysPerPublish {
+		return nil, fmt.Errorf("maxExposureKeys must be > 0 and <= %v, got %v", verifyapi.MaxKeysPerPublish, config.MaxExposureKeys)
+	}
+	if config.MaxIntervalSpan < 0 {
+		return nil, fmt.Errorf("maxIntervalSpan must be >= 0, got %v", config.MaxIntervalSpan)
+	}
+	if config.MaxKeyAge < 0 {
+		return nil, fmt.Errorf("maxKeyAge must be >= 0, got %v", config.MaxKeyAge)
+	}
+	missingCount := config.MissingIntervalCount
+	switch missingCount {
+	case "":
+		missingCount = MissingIntervalCountReject
+	case MissingIntervalCountReject, MissingIntervalCountSkip, MissingIntervalCountDefault:
+	default:
+		return nil, fmt.Errorf("missingCount must be one of %q, %q or %q, got %q",
+			MissingIntervalCountReject, MissingIntervalCountSkip, MissingIntervalCountDefault, missingCount)
+	}
+	alignment := config.IntervalAlignment
+	switch alignment {
+	case "":
+		alignment = IntervalAlignmentOff
+	case IntervalAlignmentOff, IntervalAlignmentReject, IntervalAlignmentSnap:
+	default:
+		return nil, fmt.Errorf("alignment must be one of %q, %q or %q, got %q",
+			IntervalAlignmentOff, IntervalAlignmentReject, IntervalAlignmentSnap, alignment)
 	}
 	return &Transformer{
-		maxExposureKeys:     maxExposureKeys,
-		maxIntervalStartAge: maxIntervalStartAge,
-		truncateWindow:      truncateWindow,
-		debugAllowRestOfDay: allowRestOfDay,
+		maxExposureKeys:     config.MaxExposureKeys,
+		maxIntervalStartAge: config.MaxIntervalStartAge,
+		truncateWindow:      config.TruncateWindow,
+		debugAllowRestOfDay: config.DebugAllowRestOfDay,
+		strictIntervalCount: config.StrictIntervalCount,
+		maxIntervalSpan:     config.MaxIntervalSpan,
+		maxKeyAge:           config.MaxKeyAge,
+		missingCount:        missingCount,
+		alignment:           alignment,
 	}, nil
 }
 
+// ValidateIntervalCount checks that the IntervalCount of a key is consistent
+// with its age at time now. A key which started before the current UTC day
+// must cover the full day, only the current day's key may be partial.
+func ValidateIntervalCount(intervalNumber, intervalCount int32, now time.Time) error {
+	if intervalCount < verifyapi.MinIntervalCount || intervalCount > verifyapi.MaxIntervalCount {
+		return fmt.Errorf("invalid interval count, %v, must be >= %v && <= %v", intervalCount, verifyapi.MinIntervalCount, verifyapi.MaxIntervalCount)
+	}
+	today := IntervalNumber(now.UTC().Truncate(24 * time.Hour))
+	if intervalNumber < today && intervalCount != verifyapi.MaxIntervalCount {
+		return fmt.Errorf("interval count %v for key starting at interval %v before the current day, must be %v", intervalCount, intervalNumber, verifyapi.MaxIntervalCount)
+	}
+	return nil
+}
+
+// AlignIntervalNumber returns the UTC day boundary, a multiple of
+// MaxIntervalCount, nearest to intervalNumber. Halfway between two boundaries,
+// the later one is returned.
+func AlignIntervalNumber(intervalNumber int32) int32 {
+	const day = verifyapi.MaxIntervalCount
+	return (intervalNumber + day/2) / day * day
+}
+
+// ErrKeyTooOld is returned by ValidateKeyAge for a key which ended before the
+// retention period.
+var ErrKeyTooOld = errors.New("key is older than the retention period")
+
+// ValidateKeyAge checks that a key ended within maxAge of time now. Such a key
+// would be deleted by cleanup, and skipped until then, so there is no point
+// storing it. A key which is only partially within maxAge is accepted.
+func ValidateKeyAge(intervalNumber, intervalCount int32, now time.Time, maxAge time.Duration) error {
+	end := intervalNumber + intervalCount
+	if min := IntervalNumber(now.Add(-maxAge)); end <= min {
+		return fmt.Errorf("%w: interval number %v + interval count %v ends at interval %v, must end > %v", ErrKeyTooOld, intervalNumber, intervalCount, end, min)
+	}
+	return nil
+}
+
 // TransformExposureKey converts individual key data to an exposure entity.
 // Validations during the transform include:
 //
@@ -166,11 +311,32 @@ func TransformExposureKey(exposureKey verifyapi.ExposureKey, appPackageName stri
 	}, nil
 }
 
+// ValidateIntervalSpan checks that exposures, which must be sorted by
+// IntervalNumber, cover at most maxSpan from the start of the first to the end
+// of the last. A device's keys cover about 14 days, a much wider span
+// indicates fabricated keys.
+func ValidateIntervalSpan(exposures []*Exposure, maxSpan time.Duration) error {
+	if len(exposures) == 0 {
+		return nil
+	}
+	first, last := exposures[0], exposures[len(exposures)-1]
+	intervals := int64(last.IntervalNumber + last.IntervalCount - first.IntervalNumber)
+	if span := time.Duration(intervals) * verifyapi.IntervalLength; span > maxSpan {
+		return fmt.Errorf("keys span %v from interval %v, max of %v is allowed", span, first.IntervalNumber, maxSpan)
+	}
+	return nil
+}
+
 // TransformPublish converts incoming key data to a list of exposure entities.
 // The data in the request is validated during the transform, including:
 //
 // * 0 exposure Keys in the requests
 // * > Transformer.maxExposureKeys in the request
+// * if strict interval counts are enabled, keys failing ValidateIntervalCount
+// * if a maximum interval span is set, keys failing ValidateIntervalSpan
+// * if a maximum key age is set, keys failing ValidateKeyAge
+// * keys with no interval count, unless they are skipped or defaulted
+// * full day keys not on a day boundary, if alignment is rejected
 //
 func (t *Transformer) TransformPublish(inData *verifyapi.Publish, batchTime time.Time) ([]*Exposure, error) {
 	// Validate the number of keys that want to be published.
@@ -205,13 +371,43 @@ func (t *Transformer) TransformPublish(inData *verifyapi.Publish, batchTime time
 	}
 
 	for _, exposureKey := range inData.Keys {
+		if exposureKey.IntervalCount == 0 && t.missingCount != MissingIntervalCountReject {
+			// A key which started a full day before the latest allowed end has
+			// ended, whatever its real interval count was.
+			if t.missingCount == MissingIntervalCountDefault && exposureKey.IntervalNumber+verifyapi.MaxIntervalCount <= maxIntervalNumber {
+				exposureKey.IntervalCount = verifyapi.MaxIntervalCount
+			} else {
+				continue
+			}
+		}
+		if exposureKey.IntervalCount == verifyapi.MaxIntervalCount && t.alignment != IntervalAlignmentOff {
+			aligned := AlignIntervalNumber(exposureKey.IntervalNumber)
+			if aligned != exposureKey.IntervalNumber && t.alignment == IntervalAlignmentReject {
+				return nil, fmt.Errorf("invalid publish data: interval number %v of a full day key is not a multiple of %v", exposureKey.IntervalNumber, verifyapi.MaxIntervalCount)
+			}
+			exposureKey.IntervalNumber = aligned
+		}
 		exposure, err := TransformExposureKey(exposureKey, inData.AppPackageName, upcaseRegions, createdAt, minIntervalNumber, maxIntervalNumber)
 		if err != nil {
 			return nil, fmt.Errorf("invalid publish data: %v", err)
 		}
+		if t.strictIntervalCount {
+			if err := ValidateIntervalCount(exposure.IntervalNumber, exposure.IntervalCount, batchTime); err != nil {
+				return nil, fmt.Errorf("invalid publish data: %v", err)
+			}
+		}
+		if t.maxKeyAge > 0 {
+			if err := ValidateKeyAge(exposure.IntervalNumber, exposure.IntervalCount, batchTime, t.maxKeyAge); err != nil {
+				return nil, fmt.Errorf("invalid publish data: %w", err)
+			}
+		}
 		entities = append(entities, exposure)
 	}
 
+	if len(entities) == 0 {
+		return nil, fmt.Errorf("no exposure keys with an interval count in publish request")
+	}
+
 	// Ensure that the uploaded keys are for a consecutive time period. No
 	// overlaps and no gaps.
 	// 1) Sort by interval number.
@@ -228,5 +424,11 @@ func (t *Transformer) TransformPublish(inData *verifyapi.Publish, batchTime time
 		nextInterval = ex.IntervalNumber + ex.IntervalCount
 	}
 
+	if t.maxIntervalSpan > 0 {
+		if err := ValidateIntervalSpan(entities, t.maxIntervalSpan); err != nil {
+			return nil, fmt.Errorf("invalid publish data: %v", err)
+		}
+	}
+
 	return entities, nil
 }
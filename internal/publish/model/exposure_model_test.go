@@ -17,6 +17,7 @@ package model
 import (
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"sort"
 	"strings"
@@ -43,7 +44,7 @@ func TestInvalidNew(t *testing.T) {
 	}
 
 	for i, c := range cases {
-		_, err := NewTransformer(c.maxKeys, time.Hour, time.Hour, false)
+		_, err := NewTransformer(&TransformerConfig{MaxExposureKeys: c.maxKeys, MaxIntervalStartAge: time.Hour, TruncateWindow: time.Hour})
 		if err != nil && errMsg == "" {
 			t.Errorf("%v unexpected error: %v", i, err)
 		} else if err != nil && !strings.Contains(err.Error(), c.message) {
@@ -53,7 +54,7 @@ func TestInvalidNew(t *testing.T) {
 }
 
 func TestInvalidBase64(t *testing.T) {
-	transformer, err := NewTransformer(1, time.Hour*24, time.Hour, false)
+	transformer, err := NewTransformer(&TransformerConfig{MaxExposureKeys: 1, MaxIntervalStartAge: time.Hour * 24, TruncateWindow: time.Hour})
 	if err != nil {
 		t.Fatalf("error creating transformer: %v", err)
 	}
@@ -251,7 +252,7 @@ func TestPublishValidation(t *testing.T) {
 
 	for _, c := range cases {
 		t.Run(c.name, func(t *testing.T) {
-			tf, err := NewTransformer(2, maxAge, time.Hour, c.sameDay)
+			tf, err := NewTransformer(&TransformerConfig{MaxExposureKeys: 2, MaxIntervalStartAge: maxAge, TruncateWindow: time.Hour, DebugAllowRestOfDay: c.sameDay})
 			if err != nil {
 				t.Fatalf("unepected error: %v", err)
 			}
@@ -270,6 +271,68 @@ func TestPublishValidation(t *testing.T) {
 	}
 }
 
+func TestValidateIntervalCount(t *testing.T) {
+	now := time.Date(2020, 2, 29, 11, 15, 1, 0, time.UTC)
+	today := IntervalNumber(now.Truncate(24 * time.Hour))
+	yesterday := today - 144
+
+	cases := []struct {
+		name           string
+		intervalNumber int32
+		intervalCount  int32
+		m              string
+	}{
+		{"full day", yesterday, 144, ""},
+		{"partial current day", today, 50, ""},
+		{"full current day", today, 144, ""},
+		{"partial previous day", yesterday, 100, "before the current day, must be 144"},
+		{"partial previous day unaligned", yesterday + 44, 100, "before the current day, must be 144"},
+		{"zero", today, 0, "invalid interval count"},
+		{"too large", yesterday, 145, "invalid interval count"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := ValidateIntervalCount(c.intervalNumber, c.intervalCount, now)
+			if c.m == "" {
+				if err != nil {
+					t.Errorf("want error nil, got '%v'", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), c.m) {
+				t.Errorf("want error '%v', got '%v'", c.m, err)
+			}
+		})
+	}
+}
+
+func TestStrictIntervalCount(t *testing.T) {
+	batchTime := time.Date(2020, 2, 29, 11, 15, 1, 0, time.UTC)
+	today := IntervalNumber(batchTime.Truncate(24 * time.Hour))
+	publish := &verifyapi.Publish{
+		Keys: []verifyapi.ExposureKey{
+			{
+				Key:            encodeKey(generateKey(t)),
+				IntervalNumber: today - 144,
+				IntervalCount:  100,
+			},
+		},
+	}
+
+	for _, strict := range []bool{false, true} {
+		tf, err := NewTransformer(&TransformerConfig{MaxExposureKeys: 1, MaxIntervalStartAge: 24 * time.Hour * 14, TruncateWindow: time.Hour, StrictIntervalCount: strict})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		_, err = tf.TransformPublish(publish, batchTime)
+		if strict && err == nil {
+			t.Errorf("strict: want error, got nil")
+		}
+		if !strict && err != nil {
+			t.Errorf("lenient: want error nil, got '%v'", err)
+		}
+	}
+}
+
 func generateKey(t *testing.T) []byte {
 	t.Helper()
 	key := make([]byte, 16)
@@ -370,7 +433,7 @@ func TestTransform(t *testing.T) {
 	}
 
 	allowedAge := 14 * 24 * time.Hour
-	transformer, err := NewTransformer(10, allowedAge, time.Hour, false)
+	transformer, err := NewTransformer(&TransformerConfig{MaxExposureKeys: 10, MaxIntervalStartAge: allowedAge, TruncateWindow: time.Hour})
 	if err != nil {
 		t.Fatalf("NewTransformer returned unexpected error: %v", err)
 	}
@@ -438,7 +501,7 @@ func TestTransformOverlapping(t *testing.T) {
 		t.Run(c.name, func(t *testing.T) {
 			batchTime := captureStartTime.Add(time.Hour * 24 * 7)
 			allowedAge := 14 * 24 * time.Hour
-			transformer, err := NewTransformer(10, allowedAge, time.Hour, false)
+			transformer, err := NewTransformer(&TransformerConfig{MaxExposureKeys: 10, MaxIntervalStartAge: allowedAge, TruncateWindow: time.Hour})
 			if err != nil {
 				t.Fatalf("NewTransformer returned unexpected error: %v", err)
 			}
@@ -641,3 +704,289 @@ func TestApplyOverrides(t *testing.T) {
 		})
 	}
 }
+
+func TestMaxIntervalSpan(t *testing.T) {
+	batchTime := time.Date(2020, 2, 29, 11, 15, 1, 0, time.UTC)
+	today := IntervalNumber(batchTime.Truncate(24 * time.Hour))
+	const day = verifyapi.MaxIntervalCount
+	maxSpan := 15 * 24 * time.Hour
+
+	cases := []struct {
+		name     string
+		starts   []int32
+		maxSpan  time.Duration
+		errorMsg string
+	}{
+		{
+			name:    "tight",
+			maxSpan: maxSpan,
+			starts:  []int32{today - 2*day, today - day},
+		},
+		{
+			name:    "borderline",
+			maxSpan: maxSpan,
+			starts:  []int32{today - 15*day, today - day},
+		},
+		{
+			name:     "one interval too wide",
+			maxSpan:  maxSpan,
+			starts:   []int32{today - 15*day - 1, today - day},
+			errorMsg: "keys span 360h10m0s",
+		},
+		{
+			name:     "absurd",
+			maxSpan:  maxSpan,
+			starts:   []int32{today - 300*day, today - day},
+			errorMsg: "keys span 7200h0m0s",
+		},
+		{
+			name:   "absurd without a limit",
+			starts: []int32{today - 300*day, today - day},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			publish := &verifyapi.Publish{}
+			for _, start := range c.starts {
+				publish.Keys = append(publish.Keys, verifyapi.ExposureKey{
+					Key:            encodeKey(generateKey(t)),
+					IntervalNumber: start,
+					IntervalCount:  day,
+				})
+			}
+			tf, err := NewTransformer(&TransformerConfig{MaxExposureKeys: 2, MaxIntervalStartAge: 365 * 24 * time.Hour, TruncateWindow: time.Hour, MaxIntervalSpan: c.maxSpan})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			_, err = tf.TransformPublish(publish, batchTime)
+			if c.errorMsg == "" && err != nil {
+				t.Errorf("want error nil, got '%v'", err)
+			}
+			if c.errorMsg != "" && (err == nil || !strings.Contains(err.Error(), c.errorMsg)) {
+				t.Errorf("want error '%v', got '%v'", c.errorMsg, err)
+			}
+		})
+	}
+}
+
+func TestMaxKeyAge(t *testing.T) {
+	batchTime := time.Date(2020, 2, 29, 11, 15, 1, 0, time.UTC)
+	const day = verifyapi.MaxIntervalCount
+	maxAge := 14 * 24 * time.Hour
+	oldest := IntervalNumber(batchTime.Add(-maxAge))
+
+	cases := []struct {
+		name    string
+		start   int32
+		tooOld  bool
+		noLimit bool
+	}{
+		{
+			name:  "fresh",
+			start: IntervalNumber(batchTime.Truncate(24*time.Hour)) - 2*day,
+		},
+		{
+			name:  "borderline",
+			start: oldest - day + 1,
+		},
+		{
+			name:   "fully old",
+			start:  oldest - day,
+			tooOld: true,
+		},
+		{
+			name:   "absurd",
+			start:  oldest - 300*day,
+			tooOld: true,
+		},
+		{
+			name:    "old without a limit",
+			start:   oldest - day,
+			noLimit: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			publish := &verifyapi.Publish{
+				Keys: []verifyapi.ExposureKey{{
+					Key:            encodeKey(generateKey(t)),
+					IntervalNumber: c.start,
+					IntervalCount:  day,
+				}},
+			}
+			limit := maxAge
+			if c.noLimit {
+				limit = 0
+			}
+			tf, err := NewTransformer(&TransformerConfig{MaxExposureKeys: 1, MaxIntervalStartAge: 365 * 24 * time.Hour, TruncateWindow: time.Hour, MaxKeyAge: limit})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			_, err = tf.TransformPublish(publish, batchTime)
+			if got := errors.Is(err, ErrKeyTooOld); got != c.tooOld || (!c.tooOld && err != nil) {
+				t.Errorf("TransformPublish() returned err=%v, want too old: %v", err, c.tooOld)
+			}
+		})
+	}
+
+	if _, err := NewTransformer(&TransformerConfig{MaxExposureKeys: 1, MaxIntervalStartAge: time.Hour, TruncateWindow: time.Hour, MaxKeyAge: -time.Hour}); err == nil {
+		t.Errorf("NewTransformer with negative maxKeyAge returned err=nil")
+	}
+}
+
+func TestMissingIntervalCount(t *testing.T) {
+	batchTime := time.Date(2020, 2, 29, 11, 15, 1, 0, time.UTC)
+	today := IntervalNumber(batchTime.Truncate(24 * time.Hour))
+	const day = verifyapi.MaxIntervalCount
+
+	key := func(start, count int32) verifyapi.ExposureKey {
+		return verifyapi.ExposureKey{Key: encodeKey(generateKey(t)), IntervalNumber: start, IntervalCount: count}
+	}
+	complete := key(today-2*day, day)
+	missing := key(today-day, 0)
+	missingToday := key(today, 0)
+
+	type interval struct{ start, count int32 }
+	cases := []struct {
+		name     string
+		mode     string
+		keys     []verifyapi.ExposureKey
+		want     []interval
+		errorMsg string
+	}{
+		{
+			name:     "reject",
+			mode:     MissingIntervalCountReject,
+			keys:     []verifyapi.ExposureKey{complete, missing},
+			errorMsg: "invalid interval count, 0",
+		},
+		{
+			name: "skip",
+			mode: MissingIntervalCountSkip,
+			keys: []verifyapi.ExposureKey{complete, missing, missingToday},
+			want: []interval{{today - 2*day, day}},
+		},
+		{
+			name: "default",
+			mode: MissingIntervalCountDefault,
+			keys: []verifyapi.ExposureKey{complete, missing, missingToday},
+			want: []interval{{today - 2*day, day}, {today - day, day}},
+		},
+		{
+			name:     "default leaves nothing",
+			mode:     MissingIntervalCountDefault,
+			keys:     []verifyapi.ExposureKey{missingToday},
+			errorMsg: "no exposure keys with an interval count",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tf, err := NewTransformer(&TransformerConfig{MaxExposureKeys: 3, MaxIntervalStartAge: 15 * 24 * time.Hour, TruncateWindow: time.Hour, MissingIntervalCount: c.mode})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			exposures, err := tf.TransformPublish(&verifyapi.Publish{Keys: c.keys}, batchTime)
+			if c.errorMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), c.errorMsg) {
+					t.Errorf("want error '%v', got '%v'", c.errorMsg, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("want error nil, got '%v'", err)
+			}
+			var got []interval
+			for _, exp := range exposures {
+				got = append(got, interval{exp.IntervalNumber, exp.IntervalCount})
+			}
+			if diff := cmp.Diff(c.want, got, cmp.AllowUnexported(interval{})); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+
+	if _, err := NewTransformer(&TransformerConfig{MaxExposureKeys: 1, MaxIntervalStartAge: time.Hour, TruncateWindow: time.Hour, MissingIntervalCount: "guess"}); err == nil {
+		t.Errorf("NewTransformer with unknown missingCount returned err=nil")
+	}
+}
+
+func TestIntervalAlignment(t *testing.T) {
+	batchTime := time.Date(2020, 2, 29, 11, 15, 1, 0, time.UTC)
+	today := IntervalNumber(batchTime.Truncate(24 * time.Hour))
+	const day = verifyapi.MaxIntervalCount
+
+	key := func(start, count int32) verifyapi.ExposureKey {
+		return verifyapi.ExposureKey{Key: encodeKey(generateKey(t)), IntervalNumber: start, IntervalCount: count}
+	}
+	aligned := key(today-4*day, day)
+	early := key(today-2*day-5, day) // Correctable to today-2*day.
+	late := key(today-day+70, day)   // Correctable to today-day.
+	partial := key(today+5, 10)      // Only full day keys are aligned.
+
+	type interval struct{ start, count int32 }
+	cases := []struct {
+		name      string
+		alignment string
+		keys      []verifyapi.ExposureKey
+		want      []interval
+		errorMsg  string
+	}{
+		{
+			name:      "off",
+			alignment: IntervalAlignmentOff,
+			keys:      []verifyapi.ExposureKey{aligned, early, partial},
+			want:      []interval{{today - 4*day, day}, {today - 2*day - 5, day}, {today + 5, 10}},
+		},
+		{
+			name:      "reject aligned",
+			alignment: IntervalAlignmentReject,
+			keys:      []verifyapi.ExposureKey{aligned, partial},
+			want:      []interval{{today - 4*day, day}, {today + 5, 10}},
+		},
+		{
+			name:      "reject misaligned",
+			alignment: IntervalAlignmentReject,
+			keys:      []verifyapi.ExposureKey{aligned, early},
+			errorMsg:  "is not a multiple of 144",
+		},
+		{
+			name:      "snap",
+			alignment: IntervalAlignmentSnap,
+			keys:      []verifyapi.ExposureKey{aligned, early, late, partial},
+			want:      []interval{{today - 4*day, day}, {today - 2*day, day}, {today - day, day}, {today + 5, 10}},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tf, err := NewTransformer(&TransformerConfig{MaxExposureKeys: 4, MaxIntervalStartAge: 15 * 24 * time.Hour, TruncateWindow: time.Hour, IntervalAlignment: c.alignment})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			exposures, err := tf.TransformPublish(&verifyapi.Publish{Keys: c.keys}, batchTime)
+			if c.errorMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), c.errorMsg) {
+					t.Errorf("want error '%v', got '%v'", c.errorMsg, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("want error nil, got '%v'", err)
+			}
+			var got []interval
+			for _, exp := range exposures {
+				got = append(got, interval{exp.IntervalNumber, exp.IntervalCount})
+			}
+			if diff := cmp.Diff(c.want, got, cmp.AllowUnexported(interval{})); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+
+	if _, err := NewTransformer(&TransformerConfig{MaxExposureKeys: 1, MaxIntervalStartAge: time.Hour, TruncateWindow: time.Hour, IntervalAlignment: "round"}); err == nil {
+		t.Errorf("NewTransformer with unknown alignment returned err=nil")
+	}
+}
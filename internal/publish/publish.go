@@ -25,6 +25,7 @@ import (
 	"go.opencensus.io/trace"
 
 	"github.com/google/exposure-notifications-server/internal/authorizedapp"
+	aamodel "github.com/google/exposure-notifications-server/internal/authorizedapp/model"
 	"github.com/google/exposure-notifications-server/internal/jsonutil"
 	"github.com/google/exposure-notifications-server/internal/logging"
 	"github.com/google/exposure-notifications-server/internal/publish/database"
@@ -35,6 +36,17 @@ import (
 	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1alpha1"
 )
 
+// Values of Config.VerificationOutagePolicy.
+const (
+	outagePolicyFailClosed = "fail-closed"
+	outagePolicyFailOpen   = "fail-open"
+)
+
+// diagnosisVerifier verifies the diagnosis certificate on a publish request.
+type diagnosisVerifier interface {
+	VerifyDiagnosisCertificate(ctx context.Context, authApp *aamodel.AuthorizedApp, publish *verifyapi.Publish) (verifyapi.TransmissionRiskVector, error)
+}
+
 // NewHandler creates the HTTP handler for the TTK publishing API.
 func NewHandler(ctx context.Context, config *Config, env *serverenv.ServerEnv) (http.Handler, error) {
 	logger := logging.FromContext(ctx)
@@ -46,21 +58,52 @@ func NewHandler(ctx context.Context, config *Config, env *serverenv.ServerEnv) (
 		return nil, fmt.Errorf("missing AuthorizedApp provider in server environment")
 	}
 
-	transformer, err := model.NewTransformer(config.MaxKeysOnPublish, config.MaxIntervalAge, config.TruncateWindow, config.DebugAllowRestOfDay)
+	transformer, err := model.NewTransformer(&model.TransformerConfig{
+		MaxExposureKeys:      config.MaxKeysOnPublish,
+		MaxIntervalStartAge:  config.MaxIntervalAge,
+		TruncateWindow:       config.TruncateWindow,
+		DebugAllowRestOfDay:  config.DebugAllowRestOfDay,
+		StrictIntervalCount:  config.StrictIntervalCount,
+		MaxIntervalSpan:      config.MaxIntervalSpan,
+		MaxKeyAge:            config.MaxKeyAge,
+		MissingIntervalCount: config.MissingIntervalCount,
+		IntervalAlignment:    config.IntervalAlignment,
+	})
 	if err != nil {
 		return nil, fmt.Errorf("model.NewTransformer: %w", err)
 	}
 	logger.Infof("max keys per upload: %v", config.MaxKeysOnPublish)
 	logger.Infof("max interval start age: %v", config.MaxIntervalAge)
 	logger.Infof("truncate window: %v", config.TruncateWindow)
+	logger.Infof("strict interval count: %v", config.StrictIntervalCount)
+	logger.Infof("max interval span: %v", config.MaxIntervalSpan)
+	logger.Infof("max key age: %v", config.MaxKeyAge)
+	logger.Infof("missing interval count: %v", config.MissingIntervalCount)
+	logger.Infof("max regions on publish: %d", config.MaxRegionsOnPublish)
+
+	// An unset policy fails closed.
+	switch config.VerificationOutagePolicy {
+	case "", outagePolicyFailClosed, outagePolicyFailOpen:
+	default:
+		return nil, fmt.Errorf("unknown verification outage policy %q", config.VerificationOutagePolicy)
+	}
+	logger.Infof("verification outage policy: %v", config.VerificationOutagePolicy)
 
+	webhook := newIngestWebhook(config)
+	if webhook != nil {
+		logger.Infof("ingest webhook: %v", config.IngestWebhookURL)
+	}
+
+	publishDB := database.New(env.Database()).GroupInsertsByRegion(config.GroupInsertsByRegion)
 	return &publishHandler{
 		serverenv:             env,
 		transformer:           transformer,
 		config:                config,
-		database:              database.New(env.Database()),
+		database:              publishDB,
+		results:               publishDB,
 		authorizedAppProvider: env.AuthorizedAppProvider(),
 		verifier:              verification.New(verifydb.New(env.Database())),
+		webhook:               webhook,
 	}, nil
 }
 
@@ -69,8 +112,10 @@ type publishHandler struct {
 	serverenv             *serverenv.ServerEnv
 	transformer           *model.Transformer
 	database              *database.PublishDB
+	results               publishResultStore
 	authorizedAppProvider authorizedapp.Provider
-	verifier              *verification.Verifier
+	verifier              diagnosisVerifier
+	webhook               *ingestWebhook
 }
 
 type response struct {
@@ -86,7 +131,6 @@ func (h *publishHandler) handleRequest(w http.ResponseWriter, r *http.Request) r
 	defer span.End()
 
 	logger := logging.FromContext(ctx)
-	metrics := h.serverenv.MetricsExporter(ctx)
 
 	var data verifyapi.Publish
 	code, err := jsonutil.Unmarshal(w, r, &data)
@@ -125,6 +169,13 @@ func (h *publishHandler) handleRequest(w http.ResponseWriter, r *http.Request) r
 		}
 	}
 
+	// Reject region lists too long to be a real upload before checking each region.
+	if max := h.config.MaxRegionsOnPublish; max > 0 && len(data.Regions) > max {
+		message := fmt.Sprintf("too many regions: got %d, at most %d are allowed", len(data.Regions), max)
+		span.SetStatus(trace.Status{Code: trace.StatusCodeInvalidArgument, Message: message})
+		return response{status: http.StatusBadRequest, message: message, metric: "publish-too-many-regions", count: 1}
+	}
+
 	// Verify the request is from a permitted region.
 	for _, r := range data.Regions {
 		if !appConfig.IsAllowedRegion(r) {
@@ -140,18 +191,19 @@ func (h *publishHandler) handleRequest(w http.ResponseWriter, r *http.Request) r
 		}
 	}
 
+	// A retry of a request which already succeeded gets the original result.
+	// This is checked before verification, since the certificate may only be
+	// accepted once.
+	now := time.Now()
+	requestHash, resp := h.replayPublish(ctx, appConfig, &data, now)
+	if resp != nil {
+		return *resp
+	}
+
 	// Perform health authority certificat verification.
-	overrides, err := h.verifier.VerifyDiagnosisCertificate(ctx, appConfig, &data)
-	if err != nil {
-		if appConfig.BypassHealthAuthorityVerification {
-			logger.Warnf("bypassing health authority certificate verification for app: %v", appConfig.AppPackageName)
-			metrics.WriteInt("publish-health-authority-verification-bypassed", true, 1)
-		} else {
-			message := fmt.Sprintf("unable to validate diagnosis verification: %v", err)
-			logger.Error(message)
-			span.SetStatus(trace.Status{Code: trace.StatusCodeInvalidArgument, Message: message})
-			return response{status: http.StatusUnauthorized, message: message, metric: "publish-bad-verification", count: 1}
-		}
+	overrides, unverified, resp := h.verify(ctx, appConfig, &data)
+	if resp != nil {
+		return *resp
 	}
 
 	// Apply overrides
@@ -165,10 +217,35 @@ func (h *publishHandler) handleRequest(w http.ResponseWriter, r *http.Request) r
 		message := fmt.Sprintf("unable to read request data: %v", err)
 		logger.Error(message)
 		span.SetStatus(trace.Status{Code: trace.StatusCodeInvalidArgument, Message: message})
-		return response{status: http.StatusBadRequest, message: message, metric: "publish-transform-fail", count: 1}
+		metric := "publish-transform-fail"
+		if errors.Is(err, model.ErrKeyTooOld) {
+			metric = "publish-key-too-old"
+		}
+		return response{status: http.StatusBadRequest, message: message, metric: metric, count: 1}
+	}
+	if dropped := len(data.Keys) - len(exposures); dropped > 0 {
+		h.serverenv.MetricsExporter(ctx).WriteInt("publish-missing-interval-count-dropped", true, dropped)
+	}
+	exposures = h.dropBlocklisted(ctx, exposures)
+	retention := h.config.VerifiedKeyRetention
+	if unverified {
+		retention = h.config.UnverifiedKeyRetention
+	}
+	for _, exp := range exposures {
+		exp.Namespace = appConfig.Namespace
+		exp.Unverified = unverified
+		if retention > 0 {
+			exp.ExpiresAt = exp.CreatedAt.Add(retention)
+		}
 	}
 
-	err = h.database.InsertExposures(ctx, exposures)
+	inserted, err := h.database.InsertExposuresLimited(ctx, exposures, &h.config.RegionKeyLimits)
+	if errors.Is(err, database.ErrRegionKeyLimit) {
+		message := fmt.Sprintf("unable to write exposure records: %v", err)
+		logger.Warn(message)
+		span.SetStatus(trace.Status{Code: trace.StatusCodeResourceExhausted, Message: message})
+		return response{status: http.StatusTooManyRequests, message: message, metric: "publish-region-limit-exceeded", count: 1}
+	}
 	if err != nil {
 		message := fmt.Sprintf("error writing exposure record: %v", err)
 		logger.Error(message)
@@ -176,15 +253,86 @@ func (h *publishHandler) handleRequest(w http.ResponseWriter, r *http.Request) r
 		return response{status: http.StatusInternalServerError, message: http.StatusText(http.StatusInternalServerError), metric: "publish-db-write-error", count: 1}
 	}
 
+	// Only notify if there are new keys, not for a retried request.
+	if h.webhook != nil && inserted > 0 {
+		h.webhook.notify(ctx, exposures, inserted)
+	}
+
 	message := fmt.Sprintf("Inserted %d exposures.", len(exposures))
 	span.AddAttributes(trace.Int64Attribute("inserted_exposures", int64(len(exposures))))
 	logger.Info(message)
-	return response{
+	result := response{
 		status:  http.StatusOK,
 		message: message,
 		metric:  "publish-exposures-written",
 		count:   len(exposures),
 	}
+	h.savePublishResult(ctx, appConfig, &data, requestHash, result, now)
+	return result
+}
+
+// dropBlocklisted removes keys known to be malicious. They are dropped
+// silently, rather than rejecting the request, so as not to reveal which keys
+// are blocked.
+func (h *publishHandler) dropBlocklisted(ctx context.Context, exposures []*model.Exposure) []*model.Exposure {
+	blocklist := h.serverenv.KeyBlocklist()
+	kept := exposures[:0]
+	for _, exp := range exposures {
+		if !blocklist.Contains(exp.ExposureKey) {
+			kept = append(kept, exp)
+		}
+	}
+	if dropped := len(exposures) - len(kept); dropped > 0 {
+		logging.FromContext(ctx).Warnf("Dropped %d blocklisted keys", dropped)
+		h.serverenv.MetricsExporter(ctx).WriteInt("publish-blocklisted-keys", true, dropped)
+	}
+	return kept
+}
+
+// verify checks the diagnosis verification certificate on the request. It
+// returns the transmission risk overrides from the certificate, and whether the
+// keys are being accepted unverified because the verification backend is
+// unavailable. A non-nil response rejects the request.
+func (h *publishHandler) verify(ctx context.Context, appConfig *aamodel.AuthorizedApp, data *verifyapi.Publish) (verifyapi.TransmissionRiskVector, bool, *response) {
+	logger := logging.FromContext(ctx)
+	metrics := h.serverenv.MetricsExporter(ctx)
+	span := trace.FromContext(ctx)
+
+	overrides, err := h.verifier.VerifyDiagnosisCertificate(ctx, appConfig, data)
+	if err == nil {
+		return overrides, false, nil
+	}
+
+	if appConfig.BypassHealthAuthorityVerification {
+		logger.Warnf("bypassing health authority certificate verification for app: %v", appConfig.AppPackageName)
+		metrics.WriteInt("publish-health-authority-verification-bypassed", true, 1)
+		return nil, false, nil
+	}
+
+	if errors.Is(err, verification.ErrUnavailable) {
+		if h.config.VerificationOutagePolicy == outagePolicyFailOpen {
+			logger.Warnf("accepting unverified keys for app %v: %v", appConfig.AppPackageName, err)
+			metrics.WriteInt("publish-verification-unavailable-accepted", true, 1)
+			return nil, true, nil
+		}
+
+		// Fail closed. The client should retry once the backend recovers.
+		message := fmt.Sprintf("unable to validate diagnosis verification: %v", err)
+		logger.Error(message)
+		span.SetStatus(trace.Status{Code: trace.StatusCodeUnavailable, Message: message})
+		return nil, false, &response{
+			status:      http.StatusServiceUnavailable,
+			message:     http.StatusText(http.StatusServiceUnavailable),
+			metric:      "publish-verification-unavailable",
+			count:       1,
+			errorInProd: true,
+		}
+	}
+
+	message := fmt.Sprintf("unable to validate diagnosis verification: %v", err)
+	logger.Error(message)
+	span.SetStatus(trace.Status{Code: trace.StatusCodeInvalidArgument, Message: message})
+	return nil, false, &response{status: http.StatusUnauthorized, message: message, metric: "publish-bad-verification", count: 1}
 }
 
 // There is a target normalized latency for this function. This is to help prevent
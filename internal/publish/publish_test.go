@@ -24,6 +24,8 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -41,6 +43,7 @@ import (
 	"github.com/google/exposure-notifications-server/internal/publish/model"
 	"github.com/google/exposure-notifications-server/internal/serverenv"
 	"github.com/google/exposure-notifications-server/internal/util"
+	"github.com/google/exposure-notifications-server/internal/verification"
 	verdb "github.com/google/exposure-notifications-server/internal/verification/database"
 	vermodel "github.com/google/exposure-notifications-server/internal/verification/model"
 
@@ -494,3 +497,66 @@ func TestPublishWithBypass(t *testing.T) {
 		})
 	}
 }
+
+type fakeVerifier struct {
+	overrides verifyapi.TransmissionRiskVector
+	err       error
+}
+
+func (v *fakeVerifier) VerifyDiagnosisCertificate(context.Context, *aamodel.AuthorizedApp, *verifyapi.Publish) (verifyapi.TransmissionRiskVector, error) {
+	return v.overrides, v.err
+}
+
+func TestVerifyOutagePolicy(t *testing.T) {
+	t.Parallel()
+
+	outage := fmt.Errorf("error looking up issuer: doh : %w", verification.ErrUnavailable)
+	invalid := errors.New("HMAC mismatch")
+
+	cases := []struct {
+		name           string
+		policy         string
+		bypass         bool
+		err            error
+		wantStatus     int // zero if the request is accepted
+		wantUnverified bool
+	}{
+		{name: "verified", policy: outagePolicyFailOpen},
+		{name: "unset policy fails closed", err: outage, wantStatus: http.StatusServiceUnavailable},
+		{name: "fail closed", policy: outagePolicyFailClosed, err: outage, wantStatus: http.StatusServiceUnavailable},
+		{name: "fail open", policy: outagePolicyFailOpen, err: outage, wantUnverified: true},
+		{name: "fail open invalid certificate", policy: outagePolicyFailOpen, err: invalid, wantStatus: http.StatusUnauthorized},
+		{name: "fail closed invalid certificate", policy: outagePolicyFailClosed, err: invalid, wantStatus: http.StatusUnauthorized},
+		{name: "bypass", policy: outagePolicyFailClosed, bypass: true, err: outage},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			h := &publishHandler{
+				config:    &Config{VerificationOutagePolicy: tc.policy},
+				serverenv: serverenv.New(ctx),
+				verifier:  &fakeVerifier{err: tc.err},
+			}
+			app := &aamodel.AuthorizedApp{
+				AppPackageName:                    "com.example.app",
+				BypassHealthAuthorityVerification: tc.bypass,
+			}
+
+			_, unverified, resp := h.verify(ctx, app, &verifyapi.Publish{})
+			status := 0
+			if resp != nil {
+				status = resp.status
+			}
+			if status != tc.wantStatus {
+				t.Errorf("status: got %d, want %d", status, tc.wantStatus)
+			}
+			if unverified != tc.wantUnverified {
+				t.Errorf("unverified: got %v, want %v", unverified, tc.wantUnverified)
+			}
+		})
+	}
+}
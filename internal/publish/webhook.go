@@ -0,0 +1,128 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/exposure-notifications-server/internal/retry"
+)
+
+// ingestNotification is the payload POSTed to the ingest webhook after a batch
+// of exposures is successfully inserted.
+type ingestNotification struct {
+	Regions      []string  `json:"regions"`
+	Count        int       `json:"count"`
+	MaxCreatedAt time.Time `json:"maxCreatedAt"`
+}
+
+// newIngestNotification describes a batch of exposures, of which inserted were
+// new. Keys which were already present are not counted.
+func newIngestNotification(exposures []*model.Exposure, inserted int) *ingestNotification {
+	n := &ingestNotification{Count: inserted}
+	seen := make(map[string]struct{})
+	for _, exp := range exposures {
+		for _, r := range exp.Regions {
+			if _, ok := seen[r]; !ok {
+				seen[r] = struct{}{}
+				n.Regions = append(n.Regions, r)
+			}
+		}
+		if exp.CreatedAt.After(n.MaxCreatedAt) {
+			n.MaxCreatedAt = exp.CreatedAt
+		}
+	}
+	return n
+}
+
+// ingestWebhook notifies an external endpoint about newly ingested keys.
+// Delivery happens in the background and never fails the publish request.
+type ingestWebhook struct {
+	url         string
+	client      *http.Client
+	retryBase   time.Duration
+	maxAttempts int
+}
+
+func newIngestWebhook(config *Config) *ingestWebhook {
+	if config.IngestWebhookURL == "" {
+		return nil
+	}
+	return &ingestWebhook{
+		url:         config.IngestWebhookURL,
+		client:      &http.Client{Timeout: config.IngestWebhookTimeout},
+		retryBase:   config.IngestWebhookRetryBase,
+		maxAttempts: config.IngestWebhookMaxAttempts,
+	}
+}
+
+// notify starts delivery of a notification for the given exposures, of which
+// inserted were new. The returned channel is closed once delivery has
+// succeeded or been abandoned.
+func (w *ingestWebhook) notify(ctx context.Context, exposures []*model.Exposure, inserted int) <-chan struct{} {
+	done := make(chan struct{})
+	n := newIngestNotification(exposures, inserted)
+
+	// Detach from the request context, which is cancelled once the response
+	// is written.
+	logger := logging.FromContext(ctx)
+	bgCtx := logging.WithLogger(context.Background(), logger)
+
+	go func() {
+		defer close(done)
+		if err := w.deliver(bgCtx, n); err != nil {
+			// Dead-letter: record the undelivered payload so it can be replayed.
+			logger.Errorw("ingest webhook delivery failed", "error", err, "regions", n.Regions, "count", n.Count, "maxCreatedAt", n.MaxCreatedAt)
+		}
+	}()
+	return done
+}
+
+func (w *ingestWebhook) deliver(ctx context.Context, n *ingestNotification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return fmt.Errorf("marshalling notification: %w", err)
+	}
+
+	// maxAttempts counts the initial attempt, the backoff counts retries.
+	return retry.RetryFib(ctx, w.retryBase, w.maxAttempts-1, func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("creating request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := w.client.Do(req)
+		if err != nil {
+			return retry.RetryableError(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+			return retry.RetryableError(fmt.Errorf("webhook returned %d", resp.StatusCode))
+		}
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("webhook returned %d", resp.StatusCode)
+		}
+		return nil
+	})
+}
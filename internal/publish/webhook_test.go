@@ -0,0 +1,146 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/go-cmp/cmp"
+)
+
+type webhookRecorder struct {
+	mu       sync.Mutex
+	failures int
+	calls    int
+	received []ingestNotification
+}
+
+func (rec *webhookRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	rec.calls++
+	if rec.failures > 0 {
+		rec.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	var n ingestNotification
+	if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	rec.received = append(rec.received, n)
+}
+
+func testWebhook(url string) *ingestWebhook {
+	return newIngestWebhook(&Config{
+		IngestWebhookURL:         url,
+		IngestWebhookTimeout:     time.Second,
+		IngestWebhookRetryBase:   time.Millisecond,
+		IngestWebhookMaxAttempts: 3,
+	})
+}
+
+func makeBatch(n int, createdAt time.Time, regions ...string) []*model.Exposure {
+	exposures := make([]*model.Exposure, 0, n)
+	for i := 0; i < n; i++ {
+		exposures = append(exposures, &model.Exposure{
+			ExposureKey: []byte{byte(i)},
+			Regions:     regions,
+			CreatedAt:   createdAt,
+		})
+	}
+	return exposures
+}
+
+func TestIngestWebhookOncePerBatch(t *testing.T) {
+	t.Parallel()
+
+	rec := &webhookRecorder{}
+	server := httptest.NewServer(rec)
+	defer server.Close()
+
+	webhook := testWebhook(server.URL)
+	ctx := context.Background()
+	first := time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC)
+	second := first.Add(time.Hour)
+
+	<-webhook.notify(ctx, makeBatch(3, first, "US"), 3)
+	// Only the keys which were inserted are counted.
+	<-webhook.notify(ctx, makeBatch(7, second, "US", "CA"), 5)
+
+	want := []ingestNotification{
+		{Regions: []string{"US"}, Count: 3, MaxCreatedAt: first},
+		{Regions: []string{"US", "CA"}, Count: 5, MaxCreatedAt: second},
+	}
+	if diff := cmp.Diff(want, rec.received); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+	if rec.calls != 2 {
+		t.Errorf("got %d webhook calls, want 2", rec.calls)
+	}
+}
+
+func TestIngestWebhookRetries(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name      string
+		failures  int
+		wantCalls int
+		wantCount int
+	}{
+		{name: "recovers", failures: 2, wantCalls: 3, wantCount: 1},
+		{name: "gives_up", failures: 10, wantCalls: 3, wantCount: 0},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			rec := &webhookRecorder{failures: tc.failures}
+			server := httptest.NewServer(rec)
+			defer server.Close()
+
+			webhook := testWebhook(server.URL)
+			<-webhook.notify(context.Background(), makeBatch(1, time.Now(), "US"), 1)
+
+			if rec.calls != tc.wantCalls {
+				t.Errorf("got %d webhook calls, want %d", rec.calls, tc.wantCalls)
+			}
+			if len(rec.received) != tc.wantCount {
+				t.Errorf("got %d delivered notifications, want %d", len(rec.received), tc.wantCount)
+			}
+		})
+	}
+}
+
+func TestNewIngestWebhookDisabled(t *testing.T) {
+	t.Parallel()
+
+	if w := newIngestWebhook(&Config{}); w != nil {
+		t.Errorf("expected no webhook without a URL, got %#v", w)
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"fmt"
 
 	"github.com/google/exposure-notifications-server/internal/authorizedapp"
+	"github.com/google/exposure-notifications-server/internal/blocklist"
 	"github.com/google/exposure-notifications-server/internal/database"
 	"github.com/google/exposure-notifications-server/internal/metrics"
 	"github.com/google/exposure-notifications-server/internal/secrets"
@@ -37,6 +38,7 @@ type ServerEnv struct {
 	blobstore             storage.Blobstore
 	database              *database.DB
 	exporter              metrics.ExporterFromContext
+	keyBlocklist          *blocklist.Blocklist
 	keyManager            signing.KeyManager
 	secretManager         secrets.SecretManager
 }
@@ -100,6 +102,14 @@ func WithKeyManager(km signing.KeyManager) Option {
 	}
 }
 
+// WithKeyBlocklist creates an Option to install the blocklist of keys which must not be stored or served.
+func WithKeyBlocklist(b *blocklist.Blocklist) Option {
+	return func(s *ServerEnv) *ServerEnv {
+		s.keyBlocklist = b
+		return s
+	}
+}
+
 // WithBlobStorage creates an Option to install a specific Blob storage system.
 func WithBlobStorage(sto storage.Blobstore) Option {
 	return func(s *ServerEnv) *ServerEnv {
@@ -116,6 +126,12 @@ func (s *ServerEnv) KeyManager() signing.KeyManager {
 	return s.keyManager
 }
 
+// KeyBlocklist returns the installed key blocklist, which may be nil. A nil
+// blocklist blocks nothing.
+func (s *ServerEnv) KeyBlocklist() *blocklist.Blocklist {
+	return s.keyBlocklist
+}
+
 func (s *ServerEnv) Blobstore() storage.Blobstore {
 	return s.blobstore
 }
@@ -19,6 +19,7 @@ import (
 	"fmt"
 
 	"github.com/google/exposure-notifications-server/internal/authorizedapp"
+	"github.com/google/exposure-notifications-server/internal/blocklist"
 	"github.com/google/exposure-notifications-server/internal/database"
 	"github.com/google/exposure-notifications-server/internal/envconfig"
 	"github.com/google/exposure-notifications-server/internal/logging"
@@ -49,6 +50,12 @@ type AuthorizedAppConfigProvider interface {
 	AuthorizedAppConfig() *authorizedapp.Config
 }
 
+// BlocklistConfigProvider signals that the config knows how to configure the
+// blocklist of keys which must not be stored or served.
+type BlocklistConfigProvider interface {
+	BlocklistConfig() *blocklist.Config
+}
+
 // KeyManagerConfigProvider is a marker interface indicating the key manager
 // should be installed.
 type KeyManagerConfigProvider interface {
@@ -112,13 +119,22 @@ func Setup(ctx context.Context, config DatabaseConfigProvider) (*serverenv.Serve
 	// Configure key management for signing.
 	if provider, ok := config.(KeyManagerConfigProvider); ok {
 		kmConfig := provider.KeyManagerConfig()
-		km, err := signing.KeyManagerFor(ctx, kmConfig.KeyManagerType)
+		km, err := signing.KeyManagerFor(ctx, kmConfig)
 		if err != nil {
 			return nil, nil, fmt.Errorf("unable to connect to key manager: %w", err)
 		}
 		opts = append(opts, serverenv.WithKeyManager(km))
 	}
 
+	// Load the key blocklist.
+	if provider, ok := config.(BlocklistConfigProvider); ok {
+		bl, err := blocklist.Load(ctx, provider.BlocklistConfig())
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to load key blocklist: %w", err)
+		}
+		opts = append(opts, serverenv.WithKeyBlocklist(bl))
+	}
+
 	// Configure blob storage.
 	if provider, ok := config.(BlobstoreConfigProvider); ok {
 		bsConfig := provider.BlobstoreConfig()
@@ -21,8 +21,10 @@ import (
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"strings"
 
 	"github.com/google/exposure-notifications-server/internal/base64util"
@@ -33,14 +35,20 @@ import (
 var _ KeyManager = (*HashiCorpVault)(nil)
 var _ crypto.Signer = (*HashiCorpVaultSigner)(nil)
 
+// defaultVaultTransitMount is where Vault mounts the transit secrets engine
+// unless told otherwise.
+const defaultVaultTransitMount = "transit"
+
 // HashiCorpVault implements the signing.KeyManager interface and can be used to
 // sign export files.
 type HashiCorpVault struct {
 	client *vaultapi.Client
+	mount  string
 }
 
-// NewHashiCorpVault creates a new Vault key manager instance.
-func NewHashiCorpVault(ctx context.Context) (KeyManager, error) {
+// NewHashiCorpVault creates a new Vault key manager instance, using the transit
+// secrets engine mounted at mount. If mount is empty, "transit" is used.
+func NewHashiCorpVault(ctx context.Context, mount string) (KeyManager, error) {
 	client, err := vaultapi.NewClient(nil)
 	if err != nil {
 		return nil, fmt.Errorf("secrets.NewHashiCorpVault: client: %w", err)
@@ -48,6 +56,7 @@ func NewHashiCorpVault(ctx context.Context) (KeyManager, error) {
 
 	sm := &HashiCorpVault{
 		client: client,
+		mount:  mount,
 	}
 
 	return sm, nil
@@ -65,12 +74,13 @@ func (v *HashiCorpVault) NewSigner(ctx context.Context, keyID string) (crypto.Si
 	case 0, 1:
 		return nil, fmt.Errorf("missing version in: %v", keyID)
 	default:
-		return NewHashiCorpVaultSigner(ctx, v.client, parts[0], parts[1])
+		return NewHashiCorpVaultSigner(ctx, v.client, v.mount, parts[0], parts[1])
 	}
 }
 
 type HashiCorpVaultSigner struct {
 	client  *vaultapi.Client
+	mount   string
 	name    string
 	version string
 
@@ -78,8 +88,9 @@ type HashiCorpVaultSigner struct {
 }
 
 // NewHashiCorpVaultSigner creates a new signing interface compatible with
-// HashiCorp Vault's transit backend. The key name and key version are required.
-func NewHashiCorpVaultSigner(ctx context.Context, client *vaultapi.Client, name, version string) (*HashiCorpVaultSigner, error) {
+// HashiCorp Vault's transit backend, mounted at mount. The key name and key
+// version are required; an empty mount means "transit".
+func NewHashiCorpVaultSigner(ctx context.Context, client *vaultapi.Client, mount, name, version string) (*HashiCorpVaultSigner, error) {
 	if client == nil {
 		return nil, fmt.Errorf("missing client")
 	}
@@ -92,8 +103,13 @@ func NewHashiCorpVaultSigner(ctx context.Context, client *vaultapi.Client, name,
 		return nil, fmt.Errorf("version is required")
 	}
 
+	if mount == "" {
+		mount = defaultVaultTransitMount
+	}
+
 	signer := &HashiCorpVaultSigner{
 		client:  client,
+		mount:   strings.Trim(mount, "/"),
 		name:    name,
 		version: version,
 	}
@@ -113,16 +129,18 @@ func (s *HashiCorpVaultSigner) Public() crypto.PublicKey {
 	return s.publicKey
 }
 
-// Sign signs the given digest using the public key.
+// Sign signs the given digest using the configured version of the key, so that
+// the signature matches the public key.
 func (s *HashiCorpVaultSigner) Sign(_ io.Reader, digest []byte, _ crypto.SignerOpts) ([]byte, error) {
-	pth := fmt.Sprintf("transit/sign/%s/sha2-256", s.name)
+	pth := fmt.Sprintf("%s/sign/%s/sha2-256", s.mount, s.name)
 	secret, err := s.client.Logical().Write(pth, map[string]interface{}{
 		"input":                base64.StdEncoding.EncodeToString(digest),
 		"prehashed":            true,
 		"marshaling_algorithm": "asn1",
+		"key_version":          s.version,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to sign: %w", err)
+		return nil, fmt.Errorf("failed to sign: %w", vaultError(err))
 	}
 	if secret == nil || secret.Data == nil {
 		return nil, fmt.Errorf("got response for signing, but was nil")
@@ -153,10 +171,10 @@ func (s *HashiCorpVaultSigner) Sign(_ io.Reader, digest []byte, _ crypto.SignerO
 }
 
 func (s *HashiCorpVaultSigner) getPublicKey() (crypto.PublicKey, error) {
-	pth := fmt.Sprintf("transit/keys/%s", s.name)
+	pth := fmt.Sprintf("%s/keys/%s", s.mount, s.name)
 	secret, err := s.client.Logical().Read(pth)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get public key for %v: %w", s.name, err)
+		return nil, fmt.Errorf("failed to get public key for %v: %w", s.name, vaultError(err))
 	}
 	if secret == nil || secret.Data == nil {
 		return nil, fmt.Errorf("found %v, but public key was empty", s.name)
@@ -223,3 +241,14 @@ func (s *HashiCorpVaultSigner) getPublicKey() (crypto.PublicKey, error) {
 
 	return typed, nil
 }
+
+// vaultError wraps err with ErrUnavailable if Vault could not be reached, or
+// responded with a server error such as 503 while sealed. Errors for the
+// request itself, such as permission denied, are returned as they are.
+func vaultError(err error) error {
+	var respErr *vaultapi.ResponseError
+	if errors.As(err, &respErr) && respErr.StatusCode < http.StatusInternalServerError {
+		return err
+	}
+	return fmt.Errorf("%w: %v", ErrUnavailable, err)
+}
@@ -15,14 +15,23 @@
 package signing
 
 import (
+	"bytes"
 	"context"
 	"crypto"
 	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/x509"
 	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"math/big"
+	"net/http"
 	"strings"
 	"testing"
 
@@ -63,7 +72,7 @@ func TestNewHashiCorpVaultSigner(t *testing.T) {
 
 		t.Run(tc.name, func(t *testing.T) {
 			ctx := context.Background()
-			_, err := NewHashiCorpVaultSigner(ctx, tc.client, tc.keyName, tc.keyVersion)
+			_, err := NewHashiCorpVaultSigner(ctx, tc.client, "", tc.keyName, tc.keyVersion)
 			if err == nil {
 				t.Fatal("expected error")
 			}
@@ -173,7 +182,7 @@ func TestHashiCorpVaultSigner_Public(t *testing.T) {
 			}
 
 			// Create signer.
-			signer, err := NewHashiCorpVaultSigner(ctx, client, tc.keyName, tc.keyVersion)
+			signer, err := NewHashiCorpVaultSigner(ctx, client, "", tc.keyName, tc.keyVersion)
 			if err != nil {
 				if !tc.err {
 					t.Fatal(err)
@@ -230,7 +239,7 @@ func TestHashiCorpVaultSigner_Sign(t *testing.T) {
 	}
 
 	// Create the signer.
-	signer, err := NewHashiCorpVaultSigner(ctx, client, "my-key", "1")
+	signer, err := NewHashiCorpVaultSigner(ctx, client, "", "my-key", "1")
 	if err != nil {
 		panic(err)
 	}
@@ -262,3 +271,175 @@ func TestHashiCorpVaultSigner_Sign(t *testing.T) {
 		t.Errorf("expected ok")
 	}
 }
+
+// fakeVaultTransport serves Vault transit requests from an in-memory ECDSA key.
+type fakeVaultTransport struct {
+	t     *testing.T
+	mount string
+	key   *ecdsa.PrivateKey
+
+	// signStatus, if set, is returned for sign requests instead of a signature.
+	signStatus int
+	// signErr, if set, fails sign requests in the transport.
+	signErr error
+}
+
+func (f *fakeVaultTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	respond := func(status int, body interface{}) (*http.Response, error) {
+		b, err := json.Marshal(body)
+		if err != nil {
+			f.t.Fatal(err)
+		}
+		return &http.Response{
+			StatusCode: status,
+			Header:     http.Header{"Content-Type": []string{"application/json"}},
+			Body:       ioutil.NopCloser(bytes.NewReader(b)),
+			Request:    req,
+		}, nil
+	}
+
+	switch req.URL.Path {
+	case "/v1/" + f.mount + "/keys/my-key":
+		der, err := x509.MarshalPKIXPublicKey(&f.key.PublicKey)
+		if err != nil {
+			f.t.Fatal(err)
+		}
+		pub := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+		return respond(http.StatusOK, map[string]interface{}{
+			"data": map[string]interface{}{
+				"type": "ecdsa-p256",
+				"keys": map[string]interface{}{
+					"1": map[string]interface{}{"public_key": string(pub)},
+				},
+			},
+		})
+
+	case "/v1/" + f.mount + "/sign/my-key/sha2-256":
+		if f.signErr != nil {
+			return nil, f.signErr
+		}
+		if f.signStatus != 0 {
+			return respond(f.signStatus, map[string]interface{}{"errors": []string{http.StatusText(f.signStatus)}})
+		}
+
+		var body struct {
+			Input      string `json:"input"`
+			KeyVersion string `json:"key_version"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			f.t.Fatal(err)
+		}
+		if body.KeyVersion != "1" {
+			f.t.Errorf("key_version=%q, want 1", body.KeyVersion)
+		}
+		digest, err := base64.StdEncoding.DecodeString(body.Input)
+		if err != nil {
+			f.t.Fatal(err)
+		}
+		r, s, err := ecdsa.Sign(rand.Reader, f.key, digest)
+		if err != nil {
+			f.t.Fatal(err)
+		}
+		sig, err := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+		if err != nil {
+			f.t.Fatal(err)
+		}
+		return respond(http.StatusOK, map[string]interface{}{
+			"data": map[string]interface{}{"signature": "vault:v1:" + base64.StdEncoding.EncodeToString(sig)},
+		})
+	}
+
+	return respond(http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+}
+
+func TestHashiCorpVaultSigner_Transport(t *testing.T) {
+	t.Parallel()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name       string
+		signStatus int
+		signErr    error
+		// fails is whether Sign returns an error, and unavailable whether that
+		// error is ErrUnavailable.
+		fails       bool
+		unavailable bool
+	}{
+		{
+			name: "ok",
+		},
+		{
+			name:        "sealed",
+			signStatus:  http.StatusServiceUnavailable,
+			fails:       true,
+			unavailable: true,
+		},
+		{
+			name:        "unreachable",
+			signErr:     errors.New("connection refused"),
+			fails:       true,
+			unavailable: true,
+		},
+		{
+			name:       "permission_denied",
+			signStatus: http.StatusForbidden,
+			fails:      true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			transport := &fakeVaultTransport{
+				t:          t,
+				mount:      "export/transit",
+				key:        key,
+				signStatus: tc.signStatus,
+				signErr:    tc.signErr,
+			}
+			client, err := vaultapi.NewClient(&vaultapi.Config{
+				Address:    "http://vault.test",
+				HttpClient: &http.Client{Transport: transport},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			signer, err := NewHashiCorpVaultSigner(ctx, client, "/export/transit/", "my-key", "1")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			digest := sha256.Sum256([]byte("why hello there!"))
+			sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+			if got := errors.Is(err, ErrUnavailable); got != tc.unavailable {
+				t.Errorf("errors.Is(%v, ErrUnavailable)=%v, want %v", err, got, tc.unavailable)
+			}
+			if tc.fails {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var rs struct{ R, S *big.Int }
+			if _, err := asn1.Unmarshal(sig, &rs); err != nil {
+				t.Fatal(err)
+			}
+			if !ecdsa.Verify(&key.PublicKey, digest[:], rs.R, rs.S) {
+				t.Errorf("signature does not verify")
+			}
+		})
+	}
+}
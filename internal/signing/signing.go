@@ -18,6 +18,7 @@ package signing
 import (
 	"context"
 	"crypto"
+	"errors"
 	"fmt"
 )
 
@@ -31,9 +32,17 @@ const (
 	KeyManagerTypeNoop           KeyManagerType = "NOOP"
 )
 
+// ErrUnavailable indicates that the key manager could not be reached or could
+// not serve the request. Signing may succeed if retried later.
+var ErrUnavailable = errors.New("key manager unavailable")
+
 // Config defines configuration.
 type Config struct {
 	KeyManagerType KeyManagerType `envconfig:"KEY_MANAGER" default:"GOOGLE_CLOUD_KMS"`
+
+	// VaultTransitMount is the path at which Vault's transit secrets engine is
+	// mounted, for KeyManagerTypeHashiCorpVault.
+	VaultTransitMount string `envconfig:"VAULT_TRANSIT_MOUNT" default:"transit"`
 }
 
 // KeyManager defines the interface for working with a KMS system that
@@ -43,18 +52,18 @@ type KeyManager interface {
 	NewSigner(ctx context.Context, keyID string) (crypto.Signer, error)
 }
 
-// KeyManagerFor returns the appropriate key manager for the given type.
-func KeyManagerFor(ctx context.Context, typ KeyManagerType) (KeyManager, error) {
-	switch typ {
+// KeyManagerFor returns the appropriate key manager for the given config.
+func KeyManagerFor(ctx context.Context, config *Config) (KeyManager, error) {
+	switch config.KeyManagerType {
 	case KeyManagerTypeAWSKMS:
 		return NewAWSKMS(ctx)
 	case KeyManagerTypeGoogleCloudKMS:
 		return NewGoogleCloudKMS(ctx)
 	case KeyManagerTypeHashiCorpVault:
-		return NewHashiCorpVault(ctx)
+		return NewHashiCorpVault(ctx, config.VaultTransitMount)
 	case KeyManagerTypeNoop:
 		return NewNoop(ctx)
 	}
 
-	return nil, fmt.Errorf("unknown key manager type: %v", typ)
+	return nil, fmt.Errorf("unknown key manager type: %v", config.KeyManagerType)
 }
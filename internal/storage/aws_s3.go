@@ -18,8 +18,12 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strconv"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 )
@@ -50,24 +54,31 @@ func NewAWSS3(ctx context.Context) (Blobstore, error) {
 
 // CreateObject creates a new S3 object or overwrites an existing one.
 func (s *AWSS3) CreateObject(ctx context.Context, bucket, key string, contents []byte, cacheable bool) error {
-	cacheControl := "public, max-age=86400"
-	if !cacheable {
-		cacheControl = "no-cache, max-age=0"
-	}
+	return s.PutObject(ctx, bucket, key, contents, cacheableMetadata(cacheable))
+}
 
-	if _, err := s.svc.PutObjectWithContext(ctx, &s3.PutObjectInput{
+func (s *AWSS3) PutObject(ctx context.Context, bucket, key string, contents []byte, metadata *ObjectMetadata) error {
+	input := &s3.PutObjectInput{
 		Bucket:       aws.String(bucket),
 		Key:          aws.String(key),
-		CacheControl: aws.String(cacheControl),
+		CacheControl: aws.String(metadata.CacheControl),
 		Body:         bytes.NewReader(contents),
-	}); err != nil {
-		return fmt.Errorf("storage.CreateObject: %w", err)
+	}
+	if metadata.ContentType != "" {
+		input.ContentType = aws.String(metadata.ContentType)
+	}
+	if metadata.RetentionDays > 0 {
+		input.Tagging = aws.String(url.Values{RetentionTag: {strconv.Itoa(metadata.RetentionDays)}}.Encode())
+	}
+	if metadata.BatchTag != "" {
+		input.Metadata = map[string]*string{BatchTagKey: aws.String(metadata.BatchTag)}
+	}
+	if _, err := s.svc.PutObjectWithContext(ctx, input); err != nil {
+		return fmt.Errorf("storage.PutObject: %w", err)
 	}
 	return nil
 }
 
-// DeleteObject deletes a S3 object, returns nil if the object was successfully
-// deleted, or of the object doesn't exist.
 func (s *AWSS3) DeleteObject(ctx context.Context, bucket, key string) error {
 	if _, err := s.svc.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
 		Bucket: aws.String(bucket),
@@ -77,3 +88,23 @@ func (s *AWSS3) DeleteObject(ctx context.Context, bucket, key string) error {
 	}
 	return nil
 }
+
+func (s *AWSS3) GetObject(ctx context.Context, bucket, key string) ([]byte, error) {
+	out, err := s.svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("storage.GetObject: %w", err)
+	}
+	defer out.Body.Close()
+
+	b, err := ioutil.ReadAll(out.Body)
+	if err != nil {
+		return nil, fmt.Errorf("storage.GetObject: %w", err)
+	}
+	return b, nil
+}
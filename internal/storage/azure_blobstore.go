@@ -17,8 +17,11 @@ package storage
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
 	"net/url"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/Azure/azure-storage-blob-go/azblob"
 )
@@ -66,24 +69,34 @@ func NewAzureBlobstore(ctx context.Context) (Blobstore, error) {
 
 // CreateObject creates a new blobstore object or overwrites an existing one.
 func (s *AzureBlobstore) CreateObject(ctx context.Context, container, name string, contents []byte, cacheable bool) error {
-	cacheControl := "public, max-age=86400"
-	if !cacheable {
-		cacheControl = "no-cache, max-age=0"
-	}
+	return s.PutObject(ctx, container, name, contents, cacheableMetadata(cacheable))
+}
 
-	blobURL := s.serviceURL.NewContainerURL(container).NewBlockBlobURL(name)
-	if _, err := azblob.UploadBufferToBlockBlob(ctx, contents, blobURL, azblob.UploadToBlockBlobOptions{
+func (s *AzureBlobstore) PutObject(ctx context.Context, container, name string, contents []byte, metadata *ObjectMetadata) error {
+	opts := azblob.UploadToBlockBlobOptions{
 		BlobHTTPHeaders: azblob.BlobHTTPHeaders{
-			CacheControl: cacheControl,
+			ContentType:  metadata.ContentType,
+			CacheControl: metadata.CacheControl,
 		},
-	}); err != nil {
-		return fmt.Errorf("storage.CreateObject: %w", err)
+	}
+	// Azure metadata names must be valid C# identifiers.
+	if metadata.RetentionDays > 0 || metadata.BatchTag != "" {
+		opts.Metadata = azblob.Metadata{}
+	}
+	if metadata.RetentionDays > 0 {
+		opts.Metadata[strings.ReplaceAll(RetentionTag, "-", "_")] = strconv.Itoa(metadata.RetentionDays)
+	}
+	if metadata.BatchTag != "" {
+		opts.Metadata[strings.ReplaceAll(BatchTagKey, "-", "_")] = metadata.BatchTag
+	}
+
+	blobURL := s.serviceURL.NewContainerURL(container).NewBlockBlobURL(name)
+	if _, err := azblob.UploadBufferToBlockBlob(ctx, contents, blobURL, opts); err != nil {
+		return fmt.Errorf("storage.PutObject: %w", err)
 	}
 	return nil
 }
 
-// DeleteObject deletes a blobstore object, returns nil if the object was
-// successfully deleted, or if the object doesn't exist.
 func (s *AzureBlobstore) DeleteObject(ctx context.Context, container, name string) error {
 	blobURL := s.serviceURL.NewContainerURL(container).NewBlockBlobURL(name)
 	if _, err := blobURL.Delete(ctx, azblob.DeleteSnapshotsOptionInclude, azblob.BlobAccessConditions{}); err != nil {
@@ -95,3 +108,22 @@ func (s *AzureBlobstore) DeleteObject(ctx context.Context, container, name strin
 	}
 	return nil
 }
+
+func (s *AzureBlobstore) GetObject(ctx context.Context, container, name string) ([]byte, error) {
+	blobURL := s.serviceURL.NewContainerURL(container).NewBlockBlobURL(name)
+	resp, err := blobURL.Download(ctx, 0, azblob.CountToEnd, azblob.BlobAccessConditions{}, false)
+	if err != nil {
+		if terr, ok := err.(azblob.StorageError); ok && terr.ServiceCode() == azblob.ServiceCodeBlobNotFound {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("storage.GetObject: %w", err)
+	}
+	body := resp.Body(azblob.RetryReaderOptions{})
+	defer body.Close()
+
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("storage.GetObject: %w", err)
+	}
+	return b, nil
+}
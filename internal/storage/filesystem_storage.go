@@ -39,6 +39,12 @@ func NewFilesystemStorage(ctx context.Context) (Blobstore, error) {
 // CreateObject creates a new object on the filesystem or overwrites an existing
 // one.
 func (s *FilesystemStorage) CreateObject(ctx context.Context, folder, filename string, contents []byte, cacheable bool) error {
+	return s.PutObject(ctx, folder, filename, contents, cacheableMetadata(cacheable))
+}
+
+// PutObject writes the file. The filesystem has nowhere to keep the metadata,
+// so it is ignored.
+func (s *FilesystemStorage) PutObject(ctx context.Context, folder, filename string, contents []byte, metadata *ObjectMetadata) error {
 	pth := filepath.Join(folder, filename)
 	if err := ioutil.WriteFile(pth, contents, 0644); err != nil {
 		return fmt.Errorf("failed to create object: %w", err)
@@ -46,8 +52,6 @@ func (s *FilesystemStorage) CreateObject(ctx context.Context, folder, filename s
 	return nil
 }
 
-// DeleteObject deletes an object from the filesystem. It returns nil if the
-// object was deleted or if the object no longer exists.
 func (s *FilesystemStorage) DeleteObject(ctx context.Context, folder, filename string) error {
 	pth := filepath.Join(folder, filename)
 	if err := os.Remove(pth); err != nil && !os.IsNotExist(err) {
@@ -55,3 +59,15 @@ func (s *FilesystemStorage) DeleteObject(ctx context.Context, folder, filename s
 	}
 	return nil
 }
+
+func (s *FilesystemStorage) GetObject(ctx context.Context, folder, filename string) ([]byte, error) {
+	pth := filepath.Join(folder, filename)
+	b, err := ioutil.ReadFile(pth)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to read object: %w", err)
+	}
+	return b, nil
+}
@@ -17,6 +17,7 @@ package storage
 import (
 	"bytes"
 	"context"
+	"errors"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -77,6 +78,14 @@ func TestFilesystemStorage_CreateObject(t *testing.T) {
 				if !bytes.Equal(contents, tc.contents) {
 					t.Errorf("expected %q to be %q ", contents, tc.contents)
 				}
+
+				got, err := storage.GetObject(ctx, tc.folder, tc.filepath)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, tc.contents) {
+					t.Errorf("expected %q to be %q ", got, tc.contents)
+				}
 			}
 		})
 	}
@@ -119,6 +128,9 @@ func TestFilesystemStorage_DeleteObject(t *testing.T) {
 			if err = storage.DeleteObject(ctx, tc.folder, tc.filepath); err != nil {
 				t.Fatal(err)
 			}
+			if _, err := storage.GetObject(ctx, tc.folder, tc.filepath); !errors.Is(err, ErrNotFound) {
+				t.Errorf("expected %v, got %v", ErrNotFound, err)
+			}
 		})
 	}
 }
@@ -18,6 +18,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"strconv"
 
 	"cloud.google.com/go/storage"
 )
@@ -43,14 +45,21 @@ func NewGoogleCloudStorage(ctx context.Context) (Blobstore, error) {
 
 // CreateObject creates a new cloud storage object or overwrites an existing one.
 func (gcs *GoogleCloudStorage) CreateObject(ctx context.Context, bucket, objectName string, contents []byte, cacheable bool) error {
-	cacheControl := "public, max-age=86400"
-	if !cacheable {
-		cacheControl = "no-cache, max-age=0"
-	}
+	return gcs.PutObject(ctx, bucket, objectName, contents, cacheableMetadata(cacheable))
+}
 
+func (gcs *GoogleCloudStorage) PutObject(ctx context.Context, bucket, objectName string, contents []byte, metadata *ObjectMetadata) error {
 	wc := gcs.client.Bucket(bucket).Object(objectName).NewWriter(ctx)
-	wc.Metadata = map[string]string{
-		"Cache-Control": cacheControl,
+	wc.ContentType = metadata.ContentType
+	wc.CacheControl = metadata.CacheControl
+	if metadata.RetentionDays > 0 || metadata.BatchTag != "" {
+		wc.Metadata = make(map[string]string)
+	}
+	if metadata.RetentionDays > 0 {
+		wc.Metadata[RetentionTag] = strconv.Itoa(metadata.RetentionDays)
+	}
+	if metadata.BatchTag != "" {
+		wc.Metadata[BatchTagKey] = metadata.BatchTag
 	}
 	if _, err := wc.Write(contents); err != nil {
 		return fmt.Errorf("storage.Writer.Write: %w", err)
@@ -61,8 +70,6 @@ func (gcs *GoogleCloudStorage) CreateObject(ctx context.Context, bucket, objectN
 	return nil
 }
 
-// DeleteObject deletes a cloud storage object, returns nil if the object was
-// successfully deleted, or of the object doesn't exist.
 func (gcs *GoogleCloudStorage) DeleteObject(ctx context.Context, bucket, objectName string) error {
 	if err := gcs.client.Bucket(bucket).Object(objectName).Delete(ctx); err != nil {
 		if errors.Is(err, storage.ErrObjectNotExist) {
@@ -73,3 +80,20 @@ func (gcs *GoogleCloudStorage) DeleteObject(ctx context.Context, bucket, objectN
 	}
 	return nil
 }
+
+func (gcs *GoogleCloudStorage) GetObject(ctx context.Context, bucket, objectName string) ([]byte, error) {
+	r, err := gcs.client.Bucket(bucket).Object(objectName).NewReader(ctx)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("storage.GetObject: %w", err)
+	}
+	defer r.Close()
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("storage.Reader.Read: %w", err)
+	}
+	return b, nil
+}
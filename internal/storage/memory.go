@@ -0,0 +1,76 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"sync"
+)
+
+var _ Blobstore = (*Memory)(nil)
+
+// Memory is a Blobstore which keeps objects in memory. It is intended for
+// tests and local development.
+type Memory struct {
+	mu      sync.Mutex
+	objects map[string]*MemoryObject
+}
+
+// MemoryObject is an object held by Memory.
+type MemoryObject struct {
+	Contents []byte
+	Metadata ObjectMetadata
+}
+
+func NewMemory(ctx context.Context) (Blobstore, error) {
+	return &Memory{objects: make(map[string]*MemoryObject)}, nil
+}
+
+func (s *Memory) CreateObject(ctx context.Context, bucket, objectName string, contents []byte, cacheable bool) error {
+	return s.PutObject(ctx, bucket, objectName, contents, cacheableMetadata(cacheable))
+}
+
+func (s *Memory) PutObject(ctx context.Context, bucket, objectName string, contents []byte, metadata *ObjectMetadata) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[bucket+"/"+objectName] = &MemoryObject{
+		Contents: append([]byte(nil), contents...),
+		Metadata: *metadata,
+	}
+	return nil
+}
+
+func (s *Memory) DeleteObject(ctx context.Context, bucket, objectName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, bucket+"/"+objectName)
+	return nil
+}
+
+func (s *Memory) GetObject(ctx context.Context, bucket, objectName string) ([]byte, error) {
+	o, ok := s.Object(bucket, objectName)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), o.Contents...), nil
+}
+
+// Object returns the object with the given name, or false if there is none.
+func (s *Memory) Object(bucket, objectName string) (*MemoryObject, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.objects[bucket+"/"+objectName]
+	return o, ok
+}
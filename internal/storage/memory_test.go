@@ -0,0 +1,78 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMemory_PutObject(t *testing.T) {
+	ctx := context.Background()
+
+	storage, err := NewMemory(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	memory := storage.(*Memory)
+
+	metadata := &ObjectMetadata{
+		ContentType:   "application/zip",
+		CacheControl:  "public, max-age=3600",
+		RetentionDays: 14,
+	}
+	if err := storage.PutObject(ctx, "bucket", "root/1-00001.zip", []byte("contents"), metadata); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.CreateObject(ctx, "bucket", "root/index.txt", []byte("index"), false); err != nil {
+		t.Fatal(err)
+	}
+
+	o, ok := memory.Object("bucket", "root/1-00001.zip")
+	if !ok {
+		t.Fatal("object was not written")
+	}
+	if !bytes.Equal(o.Contents, []byte("contents")) {
+		t.Errorf("expected %q to be %q", o.Contents, "contents")
+	}
+	if diff := cmp.Diff(*metadata, o.Metadata); diff != "" {
+		t.Errorf("metadata mismatch (-want +got):\n%s", diff)
+	}
+	if got, err := storage.GetObject(ctx, "bucket", "root/1-00001.zip"); err != nil || !bytes.Equal(got, []byte("contents")) {
+		t.Errorf("GetObject() = %q, %v, want %q, nil", got, err, "contents")
+	}
+
+	o, ok = memory.Object("bucket", "root/index.txt")
+	if !ok {
+		t.Fatal("object was not written")
+	}
+	if diff := cmp.Diff(ObjectMetadata{CacheControl: "no-cache, max-age=0"}, o.Metadata); diff != "" {
+		t.Errorf("metadata mismatch (-want +got):\n%s", diff)
+	}
+
+	if err := storage.DeleteObject(ctx, "bucket", "root/index.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := memory.Object("bucket", "root/index.txt"); ok {
+		t.Errorf("object was not deleted")
+	}
+	if _, err := storage.GetObject(ctx, "bucket", "root/index.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetObject() of deleted object returned err=%v, want %v", err, ErrNotFound)
+	}
+}
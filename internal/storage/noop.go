@@ -30,6 +30,14 @@ func (s *Noop) CreateObject(ctx context.Context, folder, filename string, conten
 	return nil
 }
 
+func (s *Noop) PutObject(ctx context.Context, folder, filename string, contents []byte, metadata *ObjectMetadata) error {
+	return nil
+}
+
 func (s *Noop) DeleteObject(ctx context.Context, folder, filename string) error {
 	return nil
 }
+
+func (s *Noop) GetObject(ctx context.Context, folder, filename string) ([]byte, error) {
+	return nil, ErrNotFound
+}
@@ -17,9 +17,13 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 )
 
+// ErrNotFound is returned by GetObject when the object does not exist.
+var ErrNotFound = errors.New("storage object not found")
+
 // BlobstoreType defines a specific blobstore.
 type BlobstoreType string
 
@@ -28,6 +32,7 @@ const (
 	BlobstoreTypeAzureBlobStorage   BlobstoreType = "AZURE_BLOB_STORAGE"
 	BlobstoreTypeGoogleCloudStorage BlobstoreType = "GOOGLE_CLOUD_STORAGE"
 	BlobstoreTypeFilesystem         BlobstoreType = "FILESYSTEM"
+	BlobstoreTypeMemory             BlobstoreType = "MEMORY"
 	BlobstoreTypeNoop               BlobstoreType = "NOOP"
 )
 
@@ -36,13 +41,58 @@ type Config struct {
 	BlobstoreType BlobstoreType `envconfig:"BLOBSTORE" default:"GOOGLE_CLOUD_STORAGE"`
 }
 
+// RetentionTag is the tag, or metadata key where the blob store has no tags,
+// which holds an object's ObjectMetadata.RetentionDays. Bucket lifecycle rules
+// can match on it to delete old objects.
+const RetentionTag = "retention-days"
+
+// BatchTagKey is the name of the object metadata which holds an object's
+// ObjectMetadata.BatchTag.
+const BatchTagKey = "batch-tag"
+
+// ObjectMetadata is the metadata set on an object when it is written.
+type ObjectMetadata struct {
+	// ContentType is the MIME type of the object. If empty, the blob store's
+	// default is used.
+	ContentType string
+
+	// CacheControl is the Cache-Control header served with the object.
+	CacheControl string
+
+	// RetentionDays, if positive, is recorded on the object as RetentionTag so
+	// that a lifecycle rule on the bucket can delete it after that many days.
+	// The blob store itself does not delete anything.
+	RetentionDays int
+
+	// BatchTag, if set, is recorded on the object as BatchTagKey user metadata,
+	// e.g. to trace an object to the server build which wrote it. It is not
+	// part of the object's contents.
+	BatchTag string
+}
+
+// cacheableMetadata returns the metadata CreateObject writes.
+func cacheableMetadata(cacheable bool) *ObjectMetadata {
+	if cacheable {
+		return &ObjectMetadata{CacheControl: "public, max-age=86400"}
+	}
+	return &ObjectMetadata{CacheControl: "no-cache, max-age=0"}
+}
+
 // Blobstore defines the minimum interface for a blob storage system.
 type Blobstore interface {
 	// CreateObject creates or overwrites an object in the storage system.
 	CreateObject(ctx context.Context, bucket, objectName string, contents []byte, cacheable bool) error
 
+	// PutObject creates or overwrites an object in the storage system with the
+	// given metadata.
+	PutObject(ctx context.Context, bucket, objectName string, contents []byte, metadata *ObjectMetadata) error
+
 	// DeleteObject deltes an object or does nothing if the object doesn't exist.
 	DeleteObject(ctx context.Context, bucket, objectName string) error
+
+	// GetObject returns the contents of an object, or ErrNotFound if it
+	// doesn't exist.
+	GetObject(ctx context.Context, bucket, objectName string) ([]byte, error)
 }
 
 // BlobstoreFor returns the blob store for the given type, or an error if one
@@ -57,6 +107,8 @@ func BlobstoreFor(ctx context.Context, typ BlobstoreType) (Blobstore, error) {
 		return NewGoogleCloudStorage(ctx)
 	case BlobstoreTypeFilesystem:
 		return NewFilesystemStorage(ctx)
+	case BlobstoreTypeMemory:
+		return NewMemory(ctx)
 	case BlobstoreTypeNoop:
 		return NewNoop(ctx)
 	default:
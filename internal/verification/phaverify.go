@@ -20,18 +20,26 @@ package verification
 import (
 	"context"
 	"crypto/hmac"
+	"errors"
 	"fmt"
 
 	aamodel "github.com/google/exposure-notifications-server/internal/authorizedapp/model"
 	"github.com/google/exposure-notifications-server/internal/base64util"
+	coredb "github.com/google/exposure-notifications-server/internal/database"
 	"github.com/google/exposure-notifications-server/internal/verification/database"
 
 	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1alpha1"
 	utils "github.com/google/exposure-notifications-server/pkg/verification"
 
 	"github.com/dgrijalva/jwt-go"
+	pgx "github.com/jackc/pgx/v4"
 )
 
+// ErrUnavailable indicates that a certificate could not be verified because the
+// health authority keys could not be loaded, as opposed to the certificate
+// being invalid.
+var ErrUnavailable = errors.New("verification backend unavailable")
+
 // Verifier can be used to verify public health authority diagnosis verification certificates.
 type Verifier struct {
 	db *database.HealthAuthorityDB
@@ -65,6 +73,9 @@ func (v *Verifier) VerifyDiagnosisCertificate(ctx context.Context, authApp *aamo
 		// Based on issuer, load the key versions.
 		ha, err := v.db.GetHealthAuthority(ctx, claims.Issuer)
 		if err != nil {
+			if !errors.Is(err, coredb.ErrNotFound) && !errors.Is(err, pgx.ErrNoRows) {
+				err = fmt.Errorf("%w: %v", ErrUnavailable, err)
+			}
 			return nil, fmt.Errorf("error looking up issuer: %v : %w", claims.Issuer, err)
 		}
 
@@ -80,6 +91,12 @@ func (v *Verifier) VerifyDiagnosisCertificate(ctx context.Context, authApp *aamo
 		return nil, fmt.Errorf("key not found: iss: %v version: %v", claims.Issuer, claims.KeyVersion)
 	})
 	if err != nil {
+		// The jwt package wraps errors from the key lookup; surface an
+		// unavailable backend so callers can apply their outage policy.
+		var verr *jwt.ValidationError
+		if errors.As(err, &verr) && errors.Is(verr.Inner, ErrUnavailable) {
+			return nil, verr.Inner
+		}
 		return nil, err
 	}
 
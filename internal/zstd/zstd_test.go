@@ -0,0 +1,58 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zstd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/encoding"
+)
+
+func TestRoundTrip(t *testing.T) {
+	compressor := encoding.GetCompressor(Name)
+	if compressor == nil {
+		t.Fatalf("%s is not registered", Name)
+	}
+
+	// Round trip more than once, so that pooled encoders and decoders are reused.
+	for i, msg := range []string{strings.Repeat("exposure key ", 1000), "", "a second message"} {
+		var buf bytes.Buffer
+		w, err := compressor.Compress(&buf)
+		if err != nil {
+			t.Fatalf("%d: Compress: %v", i, err)
+		}
+		if _, err := w.Write([]byte(msg)); err != nil {
+			t.Fatalf("%d: Write: %v", i, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("%d: Close: %v", i, err)
+		}
+
+		r, err := compressor.Decompress(&buf)
+		if err != nil {
+			t.Fatalf("%d: Decompress: %v", i, err)
+		}
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%d: ReadAll: %v", i, err)
+		}
+		if string(got) != msg {
+			t.Errorf("%d: got %q, want %q", i, got, msg)
+		}
+	}
+}
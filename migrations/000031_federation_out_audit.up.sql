@@ -0,0 +1,30 @@
+-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+CREATE TABLE FederationOutAudit (
+	audit_id SERIAL PRIMARY KEY,
+	oidc_issuer VARCHAR(1000),
+	oidc_subject VARCHAR(1000),
+	include_regions VARCHAR(5) [],
+	exclude_regions VARCHAR(5) [],
+	since_timestamp TIMESTAMPTZ NOT NULL,
+	until_timestamp TIMESTAMPTZ NOT NULL,
+	keys_served INT NOT NULL,
+	partial_response BOOLEAN NOT NULL,
+	fetched_at TIMESTAMPTZ NOT NULL
+);
+
+END;
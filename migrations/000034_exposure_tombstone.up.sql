@@ -0,0 +1,28 @@
+-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+CREATE TABLE ExposureTombstone (
+	exposure_key VARCHAR(30) PRIMARY KEY,
+	interval_number INT NOT NULL,
+	interval_count INT NOT NULL,
+	namespace VARCHAR(100) NOT NULL DEFAULT '',
+	regions VARCHAR(5) [],
+	deleted_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX exposure_tombstone_deleted_at ON ExposureTombstone (deleted_at);
+
+END;
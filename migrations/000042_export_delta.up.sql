@@ -0,0 +1,27 @@
+-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE ExportConfig ADD COLUMN mode VARCHAR(10) NOT NULL DEFAULT 'full';
+ALTER TABLE ExportBatch ADD COLUMN mode VARCHAR(10) NOT NULL DEFAULT 'full';
+
+CREATE TABLE ExportWatermark (
+	config_id INT NOT NULL REFERENCES ExportConfig(config_id),
+	region VARCHAR(5) NOT NULL,
+	max_created_at TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (config_id, region)
+);
+
+END;
@@ -0,0 +1,33 @@
+-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+CREATE TABLE DeadLetterKey (
+	dead_letter_id BIGSERIAL PRIMARY KEY,
+	query_id VARCHAR(50) NOT NULL,
+	sync_id BIGINT NOT NULL,
+	exposure_key BYTEA NOT NULL,
+	transmission_risk INT NOT NULL,
+	regions VARCHAR(5)[] NOT NULL,
+	interval_number INT NOT NULL,
+	interval_count INT NOT NULL,
+	reason TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL,
+	reprocessed_at TIMESTAMPTZ
+);
+
+CREATE INDEX dead_letter_pending ON DeadLetterKey (dead_letter_id) WHERE reprocessed_at IS NULL;
+
+END;
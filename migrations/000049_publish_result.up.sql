@@ -0,0 +1,29 @@
+-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+CREATE TABLE PublishResult (
+	app_package_name VARCHAR(1000) NOT NULL,
+	idempotency_key VARCHAR(128) NOT NULL,
+	request_hash BYTEA NOT NULL,
+	status INT NOT NULL,
+	message TEXT NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (app_package_name, idempotency_key)
+);
+
+CREATE INDEX publish_result_expires_at ON PublishResult (expires_at);
+
+END;
@@ -0,0 +1,41 @@
+-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- Exposure keys are stored in standard, padded base64. Rewrite keys stored in
+-- the URL safe alphabet or without padding, which the server reads as the same
+-- bytes, so that lookups and cursors compare them consistently. Where a key is
+-- stored in both encodings, the canonical row is kept.
+CREATE FUNCTION normalize_exposure_key(k VARCHAR) RETURNS VARCHAR AS $$
+	SELECT encode(decode(rpad(translate(rtrim(k, '='), '-_', '+/'), ((length(rtrim(k, '=')) + 3) / 4) * 4, '='), 'base64'), 'base64')
+$$ LANGUAGE SQL IMMUTABLE;
+
+DELETE FROM Exposure legacy
+WHERE legacy.exposure_key <> normalize_exposure_key(legacy.exposure_key)
+AND EXISTS (SELECT 1 FROM Exposure e WHERE e.exposure_key = normalize_exposure_key(legacy.exposure_key));
+
+UPDATE Exposure SET exposure_key = normalize_exposure_key(exposure_key)
+WHERE exposure_key <> normalize_exposure_key(exposure_key);
+
+DELETE FROM ExposureTombstone legacy
+WHERE legacy.exposure_key <> normalize_exposure_key(legacy.exposure_key)
+AND EXISTS (SELECT 1 FROM ExposureTombstone t WHERE t.exposure_key = normalize_exposure_key(legacy.exposure_key));
+
+UPDATE ExposureTombstone SET exposure_key = normalize_exposure_key(exposure_key)
+WHERE exposure_key <> normalize_exposure_key(exposure_key);
+
+DROP FUNCTION normalize_exposure_key;
+
+END;
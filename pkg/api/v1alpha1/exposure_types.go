@@ -54,6 +54,10 @@ const (
 // VerificationAuthorityName: a string that should be verified against the code provider.
 //  Note: This project doesn't directly include a diagnosis code verification System
 //        but does provide the ability to configure one in `serverevn.ServerEnv`
+// IdempotencyKey: Optional, at most 128 characters, identifying the request
+//  across retries. A retry of a successful request with the same key is
+//  answered with the original result rather than processed again. A key must
+//  not be reused for another request.
 //
 // The following fields are deprecated, but accepted for backwards-compatibility:
 // DeviceVerificationPayload: (attestation)
@@ -65,6 +69,7 @@ type Publish struct {
 	VerificationPayload string        `json:"verificationPayload"`
 	HMACKey             string        `json:"hmackey"`
 	Padding             string        `json:"padding"`
+	IdempotencyKey      string        `json:"idempotencyKey"`
 
 	Platform                  string `json:"platform"`                  // DEPRECATED
 	DeviceVerificationPayload string `json:"deviceVerificationPayload"` // DEPRECATED
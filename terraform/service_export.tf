@@ -190,3 +190,30 @@ resource "google_cloud_scheduler_job" "export-create-batches" {
     google_project_service.services["cloudscheduler.googleapis.com"],
   ]
 }
+
+resource "google_cloud_scheduler_job" "export-verify-signatures" {
+  name             = "export-verify-signatures"
+  region           = var.cloudscheduler_location
+  schedule         = "0 * * * *"
+  time_zone        = "Etc/UTC"
+  attempt_deadline = "600s"
+
+  retry_config {
+    retry_count = 1
+  }
+
+  http_target {
+    http_method = "POST"
+    uri         = "${google_cloud_run_service.export.status.0.url}/verify-signatures"
+    oidc_token {
+      audience              = google_cloud_run_service.export.status.0.url
+      service_account_email = google_service_account.export-invoker.email
+    }
+  }
+
+  depends_on = [
+    google_app_engine_application.app,
+    google_cloud_run_service_iam_member.export-invoker,
+    google_project_service.services["cloudscheduler.googleapis.com"],
+  ]
+}
@@ -24,9 +24,11 @@ import (
 	"github.com/google/exposure-notifications-server/internal/admin"
 	"github.com/google/exposure-notifications-server/internal/admin/authorizedapps"
 	"github.com/google/exposure-notifications-server/internal/admin/exports"
+	"github.com/google/exposure-notifications-server/internal/admin/exposures"
 	"github.com/google/exposure-notifications-server/internal/admin/healthauthority"
 	"github.com/google/exposure-notifications-server/internal/admin/index"
 	"github.com/google/exposure-notifications-server/internal/admin/siginfo"
+	"github.com/google/exposure-notifications-server/internal/admin/watermarks"
 	"github.com/google/exposure-notifications-server/internal/setup"
 )
 
@@ -73,6 +75,16 @@ func main() {
 	saveSigInfoController := siginfo.NewSave(&config, env)
 	router.POST("/siginfo/:id", saveSigInfoController.Execute)
 
+	// Maintenance.
+	recomputeWatermarksController := watermarks.NewRecompute(&config, env)
+	router.POST("/watermarks/recompute", recomputeWatermarksController.Execute)
+	rebuildWatermarksController := watermarks.NewRebuild(&config, env)
+	router.POST("/watermarks/rebuild", rebuildWatermarksController.Execute)
+
+	// Support.
+	exposureLookupController := exposures.NewLookup(&config, env)
+	router.GET("/exposures/lookup", exposureLookupController.Execute)
+
 	log.Printf("listening on http://localhost:" + config.Port)
 	if err := router.Run(); err != nil {
 		log.Fatal(err)
@@ -42,6 +42,13 @@ var (
 	signingKeyVersion = flag.String("signing-key-version", "", "The version of the signing key (for clients).")
 	appPkgID          = flag.String("app-pkg-id", "", "The App Package ID to put in export headers")
 	bundleID          = flag.String("bundle-id", "", "The BundleID to put in export headers")
+	maxKeysPerBatch   = flag.Int("max-keys-per-batch", 0, "The maximum number of keys in each export file; 0 uses the server default.")
+	minKeysPerWindow  = flag.Int("min-keys-per-window", 0, "The minimum number of keys before a period is exported; smaller periods are combined with later ones.")
+	protocolVersion   = flag.String("protocol-version", model.ExportProtocolV1, "The export file format, v1 or v1.5.")
+	mode              = flag.String("mode", model.ExportModeFull, "Export every key in each period (full), or only keys not exported before (delta).")
+	preset            = flag.String("preset", "", "The name of the export preset filtering the exported keys; empty exports all keys.")
+	presetVerified    = flag.Bool("preset-verified-only", false, "Create or replace --preset, exporting only keys whose diagnosis was verified.")
+	presetMinRisk     = flag.Int("preset-min-transmission-risk", 0, "Create or replace --preset, exporting only keys with at least this transmission risk.")
 )
 
 func main() {
@@ -93,6 +100,28 @@ func main() {
 	}
 	defer db.Close(ctx)
 
+	// The preset is only created, or replaced, if any of its filters are given.
+	definePreset := false
+	flag.Visit(func(f *flag.Flag) {
+		if strings.HasPrefix(f.Name, "preset-") {
+			definePreset = true
+		}
+	})
+	if definePreset {
+		if *preset == "" {
+			log.Fatal("--preset is required with --preset-verified-only and --preset-min-transmission-risk.")
+		}
+		p := model.ExportPreset{
+			Name:                *preset,
+			VerifiedOnly:        *presetVerified,
+			MinTransmissionRisk: *presetMinRisk,
+		}
+		if err := database.New(db).AddExportPreset(ctx, &p); err != nil {
+			log.Fatalf("AddExportPreset: %v", err)
+		}
+		log.Printf("Saved ExportPreset %q.", p.Name)
+	}
+
 	si := model.SignatureInfo{
 		SigningKey:        *signingKey,
 		AppPackageName:    *appPkgID,
@@ -112,6 +141,11 @@ func main() {
 		From:             fromTime,
 		Thru:             thruTime,
 		SignatureInfoIDs: []int64{si.ID},
+		MaxKeysPerBatch:  *maxKeysPerBatch,
+		MinKeysPerWindow: *minKeysPerWindow,
+		ProtocolVersion:  *protocolVersion,
+		Mode:             *mode,
+		Preset:           *preset,
 	}
 	if err := database.New(db).AddExportConfig(ctx, &ec); err != nil {
 		log.Fatalf("Failure: %v", err)
@@ -20,6 +20,7 @@ import (
 	"flag"
 	"log"
 	"regexp"
+	"strings"
 	"time"
 
 	coredb "github.com/google/exposure-notifications-server/internal/database"
@@ -41,6 +42,7 @@ var (
 	serverAddr    = flag.String("server-addr", "", "(Required) The address of the remote server, in the form some-server:some-port")
 	audience      = flag.String("audience", federationin.DefaultAudience, "(Required) The OIDC audience to use when creating client tokens.")
 	lastTimestamp = flag.String("last-timestamp", "", "The last timestamp (RFC3339) to set; queries start from this point and go forward.")
+	compression   = flag.String("compression", "", "A comma-separated list of codecs, e.g. zstd,gzip, to request responses in, most preferred first. Leave blank for the server's default.")
 )
 
 func main() {
@@ -98,6 +100,9 @@ func main() {
 		ExcludeRegions: excludeRegions,
 		LastTimestamp:  lastTime,
 	}
+	if *compression != "" {
+		query.Compression = strings.Split(*compression, ",")
+	}
 
 	if err := db.AddFederationInQuery(ctx, query); err != nil {
 		log.Fatalf("adding new query %s %#v: %v", *queryID, query, err)
@@ -36,9 +36,15 @@ const (
 var (
 	testRegions = []string{"TEST", "PROBE"}
 
-	subject  = flag.String("subject", "", "(Required) The OIDC subject (for issuer https://accounts.google.com, this is the obfuscated Gaia ID.)")
-	audience = flag.String("audience", federationin.DefaultAudience, "The OIDC audience; leaving this blank will cause server to not enforce the audience claim.")
-	note     = flag.String("note", "", "An open text note to include on the record.")
+	subject          = flag.String("subject", "", "(Required) The OIDC subject (for issuer https://accounts.google.com, this is the obfuscated Gaia ID.)")
+	audience         = flag.String("audience", federationin.DefaultAudience, "The OIDC audience; leaving this blank will cause server to not enforce the audience claim.")
+	note             = flag.String("note", "", "An open text note to include on the record.")
+	namespace        = flag.String("namespace", "", "The namespace whose exposures this client may fetch. Leave blank for the default namespace.")
+	historical       = flag.Bool("allow-historical", false, "Allow the client to make as-of fetches of past key sets.")
+	backfill         = flag.Bool("allow-backfill", false, "Allow the client to override the time up to which keys are served, for backfills.")
+	maxResponseBytes = flag.Int("max-response-bytes", 0, "The largest fetch response, in bytes, the client can receive. Leave 0 for the server's default.")
+	upload           = flag.Bool("allow-upload", false, "Allow the client to upload keys into the regions it includes; --regions must be set.")
+	minKeyAge        = flag.Duration("min-key-age", 0, "How old keys must be before they are served to the client, e.g. 24h. Leave 0 to serve keys as soon as their window closes.")
 )
 
 func main() {
@@ -50,6 +56,9 @@ func main() {
 	if *subject == "" {
 		log.Fatalf("--subject is required")
 	}
+	if *upload && len(includeRegions) == 0 {
+		log.Fatalf("--allow-upload requires --regions")
+	}
 
 	// Issue warnings about missing test regions in excludeRegions.
 	var missingTestRegions []string
@@ -84,12 +93,18 @@ func main() {
 	db := database.New(coredb)
 
 	auth := &model.FederationOutAuthorization{
-		Issuer:         defaultIssuer, // Authorization interceptor currently only supports defaultIssuer.
-		Subject:        *subject,
-		Audience:       *audience,
-		Note:           *note,
-		IncludeRegions: includeRegions,
-		ExcludeRegions: excludeRegions,
+		Issuer:           defaultIssuer, // Authorization interceptor currently only supports defaultIssuer.
+		Subject:          *subject,
+		Audience:         *audience,
+		Note:             *note,
+		IncludeRegions:   includeRegions,
+		ExcludeRegions:   excludeRegions,
+		Namespace:        *namespace,
+		AllowHistorical:  *historical,
+		AllowBackfill:    *backfill,
+		AllowUpload:      *upload,
+		MaxResponseBytes: *maxResponseBytes,
+		MinKeyAge:        *minKeyAge,
 	}
 
 	if err := db.AddFederationOutAuthorization(ctx, auth); err != nil {
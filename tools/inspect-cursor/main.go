@@ -0,0 +1,88 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This package is a CLI tool for operators debugging federation clients. It decodes a
+// nextFetchToken and reports the position it resumes from and whether it is still valid.
+package main
+
+import (
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/federationout"
+	"github.com/google/exposure-notifications-server/internal/publish/database"
+	"github.com/kelseyhightower/envconfig"
+)
+
+var (
+	namespace = flag.String("namespace", "", "The namespace of the client the token was issued to.")
+	maxAge    = flag.Duration("max-age", 24*time.Hour, "Report tokens issued longer ago than this as expired; 0 never expires.")
+)
+
+// config holds the federationout server's cursor keys, which are needed to open sealed tokens.
+type config struct {
+	CursorKey         string `envconfig:"CURSOR_KEY"`
+	PreviousCursorKey string `envconfig:"PREVIOUS_CURSOR_KEY"`
+}
+
+func main() {
+	flag.Usage = func() {
+		log.Printf("usage: inspect-cursor [-namespace NAMESPACE] NEXT_FETCH_TOKEN")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		log.Fatalf("exactly one token is required")
+	}
+	token := flag.Arg(0)
+
+	var c config
+	if err := envconfig.Process("", &c); err != nil {
+		log.Fatalf("error loading environment variables: %v", err)
+	}
+
+	raw, err := federationout.OpenCursor(token, *namespace, c.CursorKey, c.PreviousCursorKey)
+	if err != nil {
+		log.Fatalf("INVALID: token was not issued by this server (or for namespace %q): %v", *namespace, err)
+	}
+
+	cursor, err := database.DecodeCursor(raw)
+	if err != nil {
+		log.Fatalf("INVALID: %v", err)
+	}
+
+	now := time.Now()
+	if cursor.Start() {
+		fmt.Println("Position:    start of the query")
+	} else {
+		fmt.Printf("Created at:  %v (%d)\n", cursor.CreatedAt.Format(time.RFC3339Nano), cursor.CreatedAt.Unix())
+		fmt.Printf("Tiebreaker:  %s\n", base64.StdEncoding.EncodeToString(cursor.ExposureKey))
+	}
+	order := "forward"
+	if cursor.Reverse {
+		order = "reverse, newest first"
+	}
+	fmt.Printf("Order:       %s\n", order)
+	fmt.Printf("Issued at:   %v (%v ago)\n", cursor.IssuedAt.Format(time.RFC3339), now.Sub(cursor.IssuedAt).Round(time.Second))
+	if cursor.Expired(now, *maxAge) {
+		fmt.Printf("Status:      EXPIRED (older than %v)\n", *maxAge)
+		return
+	}
+	fmt.Println("Status:      valid")
+}
@@ -0,0 +1,65 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This package is a CLI tool for offboarding a jurisdiction. Every exposure published to the given
+// region is removed: exposures published only to it are deleted and replaced with tombstones, and
+// exposures also published to other regions have it removed.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"strings"
+	"time"
+
+	coredb "github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/publish/database"
+	"github.com/kelseyhightower/envconfig"
+)
+
+var batchSize = flag.Int("batch-size", 1000, "The number of exposures to remove in each transaction.")
+
+func main() {
+	flag.Usage = func() {
+		log.Printf("usage: offboard-region [--batch-size N] REGION")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		log.Fatalf("exactly one region is required")
+	}
+	region := strings.ToUpper(flag.Arg(0))
+
+	ctx := context.Background()
+	var config coredb.Config
+	err := envconfig.Process("database", &config)
+	if err != nil {
+		log.Fatalf("error loading environment variables: %v", err)
+	}
+
+	db, err := coredb.NewFromEnv(ctx, &config)
+	if err != nil {
+		log.Fatalf("unable to connect to database: %v", err)
+	}
+	defer db.Close(ctx)
+
+	removed, err := database.New(db).DeleteExposuresByRegion(ctx, region, *batchSize, time.Now())
+	if err != nil {
+		log.Fatalf("removing region %s: %v", region, err)
+	}
+
+	log.Printf("Removed region %s: deleted %d and trimmed %d exposures", region, removed.Deleted, removed.Trimmed)
+}
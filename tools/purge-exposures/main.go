@@ -0,0 +1,70 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This package is a CLI tool for honoring takedown requests. The given exposure keys are deleted
+// and replaced with tombstones, so that federation partners requesting tombstones also drop them.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/base64util"
+	coredb "github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/publish/database"
+	"github.com/kelseyhightower/envconfig"
+)
+
+func main() {
+	flag.Usage = func() {
+		log.Printf("usage: purge-exposures BASE64_KEY [BASE64_KEY ...]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		log.Fatalf("at least one exposure key is required")
+	}
+
+	var keys [][]byte
+	for _, arg := range flag.Args() {
+		key, err := base64util.DecodeString(arg)
+		if err != nil {
+			log.Fatalf("invalid exposure key %q: %v", arg, err)
+		}
+		keys = append(keys, key)
+	}
+
+	ctx := context.Background()
+	var config coredb.Config
+	err := envconfig.Process("database", &config)
+	if err != nil {
+		log.Fatalf("error loading environment variables: %v", err)
+	}
+
+	db, err := coredb.NewFromEnv(ctx, &config)
+	if err != nil {
+		log.Fatalf("unable to connect to database: %v", err)
+	}
+	defer db.Close(ctx)
+
+	count, err := database.New(db).PurgeExposures(ctx, keys, time.Now())
+	if err != nil {
+		log.Fatalf("purging exposures: %v", err)
+	}
+
+	log.Printf("Purged %d of %d exposures", count, len(keys))
+}